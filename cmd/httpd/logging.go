@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"cse224/proj3/pkg/config"
+	"cse224/proj3/pkg/tritonhttp"
+)
+
+// logConfig holds the command line flags about TritonHTTP logging.
+type logConfig struct {
+	level string
+
+	accessLog      string
+	accessLogLevel string
+	errorLog       string
+	errorLogLevel  string
+	sampling       int
+
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+
+	syslog         string
+	syslogFacility string
+	syslogTag      string
+
+	files []*tritonhttp.RotatingFile
+}
+
+// logConfigOf returns the logging setup described by the [logging]
+// table of a configuration file.
+func logConfigOf(l config.Logging) logConfig {
+	return logConfig{
+		level:          l.Level,
+		accessLog:      l.AccessLog,
+		accessLogLevel: l.AccessLogLevel,
+		errorLog:       l.ErrorLog,
+		errorLogLevel:  l.ErrorLogLevel,
+		sampling:       l.AccessLogSampling,
+		maxSize:        l.MaxSize,
+		maxAge:         l.MaxAge,
+		maxBackups:     l.MaxBackups,
+		compress:       l.Compress,
+		syslog:         l.Syslog,
+		syslogFacility: l.SyslogFacility,
+		syslogTag:      l.SyslogTag,
+	}
+}
+
+// apply sets up the logger and access log of s according to c.
+func (c *logConfig) apply(s *tritonhttp.Server) error {
+	level, err := tritonhttp.ParseLogLevel(c.level)
+	if err != nil {
+		return err
+	}
+	accessLevel, err := tritonhttp.ParseLogLevel(c.accessLogLevel)
+	if err != nil {
+		return err
+	}
+	errorLevel, err := tritonhttp.ParseLogLevel(c.errorLogLevel)
+	if err != nil {
+		return err
+	}
+	s.Logger = tritonhttp.NewLogger(nil, level)
+
+	var access []io.Writer
+	if w := c.output(c.accessLog); w != nil {
+		access = append(access, w)
+	}
+	if w := c.output(c.errorLog); w != nil {
+		s.ErrorLog = tritonhttp.NewLogger(log.New(w, "", log.LstdFlags), errorLevel)
+	}
+
+	if c.syslog != "" {
+		facility, err := tritonhttp.ParseSyslogFacility(c.syslogFacility)
+		if err != nil {
+			return err
+		}
+		network, addr := "", ""
+		if c.syslog != "local" {
+			parts := strings.SplitN(c.syslog, "://", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("syslog target %q is not \"local\" or of the form network://host:port", c.syslog)
+			}
+			network, addr = parts[0], parts[1]
+		}
+		w, err := tritonhttp.NewSyslogWriter(network, addr, facility, tritonhttp.SeverityInfo, c.syslogTag)
+		if err != nil {
+			return err
+		}
+		s.Logger = tritonhttp.NewSyslogLogger(w, level)
+		if s.ErrorLog == nil {
+			s.ErrorLog = tritonhttp.NewSyslogLogger(w, errorLevel)
+		}
+		access = append(access, w)
+	}
+
+	if len(access) > 0 {
+		s.AccessLog = tritonhttp.NewJSONAccessLog(io.MultiWriter(access...), accessLevel)
+		s.AccessLogSampling = c.sampling
+	}
+	return nil
+}
+
+// output returns the log destination named by path: nothing for "",
+// stdout for "-", or a file rotated according to c.
+func (c *logConfig) output(path string) io.Writer {
+	switch path {
+	case "":
+		return nil
+	case "-":
+		return os.Stdout
+	}
+	rf := &tritonhttp.RotatingFile{
+		Filename:   path,
+		MaxSize:    c.maxSize,
+		MaxAge:     c.maxAge,
+		MaxBackups: c.maxBackups,
+		Compress:   c.compress,
+	}
+	c.files = append(c.files, rf)
+	return rf
+}
+
+// reopen reopens the log files, e.g. after logrotate moved them away.
+func (c *logConfig) reopen() error {
+	var errs []error
+	for _, rf := range c.files {
+		errs = append(errs, rf.Reopen())
+	}
+	return errors.Join(errs...)
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"cse224/proj3/pkg/config"
 	"cse224/proj3/pkg/tritonhttp"
 )
 
@@ -13,7 +20,29 @@ func main() {
 	// Parse command line flags
 	var useDefault = flag.Bool("use_default", false, "whether to use the Golang standard library HTTP server")
 	var port = flag.Int("port", 8080, "the localhost port to listen on")
-	var docRoot = flag.String("doc_root", "htdocs", "path to the doc root directory")
+	var docRoot = flag.String("doc_root", "htdocs", "path to the doc root directory, or a .zip or .tar.gz archive of it")
+	var adminAddr = flag.String("admin_addr", "", "loopback address to serve TritonHTTP profiling and counters on, e.g. localhost:6060")
+	var statsdAddr = flag.String("statsd_addr", "", "StatsD daemon to send TritonHTTP request metrics to, e.g. localhost:8125")
+	var statsdRate = flag.Float64("statsd_sample_rate", 1, "share of requests reported to StatsD")
+	var dogStatsD = flag.Bool("dogstatsd", false, "whether to send DogStatsD tags to the StatsD daemon")
+	var captureDir = flag.String("capture_dir", "", "directory to record redacted transcripts of every TritonHTTP connection to")
+	var captureMax = flag.Int64("capture_max_bytes", 1<<20, "size cap of each connection transcript")
+	var configFile = flag.String("config", "", "TOML file to configure the TritonHTTP server from instead of the other flags")
+	var shutdownTimeout = flag.Duration("shutdown_timeout", 10*time.Second, "how long to wait for in-flight requests on SIGINT or SIGTERM")
+	var logs logConfig
+	flag.StringVar(&logs.level, "log_level", "warn", "minimum level of TritonHTTP server logs: debug, info, warn or error")
+	flag.StringVar(&logs.accessLog, "access_log", "", "file to write JSON access logs of the TritonHTTP server to, \"-\" for stdout")
+	flag.StringVar(&logs.accessLogLevel, "access_log_level", "info", "minimum level of access logs: info for all requests, warn or error for failed ones")
+	flag.IntVar(&logs.sampling, "access_log_sampling", 1, "only log 1 in this many successful requests; errors are always logged")
+	flag.StringVar(&logs.errorLog, "error_log", "", "file to write TritonHTTP server errors to, \"-\" for stdout")
+	flag.StringVar(&logs.errorLogLevel, "error_log_level", "info", "minimum level of the error log: debug, info, warn or error")
+	flag.Int64Var(&logs.maxSize, "log_max_size", 0, "rotate log files once they exceed this many bytes, 0 for never")
+	flag.DurationVar(&logs.maxAge, "log_max_age", 0, "rotate log files once they are this old, 0 for never")
+	flag.IntVar(&logs.maxBackups, "log_max_backups", 0, "number of rotated log files to keep, 0 for all")
+	flag.BoolVar(&logs.compress, "log_compress", false, "whether to gzip rotated log files")
+	flag.StringVar(&logs.syslog, "syslog", "", "also send TritonHTTP logs to syslog: \"local\" or network://host:port, e.g. udp://localhost:514")
+	flag.StringVar(&logs.syslogFacility, "syslog_facility", "daemon", "syslog facility, e.g. daemon or local0")
+	flag.StringVar(&logs.syslogTag, "syslog_tag", "httpd", "syslog app name")
 	flag.Parse()
 
 	// Log server configs
@@ -21,6 +50,10 @@ func main() {
 	log.Printf("  use_default: %v", *useDefault)
 	log.Printf("  port: %v", *port)
 	log.Printf("  doc_root: %v", *docRoot)
+	log.Printf("  log_level: %v", logs.level)
+	log.Printf("  access_log: %v", logs.accessLog)
+	log.Printf("  error_log: %v", logs.errorLog)
+	log.Printf("  syslog: %v", logs.syslog)
 
 	// Start server
 	addr := fmt.Sprintf(":%v", *port)
@@ -39,6 +72,90 @@ func main() {
 			Addr:    addr,
 			DocRoot: *docRoot,
 		}
-		log.Fatal(s.ListenAndServe())
+		if *configFile != "" {
+			cfg, err := config.Load(*configFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := cfg.Apply(s); err != nil {
+				log.Fatal(err)
+			}
+			logs = logConfigOf(cfg.Logging)
+			log.Printf("Loaded %v, listening on %v", *configFile, s.Addr)
+			for _, l := range s.Listeners {
+				log.Printf("  also listening on %v (TLS: %v)", l.Addr, len(l.Certificates) > 0)
+			}
+		} else {
+			s.AdminAddr = *adminAddr
+			if *statsdAddr != "" {
+				sd, err := tritonhttp.NewStatsD(*statsdAddr)
+				if err != nil {
+					log.Fatal(err)
+				}
+				sd.Prefix = "tritonhttp."
+				sd.SampleRate = *statsdRate
+				sd.DogStatsD = *dogStatsD
+				s.StatsD = sd
+			}
+			if *captureDir != "" {
+				s.Capture = &tritonhttp.Capture{Dir: *captureDir, MaxBytes: *captureMax}
+			}
+		}
+		if err := logs.apply(s); err != nil {
+			log.Fatal(err)
+		}
+		s.OnReload = logs.reopen
+		if *configFile != "" {
+			// Re-read the file, applying what can change while serving,
+			// and reopen the logs even if it no longer loads
+			s.OnReload = func() error {
+				cfg, err := config.Load(*configFile)
+				if err == nil {
+					err = cfg.Update(s)
+				}
+				return errors.Join(err, logs.reopen())
+			}
+		}
+		if s.AdminAddr != "" {
+			if err := s.PublishExpvar("tritonhttp"); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		// Reload on SIGHUP, re-reading -config if set, drain connections on SIGINT or SIGTERM,
+		// and hand the listener to a new binary before draining on SIGUSR2
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
+			for {
+				got := <-sig
+				if got == syscall.SIGHUP {
+					log.Print("Reloading on SIGHUP")
+					_ = s.Reload()
+					continue
+				}
+				if got == syscall.SIGUSR2 {
+					p, err := s.Upgrade()
+					if err != nil {
+						log.Printf("Upgrade failed: %v", err)
+						continue
+					}
+					log.Printf("Upgraded to process %v", p.Pid)
+				}
+				break
+			}
+			log.Print("Shutting down")
+			ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+			defer cancel()
+			if err := s.Shutdown(ctx); err != nil {
+				log.Printf("Shutdown: %v", err)
+			}
+		}()
+		if err := s.ListenAndServe(); err != tritonhttp.ErrServerClosed {
+			log.Fatal(err)
+		}
+		<-stopped
 	}
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"cse224/proj3/pkg/loadgen"
+)
+
+func main() {
+	// Parse command line flags
+	var cfg loadgen.Config
+	flag.IntVar(&cfg.Concurrency, "c", 10, "number of concurrent workers")
+	flag.DurationVar(&cfg.Duration, "d", 0, "how long to run, e.g. 30s; 0 to only stop after -n requests")
+	flag.IntVar(&cfg.Requests, "n", 0, "number of requests to send; 0 to only stop after -d, 1000 if neither is set")
+	flag.DurationVar(&cfg.Timeout, "timeout", 0, "timeout of each request, 0 for none")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %v [flags] url...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	cfg.URLs = flag.Args()
+	if cfg.Duration == 0 && cfg.Requests == 0 {
+		cfg.Requests = 1000
+	}
+	if err := cfg.Validate(); err != nil {
+		flag.Usage()
+		log.Fatal(err)
+	}
+
+	// Stop early, still reporting, on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	rep, err := loadgen.Run(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := rep.WriteText(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+	if len(rep.Errors) > 0 {
+		os.Exit(1)
+	}
+}
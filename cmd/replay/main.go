@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"cse224/proj3/pkg/replay"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage:\n")
+	fmt.Fprintf(os.Stderr, "  %v record -listen addr -upstream addr -dir dir [-raw]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %v run -addr addr [-ignore headers] [-timeout d] transcript...\n", os.Args[0])
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	switch os.Args[1] {
+	case "record":
+		record(ctx, os.Args[2:])
+	case "run":
+		run(ctx, os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+// record proxies connections to an upstream server, recording a
+// transcript of each, until interrupted.
+func record(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:8081", "address to accept clients on")
+	var r replay.Recorder
+	fs.StringVar(&r.Upstream, "upstream", "localhost:8080", "address of the server to record")
+	fs.StringVar(&r.Capture.Dir, "dir", "", "directory to write transcripts to")
+	fs.BoolVar(&r.Capture.Raw, "raw", false, "record credentials instead of redacting them")
+	fs.Int64Var(&r.Capture.MaxBytes, "max_bytes", 0, "cap on the bytes of each transcript, 0 for none")
+	_ = fs.Parse(args)
+	if r.Capture.Dir == "" {
+		log.Fatal("-dir is required")
+	}
+	if err := os.MkdirAll(r.Capture.Dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Recording %v to %v, proxying from %v", r.Upstream, r.Capture.Dir, ln.Addr())
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	if err := r.Serve(ln); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run replays transcripts against a server, exiting with status 1 if
+// any response differs from the one recorded.
+func run(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address of the server to replay against")
+	ignore := fs.String("ignore", "", "comma separated headers not to compare, besides Date")
+	var opts replay.Options
+	fs.DurationVar(&opts.Timeout, "timeout", replay.DefaultTimeout, "timeout of each exchange")
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		usage()
+	}
+	if *ignore != "" {
+		opts.IgnoreHeaders = strings.Split(*ignore, ",")
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		start := time.Now()
+		res, err := replay.ReplayFile(ctx, *addr, path, &opts)
+		switch {
+		case err != nil:
+			fmt.Printf("FAIL %v: %v\n", path, err)
+			failed = true
+		case len(res.Diffs) > 0:
+			fmt.Printf("FAIL %v:\n\t%v\n", path, strings.Join(res.Diffs, "\n\t"))
+			failed = true
+		default:
+			fmt.Printf("ok   %v: %v responses in %v\n", path, res.Responses, time.Since(start).Round(time.Millisecond))
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
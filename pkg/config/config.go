@@ -0,0 +1,1151 @@
+// Package config loads TritonHTTP server configuration from TOML files.
+//
+// A configuration file looks like:
+//
+//	[server]
+//	addr = ":8080"
+//	doc_root = "/srv/htdocs"
+//	listen = ["127.0.0.1:8081"]
+//	admin_addr = "localhost:6060"
+//	reserved = ["/_admin"]
+//	health_path = "/_health"
+//	metrics_path = "/_metrics"
+//	event_loop = true
+//
+//	[tls]
+//	listen = [":8443"]
+//	certificates = ["/etc/ssl/example.org.crt=/etc/ssl/example.org.key"]
+//
+//	[vhosts]
+//	sites = ["example.org,www.example.org=/srv/example.org"]
+//
+//	[limits]
+//	max_conns = 1000
+//	read_timeout = "10s"
+//
+//	[logging]
+//	level = "info"
+//	access_log = "/var/log/httpd/access.log"
+//
+//	[proxy]
+//	routes = ["/api=http://127.0.0.1:9000"]
+//
+//	[cgi]
+//	dir = "/srv/cgi-bin"
+//
+//	[fastcgi]
+//	addr = "unix:/run/php/php-fpm.sock"
+//	doc_root = "/srv/htdocs"
+//
+//	[rewrite]
+//	rules = ["^/v1/(.*)$ /api/$1 last"]
+//
+//	[redirect]
+//	rules = ["/old.html /new.html 308"]
+//
+//	[alias]
+//	paths = ["/favicon.ico=/static/img/favicon.ico"]
+//	content = ["/robots.txt=User-agent: *\nDisallow:\n"]
+//
+//	[mime]
+//	types = [".glb=model/gltf-binary"]
+//
+//	[markdown]
+//	enabled = true
+//
+//	[ssi]
+//	enabled = true
+//
+//	[webdav]
+//	mounts = ["/files/", "/pub/"]
+//	read_only = ["/pub/"]
+//	users = ["alice:secret"]
+//
+//	[geoip]
+//	databases = ["/var/lib/GeoIP/GeoLite2-Country.mmdb"]
+//	allow = ["/admin=US,CA"]
+//
+// Every table and key is optional; unknown ones are reported as errors,
+// along with the line they are on. Durations are strings in the
+// time.ParseDuration syntax. Arrays may span several lines, e.g.
+//
+//	[metrics]
+//	hosts = [
+//	  "example.com",
+//	  "www.example.com",
+//	]
+//
+// The server listens on server.addr, unless it is "" with other
+// listeners set, and on each of server.listen over plain HTTP and
+// tls.listen over HTTPS; the hosts of vhosts.sites are served from doc
+// roots of their own.
+package config
+
+import (
+	"fmt"
+	"html/template"
+	"mime"
+	"net/netip"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cse224/proj3/pkg/tritonhttp"
+)
+
+// Config is the contents of a configuration file.
+type Config struct {
+	Server       Server       `toml:"server"`
+	TLS          TLS          `toml:"tls"`
+	VHosts       VHosts       `toml:"vhosts"`
+	Limits       Limits       `toml:"limits"`
+	Ban          Ban          `toml:"ban"`
+	Quota        Quota        `toml:"quota"`
+	LoadShedding LoadShedding `toml:"load_shedding"`
+	Metrics      Metrics      `toml:"metrics"`
+	Logging      Logging      `toml:"logging"`
+	StatsD       StatsD       `toml:"statsd"`
+	Capture      Capture      `toml:"capture"`
+	Proxy        Proxy        `toml:"proxy"`
+	CGI          CGI          `toml:"cgi"`
+	FastCGI      FastCGI      `toml:"fastcgi"`
+	Rewrite      Rewrite      `toml:"rewrite"`
+	Redirect     Redirect     `toml:"redirect"`
+	Alias        Alias        `toml:"alias"`
+	MIME         MIME         `toml:"mime"`
+	Markdown     Markdown     `toml:"markdown"`
+	SSI          SSI          `toml:"ssi"`
+	WebDAV       WebDAV       `toml:"webdav"`
+	GeoIP        GeoIP        `toml:"geoip"`
+}
+
+// Server is the [server] table: where to listen and what to serve.
+// Listen are more addresses to serve plain HTTP on besides Addr; Addr
+// may then be "" not to listen on it.
+//
+// If CanonicalHost or CanonicalHTTPS is set, the requests to other
+// hosts, or over plain HTTP, are redirected; besides those to the
+// tls.listen addresses, the proxies in proxy.trusted_proxies tell
+// which requests came over HTTPS. See tritonhttp.CanonicalHost.
+//
+// TrailingSlash is the policy of the doc root on trailing slashes:
+// "add", "strip", "accept" or "as-is", the default. See
+// tritonhttp.TrailingSlash.
+//
+// Reserved lists the path prefixes reserved to internal endpoints,
+// which no file, route or rule can shadow; HealthPath and MetricsPath,
+// if set, serve the built-in health check and metrics endpoints, and
+// are reserved too. See tritonhttp.Server.Reserved.
+//
+// Attachments lists the paths under which files are served as
+// attachments, for browsers to save; DownloadQuery also serves so
+// those asked for with "?download=1".
+//
+// EventLoop parks the idle keep-alive connections in epoll or kqueue
+// rather than a goroutine each. See tritonhttp.Server.EventLoop.
+//
+// Negotiate serves the variants of files, e.g. index.en.html or
+// logo.webp, the Accept and Accept-Language headers of requests
+// prefer. See tritonhttp.Server.Negotiate.
+//
+// DocRoot may be a .zip, .tar.gz or .tgz archive; ArchiveGzip then
+// sends the files deflated in a zip as they are to the clients
+// accepting gzip. See tritonhttp.Server.ArchiveGzip.
+//
+// AllowedMethods are the methods of the requests served, GET if empty;
+// the others get a 405 Method Not Allowed. See
+// tritonhttp.Server.AllowedMethods.
+//
+// ETag is how the files served are tagged: "modtime", the default, by
+// their modification time and size, or "content" by their content.
+// See tritonhttp.ParseETag.
+type Server struct {
+	Addr                 string        `toml:"addr"`
+	Listen               []string      `toml:"listen"`
+	DocRoot              string        `toml:"doc_root"`
+	AdminAddr            string        `toml:"admin_addr"`
+	BlockProfileRate     int           `toml:"block_profile_rate"`
+	SlowRequestThreshold time.Duration `toml:"slow_request_threshold"`
+	CanonicalHost        string        `toml:"canonical_host"`
+	CanonicalHTTPS       bool          `toml:"canonical_https"`
+	TrailingSlash        string        `toml:"trailing_slash"`
+	Reserved             []string      `toml:"reserved"`
+	HealthPath           string        `toml:"health_path"`
+	MetricsPath          string        `toml:"metrics_path"`
+	Attachments          []string      `toml:"attachments"`
+	DownloadQuery        bool          `toml:"download_query"`
+	EventLoop            bool          `toml:"event_loop"`
+	Negotiate            bool          `toml:"negotiate"`
+	ArchiveGzip          bool          `toml:"archive_gzip"`
+	AllowedMethods       []string      `toml:"allowed_methods"`
+	ETag                 string        `toml:"etag"`
+}
+
+// TLS is the [tls] table: HTTPS is served on each address of Listen,
+// presenting the certificate of Certificates whose names match the
+// server name clients ask for, or else the first. Each of Certificates
+// is "cert=key", the PEM files of a certificate chain and its private
+// key; they are read again on reload. See tritonhttp.Listener.
+type TLS struct {
+	Listen       []string `toml:"listen"`
+	Certificates []string `toml:"certificates"`
+}
+
+// VHosts is the [vhosts] table. Each of Sites is "hosts=dir", e.g.
+// "example.org,www.example.org=/srv/example.org", serving the requests
+// for the comma-separated hosts from the doc root dir instead of
+// server.doc_root. See tritonhttp.VirtualHost.
+type VHosts struct {
+	Sites []string `toml:"sites"`
+}
+
+// Limits is the [limits] table, see tritonhttp.Limits.
+type Limits struct {
+	MaxRequestLineBytes int           `toml:"max_request_line_bytes"`
+	MaxHeaderBytes      int           `toml:"max_header_bytes"`
+	MaxHeaderCount      int           `toml:"max_header_count"`
+	MaxBodyBytes        int64         `toml:"max_body_bytes"`
+	MaxURLLength        int           `toml:"max_url_length"`
+	MaxConns            int           `toml:"max_conns"`
+	MaxConnsPerIP       int           `toml:"max_conns_per_ip"`
+	ReadTimeout         time.Duration `toml:"read_timeout"`
+	WriteTimeout        time.Duration `toml:"write_timeout"`
+}
+
+// Ban is the [ban] table, see tritonhttp.BanPolicy.
+type Ban struct {
+	Threshold int           `toml:"threshold"`
+	Window    time.Duration `toml:"window"`
+	Duration  time.Duration `toml:"duration"`
+}
+
+// Quota is the [quota] table, see tritonhttp.BandwidthQuota.
+type Quota struct {
+	Bytes  int64         `toml:"bytes"`
+	Window time.Duration `toml:"window"`
+}
+
+// LoadShedding is the [load_shedding] table, see tritonhttp.LoadShedding.
+type LoadShedding struct {
+	MaxConns    int           `toml:"max_conns"`
+	MaxInFlight int           `toml:"max_in_flight"`
+	MaxLatency  time.Duration `toml:"max_latency"`
+	Fraction    float64       `toml:"fraction"`
+	RetryAfter  time.Duration `toml:"retry_after"`
+}
+
+// Metrics is the [metrics] table, see tritonhttp.MetricLabels.
+type Metrics struct {
+	Hosts     []string `toml:"hosts"`
+	Routes    []string `toml:"routes"`
+	Countries []string `toml:"countries"`
+}
+
+// Logging is the [logging] table. The server package only deals in
+// Loggers, so turning these settings into them is up to the caller.
+type Logging struct {
+	Level             string        `toml:"level"`
+	AccessLog         string        `toml:"access_log"`
+	AccessLogLevel    string        `toml:"access_log_level"`
+	AccessLogSampling int           `toml:"access_log_sampling"`
+	ErrorLog          string        `toml:"error_log"`
+	ErrorLogLevel     string        `toml:"error_log_level"`
+	MaxSize           int64         `toml:"max_size"`
+	MaxAge            time.Duration `toml:"max_age"`
+	MaxBackups        int           `toml:"max_backups"`
+	Compress          bool          `toml:"compress"`
+	Syslog            string        `toml:"syslog"`
+	SyslogFacility    string        `toml:"syslog_facility"`
+	SyslogTag         string        `toml:"syslog_tag"`
+}
+
+// StatsD is the [statsd] table. Metrics are only sent if Addr is set.
+type StatsD struct {
+	Addr       string   `toml:"addr"`
+	Prefix     string   `toml:"prefix"`
+	SampleRate float64  `toml:"sample_rate"`
+	DogStatsD  bool     `toml:"dogstatsd"`
+	Tags       []string `toml:"tags"`
+}
+
+// Capture is the [capture] table. Transcripts are only recorded if Dir is set.
+type Capture struct {
+	Dir      string `toml:"dir"`
+	MaxBytes int64  `toml:"max_bytes"`
+	Raw      bool   `toml:"raw"`
+}
+
+// Proxy is the [proxy] table. Each of Routes is "prefix=url", e.g.
+// "/api=http://127.0.0.1:9000", proxying the requests under prefix to
+// url with prefix stripped from their path. The responses of each
+// route are cached if CacheMaxBytes is positive.
+//
+// The url may be a comma-separated list of backends, e.g.
+// "/api=http://a:9000,http://b:9000", that requests are retried
+// against in turn; the Retry* keys are the fields of
+// tritonhttp.RetryPolicy.
+//
+// Connections to each backend are kept alive for reuse: up to
+// MaxIdleConns idle ones, each for IdleConnTimeout, and none past
+// MaxConnLifetime; see tritonhttp.Transport.
+//
+// The X-Forwarded-* headers of the clients in TrustedProxies, in CIDR
+// notation, are kept; OmitForwarded and Via are those of
+// tritonhttp.ReverseProxy.
+//
+// The server is also a forward proxy if ForwardAllow, the "host:port"
+// patterns of the destinations clients may reach, is set. Clients
+// must then authenticate as one of ForwardUsers, "user:password", if
+// any.
+//
+// TrailingSlash is the policy of the routes on trailing slashes, as
+// that of [server].
+type Proxy struct {
+	Routes         []string `toml:"routes"`
+	PreserveHost   bool     `toml:"preserve_host"`
+	CacheMaxBytes  int64    `toml:"cache_max_bytes"`
+	TrustedProxies []string `toml:"trusted_proxies"`
+	OmitForwarded  bool     `toml:"omit_forwarded"`
+	Via            string   `toml:"via"`
+	ForwardAllow   []string `toml:"forward_allow"`
+	ForwardUsers   []string `toml:"forward_users"`
+	TrailingSlash  string   `toml:"trailing_slash"`
+
+	RetryAttempts   int           `toml:"retry_attempts"`
+	RetryStatuses   []int         `toml:"retry_statuses"`
+	RetryTryTimeout time.Duration `toml:"retry_try_timeout"`
+	RetryBackoff    time.Duration `toml:"retry_backoff"`
+	RetryMaxBackoff time.Duration `toml:"retry_max_backoff"`
+
+	MaxIdleConns    int           `toml:"max_idle_conns"`
+	IdleConnTimeout time.Duration `toml:"idle_conn_timeout"`
+	MaxConnLifetime time.Duration `toml:"max_conn_lifetime"`
+}
+
+// CGI is the [cgi] table: if Dir is set, the requests under Prefix run
+// the CGI scripts in Dir, see tritonhttp.CGI.
+type CGI struct {
+	Dir        string        `toml:"dir"`
+	Prefix     string        `toml:"prefix"`
+	Extensions []string      `toml:"extensions"`
+	Timeout    time.Duration `toml:"timeout"`
+}
+
+// FastCGI is the [fastcgi] table: if Addr is set, the requests under
+// Prefix for scripts with one of Extensions are sent to the FastCGI
+// application server at Addr, "host:port" or "unix:/path/to/socket",
+// whose doc root is DocRoot. See tritonhttp.FastCGI.
+type FastCGI struct {
+	Addr         string        `toml:"addr"`
+	DocRoot      string        `toml:"doc_root"`
+	Prefix       string        `toml:"prefix"`
+	Extensions   []string      `toml:"extensions"`
+	Timeout      time.Duration `toml:"timeout"`
+	MaxIdleConns int           `toml:"max_idle_conns"`
+}
+
+// Rewrite is the [rewrite] table. Each of Rules is "match replacement",
+// optionally followed by "last", e.g. "^/v1/(.*)$ /api/$1 last": a
+// match starting with "^" is a regular expression, any other a path
+// prefix. See tritonhttp.Rewrite.
+type Rewrite struct {
+	Rules []string `toml:"rules"`
+}
+
+// Redirect is the [redirect] table. Each of Rules is "match location",
+// optionally followed by the status code, 301 by default, e.g.
+// "^/blog/(.*)$ https://blog.example.com/$1 308": a match starting
+// with "^" is a regular expression, any other an exact path. The
+// responses have an HTML body linking to the location if HTML is set.
+// See tritonhttp.Redirect.
+type Redirect struct {
+	Rules []string `toml:"rules"`
+	HTML  bool     `toml:"html"`
+}
+
+// Alias is the [alias] table. Each of Paths is "path=target", e.g.
+// "/favicon.ico=/static/img/favicon.ico", serving the requests for path
+// as those for target; each of Content is "path=content", answering
+// the requests for path with content, e.g. "/robots.txt=User-agent: *".
+// See tritonhttp.Alias.
+type Alias struct {
+	Paths   []string `toml:"paths"`
+	Content []string `toml:"content"`
+}
+
+// MIME is the [mime] table, adding to the types of file extensions
+// those of Files, in the mime.types format, then those of Types, each
+// ".ext=type", e.g. ".glb=model/gltf-binary". See
+// tritonhttp.MIMETypeByExtension.
+type MIME struct {
+	Files []string `toml:"files"`
+	Types []string `toml:"types"`
+}
+
+// Markdown is the [markdown] table: if Enabled, the Markdown files of
+// the doc root are rendered to HTML pages, wrapped in the html/template
+// file Template if set; RawQuery is the query parameter asking for
+// their source. See tritonhttp.Markdown.
+type Markdown struct {
+	Enabled  bool   `toml:"enabled"`
+	Template string `toml:"template"`
+	RawQuery string `toml:"raw_query"`
+}
+
+// SSI is the [ssi] table: if Enabled, the server-side includes of the
+// .shtml files of the doc root are processed, nesting at most MaxDepth
+// levels deep. See tritonhttp.SSI.
+type SSI struct {
+	Enabled  bool `toml:"enabled"`
+	MaxDepth int  `toml:"max_depth"`
+}
+
+// WebDAV is the [webdav] table. Each of Mounts is the path prefix of a
+// part of the doc root WebDAV clients may browse and change, unless it
+// is also one of ReadOnly. If Users, "user:password", are set, clients
+// must authenticate as one of them, in Realm. See tritonhttp.WebDAV.
+type WebDAV struct {
+	Mounts   []string `toml:"mounts"`
+	ReadOnly []string `toml:"read_only"`
+	Users    []string `toml:"users"`
+	Realm    string   `toml:"realm"`
+}
+
+// GeoIP is the [geoip] table: the clients are looked up in the MaxMind
+// DB files of Databases, if set, the X-Forwarded-For of those in
+// proxy.trusted_proxies taken for their address. Allow and Deny are
+// rules "prefix=list", e.g. "/admin=US,CA,AS64496", of the countries and
+// autonomous systems allowed, or denied, under a path prefix; the rules
+// of the longest prefix matching a request apply. See tritonhttp.GeoIP.
+type GeoIP struct {
+	Databases     []string      `toml:"databases"`
+	Allow         []string      `toml:"allow"`
+	Deny          []string      `toml:"deny"`
+	CheckInterval time.Duration `toml:"check_interval"`
+}
+
+// Default returns the configuration used for anything a file leaves out.
+func Default() *Config {
+	return &Config{
+		Server: Server{Addr: ":8080", DocRoot: "htdocs"},
+		Logging: Logging{
+			Level:             "warn",
+			AccessLogLevel:    "info",
+			AccessLogSampling: 1,
+			ErrorLogLevel:     "info",
+			SyslogFacility:    "daemon",
+			SyslogTag:         "httpd",
+		},
+		StatsD:  StatsD{SampleRate: 1},
+		Capture: Capture{MaxBytes: 1 << 20},
+		CGI:     CGI{Prefix: "/cgi-bin"},
+		FastCGI: FastCGI{Prefix: "/", Extensions: []string{".php"}},
+	}
+}
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, data)
+}
+
+// Parse parses and validates the configuration in data, naming it
+// name in error messages.
+func Parse(name string, data []byte) (*Config, error) {
+	doc, err := parseTOML(name, data)
+	if err != nil {
+		return nil, err
+	}
+	c := Default()
+	if err := decode(name, doc, c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	return c, nil
+}
+
+// Validate reports the first setting that is invalid on its own or
+// contradicts another one.
+func (c *Config) Validate() error {
+	if c.Server.Addr == "" && len(c.Server.Listen) == 0 && len(c.TLS.Listen) == 0 {
+		return fmt.Errorf("server.addr must be set")
+	}
+	for i, addr := range c.Server.Listen {
+		if addr == "" {
+			return fmt.Errorf("server.listen[%v]: must not be empty", i)
+		}
+	}
+	if _, err := c.listeners(); err != nil {
+		return err
+	}
+	if _, err := c.virtualHosts(); err != nil {
+		return err
+	}
+	if c.Server.DocRoot == "" {
+		return fmt.Errorf("server.doc_root must be set")
+	}
+	if err := c.limits().Validate(); err != nil {
+		return fmt.Errorf("limits: %v", err)
+	}
+	levels := []struct{ key, level string }{
+		{"logging.level", c.Logging.Level},
+		{"logging.access_log_level", c.Logging.AccessLogLevel},
+		{"logging.error_log_level", c.Logging.ErrorLogLevel},
+	}
+	for _, l := range levels {
+		if _, err := tritonhttp.ParseLogLevel(l.level); err != nil {
+			return fmt.Errorf("%v: %v", l.key, err)
+		}
+	}
+	if _, err := tritonhttp.ParseSyslogFacility(c.Logging.SyslogFacility); err != nil {
+		return fmt.Errorf("logging.syslog_facility: %v", err)
+	}
+	if _, err := tritonhttp.ParseTrailingSlash(c.Server.TrailingSlash); err != nil {
+		return fmt.Errorf("server.trailing_slash: %v", err)
+	}
+	if _, err := tritonhttp.ParseETag(c.Server.ETag); err != nil {
+		return fmt.Errorf("server.etag: %v", err)
+	}
+	for i, prefix := range c.Server.Attachments {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("server.attachments[%v]: must start with \"/\", got %q", i, prefix)
+		}
+	}
+	if r := c.StatsD.SampleRate; r <= 0 || r > 1 {
+		return fmt.Errorf("statsd.sample_rate must be in (0, 1], got %v", r)
+	}
+	if c.Capture.MaxBytes < 0 {
+		return fmt.Errorf("capture.max_bytes must not be negative")
+	}
+	if c.Proxy.CacheMaxBytes < 0 {
+		return fmt.Errorf("proxy.cache_max_bytes must not be negative")
+	}
+	if c.Proxy.RetryAttempts < 0 {
+		return fmt.Errorf("proxy.retry_attempts must not be negative")
+	}
+	for i, code := range c.Proxy.RetryStatuses {
+		if code < 100 || code > 999 {
+			return fmt.Errorf("proxy.retry_statuses[%v]: invalid status code %v", i, code)
+		}
+	}
+	if c.Proxy.RetryTryTimeout < 0 || c.Proxy.RetryBackoff < 0 || c.Proxy.RetryMaxBackoff < 0 {
+		return fmt.Errorf("proxy.retry_try_timeout, retry_backoff and retry_max_backoff must not be negative")
+	}
+	if c.Proxy.IdleConnTimeout < 0 || c.Proxy.MaxConnLifetime < 0 {
+		return fmt.Errorf("proxy.idle_conn_timeout and max_conn_lifetime must not be negative")
+	}
+	if c.CGI.Timeout < 0 {
+		return fmt.Errorf("cgi.timeout must not be negative")
+	}
+	if c.CGI.Dir != "" && !strings.HasPrefix(c.CGI.Prefix, "/") {
+		return fmt.Errorf("cgi.prefix must start with \"/\", got %q", c.CGI.Prefix)
+	}
+	if c.FastCGI.Timeout < 0 {
+		return fmt.Errorf("fastcgi.timeout must not be negative")
+	}
+	if c.FastCGI.Addr != "" {
+		if !strings.HasPrefix(c.FastCGI.Prefix, "/") {
+			return fmt.Errorf("fastcgi.prefix must start with \"/\", got %q", c.FastCGI.Prefix)
+		}
+		if len(c.FastCGI.Extensions) == 0 {
+			return fmt.Errorf("fastcgi.extensions must not be empty")
+		}
+	}
+	if _, err := c.routes(); err != nil {
+		return err
+	}
+	if _, err := c.forwardProxy(); err != nil {
+		return err
+	}
+	if _, err := c.rewrites(); err != nil {
+		return err
+	}
+	if _, err := c.redirects(); err != nil {
+		return err
+	}
+	if _, err := c.aliases(); err != nil {
+		return err
+	}
+	if _, err := c.reserved(); err != nil {
+		return err
+	}
+	if _, err := c.webDAV(); err != nil {
+		return err
+	}
+	if _, err := c.mimeTypes(); err != nil {
+		return err
+	}
+	if _, err := c.geoPolicies(); err != nil {
+		return err
+	}
+	if len(c.GeoIP.Databases) == 0 && (len(c.GeoIP.Allow) > 0 || len(c.GeoIP.Deny) > 0) {
+		return fmt.Errorf("geoip.databases must be set for geoip.allow and geoip.deny")
+	}
+	return nil
+}
+
+// Apply configures s according to c, leaving logging to the caller.
+// It dials the StatsD daemon if one is configured.
+func (c *Config) Apply(s *tritonhttp.Server) error {
+	s.Addr = c.Server.Addr
+	s.DocRoot = c.Server.DocRoot
+	listeners, err := c.listeners()
+	if err != nil {
+		return err
+	}
+	s.Listeners = listeners
+	vhosts, err := c.virtualHosts()
+	if err != nil {
+		return err
+	}
+	s.VirtualHosts = vhosts
+	s.AdminAddr = c.Server.AdminAddr
+	s.BlockProfileRate = c.Server.BlockProfileRate
+	s.SlowRequestThreshold = c.Server.SlowRequestThreshold
+	s.AttachmentPrefixes = c.Server.Attachments
+	s.AttachmentQuery = c.Server.DownloadQuery
+	s.EventLoop = c.Server.EventLoop
+	s.Negotiate = c.Server.Negotiate
+	s.ArchiveGzip = c.Server.ArchiveGzip
+	s.AllowedMethods = c.Server.AllowedMethods
+	s.Limits = c.limits()
+	s.BanPolicy = tritonhttp.BanPolicy(c.Ban)
+	s.Quota = tritonhttp.BandwidthQuota(c.Quota)
+	s.LoadShedding = tritonhttp.LoadShedding(c.LoadShedding)
+	s.MetricLabels = tritonhttp.MetricLabels(c.Metrics)
+	s.AccessLogSampling = c.Logging.AccessLogSampling
+
+	if c.StatsD.Addr != "" {
+		sd, err := tritonhttp.NewStatsD(c.StatsD.Addr)
+		if err != nil {
+			return fmt.Errorf("statsd: %v", err)
+		}
+		sd.Prefix = c.StatsD.Prefix
+		sd.SampleRate = c.StatsD.SampleRate
+		sd.DogStatsD = c.StatsD.DogStatsD
+		sd.Tags = c.StatsD.Tags
+		s.StatsD = sd
+	}
+	if c.Capture.Dir != "" {
+		s.Capture = &tritonhttp.Capture{Dir: c.Capture.Dir, MaxBytes: c.Capture.MaxBytes, Raw: c.Capture.Raw}
+	}
+	routes, err := c.routes()
+	if err != nil {
+		return err
+	}
+	s.Routes = routes
+	if s.TrailingSlash, err = tritonhttp.ParseTrailingSlash(c.Server.TrailingSlash); err != nil {
+		return fmt.Errorf("server.trailing_slash: %v", err)
+	}
+	if s.ETag, err = tritonhttp.ParseETag(c.Server.ETag); err != nil {
+		return fmt.Errorf("server.etag: %v", err)
+	}
+	if c.Server.CanonicalHost != "" || c.Server.CanonicalHTTPS {
+		trusted, err := c.trustedProxies()
+		if err != nil {
+			return err
+		}
+		s.CanonicalHost = &tritonhttp.CanonicalHost{Host: c.Server.CanonicalHost, HTTPS: c.Server.CanonicalHTTPS, TrustedProxies: trusted}
+	}
+	rewrites, err := c.rewrites()
+	if err != nil {
+		return err
+	}
+	s.Rewrites = rewrites
+	redirects, err := c.redirects()
+	if err != nil {
+		return err
+	}
+	s.Redirects = redirects
+	s.Aliases, err = c.aliases()
+	if err != nil {
+		return err
+	}
+	s.Reserved, err = c.reserved()
+	if err != nil {
+		return err
+	}
+	if c.Server.HealthPath != "" {
+		s.Internal = append(s.Internal, tritonhttp.Route{Prefix: c.Server.HealthPath, Handler: s.HealthHandler()})
+	}
+	if c.Server.MetricsPath != "" {
+		s.Internal = append(s.Internal, tritonhttp.Route{Prefix: c.Server.MetricsPath, Handler: s.MetricsHandler()})
+	}
+	fp, err := c.forwardProxy()
+	if err != nil {
+		return err
+	}
+	s.ForwardProxy = fp
+	for _, name := range c.MIME.Files {
+		if err := tritonhttp.LoadMIMETypes(name); err != nil {
+			return fmt.Errorf("mime.files: %v", err)
+		}
+	}
+	types, err := c.mimeTypes()
+	if err != nil {
+		return err
+	}
+	for ext, typ := range types {
+		if err := tritonhttp.AddExtensionType(ext, typ); err != nil {
+			return fmt.Errorf("mime.types: %v", err)
+		}
+	}
+	if c.Markdown.Enabled {
+		s.Markdown = &tritonhttp.Markdown{RawQuery: c.Markdown.RawQuery}
+		if c.Markdown.Template != "" {
+			if s.Markdown.Template, err = template.ParseFiles(c.Markdown.Template); err != nil {
+				return fmt.Errorf("markdown.template: %v", err)
+			}
+		}
+	}
+	if c.SSI.Enabled {
+		s.SSI = &tritonhttp.SSI{MaxDepth: c.SSI.MaxDepth}
+	}
+	if len(c.GeoIP.Databases) > 0 {
+		trusted, err := c.trustedProxies()
+		if err != nil {
+			return err
+		}
+		policies, err := c.geoPolicies()
+		if err != nil {
+			return err
+		}
+		s.GeoIP = &tritonhttp.GeoIP{
+			Databases:      c.GeoIP.Databases,
+			TrustedProxies: trusted,
+			Policies:       policies,
+			CheckInterval:  c.GeoIP.CheckInterval,
+		}
+	}
+	s.WebDAV, err = c.webDAV()
+	return err
+}
+
+// Update applies to s, already serving, the settings of c that can
+// change without a restart: the doc root, which takes effect once s
+// reloads, the log level, limits, ban policy, quota and load shedding.
+// The rest of c, from the addresses to the routes, is left as it was;
+// s itself reads the TLS certificates and virtual host doc roots it
+// was started with again as it reloads.
+func (c *Config) Update(s *tritonhttp.Server) error {
+	level, err := tritonhttp.ParseLogLevel(c.Logging.Level)
+	if err != nil {
+		return fmt.Errorf("logging.level: %v", err)
+	}
+	if err := s.UpdateSettings(func(st *tritonhttp.Settings) error {
+		st.LogLevel = level
+		st.Limits = c.limits()
+		st.BanPolicy = tritonhttp.BanPolicy(c.Ban)
+		st.Quota = tritonhttp.BandwidthQuota(c.Quota)
+		st.LoadShedding = tritonhttp.LoadShedding(c.LoadShedding)
+		return nil
+	}); err != nil {
+		return err
+	}
+	s.DocRoot = c.Server.DocRoot
+	return nil
+}
+
+// listeners returns the addresses of server.listen and tls.listen as
+// tritonhttp.Listeners, the latter with the certificates of [tls].
+func (c *Config) listeners() ([]tritonhttp.Listener, error) {
+	var certs []tritonhttp.Certificate
+	for i, pair := range c.TLS.Certificates {
+		cert, key, ok := strings.Cut(pair, "=")
+		cert, key = strings.TrimSpace(cert), strings.TrimSpace(key)
+		if !ok || cert == "" || key == "" {
+			return nil, fmt.Errorf("tls.certificates[%v]: expected \"cert=key\", got %q", i, pair)
+		}
+		certs = append(certs, tritonhttp.Certificate{CertFile: cert, KeyFile: key})
+	}
+	if len(c.TLS.Listen) > 0 && len(certs) == 0 {
+		return nil, fmt.Errorf("tls.certificates must be set for tls.listen")
+	}
+	if len(certs) > 0 && len(c.TLS.Listen) == 0 {
+		return nil, fmt.Errorf("tls.listen must be set for tls.certificates")
+	}
+	var listeners []tritonhttp.Listener
+	for _, addr := range c.Server.Listen {
+		listeners = append(listeners, tritonhttp.Listener{Addr: addr})
+	}
+	for i, addr := range c.TLS.Listen {
+		if addr == "" {
+			return nil, fmt.Errorf("tls.listen[%v]: must not be empty", i)
+		}
+		listeners = append(listeners, tritonhttp.Listener{Addr: addr, Certificates: certs})
+	}
+	return listeners, nil
+}
+
+// virtualHosts returns the sites of [vhosts] as
+// tritonhttp.VirtualHosts.
+func (c *Config) virtualHosts() ([]tritonhttp.VirtualHost, error) {
+	var vhosts []tritonhttp.VirtualHost
+	seen := make(map[string]bool)
+	for i, site := range c.VHosts.Sites {
+		hosts, dir, ok := strings.Cut(site, "=")
+		dir = strings.TrimSpace(dir)
+		if !ok || dir == "" {
+			return nil, fmt.Errorf("vhosts.sites[%v]: expected \"hosts=dir\", got %q", i, site)
+		}
+		var vh tritonhttp.VirtualHost
+		for _, h := range strings.Split(hosts, ",") {
+			h = strings.ToLower(strings.TrimSpace(h))
+			if h == "" || strings.ContainsAny(h, ":/ ") {
+				return nil, fmt.Errorf("vhosts.sites[%v]: invalid host %q", i, h)
+			}
+			if seen[h] {
+				return nil, fmt.Errorf("vhosts.sites[%v]: host %q is already served", i, h)
+			}
+			seen[h] = true
+			vh.Hosts = append(vh.Hosts, h)
+		}
+		vh.DocRoot = dir
+		vhosts = append(vhosts, vh)
+	}
+	return vhosts, nil
+}
+
+// routes returns the [proxy] routes, then the [cgi] and [fastcgi]
+// ones if any, as tritonhttp.Routes.
+func (c *Config) routes() ([]tritonhttp.Route, error) {
+	trusted, err := c.trustedProxies()
+	if err != nil {
+		return nil, err
+	}
+	slash, err := tritonhttp.ParseTrailingSlash(c.Proxy.TrailingSlash)
+	if err != nil {
+		return nil, fmt.Errorf("proxy.trailing_slash: %v", err)
+	}
+	// The routes share the connection pools to their backends
+	transport := &tritonhttp.Transport{
+		DisableCompression:  true,
+		MaxIdleConnsPerHost: c.Proxy.MaxIdleConns,
+		IdleConnTimeout:     c.Proxy.IdleConnTimeout,
+		MaxConnLifetime:     c.Proxy.MaxConnLifetime,
+	}
+	var routes []tritonhttp.Route
+	for i, r := range c.Proxy.Routes {
+		prefix, upstream, ok := strings.Cut(r, "=")
+		prefix, upstream = strings.TrimSpace(prefix), strings.TrimSpace(upstream)
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("proxy.routes[%v]: expected \"/prefix=url\", got %q", i, r)
+		}
+		upstreams := strings.Split(upstream, ",")
+		for j := range upstreams {
+			upstreams[j] = strings.TrimSpace(upstreams[j])
+		}
+		p, err := tritonhttp.NewReverseProxy(upstreams[0], upstreams[1:]...)
+		if err != nil {
+			return nil, fmt.Errorf("proxy.routes[%v]: %v", i, err)
+		}
+		p.Transport = transport
+		p.StripPrefix = strings.TrimSuffix(prefix, "/")
+		p.PreserveHost = c.Proxy.PreserveHost
+		p.TrustedProxies = trusted
+		p.OmitForwarded = c.Proxy.OmitForwarded
+		p.Via = c.Proxy.Via
+		p.Retry = tritonhttp.RetryPolicy{
+			Attempts:   c.Proxy.RetryAttempts,
+			Statuses:   c.Proxy.RetryStatuses,
+			TryTimeout: c.Proxy.RetryTryTimeout,
+			Backoff:    c.Proxy.RetryBackoff,
+			MaxBackoff: c.Proxy.RetryMaxBackoff,
+		}
+		var h tritonhttp.Handler = p
+		if c.Proxy.CacheMaxBytes > 0 {
+			h = &tritonhttp.Cache{Handler: p, MaxBytes: c.Proxy.CacheMaxBytes}
+		}
+		routes = append(routes, tritonhttp.Route{Prefix: prefix, TrailingSlash: slash, Handler: h})
+	}
+	if c.CGI.Dir != "" {
+		routes = append(routes, tritonhttp.Route{Prefix: c.CGI.Prefix, Handler: &tritonhttp.CGI{
+			Dir:        c.CGI.Dir,
+			Root:       c.CGI.Prefix,
+			Extensions: c.CGI.Extensions,
+			Timeout:    c.CGI.Timeout,
+		}})
+	}
+	if c.FastCGI.Addr != "" {
+		fcgi := &tritonhttp.FastCGI{
+			Network:      "tcp",
+			Addr:         c.FastCGI.Addr,
+			DocRoot:      c.FastCGI.DocRoot,
+			Extensions:   c.FastCGI.Extensions,
+			Timeout:      c.FastCGI.Timeout,
+			MaxIdleConns: c.FastCGI.MaxIdleConns,
+		}
+		if sock, ok := strings.CutPrefix(fcgi.Addr, "unix:"); ok {
+			fcgi.Network, fcgi.Addr = "unix", sock
+		}
+		routes = append(routes, tritonhttp.Route{Prefix: c.FastCGI.Prefix, Extensions: c.FastCGI.Extensions, Handler: fcgi})
+	}
+	return routes, nil
+}
+
+// aliases returns the [alias] paths and content as tritonhttp.Aliases.
+func (c *Config) aliases() ([]tritonhttp.Alias, error) {
+	var aliases []tritonhttp.Alias
+	for i, p := range c.Alias.Paths {
+		from, to, ok := strings.Cut(p, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || !strings.HasPrefix(from, "/") || !strings.HasPrefix(to, "/") {
+			return nil, fmt.Errorf("alias.paths[%v]: expected \"/path=/target\", got %q", i, p)
+		}
+		aliases = append(aliases, tritonhttp.Alias{Path: from, Target: to})
+	}
+	for i, p := range c.Alias.Content {
+		// The content is kept as is, spaces included
+		from, content, ok := strings.Cut(p, "=")
+		from = strings.TrimSpace(from)
+		if !ok || !strings.HasPrefix(from, "/") {
+			return nil, fmt.Errorf("alias.content[%v]: expected \"/path=content\", got %q", i, p)
+		}
+		aliases = append(aliases, tritonhttp.Alias{Path: from, Content: content})
+	}
+	return aliases, nil
+}
+
+// reserved returns the [server] reserved prefixes, along with the
+// health and metrics paths.
+func (c *Config) reserved() ([]string, error) {
+	var prefixes []string
+	for i, prefix := range c.Server.Reserved {
+		if !strings.HasPrefix(prefix, "/") || prefix == "/" {
+			return nil, fmt.Errorf("server.reserved[%v]: expected a path prefix other than \"/\", got %q", i, prefix)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	paths := []struct{ key, path string }{
+		{"server.health_path", c.Server.HealthPath},
+		{"server.metrics_path", c.Server.MetricsPath},
+	}
+	for _, p := range paths {
+		if p.path == "" {
+			continue
+		}
+		if !strings.HasPrefix(p.path, "/") || p.path == "/" {
+			return nil, fmt.Errorf("%v: expected a path other than \"/\", got %q", p.key, p.path)
+		}
+		prefixes = append(prefixes, p.path)
+	}
+	return prefixes, nil
+}
+
+// trustedProxies returns proxy.trusted_proxies as netip.Prefixes.
+func (c *Config) trustedProxies() ([]netip.Prefix, error) {
+	var trusted []netip.Prefix
+	for i, cidr := range c.Proxy.TrustedProxies {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("proxy.trusted_proxies[%v]: %v", i, err)
+		}
+		trusted = append(trusted, prefix.Masked())
+	}
+	return trusted, nil
+}
+
+// rewrites returns the [rewrite] rules as tritonhttp.Rewrites.
+func (c *Config) rewrites() ([]tritonhttp.Rewrite, error) {
+	var rewrites []tritonhttp.Rewrite
+	for i, rule := range c.Rewrite.Rules {
+		fields := strings.Fields(rule)
+		if len(fields) == 3 && fields[2] != "last" || len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("rewrite.rules[%v]: expected \"match replacement [last]\", got %q", i, rule)
+		}
+		rw := tritonhttp.Rewrite{Replacement: fields[1], Last: len(fields) == 3}
+		if strings.HasPrefix(fields[0], "^") {
+			re, err := regexp.Compile(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("rewrite.rules[%v]: %v", i, err)
+			}
+			rw.Pattern = re
+		} else {
+			rw.Prefix = fields[0]
+		}
+		rewrites = append(rewrites, rw)
+	}
+	return rewrites, nil
+}
+
+// redirects returns the [redirect] rules as tritonhttp.Redirects.
+func (c *Config) redirects() ([]tritonhttp.Redirect, error) {
+	var redirects []tritonhttp.Redirect
+	for i, rule := range c.Redirect.Rules {
+		fields := strings.Fields(rule)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("redirect.rules[%v]: expected \"match location [status]\", got %q", i, rule)
+		}
+		rd := tritonhttp.Redirect{Location: fields[1], HTML: c.Redirect.HTML}
+		if len(fields) == 3 {
+			code, err := strconv.Atoi(fields[2])
+			if err != nil || code != 301 && code != 302 && code != 307 && code != 308 {
+				return nil, fmt.Errorf("redirect.rules[%v]: status must be 301, 302, 307 or 308, got %q", i, fields[2])
+			}
+			rd.StatusCode = code
+		}
+		if strings.HasPrefix(fields[0], "^") {
+			re, err := regexp.Compile(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("redirect.rules[%v]: %v", i, err)
+			}
+			rd.Pattern = re
+		} else {
+			rd.Path = fields[0]
+		}
+		redirects = append(redirects, rd)
+	}
+	return redirects, nil
+}
+
+// forwardProxy returns the forward proxy of the [proxy] table, or nil
+// if there is none.
+func (c *Config) forwardProxy() (*tritonhttp.ForwardProxy, error) {
+	if len(c.Proxy.ForwardAllow) == 0 {
+		if len(c.Proxy.ForwardUsers) > 0 {
+			return nil, fmt.Errorf("proxy.forward_users is set without proxy.forward_allow")
+		}
+		return nil, nil
+	}
+	fp := &tritonhttp.ForwardProxy{Allow: c.Proxy.ForwardAllow}
+	for i, pattern := range fp.Allow {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("proxy.forward_allow[%v]: %q: %v", i, pattern, err)
+		}
+	}
+	for i, u := range c.Proxy.ForwardUsers {
+		user, password, ok := strings.Cut(u, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("proxy.forward_users[%v]: expected \"user:password\"", i)
+		}
+		if fp.Credentials == nil {
+			fp.Credentials = make(map[string]string)
+		}
+		fp.Credentials[user] = password
+	}
+	return fp, nil
+}
+
+// mimeTypes returns the types of the [mime] table by extension.
+func (c *Config) mimeTypes() (map[string]string, error) {
+	types := make(map[string]string, len(c.MIME.Types))
+	for i, t := range c.MIME.Types {
+		ext, typ, ok := strings.Cut(t, "=")
+		ext, typ = strings.TrimSpace(ext), strings.TrimSpace(typ)
+		if !ok || !strings.HasPrefix(ext, ".") || typ == "" {
+			return nil, fmt.Errorf("mime.types[%v]: expected \".ext=type\", got %q", i, t)
+		}
+		if _, _, err := mime.ParseMediaType(typ); err != nil {
+			return nil, fmt.Errorf("mime.types[%v]: %q: %v", i, typ, err)
+		}
+		types[ext] = typ
+	}
+	return types, nil
+}
+
+// webDAV returns the mounts of the [webdav] table as tritonhttp.WebDAVs.
+func (c *Config) webDAV() ([]tritonhttp.WebDAV, error) {
+	var credentials map[string]string
+	for i, u := range c.WebDAV.Users {
+		user, password, ok := strings.Cut(u, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("webdav.users[%v]: expected \"user:password\"", i)
+		}
+		if credentials == nil {
+			credentials = make(map[string]string)
+		}
+		credentials[user] = password
+	}
+	readOnly := make(map[string]bool)
+	for i, prefix := range c.WebDAV.ReadOnly {
+		if !slices.Contains(c.WebDAV.Mounts, prefix) {
+			return nil, fmt.Errorf("webdav.read_only[%v]: %q is not one of webdav.mounts", i, prefix)
+		}
+		readOnly[prefix] = true
+	}
+	var mounts []tritonhttp.WebDAV
+	for i, prefix := range c.WebDAV.Mounts {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("webdav.mounts[%v]: must start with \"/\", got %q", i, prefix)
+		}
+		mounts = append(mounts, tritonhttp.WebDAV{
+			Prefix:      prefix,
+			ReadOnly:    readOnly[prefix],
+			Credentials: credentials,
+			Realm:       c.WebDAV.Realm,
+		})
+	}
+	return mounts, nil
+}
+
+// geoPolicies returns the allow and deny rules of the [geoip] table as
+// tritonhttp.GeoPolicies, one per prefix, longest first.
+func (c *Config) geoPolicies() ([]tritonhttp.GeoPolicy, error) {
+	byPrefix := make(map[string]*tritonhttp.GeoPolicy)
+	var policies []*tritonhttp.GeoPolicy
+	rules := []struct {
+		key   string
+		rules []string
+		deny  bool
+	}{
+		{"geoip.allow", c.GeoIP.Allow, false},
+		{"geoip.deny", c.GeoIP.Deny, true},
+	}
+	for _, r := range rules {
+		for i, rule := range r.rules {
+			prefix, list, ok := strings.Cut(rule, "=")
+			prefix = strings.TrimSpace(prefix)
+			if !ok || !strings.HasPrefix(prefix, "/") || strings.TrimSpace(list) == "" {
+				return nil, fmt.Errorf("%v[%v]: expected \"/prefix=US,AS64496,...\", got %q", r.key, i, rule)
+			}
+			p := byPrefix[prefix]
+			if p == nil {
+				p = &tritonhttp.GeoPolicy{Prefix: prefix}
+				byPrefix[prefix] = p
+				policies = append(policies, p)
+			}
+			for _, e := range strings.Split(list, ",") {
+				e = strings.TrimSpace(e)
+				if !isGeoEntry(e) {
+					return nil, fmt.Errorf("%v[%v]: %q is neither a country code, e.g. \"US\", nor an autonomous system, e.g. \"AS64496\"", r.key, i, e)
+				}
+				if r.deny {
+					p.Deny = append(p.Deny, e)
+				} else {
+					p.Allow = append(p.Allow, e)
+				}
+			}
+		}
+	}
+	sort.SliceStable(policies, func(i, j int) bool { return len(policies[i].Prefix) > len(policies[j].Prefix) })
+	out := make([]tritonhttp.GeoPolicy, len(policies))
+	for i, p := range policies {
+		out[i] = *p
+	}
+	return out, nil
+}
+
+// isGeoEntry reports whether e is a two-letter country code or an
+// autonomous system, e.g. "AS64496".
+func isGeoEntry(e string) bool {
+	if len(e) > 2 && strings.EqualFold(e[:2], "AS") {
+		_, err := strconv.ParseUint(e[2:], 10, 32)
+		return err == nil
+	}
+	return len(e) == 2 && strings.Trim(strings.ToUpper(e), "ABCDEFGHIJKLMNOPQRSTUVWXYZ") == ""
+}
+
+// limits returns the [limits] table as tritonhttp.Limits.
+func (c *Config) limits() tritonhttp.Limits {
+	return tritonhttp.Limits(c.Limits)
+}
@@ -0,0 +1,400 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cse224/proj3/pkg/tritonhttp"
+)
+
+const sample = `
+# Example configuration
+[server]
+addr = "127.0.0.1:8080"
+doc_root = '/srv/htdocs'   # literal string
+canonical_host = "example.com"
+canonical_https = true
+trailing_slash = "add"
+reserved = ["/_admin"]
+health_path = "/_health"
+metrics_path = "/_metrics"
+attachments = ["/files/"]
+download_query = true
+event_loop = true
+negotiate = true
+archive_gzip = true
+allowed_methods = ["GET", "HEAD"]
+etag = "content"
+listen = ["127.0.0.1:8081"]
+
+[tls]
+listen = [":8443"]
+certificates = ["/etc/ssl/a.crt = /etc/ssl/a.key", "/etc/ssl/b.crt=/etc/ssl/b.key"]
+
+[vhosts]
+sites = ["Example.org, www.example.org=/srv/example.org", "example.net=/srv/example.net"]
+
+[limits]
+max_conns = 1_000
+read_timeout = "10s"
+
+[load_shedding]
+fraction = 0.5
+retry_after = "2s"
+
+[metrics]
+hosts = ["example.com", "www.example.com",]
+routes = ["/images/"]
+countries = ["US", "CN"]
+
+[logging]
+level = "info"
+compress = true
+
+[proxy]
+routes = ["/api/ = http://127.0.0.1:9000/app, http://127.0.0.1:9001/app"]
+cache_max_bytes = 1_000_000
+trusted_proxies = ["10.0.0.0/8"]
+forward_allow = ["*:443"]
+forward_users = ["alice:secret"]
+trailing_slash = "strip"
+retry_attempts = 3
+retry_statuses = [502, 503]
+retry_backoff = "100ms"
+max_idle_conns = 16
+max_conn_lifetime = "10m"
+
+[cgi]
+dir = "/srv/cgi-bin"
+extensions = [".py"]
+timeout = "5s"
+
+[fastcgi]
+addr = "unix:/run/php/php-fpm.sock"
+doc_root = "/var/www"
+
+[rewrite]
+rules = ["/old/ /new/", "^/v1/(.*)$ /api/$1 last"]
+
+[redirect]
+rules = ["/a.html /b.html", "^/blog/(.*)$ https://blog.example.com/$1 308"]
+html = true
+
+[alias]
+paths = ["/favicon.ico = /static/favicon.ico"]
+content = ["/robots.txt=User-agent: *\nDisallow:\n"]
+
+[mime]
+types = [".glb = model/gltf-binary"]
+
+[markdown]
+enabled = true
+raw_query = "source"
+
+[ssi]
+enabled = true
+max_depth = 4
+
+[webdav]
+mounts = ["/files/", "/pub/"]
+read_only = ["/pub/"]
+users = ["alice:secret"]
+
+[geoip]
+databases = ["/var/lib/GeoIP/GeoLite2-Country.mmdb", "/var/lib/GeoIP/GeoLite2-ASN.mmdb"]
+allow = ["/admin=US, CA"]
+deny = ["/admin/private=AS64496", "/admin=CN"]
+check_interval = "5m"
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse("httpd.toml", []byte(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	want.Server.Addr = "127.0.0.1:8080"
+	want.Server.DocRoot = "/srv/htdocs"
+	want.Server.CanonicalHost = "example.com"
+	want.Server.CanonicalHTTPS = true
+	want.Server.TrailingSlash = "add"
+	want.Server.Reserved = []string{"/_admin"}
+	want.Server.HealthPath = "/_health"
+	want.Server.MetricsPath = "/_metrics"
+	want.Server.Attachments = []string{"/files/"}
+	want.Server.DownloadQuery = true
+	want.Server.EventLoop = true
+	want.Server.Negotiate = true
+	want.Server.ArchiveGzip = true
+	want.Server.AllowedMethods = []string{"GET", "HEAD"}
+	want.Server.ETag = "content"
+	want.Server.Listen = []string{"127.0.0.1:8081"}
+	want.TLS.Listen = []string{":8443"}
+	want.TLS.Certificates = []string{"/etc/ssl/a.crt = /etc/ssl/a.key", "/etc/ssl/b.crt=/etc/ssl/b.key"}
+	want.VHosts.Sites = []string{"Example.org, www.example.org=/srv/example.org", "example.net=/srv/example.net"}
+	want.Limits.MaxConns = 1000
+	want.Limits.ReadTimeout = 10 * time.Second
+	want.LoadShedding.Fraction = 0.5
+	want.LoadShedding.RetryAfter = 2 * time.Second
+	want.Metrics.Hosts = []string{"example.com", "www.example.com"}
+	want.Metrics.Routes = []string{"/images/"}
+	want.Metrics.Countries = []string{"US", "CN"}
+	want.Logging.Level = "info"
+	want.Logging.Compress = true
+	want.Proxy.Routes = []string{"/api/ = http://127.0.0.1:9000/app, http://127.0.0.1:9001/app"}
+	want.Proxy.CacheMaxBytes = 1000000
+	want.Proxy.TrustedProxies = []string{"10.0.0.0/8"}
+	want.Proxy.ForwardAllow = []string{"*:443"}
+	want.Proxy.ForwardUsers = []string{"alice:secret"}
+	want.Proxy.TrailingSlash = "strip"
+	want.Proxy.RetryAttempts = 3
+	want.Proxy.RetryStatuses = []int{502, 503}
+	want.Proxy.RetryBackoff = 100 * time.Millisecond
+	want.Proxy.MaxIdleConns = 16
+	want.Proxy.MaxConnLifetime = 10 * time.Minute
+	want.CGI.Dir = "/srv/cgi-bin"
+	want.CGI.Extensions = []string{".py"}
+	want.CGI.Timeout = 5 * time.Second
+	want.FastCGI.Addr = "unix:/run/php/php-fpm.sock"
+	want.FastCGI.DocRoot = "/var/www"
+	want.Rewrite.Rules = []string{"/old/ /new/", "^/v1/(.*)$ /api/$1 last"}
+	want.Redirect.Rules = []string{"/a.html /b.html", "^/blog/(.*)$ https://blog.example.com/$1 308"}
+	want.Redirect.HTML = true
+	want.Alias.Paths = []string{"/favicon.ico = /static/favicon.ico"}
+	want.Alias.Content = []string{"/robots.txt=User-agent: *\nDisallow:\n"}
+	want.MIME.Types = []string{".glb = model/gltf-binary"}
+	want.Markdown.Enabled = true
+	want.Markdown.RawQuery = "source"
+	want.SSI.Enabled = true
+	want.SSI.MaxDepth = 4
+	want.WebDAV.Mounts = []string{"/files/", "/pub/"}
+	want.WebDAV.ReadOnly = []string{"/pub/"}
+	want.WebDAV.Users = []string{"alice:secret"}
+	want.GeoIP.Databases = []string{"/var/lib/GeoIP/GeoLite2-Country.mmdb", "/var/lib/GeoIP/GeoLite2-ASN.mmdb"}
+	want.GeoIP.Allow = []string{"/admin=US, CA"}
+	want.GeoIP.Deny = []string{"/admin/private=AS64496", "/admin=CN"}
+	want.GeoIP.CheckInterval = 5 * time.Minute
+	if !reflect.DeepEqual(c, want) {
+		t.Fatalf("got: %+v, want: %+v", c, want)
+	}
+
+	s := &tritonhttp.Server{}
+	if err := c.Apply(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Addr != want.Server.Addr || s.DocRoot != want.Server.DocRoot || s.Limits.MaxConns != 1000 ||
+		s.LoadShedding.Fraction != 0.5 || len(s.MetricLabels.Hosts) != 2 || len(s.Routes) != 3 || s.Routes[0].Prefix != "/api/" {
+		t.Fatalf("applied server got: %+v", s)
+	}
+	wantListeners := []tritonhttp.Listener{
+		{Addr: "127.0.0.1:8081"},
+		{Addr: ":8443", Certificates: []tritonhttp.Certificate{{CertFile: "/etc/ssl/a.crt", KeyFile: "/etc/ssl/a.key"}, {CertFile: "/etc/ssl/b.crt", KeyFile: "/etc/ssl/b.key"}}},
+	}
+	if !reflect.DeepEqual(s.Listeners, wantListeners) {
+		t.Fatalf("applied listeners got: %+v", s.Listeners)
+	}
+	wantVHosts := []tritonhttp.VirtualHost{
+		{Hosts: []string{"example.org", "www.example.org"}, DocRoot: "/srv/example.org"},
+		{Hosts: []string{"example.net"}, DocRoot: "/srv/example.net"},
+	}
+	if !reflect.DeepEqual(s.VirtualHosts, wantVHosts) {
+		t.Fatalf("applied virtual hosts got: %+v", s.VirtualHosts)
+	}
+	if cache, ok := s.Routes[0].Handler.(*tritonhttp.Cache); !ok || cache.MaxBytes != 1000000 {
+		t.Fatalf("applied server got: %+v", s)
+	} else if p := cache.Handler.(*tritonhttp.ReverseProxy); p.Retry.Attempts != 3 || len(p.Retry.Statuses) != 2 ||
+		p.Transport.MaxIdleConnsPerHost != 16 || p.Transport.MaxConnLifetime != 10*time.Minute {
+		t.Fatalf("applied proxy got: %+v", p)
+	}
+	if cgi, ok := s.Routes[1].Handler.(*tritonhttp.CGI); !ok || cgi.Root != "/cgi-bin" || cgi.Timeout != 5*time.Second {
+		t.Fatalf("applied CGI route got: %+v", s.Routes[1])
+	}
+	if fcgi, ok := s.Routes[2].Handler.(*tritonhttp.FastCGI); !ok || fcgi.Network != "unix" || fcgi.Addr != "/run/php/php-fpm.sock" ||
+		s.Routes[2].Prefix != "/" || len(s.Routes[2].Extensions) != 1 {
+		t.Fatalf("applied FastCGI route got: %+v", s.Routes[2])
+	}
+	if len(s.Rewrites) != 2 || s.Rewrites[0].Prefix != "/old/" || s.Rewrites[0].Last ||
+		s.Rewrites[1].Pattern == nil || s.Rewrites[1].Replacement != "/api/$1" || !s.Rewrites[1].Last {
+		t.Fatalf("applied rewrites got: %+v", s.Rewrites)
+	}
+	if len(s.Redirects) != 2 || s.Redirects[0].Path != "/a.html" || s.Redirects[0].StatusCode != 0 || !s.Redirects[0].HTML ||
+		s.Redirects[1].Pattern == nil || s.Redirects[1].StatusCode != 308 {
+		t.Fatalf("applied redirects got: %+v", s.Redirects)
+	}
+	if s.TrailingSlash != tritonhttp.SlashAdd || s.Routes[0].TrailingSlash != tritonhttp.SlashStrip || s.Routes[1].TrailingSlash != tritonhttp.SlashAsIs {
+		t.Fatalf("applied trailing slash policies got: %v, %v, %v", s.TrailingSlash, s.Routes[0].TrailingSlash, s.Routes[1].TrailingSlash)
+	}
+	if len(s.Aliases) != 2 || s.Aliases[0] != (tritonhttp.Alias{Path: "/favicon.ico", Target: "/static/favicon.ico"}) ||
+		s.Aliases[1] != (tritonhttp.Alias{Path: "/robots.txt", Content: "User-agent: *\nDisallow:\n"}) {
+		t.Fatalf("applied aliases got: %+v", s.Aliases)
+	}
+	if !reflect.DeepEqual(s.Reserved, []string{"/_admin", "/_health", "/_metrics"}) || len(s.Internal) != 2 ||
+		s.Internal[0].Prefix != "/_health" || s.Internal[1].Prefix != "/_metrics" {
+		t.Fatalf("applied reserved prefixes got: %v, %+v", s.Reserved, s.Internal)
+	}
+	if len(s.AttachmentPrefixes) != 1 || !s.AttachmentQuery {
+		t.Fatalf("applied attachments got: %v, %v", s.AttachmentPrefixes, s.AttachmentQuery)
+	}
+	if !s.EventLoop || !s.Negotiate || !s.ArchiveGzip || len(s.AllowedMethods) != 2 || s.ETag == nil {
+		t.Fatalf("applied event loop, negotiation, archive gzip, methods and etag got: %v, %v, %v, %v, %v", s.EventLoop, s.Negotiate, s.ArchiveGzip, s.AllowedMethods, s.ETag != nil)
+	}
+	if ch := s.CanonicalHost; ch == nil || ch.Host != "example.com" || !ch.HTTPS || len(ch.TrustedProxies) != 1 {
+		t.Fatalf("applied canonical host got: %+v", s.CanonicalHost)
+	}
+	if fp := s.ForwardProxy; fp == nil || len(fp.Allow) != 1 || fp.Credentials["alice"] != "secret" {
+		t.Fatalf("applied forward proxy got: %+v", s.ForwardProxy)
+	}
+	if got := tritonhttp.MIMETypeByExtension(".glb"); got != "model/gltf-binary" {
+		t.Fatalf("applied MIME type of .glb got: %q", got)
+	}
+	if md := s.Markdown; md == nil || md.RawQuery != "source" || md.Template != nil {
+		t.Fatalf("applied Markdown got: %+v", s.Markdown)
+	}
+	if s.SSI == nil || s.SSI.MaxDepth != 4 {
+		t.Fatalf("applied SSI got: %+v", s.SSI)
+	}
+	if len(s.WebDAV) != 2 || s.WebDAV[0].ReadOnly || !s.WebDAV[1].ReadOnly || s.WebDAV[1].Credentials["alice"] != "secret" {
+		t.Fatalf("applied WebDAV mounts got: %+v", s.WebDAV)
+	}
+	wantPolicies := []tritonhttp.GeoPolicy{
+		{Prefix: "/admin/private", Deny: []string{"AS64496"}},
+		{Prefix: "/admin", Allow: []string{"US", "CA"}, Deny: []string{"CN"}},
+	}
+	if g := s.GeoIP; g == nil || len(g.Databases) != 2 || g.CheckInterval != 5*time.Minute || !reflect.DeepEqual(g.Policies, wantPolicies) {
+		t.Fatalf("applied GeoIP got: %+v", s.GeoIP)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	s := &tritonhttp.Server{Addr: ":8080", DocRoot: "/srv/old"}
+	c, err := Parse("httpd.toml", []byte(`
+[server]
+addr = ":9090"
+doc_root = "/srv/new"
+
+[limits]
+max_conns = 10
+max_conns_per_ip = 5
+
+[logging]
+level = "debug"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update(s); err != nil {
+		t.Fatal(err)
+	}
+	st := s.Settings()
+	if st.LogLevel != tritonhttp.LevelDebug || st.Limits.MaxConns != 10 || s.DocRoot != "/srv/new" {
+		t.Errorf("updated settings got %+v, doc root %q", st, s.DocRoot)
+	}
+	// Only the settings that can change while serving are updated
+	if s.Addr != ":8080" {
+		t.Errorf("updated address got %q", s.Addr)
+	}
+
+	c.Limits.MaxConns = -1
+	c.Server.DocRoot = "/srv/bad"
+	if err := c.Update(s); err == nil {
+		t.Error("invalid update got no error")
+	}
+	if s.Settings().Limits.MaxConns != 10 || s.DocRoot != "/srv/new" {
+		t.Errorf("invalid update changed settings to %+v, doc root %q", s.Settings(), s.DocRoot)
+	}
+}
+
+func TestParseMultilineArrays(t *testing.T) {
+	c, err := Parse("httpd.toml", []byte(`
+[metrics]
+hosts = [
+  "example.com",   # the apex
+  "www.example.com",
+  "[not] # a comment",
+]
+countries = [
+]
+routes = ["/images/"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"example.com", "www.example.com", "[not] # a comment"}
+	if !reflect.DeepEqual(c.Metrics.Hosts, want) || len(c.Metrics.Countries) != 0 || !reflect.DeepEqual(c.Metrics.Routes, []string{"/images/"}) {
+		t.Fatalf("got %q, %q, %q", c.Metrics.Hosts, c.Metrics.Countries, c.Metrics.Routes)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var tests = []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"UnknownTable", "[limit]\nmax_conns = 1", `httpd.toml:1: unknown table [limit] (did you mean "limits"?)`},
+		{"UnknownKey", "[limits]\nmax_con = 1", `httpd.toml:2: unknown key "max_con" in [limits] (did you mean "max_conns"?)`},
+		{"TopLevelKey", "addr = \":80\"", `httpd.toml:1: unknown key "addr" at top level`},
+		{"TableAsKey", "limits = 1", `httpd.toml:1: unknown key "limits" at top level`},
+		{"WrongType", "[limits]\nmax_conns = \"many\"", `httpd.toml:2: limits.max_conns: expected an integer, got string "many"`},
+		{"BadDuration", "[limits]\nread_timeout = \"5 parsecs\"", `httpd.toml:2: limits.read_timeout: invalid duration "5 parsecs"`},
+		{"DurationNumber", "[limits]\nread_timeout = 5", `httpd.toml:2: limits.read_timeout: expected a duration string such as "5s", got int64 5`},
+		{"ArrayElement", "[metrics]\nhosts = [\"a\", 1]", `httpd.toml:2: metrics.hosts: element 1: expected a string, got int64 1`},
+		{"DuplicateKey", "[server]\naddr = \":1\"\naddr = \":2\"", `httpd.toml:3: key "addr" defined twice`},
+		{"DuplicateTable", "[server]\n[server]", `httpd.toml:2: table [server] defined twice`},
+		{"MissingValue", "[server]\naddr =", `httpd.toml:2: addr: missing value`},
+		{"NoEquals", "[server]\naddr", `httpd.toml:2: expected key = value, got "addr"`},
+		{"Unterminated", "[server]\naddr = \":1", `httpd.toml:2: addr: unterminated string ":1`},
+		{"UnterminatedArray", "[metrics]\nhosts = [\n  \"a\",\n", `httpd.toml:2: hosts: unterminated array`},
+		{"MultilineArrayNoComma", "[metrics]\nhosts = [\n  \"a\"\n  \"b\"\n]", `httpd.toml:2: hosts: `},
+		{"BadLevel", "[logging]\nlevel = \"loud\"", `httpd.toml: logging.level: `},
+		{"BadLimits", "[limits]\nmax_conns = -1", `httpd.toml: limits: `},
+		{"BadProxyRoute", "[proxy]\nroutes = [\"http://127.0.0.1:9000\"]", `httpd.toml: proxy.routes[0]: expected "/prefix=url"`},
+		{"BadProxyUpstream", "[proxy]\nroutes = [\"/api=ftp://x\"]", `httpd.toml: proxy.routes[0]: unsupported upstream URL scheme "ftp"`},
+		{"BadBackupUpstream", "[proxy]\nroutes = [\"/api=http://a:9000,b:9000\"]", `httpd.toml: proxy.routes[0]: unsupported upstream URL scheme "b"`},
+		{"BadRetryStatus", "[proxy]\nretry_statuses = [5]", `httpd.toml: proxy.retry_statuses[0]: invalid status code 5`},
+		{"BadRewriteRule", "[rewrite]\nrules = [\"/a /b next\"]", `httpd.toml: rewrite.rules[0]: expected "match replacement [last]", got "/a /b next"`},
+		{"BadRewritePattern", "[rewrite]\nrules = [\"^/(a /b\"]", "httpd.toml: rewrite.rules[0]: error parsing regexp: missing closing ): `^/(a`"},
+		{"BadRedirectRule", "[redirect]\nrules = [\"/a\"]", `httpd.toml: redirect.rules[0]: expected "match location [status]", got "/a"`},
+		{"BadRedirectStatus", "[redirect]\nrules = [\"/a /b 303\"]", `httpd.toml: redirect.rules[0]: status must be 301, 302, 307 or 308, got "303"`},
+		{"BadTrailingSlash", "[server]\ntrailing_slash = \"sometimes\"", `httpd.toml: server.trailing_slash: unknown trailing slash policy "sometimes"`},
+		{"BadReserved", "[server]\nreserved = [\"_admin\"]", `httpd.toml: server.reserved[0]: expected a path prefix other than "/", got "_admin"`},
+		{"RootHealthPath", "[server]\nhealth_path = \"/\"", `httpd.toml: server.health_path: expected a path other than "/", got "/"`},
+		{"BadAttachments", "[server]\nattachments = [\"files/\"]", `httpd.toml: server.attachments[0]: must start with "/", got "files/"`},
+		{"BadProxyTrailingSlash", "[proxy]\ntrailing_slash = \"sometimes\"", `httpd.toml: proxy.trailing_slash: unknown trailing slash policy "sometimes"`},
+		{"BadAliasPath", "[alias]\npaths = [\"/favicon.ico\"]", `httpd.toml: alias.paths[0]: expected "/path=/target", got "/favicon.ico"`},
+		{"BadAliasContent", "[alias]\ncontent = [\"robots.txt=x\"]", `httpd.toml: alias.content[0]: expected "/path=content", got "robots.txt=x"`},
+		{"BadForwardUser", "[proxy]\nforward_allow = [\"*\"]\nforward_users = [\"alice\"]", `httpd.toml: proxy.forward_users[0]: expected "user:password"`},
+		{"BadWebDAVMount", "[webdav]\nmounts = [\"dav\"]", `httpd.toml: webdav.mounts[0]: must start with "/", got "dav"`},
+		{"BadWebDAVUser", "[webdav]\nmounts = [\"/dav/\"]\nusers = [\"alice\"]", `httpd.toml: webdav.users[0]: expected "user:password"`},
+		{"WebDAVReadOnlyUnmounted", "[webdav]\nread_only = [\"/dav/\"]", `httpd.toml: webdav.read_only[0]: "/dav/" is not one of webdav.mounts`},
+		{"BadMIMEType", "[mime]\ntypes = [\"glb=model/gltf-binary\"]", `httpd.toml: mime.types[0]: expected ".ext=type", got "glb=model/gltf-binary"`},
+		{"BadGeoRule", "[geoip]\ndatabases = [\"a.mmdb\"]\nallow = [\"/admin=USA\"]", `httpd.toml: geoip.allow[0]: "USA" is neither a country code, e.g. "US", nor an autonomous system, e.g. "AS64496"`},
+		{"GeoRulesAlone", "[geoip]\ndeny = [\"/=CN\"]", `httpd.toml: geoip.databases must be set for geoip.allow and geoip.deny`},
+		{"ForwardUsersAlone", "[proxy]\nforward_users = [\"alice:secret\"]", `httpd.toml: proxy.forward_users is set without proxy.forward_allow`},
+		{"BadCGIPrefix", "[cgi]\ndir = \"cgi-bin\"\nprefix = \"cgi\"", `httpd.toml: cgi.prefix must start with "/", got "cgi"`},
+		{"NoFastCGIExtensions", "[fastcgi]\naddr = \"127.0.0.1:9000\"\nextensions = []", `httpd.toml: fastcgi.extensions must not be empty`},
+		{"BadTrustedProxy", "[proxy]\ntrusted_proxies = [\"10.0.0.1\"]", `httpd.toml: proxy.trusted_proxies[0]: netip.ParsePrefix("10.0.0.1"): no '/'`},
+		{"BadSampleRate", "[statsd]\nsample_rate = 2", `httpd.toml: statsd.sample_rate must be in (0, 1], got 2`},
+		{"NoListeners", "[server]\naddr = \"\"", `httpd.toml: server.addr must be set`},
+		{"EmptyListen", "[server]\nlisten = [\"\"]", `httpd.toml: server.listen[0]: must not be empty`},
+		{"BadCertificate", "[tls]\nlisten = [\":8443\"]\ncertificates = [\"a.crt\"]", `httpd.toml: tls.certificates[0]: expected "cert=key", got "a.crt"`},
+		{"TLSWithoutCertificates", "[tls]\nlisten = [\":8443\"]", `httpd.toml: tls.certificates must be set for tls.listen`},
+		{"CertificatesWithoutTLS", "[tls]\ncertificates = [\"a.crt=a.key\"]", `httpd.toml: tls.listen must be set for tls.certificates`},
+		{"BadVHost", "[vhosts]\nsites = [\"example.org\"]", `httpd.toml: vhosts.sites[0]: expected "hosts=dir", got "example.org"`},
+		{"VHostWithPort", "[vhosts]\nsites = [\"example.org:80=/srv\"]", `httpd.toml: vhosts.sites[0]: invalid host "example.org:80"`},
+		{"DuplicateVHost", "[vhosts]\nsites = [\"a.org=/a\", \"A.org=/b\"]", `httpd.toml: vhosts.sites[1]: host "a.org" is already served`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse("httpd.toml", []byte(tt.config))
+			if err == nil {
+				t.Fatalf("got no error, want: %v", tt.wantErr)
+			}
+			if !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Fatalf("got error: %v, want: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decode stores the tables of doc in the struct fields of out,
+// matched by their toml tags, reporting unknown tables and keys
+// and values of the wrong type.
+func decode(name string, doc document, out interface{}) error {
+	root := reflect.ValueOf(out).Elem()
+	tables := make([]string, 0, len(doc))
+	for tname := range doc {
+		tables = append(tables, tname)
+	}
+	sort.Strings(tables)
+
+	for _, tname := range tables {
+		t := doc[tname]
+		dst := root
+		if tname != "" {
+			field, ok := fieldByTag(root, tname)
+			if !ok || field.Kind() != reflect.Struct {
+				return fmt.Errorf("%v:%v: unknown table [%v]%v", name, t.line, tname, suggest(tname, tagsOf(root, true)))
+			}
+			dst = field
+		}
+		if err := decodeTable(name, tname, t, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeTable stores the keys of t in the fields of the struct dst.
+func decodeTable(name, tname string, t *table, dst reflect.Value) error {
+	keys := make([]string, 0, len(t.keys))
+	for key := range t.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return t.keys[keys[i]].line < t.keys[keys[j]].line })
+
+	for _, key := range keys {
+		v := t.keys[key]
+		qualified := key
+		if tname != "" {
+			qualified = tname + "." + key
+		}
+		field, ok := fieldByTag(dst, key)
+		if !ok || field.Kind() == reflect.Struct {
+			where := "at top level"
+			if tname != "" {
+				where = fmt.Sprintf("in [%v]", tname)
+			}
+			return fmt.Errorf("%v:%v: unknown key %q %v%v", name, v.line, key, where, suggest(key, tagsOf(dst, false)))
+		}
+		if err := setField(field, v.v); err != nil {
+			return fmt.Errorf("%v:%v: %v: %v", name, v.line, qualified, err)
+		}
+	}
+	return nil
+}
+
+// setField stores v in field, converting it to the field's type.
+func setField(field reflect.Value, v interface{}) error {
+	if field.Type() == durationType {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expected a duration string such as \"5s\", got %v", describe(v))
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %v", describe(v))
+		}
+		field.SetString(s)
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("expected true or false, got %v", describe(v))
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, ok := v.(int64)
+		if !ok {
+			return fmt.Errorf("expected an integer, got %v", describe(v))
+		}
+		if field.OverflowInt(n) {
+			return fmt.Errorf("%v is out of range", n)
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		switch n := v.(type) {
+		case float64:
+			field.SetFloat(n)
+		case int64:
+			field.SetFloat(float64(n))
+		default:
+			return fmt.Errorf("expected a number, got %v", describe(v))
+		}
+	case reflect.Slice:
+		items, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected an array, got %v", describe(v))
+		}
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setField(slice.Index(i), item); err != nil {
+				return fmt.Errorf("element %v: %v", i, err)
+			}
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unsupported field type %v", field.Type())
+	}
+	return nil
+}
+
+// describe names the type of a parsed value for error messages.
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case []interface{}:
+		return "an array"
+	default:
+		return fmt.Sprintf("%T %v", v, v)
+	}
+}
+
+// fieldByTag returns the field of the struct v tagged toml:"name".
+func fieldByTag(v reflect.Value, name string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("toml") == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// tagsOf returns the toml tags of the table (struct) or key
+// fields of the struct v.
+func tagsOf(v reflect.Value, tables bool) []string {
+	var tags []string
+	for i := 0; i < v.NumField(); i++ {
+		tag := v.Type().Field(i).Tag.Get("toml")
+		if tag != "" && (v.Field(i).Kind() == reflect.Struct) == tables {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// suggest returns a hint naming the candidate closest to name,
+// if any is close enough to be a likely typo.
+func suggest(name string, candidates []string) string {
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if d := editDistance(name, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// value is a parsed TOML value along with the line it was found on.
+type value struct {
+	v    interface{} // string, int64, float64, bool or []interface{}
+	line int
+}
+
+// table holds the keys of one TOML table.
+type table struct {
+	line int
+	keys map[string]value
+}
+
+// document is a parsed TOML file: its tables by name, with the
+// top-level keys under "".
+type document map[string]*table
+
+// parseTOML parses the subset of TOML configuration files need:
+// tables, key/value pairs, comments, and string, integer, float,
+// boolean and array values, arrays spanning several lines included.
+// Errors are prefixed with name and the line number.
+func parseTOML(name string, data []byte) (document, error) {
+	doc := document{"": {line: 0, keys: map[string]value{}}}
+	cur := doc[""]
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		raw, line := lines[i], i+1
+		errorf := func(format string, v ...interface{}) error {
+			return fmt.Errorf("%v:%v: %v", name, line, fmt.Sprintf(format, v...))
+		}
+		text := strings.TrimSpace(stripComment(raw))
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") || strings.HasPrefix(text, "[[") {
+				return nil, errorf("invalid table header %q", text)
+			}
+			tname := strings.TrimSpace(text[1 : len(text)-1])
+			if !isBareKey(tname) {
+				return nil, errorf("invalid table name %q", tname)
+			}
+			if _, ok := doc[tname]; ok {
+				return nil, errorf("table [%v] defined twice", tname)
+			}
+			cur = &table{line: line, keys: map[string]value{}}
+			doc[tname] = cur
+			continue
+		}
+
+		eq := strings.IndexByte(text, '=')
+		if eq < 0 {
+			return nil, errorf("expected key = value, got %q", text)
+		}
+		key := strings.TrimSpace(text[:eq])
+		if !isBareKey(key) {
+			return nil, errorf("invalid key %q", key)
+		}
+		if _, ok := cur.keys[key]; ok {
+			return nil, errorf("key %q defined twice", key)
+		}
+		val := strings.TrimSpace(text[eq+1:])
+		// An array goes on until its brackets are balanced
+		for strings.HasPrefix(val, "[") && bracketDepth(val) > 0 {
+			if i++; i == len(lines) {
+				return nil, errorf("%v: unterminated array", key)
+			}
+			val += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+		v, err := parseValue(val)
+		if err != nil {
+			return nil, errorf("%v: %v", key, err)
+		}
+		cur.keys[key] = value{v: v, line: line}
+	}
+	return doc, nil
+}
+
+// stripComment removes a trailing "#" comment that is not inside a string.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// isBareKey reports whether s is a valid bare TOML key.
+func isBareKey(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseValue parses a single TOML value.
+func parseValue(s string) (interface{}, error) {
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("missing value")
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case s[0] == '"':
+		if len(s) < 2 || s[len(s)-1] != '"' {
+			return nil, fmt.Errorf("unterminated string %v", s)
+		}
+		str, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %v", s)
+		}
+		return str, nil
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' || strings.Contains(s[1:len(s)-1], "'") {
+			return nil, fmt.Errorf("invalid literal string %v", s)
+		}
+		return s[1 : len(s)-1], nil
+	case s[0] == '[':
+		return parseArray(s)
+	}
+	num := strings.ReplaceAll(s, "_", "")
+	if n, err := strconv.ParseInt(num, 0, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(num, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value %v", s)
+}
+
+// bracketDepth returns how many of the brackets of s, outside of
+// strings, are left open at its end.
+func bracketDepth(s string) int {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth
+}
+
+// parseArray parses an array of values, joined onto one line.
+func parseArray(s string) ([]interface{}, error) {
+	if s[len(s)-1] != ']' {
+		return nil, fmt.Errorf("unterminated array %v", s)
+	}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	items := []interface{}{}
+	for inner != "" {
+		end := elementEnd(inner)
+		v, err := parseValue(strings.TrimSpace(inner[:end]))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+		if end == len(inner) {
+			break
+		}
+		inner = strings.TrimSpace(inner[end+1:])
+	}
+	return items, nil
+}
+
+// elementEnd returns the index of the comma ending the first element
+// of the array contents s, or len(s).
+func elementEnd(s string) int {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			return i
+		}
+	}
+	return len(s)
+}
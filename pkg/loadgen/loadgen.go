@@ -0,0 +1,314 @@
+// Package loadgen drives concurrent load against an HTTP server and
+// reports its throughput, latency percentiles and errors, to catch
+// performance regressions in TritonHTTP.
+//
+// A run looks like:
+//
+//	rep, err := loadgen.Run(ctx, loadgen.Config{
+//		URLs:        []string{"http://localhost:8080/index.html"},
+//		Concurrency: 50,
+//		Duration:    10 * time.Second,
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	rep.WriteText(os.Stdout)
+//
+// Responses without a Content-Length, such as TritonHTTP's 404s, are
+// read until the server closes the connection, so point runs at files
+// that exist.
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"cse224/proj3/pkg/tritonhttp"
+)
+
+// Config describes a load run. It must set URLs, and Duration or
+// Requests or both; the run stops at whichever comes first.
+type Config struct {
+	URLs        []string      // requested in turn by each worker
+	Concurrency int           // workers sending requests; 0 means 1
+	Duration    time.Duration // how long to run; 0 means until Requests are sent
+	Requests    int           // total requests to send; 0 means until Duration is up
+	Timeout     time.Duration // per request, body included; 0 means none
+
+	// Transport sends the requests. If nil, a new Transport keeping
+	// an idle connection per worker is used.
+	Transport *tritonhttp.Transport
+}
+
+// Validate reports an error if c cannot describe a run.
+func (c Config) Validate() error {
+	switch {
+	case len(c.URLs) == 0:
+		return errors.New("loadgen: no URLs")
+	case c.Concurrency < 0:
+		return fmt.Errorf("loadgen: negative concurrency %v", c.Concurrency)
+	case c.Duration < 0 || c.Requests < 0 || c.Timeout < 0:
+		return errors.New("loadgen: negative duration, requests or timeout")
+	case c.Duration == 0 && c.Requests == 0:
+		return errors.New("loadgen: neither duration nor requests set")
+	}
+	for _, u := range c.URLs {
+		if _, err := tritonhttp.NewRequest("GET", u); err != nil {
+			return fmt.Errorf("loadgen: %w", err)
+		}
+	}
+	return nil
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Requests int           // requests sent
+	Elapsed  time.Duration // wall time of the run
+	Bytes    int64         // response body bytes read
+
+	// StatusCodes counts the responses by status code.
+	StatusCodes map[int]int
+
+	// Errors counts the requests that got no complete response, by
+	// kind, e.g. "timeout" or "connection refused".
+	Errors map[string]int
+
+	latencies []time.Duration // of complete responses, sorted
+}
+
+// RPS returns the complete responses per second.
+func (r *Report) RPS() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(len(r.latencies)) / r.Elapsed.Seconds()
+}
+
+// Percentile returns the latency p percent of the complete responses
+// were at most, e.g. Percentile(99). It is 0 if there were none.
+func (r *Report) Percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := int(p / 100 * float64(len(r.latencies)))
+	if i >= len(r.latencies) {
+		i = len(r.latencies) - 1
+	} else if i < 0 {
+		i = 0
+	}
+	return r.latencies[i]
+}
+
+// Mean returns the mean latency of the complete responses.
+func (r *Report) Mean() time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, l := range r.latencies {
+		sum += l
+	}
+	return sum / time.Duration(len(r.latencies))
+}
+
+// WriteText writes r in a human readable form to w.
+func (r *Report) WriteText(w io.Writer) error {
+	ew := &errWriter{w: w}
+	ew.printf("Requests:   %v in %v\n", r.Requests, r.Elapsed.Round(time.Millisecond))
+	ew.printf("Throughput: %.1f req/s, %.1f KiB/s\n", r.RPS(), float64(r.Bytes)/1024/r.Elapsed.Seconds())
+	ew.printf("Latency:    mean %v, p50 %v, p90 %v, p99 %v, max %v\n",
+		r.Mean(), r.Percentile(50), r.Percentile(90), r.Percentile(99), r.Percentile(100))
+	codes := make([]int, 0, len(r.StatusCodes))
+	for code := range r.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		ew.printf("  %v: %v\n", code, r.StatusCodes[code])
+	}
+	kinds := make([]string, 0, len(r.Errors))
+	for kind := range r.Errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		ew.printf("  error %v: %v\n", kind, r.Errors[kind])
+	}
+	return ew.err
+}
+
+// errWriter keeps the first error writing to w.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, v ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, v...)
+	}
+}
+
+// Run sends requests as cfg describes until it is done or ctx is.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	workers := cfg.Concurrency
+	if workers == 0 {
+		workers = 1
+	}
+	tr := cfg.Transport
+	if tr == nil {
+		tr = &tritonhttp.Transport{MaxIdleConnsPerHost: workers}
+		defer tr.CloseIdleConnections()
+	}
+	c := &tritonhttp.Client{Transport: tr}
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	var (
+		sent   atomic.Int64
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		report = &Report{StatusCodes: map[int]int{}, Errors: map[string]int{}}
+	)
+	start := time.Now()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var local result
+			for i := w; !ended(ctx); i++ {
+				if cfg.Requests > 0 && sent.Add(1) > int64(cfg.Requests) {
+					break
+				}
+				r, ok := send(ctx, c, cfg.URLs[i%len(cfg.URLs)], cfg.Timeout)
+				if !ok {
+					break
+				}
+				local.add(r)
+			}
+			mu.Lock()
+			local.mergeInto(report)
+			mu.Unlock()
+		}(w)
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+	sort.Slice(report.latencies, func(i, j int) bool { return report.latencies[i] < report.latencies[j] })
+	return report, nil
+}
+
+// result is the outcome of one request, or of several gathered by
+// a worker.
+type result struct {
+	requests  int
+	bytes     int64
+	codes     map[int]int
+	errors    map[string]int
+	latencies []time.Duration
+}
+
+func (r *result) add(o result) {
+	if r.codes == nil {
+		r.codes = map[int]int{}
+		r.errors = map[string]int{}
+	}
+	r.requests += o.requests
+	r.bytes += o.bytes
+	for code, n := range o.codes {
+		r.codes[code] += n
+	}
+	for kind, n := range o.errors {
+		r.errors[kind] += n
+	}
+	r.latencies = append(r.latencies, o.latencies...)
+}
+
+func (r *result) mergeInto(rep *Report) {
+	rep.Requests += r.requests
+	rep.Bytes += r.bytes
+	for code, n := range r.codes {
+		rep.StatusCodes[code] += n
+	}
+	for kind, n := range r.errors {
+		rep.Errors[kind] += n
+	}
+	rep.latencies = append(rep.latencies, r.latencies...)
+}
+
+// send requests rawURL and reads the response. It reports false if
+// the run ended before the request could complete, which then does
+// not count.
+func send(ctx context.Context, c *tritonhttp.Client, rawURL string, timeout time.Duration) (result, bool) {
+	reqCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	res, err := c.GetContext(reqCtx, rawURL)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(io.Discard, res.BodyReader)
+		_ = res.Close()
+	}
+	if err != nil && ended(ctx) {
+		return result{}, false
+	}
+	r := result{requests: 1, bytes: n}
+	if err != nil {
+		r.errors = map[string]int{errorKind(err): 1}
+		return r, true
+	}
+	r.codes = map[int]int{res.StatusCode: 1}
+	r.latencies = []time.Duration{time.Since(start)}
+	return r, true
+}
+
+// ended reports whether the run with ctx is over. Its deadline may
+// pass a little before ctx reports it.
+func ended(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	d, ok := ctx.Deadline()
+	return ok && !time.Now().Before(d)
+}
+
+// errorKind names the kind of a request error for the breakdown.
+func errorKind(err error) string {
+	var te *tritonhttp.TimeoutError
+	switch {
+	case errors.As(err, &te):
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "connection reset"
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return "connection closed early"
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "timeout"
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) {
+		return oe.Op + " error"
+	}
+	return "other"
+}
@@ -0,0 +1,97 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"cse224/proj3/pkg/tritonhttptest"
+)
+
+func startServer(t *testing.T) string {
+	ts := tritonhttptest.NewTestServer(t, &tritonhttptest.Options{
+		Files: map[string]string{"index.html": "<h1>index</h1>", "fake.png": "not a png"},
+	})
+	return ts.URL
+}
+
+func TestRun(t *testing.T) {
+	base := startServer(t)
+	rep, err := Run(context.Background(), Config{
+		URLs:        []string{base + "/index.html", base + "/fake.png"},
+		Concurrency: 4,
+		Requests:    100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Requests != 100 || rep.StatusCodes[200] != 100 || len(rep.Errors) != 0 {
+		t.Fatalf("got %v requests, codes %v, errors %v", rep.Requests, rep.StatusCodes, rep.Errors)
+	}
+	if rep.RPS() <= 0 || rep.Percentile(50) <= 0 || rep.Percentile(50) > rep.Percentile(100) {
+		t.Fatalf("got %v req/s, p50 %v, max %v", rep.RPS(), rep.Percentile(50), rep.Percentile(100))
+	}
+	var buf bytes.Buffer
+	if err := rep.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "  200: 100\n") {
+		t.Fatalf("report lacks the 200 count:\n%v", buf.String())
+	}
+}
+
+func TestRunDuration(t *testing.T) {
+	base := startServer(t)
+	start := time.Now()
+	rep, err := Run(context.Background(), Config{URLs: []string{base + "/index.html"}, Duration: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("ran for %v, want about 100ms", elapsed)
+	}
+	if rep.Requests == 0 || len(rep.Errors) != 0 {
+		t.Fatalf("got %v requests, errors %v", rep.Requests, rep.Errors)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	rep, err := Run(context.Background(), Config{URLs: []string{"http://" + addr + "/"}, Requests: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Errors["connection refused"] != 3 {
+		t.Fatalf("errors got: %v, want 3 refused connections", rep.Errors)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	var tests = []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"Requests", Config{URLs: []string{"http://localhost/"}, Requests: 1}, false},
+		{"Duration", Config{URLs: []string{"http://localhost/"}, Duration: time.Second}, false},
+		{"NoURLs", Config{Requests: 1}, true},
+		{"BadURL", Config{URLs: []string{"localhost"}, Requests: 1}, true},
+		{"NoStop", Config{URLs: []string{"http://localhost/"}}, true},
+		{"NegativeConcurrency", Config{URLs: []string{"http://localhost/"}, Requests: 1, Concurrency: -1}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.cfg.Validate(); (err != nil) != test.wantErr {
+				t.Fatalf("got error: %v, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+// Package replay records the raw traffic between clients and a server,
+// and replays it later against a server, so that regression suites can
+// be built from real traffic.
+//
+// A Recorder is a proxy writing a transcript per connection, in the
+// format of tritonhttp.Capture. Replay sends the client side of such
+// a transcript to a server and compares its responses with those
+// recorded. Unless Capture.Raw is set, credentials are redacted in the
+// transcripts, and replay as "[REDACTED]".
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"cse224/proj3/pkg/tritonhttp"
+)
+
+// DefaultTimeout bounds each exchange of a replay unless
+// Options.Timeout is set.
+const DefaultTimeout = 10 * time.Second
+
+// Recorder is a proxy forwarding each connection accepted to Upstream
+// and recording it as Capture describes.
+type Recorder struct {
+	Upstream string // host:port of the server recorded
+	Capture  tritonhttp.Capture
+
+	// Logger receives the errors of the connections proxied; nil
+	// means a tritonhttp.NewLogger writing to standard error.
+	Logger tritonhttp.Logger
+}
+
+// Serve accepts connections on ln and proxies them until ln is closed,
+// then waits for the connections in flight.
+func (r *Recorder) Serve(ln net.Listener) error {
+	logger := r.Logger
+	if logger == nil {
+		logger = tritonhttp.NewLogger(nil, tritonhttp.LevelInfo)
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.proxy(conn); err != nil {
+				logger.Warnf("Proxying %v: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// proxy forwards client to Upstream and back until both are done.
+func (r *Recorder) proxy(client net.Conn) error {
+	upstream, err := net.Dial("tcp", r.Upstream)
+	if err != nil {
+		_ = client.Close()
+		return err
+	}
+	recorded, err := r.Capture.Wrap(client)
+	if err != nil {
+		_ = client.Close()
+		_ = upstream.Close()
+		return err
+	}
+	defer recorded.Close()
+	defer upstream.Close()
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(upstream, recorded)
+		closeWrite(upstream)
+		close(done)
+	}()
+	_, err = io.Copy(recorded, upstream)
+	closeWrite(client)
+	<-done
+	return err
+}
+
+// closeWrite half-closes conn if it can, so that the other side of
+// the proxy sees the end of the input.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	}
+}
+
+// Options configures Replay. A nil *Options uses the defaults.
+type Options struct {
+	// IgnoreHeaders are not compared, in addition to Date.
+	IgnoreHeaders []string
+
+	// Timeout bounds each exchange; 0 means DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Result is the outcome of a replay.
+type Result struct {
+	Responses int      // responses compared
+	Diffs     []string // differences found, none if the replay matched
+}
+
+// ReplayFile replays the transcript at path against the server at addr.
+func ReplayFile(ctx context.Context, addr, path string, opts *Options) (*Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	chunks, _, err := tritonhttp.ReadTranscript(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return Replay(ctx, addr, chunks, opts)
+}
+
+// Replay sends the client chunks of a transcript on a new connection
+// to addr in turn. After each run of client chunks, it reads as many
+// responses as the transcript recorded next and compares them with
+// those, Date and opts.IgnoreHeaders aside. A truncated recorded
+// response ends the replay.
+func Replay(ctx context.Context, addr string, chunks []tritonhttp.TranscriptChunk, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ignored := map[string]bool{"Date": true}
+	for _, key := range opts.IgnoreHeaders {
+		ignored[tritonhttp.CanonicalHeaderKey(key)] = true
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+	br := bufio.NewReader(conn)
+
+	res := &Result{}
+	for len(chunks) > 0 {
+		var sent, recorded []byte
+		for len(chunks) > 0 && chunks[0].FromClient {
+			sent = append(sent, chunks[0].Data...)
+			chunks = chunks[1:]
+		}
+		for len(chunks) > 0 && !chunks[0].FromClient {
+			recorded = append(recorded, chunks[0].Data...)
+			chunks = chunks[1:]
+		}
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(sent); err != nil {
+			return nil, err
+		}
+		rbr := bufio.NewReader(bytes.NewReader(recorded))
+		for {
+			if _, err := rbr.Peek(1); err == io.EOF {
+				break
+			}
+			want, err := readResponse(rbr)
+			if err != nil {
+				// Truncated by Capture.MaxBytes
+				return res, nil
+			}
+			got, err := readResponse(br)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				res.Diffs = append(res.Diffs, fmt.Sprintf("response %v: %v", res.Responses, err))
+				return res, nil
+			}
+			res.Diffs = append(res.Diffs, compare(res.Responses, want, got, ignored)...)
+			res.Responses++
+		}
+	}
+	return res, nil
+}
+
+// response is a response as Replay compares it.
+type response struct {
+	status string
+	header map[string]string
+	body   []byte
+}
+
+// readResponse reads one response from br. A response without a
+// Content-Length has no body, as in the TritonHTTP spec.
+func readResponse(br *bufio.Reader) (*response, error) {
+	res, err := tritonhttp.ReadResponse(br, &tritonhttp.Request{Method: "HEAD"})
+	if err != nil {
+		return nil, err
+	}
+	r := &response{status: res.Proto + " " + strconv.Itoa(res.StatusCode), header: res.Header}
+	if cl, ok := res.Header["Content-Length"]; ok {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid Content-Length %q", cl)
+		}
+		r.body = make([]byte, n)
+		if _, err := io.ReadFull(br, r.body); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// compare returns the differences between response i as recorded in
+// want and as replayed in got, leaving the ignored headers out.
+func compare(i int, want, got *response, ignored map[string]bool) []string {
+	var diffs []string
+	if want.status != got.status {
+		diffs = append(diffs, fmt.Sprintf("response %v: status %q, recorded %q", i, got.status, want.status))
+	}
+	keys := map[string]bool{}
+	for key := range want.header {
+		keys[key] = true
+	}
+	for key := range got.header {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		if !ignored[key] {
+			sorted = append(sorted, key)
+		}
+	}
+	sort.Strings(sorted)
+	for _, key := range sorted {
+		w, wok := want.header[key]
+		g, gok := got.header[key]
+		switch {
+		case !wok:
+			diffs = append(diffs, fmt.Sprintf("response %v: unexpected %v %q", i, key, g))
+		case !gok:
+			diffs = append(diffs, fmt.Sprintf("response %v: missing %v %q", i, key, w))
+		case w != g:
+			diffs = append(diffs, fmt.Sprintf("response %v: %v %q, recorded %q", i, key, g, w))
+		}
+	}
+	if !bytes.Equal(want.body, got.body) {
+		diffs = append(diffs, fmt.Sprintf("response %v: body of %v bytes differs from the %v recorded", i, len(got.body), len(want.body)))
+	}
+	return diffs
+}
@@ -0,0 +1,136 @@
+package replay
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"cse224/proj3/pkg/tritonhttp"
+	"cse224/proj3/pkg/tritonhttptest"
+)
+
+// record sends raw through a Recorder in front of ts and returns the
+// transcript recorded.
+func record(t *testing.T, ts *tritonhttptest.Server, raw []byte) []tritonhttp.TranscriptChunk {
+	t.Helper()
+	dir := t.TempDir()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Recorder{Upstream: ts.Listener.Addr().String(), Capture: tritonhttp.Capture{Dir: dir, Raw: true}}
+	served := make(chan error, 1)
+	go func() { served <- r.Serve(ln) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.(*net.TCPConn).CloseWrite()
+	buf := make([]byte, 4096)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			break
+		}
+	}
+	_ = conn.Close()
+	_ = ln.Close()
+	if err := <-served; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil || len(names) != 1 {
+		t.Fatalf("got transcripts %v, %v, want one", names, err)
+	}
+	f, err := os.Open(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	chunks, _, err := tritonhttp.ReadTranscript(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunks
+}
+
+func TestRecordReplay(t *testing.T) {
+	ts := tritonhttptest.NewTestServer(t, &tritonhttptest.Options{
+		Files: map[string]string{"index.html": "<h1>hi</h1>", "a.txt": "a"},
+	})
+	raw := tritonhttptest.NewRequest("GET", "/").String() +
+		tritonhttptest.NewRequest("GET", "/a.txt").String() +
+		tritonhttptest.NewRequest("GET", "/missing.txt").Close().String()
+	chunks := record(t, ts, []byte(raw))
+
+	res, err := Replay(context.Background(), ts.Listener.Addr().String(), chunks, nil)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if res.Responses != 3 || len(res.Diffs) > 0 {
+		t.Fatalf("got %v responses, diffs %q, want 3 matching", res.Responses, res.Diffs)
+	}
+
+	ts.WriteFile("a.txt", "ab")
+	res, err = Replay(context.Background(), ts.Listener.Addr().String(), chunks, &Options{IgnoreHeaders: []string{"Last-Modified", "Etag"}})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	want := []string{
+		`response 1: Content-Length "2", recorded "1"`,
+		`response 1: body of 2 bytes differs from the 1 recorded`,
+	}
+	if !reflect.DeepEqual(res.Diffs, want) {
+		t.Fatalf("got diffs %q, want %q", res.Diffs, want)
+	}
+}
+
+func TestReplayTruncated(t *testing.T) {
+	ts := tritonhttptest.NewTestServer(t, &tritonhttptest.Options{Files: map[string]string{"a.txt": "a"}})
+	chunks := []tritonhttp.TranscriptChunk{
+		{FromClient: true, Data: tritonhttptest.NewRequest("GET", "/a.txt").Close().Bytes()},
+		{FromClient: false, Data: []byte("HTTP/1.1 200 OK\r\nContent-")},
+	}
+	res, err := Replay(context.Background(), ts.Listener.Addr().String(), chunks, nil)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if res.Responses != 0 || len(res.Diffs) > 0 {
+		t.Fatalf("got %v responses, diffs %q, want none", res.Responses, res.Diffs)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := func() *response {
+		return &response{status: "HTTP/1.1 200", header: map[string]string{"Date": "d1", "Content-Length": "1"}, body: []byte("a")}
+	}
+	var tests = []struct {
+		name   string
+		modify func(r *response)
+		want   []string
+	}{
+		{"Same", func(r *response) {}, nil},
+		{"DateIgnored", func(r *response) { r.header["Date"] = "d2" }, nil},
+		{"Status", func(r *response) { r.status = "HTTP/1.1 404" }, []string{`response 0: status "HTTP/1.1 404", recorded "HTTP/1.1 200"`}},
+		{"Missing", func(r *response) { delete(r.header, "Content-Length") }, []string{`response 0: missing Content-Length "1"`}},
+		{"Unexpected", func(r *response) { r.header["Connection"] = "close" }, []string{`response 0: unexpected Connection "close"`}},
+		{"Body", func(r *response) { r.body = []byte("b") }, []string{"response 0: body of 1 bytes differs from the 1 recorded"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := base()
+			tt.modify(got)
+			diffs := compare(0, base(), got, map[string]bool{"Date": true})
+			if !reflect.DeepEqual(diffs, tt.want) {
+				t.Fatalf("got: %q, want: %q", diffs, tt.want)
+			}
+		})
+	}
+}
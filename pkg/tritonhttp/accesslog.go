@@ -0,0 +1,127 @@
+package tritonhttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// slogLevels maps log levels to their log/slog counterparts.
+var slogLevels = map[LogLevel]slog.Level{
+	LevelDebug: slog.LevelDebug,
+	LevelInfo:  slog.LevelInfo,
+	LevelWarn:  slog.LevelWarn,
+	LevelError: slog.LevelError,
+}
+
+// NewJSONAccessLog returns an access logger writing one JSON object
+// per request to w, suitable for Server.AccessLog.
+// Requests are logged at LevelInfo, client errors at LevelWarn and
+// server errors at LevelError; records below min are dropped.
+func NewJSONAccessLog(w io.Writer, min LogLevel) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slogLevels[min]}))
+}
+
+// accessRecord collects what the access log reports about one request.
+type accessRecord struct {
+	start  time.Time
+	id     string
+	remote string
+	req    *Request // nil for requests that could not be parsed
+	path   string   // as requested, before any rewriting
+	key    RouteKey // metric labels
+	status int
+	bytes  int64
+}
+
+// newAccessRecord starts the record of a request received at start,
+// reusing the client's X-Request-Id if it sent one.
+func newAccessRecord(remote string, req *Request, start time.Time) *accessRecord {
+	rec := &accessRecord{start: start, remote: remote, req: req}
+	if req != nil {
+		rec.path = req.URL
+		rec.id = req.Header["X-Request-Id"]
+	}
+	if rec.id == "" {
+		rec.id = newRequestID()
+	}
+	return rec
+}
+
+// newRequestID returns a random 16 hex digit request ID.
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// accessSampler picks which successful requests get logged.
+type accessSampler struct {
+	seen    atomic.Uint64
+	skipped atomic.Uint64
+}
+
+// sample reports whether a successful request should be logged
+// when logging 1 in every n of them.
+func (as *accessSampler) sample(n int) bool {
+	if n <= 1 {
+		return true
+	}
+	if as.seen.Add(1)%uint64(n) == 1 {
+		return true
+	}
+	as.skipped.Add(1)
+	return false
+}
+
+// AccessLogSkipped returns how many successful requests were left out
+// of the access log by AccessLogSampling.
+func (s *Server) AccessLogSkipped() uint64 {
+	return s.sampler.skipped.Load()
+}
+
+// logAccess writes rec to the access log of s, if any.
+// Errors are always logged, successful requests subject to sampling.
+func (s *Server) logAccess(rec *accessRecord) {
+	if s.AccessLog == nil {
+		return
+	}
+	if rec.status < 400 && !s.sampler.sample(s.AccessLogSampling) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("request_id", rec.id),
+		slog.String("remote", rec.remote),
+		slog.Int("status", rec.status),
+		slog.Int64("bytes", rec.bytes),
+		slog.Duration("latency", time.Since(rec.start)),
+	}
+	if rec.req != nil {
+		attrs = append(attrs,
+			slog.String("method", rec.req.Method),
+			slog.String("host", rec.req.Host),
+			slog.String("path", rec.path),
+			slog.String("vhost", rec.key.Host),
+			slog.String("route", rec.key.Route),
+		)
+		if geo := rec.req.Geo; geo.Country != "" || geo.ASN != 0 {
+			attrs = append(attrs, slog.String("country", geo.Country), slog.Uint64("asn", uint64(geo.ASN)))
+		}
+	}
+	if s.AccessLogSampling > 1 && rec.status < 400 {
+		attrs = append(attrs, slog.Int("sample_rate", s.AccessLogSampling))
+	}
+	level := slog.LevelInfo
+	if rec.status >= 500 {
+		level = slog.LevelError
+	} else if rec.status >= 400 {
+		level = slog.LevelWarn
+	}
+	s.AccessLog.LogAttrs(context.Background(), level, "request", attrs...)
+}
@@ -0,0 +1,67 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLogAccess(t *testing.T) {
+	var buffer bytes.Buffer
+	s := &Server{AccessLog: NewJSONAccessLog(&buffer, LevelInfo)}
+	req := &Request{
+		Method: "GET",
+		URL:    "/index.html",
+		Proto:  "HTTP/1.1",
+		Header: map[string]string{"X-Request-Id": "abc"},
+		Host:   "test",
+	}
+	rec := newAccessRecord("127.0.0.1:1234", req, time.Now())
+	rec.status, rec.bytes = 200, 42
+	s.finishRequest(rec)
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buffer.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buffer.String(), err)
+	}
+	want := map[string]interface{}{
+		"request_id": "abc",
+		"remote":     "127.0.0.1:1234",
+		"status":     float64(200),
+		"bytes":      float64(42),
+		"method":     "GET",
+		"host":       "test",
+		"path":       "/index.html",
+		"vhost":      "other",
+		"route":      "other",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("field %q got: %v, want: %v", k, got[k], v)
+		}
+	}
+	if _, ok := got["latency"]; !ok {
+		t.Fatal("missing field \"latency\"")
+	}
+}
+
+func TestLogAccessSampling(t *testing.T) {
+	var buffer bytes.Buffer
+	s := &Server{AccessLog: NewJSONAccessLog(&buffer, LevelInfo), AccessLogSampling: 4}
+	for i := 0; i < 8; i++ {
+		rec := newAccessRecord("127.0.0.1:1234", nil, time.Now())
+		rec.status = 200
+		s.logAccess(rec)
+	}
+	rec := newAccessRecord("127.0.0.1:1234", nil, time.Now())
+	rec.status = 400
+	s.logAccess(rec)
+
+	if got := bytes.Count(buffer.Bytes(), []byte("\n")); got != 3 {
+		t.Fatalf("got %v entries, want 3 (2 sampled + 1 error)", got)
+	}
+	if got := s.AccessLogSkipped(); got != 6 {
+		t.Fatalf("skipped got: %v, want: 6", got)
+	}
+}
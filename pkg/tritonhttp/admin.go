@@ -0,0 +1,106 @@
+package tritonhttp
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+)
+
+// checkLoopback returns an error unless addr only listens on loopback.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("admin address %q is not a loopback address", addr)
+}
+
+// adminMux returns the handler of the admin listener: profiling,
+// expvars, inspection of the open connections under /admin/conns and
+// the runtime settings under /admin/settings.
+func (s *Server) adminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/admin/conns", s.serveAdminConns)
+	mux.HandleFunc("/admin/conns/close", s.serveAdminCloseConn)
+	mux.HandleFunc("/admin/settings", s.serveAdminSettings)
+	return mux
+}
+
+// serveAdminConns lists the open connections as JSON.
+func (s *Server) serveAdminConns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(s.Connections())
+}
+
+// serveAdminCloseConn force-closes the connection given by the "id"
+// query parameter. It only accepts POST.
+func (s *Server) serveAdminCloseConn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid connection id", http.StatusBadRequest)
+		return
+	}
+	if err := s.CloseConnection(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startAdmin starts serving the admin endpoints on s.AdminAddr, if set.
+func (s *Server) startAdmin() error {
+	if s.AdminAddr == "" {
+		return nil
+	}
+	if s.BlockProfileRate > 0 {
+		runtime.SetBlockProfileRate(s.BlockProfileRate)
+		runtime.SetMutexProfileFraction(s.BlockProfileRate)
+	}
+	ln, err := net.Listen("tcp", s.AdminAddr)
+	if err != nil {
+		return err
+	}
+	s.logger().Infof("Admin endpoints listening on %v", ln.Addr())
+	admin := &http.Server{Handler: s.adminMux()}
+	s.mu.Lock()
+	s.admin = admin
+	s.mu.Unlock()
+	go func() {
+		if err := admin.Serve(ln); err != nil && err != http.ErrServerClosed {
+			s.errorLog().Errorf("Admin listener stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// adminServer returns the admin endpoints server, or nil if
+// it has not been started.
+func (s *Server) adminServer() *http.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.admin
+}
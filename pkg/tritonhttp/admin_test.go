@@ -0,0 +1,37 @@
+package tritonhttp
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckLoopback(t *testing.T) {
+	var tests = []struct {
+		addr    string
+		wantErr bool
+	}{
+		{"localhost:6060", false},
+		{"127.0.0.1:6060", false},
+		{"[::1]:6060", false},
+		{":6060", true},
+		{"0.0.0.0:6060", true},
+		{"10.0.0.1:6060", true},
+		{"localhost", true},
+	}
+	for _, tt := range tests {
+		if err := checkLoopback(tt.addr); (err != nil) != tt.wantErr {
+			t.Fatalf("%q got error: %v, want error: %v", tt.addr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAdminMux(t *testing.T) {
+	mux := (&Server{}).adminMux()
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/vars"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != 200 {
+			t.Fatalf("%v status got: %v, want: 200", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package tritonhttp
+
+import (
+	"path"
+	"strings"
+)
+
+// Alias serves the requests for Path, exactly, as those for another
+// path, Target, e.g. "/favicon.ico" as "/static/img/favicon.ico",
+// without moving files around; or, if Target is empty, answers them
+// with Content, of ContentType, e.g. a generated robots.txt. The
+// ContentType defaults to that of the extension of Path, if known, or
+// else to the one DetectContentType sniffs from Content.
+//
+// The Aliases of a Server apply after its Rewrites, before routing.
+type Alias struct {
+	Path        string
+	Target      string
+	Content     string
+	ContentType string
+}
+
+// alias applies the Alias for the path of req, if any: it returns the
+// response with its Content, or sets req.URL to its Target, keeping
+// the query, and returns nil.
+func (s *Server) alias(req *Request) *Response {
+	urlPath, query, hasQuery := strings.Cut(req.URL, "?")
+	for _, a := range s.Aliases {
+		if a.Path != urlPath {
+			continue
+		}
+		if a.Target != "" {
+			s.requestLogger(req).Debugf("Serving %v as %v", urlPath, a.Target)
+			req.URL = a.Target
+			if hasQuery {
+				req.URL += "?" + query
+			}
+			return nil
+		}
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = MIMETypeByExtension(path.Ext(a.Path))
+		}
+		if contentType == "" {
+			contentType = DetectContentType([]byte(a.Content))
+		}
+		res := NewResponse(statusOK)
+		res.Header["Content-Type"] = contentType
+		res.Body = []byte(a.Content)
+		return res
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package tritonhttp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServerAliases(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "static", "img"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "static", "img", "favicon.ico"), []byte("icon"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := startTestServer(t, &Server{
+		DocRoot: root,
+		Aliases: []Alias{
+			{Path: "/favicon.ico", Target: "/static/img/favicon.ico"},
+			{Path: "/robots.txt", Content: "User-agent: *\nDisallow: /private/\n"},
+			{Path: "/health", Content: "ok", ContentType: "text/plain"},
+			{Path: "/old.ico", Target: "/favicon.ico"},
+		},
+		Rewrites: []Rewrite{{Prefix: "/v1/robots.txt", Replacement: "/robots.txt"}},
+	})
+
+	var tests = []struct {
+		name            string
+		url             string
+		wantStatus      int
+		wantBody        string
+		wantContentType string
+	}{
+		{"target", "/favicon.ico", 200, "icon", "image/vnd.microsoft.icon"},
+		{"target with query", "/favicon.ico?v=2", 200, "icon", "image/vnd.microsoft.icon"},
+		{"content", "/robots.txt", 200, "User-agent: *\nDisallow: /private/\n", "text/plain; charset=utf-8"},
+		{"content type", "/health", 200, "ok", "text/plain"},
+		{"after rewrites", "/v1/robots.txt", 200, "User-agent: *\nDisallow: /private/\n", "text/plain; charset=utf-8"},
+		{"not chained", "/old.ico", 404, "", ""},
+		{"exact", "/robots.txt/x", 404, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := exchangeRaw(t, addr, "GET "+tt.url+" HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 1)[0]
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+			body, _ := io.ReadAll(res.BodyReader)
+			if string(body) != tt.wantBody {
+				t.Errorf("got body %q, want %q", body, tt.wantBody)
+			}
+			if got := res.Header["Content-Type"]; got != tt.wantContentType {
+				t.Errorf("got Content-Type %q, want %q", got, tt.wantContentType)
+			}
+		})
+	}
+}
@@ -0,0 +1,494 @@
+package tritonhttp
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveKind returns the kind of archive the file name is by its
+// extension, "zip" or "tar.gz", or "" if none.
+func archiveKind(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	}
+	return ""
+}
+
+// ArchiveFS is a read-only fs.FS of the files of a .zip or .tar.gz
+// archive, e.g. the release of a site served as is as the doc root.
+//
+// The files of a zip are indexed from its central directory and read
+// from the archive as they are served: those stored uncompressed seek
+// in place, so that ranges of them are cheap, while seeking in deflated
+// ones reads them up to the offset. A tar.gz cannot be read at random,
+// so its files are decompressed in memory once opened.
+type ArchiveFS struct {
+	root *archiveEntry
+	file *os.File // the zip files are read from
+}
+
+// archiveEntry is a file or directory of an ArchiveFS; it is its own
+// fs.FileInfo and fs.DirEntry.
+type archiveEntry struct {
+	name     string
+	dir      bool
+	size     int64
+	modTime  time.Time
+	children []*archiveEntry // of a directory, sorted by name
+
+	data   []byte    // of a tar.gz file
+	zf     *zip.File // of a zip file
+	offset int64     // of the data of a stored zip file, or -1
+}
+
+func (e *archiveEntry) Name() string       { return e.name }
+func (e *archiveEntry) Size() int64        { return e.size }
+func (e *archiveEntry) ModTime() time.Time { return e.modTime }
+func (e *archiveEntry) IsDir() bool        { return e.dir }
+func (e *archiveEntry) Sys() interface{}   { return nil }
+
+func (e *archiveEntry) Mode() fs.FileMode {
+	if e.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+func (e *archiveEntry) Type() fs.FileMode          { return e.Mode().Type() }
+func (e *archiveEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+// OpenArchive opens the .zip, .tar.gz or .tgz archive name, indexing
+// its files.
+func OpenArchive(name string) (*ArchiveFS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	a := &ArchiveFS{root: &archiveEntry{name: ".", dir: true, modTime: fi.ModTime()}}
+	switch archiveKind(name) {
+	case "zip":
+		err = a.indexZip(f, fi.Size())
+		a.file = f
+	case "tar.gz":
+		err = a.readTarGz(f)
+		f.Close()
+	default:
+		f.Close()
+		return nil, fmt.Errorf("%v is not a .zip, .tar.gz or .tgz archive", name)
+	}
+	if err != nil {
+		if a.file != nil {
+			a.file.Close()
+		}
+		return nil, fmt.Errorf("%v: %w", name, err)
+	}
+	a.root.sort()
+	return a, nil
+}
+
+// indexZip adds the files of the central directory of the zip archive
+// f, of size bytes, to a.
+func (a *ArchiveFS) indexZip(f *os.File, size int64) error {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		modTime := zf.Modified
+		if modTime.IsZero() {
+			modTime = a.root.modTime
+		}
+		if strings.HasSuffix(zf.Name, "/") || zf.Mode().IsDir() {
+			a.dir(zf.Name, modTime)
+			continue
+		}
+		if !zf.Mode().IsRegular() || zf.Method != zip.Store && zf.Method != zip.Deflate {
+			// Links and unsupported compression methods are left out
+			continue
+		}
+		e := a.add(zf.Name, modTime)
+		if e == nil {
+			continue
+		}
+		e.size, e.zf, e.offset = int64(zf.UncompressedSize64), zf, -1
+		if zf.Method == zip.Store {
+			if e.offset, err = zf.DataOffset(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readTarGz adds the files of the tar.gz archive read from r to a.
+func (a *ArchiveFS) readTarGz(r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			a.dir(hdr.Name, hdr.ModTime)
+		case tar.TypeReg:
+			e := a.add(hdr.Name, hdr.ModTime)
+			if e == nil {
+				continue
+			}
+			if e.data, err = io.ReadAll(tr); err != nil {
+				return err
+			}
+			e.size = int64(len(e.data))
+		}
+	}
+}
+
+// archivePath returns the name in an fs.FS of the file name of an
+// archive, e.g. "./docs/index.html", or false if it is outside of it.
+func archivePath(name string) (string, bool) {
+	name = path.Clean(strings.TrimPrefix(strings.TrimSuffix(name, "/"), "./"))
+	return name, name != "." && fs.ValidPath(name)
+}
+
+// lookup returns the entry of name, a valid path, or nil if none.
+func (a *ArchiveFS) lookup(name string) *archiveEntry {
+	e := a.root
+	if name == "." {
+		return e
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if !e.dir {
+			return nil
+		}
+		i := sort.Search(len(e.children), func(i int) bool { return e.children[i].name >= elem })
+		if i == len(e.children) || e.children[i].name != elem {
+			return nil
+		}
+		e = e.children[i]
+	}
+	return e
+}
+
+// dir adds the directory name, and its parents, to a, returning it, or
+// nil if name is invalid.
+func (a *ArchiveFS) dir(name string, modTime time.Time) *archiveEntry {
+	name, ok := archivePath(name)
+	if !ok {
+		return nil
+	}
+	e := a.root
+	for _, elem := range strings.Split(name, "/") {
+		var child *archiveEntry
+		for _, c := range e.children {
+			if c.name == elem {
+				child = c
+				break
+			}
+		}
+		if child == nil {
+			child = &archiveEntry{name: elem, dir: true, modTime: modTime}
+			e.children = append(e.children, child)
+		} else if !child.dir {
+			return nil
+		}
+		e = child
+	}
+	return e
+}
+
+// add adds the file name, and its parent directories, to a, returning
+// it, or nil if name is invalid or taken by a directory. A file added
+// twice is the last one added, as when extracting the archive.
+func (a *ArchiveFS) add(name string, modTime time.Time) *archiveEntry {
+	name, ok := archivePath(name)
+	if !ok {
+		return nil
+	}
+	parent := a.root
+	if dir, _ := path.Split(name); dir != "" {
+		if parent = a.dir(dir, modTime); parent == nil {
+			return nil
+		}
+	}
+	e := &archiveEntry{name: path.Base(name), modTime: modTime}
+	for i, c := range parent.children {
+		if c.name == e.name {
+			if c.dir {
+				return nil
+			}
+			parent.children[i] = e
+			return e
+		}
+	}
+	parent.children = append(parent.children, e)
+	return e
+}
+
+// sort sorts the children of e and its subdirectories by name.
+func (e *archiveEntry) sort() {
+	sort.Slice(e.children, func(i, j int) bool { return e.children[i].name < e.children[j].name })
+	for _, c := range e.children {
+		if c.dir {
+			c.sort()
+		}
+	}
+}
+
+func (a *ArchiveFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	e := a.lookup(name)
+	if e == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if e.dir {
+		return &archiveDir{e: e}, nil
+	}
+	f := &archiveFile{e: e}
+	switch {
+	case e.data != nil:
+		f.r = bytes.NewReader(e.data)
+	case e.offset >= 0:
+		f.r = io.NewSectionReader(a.file, e.offset, e.size)
+	}
+	return f, nil
+}
+
+func (a *ArchiveFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if e := a.lookup(name); e != nil {
+		return e, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (a *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	e := a.lookup(name)
+	if e == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	if !e.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries := make([]fs.DirEntry, len(e.children))
+	for i, c := range e.children {
+		entries[i] = c
+	}
+	return entries, nil
+}
+
+// Close closes the archive. The files open remain readable only if it
+// is a tar.gz.
+func (a *ArchiveFS) Close() error {
+	if a.file != nil {
+		return a.file.Close()
+	}
+	return nil
+}
+
+// archiveFile is an open file of an ArchiveFS.
+type archiveFile struct {
+	e *archiveEntry
+	r io.ReadSeeker // of a file read in place, or nil if deflated
+
+	// The decompressor of a deflated file, at pos, and where the next
+	// Read reads from
+	rc  io.ReadCloser
+	pos int64
+	off int64
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return f.e, nil }
+
+func (f *archiveFile) Read(p []byte) (int, error) {
+	if f.r != nil {
+		return f.r.Read(p)
+	}
+	if f.off >= f.e.size {
+		return 0, io.EOF
+	}
+	if f.rc == nil || f.off < f.pos {
+		// Going back means starting over
+		if f.rc != nil {
+			f.rc.Close()
+		}
+		rc, err := f.e.zf.Open()
+		if err != nil {
+			return 0, err
+		}
+		f.rc, f.pos = rc, 0
+	}
+	if f.off > f.pos {
+		n, err := io.CopyN(io.Discard, f.rc, f.off-f.pos)
+		f.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.rc.Read(p)
+	f.pos += int64(n)
+	f.off = f.pos
+	return n, err
+}
+
+func (f *archiveFile) Seek(offset int64, whence int) (int64, error) {
+	if f.r != nil {
+		return f.r.Seek(offset, whence)
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.e.size
+	case io.SeekStart:
+	default:
+		return 0, errors.New("Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("Seek: negative position")
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *archiveFile) Close() error {
+	if f.rc != nil {
+		return f.rc.Close()
+	}
+	return nil
+}
+
+// gzipEncoded returns the file as a gzip stream, along with its length,
+// if it is deflated in a zip archive: its compressed data as is, framed
+// by the gzip header and trailer, the latter from the CRC-32 and size
+// the archive records.
+func (f *archiveFile) gzipEncoded() (io.Reader, int64, bool) {
+	zf := f.e.zf
+	if zf == nil || zf.Method != zip.Deflate || zf.UncompressedSize64 > 1<<32-1 {
+		return nil, 0, false
+	}
+	raw, err := zf.OpenRaw()
+	if err != nil {
+		return nil, 0, false
+	}
+	// Deflate, no flags, no modification time, unknown OS
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	trailer := binary.LittleEndian.AppendUint32(nil, zf.CRC32)
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(zf.UncompressedSize64))
+	n := int64(len(header)) + int64(zf.CompressedSize64) + int64(len(trailer))
+	return io.MultiReader(bytes.NewReader(header), raw, bytes.NewReader(trailer)), n, true
+}
+
+// archiveDir is an open directory of an ArchiveFS.
+type archiveDir struct {
+	e    *archiveEntry
+	read int // the entries ReadDir returned
+}
+
+func (d *archiveDir) Stat() (fs.FileInfo, error) { return d.e, nil }
+
+func (d *archiveDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.e.name, Err: errors.New("is a directory")}
+}
+
+func (d *archiveDir) Close() error { return nil }
+
+func (d *archiveDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.e.children[d.read:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.read += len(rest)
+	entries := make([]fs.DirEntry, len(rest))
+	for i, c := range rest {
+		entries[i] = c
+	}
+	return entries, nil
+}
+
+// gzipFile is a file served gzip-encoded: its Read reads the encoding.
+type gzipFile struct {
+	fs.File
+	r io.Reader
+}
+
+func (f gzipFile) Read(p []byte) (int, error) { return f.r.Read(p) }
+
+// serveArchiveGzip makes res, serving f for req, send f gzip-encoded as
+// the archive it is in holds it, if ArchiveGzip is set and req accepts
+// it. It reports whether it does.
+func (s *Server) serveArchiveGzip(res *Response, req *Request, f fs.File) bool {
+	af, ok := f.(*archiveFile)
+	if !s.ArchiveGzip || !ok {
+		return false
+	}
+	body, n, ok := af.gzipEncoded()
+	if !ok {
+		return false
+	}
+	if vary := res.Header["Vary"]; vary != "" {
+		res.Header["Vary"] = vary + ", Accept-Encoding"
+	} else {
+		res.Header["Vary"] = "Accept-Encoding"
+	}
+	if acceptQ(parseAccept(req.Header["Accept-Encoding"]), "gzip", matchEncoding) == 0 {
+		return false
+	}
+	res.file = gzipFile{File: f, r: body}
+	res.Header["Content-Encoding"] = "gzip"
+	if etag := res.Header["Etag"]; etag != "" {
+		res.Header["Etag"] = encodedETag(etag, "gzip")
+	}
+	res.Header["Content-Length"] = fmt.Sprint(n)
+	return true
+}
+
+// matchEncoding returns how specifically the coding rng of an
+// Accept-Encoding header matches the coding value: 2 if it names it, 1
+// for "*", and 0 if not at all.
+func matchEncoding(rng, value string) int {
+	switch {
+	case rng == value, value == "gzip" && rng == "x-gzip":
+		return 2
+	case rng == "*":
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,193 @@
+package tritonhttp
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var archiveFiles = map[string]string{
+	"index.html":   "<h1>home</h1>",
+	"css/site.css": strings.Repeat("body { margin: 0 }\n", 100),
+	"docs/a/b.txt": "deep",
+}
+
+// writeTestZip writes to name a zip of archiveFiles, index.html stored
+// and the others deflated, along with entries to be left out.
+func writeTestZip(t *testing.T, name string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	mod := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if _, err := zw.CreateHeader(&zip.FileHeader{Name: "docs/", Modified: mod}); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []string{"index.html", "css/site.css", "docs/a/b.txt", "../evil.txt", "/abs.txt"} {
+		method := zip.Deflate
+		if n == "index.html" {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: n, Method: method, Modified: mod})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, archiveFiles[n])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestTarGz writes to name a tar.gz of archiveFiles.
+func writeTestTarGz(t *testing.T, name string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, n := range []string{"./index.html", "./css/site.css", "./docs/a/b.txt"} {
+		body := archiveFiles[strings.TrimPrefix(n, "./")]
+		tw.WriteHeader(&tar.Header{Name: n, Mode: 0o644, Size: int64(len(body)), ModTime: time.Now()})
+		io.WriteString(tw, body)
+	}
+	tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+	tw.Close()
+	zw.Close()
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveFS(t *testing.T) {
+	dir := t.TempDir()
+	zipName, tgzName := filepath.Join(dir, "site.zip"), filepath.Join(dir, "site.tgz")
+	writeTestZip(t, zipName)
+	writeTestTarGz(t, tgzName)
+	for _, name := range []string{zipName, tgzName} {
+		t.Run(filepath.Ext(name), func(t *testing.T) {
+			a, err := OpenArchive(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer a.Close()
+			if err := fstest.TestFS(a, "index.html", "css/site.css", "docs/a/b.txt"); err != nil {
+				t.Fatal(err)
+			}
+			for _, missing := range []string{"evil.txt", "abs.txt", "link", "docs/a/b.txt/x"} {
+				if _, err := a.Open(missing); err == nil {
+					t.Errorf("Open(%q) got no error", missing)
+				}
+			}
+
+			// Seeking back and forth, as serving ranges does
+			f, _ := a.Open("css/site.css")
+			defer f.Close()
+			rs := f.(io.ReadSeeker)
+			want := archiveFiles["css/site.css"]
+			for _, off := range []int64{1000, 20, 1500, 0} {
+				if _, err := rs.Seek(off, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				p := make([]byte, 10)
+				if _, err := io.ReadFull(rs, p); err != nil || string(p) != want[off:off+10] {
+					t.Fatalf("read at %v got %q, %v, want %q", off, p, err, want[off:off+10])
+				}
+			}
+		})
+	}
+	if _, err := OpenArchive(filepath.Join(dir, "site.rar")); err == nil {
+		t.Error("OpenArchive of a .rar got no error")
+	}
+}
+
+func TestArchiveDocRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "site.zip")
+	writeTestZip(t, root)
+	s := &Server{DocRoot: root, ArchiveGzip: true, ErrorLog: NewLogger(nil, LevelError)}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("Validate got %v", err)
+	}
+	if err := s.resolveDocRoot(); err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := startTestServer(t, s)
+
+	var tests = []struct {
+		name         string
+		url          string
+		encoding     string
+		wantStatus   int
+		wantEncoding string
+	}{
+		{"Stored", "/", "gzip", 200, ""},
+		{"Deflated", "/css/site.css", "", 200, ""},
+		{"Passthrough", "/css/site.css", "br, gzip;q=0.5", 200, "gzip"},
+		{"Refused", "/css/site.css", "gzip;q=0", 200, ""},
+		{"Nested", "/docs/a/b.txt", "", 200, ""},
+		{"Missing", "/nope.html", "", 404, ""},
+		{"Outside", "/evil.txt", "", 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "GET " + tt.url + " HTTP/1.1\r\nHost: test\r\nConnection: close\r\n"
+			if tt.encoding != "" {
+				raw += "Accept-Encoding: " + tt.encoding + "\r\n"
+			}
+			res := exchangeRaw(t, addr, raw+"\r\n", 1)[0]
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+			if got := res.Header["Content-Encoding"]; got != tt.wantEncoding {
+				t.Fatalf("got Content-Encoding %q, want %q", got, tt.wantEncoding)
+			}
+			if etag := res.Header["Etag"]; tt.wantEncoding == "gzip" && !strings.HasSuffix(etag, `-gzip"`) {
+				t.Errorf("got ETag %q of the gzip encoding", etag)
+			}
+			var body io.Reader = res.BodyReader
+			if tt.wantEncoding == "gzip" {
+				zr, err := gzip.NewReader(body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = zr
+			}
+			got, err := io.ReadAll(body)
+			want := archiveFiles[strings.TrimPrefix(tt.url, "/")]
+			if tt.url == "/" {
+				want = archiveFiles["index.html"]
+			}
+			if err != nil || string(got) != want {
+				t.Errorf("got body %.20q, %v, want %.20q", got, err, want)
+			}
+			if strings.HasPrefix(tt.url, "/css") && res.Header["Vary"] != "Accept-Encoding" {
+				t.Errorf("got Vary %q, want Accept-Encoding", res.Header["Vary"])
+			}
+		})
+	}
+
+	// Replacing the archive and reloading deploys it
+	tgz := filepath.Join(dir, "next.tar.gz")
+	writeTestTarGz(t, tgz)
+	s.DocRoot = tgz
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	res := exchangeRaw(t, addr, "GET /docs/a/b.txt HTTP/1.1\r\nHost: test\r\nAccept-Encoding: gzip\r\nConnection: close\r\n\r\n", 1)[0]
+	if body, _ := io.ReadAll(res.BodyReader); res.StatusCode != 200 || string(body) != "deep" || res.Header["Content-Encoding"] != "" {
+		t.Errorf("after reload got %v %q, Content-Encoding %q", res.StatusCode, body, res.Header["Content-Encoding"])
+	}
+}
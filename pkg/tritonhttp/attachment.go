@@ -0,0 +1,69 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Attachment makes res be saved by browsers as a file named filename
+// rather than displayed, with a Content-Disposition header giving the
+// name both as is, percent-encoded as RFC 5987 says, and as an ASCII
+// fallback for older clients.
+func (res *Response) Attachment(filename string) {
+	res.setHeader("Content-Disposition", contentDisposition("attachment", filename))
+}
+
+// contentDisposition returns the Content-Disposition header value of
+// disposition, e.g. "attachment", for filename.
+func contentDisposition(disposition, filename string) string {
+	var fallback strings.Builder
+	ascii := true
+	for _, r := range filename {
+		switch {
+		case r == '"' || r == '\\' || r < ' ' || r == 0x7f:
+			fallback.WriteByte('_')
+		case r > 0x7f:
+			fallback.WriteByte('_')
+			ascii = false
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+	v := fmt.Sprintf("%v; filename=\"%v\"", disposition, fallback.String())
+	if !ascii {
+		v += "; filename*=UTF-8''" + rfc5987Escape(filename)
+	}
+	return v
+}
+
+// rfc5987Escape percent-encodes s but for the attr-char of RFC 5987.
+func rfc5987Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// attachment reports whether the file req asks for is to be served as
+// an attachment: it is under one of the AttachmentPrefixes of s, or
+// AttachmentQuery is set and req has a "download=1" query parameter.
+func (s *Server) attachment(req *Request) bool {
+	urlPath, query, _ := strings.Cut(req.URL, "?")
+	for _, prefix := range s.AttachmentPrefixes {
+		if prefixMatches(prefix, urlPath) {
+			return true
+		}
+	}
+	if !s.AttachmentQuery {
+		return false
+	}
+	values, err := url.ParseQuery(query)
+	return err == nil && values.Get("download") == "1"
+}
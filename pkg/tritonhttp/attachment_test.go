@@ -0,0 +1,61 @@
+package tritonhttp
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestContentDisposition(t *testing.T) {
+	var tests = []struct {
+		filename string
+		want     string
+	}{
+		{"report.pdf", `attachment; filename="report.pdf"`},
+		{"my report.pdf", `attachment; filename="my report.pdf"`},
+		{`a"b\c.txt`, `attachment; filename="a_b_c.txt"`},
+		{"café.txt", `attachment; filename="caf_.txt"; filename*=UTF-8''caf%C3%A9.txt`},
+		{"你好 (1).txt", `attachment; filename="__ (1).txt"; filename*=UTF-8''%E4%BD%A0%E5%A5%BD%20%281%29.txt`},
+	}
+	for _, tt := range tests {
+		if got := contentDisposition("attachment", tt.filename); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestServerAttachments(t *testing.T) {
+	root := filepath.FromSlash("/srv/www")
+	fsys := MountFS(fstest.MapFS{
+		"index.html":       {Data: []byte("home")},
+		"files/a.pdf":      {Data: []byte("pdf")},
+		"files/résumé.txt": {Data: []byte("cv")},
+	}, root)
+	var tests = []struct {
+		name  string
+		query bool
+		url   string
+		want  string
+	}{
+		{"not under prefix", false, "/index.html", ""},
+		{"under prefix", false, "/files/a.pdf", `attachment; filename="a.pdf"`},
+		{"UTF-8 name", false, "/files/r%C3%A9sum%C3%A9.txt", `attachment; filename="r_sum_.txt"; filename*=UTF-8''r%C3%A9sum%C3%A9.txt`},
+		{"query ignored", false, "/index.html?download=1", ""},
+		{"query", true, "/index.html?download=1", `attachment; filename="index.html"`},
+		{"index query", true, "/?download=1", `attachment; filename="index.html"`},
+		{"other query", true, "/index.html?download=0", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{DocRoot: root, FS: fsys, AttachmentPrefixes: []string{"/files/"}, AttachmentQuery: tt.query, ErrorLog: NewLogger(nil, LevelError)}
+			res := s.HandleGoodRequest(&Request{Method: "GET", URL: tt.url, Proto: "HTTP/1.1", Header: map[string]string{}, Host: "test"})
+			defer res.Close()
+			if res.StatusCode != 200 {
+				t.Fatalf("got status %v, want 200", res.StatusCode)
+			}
+			if got := res.Header["Content-Disposition"]; got != tt.want {
+				t.Errorf("got Content-Disposition %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package tritonhttp
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// BanPolicy configures temporary bans for abusive clients.
+// A client IP that commits Threshold protocol violations or trips of
+// its own limits, e.g. MaxConnsPerIP, within Window is banned for
+// Duration: its connections are dropped as soon as they are accepted.
+// A zero Threshold disables banning.
+type BanPolicy struct {
+	Threshold int
+	Window    time.Duration
+	Duration  time.Duration
+}
+
+// banList records recent strikes and active bans per client IP.
+type banList struct {
+	mu      sync.Mutex
+	strikes map[string][]time.Time
+	banned  map[string]time.Time // IP -> ban expiry
+	swept   time.Time            // when expired entries were last pruned
+}
+
+// strike records a violation by ip at now and reports whether
+// it resulted in a new ban.
+func (b *banList) strike(ip string, p BanPolicy, now time.Time) bool {
+	if p.Threshold <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.strikes == nil {
+		b.strikes = make(map[string][]time.Time)
+		b.banned = make(map[string]time.Time)
+	}
+	if now.Sub(b.swept) >= p.Window {
+		b.sweep(now, p.Window)
+	}
+
+	// Only keep the strikes still inside the window
+	recent := b.strikes[ip][:0]
+	for _, t := range b.strikes[ip] {
+		if now.Sub(t) < p.Window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) < p.Threshold {
+		b.strikes[ip] = recent
+		return false
+	}
+	delete(b.strikes, ip)
+	b.banned[ip] = now.Add(p.Duration)
+	return true
+}
+
+// sweep forgets the clients whose strikes are all older than window
+// at now, so that those never coming back do not pile up, and the
+// expired bans. The caller must hold b.mu.
+func (b *banList) sweep(now time.Time, window time.Duration) {
+	for ip, times := range b.strikes {
+		if now.Sub(times[len(times)-1]) >= window {
+			delete(b.strikes, ip)
+		}
+	}
+	for ip, expiry := range b.banned {
+		if !now.Before(expiry) {
+			delete(b.banned, ip)
+		}
+	}
+	b.swept = now
+}
+
+// isBanned reports whether ip is banned at now, forgetting expired bans.
+func (b *banList) isBanned(ip string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	expiry, ok := b.banned[ip]
+	if !ok {
+		return false
+	}
+	if !now.Before(expiry) {
+		delete(b.banned, ip)
+		return false
+	}
+	return true
+}
+
+// snapshot returns the active bans at now, keyed by IP.
+func (b *banList) snapshot(now time.Time) map[string]time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bans := make(map[string]time.Time, len(b.banned))
+	for ip, expiry := range b.banned {
+		if now.Before(expiry) {
+			bans[ip] = expiry
+		} else {
+			delete(b.banned, ip)
+		}
+	}
+	return bans
+}
+
+// clear lifts the ban and forgets the strikes of ip,
+// or of every client if ip is "".
+func (b *banList) clear(ip string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ip == "" {
+		b.strikes = nil
+		b.banned = nil
+		return
+	}
+	delete(b.strikes, ip)
+	delete(b.banned, ip)
+}
+
+// Bans returns the currently banned client IPs mapped to
+// the time their ban expires.
+func (s *Server) Bans() map[string]time.Time {
+	return s.bans.snapshot(time.Now())
+}
+
+// IsBanned reports whether the client IP ip is currently banned.
+func (s *Server) IsBanned(ip string) bool {
+	return s.bans.isBanned(ip, time.Now())
+}
+
+// Unban lifts the ban on ip and forgets its past violations.
+func (s *Server) Unban(ip string) {
+	s.bans.clear(ip)
+}
+
+// ClearBans lifts all bans and forgets all past violations.
+func (s *Server) ClearBans() {
+	s.bans.clear("")
+}
+
+// strike records a protocol violation or limit trip by the client at
+// addr. Only what the client is to blame for counts, not e.g. the
+// server being at its connection limit.
+func (s *Server) strike(addr net.Addr) {
+	ip := hostOf(addr)
+	bp := s.current().BanPolicy
+	if s.bans.strike(ip, bp, time.Now()) {
+		s.logger().Warnf("Banning %v until %v", ip, time.Now().Add(bp.Duration))
+	}
+}
@@ -0,0 +1,126 @@
+package tritonhttp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	p := BanPolicy{Threshold: 3, Window: time.Minute, Duration: time.Hour}
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	var b banList
+
+	// Strikes spread wider than the window never add up to a ban
+	for i := 0; i < 3; i++ {
+		if b.strike("1.2.3.4", p, start.Add(time.Duration(i)*2*time.Minute)) {
+			t.Fatalf("banned after strike %v outside the window", i)
+		}
+	}
+
+	// Strikes inside the window do
+	now := start.Add(time.Hour)
+	b.strike("1.2.3.4", p, now)
+	b.strike("1.2.3.4", p, now.Add(time.Second))
+	if !b.strike("1.2.3.4", p, now.Add(2*time.Second)) {
+		t.Fatal("not banned after 3 strikes in the window")
+	}
+	if !b.isBanned("1.2.3.4", now.Add(time.Minute)) {
+		t.Fatal("ban not active")
+	}
+	if b.isBanned("5.6.7.8", now) {
+		t.Fatal("unrelated client banned")
+	}
+	if got := len(b.snapshot(now)); got != 1 {
+		t.Fatalf("snapshot got %v bans, want 1", got)
+	}
+
+	// Bans decay
+	if b.isBanned("1.2.3.4", now.Add(2*time.Hour)) {
+		t.Fatal("ban did not expire")
+	}
+
+	// And can be lifted explicitly
+	for i := 0; i < 3; i++ {
+		b.strike("1.2.3.4", p, now)
+	}
+	b.clear("1.2.3.4")
+	if b.isBanned("1.2.3.4", now) {
+		t.Fatal("ban not cleared")
+	}
+}
+
+func TestBanListSweep(t *testing.T) {
+	p := BanPolicy{Threshold: 3, Window: time.Minute, Duration: time.Hour}
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	var b banList
+
+	// Clients striking once and never coming back are forgotten once
+	// their strikes leave the window
+	for i := 0; i < 100; i++ {
+		b.strike(net.IPv4(10, 0, 0, byte(i)).String(), p, now)
+	}
+	b.strike("1.2.3.4", p, now.Add(p.Window))
+	if len(b.strikes) != 1 {
+		t.Fatalf("got strikes of %v clients, want 1", len(b.strikes))
+	}
+	if b.strike("1.2.3.4", p, now.Add(p.Window+time.Second)) || len(b.strikes["1.2.3.4"]) != 2 {
+		t.Fatalf("strikes in the window got %v, want 2", b.strikes["1.2.3.4"])
+	}
+}
+
+// addrConn is a net.Conn from addr, only good for its RemoteAddr.
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.addr }
+
+func TestAdmitStrikes(t *testing.T) {
+	s := &Server{
+		Limits:    Limits{MaxConns: 2, MaxConnsPerIP: 1},
+		BanPolicy: BanPolicy{Threshold: 1, Window: time.Minute, Duration: time.Hour},
+		ErrorLog:  NewLogger(nil, LevelError),
+	}
+	conn := func(ip string) net.Conn {
+		return addrConn{addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}}
+	}
+	if !s.admit(conn("10.0.0.1")) || !s.admit(conn("10.0.0.2")) {
+		t.Fatal("connections under the limits not admitted")
+	}
+
+	// The server being full is not the fault of the client turned away
+	if s.admit(conn("10.0.0.3")) {
+		t.Fatal("connection over MaxConns admitted")
+	}
+	if s.IsBanned("10.0.0.3") {
+		t.Error("client turned away for MaxConns banned")
+	}
+
+	// A client over its own limit is
+	if s.admit(conn("10.0.0.1")) {
+		t.Fatal("connection over MaxConnsPerIP admitted")
+	}
+	if !s.IsBanned("10.0.0.1") {
+		t.Error("client over MaxConnsPerIP not banned")
+	}
+}
+
+func TestUnsupportedVersionNoStrike(t *testing.T) {
+	s := &Server{
+		DocRoot:   t.TempDir(),
+		BanPolicy: BanPolicy{Threshold: 2, Window: time.Minute, Duration: time.Hour},
+		ErrorLog:  NewLogger(nil, LevelError),
+	}
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+	for i := 0; i < 3; i++ {
+		if res := exchangeRaw(t, addr, "GET / HTTP/1.0\r\n\r\n", 1)[0]; res.StatusCode != 505 {
+			t.Fatalf("got %v, want 505", res.StatusCode)
+		}
+	}
+	if s.IsBanned("127.0.0.1") {
+		t.Error("client banned for requests in HTTP/1.0")
+	}
+}
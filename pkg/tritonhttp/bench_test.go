@@ -0,0 +1,143 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchConn is a connection reading the requests of a benchmark from
+// memory and discarding the responses, without the timers and
+// goroutines of a real one getting in the measures.
+type benchConn struct {
+	r *bytes.Reader
+}
+
+var benchAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4321}
+
+func (c *benchConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c *benchConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *benchConn) Close() error                       { return nil }
+func (c *benchConn) LocalAddr() net.Addr                { return benchAddr }
+func (c *benchConn) RemoteAddr() net.Addr               { return benchAddr }
+func (c *benchConn) SetDeadline(t time.Time) error      { return nil }
+func (c *benchConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *benchConn) SetWriteDeadline(t time.Time) error { return nil }
+
+var budgetTimes = flag.Bool("budget.times", false, "fail TestBenchmarkBudgets on ops over their time ceiling, rather than log them")
+
+// keepAliveRequests is how many requests the keep-alive benchmark
+// sends on a connection.
+const keepAliveRequests = 16
+
+// benchmarks are the operations benchmarked, each with the budget
+// TestBenchmarkBudgets holds it to: the allocations are those measured
+// when it was last tuned, to be raised only knowingly, and the time is
+// a loose ceiling, about ten times that of a laptop, only enforced with
+// -budget.times, as make bench does, so that a slow or busy machine
+// does not fail plain go test.
+var benchmarks = []struct {
+	name    string
+	setup   func(tb testing.TB) func()
+	allocs  float64
+	maxTime time.Duration
+}{
+	{"ReadRequest", setupReadRequest, 2, 20 * time.Microsecond},
+	{"SmallFile", setupServeFile("small.html", 1<<10, 1), 58, 300 * time.Microsecond},
+	{"LargeFile", setupServeFile("large.bin", 4<<20, 1), 58, 5 * time.Millisecond},
+	{"KeepAlive", setupServeFile("small.html", 1<<10, keepAliveRequests), 733, 2 * time.Millisecond},
+}
+
+// setupReadRequest returns the parsing of a typical browser request.
+func setupReadRequest(tb testing.TB) func() {
+	raw := []byte("GET /index.html HTTP/1.1\r\nHost: test\r\nUser-Agent: x/1\r\nAccept: */*\r\n" +
+		"Accept-Encoding: gzip\r\nAccept-Language: en\r\nConnection: keep-alive\r\n\r\n")
+	r := bytes.NewReader(raw)
+	br := bufio.NewReader(r)
+	lim := DefaultLimits()
+	return func() {
+		r.Reset(raw)
+		br.Reset(r)
+		req, _, err := readRequest(br, lim)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		releaseRequest(req)
+	}
+}
+
+// setupServeFile returns the serving of a connection asking for a file
+// of size bytes n times, keeping it alive in between.
+func setupServeFile(name string, size, n int) func(tb testing.TB) func() {
+	return func(tb testing.TB) func() {
+		dir := tb.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte("a"), size), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+		s := &Server{DocRoot: dir, ErrorLog: NewLogger(nil, LevelError)}
+		req := "GET /" + name + " HTTP/1.1\r\nHost: test\r\nUser-Agent: x/1\r\nAccept: */*\r\n\r\n"
+		last := "GET /" + name + " HTTP/1.1\r\nHost: test\r\nUser-Agent: x/1\r\nAccept: */*\r\nConnection: close\r\n\r\n"
+		raw := []byte(strings.Repeat(req, n-1) + last)
+		conn := &benchConn{r: bytes.NewReader(raw)}
+		return func() {
+			conn.r.Reset(raw)
+			s.HandleConnection(conn)
+		}
+	}
+}
+
+func runBenchmark(b *testing.B, name string) {
+	for _, bm := range benchmarks {
+		if bm.name == name {
+			op := bm.setup(b)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				op()
+			}
+			return
+		}
+	}
+	b.Fatalf("no benchmark %v", name)
+}
+
+func BenchmarkReadRequest(b *testing.B) { runBenchmark(b, "ReadRequest") }
+func BenchmarkSmallFile(b *testing.B)   { runBenchmark(b, "SmallFile") }
+func BenchmarkLargeFile(b *testing.B)   { runBenchmark(b, "LargeFile") }
+func BenchmarkKeepAlive(b *testing.B)   { runBenchmark(b, "KeepAlive") }
+
+func TestBenchmarkBudgets(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not counted, nor times comparable, under the race detector")
+	}
+	for _, bm := range benchmarks {
+		t.Run(bm.name, func(t *testing.T) {
+			op := bm.setup(t)
+			op()
+			if allocs := testing.AllocsPerRun(50, op); allocs > bm.allocs {
+				t.Errorf("got %v allocations per op, want at most %v", allocs, bm.allocs)
+			}
+			if testing.Short() {
+				return
+			}
+			const runs = 20
+			start := time.Now()
+			for i := 0; i < runs; i++ {
+				op()
+			}
+			if took := time.Since(start) / runs; took > bm.maxTime {
+				if *budgetTimes {
+					t.Errorf("took %v per op, want at most %v", took, bm.maxTime)
+				} else {
+					t.Logf("took %v per op, over the ceiling of %v", took, bm.maxTime)
+				}
+			}
+		})
+	}
+}
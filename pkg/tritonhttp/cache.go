@@ -0,0 +1,382 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheMaxBytes      = 64 << 20
+	defaultCacheMaxEntryBytes = 1 << 20
+)
+
+// cacheableStatus are the status codes whose responses a Cache stores.
+var cacheableStatus = map[int]bool{200: true, 203: true, 301: true, 404: true, 410: true}
+
+// Cache is a Handler caching the responses of another one, usually a
+// ReverseProxy, as a shared cache does in RFC 7234: it honors the
+// Cache-Control and Expires headers, and revalidates stale responses
+// with their ETag or Last-Modified validators. Every response it
+// relays has an X-Cache header telling how it was answered:
+//
+//   - HIT: from the cache, fresh
+//   - REVALIDATED: from the cache, after the upstream confirmed it
+//   - MISS: from the upstream, stored if cacheable
+//   - BYPASS: from the upstream, the request asking not to be cached
+//
+// Responses are kept in memory, the least recently used evicted first.
+// It is safe for concurrent use.
+type Cache struct {
+	// Handler answers the requests the cache cannot.
+	Handler Handler
+
+	// MaxBytes caps the body bytes stored in total; 0 means 64 MiB.
+	// MaxEntryBytes caps those of a single response; 0 means 1 MiB.
+	MaxBytes      int64
+	MaxEntryBytes int64
+
+	// Clock, if set, tells the time instead of time.Now, e.g. so that
+	// tests can expire responses.
+	Clock func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	lru     list.List // of *cacheEntry, most recently used first
+	size    int64
+}
+
+// cacheEntry is a stored response.
+type cacheEntry struct {
+	key    string
+	status int
+	header map[string]string
+	body   []byte
+
+	vary map[string]string // request headers named by Vary, as sent
+
+	stored     time.Time     // when the response was received
+	initialAge time.Duration // its Age when received
+	lifetime   time.Duration // how long it stays fresh
+	noCache    bool          // to revalidate before every use
+
+	elem *list.Element
+}
+
+// ServeRequest answers req from the cache if it can, from Handler
+// otherwise.
+func (c *Cache) ServeRequest(req *Request) *Response {
+	reqCC := parseCacheControl(req.Header["Cache-Control"])
+	if req.Method != "GET" || reqCC.has("no-store") || req.Header["Authorization"] != "" || req.Header["Upgrade"] != "" {
+		return withXCache(c.Handler.ServeRequest(req), "BYPASS")
+	}
+
+	key := req.Host + " " + req.URL
+	now := c.now()
+	e := c.lookup(key, req)
+	if e != nil && !e.noCache && !reqCC.has("no-cache") && e.age(now) < e.freshness(reqCC) {
+		return e.response(req, now, "HIT")
+	}
+
+	// Revalidate what is stored, if it can be
+	sent := req
+	if e != nil && (e.header["Etag"] != "" || e.header["Last-Modified"] != "") {
+		sent = e.conditional(req)
+	}
+	res := c.Handler.ServeRequest(sent)
+	received := c.now()
+	if e != nil && sent != req && res.StatusCode == 304 {
+		_ = res.Close()
+		revalidated := *e
+		revalidated.setHeader(res.Header, received)
+		c.put(&revalidated)
+		return revalidated.response(req, received, "REVALIDATED")
+	}
+	return c.store(key, req, res, received)
+}
+
+// lookup returns the entry stored under key matching the Vary headers
+// of req, or nil.
+func (c *Cache) lookup(key string, req *Request) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[key]
+	if e == nil {
+		return nil
+	}
+	for k, v := range e.vary {
+		if req.Header[k] != v {
+			return nil
+		}
+	}
+	c.lru.MoveToFront(e.elem)
+	return e
+}
+
+// store returns res to req, storing it under key first if it is
+// cacheable.
+func (c *Cache) store(key string, req *Request, res *Response, received time.Time) *Response {
+	if res.Body != nil {
+		// Store a generated body as a relayed one
+		res.BodyReader, res.Body = bytes.NewReader(res.Body), nil
+	}
+	e := c.entryFor(key, req, res, received)
+	if e == nil {
+		// What is stored, if anything, is outdated
+		c.evict(key)
+		return withXCache(res, "MISS")
+	}
+	body, err := io.ReadAll(io.LimitReader(res.BodyReader, int64(len(e.body))))
+	_ = res.Close()
+	if err != nil || len(body) != len(e.body) {
+		// The upstream failed mid-body; so will the client's response
+		res.BodyReader = bytes.NewReader(body)
+		return withXCache(res, "MISS")
+	}
+	e.body = body
+	c.put(e)
+	return e.response(req, received, "MISS")
+}
+
+// put stores e in place of any entry under its key, evicting the least
+// recently used entries beyond MaxBytes.
+func (c *Cache) put(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old := c.entries[e.key]; old != nil {
+		c.remove(old)
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]*cacheEntry)
+	}
+	e.elem = c.lru.PushFront(e)
+	c.entries[e.key] = e
+	c.size += int64(len(e.body))
+	for c.size > c.maxBytes() {
+		c.remove(c.lru.Back().Value.(*cacheEntry))
+	}
+}
+
+// evict removes the entry under key, if any.
+func (c *Cache) evict(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.entries[key]; e != nil {
+		c.remove(e)
+	}
+}
+
+// Purge removes every stored response, e.g. once the upstream they
+// came from has been replaced.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+	c.lru.Init()
+	c.size = 0
+}
+
+// entryFor returns the entry to store for res, with a body of the
+// right size yet to be read, or nil if res is not to be stored.
+func (c *Cache) entryFor(key string, req *Request, res *Response, received time.Time) *cacheEntry {
+	cc := parseCacheControl(res.Header["Cache-Control"])
+	cl, err := strconv.ParseInt(res.Header["Content-Length"], 10, 64)
+	if !cacheableStatus[res.StatusCode] || cc.has("no-store") || cc.has("private") ||
+		err != nil || cl < 0 || cl > c.maxEntryBytes() || res.Header["Vary"] == "*" {
+		return nil
+	}
+	e := &cacheEntry{
+		key:    key,
+		status: res.StatusCode,
+		body:   make([]byte, cl),
+		vary:   map[string]string{},
+	}
+	for _, k := range strings.Split(res.Header["Vary"], ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			k = CanonicalHeaderKey(k)
+			e.vary[k] = req.Header[k]
+		}
+	}
+	e.setHeader(res.Header, received)
+	if e.lifetime <= 0 && e.header["Etag"] == "" && e.header["Last-Modified"] == "" {
+		// Neither fresh nor revalidatable: of no use
+		return nil
+	}
+	return e
+}
+
+// setHeader sets the headers and freshness of e from those of a
+// response received at received, be it a full or a 304 response.
+// Entries are not changed once stored, only replaced.
+func (e *cacheEntry) setHeader(header map[string]string, received time.Time) {
+	h := make(map[string]string, len(e.header)+len(header))
+	for k, v := range e.header {
+		h[k] = v
+	}
+	for k, v := range header {
+		h[k] = v
+	}
+	delete(h, "Connection")
+	delete(h, "X-Cache")
+	e.header = h
+	e.stored = received
+	e.initialAge = 0
+	if age, err := strconv.Atoi(h["Age"]); err == nil && age > 0 {
+		e.initialAge = time.Duration(age) * time.Second
+	}
+	e.lifetime = lifetime(h, received)
+	e.noCache = parseCacheControl(h["Cache-Control"]).has("no-cache")
+}
+
+// remove evicts e. The caller must hold c.mu.
+func (c *Cache) remove(e *cacheEntry) {
+	c.lru.Remove(e.elem)
+	delete(c.entries, e.key)
+	c.size -= int64(len(e.body))
+}
+
+func (c *Cache) now() time.Time {
+	if c.Clock != nil {
+		return c.Clock()
+	}
+	return time.Now()
+}
+
+func (c *Cache) maxBytes() int64 {
+	if c.MaxBytes > 0 {
+		return c.MaxBytes
+	}
+	return defaultCacheMaxBytes
+}
+
+func (c *Cache) maxEntryBytes() int64 {
+	if c.MaxEntryBytes > 0 {
+		return c.MaxEntryBytes
+	}
+	return defaultCacheMaxEntryBytes
+}
+
+// age returns the current age of e, per RFC 7234 section 4.2.3.
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return e.initialAge + now.Sub(e.stored)
+}
+
+// freshness returns how long e stays fresh for a request with the
+// Cache-Control directives reqCC, which may only shorten it.
+func (e *cacheEntry) freshness(reqCC cacheControl) time.Duration {
+	lifetime := e.lifetime
+	if d, ok := reqCC.seconds("max-age"); ok && d < lifetime {
+		lifetime = d
+	}
+	return lifetime
+}
+
+// conditional returns a copy of req asking for e to be revalidated.
+func (e *cacheEntry) conditional(req *Request) *Request {
+	sent := *req
+	sent.Header = make(map[string]string, len(req.Header)+2)
+	for k, v := range req.Header {
+		sent.Header[k] = v
+	}
+	if etag := e.header["Etag"]; etag != "" {
+		sent.Header["If-None-Match"] = etag
+	}
+	if lm := e.header["Last-Modified"]; lm != "" {
+		sent.Header["If-Modified-Since"] = lm
+	}
+	return &sent
+}
+
+// response returns the response to req from e at now.
+func (e *cacheEntry) response(req *Request, now time.Time, xcache string) *Response {
+	h := make(map[string]string, len(e.header)+3)
+	for k, v := range e.header {
+		h[k] = v
+	}
+	h["Age"] = strconv.Itoa(int(e.age(now) / time.Second))
+	h["X-Cache"] = xcache
+	if req.Close {
+		h["Connection"] = "close"
+	}
+	return &Response{
+		StatusCode: e.status,
+		Proto:      "HTTP/1.1",
+		Header:     h,
+		Request:    req,
+		Body:       e.body,
+	}
+}
+
+// withXCache sets the X-Cache header of res to xcache and returns it.
+func withXCache(res *Response, xcache string) *Response {
+	res.Header["X-Cache"] = xcache
+	return res
+}
+
+// lifetime returns the freshness lifetime of a response with header
+// received at received: from s-maxage, max-age or Expires, or else
+// a tenth of the time since Last-Modified, per RFC 7234 section 4.2.
+func lifetime(header map[string]string, received time.Time) time.Duration {
+	cc := parseCacheControl(header["Cache-Control"])
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d
+	}
+	date := received
+	if t, err := http.ParseTime(header["Date"]); err == nil {
+		date = t
+	}
+	if v, ok := header["Expires"]; ok {
+		t, err := http.ParseTime(v)
+		if err != nil {
+			// Invalid dates mean already expired
+			return 0
+		}
+		return t.Sub(date)
+	}
+	if t, err := http.ParseTime(header["Last-Modified"]); err == nil && t.Before(date) {
+		return date.Sub(t) / 10
+	}
+	return 0
+}
+
+// cacheControl holds the directives of a Cache-Control header.
+type cacheControl map[string]string
+
+// parseCacheControl parses the directives of a Cache-Control header,
+// lower-casing their names and unquoting their values.
+func parseCacheControl(v string) cacheControl {
+	cc := cacheControl{}
+	for _, d := range strings.Split(v, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+		if name != "" {
+			cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the delta-seconds value of directive name.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, true
+	}
+	return time.Duration(n) * time.Second, true
+}
@@ -0,0 +1,180 @@
+package tritonhttp
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cacheUpstream is a Handler answering with a copy of the response in
+// next, recording the requests it got.
+type cacheUpstream struct {
+	next     func(req *Request) (int, map[string]string, string)
+	requests []*Request
+}
+
+func (u *cacheUpstream) ServeRequest(req *Request) *Response {
+	u.requests = append(u.requests, req)
+	status, header, body := u.next(req)
+	h := map[string]string{"Content-Length": strconv.Itoa(len(body))}
+	for k, v := range header {
+		h[k] = v
+	}
+	return &Response{StatusCode: status, Proto: "HTTP/1.1", Header: h, Request: req, BodyReader: strings.NewReader(body)}
+}
+
+// cacheGet sends a GET for url with header to c and returns the
+// response and its body.
+func cacheGet(t *testing.T, c *Cache, url string, header map[string]string) (*Response, string) {
+	t.Helper()
+	if header == nil {
+		header = map[string]string{}
+	}
+	res := c.ServeRequest(&Request{Method: "GET", URL: url, Proto: "HTTP/1.1", Header: header, Host: "test"})
+	if res.Body != nil {
+		return res, string(res.Body)
+	}
+	body, err := io.ReadAll(res.BodyReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(body)
+}
+
+func TestCacheFreshnessAndRevalidation(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	up := &cacheUpstream{next: func(req *Request) (int, map[string]string, string) {
+		if req.Header["If-None-Match"] == `"v1"` {
+			return 304, map[string]string{"Cache-Control": "max-age=60"}, ""
+		}
+		return 200, map[string]string{"Cache-Control": "max-age=60", "Etag": `"v1"`}, "hello"
+	}}
+	c := &Cache{Handler: up, Clock: func() time.Time { return now }}
+
+	var steps = []struct {
+		name      string
+		advance   time.Duration
+		header    map[string]string
+		xcache    string
+		age       string
+		upstreams int
+	}{
+		{"Miss", 0, nil, "MISS", "0", 1},
+		{"Hit", 10 * time.Second, nil, "HIT", "10", 1},
+		{"RequestMaxAge", 0, map[string]string{"Cache-Control": "max-age=5"}, "REVALIDATED", "0", 2},
+		{"HitAfterRevalidation", 30 * time.Second, nil, "HIT", "30", 2},
+		{"Stale", 31 * time.Second, nil, "REVALIDATED", "0", 3},
+		{"RequestNoCache", 0, map[string]string{"Cache-Control": "no-cache"}, "REVALIDATED", "0", 4},
+		{"RequestNoStore", 0, map[string]string{"Cache-Control": "no-store"}, "BYPASS", "", 5},
+		{"Authorization", 0, map[string]string{"Authorization": "Basic eDp5"}, "BYPASS", "", 6},
+	}
+	for _, st := range steps {
+		now = now.Add(st.advance)
+		res, body := cacheGet(t, c, "/a", st.header)
+		if res.StatusCode != 200 || body != "hello" {
+			t.Fatalf("%v: got %v %q, want 200 %q", st.name, res.StatusCode, body, "hello")
+		}
+		if res.Header["X-Cache"] != st.xcache || res.Header["Age"] != st.age || len(up.requests) != st.upstreams {
+			t.Fatalf("%v: got X-Cache %q, Age %q after %v upstream requests, want %q, %q after %v",
+				st.name, res.Header["X-Cache"], res.Header["Age"], len(up.requests), st.xcache, st.age, st.upstreams)
+		}
+	}
+	if got := up.requests[2].Header["If-None-Match"]; got != `"v1"` {
+		t.Fatalf("revalidation got If-None-Match %q, want %q", got, `"v1"`)
+	}
+}
+
+func TestCacheNotStored(t *testing.T) {
+	var tests = []struct {
+		name   string
+		status int
+		header map[string]string
+	}{
+		{"NoStore", 200, map[string]string{"Cache-Control": "no-store, max-age=60"}},
+		{"Private", 200, map[string]string{"Cache-Control": "private, max-age=60"}},
+		{"NoFreshnessNorValidator", 200, nil},
+		{"UncacheableStatus", 500, map[string]string{"Cache-Control": "max-age=60"}},
+		{"VaryStar", 200, map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}},
+		{"TooBig", 200, map[string]string{"Cache-Control": "max-age=60", "X-Big": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := "hello"
+			if tt.header["X-Big"] != "" {
+				body = strings.Repeat("x", 11)
+			}
+			up := &cacheUpstream{next: func(req *Request) (int, map[string]string, string) { return tt.status, tt.header, body }}
+			c := &Cache{Handler: up, MaxEntryBytes: 10}
+			for i := 0; i < 2; i++ {
+				res, got := cacheGet(t, c, "/a", nil)
+				if res.Header["X-Cache"] != "MISS" || got != body {
+					t.Fatalf("request %v: got X-Cache %q, body %q, want MISS, %q", i, res.Header["X-Cache"], got, body)
+				}
+			}
+			if len(up.requests) != 2 {
+				t.Fatalf("got %v upstream requests, want 2", len(up.requests))
+			}
+		})
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	up := &cacheUpstream{next: func(req *Request) (int, map[string]string, string) {
+		return 200, map[string]string{"Cache-Control": "max-age=60", "Vary": "accept-language"}, "text in " + req.Header["Accept-Language"]
+	}}
+	c := &Cache{Handler: up}
+	for _, lang := range []string{"en", "en", "fr"} {
+		cacheGet(t, c, "/a", map[string]string{"Accept-Language": lang})
+	}
+	if len(up.requests) != 2 {
+		t.Fatalf("got %v upstream requests, want 2", len(up.requests))
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	up := &cacheUpstream{next: func(req *Request) (int, map[string]string, string) {
+		return 200, map[string]string{"Cache-Control": "max-age=60"}, "123456"
+	}}
+	c := &Cache{Handler: up, MaxBytes: 15}
+	for _, url := range []string{"/a", "/b", "/a", "/c", "/a", "/b"} {
+		cacheGet(t, c, url, nil)
+	}
+	// /b was evicted for /c, as /a was used more recently
+	var got []string
+	for _, req := range up.requests {
+		got = append(got, req.URL)
+	}
+	if strings.Join(got, " ") != "/a /b /c /b" {
+		t.Fatalf("got upstream requests %v, want [/a /b /c /b]", got)
+	}
+	if c.size > 15 {
+		t.Fatalf("got %v bytes stored, want at most 15", c.size)
+	}
+}
+
+func TestLifetime(t *testing.T) {
+	received := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		name   string
+		header map[string]string
+		want   time.Duration
+	}{
+		{"MaxAge", map[string]string{"Cache-Control": "public, max-age=300"}, 300 * time.Second},
+		{"SMaxAgeFirst", map[string]string{"Cache-Control": "max-age=300, s-maxage=30"}, 30 * time.Second},
+		{"Expires", map[string]string{"Date": FormatTime(received), "Expires": FormatTime(received.Add(time.Hour))}, time.Hour},
+		{"MaxAgeOverExpires", map[string]string{"Cache-Control": "max-age=5", "Expires": FormatTime(received.Add(time.Hour))}, 5 * time.Second},
+		{"InvalidExpires", map[string]string{"Expires": "0"}, 0},
+		{"Heuristic", map[string]string{"Last-Modified": FormatTime(received.Add(-10 * time.Hour))}, time.Hour},
+		{"None", map[string]string{}, 0},
+		{"BadMaxAge", map[string]string{"Cache-Control": "max-age=soon"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lifetime(tt.header, received); got != tt.want {
+				t.Fatalf("got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package tritonhttp
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// CanonicalHost redirects the requests not made to a single origin to
+// it with a 301 Moved Permanently keeping their path and query, so that
+// search engines index a single copy of each page: those for another
+// host than Host, e.g. "www.example.com" for "example.com" or the other
+// way around, and, if HTTPS is set, those made over plain HTTP.
+type CanonicalHost struct {
+	// Host is the canonical host, with its port unless it is the
+	// default one; "" keeps the host of each request.
+	Host string
+
+	// HTTPS redirects to https URLs. A request counts as made over
+	// HTTPS if the server received it over TLS, see Listener, or if a
+	// TLS terminating proxy in TrustedProxies says so with
+	// "X-Forwarded-Proto: https".
+	HTTPS          bool
+	TrustedProxies []netip.Prefix
+}
+
+// redirect returns the redirect of req to the canonical origin, or nil
+// if req is made to it or c is nil.
+func (c *CanonicalHost) redirect(req *Request) *Response {
+	if c == nil {
+		return nil
+	}
+	scheme := "http"
+	if req.Scheme == "https" || trusted(req.RemoteAddr, c.TrustedProxies) && strings.EqualFold(req.Header["X-Forwarded-Proto"], "https") {
+		scheme = "https"
+	}
+	wantScheme := scheme
+	if c.HTTPS {
+		wantScheme = "https"
+	}
+	host := withoutDefaultPort(req.Host, scheme)
+	wantHost := host
+	if c.Host != "" {
+		wantHost = withoutDefaultPort(c.Host, wantScheme)
+	}
+	if scheme == wantScheme && strings.EqualFold(host, wantHost) {
+		return nil
+	}
+	res := &Response{}
+	res.HandleRedirect(req, statusMovedPermanently, wantScheme+"://"+wantHost+req.URL)
+	return res
+}
+
+// withoutDefaultPort returns host without the port, if any, that is the
+// default one of scheme.
+func withoutDefaultPort(host, scheme string) string {
+	port := ":80"
+	if scheme == "https" {
+		port = ":443"
+	}
+	return strings.TrimSuffix(host, port)
+}
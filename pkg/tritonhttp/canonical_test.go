@@ -0,0 +1,49 @@
+package tritonhttp
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestCanonicalHost(t *testing.T) {
+	lb := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	var tests = []struct {
+		name         string
+		c            *CanonicalHost
+		host         string
+		remoteAddr   string
+		proto        string
+		wantLocation string
+	}{
+		{"unset", nil, "www.example.com", "192.0.2.1:5000", "", ""},
+		{"canonical", &CanonicalHost{Host: "example.com"}, "example.com", "192.0.2.1:5000", "", ""},
+		{"case", &CanonicalHost{Host: "example.com"}, "Example.COM", "192.0.2.1:5000", "", ""},
+		{"default port", &CanonicalHost{Host: "example.com"}, "example.com:80", "192.0.2.1:5000", "", ""},
+		{"www to apex", &CanonicalHost{Host: "example.com"}, "www.example.com", "192.0.2.1:5000", "", "http://example.com/a?b=c"},
+		{"apex to www", &CanonicalHost{Host: "www.example.com"}, "example.com", "192.0.2.1:5000", "", "http://www.example.com/a?b=c"},
+		{"other port", &CanonicalHost{Host: "example.com"}, "example.com:8080", "192.0.2.1:5000", "", "http://example.com/a?b=c"},
+		{"to https", &CanonicalHost{HTTPS: true}, "example.com:8080", "192.0.2.1:5000", "", "https://example.com:8080/a?b=c"},
+		{"https", &CanonicalHost{HTTPS: true, TrustedProxies: lb}, "example.com", "10.0.0.1:5000", "https", ""},
+		{"https to canonical", &CanonicalHost{Host: "example.com", HTTPS: true, TrustedProxies: lb}, "www.example.com", "10.0.0.1:5000", "https", "https://example.com/a?b=c"},
+		{"forged proto", &CanonicalHost{HTTPS: true, TrustedProxies: lb}, "example.com", "192.0.2.1:5000", "https", "https://example.com/a?b=c"},
+		{"both", &CanonicalHost{Host: "example.com", HTTPS: true}, "www.example.com", "192.0.2.1:5000", "", "https://example.com/a?b=c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Method: "GET", URL: "/a?b=c", Proto: "HTTP/1.1", Header: map[string]string{}, Host: tt.host, RemoteAddr: tt.remoteAddr}
+			if tt.proto != "" {
+				req.Header["X-Forwarded-Proto"] = tt.proto
+			}
+			res := tt.c.redirect(req)
+			if tt.wantLocation == "" {
+				if res != nil {
+					t.Fatalf("got a redirect to %q, want none", res.Header["Location"])
+				}
+				return
+			}
+			if res == nil || res.StatusCode != 301 || res.Header["Location"] != tt.wantLocation {
+				t.Fatalf("got %+v, want a 301 to %q", res, tt.wantLocation)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPendingLine bounds how much of an unterminated line the capture
+// holds back for redaction before writing it out as is.
+const maxPendingLine = 64 << 10
+
+// sensitiveHeader matches the header lines whose values are redacted.
+var sensitiveHeader = regexp.MustCompile(`(?i)^(authorization|proxy-authorization|cookie|set-cookie):.*`)
+
+// Capture configures recording of connection transcripts for debugging.
+//
+// Each selected connection is recorded to its own file in Dir.
+// The transcript is a sequence of chunks, each a line "> n" for bytes
+// received from the client or "< n" for bytes sent to it, followed by
+// the n bytes and a "\n". Unless Raw is set, the values of the
+// Authorization, Proxy-Authorization, Cookie and Set-Cookie headers
+// are replaced with "[REDACTED]".
+type Capture struct {
+	Dir      string
+	MaxBytes int64                    // per transcript; 0 means no cap
+	Select   func(conn net.Conn) bool // nil selects every connection
+	Raw      bool
+}
+
+// Wrap returns conn recording to a new transcript in Dir if c selects
+// it, and conn itself otherwise. The transcript is complete once the
+// returned connection is closed.
+func (c *Capture) Wrap(conn net.Conn) (net.Conn, error) {
+	if c == nil || (c.Select != nil && !c.Select(conn)) {
+		return conn, nil
+	}
+	name := fmt.Sprintf("%v-%v.txt",
+		time.Now().UTC().Format("20060102T150405.000000"),
+		strings.NewReplacer(":", "_", "[", "", "]", "").Replace(conn.RemoteAddr().String()))
+	f, err := os.Create(filepath.Join(c.Dir, name))
+	if err != nil {
+		return conn, err
+	}
+	return &captureConn{
+		Conn: conn,
+		f:    f,
+		bw:   bufio.NewWriter(f),
+		max:  c.MaxBytes,
+		in:   &redactor{raw: c.Raw},
+		out:  &redactor{raw: c.Raw},
+	}, nil
+}
+
+// wrap is Wrap logging failures to log.
+func (c *Capture) wrap(conn net.Conn, log Logger) net.Conn {
+	cc, err := c.Wrap(conn)
+	if err != nil {
+		log.Warnf("Failed to capture connection %v: %v", conn.RemoteAddr(), err)
+	}
+	return cc
+}
+
+// captureConn is a net.Conn recording what goes through it.
+type captureConn struct {
+	net.Conn
+
+	mu        sync.Mutex
+	f         *os.File
+	bw        *bufio.Writer
+	max       int64
+	written   int64
+	truncated bool
+	in, out   *redactor
+}
+
+func (cc *captureConn) netConn() net.Conn {
+	return cc.Conn
+}
+
+func (cc *captureConn) Read(p []byte) (int, error) {
+	n, err := cc.Conn.Read(p)
+	cc.record('>', cc.in, p[:n])
+	return n, err
+}
+
+func (cc *captureConn) Write(p []byte) (int, error) {
+	n, err := cc.Conn.Write(p)
+	cc.record('<', cc.out, p[:n])
+	return n, err
+}
+
+func (cc *captureConn) Close() error {
+	cc.mu.Lock()
+	if cc.f != nil {
+		cc.writeChunk('>', cc.in.flush())
+		cc.writeChunk('<', cc.out.flush())
+		_ = cc.bw.Flush()
+		_ = cc.f.Close()
+		cc.f = nil
+	}
+	cc.mu.Unlock()
+	return cc.Conn.Close()
+}
+
+// record adds p, going in direction dir, to the transcript.
+func (cc *captureConn) record(dir byte, r *redactor, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.f == nil {
+		return
+	}
+	cc.writeChunk(dir, r.process(p))
+}
+
+// writeChunk appends one chunk, honoring the size cap.
+// The caller must hold cc.mu.
+func (cc *captureConn) writeChunk(dir byte, p []byte) {
+	if len(p) == 0 || cc.truncated {
+		return
+	}
+	if cc.max > 0 && cc.written+int64(len(p)) > cc.max {
+		p = p[:cc.max-cc.written]
+		cc.truncated = true
+	}
+	fmt.Fprintf(cc.bw, "%c %d\n", dir, len(p))
+	cc.bw.Write(p)
+	cc.bw.WriteByte('\n')
+	cc.written += int64(len(p))
+	if cc.truncated {
+		cc.bw.WriteString("! truncated\n")
+	}
+}
+
+// redactor blanks out sensitive header values in a byte stream,
+// holding back unterminated lines until they are complete.
+type redactor struct {
+	raw     bool
+	pending []byte
+}
+
+// process returns the complete lines of pending+p, redacted.
+func (r *redactor) process(p []byte) []byte {
+	if r.raw {
+		return append([]byte(nil), p...)
+	}
+	r.pending = append(r.pending, p...)
+	end := bytes.LastIndexByte(r.pending, '\n') + 1
+	if end == 0 {
+		if len(r.pending) <= maxPendingLine {
+			return nil
+		}
+		end = len(r.pending)
+	}
+	out := redactLines(r.pending[:end])
+	r.pending = append([]byte(nil), r.pending[end:]...)
+	return out
+}
+
+// flush returns whatever is still held back, redacted.
+func (r *redactor) flush() []byte {
+	out := redactLines(r.pending)
+	r.pending = nil
+	return out
+}
+
+// redactLines replaces the values of sensitive header lines in p.
+func redactLines(p []byte) []byte {
+	lines := bytes.SplitAfter(p, []byte("\n"))
+	var out []byte
+	for _, line := range lines {
+		content := bytes.TrimRight(line, "\r\n")
+		if m := sensitiveHeader.FindSubmatch(content); m != nil {
+			out = append(out, m[1]...)
+			out = append(out, ": [REDACTED]"...)
+			out = append(out, line[len(content):]...)
+		} else {
+			out = append(out, line...)
+		}
+	}
+	return out
+}
+
+// TranscriptChunk is a chunk of a connection transcript.
+type TranscriptChunk struct {
+	FromClient bool // received from the client, rather than sent to it
+	Data       []byte
+}
+
+// ReadTranscript parses a transcript recorded by Capture. It reports
+// whether the transcript was truncated by Capture.MaxBytes.
+func ReadTranscript(r io.Reader) (chunks []TranscriptChunk, truncated bool, err error) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return chunks, truncated, nil
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("transcript chunk %v: %w", len(chunks)+1, io.ErrUnexpectedEOF)
+		}
+		if line == "! truncated\n" {
+			truncated = true
+			continue
+		}
+		var dir byte
+		var n int
+		if _, err := fmt.Sscanf(line, "%c %d\n", &dir, &n); err != nil || (dir != '>' && dir != '<') || n < 0 {
+			return nil, false, fmt.Errorf("transcript chunk %v: malformed header %q", len(chunks)+1, line)
+		}
+		data := make([]byte, n+1)
+		if _, err := io.ReadFull(br, data); err != nil || data[n] != '\n' {
+			return nil, false, fmt.Errorf("transcript chunk %v: %v bytes expected", len(chunks)+1, n)
+		}
+		chunks = append(chunks, TranscriptChunk{FromClient: dir == '>', Data: data[:n]})
+	}
+}
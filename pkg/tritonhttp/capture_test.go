@@ -0,0 +1,91 @@
+package tritonhttp
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	dir := t.TempDir()
+	client, server := net.Pipe()
+	c := &Capture{Dir: dir}
+	conn := c.wrap(server, defaultLogger)
+
+	go func() {
+		client.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nCookie: secret=1\r\n\r\n"))
+		io.ReadAll(client)
+	}()
+	buf := make([]byte, 1024)
+	n := 0
+	for n < 48 {
+		m, err := conn.Read(buf[n:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += m
+	}
+	if _, err := conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("got transcripts %v, err %v, want 1", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "> 50\nGET / HTTP/1.1\r\nHost: test\r\nCookie: [REDACTED]\r\n\r\n\n" +
+		"< 19\nHTTP/1.1 200 OK\r\n\r\n\n"
+	if got := string(data); got != want {
+		t.Fatalf("\ngot: %q\nwant: %q", got, want)
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	r := &redactor{}
+	// A sensitive line split across reads is still redacted
+	got := string(r.process([]byte("Authorization: Ba")))
+	got += string(r.process([]byte("sic abc\r\nKey: val\r\n")))
+	got += string(r.flush())
+	want := "Authorization: [REDACTED]\r\nKey: val\r\n"
+	if got != want {
+		t.Fatalf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestReadTranscript(t *testing.T) {
+	var tests = []struct {
+		name          string
+		transcript    string
+		want          []TranscriptChunk
+		wantTruncated bool
+		wantErr       bool
+	}{
+		{"Exchange", "> 3\nabc\n< 2\nde\n", []TranscriptChunk{{true, []byte("abc")}, {false, []byte("de")}}, false, false},
+		{"NewlinesInData", "> 4\na\nb\n\n", []TranscriptChunk{{true, []byte("a\nb\n")}}, false, false},
+		{"Truncated", "< 2\nab\n! truncated\n", []TranscriptChunk{{false, []byte("ab")}}, true, false},
+		{"Empty", "", nil, false, false},
+		{"ShortData", "> 5\nab\n", nil, false, true},
+		{"BadDirection", "? 1\na\n", nil, false, true},
+		{"NoHeader", "abc", nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated, err := ReadTranscript(strings.NewReader(tt.transcript))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error: %v, want error: %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) || truncated != tt.wantTruncated {
+				t.Fatalf("got: %+v, %v, want: %+v, %v", got, truncated, tt.want, tt.wantTruncated)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCGITimeout = 30 * time.Second
+
+	// maxCGIHeaderBytes bounds the headers a script may output.
+	maxCGIHeaderBytes = 64 << 10
+)
+
+// CGI is a Handler running CGI/1.1 scripts (RFC 3875) from a directory,
+// e.g. as the Handler of a Route with the Prefix "/cgi-bin". The
+// request body is piped to the script, and its output headers and body
+// relayed as the response: the Status header sets the status code, and
+// a Location without one makes it a 302 Found. Output without a
+// Content-Length is relayed with "Connection: close".
+type CGI struct {
+	// Dir is the local directory holding the scripts. The request path,
+	// Root removed, names the script in it, and whatever follows the
+	// script is the PATH_INFO, as in "/cgi-bin/app.py/users/1".
+	Dir  string
+	Root string
+
+	// Extensions, if set, are those of the files run as scripts; the
+	// others are not found.
+	Extensions []string
+
+	// Env lists additional environment variables, as "key=value".
+	Env []string
+
+	// Timeout bounds the run of each script, its output included;
+	// 0 means 30s.
+	Timeout time.Duration
+
+	// ErrorLog receives the failures of scripts and what they write
+	// to their standard error. If nil, they are logged via the log
+	// package.
+	ErrorLog Logger
+}
+
+// ServeRequest runs the script req names and returns its response, or
+// a 404 Not Found if there is no such script, a 502 Bad Gateway if it
+// fails, a 504 Gateway Timeout if it outlasts Timeout.
+func (c *CGI) ServeRequest(req *Request) *Response {
+	res := &Response{}
+	script, pathInfo, ok := c.lookup(req)
+	if !ok {
+		res.HandleNotFound(req)
+		return res
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultCGITimeout
+	}
+	file, err := filepath.Abs(filepath.Join(c.Dir, filepath.FromSlash(script)))
+	if err != nil {
+		c.errorLog().Errorf("Running CGI %v: %v", script, err)
+		res.HandleBadGateway(req)
+		return res
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	cmd := exec.CommandContext(ctx, file)
+	// Scripts run in their own directory
+	cmd.Dir = filepath.Dir(file)
+	cmd.Env = c.env(req, script, pathInfo)
+	cmd.Stdin = req.Body
+	// Bound the wait for children of the script holding its output open
+	cmd.WaitDelay = time.Second
+	cmd.Stderr = &logWriter{log: c.errorLog(), prefix: "CGI " + script + ": "}
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		cancel()
+		c.errorLog().Errorf("Running CGI %v: %v", script, err)
+		res.HandleBadGateway(req)
+		return res
+	}
+	body := &cgiBody{cmd: cmd, cancel: cancel}
+	body.br = bufio.NewReader(stdout)
+
+	header, status, err := readCGIHeader(body.br)
+	if err != nil {
+		timedOut := ctx.Err() != nil
+		cancel()
+		_ = body.Close()
+		c.errorLog().Warnf("Running CGI %v: %v", script, err)
+		if timedOut {
+			res.HandleGatewayTimeout(req)
+		} else {
+			res.HandleBadGateway(req)
+		}
+		return res
+	}
+	res.StatusCode = status
+	res.Proto = "HTTP/1.1"
+	res.Header = header
+	res.Request = req
+	res.BodyReader = body
+	if _, framed := header["Content-Length"]; req.Close || !framed {
+		res.Header["Connection"] = "close"
+	}
+	return res
+}
+
+// lookup returns the path of the script req names, relative to Dir,
+// and the PATH_INFO following it.
+func (c *CGI) lookup(req *Request) (script, pathInfo string, ok bool) {
+	urlPath, _, _ := strings.Cut(req.URL, "?")
+	rel, ok := strings.CutPrefix(urlPath, c.Root)
+	if !ok {
+		return "", "", false
+	}
+	rel = path.Clean("/" + rel)
+	for i := 1; i <= len(rel); i++ {
+		if i < len(rel) && rel[i] != '/' {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(c.Dir, filepath.FromSlash(rel[:i])))
+		if err != nil {
+			return "", "", false
+		}
+		if fi.Mode().IsRegular() {
+			script, pathInfo = rel[1:i], rel[i:]
+			return script, pathInfo, c.runs(script)
+		}
+		if !fi.IsDir() {
+			return "", "", false
+		}
+	}
+	return "", "", false
+}
+
+// runs reports whether script has one of Extensions, if any are set.
+func (c *CGI) runs(script string) bool {
+	if len(c.Extensions) == 0 {
+		return true
+	}
+	ext := path.Ext(script)
+	for _, e := range c.Extensions {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// env returns the environment of script run for req, per RFC 3875
+// section 4.1, with PATH and Env added.
+func (c *CGI) env(req *Request, script, pathInfo string) []string {
+	scriptName := strings.TrimSuffix(c.Root, "/") + "/" + script
+	env := cgiVariables(req, scriptName, filepath.Join(c.Dir, filepath.FromSlash(script)), pathInfo)
+	if pathInfo != "" {
+		env = append(env, "PATH_TRANSLATED="+filepath.Join(c.Dir, filepath.FromSlash(pathInfo)))
+	}
+	if p, ok := os.LookupEnv("PATH"); ok {
+		env = append(env, "PATH="+p)
+	}
+	return append(env, c.Env...)
+}
+
+// cgiVariables returns the meta-variables of RFC 3875 section 4.1 for
+// req to the script at scriptName, the local file scriptFilename, as
+// "name=value".
+func cgiVariables(req *Request, scriptName, scriptFilename, pathInfo string) []string {
+	_, query, _ := strings.Cut(req.URL, "?")
+	serverName, serverPort, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		serverName, serverPort = req.Host, "80"
+	}
+	env := []string{
+		"GATEWAY_INTERFACE=CGI/1.1",
+		"SERVER_SOFTWARE=TritonHTTP",
+		"SERVER_PROTOCOL=" + req.Proto,
+		"SERVER_NAME=" + serverName,
+		"SERVER_PORT=" + serverPort,
+		"REQUEST_METHOD=" + req.Method,
+		"REQUEST_URI=" + req.URL,
+		"QUERY_STRING=" + query,
+		"SCRIPT_NAME=" + scriptName,
+		"SCRIPT_FILENAME=" + scriptFilename,
+		"PATH_INFO=" + pathInfo,
+		"HTTP_HOST=" + req.Host,
+	}
+	if host, port, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		env = append(env, "REMOTE_ADDR="+host, "REMOTE_PORT="+port)
+	}
+	for k, v := range req.Header {
+		switch k {
+		case "Content-Length":
+			env = append(env, "CONTENT_LENGTH="+v)
+		case "Content-Type":
+			env = append(env, "CONTENT_TYPE="+v)
+		case "Authorization", "Proxy-Authorization":
+			// Not passed on, as RFC 3875 section 4.1.18 advises
+		case "Proxy":
+			// HTTP_PROXY would have scripts send their own requests
+			// through the proxy of the client's choosing (httpoxy)
+		default:
+			env = append(env, "HTTP_"+strings.ToUpper(strings.ReplaceAll(k, "-", "_"))+"="+v)
+		}
+	}
+	return env
+}
+
+func (c *CGI) errorLog() Logger {
+	if c.ErrorLog != nil {
+		return c.ErrorLog
+	}
+	return defaultLogger
+}
+
+// readCGIHeader reads the header section a script output from br,
+// returning the response headers and status code it sets.
+func readCGIHeader(br *bufio.Reader) (map[string]string, int, error) {
+	header := make(map[string]string)
+	status := statusOK
+	read := 0
+	for {
+		line, err := br.ReadString('\n')
+		read += len(line)
+		if read > maxCGIHeaderBytes {
+			return nil, 0, fmt.Errorf("headers exceed %v bytes", maxCGIHeaderBytes)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("output ends before the end of the headers")
+			}
+			return nil, 0, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, 0, fmt.Errorf("malformed header line %q", line)
+		}
+		header[CanonicalHeaderKey(key)] = strings.TrimSpace(value)
+	}
+	if len(header) == 0 {
+		return nil, 0, errors.New("no headers")
+	}
+	if v, ok := header["Status"]; ok {
+		code, _, _ := strings.Cut(v, " ")
+		n, err := strconv.Atoi(code)
+		if err != nil || n < 100 || n > 999 {
+			return nil, 0, fmt.Errorf("invalid Status %q", v)
+		}
+		status = n
+		delete(header, "Status")
+	} else if _, ok := header["Location"]; ok {
+		status = statusFound
+	}
+	for _, k := range hopHeaders {
+		delete(header, k)
+	}
+	return header, status, nil
+}
+
+// cgiBody is the output of a running script following its headers.
+// Closing it ends the script.
+type cgiBody struct {
+	br     *bufio.Reader
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+}
+
+func (b *cgiBody) Read(p []byte) (int, error) { return b.br.Read(p) }
+
+// Close kills the script unless it is done, and waits for it.
+func (b *cgiBody) Close() error {
+	// Drain what the script still writes, so that it is not killed
+	// for a full pipe after its output was read.
+	_, _ = io.Copy(io.Discard, b.br)
+	err := b.cmd.Wait()
+	b.cancel()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		// The response is sent already; the exit status is moot
+		return nil
+	}
+	return err
+}
+
+// logWriter writes each line written through it to log at the warning
+// level, after prefix.
+type logWriter struct {
+	log    Logger
+	prefix string
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.log.Warnf("%v%v", w.prefix, line)
+	}
+	return len(p), nil
+}
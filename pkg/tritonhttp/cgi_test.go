@@ -0,0 +1,151 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startCGI serves the shell scripts in scripts, by name, under
+// /cgi-bin, and returns the server address.
+func startCGI(t *testing.T, scripts map[string]string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("CGI scripts are shell scripts")
+	}
+	root, dir := t.TempDir(), t.TempDir()
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cgi := &CGI{Dir: dir, Root: "/cgi-bin", Extensions: []string{".sh"}, Timeout: 500 * time.Millisecond}
+	addr, _ := startTestServer(t, &Server{DocRoot: root, Routes: []Route{{Prefix: "/cgi-bin", Handler: cgi}}})
+	return addr
+}
+
+func TestCGI(t *testing.T) {
+	addr := startCGI(t, map[string]string{
+		"env.sh": `printf 'Content-Type: text/plain\r\n\r\n'
+echo "$REQUEST_METHOD $SCRIPT_NAME $PATH_INFO $QUERY_STRING $HTTP_X_TEST $SERVER_NAME $GATEWAY_INTERFACE"
+`,
+		"echo.sh": `printf 'Content-Type: text/plain\nContent-Length: %s\n\n' "$CONTENT_LENGTH"
+cat
+`,
+		"status.sh":   `printf 'Status: 404 Not Found\nContent-Length: 4\n\ngone'`,
+		"redirect.sh": `printf 'Location: http://example.com/\nContent-Length: 0\n\n'`,
+		"garbage.sh":  `echo "no headers here"`,
+		"slow.sh":     `exec sleep 5`,
+		"script.txt":  `printf 'Content-Length: 0\n\n'`,
+	})
+
+	var tests = []struct {
+		name       string
+		raw        string
+		wantStatus int
+		wantBody   string
+		wantHeader map[string]string
+	}{
+		{
+			"environment",
+			"GET /cgi-bin/env.sh/a/b?q=1 HTTP/1.1\r\nHost: test:8080\r\nX-Test: yes\r\n\r\n",
+			200,
+			"GET /cgi-bin/env.sh /a/b q=1 yes test CGI/1.1\n",
+			map[string]string{"Content-Type": "text/plain", "Connection": "close"},
+		},
+		{
+			"request body",
+			"GET /cgi-bin/echo.sh HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello",
+			200,
+			"hello",
+			map[string]string{"Content-Length": "5"},
+		},
+		{"status", "GET /cgi-bin/status.sh HTTP/1.1\r\nHost: test\r\n\r\n", 404, "gone", nil},
+		{
+			"redirect",
+			"GET /cgi-bin/redirect.sh HTTP/1.1\r\nHost: test\r\n\r\n",
+			302,
+			"",
+			map[string]string{"Location": "http://example.com/"},
+		},
+		{"bad output", "GET /cgi-bin/garbage.sh HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 502, "", nil},
+		{"timeout", "GET /cgi-bin/slow.sh HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 504, "", nil},
+		{"other extension", "GET /cgi-bin/script.txt HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 404, "", nil},
+		{"missing", "GET /cgi-bin/missing.sh HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 404, "", nil},
+		{"dot segments", "GET /cgi-bin/./x/../env.sh HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 200, "", nil},
+		{"escape", "GET /cgi-bin/../../cgi-bin/env.sh HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 400, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := exchangeRaw(t, addr, tt.raw, 1)[0]
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+			body, _ := io.ReadAll(res.BodyReader)
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("got body %q, want %q", body, tt.wantBody)
+			}
+			for k, v := range tt.wantHeader {
+				if res.Header[k] != v {
+					t.Errorf("got %v %q, want %q", k, res.Header[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestCGIVariables(t *testing.T) {
+	req := &Request{Method: "GET", URL: "/cgi-bin/env.sh?x=1", Proto: "HTTP/1.1", Host: "test:8080", RemoteAddr: "10.0.0.1:1234",
+		Header: map[string]string{"Proxy": "http://evil.test:3128", "Authorization": "secret", "User-Agent": "x/1"}}
+	env := strings.Join(cgiVariables(req, "/cgi-bin/env.sh", "/srv/cgi-bin/env.sh", ""), "\n") + "\n"
+	for _, want := range []string{"QUERY_STRING=x=1\n", "SERVER_PORT=8080\n", "REMOTE_ADDR=10.0.0.1\n", "HTTP_USER_AGENT=x/1\n"} {
+		if !strings.Contains(env, want) {
+			t.Errorf("got no %q in:\n%v", want, env)
+		}
+	}
+	// The Proxy header must not become HTTP_PROXY (httpoxy)
+	for _, unwanted := range []string{"HTTP_PROXY=", "HTTP_AUTHORIZATION="} {
+		if strings.Contains(env, unwanted) {
+			t.Errorf("got %q in:\n%v", unwanted, env)
+		}
+	}
+}
+
+func TestReadCGIHeader(t *testing.T) {
+	var tests = []struct {
+		name       string
+		out        string
+		wantStatus int
+		wantErr    string
+	}{
+		{"plain", "Content-Type: text/html\n\n", 200, ""},
+		{"crlf", "Content-Type: text/html\r\n\r\nbody", 200, ""},
+		{"status", "Status: 503 Busy\nRetry-After: 5\n\n", 503, ""},
+		{"location", "Location: /elsewhere\n\n", 302, ""},
+		{"no headers", "\nbody", 0, "no headers"},
+		{"unterminated", "Content-Type: text/html\n", 0, "output ends"},
+		{"malformed", "Content-Type text/html\n\n", 0, "malformed"},
+		{"bad status", "Status: OK\n\n", 0, "invalid Status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, status, err := readCGIHeader(bufio.NewReader(strings.NewReader(tt.out)))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("got status %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
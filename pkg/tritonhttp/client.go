@@ -0,0 +1,205 @@
+package tritonhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// Client sends requests to HTTP/1.1 servers, TritonHTTP or otherwise.
+// The zero Client is usable.
+type Client struct {
+	// Transport sends the requests. If nil, DefaultTransport is used.
+	Transport *Transport
+
+	// CheckRedirect, if set, is called before following a redirect
+	// with the next request and the ones already sent, oldest first.
+	// Returning an error stops and returns it, except for
+	// ErrUseLastResponse which returns the redirect response itself.
+	CheckRedirect func(req *Request, via []*Request) error
+
+	// MaxRedirects caps the redirects followed for one request.
+	// Zero means 10; a negative value follows none.
+	MaxRedirects int
+}
+
+// defaultMaxRedirects is the redirect cap of Clients without one.
+const defaultMaxRedirects = 10
+
+// redirectDrainBytes is how much of a redirect body is read so its
+// connection can be reused; longer bodies close the connection.
+const redirectDrainBytes = 4 << 10
+
+// ErrUseLastResponse can be returned by Client.CheckRedirect to stop
+// following redirects and return the last response unread.
+var ErrUseLastResponse = errors.New("tritonhttp: use last response")
+
+// Get sends a GET request for rawURL, an "http://" or "https://" URL.
+func (c *Client) Get(rawURL string) (*Response, error) {
+	return c.GetContext(context.Background(), rawURL)
+}
+
+// GetContext is Get giving up once ctx is done.
+func (c *Client) GetContext(ctx context.Context, rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return c.DoContext(ctx, req)
+}
+
+// NewRequest returns a request with the given method for rawURL,
+// an "http://" or "https://" URL.
+func NewRequest(method, rawURL string) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("URL %q has no host", rawURL)
+	}
+	return &Request{
+		Method: method,
+		URL:    u.RequestURI(),
+		Proto:  "HTTP/1.1",
+		Header: map[string]string{},
+		Host:   u.Host,
+		Scheme: u.Scheme,
+	}, nil
+}
+
+// Do sends req to the server named by req.Host, port 80, or 443 for
+// https, unless it says otherwise, and reads the response. The caller must Close the
+// response once done with its BodyReader.
+func (c *Client) Do(req *Request) (*Response, error) {
+	return c.DoContext(context.Background(), req)
+}
+
+// DoContext is Do giving up once ctx is done, including while the
+// response body is read. Timeouts are reported as *TimeoutError.
+// Redirects (301, 302, 303, 307 and 308) are followed, see
+// CheckRedirect and MaxRedirects, but for a 307 or 308 to a request
+// with a Body, which is returned, the body being gone.
+func (c *Client) DoContext(ctx context.Context, req *Request) (*Response, error) {
+	t := c.Transport
+	if t == nil {
+		t = DefaultTransport
+	}
+	var via []*Request
+	for {
+		res, err := t.RoundTripContext(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		loc, ok := res.Header["Location"]
+		if !ok || !isRedirect(res.StatusCode) || c.maxRedirects() < 0 {
+			return res, nil
+		}
+		if len(via) >= c.maxRedirects() {
+			_ = res.Close()
+			return nil, fmt.Errorf("tritonhttp: stopped after %v redirects", len(via))
+		}
+		next, err := redirectRequest(req, res.StatusCode, loc)
+		if errors.Is(err, errBodyNotReplayable) {
+			return res, nil
+		} else if err != nil {
+			_ = res.Close()
+			return nil, err
+		}
+		via = append(via, req)
+		if c.CheckRedirect != nil {
+			if err := c.CheckRedirect(next, via); err != nil {
+				if errors.Is(err, ErrUseLastResponse) {
+					return res, nil
+				}
+				_ = res.Close()
+				return nil, err
+			}
+		}
+		_, _ = io.CopyN(io.Discard, res.BodyReader, redirectDrainBytes)
+		_ = res.Close()
+		req = next
+	}
+}
+
+func (c *Client) maxRedirects() int {
+	if c.MaxRedirects != 0 {
+		return c.MaxRedirects
+	}
+	return defaultMaxRedirects
+}
+
+// isRedirect reports whether code is a redirect the client follows.
+func isRedirect(code int) bool {
+	switch code {
+	case 301, 302, 303, 307, 308:
+		return true
+	}
+	return false
+}
+
+// errBodyNotReplayable is the error of redirectRequest for a redirect
+// keeping the method of a request whose Body was sent already, whose
+// response is then returned as it is.
+var errBodyNotReplayable = errors.New("tritonhttp: cannot send the request body again")
+
+// bodyHeaders are the headers framing and describing a request body,
+// not sent on once a redirect turns the request into a GET without it.
+var bodyHeaders = []string{"Content-Length", "Content-Type", "Content-Encoding", "Transfer-Encoding"}
+
+// redirectRequest returns the request following a redirect to loc,
+// answered with code to req. 307 and 308 keep the method, and the
+// body, which cannot be sent again once read, so it fails with
+// errBodyNotReplayable if req has one; 301 and 302 turn anything but
+// GET and HEAD into GET, as browsers do, and 303 turns anything but
+// HEAD into GET, without the body. Credentials are not sent on to
+// another host, nor from https to http.
+func redirectRequest(req *Request, code int, loc string) (*Request, error) {
+	base := &url.URL{Scheme: req.scheme(), Host: req.Host}
+	if cur, err := url.ParseRequestURI(req.URL); err == nil {
+		base.Path, base.RawQuery = cur.Path, cur.RawQuery
+	}
+	target, err := url.Parse(loc)
+	if err != nil {
+		return nil, fmt.Errorf("tritonhttp: invalid redirect location %q: %w", loc, err)
+	}
+	next, err := NewRequest(req.Method, base.ResolveReference(target).String())
+	if err != nil {
+		return nil, fmt.Errorf("tritonhttp: cannot follow redirect to %q: %w", loc, err)
+	}
+	switch {
+	case code == 303 && req.Method != "HEAD",
+		(code == 301 || code == 302) && req.Method != "GET" && req.Method != "HEAD":
+		next.Method = "GET"
+	}
+	if next.Method == req.Method && req.Body != nil {
+		return nil, errBodyNotReplayable
+	}
+	next.Close = req.Close
+	for k, v := range req.Header {
+		leaving := next.Host != req.Host || (req.scheme() == "https" && next.Scheme != "https")
+		if leaving && (k == "Authorization" || k == "Cookie") {
+			continue
+		}
+		next.Header[k] = v
+	}
+	if next.Method != req.Method {
+		for _, k := range bodyHeaders {
+			delete(next.Header, k)
+		}
+	}
+	return next, nil
+}
+
+// scheme returns the scheme of a request sent by a Client.
+func (req *Request) scheme() string {
+	if req.Scheme == "" {
+		return "http"
+	}
+	return req.Scheme
+}
@@ -0,0 +1,451 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestWrite(t *testing.T) {
+	req := &Request{
+		Method: "GET",
+		URL:    "/index.html",
+		Proto:  "HTTP/1.1",
+		Header: map[string]string{"User-Agent": "test", "Accept": "*/*"},
+		Host:   "example.com",
+		Close:  true,
+	}
+	var buf bytes.Buffer
+	if err := req.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "GET /index.html HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"Connection: close\r\n" +
+		"Accept: */*\r\n" +
+		"User-Agent: test\r\n" +
+		"\r\n"
+	if buf.String() != want {
+		t.Fatalf("got: %q, want: %q", buf.String(), want)
+	}
+
+	// What is written reads back the same
+	got, _, err := ReadRequest(bufio.NewReader(&buf))
+	checkGoodRequest(t, err, got, req)
+}
+
+func TestReadResponse(t *testing.T) {
+	var tests = []struct {
+		name     string
+		method   string
+		resText  string
+		wantCode int
+		wantBody string
+		wantErr  bool
+	}{
+		{"ContentLength", "GET", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello, extra", 200, "hello", false},
+		{"UntilClose", "GET", "HTTP/1.1 404 Not Found\r\nConnection: close\r\n\r\nnot here", 404, "not here", false},
+		{"Head", "HEAD", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n", 200, "", false},
+		{"NoContent", "GET", "HTTP/1.1 304 Not Modified\r\n\r\n", 304, "", false},
+		{"NoReason", "GET", "HTTP/1.1 200\r\nContent-Length: 0\r\n\r\n", 200, "", false},
+		{"BadStatusLine", "GET", "HTTP/1.1200 OK\r\n\r\n", 0, "", true},
+		{"BadCode", "GET", "HTTP/1.1 2000 OK\r\n\r\n", 0, "", true},
+		{"BadHeader", "GET", "HTTP/1.1 200 OK\r\nno colon\r\n\r\n", 0, "", true},
+		{"BadLength", "GET", "HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n", 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ReadResponse(bufio.NewReader(strings.NewReader(tt.resText)), &Request{Method: tt.method})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got response %v, want error", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(res.BodyReader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.wantCode || string(body) != tt.wantBody {
+				t.Fatalf("got: %v %q, want: %v %q", res.StatusCode, body, tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestClientGet(t *testing.T) {
+	s := &Server{DocRoot: "testdata"}
+	addr, done := startTestServer(t, s)
+	defer func() {
+		s.Shutdown(context.Background())
+		<-done
+	}()
+
+	c := &Client{}
+	res, err := c.Get("http://" + addr + "/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	body, err := io.ReadAll(res.BodyReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("testdata/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 || !bytes.Equal(body, want) {
+		t.Fatalf("got: %v %q, want: 200 %q", res.StatusCode, body, want)
+	}
+
+	if _, err := c.Get("ftp://" + addr + "/"); err == nil {
+		t.Fatal("ftp URL got no error")
+	}
+}
+
+// scriptedServer serves the responses respond returns, in wire format,
+// to the requests it reads, on keep-alive connections.
+func scriptedServer(t *testing.T, respond func(req *Request) string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveScripted(t, ln, respond)
+}
+
+// serveScripted is scriptedServer on ln.
+func serveScripted(t *testing.T, ln net.Listener, respond func(req *Request) string) string {
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					req, _, err := ReadRequest(br)
+					if err != nil {
+						return
+					}
+					if _, err := io.WriteString(conn, respond(req)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClientRedirects(t *testing.T) {
+	redirect := func(code int, loc string) string {
+		return fmt.Sprintf("HTTP/1.1 %v Moved\r\nLocation: %v\r\nContent-Length: 5\r\n\r\nmoved", code, loc)
+	}
+	var addr string
+	addr = scriptedServer(t, func(req *Request) string {
+		switch req.URL {
+		case "/a":
+			return redirect(301, "/b?x=1")
+		case "/b?x=1":
+			return redirect(307, "c")
+		case "/c":
+			return redirect(308, "http://"+addr+"/done")
+		case "/loop":
+			return redirect(302, "/loop")
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	})
+
+	var via []string
+	c := &Client{Transport: &Transport{}, CheckRedirect: func(req *Request, prev []*Request) error {
+		via = append(via, req.URL)
+		return nil
+	}}
+	res, err := c.Get("http://" + addr + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.BodyReader)
+	res.Close()
+	if res.StatusCode != 200 || string(body) != "ok" || res.Request.URL != "/done" {
+		t.Fatalf("got: %v %q for %v", res.StatusCode, body, res.Request.URL)
+	}
+	if want := []string{"/b?x=1", "/c", "/done"}; !reflect.DeepEqual(via, want) {
+		t.Fatalf("redirects got: %v, want: %v", via, want)
+	}
+
+	if _, err := c.Get("http://" + addr + "/loop"); err == nil || !strings.Contains(err.Error(), "10 redirects") {
+		t.Fatalf("redirect loop got: %v", err)
+	}
+
+	c.CheckRedirect = func(*Request, []*Request) error { return ErrUseLastResponse }
+	res, err = c.Get("http://" + addr + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Close()
+	if res.StatusCode != 301 {
+		t.Fatalf("ErrUseLastResponse got status: %v, want: 301", res.StatusCode)
+	}
+}
+
+func TestRedirectRequest(t *testing.T) {
+	var tests = []struct {
+		method     string
+		code       int
+		loc        string
+		wantMethod string
+		wantHost   string
+		wantURL    string
+		wantAuth   bool
+	}{
+		{"POST", 301, "/next", "GET", "a.test", "/next", true},
+		{"POST", 302, "next", "GET", "a.test", "/dir/next", true},
+		{"HEAD", 303, "/next", "HEAD", "a.test", "/next", true},
+		{"PUT", 303, "/next", "GET", "a.test", "/next", true},
+		{"POST", 307, "/next", "POST", "a.test", "/next", true},
+		{"DELETE", 308, "http://b.test:8080/x?y", "DELETE", "b.test:8080", "/x?y", false},
+	}
+	for _, tt := range tests {
+		req := &Request{Method: tt.method, URL: "/dir/page?q", Proto: "HTTP/1.1", Host: "a.test",
+			Header: map[string]string{"Authorization": "secret", "Accept": "*/*"}}
+		next, err := redirectRequest(req, tt.code, tt.loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, hasAuth := next.Header["Authorization"]
+		if next.Method != tt.wantMethod || next.Host != tt.wantHost || next.URL != tt.wantURL ||
+			hasAuth != tt.wantAuth || next.Header["Accept"] != "*/*" {
+			t.Fatalf("%v %v %v got: %+v", tt.method, tt.code, tt.loc, next)
+		}
+	}
+
+	if _, err := redirectRequest(&Request{Method: "GET", URL: "/", Host: "a.test"}, 302, "ftp://a.test/"); err == nil {
+		t.Fatal("redirect to ftp got no error")
+	}
+}
+
+func TestRedirectRequestBody(t *testing.T) {
+	var tests = []struct {
+		code       int
+		body       bool
+		wantMethod string
+		wantErr    error
+	}{
+		{303, true, "GET", nil},
+		{301, true, "GET", nil},
+		{307, true, "", errBodyNotReplayable},
+		{308, true, "", errBodyNotReplayable},
+		{307, false, "POST", nil},
+	}
+	for _, tt := range tests {
+		req := &Request{Method: "POST", URL: "/a", Proto: "HTTP/1.1", Host: "a.test",
+			Header: map[string]string{"Content-Length": "5", "Content-Type": "text/plain", "Accept": "*/*"}}
+		if tt.body {
+			req.Body = strings.NewReader("hello")
+		}
+		next, err := redirectRequest(req, tt.code, "/b")
+		if err != tt.wantErr {
+			t.Fatalf("%v: got error %v, want %v", tt.code, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		_, framed := next.Header["Content-Length"]
+		_, typed := next.Header["Content-Type"]
+		keep := tt.wantMethod == "POST"
+		if next.Method != tt.wantMethod || next.Body != nil || framed != keep || typed != keep || next.Header["Accept"] != "*/*" {
+			t.Fatalf("%v: got %v with Body %v and headers %v", tt.code, next.Method, next.Body != nil, next.Header)
+		}
+	}
+}
+
+func TestClientRedirectBody(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					req, _, err := readProxyRequest(br, DefaultLimits(), readOptions{methods: true})
+					if err != nil {
+						return
+					}
+					var reply string
+					switch req.URL {
+					case "/see-other":
+						reply = "HTTP/1.1 303 See Other\r\nLocation: /b\r\nContent-Length: 0\r\n\r\n"
+					case "/temporary":
+						reply = "HTTP/1.1 307 Temporary Redirect\r\nLocation: /b\r\nContent-Length: 0\r\n\r\n"
+					default:
+						reply = fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%v", len(req.Method), req.Method)
+					}
+					if _, err := io.WriteString(conn, reply); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	c := &Client{Transport: &Transport{}}
+	for _, tt := range []struct {
+		url        string
+		wantStatus int
+		wantBody   string
+	}{
+		{"/see-other", 200, "GET"},
+		{"/temporary", 307, ""},
+	} {
+		req, err := NewRequest("POST", "http://"+ln.Addr().String()+tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header["Content-Length"] = "5"
+		req.Body = strings.NewReader("hello")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		res, err := c.DoContext(ctx, req)
+		if err != nil {
+			cancel()
+			t.Fatalf("%v: %v", tt.url, err)
+		}
+		body, _ := io.ReadAll(res.BodyReader)
+		res.Close()
+		cancel()
+		if res.StatusCode != tt.wantStatus || string(body) != tt.wantBody {
+			t.Errorf("%v: got %v %q, want %v %q", tt.url, res.StatusCode, body, tt.wantStatus, tt.wantBody)
+		}
+	}
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1
+// and the pool of roots trusting it.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tritonhttp test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots
+}
+
+func TestClientTLS(t *testing.T) {
+	cert, roots := testCertificate(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveScripted(t, ln, func(req *Request) string {
+		if req.URL == "/away" {
+			return "HTTP/1.1 302 Found\r\nLocation: http://" + req.Host + "/plain\r\nContent-Length: 0\r\n\r\n"
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\nsecret"
+	})
+
+	var tests = []struct {
+		name    string
+		config  *tls.Config
+		wantErr bool
+	}{
+		{"UnknownAuthority", nil, true},
+		{"CustomRoots", &tls.Config{RootCAs: roots}, false},
+		{"InsecureSkipVerify", &tls.Config{InsecureSkipVerify: true}, false},
+		{"WrongServerName", &tls.Config{RootCAs: roots, ServerName: "example.com"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr := &Transport{TLSClientConfig: test.config}
+			defer tr.CloseIdleConnections()
+			c := &Client{Transport: tr}
+			for i := 0; i < 2; i++ {
+				res, err := c.Get("https://" + addr + "/")
+				if (err != nil) != test.wantErr {
+					t.Fatalf("got error: %v, want error: %v", err, test.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				body, _ := io.ReadAll(res.BodyReader)
+				res.Close()
+				if string(body) != "secret" {
+					t.Fatalf("got: %q, want: %q", body, "secret")
+				}
+			}
+			if got := tr.idleCount(); got != 1 {
+				t.Fatalf("idle conns got: %v, want: 1", got)
+			}
+		})
+	}
+
+	// Credentials stay behind when redirected from https to http
+	req, err := NewRequest("GET", "https://"+addr+"/away")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header["Authorization"] = "Basic c2VjcmV0"
+	c := &Client{
+		Transport: &Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		CheckRedirect: func(next *Request, via []*Request) error {
+			if _, ok := next.Header["Authorization"]; ok || next.Scheme != "http" {
+				t.Errorf("redirect to %v://%v got Authorization: %v", next.Scheme, next.URL, ok)
+			}
+			return ErrUseLastResponse
+		},
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Close()
+}
@@ -0,0 +1,133 @@
+package tritonhttp
+
+import (
+	"net/http"
+	"strings"
+)
+
+// isETag reports whether s is an entity tag, e.g. `"v1"` or `W/"v1"`.
+func isETag(s string) bool {
+	s = strings.TrimPrefix(s, "W/")
+	return len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' && !strings.Contains(s[1:len(s)-1], `"`)
+}
+
+// etagStrongMatch reports whether the entity tags a and b match by the
+// strong comparison of RFC 9110: both strong, and the same.
+func etagStrongMatch(a, b string) bool {
+	return isETag(a) && a == b && !strings.HasPrefix(a, "W/")
+}
+
+// etagWeakMatch reports whether the entity tags a and b match by the
+// weak comparison of RFC 9110: the same, weak or not.
+func etagWeakMatch(a, b string) bool {
+	return isETag(a) && isETag(b) && strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// modifiedAt reports whether date, an HTTP-date of a request header,
+// is the Last-Modified time of header, those of a response, to the
+// second HTTP-dates have. Invalid dates never are.
+func modifiedAt(date string, header map[string]string) bool {
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(header["Last-Modified"])
+	return err == nil && t.Equal(lm)
+}
+
+// ifRangeMatches reports whether the If-Range header of req, if any,
+// matches the representation of header, the headers of the response to
+// it, for its Range to apply: by the strong comparison of an entity
+// tag with its ETag, or a date being its Last-Modified time.
+func ifRangeMatches(req *Request, header map[string]string) bool {
+	v := strings.TrimSpace(req.Header["If-Range"])
+	switch {
+	case v == "":
+		return true
+	case isETag(v):
+		return etagStrongMatch(v, header["Etag"])
+	}
+	return modifiedAt(v, header)
+}
+
+// checkPreconditions evaluates the conditional headers of req against
+// res, a 200 OK serving a file, in the order of RFC 9110: making res a
+// 412 Precondition Failed if its If-Match does not match the ETag of
+// the file, or, without one, if the file was modified since its
+// If-Unmodified-Since; or, for a GET or HEAD, a 304 Not Modified if the
+// copy of the client is current: if its If-None-Match matches the ETag,
+// or, without one, if the file was not modified since its
+// If-Modified-Since. An If-None-Match matching other requests fails
+// too. It reports whether it made res either.
+func (res *Response) checkPreconditions(req *Request) bool {
+	// The file may have no ETag, if it could not be had
+	etag, exists := res.Header["Etag"], res.FilePath != ""
+	if im, ok := req.Header["If-Match"]; ok {
+		if !ifMatch(im, etag, exists) {
+			res.preconditionFailed()
+			return true
+		}
+	} else if ius := req.Header["If-Unmodified-Since"]; ius != "" && validDate(ius) && modifiedSince(ius, res.Header) {
+		res.preconditionFailed()
+		return true
+	}
+	safe := req.Method == "GET" || req.Method == "HEAD"
+	if inm, ok := req.Header["If-None-Match"]; ok {
+		switch {
+		case !ifNoneMatch(inm, etag, exists):
+			return false
+		case safe:
+			res.notModified()
+		default:
+			res.preconditionFailed()
+		}
+		return true
+	}
+	if ims := req.Header["If-Modified-Since"]; safe && ims != "" && !modifiedSince(ims, res.Header) {
+		res.notModified()
+		return true
+	}
+	return false
+}
+
+// validDate reports whether date is a valid HTTP-date; the conditional
+// headers with an invalid one are ignored.
+func validDate(date string) bool {
+	_, err := http.ParseTime(date)
+	return err == nil
+}
+
+// modifiedSince reports whether the Last-Modified time of header, the
+// headers of a response, is after date, an HTTP-date of a request
+// header, as it is taken to be if either is invalid.
+func modifiedSince(date string, header map[string]string) bool {
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return true
+	}
+	lm, err := http.ParseTime(header["Last-Modified"])
+	return err != nil || lm.After(t)
+}
+
+// notModified makes res, serving a file, a 304 Not Modified without a
+// body, keeping the headers of the file, such as its Last-Modified,
+// but for those of the body.
+func (res *Response) notModified() {
+	_ = res.closeFile()
+	res.FilePath, res.offset, res.parts = "", 0, nil
+	res.StatusCode = statusNotModified
+	for _, key := range []string{"Content-Length", "Content-Type", "Content-Encoding", "Content-Range", "Accept-Ranges"} {
+		delete(res.Header, key)
+	}
+}
+
+// preconditionFailed makes res, serving a file, a 412 Precondition
+// Failed, keeping the validators of the file for the client to tell
+// what it has changed to.
+func (res *Response) preconditionFailed() {
+	res.offset, res.parts = 0, nil
+	for _, key := range []string{"Content-Encoding", "Content-Range", "Accept-Ranges"} {
+		delete(res.Header, key)
+	}
+	res.Text(statusPreconditionFailed, StatusText(statusPreconditionFailed)+"\n")
+}
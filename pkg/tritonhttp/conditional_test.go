@@ -0,0 +1,197 @@
+package tritonhttp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestETagMatch(t *testing.T) {
+	var tests = []struct {
+		a, b         string
+		strong, weak bool
+	}{
+		{`"v1"`, `"v1"`, true, true},
+		{`"v1"`, `"v2"`, false, false},
+		{`W/"v1"`, `"v1"`, false, true},
+		{`W/"v1"`, `W/"v1"`, false, true},
+		{`"v1"`, `W/"v1"`, false, true},
+		{`v1`, `v1`, false, false},
+		{`""`, `""`, true, true},
+		{`"a"b"`, `"a"b"`, false, false},
+		{`"v1"`, ``, false, false},
+	}
+	for _, tt := range tests {
+		if got := etagStrongMatch(tt.a, tt.b); got != tt.strong {
+			t.Errorf("etagStrongMatch(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.strong)
+		}
+		if got := etagWeakMatch(tt.a, tt.b); got != tt.weak {
+			t.Errorf("etagWeakMatch(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.weak)
+		}
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	header := map[string]string{"Etag": `"abc"`, "Last-Modified": "Sun, 02 Jan 2022 03:04:05 GMT"}
+	var tests = []struct {
+		ifRange string
+		want    bool
+	}{
+		{"", true},
+		{`"abc"`, true},
+		{`"xyz"`, false},
+		{`W/"abc"`, false},
+		{"Sun, 02 Jan 2022 03:04:05 GMT", true},
+		{"Sunday, 02-Jan-22 03:04:05 GMT", true},
+		{"Sun, 02 Jan 2022 03:04:04 GMT", false},
+		{"Mon, 03 Jan 2022 03:04:05 GMT", false},
+		{"yesterday", false},
+	}
+	for _, tt := range tests {
+		req := &Request{Header: map[string]string{"If-Range": tt.ifRange}}
+		if got := ifRangeMatches(req, header); got != tt.want {
+			t.Errorf("If-Range %q: got %v, want %v", tt.ifRange, got, tt.want)
+		}
+	}
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	const data = "0123456789"
+	modTime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := &Server{
+		FS:       MountFS(fstest.MapFS{"data.txt": {Data: []byte(data), ModTime: modTime}}, "/srv"),
+		DocRoot:  "/srv",
+		ErrorLog: NewLogger(nil, LevelError),
+	}
+	addr, _ := startTestServer(t, s)
+	var tests = []struct {
+		name       string
+		headers    string
+		wantStatus int
+	}{
+		{"None", "", 200},
+		{"Same", "If-Modified-Since: Sun, 02 Jan 2022 03:04:05 GMT\r\n", 304},
+		{"Later", "If-Modified-Since: Mon, 03 Jan 2022 00:00:00 GMT\r\n", 304},
+		{"Earlier", "If-Modified-Since: Sun, 02 Jan 2022 03:04:04 GMT\r\n", 200},
+		{"Invalid", "If-Modified-Since: yesterday\r\n", 200},
+		{"Range", "If-Modified-Since: Sun, 02 Jan 2022 03:04:05 GMT\r\nRange: bytes=0-1\r\n", 304},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "GET /data.txt HTTP/1.1\r\nHost: test\r\n" + tt.headers + "\r\n"
+			// The connection stays open for the next request
+			res := exchangeRaw(t, addr, raw+"GET /data.txt HTTP/1.1\r\nHost: test\r\n\r\n", 2)
+			body, _ := io.ReadAll(res[0].BodyReader)
+			if res[0].StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res[0].StatusCode, tt.wantStatus)
+			}
+			if got := res[0].Header["Last-Modified"]; got != "Sun, 02 Jan 2022 03:04:05 GMT" {
+				t.Errorf("got Last-Modified %q", got)
+			}
+			if tt.wantStatus == 304 {
+				if len(body) != 0 || res[0].Header["Content-Length"] != "" || res[0].Header["Content-Type"] != "" {
+					t.Errorf("got body %q, Content-Length %q, Content-Type %q, want none",
+						body, res[0].Header["Content-Length"], res[0].Header["Content-Type"])
+				}
+			} else if string(body) != data {
+				t.Errorf("got body %q, want %q", body, data)
+			}
+			if next, _ := io.ReadAll(res[1].BodyReader); res[1].StatusCode != 200 || string(next) != data {
+				t.Errorf("got next %v %q, want 200 %q", res[1].StatusCode, next, data)
+			}
+		})
+	}
+}
+
+func TestPreconditions(t *testing.T) {
+	const data = "0123456789"
+	modTime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := &Server{
+		FS:             MountFS(fstest.MapFS{"data.txt": {Data: []byte(data), ModTime: modTime}}, "/srv"),
+		DocRoot:        "/srv",
+		AllowedMethods: []string{"GET", "HEAD", "POST"},
+		ErrorLog:       NewLogger(nil, LevelError),
+	}
+	addr, _ := startTestServer(t, s)
+	const etag = `"16c65510d4bb3200-a"`
+	const before, at = "Sat, 01 Jan 2022 00:00:00 GMT", "Sun, 02 Jan 2022 03:04:05 GMT"
+
+	var tests = []struct {
+		name       string
+		method     string
+		headers    string
+		wantStatus int
+	}{
+		{"IfMatch", "GET", "If-Match: " + etag + "\r\n", 200},
+		{"IfMatchList", "GET", `If-Match: "other", ` + etag + "\r\n", 200},
+		{"IfMatchStar", "GET", "If-Match: *\r\n", 200},
+		{"IfMatchOther", "GET", `If-Match: "other"` + "\r\n", 412},
+		{"IfMatchWeak", "GET", "If-Match: W/" + etag + "\r\n", 412},
+		{"IfUnmodifiedSince", "GET", "If-Unmodified-Since: " + at + "\r\n", 200},
+		{"IfUnmodifiedSinceModified", "GET", "If-Unmodified-Since: " + before + "\r\n", 412},
+		{"IfUnmodifiedSinceInvalid", "GET", "If-Unmodified-Since: yesterday\r\n", 200},
+		// If-Match takes precedence over If-Unmodified-Since
+		{"IfMatchModified", "GET", "If-Match: " + etag + "\r\nIf-Unmodified-Since: " + before + "\r\n", 200},
+		// and a failed one over If-None-Match and Range
+		{"IfMatchOtherNoneMatch", "GET", `If-Match: "other"` + "\r\nIf-None-Match: " + etag + "\r\n", 412},
+		{"IfMatchOtherRange", "GET", `If-Match: "other"` + "\r\nRange: bytes=0-1\r\n", 412},
+		{"IfMatchRange", "GET", "If-Match: " + etag + "\r\nRange: bytes=0-1\r\n", 206},
+		{"IfMatchNoneMatch", "GET", "If-Match: " + etag + "\r\nIf-None-Match: " + etag + "\r\n", 304},
+		{"PostIfNoneMatch", "POST", "If-None-Match: *\r\nContent-Length: 0\r\n", 412},
+		{"PostIfModifiedSince", "POST", "If-Modified-Since: " + at + "\r\nContent-Length: 0\r\n", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := tt.method + " /data.txt HTTP/1.1\r\nHost: test\r\n" + tt.headers + "\r\n"
+			res := exchangeRaw(t, addr, raw+"GET /data.txt HTTP/1.1\r\nHost: test\r\n\r\n", 2)
+			body, _ := io.ReadAll(res[0].BodyReader)
+			if res[0].StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res[0].StatusCode, tt.wantStatus)
+			}
+			if res[0].Header["Etag"] != etag {
+				t.Errorf("got ETag %q, want %q", res[0].Header["Etag"], etag)
+			}
+			if tt.wantStatus == 412 && (string(body) != "Precondition Failed\n" || res[0].Header["Content-Range"] != "") {
+				t.Errorf("got body %q, Content-Range %q", body, res[0].Header["Content-Range"])
+			}
+			if next, _ := io.ReadAll(res[1].BodyReader); res[1].StatusCode != 200 || string(next) != data {
+				t.Errorf("got next %v %q, want 200 %q", res[1].StatusCode, next, data)
+			}
+		})
+	}
+}
+
+func TestPreconditionsUntagged(t *testing.T) {
+	s := &Server{
+		FS:      MountFS(fstest.MapFS{"data.txt": {Data: []byte("0123456789")}}, "/srv"),
+		DocRoot: "/srv",
+		ETag: func(fs.FileInfo, fs.File) (string, error) {
+			return "", errors.New("no tag")
+		},
+		ErrorLog: NewLogger(nil, LevelError),
+	}
+	addr, _ := startTestServer(t, s)
+	var tests = []struct {
+		name       string
+		headers    string
+		wantStatus int
+	}{
+		// The file exists, though its tag could not be had
+		{"IfMatchStar", "If-Match: *\r\n", 200},
+		{"IfMatch", `If-Match: "x"` + "\r\n", 412},
+		{"IfNoneMatchStar", "If-None-Match: *\r\n", 304},
+		{"IfNoneMatch", `If-None-Match: "x"` + "\r\n", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "GET /data.txt HTTP/1.1\r\nHost: test\r\n" + tt.headers + "Connection: close\r\n\r\n"
+			res := exchangeRaw(t, addr, raw, 1)[0]
+			if res.StatusCode != tt.wantStatus || res.Header["Etag"] != "" {
+				t.Fatalf("got status %v with ETag %q, want %v without", res.StatusCode, res.Header["Etag"], tt.wantStatus)
+			}
+		})
+	}
+}
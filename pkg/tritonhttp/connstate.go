@@ -0,0 +1,299 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnState is the state of a client connection,
+// reported to the Server.ConnState hook.
+type ConnState int
+
+const (
+	// StateNew is a connection that was just accepted.
+	StateNew ConnState = iota
+	// StateActive is a connection with a request being handled.
+	StateActive
+	// StateIdle is a connection waiting for its next request.
+	StateIdle
+	// StateClosed is a closed connection. It is a terminal state.
+	StateClosed
+)
+
+var connStateNames = map[ConnState]string{
+	StateNew:    "new",
+	StateActive: "active",
+	StateIdle:   "idle",
+	StateClosed: "closed",
+}
+
+// MarshalText encodes c as its name, e.g. in JSON.
+func (c ConnState) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c ConnState) String() string {
+	if name, ok := connStateNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("ConnState(%d)", int(c))
+}
+
+// Stats is a snapshot of the connection counters of a server.
+type Stats struct {
+	ActiveConns int // connections with a request being handled
+	IdleConns   int // connections waiting for their next request
+
+	AcceptedConns int64 // connections handled so far
+	RejectedConns int64 // connections dropped right after accept
+	ClosedConns   int64
+	Timeouts      int64 // reads of a request that timed out
+	SlowRequests  int64 // requests over Server.SlowRequestThreshold
+}
+
+// ConnInfo describes an open client connection.
+type ConnInfo struct {
+	ID         uint64
+	RemoteAddr string
+	State      ConnState
+	Accepted   time.Time
+
+	// Request is the request line of the request being handled,
+	// and RequestDuration how long it has been handled for.
+	// Both are zero unless State is StateActive.
+	Request         string
+	RequestDuration time.Duration
+
+	BytesRead    int64
+	BytesWritten int64
+}
+
+// connInfo is what the tracker knows about one connection.
+type connInfo struct {
+	id       uint64
+	accepted time.Time
+	metered  *meteredConn
+	state    int32 // a ConnState, updated atomically
+
+	mu       sync.Mutex // guards req and reqStart
+	req      string
+	reqStart time.Time
+}
+
+// connTracker knows the state of every open connection. Connections
+// come and go, and change state on every request, without taking a
+// lock shared by all: the connections are in a sync.Map, and the
+// counters atomic.
+type connTracker struct {
+	conns  sync.Map // net.Conn to *connInfo
+	open   int64
+	lastID uint64
+
+	// The counters of Stats
+	active   int64
+	idle     int64
+	accepted int64
+	rejected int64
+	closed   int64
+	timeouts int64
+	slowReqs int64
+}
+
+// set moves conn to state, updating the counters.
+func (ct *connTracker) set(conn net.Conn, state ConnState) {
+	switch state {
+	case StateNew:
+		info := &connInfo{
+			id:       atomic.AddUint64(&ct.lastID, 1),
+			accepted: time.Now(),
+			metered:  &meteredConn{Conn: conn},
+			state:    int32(StateNew),
+		}
+		atomic.AddInt64(&ct.accepted, 1)
+		if old, tracked := ct.conns.Swap(conn, info); tracked {
+			ct.count(old.(*connInfo).loadState(), -1)
+		} else {
+			atomic.AddInt64(&ct.open, 1)
+		}
+		ct.count(StateNew, 1)
+		return
+	case StateClosed:
+		if v, tracked := ct.conns.LoadAndDelete(conn); tracked {
+			ct.count(v.(*connInfo).loadState(), -1)
+			atomic.AddInt64(&ct.closed, 1)
+			atomic.AddInt64(&ct.open, -1)
+		}
+		return
+	}
+	info := ct.info(conn)
+	if info == nil {
+		return
+	}
+	old := ConnState(atomic.SwapInt32(&info.state, int32(state)))
+	ct.count(old, -1)
+	ct.count(state, 1)
+	if state != StateActive {
+		info.mu.Lock()
+		info.req = ""
+		info.mu.Unlock()
+	}
+}
+
+// info returns what is known about conn, or nil if it is not tracked.
+func (ct *connTracker) info(conn net.Conn) *connInfo {
+	if v, ok := ct.conns.Load(conn); ok {
+		return v.(*connInfo)
+	}
+	return nil
+}
+
+// loadState returns the state of the connection.
+func (info *connInfo) loadState() ConnState {
+	return ConnState(atomic.LoadInt32(&info.state))
+}
+
+// setRequest records that conn started handling req.
+func (ct *connTracker) setRequest(conn net.Conn, req *Request) {
+	if info := ct.info(conn); info != nil {
+		line := fmt.Sprintf("%v %v %v", req.Method, req.URL, req.Proto)
+		info.mu.Lock()
+		info.req = line
+		info.reqStart = time.Now()
+		info.mu.Unlock()
+	}
+}
+
+// metered returns conn counting the bytes going through it,
+// or conn itself if it is not tracked.
+func (ct *connTracker) metered(conn net.Conn) net.Conn {
+	if info := ct.info(conn); info != nil {
+		return info.metered
+	}
+	return conn
+}
+
+// count adds delta to the gauge of state.
+func (ct *connTracker) count(state ConnState, delta int64) {
+	switch state {
+	case StateActive:
+		atomic.AddInt64(&ct.active, delta)
+	case StateNew, StateIdle:
+		atomic.AddInt64(&ct.idle, delta)
+	}
+}
+
+// reject counts a connection dropped right after accept.
+func (ct *connTracker) reject() {
+	atomic.AddInt64(&ct.rejected, 1)
+}
+
+// timeout counts a connection that timed out waiting for a request.
+func (ct *connTracker) timeout() {
+	atomic.AddInt64(&ct.timeouts, 1)
+}
+
+// slow counts a request over the slow request threshold.
+func (ct *connTracker) slow() {
+	atomic.AddInt64(&ct.slowReqs, 1)
+}
+
+// each calls f with every tracked connection, until it returns false.
+func (ct *connTracker) each(f func(conn net.Conn, info *connInfo) bool) {
+	ct.conns.Range(func(k, v interface{}) bool {
+		return f(k.(net.Conn), v.(*connInfo))
+	})
+}
+
+// Connections returns a snapshot of all open connections, by ID.
+func (s *Server) Connections() []ConnInfo {
+	all := make([]ConnInfo, 0)
+	s.tracker.each(func(conn net.Conn, info *connInfo) bool {
+		ci := ConnInfo{
+			ID:           info.id,
+			RemoteAddr:   conn.RemoteAddr().String(),
+			State:        info.loadState(),
+			Accepted:     info.accepted,
+			BytesRead:    atomic.LoadInt64(&info.metered.read),
+			BytesWritten: atomic.LoadInt64(&info.metered.written),
+		}
+		info.mu.Lock()
+		if ci.State == StateActive && info.req != "" {
+			ci.Request = info.req
+			ci.RequestDuration = time.Since(info.reqStart)
+		}
+		info.mu.Unlock()
+		all = append(all, ci)
+		return true
+	})
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all
+}
+
+// CloseConnection force-closes the open connection with the given ID,
+// as listed by Connections.
+func (s *Server) CloseConnection(id uint64) error {
+	var err error
+	found := false
+	s.tracker.each(func(conn net.Conn, info *connInfo) bool {
+		if info.id == id {
+			found = true
+			err = conn.Close()
+		}
+		return !found
+	})
+	if !found {
+		return fmt.Errorf("no open connection with ID %v", id)
+	}
+	return err
+}
+
+// Stats returns a snapshot of the connection counters of s.
+func (s *Server) Stats() Stats {
+	ct := &s.tracker
+	return Stats{
+		ActiveConns:   int(atomic.LoadInt64(&ct.active)),
+		IdleConns:     int(atomic.LoadInt64(&ct.idle)),
+		AcceptedConns: atomic.LoadInt64(&ct.accepted),
+		RejectedConns: atomic.LoadInt64(&ct.rejected),
+		ClosedConns:   atomic.LoadInt64(&ct.closed),
+		Timeouts:      atomic.LoadInt64(&ct.timeouts),
+		SlowRequests:  atomic.LoadInt64(&ct.slowReqs),
+	}
+}
+
+// setState records that conn entered state and calls the ConnState hook.
+func (s *Server) setState(conn net.Conn, state ConnState) {
+	s.tracker.set(conn, state)
+	if s.ConnState != nil {
+		s.ConnState(conn, state)
+	}
+}
+
+// meteredConn is a net.Conn counting the bytes read from and written to it.
+type meteredConn struct {
+	net.Conn
+	read    int64
+	written int64
+}
+
+func (mc *meteredConn) Read(p []byte) (int, error) {
+	n, err := mc.Conn.Read(p)
+	atomic.AddInt64(&mc.read, int64(n))
+	return n, err
+}
+
+func (mc *meteredConn) Write(p []byte) (int, error) {
+	n, err := mc.Conn.Write(p)
+	atomic.AddInt64(&mc.written, int64(n))
+	return n, err
+}
+
+func (mc *meteredConn) writeBuffers(v net.Buffers) (int64, error) {
+	n, err := writeBuffers(mc.Conn, v)
+	atomic.AddInt64(&mc.written, n)
+	return n, err
+}
@@ -0,0 +1,178 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnStateAndStats(t *testing.T) {
+	var mu sync.Mutex
+	var states []ConnState
+	s := &Server{
+		DocRoot: "testdata",
+		ConnState: func(conn net.Conn, state ConnState) {
+			mu.Lock()
+			states = append(states, state)
+			mu.Unlock()
+		},
+	}
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.HandleConnection(server)
+		close(done)
+	}()
+	go client.Write([]byte(
+		"GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n" +
+			"GET /index.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"))
+	if _, err := io.ReadAll(client); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	want := []ConnState{StateNew, StateActive, StateIdle, StateActive, StateClosed}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) != len(want) {
+		t.Fatalf("states got: %v, want: %v", states, want)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Fatalf("states got: %v, want: %v", states, want)
+		}
+	}
+
+	got := s.Stats()
+	if got != (Stats{AcceptedConns: 1, ClosedConns: 1}) {
+		t.Fatalf("stats got: %+v", got)
+	}
+}
+
+func TestConnectionsAndCloseConnection(t *testing.T) {
+	s := &Server{DocRoot: "testdata"}
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		s.HandleConnection(server)
+		close(done)
+	}()
+
+	// Read the first response so the connection is known to be idle
+	go client.Write([]byte("GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n"))
+	br := bufio.NewReader(client)
+	for {
+		line, err := ReadLine(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "" {
+			break
+		}
+	}
+	if _, err := io.ReadFull(br, make([]byte, 12)); err != nil {
+		t.Fatal(err)
+	}
+	var conns []ConnInfo
+	for i := 0; i < 100; i++ {
+		if conns = s.Connections(); len(conns) == 1 && conns[0].State == StateIdle {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if len(conns) != 1 || conns[0].State != StateIdle || conns[0].BytesRead == 0 {
+		t.Fatalf("connections got: %+v", conns)
+	}
+
+	if err := s.CloseConnection(conns[0].ID + 1); err == nil {
+		t.Fatal("got no error closing unknown connection")
+	}
+	if err := s.CloseConnection(conns[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if got := len(s.Connections()); got != 0 {
+		t.Fatalf("got %v connections after close, want 0", got)
+	}
+}
+
+func TestConnTrackerConcurrent(t *testing.T) {
+	s := &Server{}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server, client := net.Pipe()
+			defer client.Close()
+			s.setState(server, StateNew)
+			for i := 0; i < 100; i++ {
+				s.setState(server, StateActive)
+				s.tracker.setRequest(server, &Request{Method: "GET", URL: "/", Proto: "HTTP/1.1"})
+				_ = s.Connections()
+				s.setState(server, StateIdle)
+			}
+			s.setState(server, StateClosed)
+		}()
+	}
+	wg.Wait()
+	want := Stats{AcceptedConns: 8, ClosedConns: 8}
+	if got := s.Stats(); got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if got := s.Connections(); len(got) != 0 {
+		t.Fatalf("got %v connections left, want none", len(got))
+	}
+}
+
+func TestAcceptFilter(t *testing.T) {
+	var mu sync.Mutex
+	var filtered []string
+	var served int
+	s := &Server{
+		DocRoot: "testdata",
+		AcceptFilter: func(conn net.Conn) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			filtered = append(filtered, conn.RemoteAddr().String())
+			// Only the second connection gets through
+			return len(filtered) == 2
+		},
+		ConnState: func(conn net.Conn, state ConnState) {
+			if state == StateNew {
+				mu.Lock()
+				served++
+				mu.Unlock()
+			}
+		},
+	}
+	addr, _ := startTestServer(t, s)
+	raw := "GET /index.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"
+	for i, wantServed := range []bool{false, true} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(conn, raw)
+		got, _ := io.ReadAll(conn)
+		conn.Close()
+		if served := len(got) > 0; served != wantServed {
+			t.Fatalf("connection %v got %q, want served %v", i, got, wantServed)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(filtered) != 2 || served != 1 {
+		t.Fatalf("filtered %v connections and served %v, want 2 and 1", len(filtered), served)
+	}
+	if got := s.Stats(); got.RejectedConns != 1 || got.AcceptedConns != 1 {
+		t.Fatalf("stats got: %+v", got)
+	}
+}
@@ -0,0 +1,77 @@
+package tritonhttp
+
+import (
+	"path"
+	"sync"
+)
+
+// debugTargets is the set of clients and paths that get verbose logging.
+type debugTargets struct {
+	mu    sync.RWMutex
+	ips   map[string]bool
+	paths []string
+}
+
+// match reports whether a request from ip for urlPath is targeted.
+func (dt *debugTargets) match(ip, urlPath string) bool {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+	if dt.ips[ip] {
+		return true
+	}
+	for _, pattern := range dt.paths {
+		if ok, _ := path.Match(pattern, urlPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DebugClient turns on debug logging for all requests from the client IP ip,
+// whatever the level of Logger.
+func (s *Server) DebugClient(ip string) {
+	s.debug.mu.Lock()
+	defer s.debug.mu.Unlock()
+	if s.debug.ips == nil {
+		s.debug.ips = make(map[string]bool)
+	}
+	s.debug.ips[ip] = true
+}
+
+// DebugPath turns on debug logging for all requests whose URL matches
+// pattern, whatever the level of Logger. The pattern syntax is that of
+// path.Match, e.g. "/images/*.png".
+func (s *Server) DebugPath(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+	s.debug.mu.Lock()
+	defer s.debug.mu.Unlock()
+	s.debug.paths = append(s.debug.paths, pattern)
+	return nil
+}
+
+// ClearDebugTargets turns off all debug logging enabled by
+// DebugClient and DebugPath.
+func (s *Server) ClearDebugTargets() {
+	s.debug.mu.Lock()
+	defer s.debug.mu.Unlock()
+	s.debug.ips = nil
+	s.debug.paths = nil
+}
+
+// requestLogger returns the Logger for messages about req: one with
+// debug output enabled if req is targeted, s.logger() otherwise.
+func (s *Server) requestLogger(req *Request) Logger {
+	if !s.debug.match(splitHost(req.RemoteAddr), req.URL) {
+		return s.logger()
+	}
+	if s.DebugLog != nil {
+		return s.DebugLog
+	}
+	return defaultDebugLogger
+}
+
+// defaultDebugLogger receives targeted debug output
+// when Server.DebugLog is not set.
+var defaultDebugLogger = NewLogger(nil, LevelDebug)
@@ -0,0 +1,55 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestRequestLogger(t *testing.T) {
+	var logs, debugs bytes.Buffer
+	s := &Server{
+		Logger:   NewLogger(log.New(&logs, "", 0), LevelWarn),
+		DebugLog: NewLogger(log.New(&debugs, "", 0), LevelDebug),
+	}
+	s.DebugClient("10.0.0.1")
+	if err := s.DebugPath("/images/*.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DebugPath("[bad"); err == nil {
+		t.Fatal("got no error for malformed pattern")
+	}
+
+	var tests = []struct {
+		name     string
+		remote   string
+		url      string
+		targeted bool
+	}{
+		{"Client", "10.0.0.1:5555", "/index.html", true},
+		{"Path", "10.0.0.2:5555", "/images/cat.png", true},
+		{"Neither", "10.0.0.2:5555", "/images/cat.jpg", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			debugs.Reset()
+			req := &Request{URL: tt.url, RemoteAddr: tt.remote}
+			s.requestLogger(req).Debugf("hello")
+			if got := debugs.Len() > 0; got != tt.targeted {
+				t.Fatalf("debug output got: %q, want targeted: %v", debugs.String(), tt.targeted)
+			}
+			if logs.Len() > 0 {
+				t.Fatalf("unexpected output to Logger: %q", logs.String())
+			}
+		})
+	}
+
+	s.ClearDebugTargets()
+	debugs.Reset()
+	s.requestLogger(&Request{URL: "/index.html", RemoteAddr: "10.0.0.1:5555"}).Debugf("hello")
+	if debugs.Len() > 0 {
+		t.Fatal("debug output after clearing targets")
+	}
+}
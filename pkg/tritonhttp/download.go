@@ -0,0 +1,388 @@
+package tritonhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DownloadOptions tunes Client.DownloadFile. The zero value, or a nil
+// *DownloadOptions, downloads in one stream with the default retries.
+type DownloadOptions struct {
+	// Segments is how many ranged requests fetch a large file in
+	// parallel. Zero or one downloads in a single stream.
+	Segments int
+
+	// MinSegmentSize is the smallest segment worth a request of its
+	// own; smaller files take fewer segments. Zero means 1 MiB.
+	MinSegmentSize int64
+
+	// Retries is how many times an interrupted transfer is resumed
+	// before giving up. Zero means 3; a negative value means none.
+	Retries int
+}
+
+const (
+	defaultMinSegmentSize   = 1 << 20
+	defaultDownloadRetries  = 3
+	partialDownloadSuffix   = ".part"
+	downloadValidatorSuffix = ".part.validator"
+)
+
+// ErrDownloadChanged is returned by Client.DownloadFile when the
+// resource changed while a segmented download was in progress.
+var ErrDownloadChanged = errors.New("tritonhttp: resource changed during download")
+
+// DownloadFile saves the resource at rawURL to path.
+//
+// The data goes to path+".part" first, and is renamed to path once
+// complete. An interrupted transfer is resumed with a Range request,
+// both within the call, up to opts.Retries times, and by a later call
+// for the same path. The resource's ETag, or its Last-Modified time
+// if it has no strong ETag, is sent as If-Range so a resource that
+// changed in between is downloaded again from the start.
+//
+// With opts.Segments above one, a server supporting ranges is sent
+// that many requests for parts of the file in parallel. Segmented
+// downloads are only resumed within the call.
+func (c *Client) DownloadFile(ctx context.Context, rawURL, path string, opts *DownloadOptions) error {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	d := &download{c: c, ctx: ctx, url: rawURL, path: path, opts: opts}
+	if _, err := d.newRequest(); err != nil {
+		return err
+	}
+	var err error
+	if opts.Segments > 1 {
+		err = d.segmented()
+	} else {
+		err = d.stream()
+	}
+	if err != nil {
+		return err
+	}
+	_ = os.Remove(path + downloadValidatorSuffix)
+	return os.Rename(path+partialDownloadSuffix, path)
+}
+
+// download is the state of one DownloadFile call.
+type download struct {
+	c    *Client
+	ctx  context.Context
+	url  string
+	path string
+	opts *DownloadOptions
+}
+
+func (d *download) retries() int {
+	switch {
+	case d.opts.Retries > 0:
+		return d.opts.Retries
+	case d.opts.Retries < 0:
+		return 0
+	}
+	return defaultDownloadRetries
+}
+
+func (d *download) minSegmentSize() int64 {
+	if d.opts.MinSegmentSize > 0 {
+		return d.opts.MinSegmentSize
+	}
+	return defaultMinSegmentSize
+}
+
+// newRequest returns a GET request for the resource, asking for it
+// unencoded so that byte ranges line up with the file.
+func (d *download) newRequest() (*Request, error) {
+	req, err := NewRequest("GET", d.url)
+	if err != nil {
+		return nil, err
+	}
+	req.Header["Accept-Encoding"] = "identity"
+	return req, nil
+}
+
+// stream downloads the resource in one stream, resuming from whatever
+// a previous call left in the partial file.
+func (d *download) stream() error {
+	f, err := os.OpenFile(d.path+partialDownloadSuffix, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	validator := ""
+	if b, err := os.ReadFile(d.path + downloadValidatorSuffix); err == nil {
+		validator = string(b)
+	}
+
+	for attempt := 0; ; attempt++ {
+		var done bool
+		done, offset, validator, err = d.streamFrom(f, offset, validator)
+		if done {
+			return err
+		}
+		if attempt >= d.retries() || d.ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// streamFrom makes one attempt at downloading the rest of the resource
+// into f, which holds offset bytes of the version named by validator.
+// It returns the new offset and validator, and whether it is done,
+// for good or bad; otherwise err may be overcome by trying again.
+func (d *download) streamFrom(f *os.File, offset int64, validator string) (bool, int64, string, error) {
+	req, err := d.newRequest()
+	if err != nil {
+		return true, offset, validator, err
+	}
+	if offset > 0 && validator != "" {
+		req.Header["Range"] = fmt.Sprintf("bytes=%d-", offset)
+		req.Header["If-Range"] = validator
+	}
+	res, err := d.c.DoContext(d.ctx, req)
+	if err != nil {
+		return false, offset, validator, err
+	}
+	defer res.Close()
+
+	switch res.StatusCode {
+	case 206:
+		start, _, _, err := parseContentRange(res.Header["Content-Range"])
+		if err != nil || start != offset {
+			return true, offset, validator, fmt.Errorf("tritonhttp: unexpected Content-Range %q resuming at %v",
+				res.Header["Content-Range"], offset)
+		}
+		if v := responseValidator(res); v != "" && v != validator {
+			return true, offset, validator, ErrDownloadChanged
+		}
+	case 200:
+		// A full body: either no range was asked for, or the resource
+		// changed since the partial file was written
+		if offset > 0 {
+			if err := f.Truncate(0); err != nil {
+				return true, offset, validator, err
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return true, offset, validator, err
+			}
+			offset = 0
+		}
+		validator = responseValidator(res)
+		if err := d.saveValidator(validator); err != nil {
+			return true, offset, validator, err
+		}
+	case 416:
+		// Nothing left to fetch if the partial file is already whole
+		if _, _, size, err := parseContentRange(res.Header["Content-Range"]); err == nil && size == offset {
+			return true, offset, validator, nil
+		}
+		return true, offset, validator, fmt.Errorf("tritonhttp: cannot resume %v at %v: %v", d.url, offset, res.StatusCode)
+	default:
+		return true, offset, validator, fmt.Errorf("tritonhttp: downloading %v: %v", d.url, res.StatusCode)
+	}
+
+	n, err := io.Copy(f, res.BodyReader)
+	offset += n
+	if err != nil {
+		if validator == "" {
+			// Without a validator the partial data cannot be trusted
+			// to match what a resumed request would return
+			offset = 0
+			if terr := f.Truncate(0); terr != nil {
+				return true, offset, validator, terr
+			}
+			if _, serr := f.Seek(0, io.SeekStart); serr != nil {
+				return true, offset, validator, serr
+			}
+		}
+		return false, offset, validator, err
+	}
+	return true, offset, validator, nil
+}
+
+// saveValidator records the validator of the partial file, so a later
+// call can resume it.
+func (d *download) saveValidator(validator string) error {
+	if validator == "" {
+		err := os.Remove(d.path + downloadValidatorSuffix)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(d.path+downloadValidatorSuffix, []byte(validator), 0644)
+}
+
+// segmented downloads the resource in parallel ranges if the server
+// supports them and it is large enough, and in one stream otherwise.
+func (d *download) segmented() error {
+	req, err := d.newRequest()
+	if err != nil {
+		return err
+	}
+	req.Header["Range"] = "bytes=0-0"
+	res, err := d.c.DoContext(d.ctx, req)
+	if err != nil {
+		return err
+	}
+	_ = res.Close()
+	_, _, size, err := parseContentRange(res.Header["Content-Range"])
+	validator := responseValidator(res)
+	if res.StatusCode != 206 || err != nil || size < 0 || validator == "" {
+		return d.fresh()
+	}
+	segments := int64(d.opts.Segments)
+	if max := size / d.minSegmentSize(); segments > max {
+		segments = max
+	}
+	if segments < 2 {
+		return d.fresh()
+	}
+
+	f, err := os.Create(d.path + partialDownloadSuffix)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for i := int64(0); i < segments; i++ {
+		start, end := size*i/segments, size*(i+1)/segments-1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.fetchSegment(ctx, f, start, end, validator); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		// Report the first failure rather than the cancellations it caused
+		return errs[0]
+	}
+	return nil
+}
+
+// fresh discards any partial file and downloads in one stream.
+func (d *download) fresh() error {
+	_ = os.Remove(d.path + partialDownloadSuffix)
+	_ = os.Remove(d.path + downloadValidatorSuffix)
+	return d.stream()
+}
+
+// fetchSegment writes the bytes start to end, inclusive, of the version
+// of the resource named by validator at the same offsets of f.
+func (d *download) fetchSegment(ctx context.Context, f *os.File, start, end int64, validator string) error {
+	for attempt := 0; ; attempt++ {
+		req, err := d.newRequest()
+		if err != nil {
+			return err
+		}
+		req.Header["Range"] = fmt.Sprintf("bytes=%d-%d", start, end)
+		req.Header["If-Range"] = validator
+		res, err := d.c.DoContext(ctx, req)
+		if err == nil {
+			var n int64
+			n, err = d.copySegment(res, f, start, end, validator)
+			start += n
+			_ = res.Close()
+			if err == nil {
+				return nil
+			}
+		}
+		if errors.Is(err, ErrDownloadChanged) || attempt >= d.retries() || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// copySegment checks that res answers the range start to end of the
+// version named by validator, and copies its body to f, returning the
+// number of bytes written.
+func (d *download) copySegment(res *Response, f *os.File, start, end int64, validator string) (int64, error) {
+	if res.StatusCode == 200 {
+		return 0, ErrDownloadChanged
+	}
+	if res.StatusCode != 206 {
+		return 0, fmt.Errorf("tritonhttp: downloading %v: %v", d.url, res.StatusCode)
+	}
+	if v := responseValidator(res); v != validator {
+		return 0, ErrDownloadChanged
+	}
+	first, last, _, err := parseContentRange(res.Header["Content-Range"])
+	if err != nil || first != start || last != end {
+		return 0, fmt.Errorf("tritonhttp: unexpected Content-Range %q for bytes %v-%v",
+			res.Header["Content-Range"], start, end)
+	}
+	return io.Copy(io.NewOffsetWriter(f, start), io.LimitReader(res.BodyReader, end-start+1))
+}
+
+// responseValidator returns what to send as If-Range to get the same
+// version of the resource as res: its strong ETag, else its
+// Last-Modified date, else "".
+func responseValidator(res *Response) string {
+	if etag := res.Header["Etag"]; etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return res.Header["Last-Modified"]
+}
+
+// parseContentRange parses a Content-Range header value such as
+// "bytes 0-99/1000" or "bytes */1000". A missing range is returned
+// as -1, -1 and an unknown size as -1.
+func parseContentRange(s string) (start, end, size int64, err error) {
+	bad := func() (int64, int64, int64, error) {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", s)
+	}
+	rng, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return bad()
+	}
+	rng, total, ok := strings.Cut(rng, "/")
+	if !ok {
+		return bad()
+	}
+	size = -1
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil || size < 0 {
+			return bad()
+		}
+	}
+	if rng == "*" {
+		return -1, -1, size, nil
+	}
+	first, last, ok := strings.Cut(rng, "-")
+	if !ok {
+		return bad()
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 {
+		return bad()
+	}
+	if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start || (size >= 0 && end >= size) {
+		return bad()
+	}
+	return start, end, size, nil
+}
@@ -0,0 +1,235 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// rangeServer serves content with the given ETag, honoring Range and
+// If-Range, and records the Range of every request. The first cuts
+// responses stop halfway through their body and close the connection.
+type rangeServer struct {
+	mu      sync.Mutex
+	content []byte
+	etag    string
+	ranges  []string
+	cuts    atomic.Int32
+}
+
+func (rs *rangeServer) start(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go rs.serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (rs *rangeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for {
+		req, _, err := ReadRequest(br)
+		if err != nil {
+			return
+		}
+		rs.mu.Lock()
+		content, etag := rs.content, rs.etag
+		rs.ranges = append(rs.ranges, req.Header["Range"])
+		rs.mu.Unlock()
+
+		status, body, extra := "200 OK", content, ""
+		rng, ok := strings.CutPrefix(req.Header["Range"], "bytes=")
+		if ok && (req.Header["If-Range"] == "" || req.Header["If-Range"] == etag) {
+			first, last, _ := strings.Cut(rng, "-")
+			start, _ := strconv.Atoi(first)
+			end := len(content) - 1
+			if last != "" {
+				end, _ = strconv.Atoi(last)
+			}
+			if start >= len(content) {
+				fmt.Fprintf(conn, "HTTP/1.1 416 Range Not Satisfiable\r\nContent-Range: bytes */%d\r\nContent-Length: 0\r\n\r\n", len(content))
+				continue
+			}
+			status, body = "206 Partial Content", content[start:end+1]
+			extra = fmt.Sprintf("Content-Range: bytes %d-%d/%d\r\n", start, end, len(content))
+		}
+		fmt.Fprintf(conn, "HTTP/1.1 %v\r\nETag: %v\r\n%vContent-Length: %d\r\n\r\n", status, etag, extra, len(body))
+		if rs.cuts.Add(-1) >= 0 {
+			conn.Write(body[:len(body)/2])
+			return
+		}
+		conn.Write(body)
+	}
+}
+
+func (rs *rangeServer) requests() []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]string(nil), rs.ranges...)
+}
+
+func TestDownloadFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	check := func(t *testing.T, path string) {
+		t.Helper()
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("downloaded %v bytes, want the %v bytes of content", len(got), len(content))
+		}
+		if _, err := os.Stat(path + partialDownloadSuffix); !os.IsNotExist(err) {
+			t.Fatalf("partial file left behind: %v", err)
+		}
+	}
+	c := &Client{Transport: &Transport{}}
+
+	t.Run("ResumeInterrupted", func(t *testing.T) {
+		rs := &rangeServer{content: content, etag: `"v1"`}
+		rs.cuts.Store(2)
+		addr := rs.start(t)
+		path := filepath.Join(t.TempDir(), "file")
+		if err := c.DownloadFile(context.Background(), "http://"+addr+"/file", path, nil); err != nil {
+			t.Fatal(err)
+		}
+		check(t, path)
+		half, quarter := len(content)/2, len(content)/2+len(content)/4
+		want := []string{"", fmt.Sprintf("bytes=%d-", half), fmt.Sprintf("bytes=%d-", quarter)}
+		if got := rs.requests(); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("ranges got: %q, want: %q", got, want)
+		}
+	})
+
+	t.Run("GiveUp", func(t *testing.T) {
+		rs := &rangeServer{content: content, etag: `"v1"`}
+		rs.cuts.Store(10)
+		addr := rs.start(t)
+		path := filepath.Join(t.TempDir(), "file")
+		err := c.DownloadFile(context.Background(), "http://"+addr+"/file", path, &DownloadOptions{Retries: 1})
+		if err == nil {
+			t.Fatal("got no error")
+		}
+		if got := len(rs.requests()); got != 2 {
+			t.Fatalf("made %v requests, want: 2", got)
+		}
+
+		// A later call picks up where this one stopped
+		rs.cuts.Store(0)
+		if err := c.DownloadFile(context.Background(), "http://"+addr+"/file", path, nil); err != nil {
+			t.Fatal(err)
+		}
+		check(t, path)
+		if got := rs.requests(); !strings.HasPrefix(got[2], "bytes=") {
+			t.Fatalf("later call got range: %q, want a resumed range", got[2])
+		}
+	})
+
+	t.Run("ChangedSinceLastCall", func(t *testing.T) {
+		rs := &rangeServer{content: content, etag: `"v2"`}
+		addr := rs.start(t)
+		path := filepath.Join(t.TempDir(), "file")
+		os.WriteFile(path+partialDownloadSuffix, []byte("stale data of v1"), 0644)
+		os.WriteFile(path+downloadValidatorSuffix, []byte(`"v1"`), 0644)
+		if err := c.DownloadFile(context.Background(), "http://"+addr+"/file", path, nil); err != nil {
+			t.Fatal(err)
+		}
+		check(t, path)
+	})
+
+	t.Run("Segmented", func(t *testing.T) {
+		rs := &rangeServer{content: content, etag: `"v1"`}
+		addr := rs.start(t)
+		path := filepath.Join(t.TempDir(), "file")
+		opts := &DownloadOptions{Segments: 4, MinSegmentSize: 1000}
+		if err := c.DownloadFile(context.Background(), "http://"+addr+"/file", path, opts); err != nil {
+			t.Fatal(err)
+		}
+		check(t, path)
+		if got := len(rs.requests()); got != 5 {
+			t.Fatalf("made %v requests, want a probe and 4 segments", got)
+		}
+	})
+
+	t.Run("SegmentedResumesSegments", func(t *testing.T) {
+		rs := &rangeServer{content: content, etag: `"v1"`}
+		addr := rs.start(t)
+		rs.cuts.Store(1) // the probe is cut short, harmlessly
+		path := filepath.Join(t.TempDir(), "file")
+		opts := &DownloadOptions{Segments: 4, MinSegmentSize: 1000}
+		if err := c.DownloadFile(context.Background(), "http://"+addr+"/file", path, opts); err != nil {
+			t.Fatal(err)
+		}
+		check(t, path)
+	})
+
+	t.Run("SegmentedChanged", func(t *testing.T) {
+		rs := &rangeServer{content: content, etag: `"v1"`}
+		addr := rs.start(t)
+		path := filepath.Join(t.TempDir(), "file")
+		var dials atomic.Int32
+		tr := &Transport{MaxIdleConnsPerHost: -1}
+		tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			// Change the resource once the probe has been answered
+			if dials.Add(1) > 1 {
+				rs.mu.Lock()
+				rs.etag = `"v2"`
+				rs.mu.Unlock()
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		}
+		c := &Client{Transport: tr}
+		opts := &DownloadOptions{Segments: 2, MinSegmentSize: 1000}
+		err := c.DownloadFile(context.Background(), "http://"+addr+"/file", path, opts)
+		if !errors.Is(err, ErrDownloadChanged) {
+			t.Fatalf("got: %v, want: %v", err, ErrDownloadChanged)
+		}
+	})
+}
+
+func TestParseContentRange(t *testing.T) {
+	var tests = []struct {
+		in               string
+		start, end, size int64
+		wantErr          bool
+	}{
+		{"bytes 0-99/1000", 0, 99, 1000, false},
+		{"bytes 10-10/*", 10, 10, -1, false},
+		{"bytes */1000", -1, -1, 1000, false},
+		{"bytes 0-1000/1000", 0, 0, 0, true},
+		{"bytes 5-4/10", 0, 0, 0, true},
+		{"items 0-1/2", 0, 0, 0, true},
+		{"bytes 0-1", 0, 0, 0, true},
+		{"", 0, 0, 0, true},
+	}
+	for _, test := range tests {
+		start, end, size, err := parseContentRange(test.in)
+		if (err != nil) != test.wantErr {
+			t.Fatalf("%q: got error: %v, want error: %v", test.in, err, test.wantErr)
+		}
+		if !test.wantErr && (start != test.start || end != test.end || size != test.size) {
+			t.Fatalf("%q: got: %v %v %v, want: %v %v %v", test.in, start, end, size, test.start, test.end, test.size)
+		}
+	}
+}
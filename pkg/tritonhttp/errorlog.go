@@ -0,0 +1,27 @@
+package tritonhttp
+
+import (
+	"net"
+	"runtime/debug"
+)
+
+// errorLog returns the Logger errors are reported to:
+// ErrorLog if set, Logger otherwise.
+func (s *Server) errorLog() Logger {
+	if s.ErrorLog != nil {
+		return s.ErrorLog
+	}
+	return s.logger()
+}
+
+// recoverPanic reports a panic while serving conn to the error log,
+// along with the stack of the panicking goroutine, and closes conn.
+// It must be deferred directly.
+func (s *Server) recoverPanic(conn net.Conn) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	s.errorLog().Errorf("Panic serving %v: %v\n%s", conn.RemoteAddr(), v, debug.Stack())
+	_ = conn.Close()
+}
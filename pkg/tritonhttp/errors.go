@@ -0,0 +1,75 @@
+package tritonhttp
+
+import "errors"
+
+// The errors of reading a malformed or unacceptable request, which
+// those ReadRequest returns wrap along with the details, to be told
+// apart with errors.Is and answered with the status StatusFromError
+// maps them to.
+var (
+	// ErrMalformedRequestLine is that of a request line not made of a
+	// method, a target and a protocol separated by single spaces.
+	ErrMalformedRequestLine = errors.New("malformed request line")
+
+	// ErrUnsupportedMethod is that of a request with a standard method
+	// other than GET.
+	ErrUnsupportedMethod = errors.New("unsupported method")
+
+	// ErrInvalidTarget is that of a request target that is not a path,
+	// or one with characters, escapes or dot segments it cannot have.
+	ErrInvalidTarget = errors.New("invalid request target")
+
+	// ErrURITooLong is that of a request target, or request line,
+	// longer than the Limits allow.
+	ErrURITooLong = errors.New("request target too long")
+
+	// ErrUnsupportedVersion is that of a request of another protocol
+	// version than HTTP/1.1.
+	ErrUnsupportedVersion = errors.New("unsupported HTTP version")
+
+	// ErrHeaderTooLarge is that of request headers taking more bytes,
+	// or lines, than the Limits allow.
+	ErrHeaderTooLarge = errors.New("request headers too large")
+
+	// ErrMalformedHeader is that of a header line that is not a valid
+	// "Key: value".
+	ErrMalformedHeader = errors.New("malformed header")
+
+	// ErrMissingHost is that of a request without a Host header.
+	ErrMissingHost = errors.New("missing Host header")
+
+	// ErrInvalidContentLength is that of a Content-Length that is not
+	// a count of bytes.
+	ErrInvalidContentLength = errors.New("invalid Content-Length")
+
+	// ErrBodyTooLarge is that of a request body longer than the Limits
+	// allow.
+	ErrBodyTooLarge = errors.New("request body too large")
+
+	// ErrUnsupportedTransferEncoding is that of a request with a
+	// Transfer-Encoding, whose body the server cannot frame.
+	ErrUnsupportedTransferEncoding = errors.New("unsupported Transfer-Encoding")
+)
+
+// StatusFromError returns the status code of the response to a request
+// that could not be read because of err: e.g. 405 Method Not Allowed
+// for ErrUnsupportedMethod, or 400 Bad Request for errors it does not
+// know of.
+func StatusFromError(err error) int {
+	switch {
+	case errors.Is(err, ErrUnsupportedMethod):
+		return statusMethodNotAllowed
+	case errors.Is(err, ErrURITooLong):
+		return statusURITooLong
+	case errors.Is(err, ErrUnsupportedVersion):
+		return statusHTTPVersionNotSupported
+	case errors.Is(err, ErrHeaderTooLarge):
+		return statusRequestHeaderFieldsTooLarge
+	case errors.Is(err, ErrBodyTooLarge):
+		return statusPayloadTooLarge
+	case errors.Is(err, ErrUnsupportedTransferEncoding):
+		return statusNotImplemented
+	default:
+		return statusBadRequest
+	}
+}
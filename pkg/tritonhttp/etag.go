@@ -0,0 +1,118 @@
+package tritonhttp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// ETagFunc returns the entity tag of a file, described by fi and opened
+// as f, e.g. `"5f1c2a-3e8"`, the ETag of the responses serving it. It
+// may read f, but must leave its offset at the start.
+type ETagFunc func(fi fs.FileInfo, f fs.File) (string, error)
+
+// ModTimeETag is the ETagFunc of the modification time and size of a
+// file, which changes whenever it is rewritten, at no cost.
+func ModTimeETag(fi fs.FileInfo, f fs.File) (string, error) {
+	// Built on the stack, as every file served is tagged
+	buf := make([]byte, 0, 36)
+	buf = append(buf, '"')
+	buf = strconv.AppendInt(buf, fi.ModTime().UnixNano(), 16)
+	buf = append(buf, '-')
+	buf = strconv.AppendInt(buf, fi.Size(), 16)
+	return string(append(buf, '"')), nil
+}
+
+// ContentHashETag is the ETagFunc of the SHA-256 hash of the content of
+// a file, the same on every server holding a copy of it whatever its
+// modification time, at the cost of reading it for every response.
+func ContentHashETag(fi fs.FileInfo, f fs.File) (string, error) {
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		return "", fmt.Errorf("%v cannot be rewound once hashed", fi.Name())
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, rs); err != nil {
+		return "", err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return `"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:18]) + `"`, nil
+}
+
+var etagNames = map[string]ETagFunc{
+	"modtime": ModTimeETag,
+	"content": ContentHashETag,
+}
+
+// ParseETag returns the ETagFunc named s: "modtime" or "", for
+// ModTimeETag, or "content", for ContentHashETag.
+func ParseETag(s string) (ETagFunc, error) {
+	if s == "" {
+		return ModTimeETag, nil
+	}
+	if fn, ok := etagNames[strings.ToLower(s)]; ok {
+		return fn, nil
+	}
+	return nil, fmt.Errorf("unknown entity tag %q", s)
+}
+
+// setETag sets the ETag of res, a 200 OK serving the file described by
+// fi and opened as f, with ETag, or ModTimeETag if it is nil. A file
+// whose tag cannot be had is served without one.
+func (s *Server) setETag(res *Response, fi fs.FileInfo, f fs.File) {
+	etag := s.ETag
+	if etag == nil {
+		etag = ModTimeETag
+	}
+	tag, err := etag(fi, f)
+	if err != nil {
+		s.errorLog().Warnf("Failed to tag %v: %v", res.FilePath, err)
+		return
+	}
+	res.Header["Etag"] = tag
+}
+
+// encodedETag returns tag, an entity tag, as that of the representation
+// of the same file encoded with coding, which must differ from it.
+func encodedETag(tag, coding string) string {
+	if !isETag(tag) {
+		return tag
+	}
+	return strings.TrimSuffix(tag, `"`) + "-" + coding + `"`
+}
+
+// ifMatch reports whether the If-Match header v, a list of entity tags
+// or "*", matches etag by the strong comparison; "*" matches any
+// existing file, tagged or not.
+func ifMatch(v, etag string, exists bool) bool {
+	if strings.TrimSpace(v) == "*" {
+		return exists
+	}
+	for _, tag := range strings.Split(v, ",") {
+		if etagStrongMatch(strings.TrimSpace(tag), etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// ifNoneMatch reports whether the If-None-Match header v, a list of
+// entity tags or "*", matches etag by the weak comparison; "*" matches
+// any existing file, tagged or not.
+func ifNoneMatch(v, etag string, exists bool) bool {
+	if strings.TrimSpace(v) == "*" {
+		return exists
+	}
+	for _, tag := range strings.Split(v, ",") {
+		if etagWeakMatch(strings.TrimSpace(tag), etag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package tritonhttp
+
+import (
+	"io"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestETagFuncs(t *testing.T) {
+	modTime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("hello"), ModTime: modTime},
+		"b.txt": {Data: []byte("hello"), ModTime: modTime.Add(time.Hour)},
+		"c.txt": {Data: []byte("world"), ModTime: modTime},
+	}
+	tags := func(etag ETagFunc) map[string]string {
+		got := map[string]string{}
+		for name := range fsys {
+			f, _ := fsys.Open(name)
+			fi, _ := f.Stat()
+			tag, err := etag(fi, f)
+			if err != nil || !isETag(tag) {
+				t.Fatalf("tag of %v got %q, %v", name, tag, err)
+			}
+			// The file is left to be served from the start
+			if b, _ := io.ReadAll(f); string(b) != string(fsys[name].Data) {
+				t.Errorf("after tagging %v read %q", name, b)
+			}
+			f.Close()
+			got[name] = tag
+		}
+		return got
+	}
+
+	mod := tags(ModTimeETag)
+	if mod["a.txt"] != `"16c65510d4bb3200-5"` || mod["a.txt"] == mod["b.txt"] {
+		t.Errorf("ModTimeETag got %v", mod)
+	}
+	content := tags(ContentHashETag)
+	if content["a.txt"] != content["b.txt"] || content["a.txt"] == content["c.txt"] {
+		t.Errorf("ContentHashETag got %v", content)
+	}
+}
+
+func TestParseETag(t *testing.T) {
+	for _, s := range []string{"", "modtime", "content", "Content"} {
+		if _, err := ParseETag(s); err != nil {
+			t.Errorf("ParseETag(%q) got %v", s, err)
+		}
+	}
+	if _, err := ParseETag("md5"); err == nil {
+		t.Error(`ParseETag("md5") got no error`)
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	const data = "0123456789"
+	modTime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := &Server{
+		FS:       MountFS(fstest.MapFS{"data.txt": {Data: []byte(data), ModTime: modTime}}, "/srv"),
+		DocRoot:  "/srv",
+		ETag:     ContentHashETag,
+		ErrorLog: NewLogger(nil, LevelError),
+	}
+	addr, _ := startTestServer(t, s)
+	etag := exchangeRaw(t, addr, "GET /data.txt HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 1)[0].Header["Etag"]
+	if !isETag(etag) {
+		t.Fatalf("got ETag %q", etag)
+	}
+
+	var tests = []struct {
+		name       string
+		headers    string
+		wantStatus int
+	}{
+		{"Match", "If-None-Match: " + etag + "\r\n", 304},
+		{"Weak", "If-None-Match: W/" + etag + "\r\n", 304},
+		{"List", `If-None-Match: "other", ` + etag + "\r\n", 304},
+		{"Star", "If-None-Match: *\r\n", 304},
+		{"Other", `If-None-Match: "other"` + "\r\n", 200},
+		// If-None-Match takes precedence over If-Modified-Since
+		{"Modified", "If-None-Match: " + etag + "\r\nIf-Modified-Since: Sat, 01 Jan 2022 00:00:00 GMT\r\n", 304},
+		{"Unmodified", "If-None-Match: \"other\"\r\nIf-Modified-Since: Sun, 02 Jan 2022 03:04:05 GMT\r\n", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "GET /data.txt HTTP/1.1\r\nHost: test\r\n" + tt.headers + "\r\n"
+			res := exchangeRaw(t, addr, raw+"GET /data.txt HTTP/1.1\r\nHost: test\r\n\r\n", 2)
+			body, _ := io.ReadAll(res[0].BodyReader)
+			if res[0].StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res[0].StatusCode, tt.wantStatus)
+			}
+			if got := res[0].Header["Etag"]; got != etag {
+				t.Errorf("got ETag %q, want %q", got, etag)
+			}
+			if tt.wantStatus == 304 && len(body) != 0 {
+				t.Errorf("got body %q, want none", body)
+			}
+			if next, _ := io.ReadAll(res[1].BodyReader); res[1].StatusCode != 200 || string(next) != data {
+				t.Errorf("got next %v %q, want 200 %q", res[1].StatusCode, next, data)
+			}
+		})
+	}
+}
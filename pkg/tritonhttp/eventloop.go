@@ -0,0 +1,177 @@
+package tritonhttp
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// parkTick is how often the parked connections are checked for their
+// idle timeout, and for having been closed meanwhile, e.g. by
+// Shutdown.
+const parkTick = 50 * time.Millisecond
+
+// parkedConns holds the idle keep-alive connections of a Server with
+// EventLoop set, by file descriptor, while its poller waits for them
+// to be readable.
+type parkedConns struct {
+	once   sync.Once
+	mu     sync.Mutex
+	poller *netPoller
+	conns  map[int]*parkedConn
+}
+
+// parkedConn is a connection waiting in the poller.
+type parkedConn struct {
+	c        *serverConn
+	rc       syscall.RawConn
+	deadline time.Time
+}
+
+// netConner is a net.Conn wrapping another, e.g. to record what goes
+// through it.
+type netConner interface {
+	netConn() net.Conn
+}
+
+// rawConn returns the raw TCP connection under conn, if any.
+func rawConn(conn net.Conn) (syscall.RawConn, bool) {
+	for {
+		switch c := conn.(type) {
+		case netConner:
+			conn = c.netConn()
+		case *net.TCPConn:
+			rc, err := c.SyscallConn()
+			return rc, err == nil
+		default:
+			return nil, false
+		}
+	}
+}
+
+// park hands c, idle, over to the poller, to be served again by a new
+// goroutine once it is readable, and reports whether it did. It does
+// not for connections with pipelined bytes already buffered, without
+// a file descriptor, or if the system has no poller.
+func (pc *parkedConns) park(c *serverConn) bool {
+	if c.br.Buffered() > 0 {
+		return false
+	}
+	rc, ok := rawConn(c.tracked)
+	if !ok {
+		return false
+	}
+	s := c.s
+	pc.once.Do(func() { pc.start(s) })
+	fd := -1
+	if err := rc.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return false
+	}
+
+	s.setState(c.tracked, StateIdle)
+	putBufioReader(c.br)
+	c.br = nil
+	pc.mu.Lock()
+	if pc.poller == nil {
+		pc.mu.Unlock()
+		c.br = getBufioReader(c.conn)
+		return false
+	}
+	// A descriptor parked already was closed, and reused for c
+	stale := pc.conns[fd]
+	pc.conns[fd] = &parkedConn{c: c, rc: rc, deadline: time.Now().Add(s.current().Limits.withDefaults().ReadTimeout)}
+	err := pc.poller.add(fd)
+	if err != nil {
+		delete(pc.conns, fd)
+	}
+	pc.mu.Unlock()
+	if stale != nil {
+		stale.c.close()
+	}
+	if err != nil {
+		s.errorLog().Warnf("Failed to park connection %v: %v", c.conn.RemoteAddr(), err)
+		c.br = getBufioReader(c.conn)
+		return false
+	}
+	return true
+}
+
+// start starts the poller of s, if the system has one.
+func (pc *parkedConns) start(s *Server) {
+	p, err := newNetPoller()
+	if err != nil {
+		s.errorLog().Warnf("Event loop unavailable, serving idle connections with goroutines: %v", err)
+		return
+	}
+	pc.mu.Lock()
+	pc.poller = p
+	pc.conns = make(map[int]*parkedConn)
+	pc.mu.Unlock()
+	go pc.run(s, p)
+}
+
+// run waits for the parked connections of s to be readable, resuming
+// them, and closes those timing out, until s shuts down.
+func (pc *parkedConns) run(s *Server, p *netPoller) {
+	for {
+		if err := p.wait(parkTick, pc.ready); err != nil {
+			s.errorLog().Errorf("Event loop: %v", err)
+			time.Sleep(parkTick)
+		}
+		if done := pc.sweep(s); done {
+			_ = p.close()
+			return
+		}
+	}
+}
+
+// ready resumes the connection parked under fd, which is readable.
+func (pc *parkedConns) ready(fd int) {
+	pc.mu.Lock()
+	e := pc.conns[fd]
+	delete(pc.conns, fd)
+	if e != nil {
+		_ = pc.poller.remove(fd)
+	}
+	pc.mu.Unlock()
+	if e != nil {
+		go e.c.resume()
+	}
+}
+
+// sweep closes the parked connections that timed out, were closed
+// meanwhile, or all of them once s is shutting down, and reports
+// whether the poller is done for, s having shut down.
+func (pc *parkedConns) sweep(s *Server) bool {
+	now := time.Now()
+	closing := s.shuttingDown()
+	var expired, closed []*parkedConn
+	pc.mu.Lock()
+	for fd, e := range pc.conns {
+		switch {
+		case closing || e.rc.Control(func(uintptr) {}) != nil:
+			closed = append(closed, e)
+		case now.After(e.deadline):
+			expired = append(expired, e)
+		default:
+			continue
+		}
+		delete(pc.conns, fd)
+		_ = pc.poller.remove(fd)
+	}
+	if closing {
+		pc.poller = nil
+	}
+	pc.mu.Unlock()
+
+	for _, e := range expired {
+		s.tracker.timeout()
+		s.logger().Debugf("Connection to %v timed out", e.c.conn.RemoteAddr())
+		e.c.close()
+	}
+	for _, e := range closed {
+		e.c.close()
+	}
+	return closing
+}
@@ -0,0 +1,150 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// startEventLoopServer starts s with EventLoop set, serving a.txt,
+// skipping the test on systems without a poller.
+func startEventLoopServer(t *testing.T, s *Server) (string, <-chan error) {
+	switch runtime.GOOS {
+	case "linux", "darwin", "freebsd", "netbsd", "openbsd", "dragonfly":
+	default:
+		t.Skipf("no event loop on %v", runtime.GOOS)
+	}
+	s.EventLoop = true
+	s.FS = MountFS(fstest.MapFS{"a.txt": {Data: []byte("a")}}, "/srv")
+	s.DocRoot = "/srv"
+	s.ErrorLog = NewLogger(nil, LevelError)
+	return startTestServer(t, s)
+}
+
+// waitParked waits for s to have n connections parked.
+func waitParked(t *testing.T, s *Server, n int) {
+	t.Helper()
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		s.parked.mu.Lock()
+		got := len(s.parked.conns)
+		s.parked.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("got %v connections parked, want %v", got, n)
+		}
+	}
+}
+
+func TestEventLoopKeepAlive(t *testing.T) {
+	s := &Server{}
+	addr, _ := startEventLoopServer(t, s)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for i := 0; i < 3; i++ {
+		if _, err := io.WriteString(conn, "GET /a.txt HTTP/1.1\r\nHost: test\r\n\r\n"); err != nil {
+			t.Fatal(err)
+		}
+		res, err := ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("request %v: %v", i, err)
+		}
+		body, _ := io.ReadAll(res.BodyReader)
+		if res.StatusCode != 200 || string(body) != "a" {
+			t.Fatalf("request %v: got %v %q, want 200 \"a\"", i, res.StatusCode, body)
+		}
+		waitParked(t, s, 1)
+		if st := s.Stats(); st.IdleConns != 1 || st.ActiveConns != 0 {
+			t.Fatalf("request %v: got %v idle, %v active, want the conn idle", i, st.IdleConns, st.ActiveConns)
+		}
+	}
+
+	// Pipelined requests are served without parking in between
+	raw := "GET /a.txt HTTP/1.1\r\nHost: test\r\n\r\nGET /a.txt HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		res, err := ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("pipelined request %v: %v", i, err)
+		}
+		io.Copy(io.Discard, res.BodyReader)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("read after Connection: close got: %v, want: EOF", err)
+	}
+}
+
+func TestEventLoopTimeout(t *testing.T) {
+	s := &Server{Limits: Limits{ReadTimeout: 100 * time.Millisecond}}
+	addr, _ := startEventLoopServer(t, s)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET /a.txt HTTP/1.1\r\nHost: test\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	res, err := ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.BodyReader)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("read of idle conn got: %v, want: EOF", err)
+	}
+	waitParked(t, s, 0)
+	if st := s.Stats(); st.Timeouts != 1 {
+		t.Errorf("got %v timeouts, want 1", st.Timeouts)
+	}
+}
+
+func TestEventLoopShutdown(t *testing.T) {
+	s := &Server{}
+	addr, done := startEventLoopServer(t, s)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET /a.txt HTTP/1.1\r\nHost: test\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	res, err := ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.BodyReader)
+	waitParked(t, s, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown got error: %v", err)
+	}
+	if err := <-done; err != ErrServerClosed {
+		t.Fatalf("Serve got: %v, want: %v", err, ErrServerClosed)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("parked conn read got: %v, want: EOF", err)
+	}
+	waitParked(t, s, 0)
+}
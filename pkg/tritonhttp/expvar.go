@@ -0,0 +1,50 @@
+package tritonhttp
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// expvarMu serializes PublishExpvar, whose check of a name and
+// publishing under it must be atomic, expvar.Publish panicking on a
+// name already published.
+var expvarMu sync.Mutex
+
+// PublishExpvar publishes the counters of s as the expvar variable name,
+// e.g. "tritonhttp", so that they show up in /debug/vars next to the
+// runtime's memstats. It fails if name is already published.
+func (s *Server) PublishExpvar(name string) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar %q is already published", name)
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return s.expvarCounters()
+	}))
+	return nil
+}
+
+// expvarCounters returns the counters published by PublishExpvar.
+func (s *Server) expvarCounters() map[string]int64 {
+	st := s.Stats()
+	counters := map[string]int64{
+		"conns_accepted":     st.AcceptedConns,
+		"conns_rejected":     st.RejectedConns,
+		"conns_closed":       st.ClosedConns,
+		"conns_timeouts":     st.Timeouts,
+		"slow_requests":      st.SlowRequests,
+		"conns_active":       int64(st.ActiveConns),
+		"conns_idle":         int64(st.IdleConns),
+		"conn_goroutines":    int64(st.ActiveConns + st.IdleConns),
+		"access_log_skipped": int64(s.AccessLogSkipped()),
+	}
+	for _, rs := range s.RouteStats() {
+		counters["requests"] += rs.Requests
+		counters["bytes"] += rs.Bytes
+		counters["errors"] += rs.Statuses[4] + rs.Statuses[5]
+		counters["latency_ns"] += int64(rs.Latency)
+	}
+	return counters
+}
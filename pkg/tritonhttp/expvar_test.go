@@ -0,0 +1,62 @@
+package tritonhttp
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var expvarTestRuns int
+
+func TestPublishExpvar(t *testing.T) {
+	s := &Server{}
+	rec := newAccessRecord("127.0.0.1:1234", &Request{URL: "/", Host: "test"}, time.Now())
+	rec.status, rec.bytes = 404, 7
+	s.finishRequest(rec)
+
+	// expvars can't be unpublished, so use a new name on every run
+	expvarTestRuns++
+	name := fmt.Sprintf("tritonhttp_test_%v", expvarTestRuns)
+	if err := s.PublishExpvar(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PublishExpvar(name); err == nil {
+		t.Fatal("got no error publishing twice")
+	}
+
+	var got map[string]int64
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["requests"] != 1 || got["errors"] != 1 || got["bytes"] != 7 {
+		t.Fatalf("got: %v", got)
+	}
+}
+
+func TestPublishExpvarConcurrently(t *testing.T) {
+	expvarTestRuns++
+	name := fmt.Sprintf("tritonhttp_race_%v", expvarTestRuns)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- (&Server{}).PublishExpvar(name)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	published := 0
+	for err := range errs {
+		if err == nil {
+			published++
+		}
+	}
+	if published != 1 {
+		t.Fatalf("published %v times, want once", published)
+	}
+}
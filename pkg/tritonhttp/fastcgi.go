@@ -0,0 +1,419 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFastCGITimeout      = 30 * time.Second
+	defaultFastCGIMaxIdleConns = 2
+
+	// maxFastCGIDrain bounds the output read past the response body
+	// for a connection to be reused.
+	maxFastCGIDrain = 64 << 10
+
+	// FastCGI record types and constants, from the FastCGI 1.0
+	// specification.
+	fcgiVersion         = 1
+	fcgiBeginRequest    = 1
+	fcgiEndRequest      = 3
+	fcgiParams          = 4
+	fcgiStdin           = 5
+	fcgiStdout          = 6
+	fcgiStderr          = 7
+	fcgiResponder       = 1
+	fcgiKeepConn        = 1
+	fcgiRequestID       = 1
+	fcgiMaxContent      = 65535
+	fcgiHeaderLen       = 8
+	fcgiRequestComplete = 0
+)
+
+// FastCGI is a Handler dispatching requests to a FastCGI application
+// server such as php-fpm, e.g. as the Handler of a Route with the
+// Prefix "/" and the Extensions [".php"]. The output of the application
+// is relayed as CGI handles that of its scripts. Connections are kept
+// open and reused across requests.
+type FastCGI struct {
+	// Network and Addr are those of the application server, e.g.
+	// "unix" and "/run/php/php-fpm.sock", or "tcp" and
+	// "127.0.0.1:9000".
+	Network string
+	Addr    string
+
+	// DocRoot is the directory of the scripts as the application server
+	// sees it, prepended to their path in SCRIPT_FILENAME.
+	DocRoot string
+
+	// Extensions are those of the scripts, the rest of the path being
+	// the PATH_INFO, as in "/index.php/users/1"; nil means [".php"].
+	// Requests without one are not found.
+	Extensions []string
+
+	// Params lists additional parameters, as "name=value".
+	Params []string
+
+	// Timeout bounds each request, its output included; 0 means 30s.
+	Timeout time.Duration
+
+	// MaxIdleConns caps the connections kept open for reuse; 0 means 2,
+	// a negative value none.
+	MaxIdleConns int
+
+	// ErrorLog receives the failures to reach the application server
+	// and what the application writes to its standard error. If nil,
+	// they are logged via the log package.
+	ErrorLog Logger
+
+	mu   sync.Mutex
+	idle []net.Conn
+}
+
+// ServeRequest sends req to the application server and returns its
+// response, or a 404 Not Found if it names no script, a 502 Bad Gateway
+// if the application server fails, a 504 Gateway Timeout if it answers
+// too late.
+func (f *FastCGI) ServeRequest(req *Request) *Response {
+	res := &Response{}
+	exts := f.Extensions
+	if exts == nil {
+		exts = []string{".php"}
+	}
+	urlPath, _, _ := strings.Cut(req.URL, "?")
+	script, pathInfo, ok := splitScript(path.Clean(urlPath), exts)
+	if !ok {
+		res.HandleNotFound(req)
+		return res
+	}
+	params := cgiVariables(req, script, strings.TrimSuffix(f.DocRoot, "/")+script, pathInfo)
+	params = append(params, "DOCUMENT_ROOT="+f.DocRoot)
+	if pathInfo != "" {
+		params = append(params, "PATH_TRANSLATED="+strings.TrimSuffix(f.DocRoot, "/")+pathInfo)
+	}
+	params = append(params, f.Params...)
+
+	body, err := f.roundTrip(params, req.Body)
+	var header map[string]string
+	var status int
+	if err == nil {
+		header, status, err = readCGIHeader(body.br)
+		if err != nil {
+			body.discard()
+		}
+	}
+	if err != nil {
+		f.errorLog().Warnf("FastCGI %v to %v: %v", req.URL, f.Addr, err)
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			res.HandleGatewayTimeout(req)
+		} else {
+			res.HandleBadGateway(req)
+		}
+		return res
+	}
+	res.StatusCode = status
+	res.Proto = "HTTP/1.1"
+	res.Header = header
+	res.Request = req
+	res.BodyReader = body
+	if _, framed := header["Content-Length"]; req.Close || !framed {
+		res.Header["Connection"] = "close"
+	}
+	return res
+}
+
+// roundTrip sends a request with params and the body stdin, and returns
+// the output of the application. A reused connection the application
+// server closed meanwhile is replaced once, unless stdin was read.
+func (f *FastCGI) roundTrip(params []string, stdin io.Reader) (*fcgiBody, error) {
+	for {
+		conn, reused, err := f.conn()
+		if err != nil {
+			return nil, err
+		}
+		body, err := f.send(conn, params, stdin)
+		if err == nil {
+			return body, nil
+		}
+		_ = conn.Close()
+		if !reused || stdin != nil {
+			return nil, err
+		}
+	}
+}
+
+// send writes the request on conn, and returns the output once its
+// first record arrives.
+func (f *FastCGI) send(conn net.Conn, params []string, stdin io.Reader) (*fcgiBody, error) {
+	if err := conn.SetDeadline(time.Now().Add(f.timeout())); err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(conn)
+	begin := []byte{0, fcgiResponder, fcgiKeepConn, 0, 0, 0, 0, 0}
+	if err := writeRecord(bw, fcgiBeginRequest, begin); err != nil {
+		return nil, err
+	}
+	if err := writeStream(bw, fcgiParams, strings.NewReader(string(encodeParams(params)))); err != nil {
+		return nil, err
+	}
+	if stdin == nil {
+		stdin = strings.NewReader("")
+	}
+	if err := writeStream(bw, fcgiStdin, stdin); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	body := &fcgiBody{f: f, conn: conn, r: bufio.NewReader(conn)}
+	body.br = bufio.NewReader(fcgiRecords{body})
+	// Detect closed connections before the response is under way
+	if _, err := body.br.Peek(1); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return body, nil
+}
+
+// conn returns an idle connection to the application server, or a
+// new one, reporting which.
+func (f *FastCGI) conn() (net.Conn, bool, error) {
+	f.mu.Lock()
+	if n := len(f.idle); n > 0 {
+		conn := f.idle[n-1]
+		f.idle = f.idle[:n-1]
+		f.mu.Unlock()
+		return conn, true, nil
+	}
+	f.mu.Unlock()
+	network := f.Network
+	if network == "" {
+		network = "tcp"
+	}
+	conn, err := net.DialTimeout(network, f.Addr, f.timeout())
+	return conn, false, err
+}
+
+// release keeps conn for reuse, or closes it beyond MaxIdleConns.
+func (f *FastCGI) release(conn net.Conn) {
+	max := f.MaxIdleConns
+	if max == 0 {
+		max = defaultFastCGIMaxIdleConns
+	}
+	f.mu.Lock()
+	if len(f.idle) < max {
+		f.idle = append(f.idle, conn)
+		conn = nil
+	}
+	f.mu.Unlock()
+	if conn != nil {
+		_ = conn.Close()
+	}
+}
+
+// CloseIdleConnections closes the connections kept for reuse.
+func (f *FastCGI) CloseIdleConnections() {
+	f.mu.Lock()
+	idle := f.idle
+	f.idle = nil
+	f.mu.Unlock()
+	for _, conn := range idle {
+		_ = conn.Close()
+	}
+}
+
+func (f *FastCGI) timeout() time.Duration {
+	if f.Timeout > 0 {
+		return f.Timeout
+	}
+	return defaultFastCGITimeout
+}
+
+func (f *FastCGI) errorLog() Logger {
+	if f.ErrorLog != nil {
+		return f.ErrorLog
+	}
+	return defaultLogger
+}
+
+// fcgiBody is the standard output of a FastCGI request, read through
+// br, its headers first.
+type fcgiBody struct {
+	f    *FastCGI
+	conn net.Conn
+	r    *bufio.Reader // of conn
+	br   *bufio.Reader // of the fcgiBody itself
+
+	left   int // of the current stdout record
+	pad    int // following it
+	done   bool
+	closed bool
+}
+
+func (b *fcgiBody) Read(p []byte) (int, error) { return b.br.Read(p) }
+
+// fcgiRecords reads the standard output of a FastCGI request from its
+// records, logging its standard error, until the end of the request.
+type fcgiRecords struct{ b *fcgiBody }
+
+func (r fcgiRecords) Read(p []byte) (int, error) {
+	b := r.b
+	if b.closed {
+		return 0, os.ErrClosed
+	}
+	for b.left == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		if b.pad > 0 {
+			if _, err := b.r.Discard(b.pad); err != nil {
+				return 0, err
+			}
+			b.pad = 0
+		}
+		if err := b.next(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := b.r.Read(p[:min(len(p), b.left)])
+	b.left -= n
+	return n, err
+}
+
+// next reads records up to the next one with output, or the end of
+// the request.
+func (b *fcgiBody) next() error {
+	var h [fcgiHeaderLen]byte
+	if _, err := io.ReadFull(b.r, h[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if h[0] != fcgiVersion {
+		return fmt.Errorf("FastCGI version %v", h[0])
+	}
+	typ := h[1]
+	n := int(binary.BigEndian.Uint16(h[4:6]))
+	pad := int(h[6])
+	switch typ {
+	case fcgiStdout:
+		b.left, b.pad = n, pad
+		return nil
+	case fcgiStderr:
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(b.r, msg); err != nil {
+			return err
+		}
+		if n > 0 {
+			b.f.errorLog().Warnf("FastCGI %v: %s", b.f.Addr, strings.TrimRight(string(msg), "\n"))
+		}
+	case fcgiEndRequest:
+		end := make([]byte, n)
+		if _, err := io.ReadFull(b.r, end); err != nil {
+			return err
+		}
+		if n < 5 || end[4] != fcgiRequestComplete {
+			return fmt.Errorf("FastCGI request rejected, protocol status %v", end)
+		}
+		b.done = true
+	default:
+		if _, err := b.r.Discard(n); err != nil {
+			return err
+		}
+	}
+	_, err := b.r.Discard(pad)
+	return err
+}
+
+// Close keeps the connection for reuse if the request ends within
+// maxFastCGIDrain bytes of output, or closes it otherwise.
+func (b *fcgiBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	if !b.done {
+		// The records following a body framed by its Content-Length
+		_, _ = io.CopyN(io.Discard, b.br, maxFastCGIDrain)
+	}
+	b.closed = true
+	if b.done && b.left == 0 && b.br.Buffered() == 0 && b.r.Buffered() == 0 {
+		_ = b.conn.SetDeadline(time.Time{})
+		b.f.release(b.conn)
+		return nil
+	}
+	return b.conn.Close()
+}
+
+// discard drops the connection of a response that is not relayed.
+func (b *fcgiBody) discard() {
+	b.closed = true
+	_ = b.conn.Close()
+}
+
+// writeRecord writes a record of type typ holding content to w.
+func writeRecord(w io.Writer, typ byte, content []byte) error {
+	pad := -len(content) & 7
+	h := [fcgiHeaderLen]byte{fcgiVersion, typ, 0, fcgiRequestID, 0, 0, byte(pad), 0}
+	binary.BigEndian.PutUint16(h[4:6], uint16(len(content)))
+	if _, err := w.Write(h[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, pad))
+	return err
+}
+
+// writeStream writes r as a stream of records of type typ to w, ending
+// with an empty one.
+func writeStream(w io.Writer, typ byte, r io.Reader) error {
+	buf := make([]byte, fcgiMaxContent&^7)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := writeRecord(w, typ, buf[:n]); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return writeRecord(w, typ, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// encodeParams encodes params, "name=value", as FastCGI name-value
+// pairs.
+func encodeParams(params []string) []byte {
+	var b []byte
+	for _, p := range params {
+		name, value, _ := strings.Cut(p, "=")
+		b = appendParamLen(b, len(name))
+		b = appendParamLen(b, len(value))
+		b = append(b, name...)
+		b = append(b, value...)
+	}
+	return b
+}
+
+// appendParamLen appends n to b in one byte if it fits in 7 bits, in
+// four with the high bit set otherwise.
+func appendParamLen(b []byte, n int) []byte {
+	if n < 128 {
+		return append(b, byte(n))
+	}
+	return binary.BigEndian.AppendUint32(b, uint32(n)|1<<31)
+}
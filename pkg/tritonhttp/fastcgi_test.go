@@ -0,0 +1,226 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeFPM is a FastCGI application server answering with the
+// parameters and standard input of each request.
+type fakeFPM struct {
+	ln    net.Listener
+	conns atomic.Int32
+}
+
+func startFakeFPM(t *testing.T, network, addr string) *fakeFPM {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	fpm := &fakeFPM{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			fpm.conns.Add(1)
+			go fpm.serve(conn)
+		}
+	}()
+	return fpm
+}
+
+// readFCGIRecord reads a record from br, returning its type and content.
+func readFCGIRecord(br *bufio.Reader) (byte, []byte, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(br, h[:]); err != nil {
+		return 0, nil, err
+	}
+	content := make([]byte, binary.BigEndian.Uint16(h[4:6]))
+	if _, err := io.ReadFull(br, content); err != nil {
+		return 0, nil, err
+	}
+	_, err := br.Discard(int(h[6]))
+	return h[1], content, err
+}
+
+// decodeParams decodes FastCGI name-value pairs.
+func decodeParams(b []byte) map[string]string {
+	params := map[string]string{}
+	readLen := func() int {
+		if b[0] < 128 {
+			n := int(b[0])
+			b = b[1:]
+			return n
+		}
+		n := int(binary.BigEndian.Uint32(b) &^ (1 << 31))
+		b = b[4:]
+		return n
+	}
+	for len(b) > 0 {
+		nl, vl := readLen(), readLen()
+		params[string(b[:nl])] = string(b[nl : nl+vl])
+		b = b[nl+vl:]
+	}
+	return params
+}
+
+func (fpm *fakeFPM) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for {
+		var keep bool
+		var params, stdin []byte
+		for stdinDone := false; !stdinDone; {
+			typ, content, err := readFCGIRecord(br)
+			if err != nil {
+				return
+			}
+			switch typ {
+			case fcgiBeginRequest:
+				keep = content[2]&fcgiKeepConn != 0
+			case fcgiParams:
+				params = append(params, content...)
+			case fcgiStdin:
+				stdin = append(stdin, content...)
+				stdinDone = len(content) == 0
+			}
+		}
+		p := decodeParams(params)
+		var out string
+		switch p["SCRIPT_NAME"] {
+		case "/slow.php":
+			time.Sleep(time.Second)
+			return
+		case "/missing.php":
+			out = "Status: 404 Not Found\r\nContent-Type: text/plain\r\n\r\nFile not found.\n"
+		default:
+			body := fmt.Sprintf("%v %v %v %v %v stdin=%s",
+				p["REQUEST_METHOD"], p["SCRIPT_FILENAME"], p["PATH_INFO"], p["QUERY_STRING"], p["HTTP_X_TEST"], stdin)
+			out = fmt.Sprintf("Content-Type: text/plain\r\nContent-Length: %v\r\n\r\n%v", len(body), body)
+		}
+		bw := bufio.NewWriter(conn)
+		writeRecord(bw, fcgiStderr, []byte("a warning"))
+		// Split the output across records
+		half := len(out) / 2
+		writeRecord(bw, fcgiStdout, []byte(out[:half]))
+		writeRecord(bw, fcgiStdout, []byte(out[half:]))
+		writeRecord(bw, fcgiStdout, nil)
+		writeRecord(bw, fcgiEndRequest, []byte{0, 0, 0, 0, fcgiRequestComplete, 0, 0, 0})
+		// once.php closes the connection as if the server restarted
+		if bw.Flush() != nil || !keep || p["SCRIPT_NAME"] == "/once.php" {
+			return
+		}
+	}
+}
+
+func TestFastCGI(t *testing.T) {
+	fpm := startFakeFPM(t, "tcp", "127.0.0.1:0")
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "index.html"), []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fcgi := &FastCGI{Addr: fpm.ln.Addr().String(), DocRoot: "/var/www", Timeout: 200 * time.Millisecond}
+	addr, _ := startTestServer(t, &Server{DocRoot: root, Routes: []Route{
+		{Prefix: "/", Extensions: []string{".php"}, Handler: fcgi},
+	}})
+
+	var tests = []struct {
+		name       string
+		raw        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			"script",
+			"GET /app/index.php/users/1?q=1 HTTP/1.1\r\nHost: test\r\nX-Test: yes\r\n\r\n",
+			200,
+			"GET /var/www/app/index.php /users/1 q=1 yes stdin=",
+		},
+		{
+			"request body",
+			"GET /index.php HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\n\r\nhello",
+			200,
+			"GET /var/www/index.php    stdin=hello",
+		},
+		{"static", "GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n", 200, "local"},
+		{"status", "GET /missing.php HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 404, "File not found.\n"},
+		{"timeout", "GET /slow.php HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 504, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := exchangeRaw(t, addr, tt.raw, 1)[0]
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+			body, _ := io.ReadAll(res.BodyReader)
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("got body %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestFastCGIReusesConnections(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "fpm.sock")
+	fpm := startFakeFPM(t, "unix", sock)
+	fcgi := &FastCGI{Network: "unix", Addr: sock, DocRoot: "/var/www"}
+	defer fcgi.CloseIdleConnections()
+
+	for i := 0; i < 3; i++ {
+		req := &Request{Method: "GET", URL: "/index.php", Proto: "HTTP/1.1", Host: "test", Header: map[string]string{}}
+		res := fcgi.ServeRequest(req)
+		body, err := io.ReadAll(io.LimitReader(res.BodyReader, 1<<10))
+		if err != nil || res.StatusCode != 200 {
+			t.Fatalf("request %v: got %v %q, %v", i, res.StatusCode, body, err)
+		}
+		if !strings.HasPrefix(string(body), "GET /var/www/index.php") {
+			t.Errorf("request %v: got body %q", i, body)
+		}
+		res.Close()
+	}
+	if n := fpm.conns.Load(); n != 1 {
+		t.Errorf("got %v connections, want 1", n)
+	}
+
+	// A connection closed by the application server is replaced
+	for _, script := range []string{"/once.php", "/index.php"} {
+		res := fcgi.ServeRequest(&Request{Method: "GET", URL: script, Proto: "HTTP/1.1", Host: "test", Header: map[string]string{}})
+		_, _ = io.ReadAll(io.LimitReader(res.BodyReader, 1<<10))
+		res.Close()
+		if res.StatusCode != 200 {
+			t.Errorf("%v: got status %v", script, res.StatusCode)
+		}
+		// Let the server close the connection
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := fpm.conns.Load(); n != 2 {
+		t.Errorf("got %v connections, want 2", n)
+	}
+}
+
+func TestEncodeParams(t *testing.T) {
+	long := strings.Repeat("x", 200)
+	params := []string{"A=1", "EMPTY=", "LONG=" + long, long + "=v"}
+	got := decodeParams(encodeParams(params))
+	want := map[string]string{"A": "1", "EMPTY": "", "LONG": long, long: "v"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v params, want %v", len(got), len(want))
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("got %.10v=%.10q, want %.10q", k, got[k], v)
+		}
+	}
+}
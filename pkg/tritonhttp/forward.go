@@ -0,0 +1,199 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	defaultProxyRealm       = "TritonHTTP"
+	defaultProxyDialTimeout = 10 * time.Second
+)
+
+// ForwardProxy makes a Server an explicit forward proxy: it relays the
+// absolute-form GETs ("GET http://host/path HTTP/1.1") it receives to
+// their destination, and tunnels the CONNECTs, e.g. for HTTPS, to
+// theirs. Requests in origin form are still served as usual.
+type ForwardProxy struct {
+	// Allow lists the destinations clients may reach, as "host:port"
+	// patterns in path.Match syntax, e.g. "*.example.com:443". Those of
+	// absolute-form GETs default to port 80. Requests to others are
+	// refused with 403 Forbidden; none are allowed if Allow is empty.
+	Allow []string
+
+	// Credentials, if set, maps the users clients must authenticate
+	// as, with Basic Proxy-Authorization, to their passwords. Requests
+	// without valid credentials are refused with 407.
+	Credentials map[string]string
+
+	// Realm is that of the Proxy-Authenticate challenge; "" means
+	// "TritonHTTP".
+	Realm string
+
+	// Transport relays the GETs; nil means that of ReverseProxies.
+	// DialTimeout bounds the dial of CONNECT tunnels; 0 means 10s.
+	Transport   *Transport
+	DialTimeout time.Duration
+
+	// ErrorLog receives the failures to reach destinations. If nil,
+	// they are logged via the log package.
+	ErrorLog Logger
+}
+
+// ServeRequest relays the absolute-form req to its destination, if the
+// client may reach it.
+func (p *ForwardProxy) ServeRequest(req *Request) *Response {
+	u, err := url.Parse(req.URL)
+	if err != nil || u.Host == "" {
+		res := &Response{}
+		res.HandleBadRequest()
+		return res
+	}
+	if res := p.refuse(req, hostPort(u.Host, "80")); res != nil {
+		return res
+	}
+	out := &Request{
+		Method: req.Method,
+		URL:    u.RequestURI(),
+		Proto:  "HTTP/1.1",
+		Header: withoutHopHeaders(req.Header),
+		Host:   u.Host,
+		Scheme: "http",
+		Body:   req.Body,
+	}
+	t := p.Transport
+	if t == nil {
+		t = defaultProxyTransport
+	}
+	return relay(t, req, out, "", p.errorLog())
+}
+
+// connect dials the destination of the CONNECT req. It returns the
+// response to req and, if it is a 200 OK, the connection to tunnel to.
+func (p *ForwardProxy) connect(req *Request) (*Response, net.Conn) {
+	if res := p.refuse(req, req.URL); res != nil {
+		return res, nil
+	}
+	timeout := p.DialTimeout
+	if timeout == 0 {
+		timeout = defaultProxyDialTimeout
+	}
+	conn, err := net.DialTimeout("tcp", req.URL, timeout)
+	res := &Response{}
+	if err != nil {
+		p.errorLog().Warnf("Tunneling to %v: %v", req.URL, err)
+		res.HandleBadGateway(req)
+		return res, nil
+	}
+	res.HandleNotFound(req)
+	res.StatusCode = statusOK
+	return res, conn
+}
+
+// refuse returns the response refusing req, whose destination is
+// hostport, or nil if the client may reach it. It is framed, so that
+// clients refused an absolute-form request can retry with credentials
+// on the same connection; that of a refused CONNECT is closed all the
+// same by serveTunnel, as the bytes meant for the tunnel may follow.
+func (p *ForwardProxy) refuse(req *Request, hostport string) *Response {
+	res := &Response{}
+	if !p.authorized(req.Header["Proxy-Authorization"]) {
+		res.HandleProxyAuthRequired(req, p.Realm)
+	} else if p.allowed(hostport) {
+		return nil
+	} else {
+		p.errorLog().Infof("Refusing to proxy %v to %v", req.RemoteAddr, hostport)
+		res.HandleForbidden(req)
+	}
+	res.Header["Content-Length"] = "0"
+	return res
+}
+
+// allowed reports whether hostport matches a pattern of Allow.
+func (p *ForwardProxy) allowed(hostport string) bool {
+	for _, pattern := range p.Allow {
+		if ok, _ := path.Match(pattern, strings.ToLower(hostport)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// authorized reports whether the Proxy-Authorization header auth holds
+// valid Credentials, or none are needed.
+func (p *ForwardProxy) authorized(auth string) bool {
+	return basicAuthorized(auth, p.Credentials)
+}
+
+func (p *ForwardProxy) errorLog() Logger {
+	if p.ErrorLog != nil {
+		return p.ErrorLog
+	}
+	return defaultLogger
+}
+
+// hostPort returns host with port appended unless it has one.
+func hostPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// serveTunnel answers the CONNECT req read from br on conn and, if the
+// forward proxy accepts it, relays the bytes between conn and the
+// destination until either side is done.
+func (s *Server) serveTunnel(conn net.Conn, br *bufio.Reader, req *Request) {
+	req.RemoteAddr = conn.RemoteAddr().String()
+	s.geoLocate(req)
+	rec := newAccessRecord(req.RemoteAddr, req, time.Now())
+	res, upstream := s.ForwardProxy.connect(req)
+	res.Header["Date"] = FormatTime(s.now())
+	if upstream == nil {
+		// The client may have sent the bytes to tunnel already, which are
+		// no request to read
+		res.Header["Connection"] = "close"
+	}
+	n, err := res.WriteTo(conn)
+	rec.status, rec.bytes = res.StatusCode, n
+	s.finishRequest(rec)
+	if upstream == nil {
+		return
+	}
+	defer upstream.Close()
+	if err != nil {
+		s.errorLog().Warnf("Write error to %v: %v", req.RemoteAddr, err)
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	splice(conn, br, upstream)
+}
+
+// splice copies client, whose reads go through br, to upstream and
+// back until both directions are done. Each side is half-closed when
+// the other is done sending, or closed if it cannot be half-closed.
+func splice(client net.Conn, br *bufio.Reader, upstream net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(upstream, br)
+		closeWrite(upstream)
+		close(done)
+	}()
+	_, _ = io.Copy(client, upstream)
+	closeWrite(client)
+	<-done
+}
+
+// closeWrite half-closes conn if it can, closes it otherwise.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+		return
+	}
+	_ = conn.Close()
+}
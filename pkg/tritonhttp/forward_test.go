@@ -0,0 +1,214 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startForwardProxy serves a doc root holding index.html, forwarding
+// to the loopback address for user "alice", and returns its address.
+func startForwardProxy(t *testing.T) string {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "index.html"), []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := startTestServer(t, &Server{DocRoot: root, ForwardProxy: &ForwardProxy{
+		Allow:       []string{"127.0.0.1:*"},
+		Credentials: map[string]string{"alice": "secret"},
+	}})
+	return addr
+}
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestForwardProxy(t *testing.T) {
+	up := echoUpstream(t)
+	addr := startForwardProxy(t)
+	upHost := strings.TrimPrefix(up.URL, "http://")
+	auth := "Proxy-Authorization: " + basicAuth("alice", "secret") + "\r\n"
+
+	var tests = []struct {
+		name       string
+		raw        string
+		wantStatus int
+		wantBody   string
+		wantHeader map[string]string
+	}{
+		{
+			"absolute form",
+			"GET " + up.URL + "/a?b=c HTTP/1.1\r\nHost: " + upHost + "\r\n" + auth + "\r\n",
+			200,
+			"GET /a?b=c host=" + upHost + " test= proxy-auth= body=",
+			map[string]string{"X-Upstream": "yes"},
+		},
+		{
+			"origin form",
+			"GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n",
+			200,
+			"local",
+			nil,
+		},
+		{
+			"no credentials",
+			"GET " + up.URL + "/a HTTP/1.1\r\nHost: " + upHost + "\r\n\r\n",
+			407,
+			"",
+			map[string]string{"Proxy-Authenticate": `Basic realm="TritonHTTP"`},
+		},
+		{
+			"wrong password",
+			"GET " + up.URL + "/a HTTP/1.1\r\nHost: " + upHost + "\r\nProxy-Authorization: " + basicAuth("alice", "guess") + "\r\n\r\n",
+			407,
+			"",
+			nil,
+		},
+		{
+			"disallowed destination",
+			"GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n" + auth + "\r\n",
+			403,
+			"",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := exchangeRaw(t, addr, tt.raw, 1)[0]
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+			body, _ := io.ReadAll(res.BodyReader)
+			if tt.wantStatus == 200 && string(body) != tt.wantBody {
+				t.Errorf("got body %q, want %q", body, tt.wantBody)
+			}
+			for k, v := range tt.wantHeader {
+				if res.Header[k] != v {
+					t.Errorf("got %v %q, want %q", k, res.Header[k], v)
+				}
+			}
+		})
+	}
+}
+
+// echoTCP listens for connections echoing what they receive.
+func echoTCP(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestForwardProxyConnect(t *testing.T) {
+	target := echoTCP(t)
+	addr := startForwardProxy(t)
+
+	var tests = []struct {
+		name       string
+		target     string
+		auth       string
+		wantStatus int
+	}{
+		{"tunnel", target, basicAuth("alice", "secret"), 200},
+		{"no credentials", target, "", 407},
+		{"disallowed destination", "example.com:443", basicAuth("alice", "secret"), 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			raw := "CONNECT " + tt.target + " HTTP/1.1\r\nHost: " + tt.target + "\r\n"
+			if tt.auth != "" {
+				raw += "Proxy-Authorization: " + tt.auth + "\r\n"
+			}
+			// Bytes sent along with the CONNECT are tunneled too
+			if _, err := io.WriteString(conn, raw+"\r\nping"); err != nil {
+				t.Fatal(err)
+			}
+			br := bufio.NewReader(conn)
+			res, err := ReadResponse(br, &Request{Method: "CONNECT"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != 200 {
+				if _, err := br.Peek(1); err != io.EOF {
+					t.Errorf("connection left open after a refused CONNECT, %v", err)
+				}
+				return
+			}
+			if _, err := io.WriteString(conn, "-pong"); err != nil {
+				t.Fatal(err)
+			}
+			conn.(*net.TCPConn).CloseWrite()
+			got, err := io.ReadAll(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "ping-pong" {
+				t.Errorf("got %q through the tunnel, want %q", got, "ping-pong")
+			}
+		})
+	}
+}
+
+func TestReadProxyRequest(t *testing.T) {
+	var tests = []struct {
+		name    string
+		raw     string
+		proxy   bool
+		wantURL string
+	}{
+		{"absolute form", "GET http://example.com/a HTTP/1.1\r\nHost: example.com\r\n\r\n", true, "http://example.com/a"},
+		{"connect", "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n", true, "example.com:443"},
+		{"connect without port", "CONNECT example.com HTTP/1.1\r\nHost: example.com\r\n\r\n", true, ""},
+		{"connect with path", "CONNECT /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n", true, ""},
+		{"absolute form without proxy", "GET http://example.com/a HTTP/1.1\r\nHost: example.com\r\n\r\n", false, ""},
+		{"connect without proxy", "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(tt.raw))
+			req, _, err := readProxyRequest(br, DefaultLimits(), readOptions{proxy: tt.proxy})
+			if tt.wantURL == "" {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", req)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.URL != tt.wantURL {
+				t.Errorf("got URL %q, want %q", req.URL, tt.wantURL)
+			}
+		})
+	}
+}
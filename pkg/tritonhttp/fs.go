@@ -0,0 +1,88 @@
+package tritonhttp
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileSystem is the file access of a Server: every Stat, Open and
+// ReadDir of the doc root goes through it. Names are local paths, as
+// DocRoot joined with the request URL. Tests can replace it with an
+// in-memory or error-injecting implementation.
+type FileSystem interface {
+	Stat(name string) (fs.FileInfo, error)
+	Open(name string) (fs.File, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+}
+
+// OSFileSystem is the FileSystem of the operating system.
+type OSFileSystem struct{}
+
+func (OSFileSystem) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+
+func (OSFileSystem) Open(name string) (fs.File, error) { return os.Open(name) }
+
+func (OSFileSystem) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+
+// MountFS returns a FileSystem serving fsys as the directory root,
+// e.g. a testing/fstest.MapFS as the doc root of a Server whose
+// DocRoot is root. Names outside root do not exist.
+func MountFS(fsys fs.FS, root string) FileSystem {
+	return &mountFS{fsys: fsys, root: filepath.Clean(root)}
+}
+
+type mountFS struct {
+	fsys fs.FS
+	root string
+}
+
+// name returns the name in m.fsys of the local path name.
+func (m *mountFS) name(op, name string) (string, error) {
+	name = filepath.Clean(name)
+	if name == m.root {
+		return ".", nil
+	}
+	rel, ok := strings.CutPrefix(name, m.root+string(filepath.Separator))
+	if !ok || !fs.ValidPath(filepath.ToSlash(rel)) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func (m *mountFS) Stat(name string) (fs.FileInfo, error) {
+	rel, err := m.name("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(m.fsys, rel)
+}
+
+func (m *mountFS) Open(name string) (fs.File, error) {
+	rel, err := m.name("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return m.fsys.Open(rel)
+}
+
+func (m *mountFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	rel, err := m.name("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadDir(m.fsys, rel)
+}
+
+// fileSystem returns FS, or if it is nil the archive DocRoot is, if it
+// is one, or the operating system's.
+func (s *Server) fileSystem() FileSystem {
+	if s.FS != nil {
+		return s.FS
+	}
+	if m := s.archive.Load(); m != nil {
+		return m
+	}
+	return OSFileSystem{}
+}
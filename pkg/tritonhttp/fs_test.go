@@ -0,0 +1,148 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// errFS is a FileSystem failing Open of the names in errs.
+type errFS struct {
+	FileSystem
+	errs map[string]error
+}
+
+func (e *errFS) Open(name string) (fs.File, error) {
+	if err, ok := e.errs[name]; ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return e.FileSystem.Open(name)
+}
+
+func TestMountFS(t *testing.T) {
+	root := filepath.FromSlash("/srv/www")
+	m := MountFS(fstest.MapFS{
+		"index.html":        {Data: []byte("hi")},
+		"subdir/index.html": {Data: []byte("sub")},
+	}, root)
+	var tests = []struct {
+		name    string
+		path    string
+		wantDir bool
+		wantErr bool
+	}{
+		{"Root", "/srv/www", true, false},
+		{"File", "/srv/www/index.html", false, false},
+		{"Nested", "/srv/www/subdir/index.html", false, false},
+		{"Dir", "/srv/www/subdir", true, false},
+		{"Missing", "/srv/www/missing.html", false, true},
+		{"Sibling", "/srv/wwwx/index.html", false, true},
+		{"Outside", "/srv/index.html", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fi, err := m.Stat(filepath.FromSlash(tt.path))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error: %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Fatalf("got error %v, want fs.ErrNotExist", err)
+				}
+				return
+			}
+			if fi.IsDir() != tt.wantDir {
+				t.Fatalf("got dir: %v, want: %v", fi.IsDir(), tt.wantDir)
+			}
+		})
+	}
+
+	entries, err := m.ReadDir(root)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("ReadDir: got %v, %v, want 2 entries", entries, err)
+	}
+}
+
+func TestServerFS(t *testing.T) {
+	root := filepath.FromSlash("/srv/www")
+	var errs bytes.Buffer
+	s := &Server{
+		DocRoot: root,
+		FS: &errFS{
+			FileSystem: MountFS(fstest.MapFS{
+				"index.html":  {Data: []byte("<h1>hi</h1>")},
+				"secret.html": {Data: []byte("secret")},
+				"subdir/a.js": {Data: []byte("a")},
+			}, root),
+			errs: map[string]error{filepath.Join(root, "secret.html"): fs.ErrPermission},
+		},
+		ErrorLog: NewLogger(log.New(&errs, "", 0), LevelDebug),
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := s.resolveDocRoot(); err != nil {
+		t.Fatalf("resolveDocRoot: %v", err)
+	}
+
+	var tests = []struct {
+		name       string
+		url        string
+		statusWant int
+		bodyWant   string
+		errLogWant string
+	}{
+		{"OK", "/", 200, "<h1>hi</h1>", ""},
+		{"Nested", "/subdir/a.js", 200, "a", ""},
+		{"Missing", "/missing.html", 404, "", ""},
+		{"Directory", "/subdir", 404, "", ""},
+		{"PermissionDenied", "/secret.html", 404, "", "permission denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs.Reset()
+			res := s.HandleGoodRequest(&Request{Method: "GET", URL: tt.url, Proto: "HTTP/1.1", Header: map[string]string{}, Host: "test"})
+			var body bytes.Buffer
+			if err := res.WriteBody(&body); err != nil {
+				t.Fatalf("WriteBody: %v", err)
+			}
+			if res.StatusCode != tt.statusWant || body.String() != tt.bodyWant {
+				t.Fatalf("got: %v %q, want: %v %q", res.StatusCode, body.String(), tt.statusWant, tt.bodyWant)
+			}
+			if !strings.Contains(errs.String(), tt.errLogWant) || (tt.errLogWant == "" && errs.Len() > 0) {
+				t.Fatalf("error log got: %q, want: %q", errs.String(), tt.errLogWant)
+			}
+		})
+	}
+}
+
+func TestServeDeletedFile(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "index.html")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{DocRoot: root}
+	res := s.HandleGoodRequest(&Request{Method: "GET", URL: "/index.html", Proto: "HTTP/1.1", Header: map[string]string{}, Host: "test"})
+	if res.StatusCode != 200 {
+		t.Fatalf("got status %v, want 200", res.StatusCode)
+	}
+
+	// The file goes away between the headers and the body
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	if err := res.WriteBody(&body); err != nil {
+		t.Fatalf("WriteBody: %v", err)
+	}
+	if body.String() != "hello" {
+		t.Fatalf("got body %q, want %q", body.String(), "hello")
+	}
+}
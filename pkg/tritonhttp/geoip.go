@@ -0,0 +1,272 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultGeoIPCheckInterval is how often GeoIP checks its databases for
+// changes if CheckInterval is 0.
+const defaultGeoIPCheckInterval = time.Minute
+
+// GeoInfo is what GeoIP knows of the client of a request.
+type GeoInfo struct {
+	Country string // ISO 3166-1 code, e.g. "US", or "" if unknown
+	ASN     uint   // autonomous system number, or 0 if unknown
+	ASOrg   string // the organization of ASN, e.g. "GOOGLE"
+}
+
+// GeoIP looks up the country and the autonomous system of the clients
+// of the server in MaxMind DB files, e.g. GeoLite2-Country.mmdb and
+// GeoLite2-ASN.mmdb, setting the Geo of their requests. It is reported
+// in the access log, may label the metrics, see MetricLabels.Countries,
+// and Policies may refuse requests by it.
+//
+// The databases are reloaded once their files change, as updates
+// replace them, and on Server.Reload; a database that fails to load
+// keeps the previous one in use.
+type GeoIP struct {
+	// Databases are the MaxMind DB files looked up, in order: the first
+	// one knowing the country of an address gives it, likewise for its
+	// autonomous system.
+	Databases []string
+
+	// TrustedProxies are the networks of the clients that are proxies:
+	// for their requests, the last address of X-Forwarded-For is looked
+	// up instead.
+	TrustedProxies []netip.Prefix
+
+	// Policies refuse requests with 403 Forbidden by where they come
+	// from: the first one whose Prefix matches a request applies.
+	Policies []GeoPolicy
+
+	// CheckInterval is how often the files of Databases are checked for
+	// changes, every minute if 0, and never if negative.
+	CheckInterval time.Duration
+
+	mu       sync.Mutex // serializes loads
+	dbs      atomic.Pointer[[]*geoDB]
+	checked  atomic.Int64 // when the files were last checked, in Unix nanoseconds
+	checking atomic.Bool
+}
+
+// GeoPolicy restricts the requests under Prefix by the country or the
+// autonomous system they come from. Allow and Deny list ISO country
+// codes, e.g. "CN", and autonomous systems, e.g. "AS64496". If Allow is
+// set, the clients matching none of it are refused, as are, in any
+// case, those matching Deny.
+type GeoPolicy struct {
+	Prefix string
+	Allow  []string
+	Deny   []string
+}
+
+// geoDB is a loaded database of GeoIP.
+type geoDB struct {
+	name    string
+	modTime time.Time
+	db      *mmdb
+}
+
+// Load loads the databases, in place of any loaded before, unless one
+// fails to.
+func (g *GeoIP) Load() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.load(true)
+}
+
+// load loads the files of Databases that changed since last loaded, or
+// all of them if force is set. g.mu must be held.
+func (g *GeoIP) load(force bool) error {
+	var old []*geoDB
+	if p := g.dbs.Load(); p != nil {
+		old = *p
+	}
+	dbs := make([]*geoDB, len(g.Databases))
+	changed := len(old) != len(dbs)
+	for i, name := range g.Databases {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if !force && i < len(old) && old[i].name == name && old[i].modTime.Equal(fi.ModTime()) {
+			dbs[i] = old[i]
+			continue
+		}
+		db, err := openMMDB(name)
+		if err != nil {
+			return err
+		}
+		dbs[i] = &geoDB{name: name, modTime: fi.ModTime(), db: db}
+		changed = true
+	}
+	if changed || force {
+		g.dbs.Store(&dbs)
+	}
+	return nil
+}
+
+// databases returns the loaded databases, loading them on first use,
+// and having them checked for changes in the background if it is time.
+func (g *GeoIP) databases(now time.Time, log Logger) []*geoDB {
+	p := g.dbs.Load()
+	if p == nil {
+		g.mu.Lock()
+		if g.dbs.Load() == nil {
+			if err := g.load(true); err != nil {
+				log.Errorf("Loading GeoIP databases: %v", err)
+				g.dbs.Store(new([]*geoDB))
+			}
+			g.checked.Store(now.UnixNano())
+		}
+		g.mu.Unlock()
+		return *g.dbs.Load()
+	}
+	interval := g.CheckInterval
+	if interval == 0 {
+		interval = defaultGeoIPCheckInterval
+	}
+	if interval > 0 && now.Sub(time.Unix(0, g.checked.Load())) >= interval && g.checking.CompareAndSwap(false, true) {
+		g.checked.Store(now.UnixNano())
+		go func() {
+			defer g.checking.Store(false)
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if err := g.load(false); err != nil {
+				log.Errorf("Reloading GeoIP databases: %v", err)
+			}
+		}()
+	}
+	return *p
+}
+
+// lookup returns what the databases know of ip.
+func (g *GeoIP) lookup(ip netip.Addr, now time.Time, log Logger) GeoInfo {
+	var info GeoInfo
+	for _, d := range g.databases(now, log) {
+		v, err := d.db.lookup(ip)
+		if err != nil {
+			log.Warnf("Looking up %v in %v: %v", ip, d.name, err)
+			continue
+		}
+		rec, _ := v.(map[string]any)
+		if info.Country == "" {
+			info.Country = geoCountry(rec)
+		}
+		if info.ASN == 0 {
+			if asn := mmdbUint(rec["autonomous_system_number"]); asn != 0 {
+				info.ASN = asn
+				info.ASOrg, _ = rec["autonomous_system_organization"].(string)
+			}
+		}
+	}
+	return info
+}
+
+// geoCountry returns the ISO code of the country of rec, a record of a
+// country or city database, or of the country its network is
+// registered in if it has none.
+func geoCountry(rec map[string]any) string {
+	for _, key := range []string{"country", "registered_country"} {
+		if c, ok := rec[key].(map[string]any); ok {
+			if code, ok := c["iso_code"].(string); ok && code != "" {
+				return code
+			}
+		}
+	}
+	return ""
+}
+
+// clientAddr returns the address of the client of req: its remote
+// address, or the last of X-Forwarded-For if sent by a trusted proxy.
+func (g *GeoIP) clientAddr(req *Request) (netip.Addr, bool) {
+	if trusted(req.RemoteAddr, g.TrustedProxies) {
+		if xff := req.Header["X-Forwarded-For"]; xff != "" {
+			last := xff[strings.LastIndexByte(xff, ',')+1:]
+			if ip, err := netip.ParseAddr(strings.TrimSpace(last)); err == nil {
+				return ip, true
+			}
+		}
+	}
+	ip, err := netip.ParseAddr(splitHost(req.RemoteAddr))
+	return ip, err == nil
+}
+
+// geoLocate sets the Geo of req, if s looks up its clients.
+func (s *Server) geoLocate(req *Request) {
+	if s.GeoIP == nil {
+		return
+	}
+	if ip, ok := s.GeoIP.clientAddr(req); ok {
+		req.Geo = s.GeoIP.lookup(ip, s.now(), s.errorLog())
+	}
+}
+
+// geoRefused reports whether the GeoIP policies of s refuse req.
+func (s *Server) geoRefused(req *Request) bool {
+	if s.GeoIP == nil {
+		return false
+	}
+	urlPath, _, _ := strings.Cut(req.URL, "?")
+	for _, p := range s.GeoIP.Policies {
+		if prefixMatches(p.Prefix, urlPath) {
+			return len(p.Allow) > 0 && !geoMatches(p.Allow, req.Geo) || geoMatches(p.Deny, req.Geo)
+		}
+	}
+	return false
+}
+
+// geoMatches reports whether the client of geo is among list.
+func geoMatches(list []string, geo GeoInfo) bool {
+	for _, e := range list {
+		if n, ok := parseASN(e); ok {
+			if geo.ASN != 0 && geo.ASN == n {
+				return true
+			}
+		} else if geo.Country != "" && strings.EqualFold(e, geo.Country) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseASN parses an autonomous system of a GeoPolicy, e.g. "AS64496".
+func parseASN(s string) (uint, bool) {
+	if len(s) < 3 || !strings.EqualFold(s[:2], "AS") {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s[2:], 10, 32)
+	return uint(n), err == nil
+}
+
+// validateGeoIP records the problems with g in v.
+func validateGeoIP(v *validation, g *GeoIP) {
+	v.check(len(g.Databases) == 0, "GeoIP.Databases", "must be set")
+	for i, p := range g.Policies {
+		field := fmt.Sprintf("GeoIP.Policies[%d]", i)
+		v.check(!strings.HasPrefix(p.Prefix, "/"), field+".Prefix", "must start with \"/\", got %q", p.Prefix)
+		for _, e := range append(append([]string(nil), p.Allow...), p.Deny...) {
+			if _, ok := parseASN(e); !ok && (len(e) != 2 || !isAlpha(e)) {
+				v.add(field, fmt.Errorf("%q is neither a country code, e.g. \"US\", nor an autonomous system, e.g. \"AS64496\"", e))
+			}
+		}
+	}
+}
+
+// reloadGeoIP reloads the GeoIP databases of s, if any.
+func (s *Server) reloadGeoIP() error {
+	if s.GeoIP == nil {
+		return nil
+	}
+	if err := s.GeoIP.Load(); err != nil {
+		return fmt.Errorf("GeoIP: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// encodeMMDB encodes v, a map[string]any, string or int, in the data
+// format of MaxMind DBs.
+func encodeMMDB(v any) []byte {
+	head := func(typ byte, size int) []byte {
+		var b []byte
+		if typ > 7 {
+			b = []byte{0, typ - 7}
+		} else {
+			b = []byte{typ << 5}
+		}
+		if size < 29 {
+			b[0] |= byte(size)
+			return b
+		}
+		b[0] |= 29
+		return append(b, byte(size-29))
+	}
+	switch v := v.(type) {
+	case string:
+		return append(head(2, len(v)), v...)
+	case int:
+		var n []byte
+		for u := uint32(v); u > 0; u >>= 8 {
+			n = append([]byte{byte(u)}, n...)
+		}
+		return append(head(6, len(n)), n...)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := head(7, len(v))
+		for _, k := range keys {
+			b = append(b, encodeMMDB(k)...)
+			b = append(b, encodeMMDB(v[k])...)
+		}
+		return b
+	}
+	panic(fmt.Sprintf("cannot encode %T", v))
+}
+
+// writeTestMMDB writes to name a MaxMind DB of IPv6, with records of 24
+// bits, holding the records of the networks, which may not overlap.
+func writeTestMMDB(t *testing.T, name string, networks map[string]map[string]any) {
+	t.Helper()
+	// A record is 0 if empty, a node if positive, data at -r-1 otherwise
+	nodes := [][2]int{{}}
+	var data []byte
+	for prefix, rec := range networks {
+		p := netip.MustParsePrefix(prefix)
+		bits, addr := p.Bits(), p.Addr()
+		if addr.Is4() {
+			addr, bits = netip.AddrFrom16(addr.As16()), bits+96
+			a := addr.As16()
+			a[10], a[11] = 0, 0 // ::a.b.c.d
+			addr = netip.AddrFrom16(a)
+		}
+		a := addr.As16()
+		node := 0
+		for i := 0; i < bits; i++ {
+			bit := a[i/8] >> (7 - i%8) & 1
+			if i == bits-1 {
+				nodes[node][bit] = -len(data) - 1
+				break
+			}
+			if nodes[node][bit] <= 0 {
+				nodes = append(nodes, [2]int{})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+		data = append(data, encodeMMDB(rec)...)
+	}
+	var b []byte
+	for _, n := range nodes {
+		for _, r := range n {
+			switch {
+			case r == 0:
+				r = len(nodes)
+			case r < 0:
+				r = len(nodes) + 16 + (-r - 1)
+			}
+			b = append(b, byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+	b = append(b, make([]byte, 16)...)
+	b = append(b, data...)
+	b = append(b, mmdbMetadataMarker...)
+	b = append(b, encodeMMDB(map[string]any{
+		"node_count":                  len(nodes),
+		"record_size":                 24,
+		"ip_version":                  6,
+		"database_type":               "Test",
+		"binary_format_major_version": 2,
+	})...)
+	if err := os.WriteFile(name, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func country(code string) map[string]any {
+	return map[string]any{"country": map[string]any{"iso_code": code}}
+}
+
+func TestGeoIP(t *testing.T) {
+	dir := t.TempDir()
+	countries, asns := filepath.Join(dir, "country.mmdb"), filepath.Join(dir, "asn.mmdb")
+	writeTestMMDB(t, countries, map[string]map[string]any{
+		"198.51.100.0/24": country("US"),
+		"203.0.113.0/25":  country("JP"),
+		"2001:db8::/32":   {"registered_country": map[string]any{"iso_code": "DE"}},
+	})
+	writeTestMMDB(t, asns, map[string]map[string]any{
+		"203.0.113.0/24": {"autonomous_system_number": 64496, "autonomous_system_organization": "EXAMPLE-NET"},
+	})
+	whoami := HandlerFunc(func(req *Request) *Response {
+		res := NewResponse(statusOK)
+		res.Text(statusOK, fmt.Sprintf("%v AS%v %v", req.Geo.Country, req.Geo.ASN, req.Geo.ASOrg))
+		return res
+	})
+	s := &Server{
+		FS:       MountFS(fstest.MapFS{}, "/srv"),
+		DocRoot:  "/srv",
+		ErrorLog: NewLogger(nil, LevelError),
+		Routes:   []Route{{Prefix: "/", Handler: whoami}},
+		GeoIP: &GeoIP{
+			Databases:      []string{countries, asns},
+			TrustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+			Policies: []GeoPolicy{
+				{Prefix: "/admin", Allow: []string{"us", "DE"}},
+				{Prefix: "/private", Deny: []string{"AS64496"}},
+			},
+		},
+		MetricLabels: MetricLabels{Countries: []string{"US"}},
+	}
+	addr, _ := startTestServer(t, s)
+	var tests = []struct {
+		name       string
+		url        string
+		client     string
+		wantStatus int
+		wantBody   string
+	}{
+		{"Country", "/", "198.51.100.7", 200, "US AS0 "},
+		{"Both", "/", "203.0.113.9", 200, "JP AS64496 EXAMPLE-NET"},
+		{"ASNOnly", "/", "203.0.113.200", 200, " AS64496 EXAMPLE-NET"},
+		{"Registered", "/", "2001:db8::1", 200, "DE AS0 "},
+		{"Unknown", "/", "192.0.2.1", 200, " AS0 "},
+		{"Allowed", "/admin/", "198.51.100.7", 200, "US AS0 "},
+		{"NotAllowed", "/admin/", "203.0.113.9", 403, ""},
+		{"UnknownNotAllowed", "/admin", "192.0.2.1", 403, ""},
+		{"Denied", "/private", "203.0.113.200", 403, ""},
+		{"NotDenied", "/private", "2001:db8::1", 200, "DE AS0 "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "GET " + tt.url + " HTTP/1.1\r\nHost: test\r\nConnection: close\r\nX-Forwarded-For: 10.0.0.1, " + tt.client + "\r\n\r\n"
+			res := exchangeRaw(t, addr, raw, 1)[0]
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+			if body, _ := io.ReadAll(res.BodyReader); string(body) != tt.wantBody {
+				t.Errorf("got body %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+
+	// The requests are labeled by country once finished
+	var us, other int64
+	for deadline := time.Now().Add(5 * time.Second); us+other < int64(len(tests)) && time.Now().Before(deadline); {
+		us, other = 0, 0
+		for _, st := range s.RouteStats() {
+			switch st.Country {
+			case "US":
+				us += st.Requests
+			case otherLabel:
+				other += st.Requests
+			}
+		}
+	}
+	if us != 2 || other != int64(len(tests))-2 {
+		t.Errorf("got %v requests labeled US and %v other, want 2 and %v", us, other, len(tests)-2)
+	}
+}
+
+func TestGeoIPReload(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "country.mmdb")
+	writeTestMMDB(t, name, map[string]map[string]any{"198.51.100.0/24": country("US")})
+	g := &GeoIP{Databases: []string{name}, CheckInterval: time.Millisecond}
+	ip := netip.MustParseAddr("198.51.100.1")
+	log := NewLogger(nil, LevelError)
+	if got := g.lookup(ip, time.Now(), log).Country; got != "US" {
+		t.Fatalf("got country %q, want US", got)
+	}
+
+	// Replaced, as an update would
+	next := name + ".new"
+	writeTestMMDB(t, next, map[string]map[string]any{"198.51.100.0/24": country("CA")})
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(next, future, future)
+	if err := os.Rename(next, name); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for g.lookup(ip, time.Now(), log).Country != "CA" {
+		if time.Now().After(deadline) {
+			t.Fatal("database not reloaded once replaced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A broken update keeps the database in use
+	os.WriteFile(name, []byte("garbage"), 0o644)
+	if err := g.Load(); err == nil {
+		t.Fatal("Load of a broken database got no error")
+	}
+	if got := g.lookup(ip, time.Now(), log).Country; got != "CA" {
+		t.Fatalf("got country %q after a failed load, want CA", got)
+	}
+}
+
+func TestMMDBDecode(t *testing.T) {
+	var tests = []struct {
+		name string
+		data []byte
+		off  uint
+		want string
+	}{
+		{"String", []byte{0x43, 'a', 'b', 'c'}, 0, `abc`},
+		{"LongString", append([]byte{0x5d, 1}, []byte("0123456789012345678901234567890")[:30]...), 0, `012345678901234567890123456789`},
+		{"Pointer", []byte{0x42, 'h', 'i', 0x20, 0x00}, 3, `hi`},
+		{"Uint64", []byte{0x02, 0x02, 0x01, 0x00}, 0, `256`},
+		{"Int32", []byte{0x04, 0x01, 0xff, 0xff, 0xff, 0xfe}, 0, `-2`},
+		{"Bool", []byte{0x01, 0x07}, 0, `true`},
+		{"Array", []byte{0x02, 0x04, 0x41, 'x', 0xa1, 0x05}, 0, `[x 5]`},
+		{"Map", []byte{0xe1, 0x41, 'k', 0x41, 'v'}, 0, `map[k:v]`},
+		{"Double", []byte{0x68, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, 0, `1.5`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, _, err := mmdbDecoder(tt.data).decode(tt.off, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(v); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	for _, bad := range [][]byte{{0x45, 'a'}, {0x20}, {0x20, 0x00}, {0x00, 0x10}} {
+		if _, _, err := mmdbDecoder(bad).decode(0, 0); err == nil {
+			t.Errorf("decode(%x) got no error", bad)
+		}
+	}
+}
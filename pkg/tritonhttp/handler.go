@@ -0,0 +1,94 @@
+package tritonhttp
+
+import "strings"
+
+// Handler answers the requests a Route hands it.
+type Handler interface {
+	// ServeRequest returns the response to req. It may read req.Body,
+	// and its response may stream its BodyReader, which is closed
+	// once written. The server reuses req, and its Header, for later
+	// requests once the response is written: they must not be kept.
+	ServeRequest(req *Request) *Response
+}
+
+// HandlerFunc is a function serving as a Handler.
+type HandlerFunc func(req *Request) *Response
+
+func (f HandlerFunc) ServeRequest(req *Request) *Response { return f(req) }
+
+// Route hands the requests for the paths under Prefix to Handler.
+// A Prefix ending in "/" matches the paths it starts; any other also
+// matches the path equal to it, so "/api" matches "/api" and
+// "/api/users" but not "/apix".
+//
+// If Extensions are set, the Route only matches the paths with a
+// segment ending in one of them, e.g. ".php" matches "/index.php" and
+// "/index.php/users", the rest of the path being the PATH_INFO.
+//
+// TrailingSlash is the policy of the Route on the trailing slash of
+// request paths; as the server cannot tell files from directories
+// there, SlashAccept leaves both forms to Handler, like SlashAsIs.
+type Route struct {
+	Prefix        string
+	Extensions    []string
+	TrailingSlash TrailingSlash
+	Handler       Handler
+}
+
+// matches reports whether r matches the request for urlPath.
+func (r Route) matches(urlPath string) bool {
+	if len(r.Extensions) > 0 {
+		if _, _, ok := splitScript(urlPath, r.Extensions); !ok {
+			return false
+		}
+	}
+	return prefixMatches(r.Prefix, urlPath)
+}
+
+// prefixMatches reports whether urlPath is under prefix: it starts
+// with a prefix ending in "/", or equals or starts with any other
+// followed by "/".
+func prefixMatches(prefix, urlPath string) bool {
+	if strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(urlPath, prefix)
+	}
+	return urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/")
+}
+
+// splitScript splits urlPath after its first segment ending in one of
+// exts, case-insensitively, into the script path and the PATH_INFO.
+func splitScript(urlPath string, exts []string) (script, pathInfo string, ok bool) {
+	lower := strings.ToLower(urlPath)
+	for i := 0; i < len(lower); i++ {
+		if i+1 < len(lower) && lower[i+1] != '/' {
+			continue
+		}
+		for _, ext := range exts {
+			if ext != "" && strings.HasSuffix(lower[:i+1], strings.ToLower(ext)) {
+				return urlPath[:i+1], urlPath[i+1:], true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// serve returns the response of r to req, or the redirect its
+// TrailingSlash policy wants.
+func (r *Route) serve(req *Request) *Response {
+	if res := r.TrailingSlash.redirect(req); res != nil {
+		return res
+	}
+	return r.Handler.ServeRequest(req)
+}
+
+// route returns the first Route matching req, or nil if it is to be
+// served from the doc root.
+func (s *Server) route(req *Request) *Route {
+	urlPath, _, _ := strings.Cut(req.URL, "?")
+	for i := range s.Routes {
+		if s.Routes[i].matches(urlPath) {
+			return &s.Routes[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package tritonhttp
+
+import "fmt"
+
+// RequestHook is a hook the server calls with each request it reads,
+// before serving it, e.g. to check a token, tag it with a header or
+// rewrite its URL. If OnRequest returns a response, it is sent as is
+// instead of serving the request.
+type RequestHook interface {
+	OnRequest(req *Request) *Response
+}
+
+// ResponseHook is a hook the server calls with each response it is
+// about to send, along with its request, e.g. to add security headers.
+// If OnResponse returns a response other than res, it is sent in place
+// of res, which is closed.
+type ResponseHook interface {
+	OnResponse(req *Request, res *Response) *Response
+}
+
+// RequestHookFunc is a function serving as a RequestHook.
+type RequestHookFunc func(req *Request) *Response
+
+func (f RequestHookFunc) OnRequest(req *Request) *Response { return f(req) }
+
+// ResponseHookFunc is a function serving as a ResponseHook.
+type ResponseHookFunc func(req *Request, res *Response) *Response
+
+func (f ResponseHookFunc) OnResponse(req *Request, res *Response) *Response { return f(req, res) }
+
+// hookList holds the hooks registered on a server, replaced whole by
+// RegisterHook so that requests read it without locking.
+type hookList struct {
+	request  []RequestHook
+	response []ResponseHook
+}
+
+// RegisterHook registers hook, a RequestHook, a ResponseHook or both,
+// e.g. a plugin handling a cross-cutting concern. Hooks run in the
+// order they were registered: the request hooks once the server has
+// checked the request against its maintenance, load shedding, quota
+// and GeoIP policies, stopping at the first returning a response, and
+// the response hooks on every response but those of CONNECT tunnels.
+// It panics if hook is neither.
+func (s *Server) RegisterHook(hook interface{}) {
+	reqHook, isReq := hook.(RequestHook)
+	resHook, isRes := hook.(ResponseHook)
+	if !isReq && !isRes {
+		panic(fmt.Sprintf("tritonhttp: %T is neither a RequestHook nor a ResponseHook", hook))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hooks := new(hookList)
+	if old := s.hooks.Load(); old != nil {
+		hooks.request = append(hooks.request, old.request...)
+		hooks.response = append(hooks.response, old.response...)
+	}
+	if isReq {
+		hooks.request = append(hooks.request, reqHook)
+	}
+	if isRes {
+		hooks.response = append(hooks.response, resHook)
+	}
+	s.hooks.Store(hooks)
+}
+
+// runRequestHooks returns the response of the first request hook
+// answering req, or nil if none does.
+func (s *Server) runRequestHooks(req *Request) *Response {
+	hooks := s.hooks.Load()
+	if hooks == nil {
+		return nil
+	}
+	for _, h := range hooks.request {
+		if res := h.OnRequest(req); res != nil {
+			return res
+		}
+	}
+	return nil
+}
+
+// runResponseHooks returns res, the response to req, once through the
+// response hooks, or the response they replaced it with.
+func (s *Server) runResponseHooks(req *Request, res *Response) *Response {
+	hooks := s.hooks.Load()
+	if hooks == nil {
+		return res
+	}
+	for _, h := range hooks.response {
+		if r := h.OnResponse(req, res); r != nil && r != res {
+			_ = res.Close()
+			releaseResponse(res)
+			res = r
+		}
+	}
+	return res
+}
@@ -0,0 +1,99 @@
+package tritonhttp
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// tokenPlugin is a plugin of tests: it refuses the requests under
+// /private without its token, and tags the responses with its name.
+type tokenPlugin struct{ name string }
+
+func (p tokenPlugin) OnRequest(req *Request) *Response {
+	if strings.HasPrefix(req.URL, "/private") && req.Header["X-Token"] != "secret" {
+		res := NewResponse(statusUnauthorized)
+		res.Text(statusUnauthorized, "no token\n")
+		return res
+	}
+	req.Header["X-Seen-By"] += p.name
+	return nil
+}
+
+func (p tokenPlugin) OnResponse(req *Request, res *Response) *Response {
+	res.setHeader("X-Plugins", res.Header["X-Plugins"]+p.name)
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":        {Data: []byte("home")},
+		"private/data.html": {Data: []byte("data")},
+	}
+	s := &Server{
+		FS:       MountFS(fsys, "/srv"),
+		DocRoot:  "/srv",
+		ErrorLog: NewLogger(nil, LevelError),
+		Routes: []Route{{Prefix: "/echo", Handler: HandlerFunc(func(req *Request) *Response {
+			res := NewResponse(statusOK)
+			res.Text(statusOK, req.Header["X-Seen-By"])
+			return res
+		})}},
+	}
+	s.RegisterHook(tokenPlugin{"a"})
+	s.RegisterHook(RequestHookFunc(func(req *Request) *Response {
+		if req.URL == "/teapot" {
+			res := NewResponse(418)
+			res.Text(418, "short and stout\n")
+			return res
+		}
+		return nil
+	}))
+	s.RegisterHook(tokenPlugin{"b"})
+	s.RegisterHook(ResponseHookFunc(func(req *Request, res *Response) *Response {
+		if res.StatusCode != statusNotFound {
+			return nil
+		}
+		page := NewResponse(statusNotFound)
+		page.Text(statusNotFound, "custom 404 for "+req.URL+"\n")
+		return page
+	}))
+	addr, _ := startTestServer(t, s)
+
+	var tests = []struct {
+		name        string
+		request     string
+		wantStatus  int
+		wantBody    string
+		wantPlugins string
+	}{
+		{"Served", "GET / HTTP/1.1\r\nHost: test\r\n\r\n", 200, "home", "ab"},
+		{"Mutated", "GET /echo HTTP/1.1\r\nHost: test\r\n\r\n", 200, "ab", "ab"},
+		{"ShortCircuit", "GET /private/data.html HTTP/1.1\r\nHost: test\r\n\r\n", 401, "no token\n", "ab"},
+		{"Token", "GET /private/data.html HTTP/1.1\r\nHost: test\r\nX-Token: secret\r\n\r\n", 200, "data", "ab"},
+		{"Later", "GET /teapot HTTP/1.1\r\nHost: test\r\n\r\n", 418, "short and stout\n", "ab"},
+		{"Replaced", "GET /missing HTTP/1.1\r\nHost: test\r\n\r\n", 404, "custom 404 for /missing\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := exchangeRaw(t, addr, tt.request, 1)[0]
+			body, _ := io.ReadAll(res.BodyReader)
+			if res.StatusCode != tt.wantStatus || string(body) != tt.wantBody {
+				t.Fatalf("got %v %q, want %v %q", res.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+			if got := res.Header["X-Plugins"]; got != tt.wantPlugins {
+				t.Errorf("got X-Plugins %q, want %q", got, tt.wantPlugins)
+			}
+		})
+	}
+}
+
+func TestRegisterHookPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterHook of a non-hook did not panic")
+		}
+	}()
+	new(Server).RegisterHook(HandlerFunc(nil))
+}
@@ -0,0 +1,74 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// errNoInterim is the error of WriteInterim on a request no server
+// connection is waiting to answer.
+var errNoInterim = errors.New("request is not being served on a connection")
+
+// WriteInterim sends the client of req an interim 1xx response with
+// header, ahead of the final one: e.g. 103 Early Hints with the Link
+// headers of the resources to preload, or 102 Processing to keep it
+// waiting. It is only available to the handler serving req, from its
+// goroutine, until it returns. 101 Switching Protocols is left to
+// protocol upgrades, and 100 Continue is sent when the handler first
+// reads a body the client expects to be asked for.
+func (req *Request) WriteInterim(code int, header map[string]string) error {
+	if code < 100 || code > 199 || code == statusSwitchingProtocols {
+		return fmt.Errorf("invalid interim status %v", code)
+	}
+	if req.interim == nil {
+		return errNoInterim
+	}
+	return req.interim(&Response{StatusCode: code, Proto: "HTTP/1.1", Header: header})
+}
+
+// writeInterim writes res, an interim response, to w: its status line
+// and headers, as it has no body.
+func writeInterim(w io.Writer, res *Response) error {
+	_, err := w.Write(res.appendHeaders(res.appendStatusLine(nil)))
+	return err
+}
+
+// continueReader is the body of a request with "Expect: 100-continue",
+// answering it with 100 Continue on the first Read.
+type continueReader struct {
+	r    io.Reader
+	req  *Request
+	sent bool
+	err  error
+}
+
+func (cr *continueReader) Read(p []byte) (int, error) {
+	if !cr.sent {
+		cr.sent = true
+		cr.err = cr.req.WriteInterim(statusContinue, nil)
+	}
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	return cr.r.Read(p)
+}
+
+// expectsContinue reports whether req waits for 100 Continue before
+// sending its body.
+func expectsContinue(req *Request) bool {
+	return req.Body != nil && strings.EqualFold(req.Header["Expect"], "100-continue")
+}
+
+// readFinalResponse is ReadResponse skipping the interim responses to
+// req but 101 Switching Protocols, which is final for the protocol.
+func readFinalResponse(br *bufio.Reader, req *Request) (*Response, error) {
+	for {
+		res, err := ReadResponse(br, req)
+		if err != nil || res.StatusCode/100 != 1 || res.StatusCode == statusSwitchingProtocols {
+			return res, err
+		}
+	}
+}
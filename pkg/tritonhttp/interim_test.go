@@ -0,0 +1,95 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWriteInterim(t *testing.T) {
+	hints := HandlerFunc(func(req *Request) *Response {
+		if err := req.WriteInterim(103, map[string]string{"Link": "</style.css>; rel=preload; as=style"}); err != nil {
+			t.Errorf("WriteInterim: %v", err)
+		}
+		if err := req.WriteInterim(101, nil); err == nil {
+			t.Errorf("WriteInterim sent a 101")
+		}
+		res := &Response{}
+		res.Text(200, "page")
+		return res
+	})
+	addr, _ := startTestServer(t, &Server{DocRoot: t.TempDir(), Routes: []Route{{Prefix: "/", Handler: hints}}})
+
+	responses := exchangeRaw(t, addr, "GET / HTTP/1.1\r\nHost: test\r\n\r\n", 2)
+	if res := responses[0]; res.StatusCode != 103 || res.Header["Link"] == "" {
+		t.Fatalf("interim response got: %v %v", res.StatusCode, res.Header)
+	}
+	if body, _ := io.ReadAll(responses[1].BodyReader); responses[1].StatusCode != 200 || string(body) != "page" {
+		t.Fatalf("final response got: %v %q", responses[1].StatusCode, body)
+	}
+
+	// Clients only hand over the final response
+	res, err := (&Client{}).Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	if res.StatusCode != 200 {
+		t.Fatalf("client got status %v, want 200", res.StatusCode)
+	}
+
+	if err := (&Request{}).WriteInterim(103, nil); err != errNoInterim {
+		t.Fatalf("WriteInterim outside a server got: %v", err)
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	count := HandlerFunc(func(req *Request) *Response {
+		res := &Response{}
+		if req.URL == "/ignore" {
+			res.Text(200, "ignored")
+			return res
+		}
+		body, _ := io.ReadAll(req.Body)
+		res.Text(200, strconv.Itoa(len(body)))
+		return res
+	})
+	addr, _ := startTestServer(t, &Server{DocRoot: t.TempDir(), Routes: []Route{{Prefix: "/", Handler: count}}})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+	io.WriteString(conn, "GET /count HTTP/1.1\r\nHost: test\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	// The body is only sent once asked for
+	if res, err := ReadResponse(br, &Request{Method: "GET"}); err != nil || res.StatusCode != 100 {
+		t.Fatalf("got %v, %v, want 100 Continue", res, err)
+	}
+	io.WriteString(conn, "hello")
+	res, err := ReadResponse(br, &Request{Method: "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(res.BodyReader); res.StatusCode != 200 || string(body) != "5" {
+		t.Fatalf("got %v %q, want 200 \"5\"", res.StatusCode, body)
+	}
+
+	// Left unasked for, the body can't be told from the next request
+	io.WriteString(conn, "GET /ignore HTTP/1.1\r\nHost: test\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	res, err = ReadResponse(br, &Request{Method: "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(res.BodyReader); res.StatusCode != 200 || string(body) != "ignored" || res.Header["Connection"] != "close" {
+		t.Fatalf("got %v %v %q, want 200 \"ignored\" closing the connection", res.StatusCode, res.Header, body)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("connection left open: %v", err)
+	}
+}
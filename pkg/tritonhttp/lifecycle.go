@@ -0,0 +1,63 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// RegisterOnStartup registers f to be called by Serve, and so by
+// ListenAndServe, once the listener is ready and before the first
+// connection is accepted, e.g. to warm caches or announce readiness.
+// If f returns an error, Serve closes the listener and returns it.
+// Functions run in the order they were registered.
+func (s *Server) RegisterOnStartup(f func(addr net.Addr) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onStartup = append(s.onStartup, f)
+}
+
+// RegisterOnShutdown registers f to be called when Shutdown is first
+// called, e.g. to flush state or deregister from service discovery.
+// Each function runs in its own goroutine.
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onShutdown = append(s.onShutdown, f)
+}
+
+// runStartupHooks calls the startup hooks for the listener on addr,
+// stopping at the first error.
+func (s *Server) runStartupHooks(addr net.Addr) error {
+	s.mu.Lock()
+	hooks := append([]func(net.Addr) error(nil), s.onStartup...)
+	s.mu.Unlock()
+	for _, f := range hooks {
+		if err := f(addr); err != nil {
+			return fmt.Errorf("startup hook: %w", err)
+		}
+	}
+	return nil
+}
+
+// runShutdownHooks starts the shutdown hooks and returns a channel
+// closed once they have all returned.
+func (s *Server) runShutdownHooks() <-chan struct{} {
+	s.mu.Lock()
+	hooks := append([]func(){}, s.onShutdown...)
+	s.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, f := range hooks {
+		wg.Add(1)
+		go func(f func()) {
+			defer wg.Done()
+			f()
+		}(f)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
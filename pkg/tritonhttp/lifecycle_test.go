@@ -0,0 +1,62 @@
+package tritonhttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLifecycleHooks(t *testing.T) {
+	s := &Server{DocRoot: t.TempDir()}
+	var order []string
+	ready := make(chan net.Addr, 1)
+	s.RegisterOnStartup(func(addr net.Addr) error {
+		order = append(order, "first")
+		return nil
+	})
+	s.RegisterOnStartup(func(addr net.Addr) error {
+		order = append(order, "second")
+		ready <- addr
+		return nil
+	})
+	var flushed atomic.Bool
+	s.RegisterOnShutdown(func() {
+		time.Sleep(20 * time.Millisecond)
+		flushed.Store(true)
+	})
+
+	addr, done := startTestServer(t, s)
+	if got := (<-ready).String(); got != addr {
+		t.Fatalf("startup hook addr got: %v, want: %v", got, addr)
+	}
+	if len(order) != 2 || order[0] != "first" {
+		t.Fatalf("startup hooks ran in order %v", order)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !flushed.Load() {
+		t.Fatal("Shutdown returned before the shutdown hook finished")
+	}
+	<-done
+}
+
+func TestStartupHookError(t *testing.T) {
+	s := &Server{}
+	boom := errors.New("cache warmup failed")
+	s.RegisterOnStartup(func(net.Addr) error { return boom })
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(ln); !errors.Is(err, boom) {
+		t.Fatalf("Serve got: %v, want: %v", err, boom)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Fatal("listener still open after failed startup hook")
+	}
+}
@@ -0,0 +1,171 @@
+package tritonhttp
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Limits gathers the protective limits the server enforces on clients.
+// A zero field means the corresponding value from DefaultLimits is used,
+// so the zero Limits is a valid configuration.
+type Limits struct {
+	MaxRequestLineBytes int   // bytes in the request line, excluding "\r\n"
+	MaxHeaderBytes      int   // total bytes of all header lines
+	MaxHeaderCount      int   // number of header lines
+	MaxBodyBytes        int64 // largest Content-Length accepted
+	MaxURLLength        int   // bytes in the request target
+
+	MaxConns      int // concurrent connections across all clients
+	MaxConnsPerIP int // concurrent connections from a single client IP
+
+	ReadTimeout  time.Duration // time allowed to receive the next request
+	WriteTimeout time.Duration // time allowed to write a response
+}
+
+// DefaultLimits returns the limits used for any unset field of Server.Limits.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxRequestLineBytes: 8 << 10,
+		MaxHeaderBytes:      1 << 20,
+		MaxHeaderCount:      100,
+		MaxBodyBytes:        10 << 20,
+		MaxURLLength:        4 << 10,
+		MaxConns:            10000,
+		MaxConnsPerIP:       256,
+		ReadTimeout:         5 * time.Second,
+		WriteTimeout:        30 * time.Second,
+	}
+}
+
+// Validate reports an error if any limit is negative or the limits
+// contradict each other. The error is a *ValidationError listing
+// every problem found.
+func (l Limits) Validate() error {
+	v := &validation{}
+	l.validate(v, "")
+	return v.err()
+}
+
+// validate records the problems with l in v, naming the fields
+// with prefix.
+func (l Limits) validate(v *validation, prefix string) {
+	found := len(v.errs)
+	v.check(l.MaxRequestLineBytes < 0, prefix+"MaxRequestLineBytes", "must not be negative")
+	v.check(l.MaxHeaderBytes < 0, prefix+"MaxHeaderBytes", "must not be negative")
+	v.check(l.MaxHeaderCount < 0, prefix+"MaxHeaderCount", "must not be negative")
+	v.check(l.MaxBodyBytes < 0, prefix+"MaxBodyBytes", "must not be negative")
+	v.check(l.MaxURLLength < 0, prefix+"MaxURLLength", "must not be negative")
+	v.check(l.MaxConns < 0, prefix+"MaxConns", "must not be negative")
+	v.check(l.MaxConnsPerIP < 0, prefix+"MaxConnsPerIP", "must not be negative")
+	v.check(l.ReadTimeout < 0, prefix+"ReadTimeout", "must not be negative")
+	v.check(l.WriteTimeout < 0, prefix+"WriteTimeout", "must not be negative")
+	if len(v.errs) > found {
+		return
+	}
+	d := l.withDefaults()
+	v.check(d.MaxURLLength > d.MaxRequestLineBytes, prefix+"MaxURLLength",
+		"%v exceeds MaxRequestLineBytes %v", d.MaxURLLength, d.MaxRequestLineBytes)
+	v.check(d.MaxConnsPerIP > d.MaxConns, prefix+"MaxConnsPerIP",
+		"%v exceeds MaxConns %v", d.MaxConnsPerIP, d.MaxConns)
+}
+
+// withDefaults returns a copy of l with every zero field
+// replaced by its default value.
+func (l Limits) withDefaults() Limits {
+	d := DefaultLimits()
+	if l.MaxRequestLineBytes == 0 {
+		l.MaxRequestLineBytes = d.MaxRequestLineBytes
+	}
+	if l.MaxHeaderBytes == 0 {
+		l.MaxHeaderBytes = d.MaxHeaderBytes
+	}
+	if l.MaxHeaderCount == 0 {
+		l.MaxHeaderCount = d.MaxHeaderCount
+	}
+	if l.MaxBodyBytes == 0 {
+		l.MaxBodyBytes = d.MaxBodyBytes
+	}
+	if l.MaxURLLength == 0 {
+		l.MaxURLLength = d.MaxURLLength
+	}
+	if l.MaxConns == 0 {
+		l.MaxConns = d.MaxConns
+	}
+	if l.MaxConnsPerIP == 0 {
+		l.MaxConnsPerIP = d.MaxConnsPerIP
+	}
+	if l.ReadTimeout == 0 {
+		l.ReadTimeout = d.ReadTimeout
+	}
+	if l.WriteTimeout == 0 {
+		l.WriteTimeout = d.WriteTimeout
+	}
+	return l
+}
+
+// connCounter tracks open connections in total and per client IP.
+type connCounter struct {
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+// acquire records a new connection from addr, unless doing so
+// would exceed the connection limits in l. If it would, perIP reports
+// whether the client is over its own limit, rather than the server
+// over MaxConns alone.
+func (c *connCounter) acquire(addr net.Addr, l Limits) (ok, perIP bool) {
+	ip := hostOf(addr)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.perIP == nil {
+		c.perIP = make(map[string]int)
+	}
+	if c.perIP[ip] >= l.MaxConnsPerIP {
+		return false, true
+	}
+	if c.total >= l.MaxConns {
+		return false, false
+	}
+	c.total++
+	c.perIP[ip]++
+	return true, false
+}
+
+// release forgets a connection previously acquired from addr.
+func (c *connCounter) release(addr net.Addr) {
+	ip := hostOf(addr)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total--
+	if c.perIP[ip]--; c.perIP[ip] <= 0 {
+		delete(c.perIP, ip)
+	}
+}
+
+// count returns the number of open connections.
+func (c *connCounter) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// hostOf returns the IP part of addr, or the whole address
+// if it has no port.
+func hostOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return splitHost(addr.String())
+}
+
+// splitHost returns the host part of hostport, or the whole
+// of hostport if it has no port.
+func splitHost(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport
+	}
+	return host
+}
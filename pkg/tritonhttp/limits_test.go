@@ -0,0 +1,74 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitsValidate(t *testing.T) {
+	var tests = []struct {
+		name    string
+		lim     Limits
+		wantErr bool
+	}{
+		{"Zero", Limits{}, false},
+		{"Default", DefaultLimits(), false},
+		{"NegativeHeaderCount", Limits{MaxHeaderCount: -1}, true},
+		{"NegativeTimeout", Limits{ReadTimeout: -time.Second}, true},
+		{"URLLongerThanLine", Limits{MaxRequestLineBytes: 64, MaxURLLength: 128}, true},
+		{"PerIPAboveTotal", Limits{MaxConns: 2, MaxConnsPerIP: 3}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.lim.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error: %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadRequestLimits(t *testing.T) {
+	var tests = []struct {
+		name string
+		lim  Limits
+		req  string
+	}{
+		{
+			"RequestLineTooLong",
+			Limits{MaxRequestLineBytes: 16, MaxURLLength: 8},
+			"GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n",
+		},
+		{
+			"URLTooLong",
+			Limits{MaxURLLength: 4},
+			"GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n",
+		},
+		{
+			"TooManyHeaders",
+			Limits{MaxHeaderCount: 2},
+			"GET /index.html HTTP/1.1\r\nHost: test\r\nKey1: val1\r\nKey2: val2\r\n\r\n",
+		},
+		{
+			"HeadersTooLarge",
+			Limits{MaxHeaderBytes: 20},
+			"GET /index.html HTTP/1.1\r\nHost: test\r\nKey1: a long value\r\n\r\n",
+		},
+		{
+			"BodyTooLarge",
+			Limits{MaxBodyBytes: 10},
+			"GET /index.html HTTP/1.1\r\nHost: test\r\nContent-Length: 11\r\n\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(tt.req))
+			reqGot, _, err := readRequest(br, tt.lim.withDefaults())
+			checkBadRequest(t, err, reqGot)
+		})
+	}
+}
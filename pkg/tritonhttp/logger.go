@@ -0,0 +1,118 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[LogLevel]string{
+	LevelDebug: "DEBUG",
+	LevelInfo:  "INFO",
+	LevelWarn:  "WARN",
+	LevelError: "ERROR",
+}
+
+func (l LogLevel) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+func (l LogLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *LogLevel) UnmarshalText(text []byte) error {
+	parsed, err := ParseLogLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+// ParseLogLevel returns the level named s, e.g. "debug" or "WARN".
+func ParseLogLevel(s string) (LogLevel, error) {
+	for l, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Logger receives the diagnostic output of the server.
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// NewLogger returns a Logger writing messages of at least level min to l.
+// A nil l writes through the standard logger of the log package.
+func NewLogger(l *log.Logger, min LogLevel) Logger {
+	if l == nil {
+		l = log.Default()
+	}
+	ll := &levelLogger{l: l}
+	ll.SetLevel(min)
+	return ll
+}
+
+// LevelSetter is implemented by the Loggers whose minimum level can be
+// changed while they are in use, such as those returned by NewLogger
+// and NewSyslogLogger.
+type LevelSetter interface {
+	Level() LogLevel
+	SetLevel(min LogLevel)
+}
+
+// defaultLogger is used by servers without a Logger. It stays quiet
+// unless something goes wrong.
+var defaultLogger = NewLogger(nil, LevelWarn)
+
+type levelLogger struct {
+	l   *log.Logger
+	min atomic.Int64
+}
+
+func (ll *levelLogger) Level() LogLevel       { return LogLevel(ll.min.Load()) }
+func (ll *levelLogger) SetLevel(min LogLevel) { ll.min.Store(int64(min)) }
+
+func (ll *levelLogger) logf(level LogLevel, format string, v []interface{}) {
+	if level < ll.Level() {
+		return
+	}
+	ll.l.Printf(level.String()+" "+format, v...)
+}
+
+func (ll *levelLogger) Debugf(format string, v ...interface{}) { ll.logf(LevelDebug, format, v) }
+func (ll *levelLogger) Infof(format string, v ...interface{})  { ll.logf(LevelInfo, format, v) }
+func (ll *levelLogger) Warnf(format string, v ...interface{})  { ll.logf(LevelWarn, format, v) }
+func (ll *levelLogger) Errorf(format string, v ...interface{}) { ll.logf(LevelError, format, v) }
+
+// logger returns the Logger of s, falling back to the default one,
+// or to the one with its own level once UpdateSettings changed it.
+func (s *Server) logger() Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	if l := s.fallbackLog.Load(); l != nil {
+		return l
+	}
+	return defaultLogger
+}
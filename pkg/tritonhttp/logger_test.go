@@ -0,0 +1,53 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestLevelLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	l := NewLogger(log.New(&buffer, "", 0), LevelWarn)
+	l.Debugf("debug %v", 1)
+	l.Infof("info %v", 2)
+	l.Warnf("warn %v", 3)
+	l.Errorf("error %v", 4)
+
+	want := "WARN warn 3\nERROR error 4\n"
+	if got := buffer.String(); got != want {
+		t.Fatalf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for _, level := range []LogLevel{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		got, err := ParseLogLevel(level.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != level {
+			t.Fatalf("got: %v, want: %v", got, level)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Fatal("got no error for unknown level")
+	}
+}
+
+func TestErrorLogSeparate(t *testing.T) {
+	var logs, errs bytes.Buffer
+	s := &Server{
+		Logger:   NewLogger(log.New(&logs, "", 0), LevelDebug),
+		ErrorLog: NewLogger(log.New(&errs, "", 0), LevelInfo),
+	}
+	s.logger().Debugf("debug")
+	s.errorLog().Errorf("failure")
+
+	if got, want := logs.String(), "DEBUG debug\n"; got != want {
+		t.Fatalf("log got: %q, want: %q", got, want)
+	}
+	if got, want := errs.String(), "ERROR failure\n"; got != want {
+		t.Fatalf("error log got: %q, want: %q", got, want)
+	}
+}
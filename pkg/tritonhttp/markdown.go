@@ -0,0 +1,773 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxMarkdownBytes is the size of the largest Markdown file rendered;
+// larger ones are served as they are.
+const maxMarkdownBytes = 4 << 20
+
+// defaultMarkdownTemplate is the page the HTML of Markdown files is
+// wrapped in if Markdown.Template is nil.
+var defaultMarkdownTemplate = template.Must(template.New("markdown").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+</head>
+<body>
+{{.Body}}</body>
+</html>
+`))
+
+// Markdown renders the Markdown files of the doc root, those ending in
+// ".md", to HTML pages, so that a tree of documentation can be served
+// as is. It knows the usual CommonMark blocks and inlines, and GitHub
+// tables and strikethrough; raw HTML is escaped rather than passed
+// through. A directory without an index.html is served its index.md.
+type Markdown struct {
+	// Template, if set, is executed with a MarkdownPage to wrap the
+	// HTML of each file; nil means a bare HTML5 page.
+	Template *template.Template
+
+	// RawQuery is the query parameter asking for the Markdown source
+	// instead, e.g. "raw" for "/README.md?raw"; "" means "raw".
+	RawQuery string
+}
+
+// MarkdownPage is what Markdown.Template is executed with.
+type MarkdownPage struct {
+	// Title is the text of the first heading of the file, or else its
+	// name.
+	Title string
+
+	// Body is the HTML the file renders to.
+	Body template.HTML
+
+	// Path is the path of the request.
+	Path string
+}
+
+// isMarkdown reports whether the file at name is a Markdown one.
+func isMarkdown(name string) bool {
+	return strings.EqualFold(pathExt(name), ".md")
+}
+
+// raw reports whether req asks for the Markdown source.
+func (m *Markdown) raw(req *Request) bool {
+	_, query, _ := strings.Cut(req.URL, "?")
+	param := m.RawQuery
+	if param == "" {
+		param = "raw"
+	}
+	values, err := url.ParseQuery(query)
+	return err == nil && values.Has(param)
+}
+
+// pathExt returns the extension of name, either a slash or a
+// backslash separated path.
+func pathExt(name string) string {
+	for i := len(name) - 1; i >= 0 && name[i] != '/' && name[i] != '\\'; i-- {
+		if name[i] == '.' {
+			return name[i:]
+		}
+	}
+	return ""
+}
+
+// serveMarkdown makes res, the response serving f, the Markdown file
+// at name described by fi, the HTML page rendered from it, unless req
+// asks for the source or f is too large to render.
+func (s *Server) serveMarkdown(res *Response, req *Request, name string, fi fs.FileInfo, f fs.File) {
+	if s.Markdown.raw(req) || fi.Size() > maxMarkdownBytes {
+		res.Header["Content-Type"] = "text/markdown; charset=utf-8"
+		return
+	}
+	src, err := io.ReadAll(io.LimitReader(f, maxMarkdownBytes))
+	if err != nil {
+		s.errorLog().Errorf("Failed to read %v: %v", name, err)
+		res.Text(statusInternalServerError, StatusText(statusInternalServerError)+"\n")
+		return
+	}
+	body, title := renderMarkdown(src)
+	if title == "" {
+		title = strings.TrimSuffix(fi.Name(), pathExt(fi.Name()))
+	}
+	urlPath, _, _ := strings.Cut(req.URL, "?")
+	tmpl := s.Markdown.Template
+	if tmpl == nil {
+		tmpl = defaultMarkdownTemplate
+	}
+	var b bytes.Buffer
+	page := MarkdownPage{Title: title, Body: template.HTML(body), Path: urlPath}
+	if err := tmpl.Execute(&b, page); err != nil {
+		s.errorLog().Errorf("Failed to render %v: %v", name, err)
+		res.Text(statusInternalServerError, StatusText(statusInternalServerError)+"\n")
+		return
+	}
+	res.setBody(statusOK, "text/html; charset=utf-8", b.Bytes())
+}
+
+// renderMarkdown returns the HTML of the Markdown document src, and the
+// text of its first heading.
+func renderMarkdown(src []byte) (body []byte, title string) {
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\t", "    ")
+	r := &mdRenderer{}
+	r.blocks(strings.Split(text, "\n"), false)
+	return r.b.Bytes(), r.title
+}
+
+// mdRenderer renders Markdown blocks to b.
+type mdRenderer struct {
+	b     bytes.Buffer
+	title string
+}
+
+// blocks renders lines, a sequence of blocks; the paragraphs of tight
+// list items are rendered without <p> tags.
+func (r *mdRenderer) blocks(lines []string, tight bool) {
+	var para []string
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		text := strings.TrimSpace(strings.Join(para, "\n"))
+		if tight {
+			r.b.WriteString(renderInline(text))
+			r.b.WriteByte('\n')
+		} else {
+			r.b.WriteString("<p>" + renderInline(text) + "</p>\n")
+		}
+		para = para[:0]
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " ")
+		indent := len(line) - len(trimmed)
+		switch {
+		case trimmed == "":
+			flush()
+		case indent >= 4 && len(para) == 0:
+			// Indented code block
+			j := i
+			for j < len(lines) && (strings.HasPrefix(lines[j], "    ") || strings.TrimSpace(lines[j]) == "") {
+				j++
+			}
+			for j > i && strings.TrimSpace(lines[j-1]) == "" {
+				j--
+			}
+			var code []string
+			for _, l := range lines[i:j] {
+				code = append(code, strings.TrimPrefix(l, "    "))
+			}
+			r.b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "\n</code></pre>\n")
+			i = j - 1
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			fence := trimmed[:3]
+			lang := strings.TrimSpace(strings.TrimLeft(trimmed, fence[:1]))
+			var code []string
+			j := i + 1
+			for ; j < len(lines); j++ {
+				if strings.HasPrefix(strings.TrimLeft(lines[j], " "), fence) {
+					break
+				}
+				code = append(code, lines[j])
+			}
+			r.b.WriteString("<pre><code")
+			if lang != "" {
+				lang, _, _ = strings.Cut(lang, " ")
+				r.b.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+			}
+			r.b.WriteString(">")
+			if len(code) > 0 {
+				r.b.WriteString(html.EscapeString(strings.Join(code, "\n")) + "\n")
+			}
+			r.b.WriteString("</code></pre>\n")
+			i = j
+		case headingLevel(trimmed) > 0:
+			flush()
+			level := headingLevel(trimmed)
+			text := strings.TrimSpace(trimmed[level:])
+			if t := strings.TrimRight(text, "#"); t == "" || strings.HasSuffix(t, " ") {
+				text = strings.TrimSpace(t)
+			}
+			r.heading(level, text)
+		case len(para) > 0 && setextLevel(trimmed) > 0:
+			text := strings.TrimSpace(strings.Join(para, "\n"))
+			para = para[:0]
+			r.heading(setextLevel(trimmed), text)
+		case thematicBreak(trimmed):
+			flush()
+			r.b.WriteString("<hr>\n")
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			j := i
+			for ; j < len(lines); j++ {
+				l := strings.TrimLeft(lines[j], " ")
+				if !strings.HasPrefix(l, ">") {
+					break
+				}
+				l = strings.TrimPrefix(l[1:], " ")
+				quote = append(quote, l)
+			}
+			r.b.WriteString("<blockquote>\n")
+			r.blocks(quote, false)
+			r.b.WriteString("</blockquote>\n")
+			i = j - 1
+		case indent < 4 && listMarker(trimmed) > 0 && (len(para) == 0 || !orderedMarker(trimmed)):
+			flush()
+			i = r.list(lines, i) - 1
+		case len(para) == 0 && i+1 < len(lines) && strings.Contains(line, "|") && tableDelimiter(lines[i+1]) != nil:
+			i = r.table(lines, i) - 1
+		default:
+			para = append(para, line)
+		}
+	}
+	flush()
+}
+
+// heading renders a heading of level, with an id made of its text for
+// links to point at.
+func (r *mdRenderer) heading(level int, text string) {
+	plain := stripInline(text)
+	if r.title == "" {
+		r.title = plain
+	}
+	tag := "h" + strconv.Itoa(level)
+	r.b.WriteString("<" + tag + ` id="` + html.EscapeString(slugify(plain)) + `">` + renderInline(text) + "</" + tag + ">\n")
+}
+
+// list renders the list starting at lines[start], and returns the
+// index of the line past it.
+func (r *mdRenderer) list(lines []string, start int) int {
+	first := strings.TrimLeft(lines[start], " ")
+	ordered := orderedMarker(first)
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	var items [][]string
+	tight := true
+	blank := false
+	contentIndent := 0
+	i := start
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " ")
+		indent := len(line) - len(trimmed)
+		if trimmed == "" {
+			blank = true
+			continue
+		}
+		if n := listMarker(trimmed); n > 0 && indent < 4 && (len(items) == 0 || indent < contentIndent) && orderedMarker(trimmed) == ordered {
+			if blank && len(items) > 0 {
+				tight = false
+			}
+			items = append(items, []string{trimmed[n:]})
+			contentIndent = indent + n
+			blank = false
+			continue
+		}
+		cur := &items[len(items)-1]
+		switch {
+		case indent >= contentIndent:
+			if blank {
+				*cur = append(*cur, "")
+				tight = tight && !blankSeparatesParagraphs(*cur)
+			}
+			*cur = append(*cur, dedent(line, contentIndent))
+		case !blank && listMarker(trimmed) == 0 && headingLevel(trimmed) == 0 && !thematicBreak(trimmed):
+			// Lazy continuation of the paragraph
+			*cur = append(*cur, trimmed)
+		default:
+			goto done
+		}
+		blank = false
+	}
+done:
+	if ordered {
+		if n, _ := strconv.Atoi(strings.TrimLeft(first[:strings.IndexAny(first, ".)")], "0")); n > 1 {
+			r.b.WriteString("<ol start=\"" + strconv.Itoa(n) + "\">\n")
+		} else {
+			r.b.WriteString("<ol>\n")
+		}
+	} else {
+		r.b.WriteString("<ul>\n")
+	}
+	for _, item := range items {
+		r.b.WriteString("<li>")
+		if !tight {
+			r.b.WriteByte('\n')
+		}
+		r.blocks(item, tight)
+		trimTrailingNewline(&r.b)
+		r.b.WriteString("</li>\n")
+	}
+	r.b.WriteString("</" + tag + ">\n")
+	// Blank lines before what follows the list are not part of it
+	for i > start && strings.TrimSpace(lines[i-1]) == "" {
+		i--
+	}
+	return i
+}
+
+// blankSeparatesParagraphs reports whether the blank line just added
+// to item separates two of its paragraphs, rather than a paragraph
+// from a nested list, which would make the list loose.
+func blankSeparatesParagraphs(item []string) bool {
+	last := ""
+	for j := len(item) - 2; j >= 0; j-- {
+		if strings.TrimSpace(item[j]) != "" {
+			last = strings.TrimLeft(item[j], " ")
+			break
+		}
+	}
+	return listMarker(last) == 0
+}
+
+// table renders the GitHub table whose header row is lines[start], and
+// returns the index of the line past it.
+func (r *mdRenderer) table(lines []string, start int) int {
+	aligns := tableDelimiter(lines[start+1])
+	row := func(line, cell string) {
+		r.b.WriteString("<tr>")
+		for k, c := range tableCells(line) {
+			if k >= len(aligns) {
+				break
+			}
+			r.b.WriteString("<" + cell)
+			if aligns[k] != "" {
+				r.b.WriteString(` style="text-align: ` + aligns[k] + `"`)
+			}
+			r.b.WriteString(">" + renderInline(c) + "</" + cell + ">")
+		}
+		r.b.WriteString("</tr>\n")
+	}
+	r.b.WriteString("<table>\n<thead>\n")
+	row(lines[start], "th")
+	r.b.WriteString("</thead>\n")
+	i := start + 2
+	if i < len(lines) && strings.Contains(lines[i], "|") {
+		r.b.WriteString("<tbody>\n")
+		for ; i < len(lines) && strings.Contains(lines[i], "|"); i++ {
+			row(lines[i], "td")
+		}
+		r.b.WriteString("</tbody>\n")
+	}
+	r.b.WriteString("</table>\n")
+	return i
+}
+
+// tableCells splits a table row into its trimmed cells.
+func tableCells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// tableDelimiter returns the alignments of the columns of a table if
+// line is its delimiter row, e.g. "| --- | :-: |", or nil if it is not.
+func tableDelimiter(line string) []string {
+	if !strings.Contains(line, "-") {
+		return nil
+	}
+	var aligns []string
+	for _, c := range tableCells(line) {
+		left, right := strings.HasPrefix(c, ":"), strings.HasSuffix(c, ":")
+		dashes := strings.Trim(c, ":")
+		if dashes == "" || strings.Trim(dashes, "-") != "" {
+			return nil
+		}
+		switch {
+		case left && right:
+			aligns = append(aligns, "center")
+		case right:
+			aligns = append(aligns, "right")
+		case left:
+			aligns = append(aligns, "left")
+		default:
+			aligns = append(aligns, "")
+		}
+	}
+	return aligns
+}
+
+// headingLevel returns the level of the ATX heading line starts, e.g.
+// 2 for "## Usage", or 0 if it starts none.
+func headingLevel(line string) int {
+	n := 0
+	for n < len(line) && line[n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 || n < len(line) && line[n] != ' ' {
+		return 0
+	}
+	return n
+}
+
+// setextLevel returns the level of the heading line underlines, 1 for
+// "===" and 2 for "---", or 0 if it underlines none.
+func setextLevel(line string) int {
+	line = strings.TrimSpace(line)
+	switch {
+	case line == "":
+		return 0
+	case strings.Trim(line, "=") == "":
+		return 1
+	case strings.Trim(line, "-") == "":
+		return 2
+	}
+	return 0
+}
+
+// thematicBreak reports whether line is a thematic break, e.g. "---"
+// or "* * *".
+func thematicBreak(line string) bool {
+	line = strings.ReplaceAll(strings.TrimSpace(line), " ", "")
+	return len(line) >= 3 && (strings.Trim(line, "-") == "" || strings.Trim(line, "*") == "" || strings.Trim(line, "_") == "")
+}
+
+// listMarker returns the length of the list item marker line starts
+// with, e.g. 2 for "- item" or 3 for "1. item", or 0 if none.
+func listMarker(line string) int {
+	if len(line) >= 2 && strings.IndexByte("-*+", line[0]) >= 0 && line[1] == ' ' {
+		return 2
+	}
+	n := 0
+	for n < len(line) && n < 9 && '0' <= line[n] && line[n] <= '9' {
+		n++
+	}
+	if n > 0 && n+1 < len(line) && (line[n] == '.' || line[n] == ')') && line[n+1] == ' ' {
+		return n + 2
+	}
+	return 0
+}
+
+// orderedMarker reports whether line starts with the marker of an
+// ordered list item.
+func orderedMarker(line string) bool {
+	return listMarker(line) > 0 && '0' <= line[0] && line[0] <= '9'
+}
+
+// dedent removes up to n leading spaces from line.
+func dedent(line string, n int) string {
+	for i := 0; i < n && strings.HasPrefix(line, " "); i++ {
+		line = line[1:]
+	}
+	return line
+}
+
+// trimTrailingNewline removes the newline b ends with, if any.
+func trimTrailingNewline(b *bytes.Buffer) {
+	if n := b.Len(); n > 0 && b.Bytes()[n-1] == '\n' {
+		b.Truncate(n - 1)
+	}
+}
+
+// slugify returns the id of a heading of text, e.g. "getting-started"
+// for "Getting Started".
+func slugify(text string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '-' || c == '_':
+			b.WriteRune(c)
+		case c == ' ':
+			b.WriteByte('-')
+		}
+	}
+	return b.String()
+}
+
+// stripInline returns the text of the Markdown inlines text, without
+// their markup.
+func stripInline(text string) string {
+	s := renderInline(text)
+	var b strings.Builder
+	for len(s) > 0 {
+		if i := strings.IndexByte(s, '<'); i >= 0 {
+			b.WriteString(s[:i])
+			j := strings.IndexByte(s[i:], '>')
+			if j < 0 {
+				break
+			}
+			s = s[i+j+1:]
+		} else {
+			b.WriteString(s)
+			break
+		}
+	}
+	return html.UnescapeString(b.String())
+}
+
+// renderInline returns the HTML of the Markdown inlines text: code
+// spans, emphasis, links, images, autolinks and line breaks, with the
+// rest escaped.
+func renderInline(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && text[i+1] == '\n':
+			b.WriteString("<br>\n")
+			i += 2
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", text[i+1]) >= 0:
+			b.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+		case c == '`':
+			n := runLength(text[i:], '`')
+			end := strings.Index(text[i+n:], text[i:i+n])
+			if end < 0 {
+				b.WriteString(text[i : i+n])
+				i += n
+				break
+			}
+			code := strings.ReplaceAll(text[i+n:i+n+end], "\n", " ")
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+				code = code[1 : len(code)-1]
+			}
+			b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+			i += n + end + n
+		case c == '!' && i+1 < len(text) && text[i+1] == '[':
+			if alt, dest, title, n := parseLink(text[i+1:]); n > 0 {
+				b.WriteString(`<img src="` + html.EscapeString(safeURL(dest)) + `" alt="` + html.EscapeString(stripInline(alt)) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				b.WriteString(">")
+				i += 1 + n
+				break
+			}
+			b.WriteString("!")
+			i++
+		case c == '[':
+			if label, dest, title, n := parseLink(text[i:]); n > 0 {
+				b.WriteString(`<a href="` + html.EscapeString(safeURL(dest)) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				b.WriteString(">" + renderInline(label) + "</a>")
+				i += n
+				break
+			}
+			b.WriteString("[")
+			i++
+		case c == '<':
+			if end := strings.IndexByte(text[i:], '>'); end > 0 {
+				if u := text[i+1 : i+end]; autolink(u) {
+					href := u
+					if !strings.Contains(u, "://") {
+						href = "mailto:" + u
+					}
+					b.WriteString(`<a href="` + html.EscapeString(href) + `">` + html.EscapeString(u) + "</a>")
+					i += end + 1
+					break
+				}
+			}
+			b.WriteString("&lt;")
+			i++
+		case c == '*' || c == '_' || c == '~':
+			n := runLength(text[i:], c)
+			if c == '~' && n != 2 {
+				b.WriteString(text[i : i+n])
+				i += n
+				break
+			}
+			if n > 2 {
+				n = 2
+			}
+			end := closingDelimiter(text, i, n)
+			if end < 0 {
+				b.WriteString(text[i : i+n])
+				i += n
+				break
+			}
+			tag := "em"
+			switch {
+			case c == '~':
+				tag = "del"
+			case n == 2:
+				tag = "strong"
+			}
+			b.WriteString("<" + tag + ">" + renderInline(text[i+n:end]) + "</" + tag + ">")
+			i = end + n
+		case c == '\n':
+			b.WriteByte('\n')
+			i++
+		default:
+			j := i + 1
+			for j < len(text) && strings.IndexByte("\\`![<*_~\n", text[j]) < 0 {
+				j++
+			}
+			if chunk := text[i:j]; j < len(text) && text[j] == '\n' && strings.HasSuffix(chunk, "  ") {
+				// Hard line break
+				b.WriteString(html.EscapeString(strings.TrimRight(chunk, " ")) + "<br>")
+			} else {
+				b.WriteString(html.EscapeString(chunk))
+			}
+			i = j
+		}
+	}
+	return b.String()
+}
+
+// runLength returns how many times c repeats at the start of s.
+func runLength(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+// closingDelimiter returns the index in text of the run of n of the
+// delimiter opened at text[open:], or -1 if it is not closed. The
+// delimiters must hug the text they enclose, and underscores must not
+// be inside words.
+func closingDelimiter(text string, open, n int) int {
+	c := text[open]
+	if open+n >= len(text) || text[open+n] == ' ' || text[open+n] == '\n' {
+		return -1
+	}
+	if c == '_' && open > 0 && isWordByte(text[open-1]) {
+		return -1
+	}
+	for i := open + n + 1; i+n <= len(text); i++ {
+		switch text[i] {
+		case '`', '\\':
+			// Skip code spans and escapes, which may hold delimiters
+			if text[i] == '\\' {
+				i++
+			} else if end := strings.IndexByte(text[i+1:], '`'); end >= 0 {
+				i += end + 1
+			}
+			continue
+		case c:
+		default:
+			continue
+		}
+		run := runLength(text[i:], c)
+		if text[i-1] == ' ' || text[i-1] == '\n' {
+			i += run - 1
+			continue
+		}
+		if c == '_' && i+run < len(text) && isWordByte(text[i+run]) {
+			i += run - 1
+			continue
+		}
+		if run == n || run >= 3 {
+			return i + run - n
+		}
+		i += run - 1
+	}
+	return -1
+}
+
+// isWordByte reports whether c is part of a word.
+func isWordByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c >= 0x80
+}
+
+// parseLink parses the inline link at the start of s, e.g.
+// "[text](dest "title")", returning its text, destination, title and
+// length, or 0 if s starts none.
+func parseLink(s string) (text, dest, title string, n int) {
+	depth := 0
+	end := -1
+	for i := 0; i < len(s) && end < 0; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				end = i
+			}
+		}
+	}
+	if end < 0 || end+1 >= len(s) || s[end+1] != '(' {
+		return "", "", "", 0
+	}
+	close := -1
+	for i, depth := end+2, 0; i < len(s) && close < 0; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				close = i - (end + 2)
+			}
+			depth--
+		}
+	}
+	if close < 0 {
+		return "", "", "", 0
+	}
+	inner := strings.TrimSpace(s[end+2 : end+2+close])
+	dest, rest, _ := strings.Cut(inner, " ")
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	if rest = strings.TrimSpace(rest); len(rest) >= 2 && (rest[0] == '"' || rest[0] == '\'') && rest[len(rest)-1] == rest[0] {
+		title = rest[1 : len(rest)-1]
+	}
+	return s[1:end], dest, title, end + 2 + close + 1
+}
+
+// autolink reports whether u, found between angle brackets, is an
+// absolute URL or an email address.
+func autolink(u string) bool {
+	if strings.ContainsAny(u, " <>\n") {
+		return false
+	}
+	if scheme, _, ok := strings.Cut(u, "://"); ok {
+		return scheme != "" && strings.Trim(strings.ToLower(scheme), "abcdefghijklmnopqrstuvwxyz+.-") == ""
+	}
+	at := strings.IndexByte(u, '@')
+	return at > 0 && strings.Contains(u[at:], ".")
+}
+
+// safeURL returns dest, unless it is a script URL, which is made
+// harmless.
+func safeURL(dest string) string {
+	scheme, _, ok := strings.Cut(dest, ":")
+	if ok && !strings.ContainsAny(scheme, "/?#") {
+		switch strings.ToLower(strings.TrimSpace(scheme)) {
+		case "javascript", "vbscript", "data":
+			return "#"
+		}
+	}
+	return dest
+}
@@ -0,0 +1,107 @@
+package tritonhttp
+
+import (
+	"html/template"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	var tests = []struct {
+		name      string
+		src       string
+		want      string
+		wantTitle string
+	}{
+		{"Headings", "# Getting Started\n\nSub\n---\n### Notes ###", "<h1 id=\"getting-started\">Getting Started</h1>\n" +
+			"<h2 id=\"sub\">Sub</h2>\n<h3 id=\"notes\">Notes</h3>\n", "Getting Started"},
+		{"Paragraphs", "one\ntwo  \nthree\n\nfour", "<p>one\ntwo<br>\nthree</p>\n<p>four</p>\n", ""},
+		{"Emphasis", "*a* **b** _c_ __d__ ~~e~~ snake_case_name *a **b** c*",
+			"<p><em>a</em> <strong>b</strong> <em>c</em> <strong>d</strong> <del>e</del> snake_case_name <em>a <strong>b</strong> c</em></p>\n", ""},
+		{"Unclosed", "2 * 3 * 4 and a_b", "<p>2 * 3 * 4 and a_b</p>\n", ""},
+		{"Code", "use `a <b>` or ``x ` y``", "<p>use <code>a &lt;b&gt;</code> or <code>x ` y</code></p>\n", ""},
+		{"Links", `[the *docs*](/docs/ "Docs") ![logo](/l.png) <https://example.com> [bad](javascript:alert(1))`,
+			`<p><a href="/docs/" title="Docs">the <em>docs</em></a> <img src="/l.png" alt="logo"> ` +
+				`<a href="https://example.com">https://example.com</a> <a href="#">bad</a></p>` + "\n", ""},
+		{"RawHTML", "<script>alert(1)</script> & co", "<p>&lt;script&gt;alert(1)&lt;/script&gt; &amp; co</p>\n", ""},
+		{"Escapes", `\*not\* \# \[x\]`, "<p>*not* # [x]</p>\n", ""},
+		{"Fence", "```go\nif a < b {\n}\n```\nafter", "<pre><code class=\"language-go\">if a &lt; b {\n}\n</code></pre>\n<p>after</p>\n", ""},
+		{"IndentedCode", "    $ make\n    $ make test\n\ntext", "<pre><code>$ make\n$ make test\n</code></pre>\n<p>text</p>\n", ""},
+		{"Quote", "> quoted\n> **text**", "<blockquote>\n<p>quoted\n<strong>text</strong></p>\n</blockquote>\n", ""},
+		{"Rule", "a\n\n***\n\nb", "<p>a</p>\n<hr>\n<p>b</p>\n", ""},
+		{"TightList", "- a\n- b\n  - c\n  - d\n- e", "<ul>\n<li>a</li>\n<li>b\n<ul>\n<li>c</li>\n<li>d</li>\n</ul></li>\n<li>e</li>\n</ul>\n", ""},
+		{"LooseList", "1. a\n\n2. b\n\n   more\n\nafter", "<ol>\n<li>\n<p>a</p></li>\n<li>\n<p>b</p>\n<p>more</p></li>\n</ol>\n<p>after</p>\n", ""},
+		{"OrderedStart", "3) c\n4) d", "<ol start=\"3\">\n<li>c</li>\n<li>d</li>\n</ol>\n", ""},
+		{"Table", "| Key | Default |\n| :-- | --: |\n| `addr` | :8080 |\n| a\\|b | |\n\nafter",
+			"<table>\n<thead>\n<tr><th style=\"text-align: left\">Key</th><th style=\"text-align: right\">Default</th></tr>\n</thead>\n<tbody>\n" +
+				"<tr><td style=\"text-align: left\"><code>addr</code></td><td style=\"text-align: right\">:8080</td></tr>\n" +
+				"<tr><td style=\"text-align: left\">a|b</td><td style=\"text-align: right\"></td></tr>\n</tbody>\n</table>\n<p>after</p>\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, title := renderMarkdown([]byte(tt.src))
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if tt.wantTitle != "" && title != tt.wantTitle {
+				t.Errorf("got title %q, want %q", title, tt.wantTitle)
+			}
+		})
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	fsys := fstest.MapFS{
+		"docs/guide.md":   {Data: []byte("# Guide\n\nRead *this*.\n")},
+		"docs/index.md":   {Data: []byte("no heading\n")},
+		"site/index.md":   {Data: []byte("# Site\n")},
+		"site/index.html": {Data: []byte("<p>html</p>")},
+	}
+	tmpl := template.Must(template.New("page").Parse("<title>{{.Title}}</title><main data-path=\"{{.Path}}\">{{.Body}}</main>"))
+	var tests = []struct {
+		name     string
+		markdown *Markdown
+		url      string
+		wantType string // "" for any but HTML, "-" for a 404
+		wantBody string
+	}{
+		{"Rendered", &Markdown{}, "/docs/guide.md", "text/html; charset=utf-8",
+			"<title>Guide</title>\n</head>\n<body>\n<h1 id=\"guide\">Guide</h1>\n<p>Read <em>this</em>.</p>\n</body>"},
+		{"Template", &Markdown{Template: tmpl}, "/docs/guide.md", "text/html; charset=utf-8",
+			"<title>Guide</title><main data-path=\"/docs/guide.md\"><h1 id=\"guide\">Guide</h1>\n<p>Read <em>this</em>.</p>\n</main>"},
+		{"Raw", &Markdown{}, "/docs/guide.md?raw", "text/markdown; charset=utf-8", "# Guide\n\nRead *this*.\n"},
+		{"RawQuery", &Markdown{RawQuery: "source"}, "/docs/guide.md?source=1", "text/markdown; charset=utf-8", "# Guide"},
+		{"Index", &Markdown{}, "/docs/", "text/html; charset=utf-8", "<title>index</title>"},
+		{"IndexHTMLFirst", &Markdown{}, "/site/", "text/html; charset=utf-8", "<p>html</p>"},
+		{"Off", nil, "/docs/guide.md", "", "# Guide"},
+		{"OffNoIndex", nil, "/docs/", "-", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				FS:       MountFS(fsys, "/srv"),
+				DocRoot:  "/srv",
+				ErrorLog: NewLogger(nil, LevelError),
+				Markdown: tt.markdown,
+			}
+			addr, _ := startTestServer(t, s)
+			res := exchangeRaw(t, addr, "GET "+tt.url+" HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 1)[0]
+			body, _ := io.ReadAll(res.BodyReader)
+			if tt.wantType == "-" {
+				if res.StatusCode != 404 {
+					t.Fatalf("got status %v, want 404", res.StatusCode)
+				}
+				return
+			}
+			if got := res.Header["Content-Type"]; res.StatusCode != 200 ||
+				tt.wantType == "" && strings.HasPrefix(got, "text/html") || tt.wantType != "" && got != tt.wantType {
+				t.Fatalf("got %v %q, want 200 %q", res.StatusCode, res.Header["Content-Type"], tt.wantType)
+			}
+			if !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("got body:\n%s\nwant it to contain:\n%s", body, tt.wantBody)
+			}
+		})
+	}
+}
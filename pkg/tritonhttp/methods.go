@@ -0,0 +1,51 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// methodAllowed reports whether the requests of method are among the
+// AllowedMethods of s.
+func (s *Server) methodAllowed(method string) bool {
+	if len(s.AllowedMethods) == 0 {
+		return method == "GET"
+	}
+	for _, m := range s.AllowedMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allow returns the Allow header listing the AllowedMethods of s, and
+// OPTIONS, which it always answers.
+func (s *Server) allow() string {
+	if len(s.AllowedMethods) == 0 {
+		return "GET, OPTIONS"
+	}
+	if s.methodAllowed("OPTIONS") {
+		return strings.Join(s.AllowedMethods, ", ")
+	}
+	return strings.Join(s.AllowedMethods, ", ") + ", OPTIONS"
+}
+
+// methodNotAllowed returns the 405 Method Not Allowed answering req,
+// whose method s does not allow.
+func (s *Server) methodNotAllowed(req *Request) *Response {
+	res := NewResponse(statusMethodNotAllowed)
+	res.Text(statusMethodNotAllowed, StatusText(statusMethodNotAllowed)+"\n")
+	res.Header["Allow"] = s.allow()
+	return res
+}
+
+// validateMethods records the problems with methods, the
+// AllowedMethods of a Server, in v.
+func validateMethods(v *validation, methods []string) {
+	for i, m := range methods {
+		if standardMethods[m] == "" || m == "CONNECT" {
+			v.add(fmt.Sprintf("AllowedMethods[%d]", i), fmt.Errorf("%q is not a standard method other than CONNECT", m))
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMethodNotAllowed(t *testing.T) {
+	fsys := fstest.MapFS{"index.html": {Data: []byte("home")}}
+	var tests = []struct {
+		name      string
+		allowed   []string
+		raw       string
+		wantAllow string
+	}{
+		{"Default", nil, "POST / HTTP/1.1\r\nHost: test\r\n\r\n", "GET, OPTIONS"},
+		{"Body", nil, "DELETE / HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\n\r\nhello", "GET, OPTIONS"},
+		{"Configured", []string{"GET", "HEAD", "POST"}, "PATCH / HTTP/1.1\r\nHost: test\r\n\r\n", "GET, HEAD, POST, OPTIONS"},
+		{"NotGet", []string{"POST"}, "GET / HTTP/1.1\r\nHost: test\r\n\r\n", "POST, OPTIONS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				FS:             MountFS(fsys, "/srv"),
+				DocRoot:        "/srv",
+				ErrorLog:       NewLogger(nil, LevelError),
+				AllowedMethods: tt.allowed,
+			}
+			addr, _ := startTestServer(t, s)
+			next := "POST / HTTP/1.1\r\nHost: test\r\n\r\n"
+			if len(tt.allowed) == 0 {
+				next = "GET / HTTP/1.1\r\nHost: test\r\n\r\n"
+			}
+			responses := exchangeRaw(t, addr, tt.raw+next, 2)
+			res := responses[0]
+			if res.StatusCode != 405 || res.Header["Allow"] != tt.wantAllow {
+				t.Fatalf("got %v with Allow %q, want 405 with Allow %q", res.StatusCode, res.Header["Allow"], tt.wantAllow)
+			}
+			if res.Header["Connection"] == "close" {
+				t.Fatal("got Connection close, want the connection kept")
+			}
+			// The connection serves the next request, of an allowed method
+			res = responses[1]
+			if body, _ := io.ReadAll(res.BodyReader); res.StatusCode != 200 || string(body) != "home" {
+				t.Errorf("got next %v %q, want 200 %q", res.StatusCode, body, "home")
+			}
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	s := &Server{
+		FS:             MountFS(fstest.MapFS{"index.html": {Data: []byte("home")}}, "/srv"),
+		DocRoot:        "/srv",
+		ErrorLog:       NewLogger(nil, LevelError),
+		AllowedMethods: []string{"GET", "HEAD"},
+	}
+	addr, _ := startTestServer(t, s)
+	for _, target := range []string{"*", "/", "/index.html", "/missing"} {
+		res := exchangeRaw(t, addr, "OPTIONS "+target+" HTTP/1.1\r\nHost: test\r\n\r\nGET / HTTP/1.1\r\nHost: test\r\n\r\n", 2)
+		if res[0].StatusCode != 200 || res[0].Header["Allow"] != "GET, HEAD, OPTIONS" || res[0].Header["Content-Length"] != "0" {
+			t.Errorf("OPTIONS %v: got %v with Allow %q, Content-Length %q, want 200 with Allow %q, Content-Length 0",
+				target, res[0].StatusCode, res[0].Header["Allow"], res[0].Header["Content-Length"], "GET, HEAD, OPTIONS")
+		}
+		if res[1].StatusCode != 200 {
+			t.Errorf("OPTIONS %v: got next %v, want 200", target, res[1].StatusCode)
+		}
+	}
+
+	// The asterisk form is only that of OPTIONS
+	if res := exchangeRaw(t, addr, "GET * HTTP/1.1\r\nHost: test\r\n\r\n", 1)[0]; res.StatusCode != 400 {
+		t.Errorf("GET *: got %v, want 400", res.StatusCode)
+	}
+}
+
+func TestHead(t *testing.T) {
+	s := &Server{
+		FS:             MountFS(fstest.MapFS{"a.txt": {Data: []byte("hello world")}}, "/srv"),
+		DocRoot:        "/srv",
+		ErrorLog:       NewLogger(nil, LevelError),
+		AllowedMethods: []string{"GET", "HEAD"},
+		Routes: []Route{{Prefix: "/w", Handler: WriterFunc(func(w ResponseWriter, req *Request) {
+			io.WriteString(w, "written")
+		})}},
+	}
+	addr, _ := startTestServer(t, s)
+	for _, tt := range []struct{ url, wantLength string }{{"/a.txt", "11"}, {"/w", "7"}} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		// A body sent after the headers would be taken for the next
+		// response on the connection
+		io.WriteString(conn, "HEAD "+tt.url+" HTTP/1.1\r\nHost: test\r\n\r\n"+
+			"GET /a.txt HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+		br := bufio.NewReader(conn)
+		res, err := ReadResponse(br, &Request{Method: "HEAD"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != 200 || res.Header["Content-Length"] != tt.wantLength {
+			t.Fatalf("HEAD %v: got %v with Content-Length %q, want 200 with %v", tt.url, res.StatusCode, res.Header["Content-Length"], tt.wantLength)
+		}
+		res, err = ReadResponse(br, &Request{Method: "GET"})
+		if err != nil {
+			t.Fatalf("HEAD %v: reading next got %v", tt.url, err)
+		}
+		if body, _ := io.ReadAll(res.BodyReader); res.StatusCode != 200 || string(body) != "hello world" {
+			t.Errorf("HEAD %v: got next %v %q, want 200 %q", tt.url, res.StatusCode, body, "hello world")
+		}
+		if rest, _ := io.ReadAll(br); len(rest) != 0 {
+			t.Errorf("HEAD %v: got %q after the responses", tt.url, rest)
+		}
+	}
+}
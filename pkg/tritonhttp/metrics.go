@@ -0,0 +1,178 @@
+package tritonhttp
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// otherLabel is the label of hosts and routes not listed in MetricLabels.
+const otherLabel = "other"
+
+// MetricLabels bounds the label values request metrics are broken down
+// by, so that arbitrary Host headers and URLs can't blow up their number.
+type MetricLabels struct {
+	// Hosts lists the virtual host names to label by, without port.
+	// Requests for any other host are labeled "other".
+	Hosts []string
+
+	// Routes lists the route patterns to label by, in path.Match syntax
+	// (e.g. "/images/*.png"), or ending in "/" to match a whole subtree.
+	// The first matching pattern is the label; requests matching none
+	// are labeled "other".
+	Routes []string
+
+	// Countries lists the ISO codes of the countries to label by, e.g.
+	// "US", as GeoIP finds them; requests from any other country, or
+	// one unknown, are labeled "other". If empty, metrics are not
+	// broken down by country.
+	Countries []string
+}
+
+// host returns the host label of a request for host.
+func (ml MetricLabels) host(host string) string {
+	host = strings.ToLower(splitHost(host))
+	for _, h := range ml.Hosts {
+		if strings.EqualFold(h, host) {
+			return h
+		}
+	}
+	return otherLabel
+}
+
+// route returns the route label of a request for urlPath.
+func (ml MetricLabels) route(urlPath string) string {
+	for _, pattern := range ml.Routes {
+		if strings.HasSuffix(pattern, "/") {
+			if strings.HasPrefix(urlPath, pattern) {
+				return pattern
+			}
+		} else if ok, _ := path.Match(pattern, urlPath); ok {
+			return pattern
+		}
+	}
+	return otherLabel
+}
+
+// country returns the country label of a request from country, or ""
+// if metrics are not labeled by country.
+func (ml MetricLabels) country(country string) string {
+	if len(ml.Countries) == 0 {
+		return ""
+	}
+	for _, c := range ml.Countries {
+		if country != "" && strings.EqualFold(c, country) {
+			return c
+		}
+	}
+	return otherLabel
+}
+
+// RouteKey identifies the traffic of one route on one virtual host,
+// from one country if MetricLabels.Countries is set.
+type RouteKey struct {
+	Host    string
+	Route   string
+	Country string
+}
+
+// RouteStats are the request metrics of one route on one virtual host.
+type RouteStats struct {
+	RouteKey
+	Requests int64
+	Statuses map[int]int64 // by status class: 2 for 2xx, 4 for 4xx, ...
+	Bytes    int64
+	Latency  time.Duration // total over all requests
+}
+
+// routeMetrics accumulates RouteStats per RouteKey. Requests update
+// them without taking any lock, as they would on every request: the
+// keys, bounded by MetricLabels, are only added once, and the counters
+// are atomic.
+type routeMetrics struct {
+	stats sync.Map // RouteKey to *routeCounters
+}
+
+// routeCounters are the RouteStats of one RouteKey, updated atomically.
+type routeCounters struct {
+	requests int64
+	bytes    int64
+	latency  int64
+	statuses [10]int64 // by status class
+}
+
+// record adds a request to the metrics of key.
+func (rm *routeMetrics) record(key RouteKey, status int, bytes int64, latency time.Duration) {
+	v, ok := rm.stats.Load(key)
+	if !ok {
+		v, _ = rm.stats.LoadOrStore(key, new(routeCounters))
+	}
+	c := v.(*routeCounters)
+	atomic.AddInt64(&c.requests, 1)
+	if class := status / 100; class >= 0 && class < len(c.statuses) {
+		atomic.AddInt64(&c.statuses[class], 1)
+	}
+	atomic.AddInt64(&c.bytes, bytes)
+	atomic.AddInt64(&c.latency, int64(latency))
+}
+
+// snapshot returns a copy of all metrics, sorted by host, route then
+// country.
+func (rm *routeMetrics) snapshot() []RouteStats {
+	all := make([]RouteStats, 0)
+	rm.stats.Range(func(k, v interface{}) bool {
+		c := v.(*routeCounters)
+		st := RouteStats{
+			RouteKey: k.(RouteKey),
+			Requests: atomic.LoadInt64(&c.requests),
+			Statuses: make(map[int]int64),
+			Bytes:    atomic.LoadInt64(&c.bytes),
+			Latency:  time.Duration(atomic.LoadInt64(&c.latency)),
+		}
+		for class := range c.statuses {
+			if n := atomic.LoadInt64(&c.statuses[class]); n > 0 {
+				st.Statuses[class] = n
+			}
+		}
+		all = append(all, st)
+		return true
+	})
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Host != all[j].Host {
+			return all[i].Host < all[j].Host
+		}
+		if all[i].Route != all[j].Route {
+			return all[i].Route < all[j].Route
+		}
+		return all[i].Country < all[j].Country
+	})
+	return all
+}
+
+// RouteStats returns the request metrics per virtual host and route,
+// labeled according to s.MetricLabels.
+func (s *Server) RouteStats() []RouteStats {
+	return s.routes.snapshot()
+}
+
+// finishRequest labels the finished request rec, records it in the
+// metrics and writes it to the access log.
+func (s *Server) finishRequest(rec *accessRecord) {
+	rec.key = RouteKey{Host: otherLabel, Route: otherLabel, Country: s.MetricLabels.country("")}
+	if rec.req != nil {
+		rec.key = RouteKey{
+			Host:    s.MetricLabels.host(rec.req.Host),
+			Route:   s.MetricLabels.route(rec.path),
+			Country: s.MetricLabels.country(rec.req.Geo.Country),
+		}
+	}
+	latency := time.Since(rec.start)
+	s.routes.record(rec.key, rec.status, rec.bytes, latency)
+	if s.StatsD != nil {
+		s.StatsD.recordRequest(rec.key, rec.status, rec.bytes, latency)
+	}
+	s.logAccess(rec)
+}
@@ -0,0 +1,85 @@
+package tritonhttp
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetricLabels(t *testing.T) {
+	ml := MetricLabels{
+		Hosts:  []string{"example.com"},
+		Routes: []string{"/images/*.png", "/docs/"},
+	}
+	var tests = []struct {
+		host, path string
+		want       RouteKey
+	}{
+		{"example.com", "/images/cat.png", RouteKey{Host: "example.com", Route: "/images/*.png"}},
+		{"EXAMPLE.com:8080", "/docs/a/b.html", RouteKey{Host: "example.com", Route: "/docs/"}},
+		{"evil.com", "/images/cat.jpg", RouteKey{Host: "other", Route: "other"}},
+	}
+	for _, tt := range tests {
+		got := RouteKey{Host: ml.host(tt.host), Route: ml.route(tt.path)}
+		if got != tt.want {
+			t.Fatalf("%v%v got: %v, want: %v", tt.host, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRouteStats(t *testing.T) {
+	s := &Server{MetricLabels: MetricLabels{Hosts: []string{"test"}, Routes: []string{"/"}}}
+	for _, status := range []int{200, 200, 404} {
+		rec := newAccessRecord("127.0.0.1:1234", &Request{URL: "/index.html", Host: "test"}, time.Now())
+		rec.status, rec.bytes = status, 10
+		s.finishRequest(rec)
+	}
+
+	stats := s.RouteStats()
+	if len(stats) != 1 {
+		t.Fatalf("got %v routes, want 1", len(stats))
+	}
+	st := stats[0]
+	if st.RouteKey != (RouteKey{Host: "test", Route: "/"}) || st.Requests != 3 || st.Bytes != 30 ||
+		st.Statuses[2] != 2 || st.Statuses[4] != 1 {
+		t.Fatalf("got: %+v", st)
+	}
+}
+
+func TestRouteStatsConcurrent(t *testing.T) {
+	var rm routeMetrics
+	keys := []RouteKey{{Host: "a", Route: "/"}, {Host: "b", Route: "/"}}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				rm.record(keys[(g+i)%2], 200+200*(i%2), 1, time.Millisecond)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	stats := rm.snapshot()
+	if len(stats) != 2 {
+		t.Fatalf("got %v routes, want 2", len(stats))
+	}
+	for _, st := range stats {
+		if st.Requests != 4000 || st.Bytes != 4000 || st.Latency != 4000*time.Millisecond ||
+			st.Statuses[2] != 2000 || st.Statuses[4] != 2000 {
+			t.Errorf("got: %+v", st)
+		}
+	}
+}
+
+func BenchmarkRouteMetricsParallel(b *testing.B) {
+	var rm routeMetrics
+	key := RouteKey{Host: "test", Route: "/"}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rm.record(key, 200, 1024, time.Millisecond)
+		}
+	})
+}
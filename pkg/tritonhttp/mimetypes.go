@@ -0,0 +1,213 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"os"
+	"strings"
+	"sync"
+)
+
+// systemMIMETypeFiles are the mime.types files of the system the
+// default registry loads, those that exist.
+var systemMIMETypeFiles = []string{
+	"/etc/mime.types",
+	"/etc/apache2/mime.types",
+	"/etc/apache/mime.types",
+	"/etc/httpd/conf/mime.types",
+}
+
+// defaultMIMETypes are the types of the extensions of the files of
+// web sites, taking precedence over those of the system, which are
+// often out of date.
+var defaultMIMETypes = map[string]string{
+	// Documents
+	".htm":         "text/html; charset=utf-8",
+	".html":        "text/html; charset=utf-8",
+	".xhtml":       "application/xhtml+xml",
+	".css":         "text/css; charset=utf-8",
+	".js":          "text/javascript; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".json":        "application/json",
+	".jsonld":      "application/ld+json",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+	".xml":         "text/xml; charset=utf-8",
+	".txt":         "text/plain; charset=utf-8",
+	".md":          "text/markdown; charset=utf-8",
+	".csv":         "text/csv; charset=utf-8",
+	".ics":         "text/calendar; charset=utf-8",
+	".rss":         "application/rss+xml",
+	".atom":        "application/atom+xml",
+	".pdf":         "application/pdf",
+	".wasm":        "application/wasm",
+
+	// Images
+	".apng": "image/apng",
+	".avif": "image/avif",
+	".bmp":  "image/bmp",
+	".gif":  "image/gif",
+	".ico":  "image/vnd.microsoft.icon",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".jxl":  "image/jxl",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".webp": "image/webp",
+
+	// Fonts
+	".otf":   "font/otf",
+	".ttf":   "font/ttf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+
+	// Audio and video
+	".aac":  "audio/aac",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".mp3":  "audio/mpeg",
+	".oga":  "audio/ogg",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".weba": "audio/webm",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".mp4":  "video/mp4",
+	".mpd":  "application/dash+xml",
+	".ogv":  "video/ogg",
+	".ts":   "video/mp2t",
+	".vtt":  "text/vtt; charset=utf-8",
+	".webm": "video/webm",
+
+	// Archives
+	".7z":  "application/x-7z-compressed",
+	".br":  "application/x-brotli",
+	".bz2": "application/x-bzip2",
+	".gz":  "application/gzip",
+	".jar": "application/java-archive",
+	".tar": "application/x-tar",
+	".xz":  "application/x-xz",
+	".zip": "application/zip",
+	".zst": "application/zstd",
+}
+
+// MIMETypes is a registry of the MIME types of file extensions. The
+// zero value is empty; it is safe for concurrent use.
+type MIMETypes struct {
+	mu    sync.RWMutex
+	types map[string]string // by lower-case extension
+}
+
+// NewMIMETypes returns a registry holding the default types of the
+// extensions of web sites, e.g. ".wasm", ".woff2", ".avif" and ".mjs".
+func NewMIMETypes() *MIMETypes {
+	m := &MIMETypes{types: make(map[string]string, len(defaultMIMETypes))}
+	for ext, typ := range defaultMIMETypes {
+		m.types[ext] = typ
+	}
+	return m
+}
+
+// TypeByExtension returns the MIME type of the extension ext, e.g.
+// ".html", looked up regardless of case, or "" if it has none.
+func (m *MIMETypes) TypeByExtension(ext string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.types[strings.ToLower(ext)]
+}
+
+// AddExtensionType sets the MIME type of the extension ext, which
+// starts with a dot, to typ. A text type without a charset is given
+// the UTF-8 one, as mime.AddExtensionType does.
+func (m *MIMETypes) AddExtensionType(ext, typ string) error {
+	if !strings.HasPrefix(ext, ".") || len(ext) == 1 || strings.ContainsAny(ext, "/ \t") {
+		return fmt.Errorf("%q is not a file extension, e.g. \".html\"", ext)
+	}
+	mediaType, params, err := mime.ParseMediaType(typ)
+	if err != nil {
+		return fmt.Errorf("type %q of %v: %v", typ, ext, err)
+	}
+	if strings.HasPrefix(mediaType, "text/") && params["charset"] == "" {
+		typ += "; charset=utf-8"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.types == nil {
+		m.types = make(map[string]string)
+	}
+	m.types[strings.ToLower(ext)] = typ
+	return nil
+}
+
+// LoadFile adds the types of the mime.types file name to m: each of its
+// lines is a type followed by its extensions, without dots, e.g.
+// "image/avif avif", and "#" starts a comment.
+func (m *MIMETypes) LoadFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.load(name, f, false)
+}
+
+// load adds the types of the mime.types file name, read from f, to m.
+// Those of a system file do not override the ones m has, and its
+// malformed lines are skipped rather than failing the load.
+func (m *MIMETypes) load(name string, f *os.File, system bool) error {
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, ext := range fields[1:] {
+			ext = "." + strings.TrimPrefix(ext, ".")
+			if system && m.TypeByExtension(ext) != "" {
+				continue
+			}
+			if err := m.AddExtensionType(ext, fields[0]); err != nil && !system {
+				return fmt.Errorf("%v:%v: %v", name, n, err)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+var (
+	mimeTypesOnce sync.Once
+	mimeTypes     *MIMETypes
+)
+
+// defaultMIMETypeRegistry returns the registry of MIMETypeByExtension,
+// made on first use of the default types and then those of the system
+// mime.types files it lacks.
+func defaultMIMETypeRegistry() *MIMETypes {
+	mimeTypesOnce.Do(func() {
+		mimeTypes = NewMIMETypes()
+		for _, name := range systemMIMETypeFiles {
+			if f, err := os.Open(name); err == nil {
+				_ = mimeTypes.load(name, f, true)
+				f.Close()
+			}
+		}
+	})
+	return mimeTypes
+}
+
+// AddExtensionType sets the MIME type MIMETypeByExtension returns for
+// the extension ext, e.g. ".glb", to typ, e.g. "model/gltf-binary".
+func AddExtensionType(ext, typ string) error {
+	return defaultMIMETypeRegistry().AddExtensionType(ext, typ)
+}
+
+// LoadMIMETypes adds the types of the mime.types file name to those
+// MIMETypeByExtension returns, in place of those it had for the same
+// extensions. See MIMETypes.LoadFile.
+func LoadMIMETypes(name string) error {
+	return defaultMIMETypeRegistry().LoadFile(name)
+}
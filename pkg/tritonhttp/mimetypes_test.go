@@ -0,0 +1,103 @@
+package tritonhttp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMIMETypes(t *testing.T) {
+	m := NewMIMETypes()
+	for ext, want := range map[string]string{
+		".wasm":  "application/wasm",
+		".woff2": "font/woff2",
+		".avif":  "image/avif",
+		".mjs":   "text/javascript; charset=utf-8",
+		".HTML":  "text/html; charset=utf-8",
+		".nope":  "",
+	} {
+		if got := m.TypeByExtension(ext); got != want {
+			t.Errorf("TypeByExtension(%q) got: %q, want: %q", ext, got, want)
+		}
+	}
+
+	var tests = []struct {
+		ext, typ string
+		want     string // "" if an error is wanted
+	}{
+		{".glb", "model/gltf-binary", "model/gltf-binary"},
+		{".Conf", "text/plain", "text/plain; charset=utf-8"},
+		{".sjis", "text/plain; charset=shift_jis", "text/plain; charset=shift_jis"},
+		{"glb", "model/gltf-binary", ""},
+		{".", "model/gltf-binary", ""},
+		{".glb", "not a type", ""},
+	}
+	for _, tt := range tests {
+		err := m.AddExtensionType(tt.ext, tt.typ)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("AddExtensionType(%q, %q) got no error", tt.ext, tt.typ)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("AddExtensionType(%q, %q) got error: %v", tt.ext, tt.typ, err)
+		} else if got := m.TypeByExtension(strings.ToUpper(tt.ext)); got != tt.want {
+			t.Errorf("after AddExtensionType(%q, %q) got: %q, want: %q", tt.ext, tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestMIMETypesLoadFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "mime.types")
+	data := "# comment\napplication/x-foo\tfoo fooz # trailing\n\ntext/x-script js\n"
+	if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file given by the user overrides the defaults
+	m := NewMIMETypes()
+	if err := m.LoadFile(name); err != nil {
+		t.Fatal(err)
+	}
+	for ext, want := range map[string]string{".foo": "application/x-foo", ".fooz": "application/x-foo", ".js": "text/x-script; charset=utf-8"} {
+		if got := m.TypeByExtension(ext); got != want {
+			t.Errorf("TypeByExtension(%q) got: %q, want: %q", ext, got, want)
+		}
+	}
+
+	// One of the system's only adds to them
+	m = NewMIMETypes()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := m.load(name, f, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.TypeByExtension(".js"); got != "text/javascript; charset=utf-8" {
+		t.Errorf("system file overrode .js with %q", got)
+	}
+	if got := m.TypeByExtension(".foo"); got != "application/x-foo" {
+		t.Errorf("system file did not add .foo, got %q", got)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.types")
+	if err := os.WriteFile(bad, []byte("application/x-ok ok\nnot//type bad\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewMIMETypes().LoadFile(bad); err == nil || !strings.Contains(err.Error(), "bad.types:2") {
+		t.Errorf("LoadFile of a malformed file got: %v, want the line of the error", err)
+	}
+}
+
+func TestAddExtensionType(t *testing.T) {
+	if err := AddExtensionType(".tritontest", "application/x-triton-test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := MIMETypeByExtension(".tritontest"); got != "application/x-triton-test" {
+		t.Errorf("MIMETypeByExtension got: %q, want the added type", got)
+	}
+}
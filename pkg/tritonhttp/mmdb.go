@@ -0,0 +1,307 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// mmdbMetadataMarker precedes the metadata at the end of a MaxMind DB.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// maxMMDBDepth bounds how deep the values of a MaxMind DB may nest, and
+// the pointers followed to decode one, so that a corrupt file cannot
+// loop forever.
+const maxMMDBDepth = 32
+
+// mmdb is a MaxMind DB, e.g. GeoLite2-Country.mmdb, read in memory: a
+// binary search tree over the bits of IP addresses, whose leaves point
+// to records in its data section. See
+// https://maxmind.github.io/MaxMind-DB/.
+type mmdb struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dbType     string
+	ipv4Start  uint // the node of ::/96, where IPv4 lookups start
+}
+
+// openMMDB reads the MaxMind DB file name.
+func openMMDB(name string) (*mmdb, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	db, err := parseMMDB(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	return db, nil
+}
+
+// parseMMDB parses the MaxMind DB buf.
+func parseMMDB(buf []byte) (*mmdb, error) {
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, errors.New("not a MaxMind DB: no metadata")
+	}
+	v, _, err := mmdbDecoder(buf[i+len(mmdbMetadataMarker):]).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %v", err)
+	}
+	md, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("metadata is not a map")
+	}
+	db := &mmdb{
+		nodeCount:  mmdbUint(md["node_count"]),
+		recordSize: mmdbUint(md["record_size"]),
+		ipVersion:  mmdbUint(md["ip_version"]),
+	}
+	db.dbType, _ = md["database_type"].(string)
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %v", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %v", db.ipVersion)
+	}
+	treeSize := db.recordSize / 4 * db.nodeCount
+	// The tree is followed by 16 zero bytes, then the data section
+	if treeSize+16 > uint(i) {
+		return nil, fmt.Errorf("search tree of %v nodes overruns the file", db.nodeCount)
+	}
+	db.tree, db.data = buf[:treeSize], buf[treeSize+16:i]
+	if db.ipVersion == 6 {
+		for n := 0; n < 96 && db.ipv4Start < db.nodeCount; n++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record returns the left, bit 0, or right, bit 1, record of node.
+func (db *mmdb) record(node, bit uint) uint {
+	b := db.tree
+	switch db.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		off := node * 7
+		if bit == 0 {
+			return uint(b[off+3]&0xf0)<<20 | uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+		}
+		return uint(b[off+3]&0x0f)<<24 | uint(b[off+4])<<16 | uint(b[off+5])<<8 | uint(b[off+6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[node*8+bit*4:]))
+	}
+}
+
+// lookup returns the record of ip, or nil if the database has none.
+func (db *mmdb) lookup(ip netip.Addr) (any, error) {
+	var addr []byte
+	node := uint(0)
+	if ip = ip.Unmap(); ip.Is4() {
+		a := ip.As4()
+		addr, node = a[:], db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	} else {
+		a := ip.As16()
+		addr = a[:]
+	}
+	for i := 0; i < len(addr)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, uint(addr[i/8]>>(7-i%8))&1)
+	}
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+	case node < db.nodeCount:
+		return nil, errors.New("search tree deeper than addresses")
+	}
+	v, _, err := mmdbDecoder(db.data).decode(node-db.nodeCount-16, 0)
+	return v, err
+}
+
+// mmdbDecoder decodes the values of a section of a MaxMind DB, whose
+// pointers are offsets in it.
+type mmdbDecoder []byte
+
+var errMMDBTruncated = errors.New("truncated data")
+
+// bytes returns the n bytes at off in d, and the offset after them.
+func (d mmdbDecoder) bytes(off, n uint) ([]byte, uint, error) {
+	if off+n > uint(len(d)) || off+n < off {
+		return nil, 0, errMMDBTruncated
+	}
+	return d[off : off+n], off + n, nil
+}
+
+// decode decodes the value at off in d, nested depth levels deep, and
+// returns it with the offset after it: a map[string]any, []any, string,
+// []byte, float64, uint64, int64 or bool.
+func (d mmdbDecoder) decode(off uint, depth int) (any, uint, error) {
+	if depth > maxMMDBDepth {
+		return nil, 0, errors.New("values nest too deep")
+	}
+	b, off, err := d.bytes(off, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	typ := uint(ctrl >> 5)
+	if typ == 1 {
+		ptr, next, err := d.pointer(ctrl, off)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decode(ptr, depth+1)
+		return v, next, err
+	}
+	if typ == 0 {
+		if b, off, err = d.bytes(off, 1); err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + uint(b[0])
+	}
+	size, off, err := d.size(ctrl, off)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch typ {
+	case 7: // map
+		m := make(map[string]any, min(size, 64))
+		for i := uint(0); i < size; i++ {
+			var k, v any
+			if k, off, err = d.decode(off, depth+1); err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			if v, off, err = d.decode(off, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+		}
+		return m, off, nil
+	case 11: // array
+		a := make([]any, 0, min(size, 64))
+		for i := uint(0); i < size; i++ {
+			var v any
+			if v, off, err = d.decode(off, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, off, nil
+	case 14: // boolean, whose size is its value
+		return size != 0, off, nil
+	}
+
+	if b, off, err = d.bytes(off, size); err != nil {
+		return nil, 0, err
+	}
+	switch typ {
+	case 2: // UTF-8 string
+		return string(b), off, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of %v bytes", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case 4: // bytes
+		return bytes.Clone(b), off, nil
+	case 5, 6, 9: // unsigned 16, 32 and 64-bit integers
+		if size > 8 {
+			return nil, 0, fmt.Errorf("integer of %v bytes", size)
+		}
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		return u, off, nil
+	case 8: // signed 32-bit integer
+		if size > 4 {
+			return nil, 0, fmt.Errorf("int32 of %v bytes", size)
+		}
+		var u uint32
+		for _, c := range b {
+			u = u<<8 | uint32(c)
+		}
+		return int64(int32(u)), off, nil
+	case 10: // unsigned 128-bit integer, as its bytes
+		return bytes.Clone(b), off, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of %v bytes", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %v", typ)
+}
+
+// size returns the size of the value of the control byte ctrl, whose
+// extra bytes, if any, are at off, and the offset after them.
+func (d mmdbDecoder) size(ctrl byte, off uint) (uint, uint, error) {
+	size := uint(ctrl & 0x1f)
+	if size < 29 {
+		return size, off, nil
+	}
+	n := size - 28
+	b, off, err := d.bytes(off, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	var extra uint
+	for _, c := range b {
+		extra = extra<<8 | uint(c)
+	}
+	switch n {
+	case 1:
+		return 29 + extra, off, nil
+	case 2:
+		return 285 + extra, off, nil
+	default:
+		return 65821 + extra, off, nil
+	}
+}
+
+// pointer returns the offset the pointer of the control byte ctrl, and
+// of the bytes at off, points to, and the offset after it.
+func (d mmdbDecoder) pointer(ctrl byte, off uint) (uint, uint, error) {
+	n := uint(ctrl>>3&3) + 1
+	b, off, err := d.bytes(off, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	var p uint
+	if n < 4 {
+		p = uint(ctrl & 7)
+	}
+	for _, c := range b {
+		p = p<<8 | uint(c)
+	}
+	switch n {
+	case 2:
+		p += 2048
+	case 3:
+		p += 526336
+	}
+	return p, off, nil
+}
+
+// mmdbUint returns v, a decoded unsigned integer, as a uint, or 0 if it
+// is not one.
+func mmdbUint(v any) uint {
+	u, _ := v.(uint64)
+	return uint(u)
+}
@@ -0,0 +1,244 @@
+package tritonhttp
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// variant is a file of the doc root the path of a request may be
+// served with, e.g. index.en.html for "/index.html".
+type variant struct {
+	name     string // the path of the file
+	mimeType string // "" if its extension has none
+	lang     string // e.g. "en", or "" for none
+	exact    bool   // it is the file the path names
+}
+
+// negotiated is the result of a negotiation: the file chosen, and the
+// headers describing the choice.
+type negotiated struct {
+	name string
+	lang string // the Content-Language, if any
+	vary string // the Vary header, if the choice depends on the request
+}
+
+// negotiate chooses, among name, the file the path of req names, and
+// its variants next to it, the one the Accept and Accept-Language
+// headers of req prefer, or false if they accept none. The variants of
+// "dir/stem.ext", or "dir/stem", are the files "dir/stem.lang.ext",
+// "dir/stem.lang" and "dir/stem.other", whose type is that of their
+// extension and lang a language tag, e.g. "en" or "zh-TW". Without
+// variants, name is chosen as is.
+func (s *Server) negotiate(req *Request, name string) (negotiated, bool) {
+	variants := s.variants(name)
+	if len(variants) == 0 || len(variants) == 1 && variants[0].exact {
+		return negotiated{name: name}, true
+	}
+	types := parseAccept(req.Header["Accept"])
+	langs := parseAccept(req.Header["Accept-Language"])
+	var best *variant
+	bestQ := 0.0
+	for i := range variants {
+		v := &variants[i]
+		q := 1.0
+		if len(types) > 0 {
+			q *= acceptQ(types, v.mimeType, matchType)
+		}
+		if len(langs) > 0 {
+			if v.lang == "" {
+				// Better than none the client refuses, worse than any
+				// it asks for
+				q *= 0.001
+			} else {
+				q *= acceptQ(langs, v.lang, matchLanguage)
+			}
+		}
+		if q > bestQ || q == bestQ && q > 0 && v.exact {
+			best, bestQ = v, q
+		}
+	}
+
+	var vary []string
+	for _, v := range variants[1:] {
+		if v.mimeType != variants[0].mimeType {
+			vary = append(vary, "Accept")
+			break
+		}
+	}
+	for _, v := range variants[1:] {
+		if v.lang != variants[0].lang {
+			vary = append(vary, "Accept-Language")
+			break
+		}
+	}
+	if best == nil {
+		return negotiated{vary: strings.Join(vary, ", ")}, false
+	}
+	return negotiated{name: best.name, lang: best.lang, vary: strings.Join(vary, ", ")}, true
+}
+
+// variants returns the variants of the file at name, name included if
+// it exists, in the order of their names.
+func (s *Server) variants(name string) []variant {
+	dir, base := filepath.Split(name)
+	stem := base
+	if ext := filepath.Ext(base); ext != "" {
+		stem = strings.TrimSuffix(base, ext)
+	}
+	if stem == "" {
+		return nil
+	}
+	entries, err := s.fileSystem().ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil
+	}
+	var variants []variant
+	for _, e := range entries {
+		n := e.Name()
+		if e.IsDir() || !strings.HasPrefix(n, stem) {
+			continue
+		}
+		v := variant{name: dir + n, exact: n == base}
+		rest := n[len(stem):]
+		switch {
+		case v.exact:
+			v.mimeType = MIMETypeByExtension(filepath.Ext(n))
+		case !strings.HasPrefix(rest, "."):
+			continue
+		default:
+			// ".ext", ".lang" or ".lang.ext"
+			parts := strings.Split(rest[1:], ".")
+			switch {
+			case len(parts) == 1 && MIMETypeByExtension("."+parts[0]) != "":
+				v.mimeType = MIMETypeByExtension("." + parts[0])
+			case len(parts) == 1 && isLanguageTag(parts[0]):
+				v.lang = strings.ToLower(parts[0])
+				v.mimeType = MIMETypeByExtension(filepath.Ext(base))
+			case len(parts) == 2 && isLanguageTag(parts[0]) && MIMETypeByExtension("."+parts[1]) != "":
+				v.lang = strings.ToLower(parts[0])
+				v.mimeType = MIMETypeByExtension("." + parts[1])
+			default:
+				continue
+			}
+		}
+		variants = append(variants, v)
+	}
+	return variants
+}
+
+// isLanguageTag reports whether s looks like a language tag of a
+// variant: a two-letter primary language, and optionally subtags, e.g.
+// "en", "pt-BR" or "zh-Hant".
+func isLanguageTag(s string) bool {
+	primary, rest, _ := strings.Cut(s, "-")
+	if len(primary) != 2 || !isAlpha(primary) {
+		return false
+	}
+	for rest != "" {
+		var sub string
+		sub, rest, _ = strings.Cut(rest, "-")
+		if len(sub) < 2 || len(sub) > 8 || !isAlnum(sub) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i] | 0x20; c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlnum(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && ((c|0x20) < 'a' || (c|0x20) > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// acceptRange is a range of an Accept or Accept-Language header, with
+// its quality.
+type acceptRange struct {
+	value string
+	q     float64
+}
+
+// parseAccept parses the ranges of an Accept or Accept-Language header
+// h, lower-cased and without parameters other than q.
+func parseAccept(h string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(h, ",") {
+		value, params, _ := strings.Cut(part, ";")
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+		r := acceptRange{value: value, q: 1}
+		for _, p := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(p, "=")
+			if strings.EqualFold(strings.TrimSpace(k), "q") {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && q >= 0 && q <= 1 {
+					r.q = q
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// acceptQ returns the quality ranges give value: that of the most
+// specific range match says value matches, or 0 if none does.
+func acceptQ(ranges []acceptRange, value string, match func(rng, value string) int) float64 {
+	q, best := 0.0, 0
+	for _, r := range ranges {
+		if m := match(r.value, value); m > best {
+			q, best = r.q, m
+		}
+	}
+	return q
+}
+
+// matchType returns how specifically the media range rng, e.g.
+// "image/*", matches the MIME type value: 3 exactly, 2 for its type, 1
+// for "*/*", and 0 if not at all.
+func matchType(rng, value string) int {
+	value, _, _ = strings.Cut(value, ";")
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch {
+	case rng == "*/*":
+		return 1
+	case value == "":
+		return 0
+	case rng == value:
+		return 3
+	case strings.HasSuffix(rng, "/*") && strings.HasPrefix(value, rng[:len(rng)-1]):
+		return 2
+	}
+	return 0
+}
+
+// matchLanguage returns how specifically the language range rng, e.g.
+// "en", matches the tag value, e.g. "en-gb": the longer the range
+// matching it, the higher, down to 1 for "*", and 0 if it does not
+// match at all. A tag matches the longer ranges it is a prefix of, less
+// specifically than those it starts with.
+func matchLanguage(rng, value string) int {
+	switch {
+	case rng == "*":
+		return 1
+	case rng == value || strings.HasPrefix(value, rng+"-"):
+		return len(rng) + 2
+	case strings.HasPrefix(rng, value+"-"):
+		// "en-us" is served "en" rather than nothing
+		return len(value) + 1
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package tritonhttp
+
+import (
+	"io"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNegotiate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":    {Data: []byte("default")},
+		"index.en.html": {Data: []byte("english")},
+		"index.zh.html": {Data: []byte("chinese")},
+		"logo.png":      {Data: []byte("png")},
+		"logo.webp":     {Data: []byte("webp")},
+		"about.en.html": {Data: []byte("about")},
+		"about.fr.html": {Data: []byte("à propos")},
+		"style.css":     {Data: []byte("css")},
+		"style.min.css": {Data: []byte("min")},
+	}
+	var tests = []struct {
+		name       string
+		off        bool
+		url        string
+		header     string
+		wantBody   string
+		wantLang   string
+		wantVary   string
+		wantStatus int
+	}{
+		{"Default", false, "/", "", "default", "", "Accept-Language", 200},
+		{"Language", false, "/", "Accept-Language: zh-CN, zh;q=0.9, en;q=0.8\r\n", "chinese", "zh", "Accept-Language", 200},
+		{"Region", false, "/index.html", "Accept-Language: en-GB\r\n", "english", "en", "Accept-Language", 200},
+		{"Refused", false, "/index.html", "Accept-Language: de, en;q=0\r\n", "default", "", "Accept-Language", 200},
+		{"Type", false, "/logo.png", "Accept: image/webp, image/*;q=0.8\r\n", "webp", "", "Accept", 200},
+		{"TypeAsked", false, "/logo.png", "Accept: image/png, image/*;q=0.8\r\n", "png", "", "Accept", 200},
+		{"TypeAny", false, "/logo.png", "Accept: */*\r\n", "png", "", "Accept", 200},
+		{"NoExact", false, "/logo", "", "png", "", "Accept", 200},
+		{"OnlyVariants", false, "/about.html", "Accept-Language: fr-CA, en;q=0.5\r\n", "à propos", "fr", "Accept-Language", 200},
+		{"NotAcceptable", false, "/about.html", "Accept-Language: de\r\n", "Not Acceptable\n", "", "Accept-Language", 406},
+		{"NoVariants", false, "/style.css", "Accept-Language: en\r\n", "css", "", "", 200},
+		{"Off", true, "/about.html", "Accept-Language: en\r\n", "", "", "", 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				FS:        MountFS(fsys, "/srv"),
+				DocRoot:   "/srv",
+				ErrorLog:  NewLogger(nil, LevelError),
+				Negotiate: !tt.off,
+			}
+			addr, _ := startTestServer(t, s)
+			res := exchangeRaw(t, addr, "GET "+tt.url+" HTTP/1.1\r\nHost: test\r\nConnection: close\r\n"+tt.header+"\r\n", 1)[0]
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == 404 {
+				return
+			}
+			body, _ := io.ReadAll(res.BodyReader)
+			if string(body) != tt.wantBody {
+				t.Errorf("got body %q, want %q", body, tt.wantBody)
+			}
+			if res.Header["Content-Language"] != tt.wantLang {
+				t.Errorf("got Content-Language %q, want %q", res.Header["Content-Language"], tt.wantLang)
+			}
+			if res.Header["Vary"] != tt.wantVary {
+				t.Errorf("got Vary %q, want %q", res.Header["Vary"], tt.wantVary)
+			}
+		})
+	}
+}
+
+func TestAcceptQ(t *testing.T) {
+	var tests = []struct {
+		header string
+		value  string
+		match  func(rng, value string) int
+		want   float64
+	}{
+		{"text/html, */*;q=0.1", "text/html", matchType, 1},
+		{"text/html, */*;q=0.1", "image/png", matchType, 0.1},
+		{"image/*;q=0.5, image/png;q=0", "image/png", matchType, 0},
+		{"image/*;q=0.5, image/png;q=0", "image/gif", matchType, 0.5},
+		{"text/plain", "text/plain; charset=utf-8", matchType, 1},
+		{"en;q=0.5, en-us;q=0.8", "en-us", matchLanguage, 0.8},
+		{"en;q=0.5, en-us;q=0.8", "en-gb", matchLanguage, 0.5},
+		{"en-us;q=0.7", "en", matchLanguage, 0.7},
+		{"fr, *;q=0.2", "de", matchLanguage, 0.2},
+		{"fr;q=bad", "fr", matchLanguage, 1},
+	}
+	for _, tt := range tests {
+		if got := acceptQ(parseAccept(tt.header), tt.value, tt.match); got != tt.want {
+			t.Errorf("acceptQ(%q, %q) got: %v, want: %v", tt.header, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !race
+
+package tritonhttp
+
+// raceEnabled reports that the race detector is on, under which
+// sync.Pool drops items at random and allocations cannot be counted.
+const raceEnabled = false
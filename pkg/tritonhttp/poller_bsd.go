@@ -0,0 +1,61 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package tritonhttp
+
+import (
+	"syscall"
+	"time"
+)
+
+// netPoller waits for parked connections to be readable with kqueue.
+type netPoller struct {
+	fd     int
+	events []syscall.Kevent_t
+}
+
+func newNetPoller() (*netPoller, error) {
+	fd, err := syscall.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	return &netPoller{fd: fd, events: make([]syscall.Kevent_t, 128)}, nil
+}
+
+// add waits for fd to be readable, or hung up, once.
+func (p *netPoller) add(fd int) error {
+	return p.change(fd, syscall.EV_ADD|syscall.EV_ONESHOT)
+}
+
+// remove stops waiting for fd.
+func (p *netPoller) remove(fd int) error {
+	return p.change(fd, syscall.EV_DELETE)
+}
+
+func (p *netPoller) change(fd, flags int) error {
+	var ev syscall.Kevent_t
+	syscall.SetKevent(&ev, fd, syscall.EVFILT_READ, flags)
+	_, err := syscall.Kevent(p.fd, []syscall.Kevent_t{ev}, nil, nil)
+	return err
+}
+
+// wait calls ready with the descriptors that became ready within
+// timeout.
+func (p *netPoller) wait(timeout time.Duration, ready func(fd int)) error {
+	ts := syscall.NsecToTimespec(int64(timeout))
+	n, err := syscall.Kevent(p.fd, nil, p.events, &ts)
+	if err == syscall.EINTR {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, ev := range p.events[:n] {
+		ready(int(ev.Ident))
+	}
+	return nil
+}
+
+func (p *netPoller) close() error {
+	return syscall.Close(p.fd)
+}
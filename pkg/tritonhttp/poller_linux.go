@@ -0,0 +1,53 @@
+//go:build linux
+
+package tritonhttp
+
+import (
+	"syscall"
+	"time"
+)
+
+// netPoller waits for parked connections to be readable with epoll.
+type netPoller struct {
+	fd     int
+	events []syscall.EpollEvent
+}
+
+func newNetPoller() (*netPoller, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &netPoller{fd: fd, events: make([]syscall.EpollEvent, 128)}, nil
+}
+
+// add waits for fd to be readable, or hung up, once.
+func (p *netPoller) add(fd int) error {
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT, Fd: int32(fd)}
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_ADD, fd, &ev)
+}
+
+// remove stops waiting for fd.
+func (p *netPoller) remove(fd int) error {
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, fd, &syscall.EpollEvent{})
+}
+
+// wait calls ready with the descriptors that became ready within
+// timeout.
+func (p *netPoller) wait(timeout time.Duration, ready func(fd int)) error {
+	n, err := syscall.EpollWait(p.fd, p.events, int(timeout/time.Millisecond))
+	if err == syscall.EINTR {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, ev := range p.events[:n] {
+		ready(int(ev.Fd))
+	}
+	return nil
+}
+
+func (p *netPoller) close() error {
+	return syscall.Close(p.fd)
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package tritonhttp
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// netPoller is not available on systems without epoll or kqueue.
+type netPoller struct{}
+
+func newNetPoller() (*netPoller, error) {
+	return nil, fmt.Errorf("no epoll or kqueue on %v", runtime.GOOS)
+}
+
+func (p *netPoller) add(fd int) error                                     { return nil }
+func (p *netPoller) remove(fd int) error                                  { return nil }
+func (p *netPoller) wait(timeout time.Duration, ready func(fd int)) error { return nil }
+func (p *netPoller) close() error                                         { return nil }
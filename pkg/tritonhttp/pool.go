@@ -0,0 +1,138 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// maxPooledHeaders is the most headers a pooled header map may have
+// held to be reused: maps never shrink, and a few requests with many
+// headers should not keep large maps around.
+const maxPooledHeaders = 32
+
+// maxPooledScratch is the largest scratch buffer kept for reuse.
+const maxPooledScratch = 8 << 10
+
+// The sizes of the pooled copy buffers streaming bodies: the small
+// ones for most, the large ones for bodies longer than a large buffer,
+// taking fewer reads and writes.
+const (
+	smallCopyBuffer = 32 << 10
+	largeCopyBuffer = 256 << 10
+)
+
+var (
+	requestPool  = sync.Pool{New: func() interface{} { return new(Request) }}
+	responsePool = sync.Pool{New: func() interface{} { return new(Response) }}
+	scratchPool  = sync.Pool{New: func() interface{} { b := make([]byte, 0, 1<<10); return &b }}
+	readerPool   sync.Pool
+
+	smallCopyPool = sync.Pool{New: func() interface{} { b := make([]byte, smallCopyBuffer); return &b }}
+	largeCopyPool = sync.Pool{New: func() interface{} { b := make([]byte, largeCopyBuffer); return &b }}
+)
+
+// getRequest returns a zero Request from the pool, with an empty
+// Header map.
+func getRequest() *Request {
+	req := requestPool.Get().(*Request)
+	if req.Header == nil {
+		req.Header = make(map[string]string)
+	}
+	return req
+}
+
+// releaseRequest puts req back in the pool, once the server is done
+// with it: neither req nor its Header may be used afterwards.
+func releaseRequest(req *Request) {
+	h := reusableHeader(req.Header)
+	*req = Request{Header: h}
+	requestPool.Put(req)
+}
+
+// getResponse returns a zero Response from the pool, to be put back
+// by releaseResponse once written. The Handle methods reuse its
+// Header map.
+func getResponse() *Response {
+	res := responsePool.Get().(*Response)
+	res.pooled = true
+	return res
+}
+
+// releaseResponse puts res back in the pool if it came from it, once
+// written: neither res nor its Header may be used afterwards.
+func releaseResponse(res *Response) {
+	if res == nil || !res.pooled {
+		return
+	}
+	h := reusableHeader(res.Header)
+	*res = Response{Header: h}
+	responsePool.Put(res)
+}
+
+// reusableHeader returns h cleared, or nil if it is too large to keep.
+func reusableHeader(h map[string]string) map[string]string {
+	if len(h) > maxPooledHeaders {
+		return nil
+	}
+	clear(h)
+	return h
+}
+
+// freshHeader returns an empty header map for res: its own, cleared,
+// if res came from the pool, or a new one.
+func (res *Response) freshHeader() map[string]string {
+	if res.pooled && res.Header != nil {
+		clear(res.Header)
+		return res.Header
+	}
+	return make(map[string]string)
+}
+
+// getScratch returns an empty buffer from the pool.
+func getScratch() *[]byte {
+	b := scratchPool.Get().(*[]byte)
+	*b = (*b)[:0]
+	return b
+}
+
+// putScratch puts b back in the pool, unless it grew too large.
+func putScratch(b *[]byte) {
+	if cap(*b) <= maxPooledScratch {
+		scratchPool.Put(b)
+	}
+}
+
+// getBufioReader returns a reader of r from the pool.
+func getBufioReader(r io.Reader) *bufio.Reader {
+	if br, ok := readerPool.Get().(*bufio.Reader); ok {
+		br.Reset(r)
+		return br
+	}
+	return bufio.NewReader(r)
+}
+
+// putBufioReader puts br back in the pool, once nothing is buffered
+// in it, or left to read from it.
+func putBufioReader(br *bufio.Reader) {
+	br.Reset(nil)
+	readerPool.Put(br)
+}
+
+// getCopyBuffer returns a buffer from the pool to copy a body of n
+// bytes with, or of unknown length if n is negative.
+func getCopyBuffer(n int64) *[]byte {
+	if n > largeCopyBuffer {
+		return largeCopyPool.Get().(*[]byte)
+	}
+	return smallCopyPool.Get().(*[]byte)
+}
+
+// putCopyBuffer puts b, from getCopyBuffer, back in its pool.
+func putCopyBuffer(b *[]byte) {
+	if len(*b) == largeCopyBuffer {
+		largeCopyPool.Put(b)
+	} else {
+		smallCopyPool.Put(b)
+	}
+}
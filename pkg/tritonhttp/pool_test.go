@@ -0,0 +1,135 @@
+package tritonhttp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReleaseRequest(t *testing.T) {
+	req := getRequest()
+	req.Method, req.URL, req.Close = "GET", "/a", true
+	req.Header["X-A"] = "a"
+	releaseRequest(req)
+	if req.Method != "" || req.URL != "" || req.Close || len(req.Header) != 0 || req.Header == nil {
+		t.Fatalf("released request not reset: %+v", req)
+	}
+
+	big := getRequest()
+	for i := 0; i <= maxPooledHeaders; i++ {
+		big.Header[string(rune('A'+i))] = "x"
+	}
+	releaseRequest(big)
+	if big.Header != nil {
+		t.Fatalf("header map of %v entries kept for reuse", maxPooledHeaders+1)
+	}
+	if req := getRequest(); req.Header == nil || len(req.Header) != 0 {
+		t.Fatalf("got header %v from the pool, want an empty map", req.Header)
+	}
+}
+
+func TestReleaseResponse(t *testing.T) {
+	// Responses not from the pool are left alone
+	res := &Response{StatusCode: 200, Header: map[string]string{"X-A": "a"}}
+	releaseResponse(res)
+	if res.StatusCode != 200 || res.Header["X-A"] != "a" {
+		t.Fatalf("unpooled response changed: %+v", res)
+	}
+
+	res = getResponse()
+	res.HandleNotFound(&Request{})
+	h := res.Header
+	releaseResponse(res)
+	if res.StatusCode != 0 || res.pooled || len(res.Header) != 0 {
+		t.Fatalf("released response not reset: %+v", res)
+	}
+	// The Handle methods reuse the header map of pooled responses
+	res.pooled = true
+	res.HandleNotFound(&Request{Close: true})
+	if len(res.Header) != 2 || len(h) != 2 || res.Header["Connection"] != "close" {
+		t.Fatalf("got header %v, want the pooled map with Date and Connection", res.Header)
+	}
+}
+
+func TestPooledKeepAlive(t *testing.T) {
+	echo := HandlerFunc(func(req *Request) *Response {
+		res := NewResponse(200)
+		res.Text(200, req.Header["X-Test"])
+		return res
+	})
+	s := &Server{
+		FS:       MountFS(fstest.MapFS{"a.txt": {Data: []byte("a")}, "b.html": {Data: []byte("<b>")}}, "/srv"),
+		DocRoot:  "/srv",
+		ErrorLog: NewLogger(nil, LevelError),
+		Routes:   []Route{{Prefix: "/echo", Handler: echo}},
+	}
+	addr, _ := startTestServer(t, s)
+	raw := "GET /echo HTTP/1.1\r\nHost: test\r\nX-Test: first\r\n\r\n" +
+		"GET /a.txt HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"
+	res := exchangeRaw(t, addr, raw, 2)
+	raw = "GET /echo HTTP/1.1\r\nHost: test\r\n\r\n" +
+		"GET /b.html HTTP/1.1\r\nHost: test\r\n\r\n" +
+		"GET /echo HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"
+	res = append(res, exchangeRaw(t, addr, raw, 3)...)
+	want := []struct {
+		body        string
+		contentType string
+		close       bool
+	}{
+		{"first", "text/plain; charset=utf-8", false},
+		{"a", "text/plain; charset=utf-8", true},
+		{"", "text/plain; charset=utf-8", false},
+		{"<b>", contentTypeHTML, false},
+		{"", "text/plain; charset=utf-8", true},
+	}
+	for i, w := range want {
+		body, _ := io.ReadAll(res[i].BodyReader)
+		if string(body) != w.body || res[i].Header["Content-Type"] != w.contentType || (res[i].Header["Connection"] == "close") != w.close {
+			t.Errorf("response %v: got %q, headers %v, want %q of %v, close %v", i, body, res[i].Header, w.body, w.contentType, w.close)
+		}
+	}
+}
+
+func TestCopyBuffers(t *testing.T) {
+	var tests = []struct {
+		n    int64
+		size int
+	}{
+		{-1, smallCopyBuffer},
+		{0, smallCopyBuffer},
+		{largeCopyBuffer, smallCopyBuffer},
+		{largeCopyBuffer + 1, largeCopyBuffer},
+	}
+	for _, tt := range tests {
+		b := getCopyBuffer(tt.n)
+		if len(*b) != tt.size {
+			t.Errorf("buffer for %v bytes got: %v bytes, want: %v", tt.n, len(*b), tt.size)
+		}
+		putCopyBuffer(b)
+	}
+
+	if raceEnabled {
+		t.Skip("allocations are not counted under the race detector")
+	}
+	name := filepath.Join(t.TempDir(), "large.bin")
+	if err := os.WriteFile(name, make([]byte, 1<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// A plain writer, not reading from the file itself
+	w := struct{ io.Writer }{io.Discard}
+	r := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			res := &Response{StatusCode: 200, Proto: "HTTP/1.1", Header: map[string]string{"Content-Length": strconv.Itoa(1 << 20)}, FilePath: name}
+			if _, err := res.WriteTo(w); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	// The file, the response and its head, but no copy buffer
+	if got := r.AllocedBytesPerOp(); got >= smallCopyBuffer {
+		t.Errorf("got %v bytes allocated per file written, want less than a copy buffer", got)
+	}
+}
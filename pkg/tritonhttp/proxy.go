@@ -0,0 +1,389 @@
+package tritonhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// hopHeaders are the hop-by-hop headers, which concern a single
+// connection and are not forwarded by a proxy.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// forwardedHeaders tell upstream servers about the client of a proxy.
+// Those sent by untrusted clients are dropped.
+var forwardedHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+}
+
+// defaultVia is the pseudonym of proxies in Via headers.
+const defaultVia = "TritonHTTP"
+
+// defaultProxyTransport is the Transport of ReverseProxies without one.
+// Bodies are relayed as the upstream server encoded them.
+var defaultProxyTransport = &Transport{DisableCompression: true}
+
+// ReverseProxy is a Handler forwarding requests to an upstream server
+// and relaying its responses, bodies streamed both ways, so that
+// TritonHTTP can front application servers.
+//
+// The Host header sent upstream is that of the upstream URL, and the
+// hop-by-hop headers are dropped both ways. Upstream responses without
+// a Content-Length are read until the upstream server closes the
+// connection, and relayed with "Connection: close".
+//
+// The client address is appended to the X-Forwarded-For header sent
+// upstream, X-Forwarded-Proto and X-Forwarded-Host tell the scheme and
+// Host it used, and the proxy adds itself to the Via headers both ways.
+//
+// Requests with an Upgrade header, e.g. WebSocket handshakes, are sent
+// on a connection of their own. If the upstream server switches
+// protocols, the client connection is then spliced with it until
+// either side closes.
+type ReverseProxy struct {
+	// Transport sends the requests upstream. If nil, a shared Transport
+	// with DisableCompression set is used; a custom one should set it
+	// too, or the client gets decompressed bodies with the headers of
+	// compressed ones.
+	Transport *Transport
+
+	// StripPrefix is removed from the request path before it is
+	// appended to the upstream path, e.g. the Prefix of the Route.
+	StripPrefix string
+
+	// PreserveHost sends the Host of the client's request upstream
+	// instead of that of the upstream URL.
+	PreserveHost bool
+
+	// TrustedProxies are the networks of the clients that are proxies
+	// themselves: the X-Forwarded-* and Forwarded headers they send are
+	// kept, and extended. Those of other clients are dropped, as they
+	// could be forged.
+	TrustedProxies []netip.Prefix
+
+	// OmitForwarded sends no X-Forwarded-* or Forwarded headers
+	// upstream, e.g. to keep the client addresses from it.
+	OmitForwarded bool
+
+	// Via is the pseudonym of the proxy in Via headers; "" means
+	// "TritonHTTP".
+	Via string
+
+	// Retry tells when to send a request again, to the next backend.
+	// The zero RetryPolicy never does.
+	Retry RetryPolicy
+
+	// ErrorLog receives the failures to reach the upstream server.
+	// If nil, they are logged via the log package.
+	ErrorLog Logger
+
+	backends []*url.URL
+}
+
+// NewReverseProxy returns a ReverseProxy to rawURL, an "http://" or
+// "https://" URL whose path, if any, request paths are appended to.
+// The backups, if any, are URLs of further backends serving the same,
+// that retries go to in turn, as Retry allows.
+func NewReverseProxy(rawURL string, backups ...string) (*ReverseProxy, error) {
+	p := &ReverseProxy{}
+	for _, raw := range append([]string{rawURL}, backups...) {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("unsupported upstream URL scheme %q", u.Scheme)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("upstream URL %q has no host", raw)
+		}
+		p.backends = append(p.backends, u)
+	}
+	return p, nil
+}
+
+// ServeRequest forwards req upstream and returns the upstream response,
+// or a 502 Bad Gateway if there is none, a 504 Gateway Timeout if it
+// came too late. Requests Retry allows to are sent again, to the next
+// backend, after a connection error or a response with one of
+// Retry.Statuses. A ReverseProxy not made by NewReverseProxy has no
+// backends, so answers every request with a 502.
+func (p *ReverseProxy) ServeRequest(req *Request) *Response {
+	if len(p.backends) == 0 {
+		p.errorLog().Warnf("Proxying %v %v: no backends", req.Method, req.URL)
+		res := &Response{}
+		res.HandleBadGateway(req)
+		return res
+	}
+	if req.Header["Upgrade"] != "" {
+		return p.upgrade(req)
+	}
+	attempts := 1
+	if p.Retry.Attempts > 1 && retryable(req) {
+		attempts = p.Retry.Attempts
+	}
+	for try := 0; ; try++ {
+		out := p.outgoing(req, p.backends[try%len(p.backends)])
+		up, err := p.roundTrip(out)
+		if try+1 == attempts || (err == nil && !p.Retry.retries(up.StatusCode)) {
+			return relayed(req, out, up, err, p.via(), p.errorLog())
+		}
+		if err == nil {
+			_ = up.Close()
+			err = fmt.Errorf("status %v", up.StatusCode)
+		}
+		p.errorLog().Infof("Proxying %v %v to %v, try %v of %v: %v", req.Method, req.URL, out.Host, try+1, attempts, err)
+		time.Sleep(p.Retry.backoff(try))
+	}
+}
+
+// roundTrip sends out upstream, giving up if Retry.TryTimeout passes
+// without the response headers.
+func (p *ReverseProxy) roundTrip(out *Request) (*Response, error) {
+	if p.Retry.TryTimeout <= 0 {
+		return p.transport().RoundTripContext(context.Background(), out)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var expired atomic.Bool
+	timer := time.AfterFunc(p.Retry.TryTimeout, func() {
+		expired.Store(true)
+		cancel()
+	})
+	up, err := p.transport().RoundTripContext(ctx, out)
+	timer.Stop()
+	if err != nil {
+		cancel()
+		if expired.Load() {
+			err = &TimeoutError{Phase: "response header", Err: context.DeadlineExceeded}
+		}
+		return nil, err
+	}
+	if up.BodyReader == nil {
+		cancel()
+		return up, nil
+	}
+	up.BodyReader = &cancelBody{Reader: up.BodyReader, cancel: cancel}
+	return up, nil
+}
+
+// cancelBody is a response body whose context is canceled once it is
+// closed.
+type cancelBody struct {
+	io.Reader
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	if c, ok := b.Reader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// upgrade forwards the Upgrade request req upstream, and returns the
+// 101 Switching Protocols response to relay, with the upstream
+// connection to splice with the client's, or the upstream response
+// refusing to switch.
+func (p *ReverseProxy) upgrade(req *Request) *Response {
+	out := p.outgoing(req, p.backends[0])
+	out.Header["Upgrade"] = req.Header["Upgrade"]
+	out.Header["Connection"] = "Upgrade"
+	up, conn, err := p.transport().Upgrade(context.Background(), out)
+	if err != nil || conn == nil {
+		return relayed(req, out, up, err, p.via(), p.errorLog())
+	}
+	res := relayed(req, out, up, nil, p.via(), p.errorLog())
+	res.Header["Upgrade"] = up.Header["Upgrade"]
+	res.Header["Connection"] = "Upgrade"
+	res.upgraded = conn
+	return res
+}
+
+// relay sends out, the request to forward for req, with t, and returns
+// the response to req relaying the answer, or a 502 Bad Gateway if
+// there is none, a 504 Gateway Timeout if it came too late. The answer
+// gets via appended to its Via header, unless via is "". Failures are
+// logged to log.
+func relay(t *Transport, req, out *Request, via string, log Logger) *Response {
+	up, err := t.RoundTripContext(context.Background(), out)
+	return relayed(req, out, up, err, via, log)
+}
+
+// relayed returns the response to req relaying up, the response to out,
+// or the error err sending out, as relay does.
+func relayed(req, out *Request, up *Response, err error, via string, log Logger) *Response {
+	if err != nil {
+		log.Warnf("Proxying %v %v to %v: %v", req.Method, req.URL, out.Host, err)
+		res := &Response{}
+		var te *TimeoutError
+		if errors.As(err, &te) {
+			res.HandleGatewayTimeout(req)
+		} else {
+			res.HandleBadGateway(req)
+		}
+		return res
+	}
+
+	res := &Response{
+		StatusCode: up.StatusCode,
+		Proto:      "HTTP/1.1",
+		Reason:     up.Reason,
+		Header:     withoutHopHeaders(up.Header),
+		Request:    req,
+		BodyReader: up.BodyReader,
+	}
+	if via != "" {
+		res.Header["Via"] = appendVia(res.Header["Via"], up.Proto, via)
+	}
+	_, framed := res.Header["Content-Length"]
+	bodiless := up.StatusCode/100 == 1 || up.StatusCode == 204 || up.StatusCode == 304
+	if req.Close || (!framed && !bodiless) {
+		res.Header["Connection"] = "close"
+	}
+	return res
+}
+
+// outgoing returns the request to send to the backend at upstream
+// for req.
+func (p *ReverseProxy) outgoing(req *Request, upstream *url.URL) *Request {
+	reqPath, query, hasQuery := strings.Cut(req.URL, "?")
+	reqPath = strings.TrimPrefix(reqPath, p.StripPrefix)
+	if !strings.HasPrefix(reqPath, "/") {
+		reqPath = "/" + reqPath
+	}
+	target := strings.TrimSuffix(upstream.EscapedPath(), "/") + reqPath
+	switch {
+	case hasQuery && upstream.RawQuery != "":
+		target += "?" + upstream.RawQuery + "&" + query
+	case hasQuery:
+		target += "?" + query
+	case upstream.RawQuery != "":
+		target += "?" + upstream.RawQuery
+	}
+
+	host := upstream.Host
+	if p.PreserveHost {
+		host = req.Host
+	}
+	header := withoutHopHeaders(req.Header)
+	p.setForwarded(req, header)
+	return &Request{
+		Method: req.Method,
+		URL:    target,
+		Proto:  "HTTP/1.1",
+		Header: header,
+		Host:   host,
+		Scheme: upstream.Scheme,
+		Body:   req.Body,
+	}
+}
+
+// setForwarded sets the X-Forwarded-* and Via headers in header, that
+// of the request forwarded for req.
+func (p *ReverseProxy) setForwarded(req *Request, header map[string]string) {
+	if p.OmitForwarded || !trusted(req.RemoteAddr, p.TrustedProxies) {
+		for _, k := range forwardedHeaders {
+			delete(header, k)
+		}
+	}
+	if !p.OmitForwarded {
+		client := splitHost(req.RemoteAddr)
+		if prior := header["X-Forwarded-For"]; prior != "" {
+			client = prior + ", " + client
+		}
+		header["X-Forwarded-For"] = client
+		if header["X-Forwarded-Proto"] == "" {
+			header["X-Forwarded-Proto"] = "http"
+			if req.Scheme == "https" {
+				header["X-Forwarded-Proto"] = "https"
+			}
+		}
+		if header["X-Forwarded-Host"] == "" {
+			header["X-Forwarded-Host"] = req.Host
+		}
+	}
+	header["Via"] = appendVia(header["Via"], req.Proto, p.via())
+}
+
+// trusted reports whether the client at remoteAddr is in one of
+// proxies.
+func trusted(remoteAddr string, proxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(splitHost(remoteAddr))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ReverseProxy) via() string {
+	if p.Via != "" {
+		return p.Via
+	}
+	return defaultVia
+}
+
+// appendVia appends the proxy pseudonym, having received a message over
+// proto, to the Via header prior, per RFC 7230 section 5.7.1.
+func appendVia(prior, proto, pseudonym string) string {
+	v := strings.TrimPrefix(proto, "HTTP/") + " " + pseudonym
+	if prior != "" {
+		return prior + ", " + v
+	}
+	return v
+}
+
+// withoutHopHeaders returns a copy of header without the hop-by-hop
+// headers, including those its Connection header lists.
+func withoutHopHeaders(header map[string]string) map[string]string {
+	h := make(map[string]string, len(header))
+	for k, v := range header {
+		h[k] = v
+	}
+	for _, k := range strings.Split(header["Connection"], ",") {
+		delete(h, CanonicalHeaderKey(strings.TrimSpace(k)))
+	}
+	for _, k := range hopHeaders {
+		delete(h, k)
+	}
+	return h
+}
+
+func (p *ReverseProxy) transport() *Transport {
+	if p.Transport != nil {
+		return p.Transport
+	}
+	return defaultProxyTransport
+}
+
+func (p *ReverseProxy) errorLog() Logger {
+	if p.ErrorLog != nil {
+		return p.ErrorLog
+	}
+	return defaultLogger
+}
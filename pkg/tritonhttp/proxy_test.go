@@ -0,0 +1,501 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startProxy serves a doc root holding index.html, with the requests
+// under /api proxied to upstream's /app, and returns its address.
+func startProxy(t *testing.T, upstream string) string {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "index.html"), []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewReverseProxy(upstream + "/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.StripPrefix = "/api"
+	addr, _ := startTestServer(t, &Server{DocRoot: root, Routes: []Route{{Prefix: "/api", Handler: p}}})
+	return addr
+}
+
+// echoUpstream answers with what it received.
+func echoUpstream(t *testing.T) *httptest.Server {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Upstream", "yes")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		fmt.Fprintf(w, "%v %v host=%v test=%v proxy-auth=%v body=%s",
+			r.Method, r.RequestURI, r.Host, r.Header.Get("X-Test"), r.Header.Get("Proxy-Authorization"), body)
+	}))
+	t.Cleanup(up.Close)
+	return up
+}
+
+// exchangeRaw writes raw to addr, then reads n responses.
+func exchangeRaw(t *testing.T, addr, raw string, n int) []*Response {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	var responses []*Response
+	for i := 0; i < n; i++ {
+		res, err := ReadResponse(br, &Request{Method: "GET"})
+		if err != nil {
+			t.Fatalf("response %v: %v", i, err)
+		}
+		body, err := io.ReadAll(res.BodyReader)
+		if err != nil {
+			t.Fatalf("response %v: %v", i, err)
+		}
+		res.BodyReader = strings.NewReader(string(body))
+		responses = append(responses, res)
+	}
+	return responses
+}
+
+func TestReverseProxy(t *testing.T) {
+	up := echoUpstream(t)
+	addr := startProxy(t, up.URL)
+	upHost := strings.TrimPrefix(up.URL, "http://")
+
+	var tests = []struct {
+		name       string
+		url        string
+		statusWant int
+		bodyWant   string
+	}{
+		{"Proxied", "/api/users?x=1", 200, "GET /app/users?x=1 host=" + upHost + " test=t proxy-auth= body="},
+		{"PrefixOnly", "/api", 200, "GET /app/ host=" + upHost + " test=t proxy-auth= body="},
+		{"Local", "/index.html", 200, "local"},
+		{"NotUnderPrefix", "/apix", 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "GET " + tt.url + " HTTP/1.1\r\nHost: front\r\nX-Test: t\r\nProxy-Authorization: secret\r\nConnection: close\r\n\r\n"
+			res := exchangeRaw(t, addr, raw, 1)[0]
+			body, _ := io.ReadAll(res.BodyReader)
+			if res.StatusCode != tt.statusWant || string(body) != tt.bodyWant {
+				t.Fatalf("got: %v %q, want: %v %q", res.StatusCode, body, tt.statusWant, tt.bodyWant)
+			}
+			if tt.statusWant == 200 && strings.HasPrefix(tt.url, "/api") {
+				if res.Header["X-Upstream"] != "yes" {
+					t.Fatalf("missing upstream header in %v", res.Header)
+				}
+				if _, ok := res.Header["Keep-Alive"]; ok {
+					t.Fatalf("hop-by-hop Keep-Alive relayed")
+				}
+			}
+		})
+	}
+}
+
+func TestReverseProxyRequestBody(t *testing.T) {
+	up := echoUpstream(t)
+	addr := startProxy(t, up.URL)
+
+	// The body is forwarded, and the request after it still read
+	raw := "GET /api/echo HTTP/1.1\r\nHost: front\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /index.html HTTP/1.1\r\nHost: front\r\nContent-Length: 3\r\n\r\nabc" +
+		"GET /index.html HTTP/1.1\r\nHost: front\r\nConnection: close\r\n\r\n"
+	responses := exchangeRaw(t, addr, raw, 3)
+	body, _ := io.ReadAll(responses[0].BodyReader)
+	if !strings.HasSuffix(string(body), "body=hello") {
+		t.Fatalf("got body %q, want the request body echoed", body)
+	}
+	for i, res := range responses[1:] {
+		if body, _ := io.ReadAll(res.BodyReader); res.StatusCode != 200 || string(body) != "local" {
+			t.Fatalf("response %v: got %v %q, want 200 %q", i+1, res.StatusCode, body, "local")
+		}
+	}
+}
+
+func TestReverseProxyStreaming(t *testing.T) {
+	big := strings.Repeat("0123456789", 100000)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// No Content-Length: net/http chunks the body
+		for i := 0; i < len(big); i += 10000 {
+			io.WriteString(w, big[i:i+10000])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(up.Close)
+	addr := startProxy(t, up.URL)
+
+	res := exchangeRaw(t, addr, "GET /api/big HTTP/1.1\r\nHost: front\r\n\r\n", 1)[0]
+	body, _ := io.ReadAll(res.BodyReader)
+	if res.StatusCode != 200 || string(body) != big {
+		t.Fatalf("got %v with %v bytes, want 200 with %v", res.StatusCode, len(body), len(big))
+	}
+	if res.Header["Connection"] != "close" {
+		t.Fatalf("got Connection %q, want close for a body framed by the end of the connection", res.Header["Connection"])
+	}
+	if _, ok := res.Header["Transfer-Encoding"]; ok {
+		t.Fatalf("hop-by-hop Transfer-Encoding relayed")
+	}
+}
+
+func TestReverseProxyBadGateway(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := "http://" + ln.Addr().String()
+	ln.Close()
+	p, err := NewReverseProxy(upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ErrorLog = NewLogger(nil, LevelError)
+	res := p.ServeRequest(&Request{Method: "GET", URL: "/x", Proto: "HTTP/1.1", Header: map[string]string{}, Host: "front"})
+	if res.StatusCode != 502 {
+		t.Fatalf("got status %v, want 502", res.StatusCode)
+	}
+}
+
+func TestReverseProxyNoBackends(t *testing.T) {
+	p := &ReverseProxy{ErrorLog: NewLogger(nil, LevelError)}
+	for _, upgrade := range []string{"", "websocket"} {
+		req := &Request{Method: "GET", URL: "/x", Proto: "HTTP/1.1", Header: map[string]string{}, Host: "front"}
+		if upgrade != "" {
+			req.Header["Upgrade"] = upgrade
+		}
+		if res := p.ServeRequest(req); res.StatusCode != 502 {
+			t.Errorf("Upgrade %q got status %v, want 502", upgrade, res.StatusCode)
+		}
+	}
+}
+
+func TestReverseProxyRetry(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := "http://" + ln.Addr().String()
+	ln.Close()
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	t.Cleanup(busy.Close)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	live := echoUpstream(t).URL
+
+	var tests = []struct {
+		name       string
+		backends   []string
+		retry      RetryPolicy
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{"failover", []string{dead, live}, RetryPolicy{Attempts: 2}, "GET", "", 200},
+		{"retried status", []string{busy.URL, live}, RetryPolicy{Attempts: 2, Statuses: []int{503}}, "GET", "", 200},
+		{"other status", []string{busy.URL, live}, RetryPolicy{Attempts: 2}, "GET", "", 503},
+		{"attempts exhausted", []string{dead, dead, live}, RetryPolicy{Attempts: 2, Backoff: time.Millisecond}, "GET", "", 502},
+		{"wraps around", []string{dead, live}, RetryPolicy{Attempts: 4, Statuses: []int{200}}, "GET", "", 200},
+		{"no retries", []string{dead, live}, RetryPolicy{}, "GET", "", 502},
+		{"not idempotent", []string{dead, live}, RetryPolicy{Attempts: 2}, "POST", "", 502},
+		{"request body", []string{dead, live}, RetryPolicy{Attempts: 2}, "PUT", "hello", 502},
+		{"try timeout", []string{slow.URL, live}, RetryPolicy{Attempts: 2, TryTimeout: 100 * time.Millisecond}, "GET", "", 200},
+		{"last try timeout", []string{slow.URL}, RetryPolicy{TryTimeout: 100 * time.Millisecond}, "GET", "", 504},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewReverseProxy(tt.backends[0], tt.backends[1:]...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Retry = tt.retry
+			p.ErrorLog = NewLogger(nil, LevelError)
+			req := &Request{Method: tt.method, URL: "/x", Proto: "HTTP/1.1", Header: map[string]string{}, Host: "front"}
+			if tt.body != "" {
+				req.Header["Content-Length"] = fmt.Sprint(len(tt.body))
+				req.Body = strings.NewReader(tt.body)
+			}
+			res := p.ServeRequest(req)
+			defer res.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReverseProxyPoolsConns(t *testing.T) {
+	tr := &Transport{DisableCompression: true, MaxIdleConnsPerHost: 1}
+	defer tr.CloseIdleConnections()
+	for i := 0; i < 2; i++ {
+		var conns atomic.Int32
+		up := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "ok")
+		}))
+		up.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				conns.Add(1)
+			}
+		}
+		up.Start()
+		t.Cleanup(up.Close)
+		p, err := NewReverseProxy(up.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Transport = tr
+		for j := 0; j < 3; j++ {
+			res := p.ServeRequest(&Request{Method: "GET", URL: "/x", Proto: "HTTP/1.1", Header: map[string]string{}, Host: "front"})
+			body, _ := io.ReadAll(res.BodyReader)
+			res.Close()
+			if res.StatusCode != 200 || string(body) != "ok" {
+				t.Fatalf("got %v %q", res.StatusCode, body)
+			}
+		}
+		if n := conns.Load(); n != 1 {
+			t.Errorf("backend %v: got %v connections for 3 requests, want 1", i, n)
+		}
+	}
+	// Each backend keeps its idle connection
+	if n := tr.idleCount(); n != 2 {
+		t.Errorf("got %v idle connections, want 2", n)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	rp := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 30 * time.Millisecond}
+	var tests = []struct {
+		try      int
+		min, max time.Duration
+	}{
+		{0, 10 * time.Millisecond, 20 * time.Millisecond},
+		{1, 20 * time.Millisecond, 40 * time.Millisecond},
+		{2, 30 * time.Millisecond, 60 * time.Millisecond},
+		{10, 30 * time.Millisecond, 60 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if d := rp.backoff(tt.try); d < tt.min || d > tt.max {
+			t.Errorf("try %v: got backoff %v, want in [%v, %v]", tt.try, d, tt.min, tt.max)
+		}
+	}
+	if d := (RetryPolicy{}).backoff(3); d != 0 {
+		t.Errorf("got backoff %v without Backoff, want 0", d)
+	}
+}
+
+// upgradeUpstream switches the requests for /app/ws with an Upgrade
+// header to an echo protocol, and refuses the others with a 400.
+func upgradeUpstream(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				r, err := http.ReadRequest(br)
+				if err != nil {
+					return
+				}
+				if r.URL.Path != "/app/ws" || r.Header.Get("Upgrade") != "echo" || r.Header.Get("Connection") != "Upgrade" {
+					io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\nContent-Length: 2\r\n\r\nno")
+					return
+				}
+				io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+				io.Copy(conn, br)
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String()
+}
+
+func TestReverseProxyUpgrade(t *testing.T) {
+	addr := startProxy(t, upgradeUpstream(t))
+
+	var tests = []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"switched", "/api/ws", 101},
+		{"refused", "/api/other", 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			// Bytes sent right after the handshake are relayed too
+			raw := "GET " + tt.path + " HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nping"
+			if _, err := io.WriteString(conn, raw); err != nil {
+				t.Fatal(err)
+			}
+			br := bufio.NewReader(conn)
+			res, err := ReadResponse(br, &Request{Method: "GET"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != 101 {
+				return
+			}
+			if res.Header["Upgrade"] != "echo" || res.Header["Connection"] != "Upgrade" || res.Header["Via"] != "1.1 TritonHTTP" {
+				t.Errorf("got headers %v", res.Header)
+			}
+			if _, err := io.WriteString(conn, "-pong"); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, len("ping-pong"))
+			if _, err := io.ReadFull(br, got); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "ping-pong" {
+				t.Errorf("got %q through the upgraded connection, want %q", got, "ping-pong")
+			}
+		})
+	}
+}
+
+func TestReverseProxyForwardedHeaders(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Via", "1.1 app")
+		fmt.Fprintf(w, "for=%v proto=%v host=%v forwarded=%v via=%v",
+			r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Forwarded-Proto"), r.Header.Get("X-Forwarded-Host"),
+			r.Header.Get("Forwarded"), r.Header.Get("Via"))
+	}))
+	defer up.Close()
+	spoofed := map[string]string{
+		"X-Forwarded-For":   "10.1.1.1",
+		"X-Forwarded-Proto": "https",
+		"Forwarded":         "for=10.1.1.1",
+		"Via":               "1.0 edge",
+	}
+
+	var tests = []struct {
+		name    string
+		trusted []netip.Prefix
+		omit    bool
+		want    string
+	}{
+		{
+			"untrusted",
+			nil,
+			false,
+			"for=127.0.0.1 proto=http host=front forwarded= via=1.0 edge, 1.1 TritonHTTP",
+		},
+		{
+			"trusted",
+			[]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+			false,
+			"for=10.1.1.1, 127.0.0.1 proto=https host=front forwarded=for=10.1.1.1 via=1.0 edge, 1.1 TritonHTTP",
+		},
+		{
+			"omitted",
+			[]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+			true,
+			"for= proto= host= forwarded= via=1.0 edge, 1.1 TritonHTTP",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewReverseProxy(up.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.TrustedProxies, p.OmitForwarded = tt.trusted, tt.omit
+			header := map[string]string{}
+			for k, v := range spoofed {
+				header[k] = v
+			}
+			res := p.ServeRequest(&Request{Method: "GET", URL: "/", Proto: "HTTP/1.1", Header: header, Host: "front", RemoteAddr: "127.0.0.1:5000"})
+			defer res.Close()
+			body, _ := io.ReadAll(res.BodyReader)
+			if string(body) != tt.want {
+				t.Errorf("upstream got %q, want %q", body, tt.want)
+			}
+			if res.Header["Via"] != "1.1 app, 1.1 TritonHTTP" {
+				t.Errorf("got response Via %q", res.Header["Via"])
+			}
+		})
+	}
+}
+
+func TestNewReverseProxy(t *testing.T) {
+	var tests = []struct {
+		url     string
+		wantErr bool
+	}{
+		{"http://127.0.0.1:9000", false},
+		{"https://app.example/base", false},
+		{"ftp://app.example", true},
+		{"/relative", true},
+	}
+	for _, tt := range tests {
+		if _, err := NewReverseProxy(tt.url); (err != nil) != tt.wantErr {
+			t.Errorf("%q: got error: %v, want error: %v", tt.url, err, tt.wantErr)
+		}
+		// Backups are checked alike
+		if _, err := NewReverseProxy("http://127.0.0.1:9000", tt.url); (err != nil) != tt.wantErr {
+			t.Errorf("%q: got error: %v, want error: %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRouteMatches(t *testing.T) {
+	var tests = []struct {
+		prefix, path string
+		exts         []string
+		want         bool
+	}{
+		{"/api", "/api", nil, true},
+		{"/api", "/api/users", nil, true},
+		{"/api", "/apix", nil, false},
+		{"/api/", "/api", nil, false},
+		{"/api/", "/api/users", nil, true},
+		{"/", "/anything", nil, true},
+		{"/", "/index.php", []string{".php"}, true},
+		{"/", "/app/INDEX.PHP/users/1", []string{".php"}, true},
+		{"/", "/index.html", []string{".php"}, false},
+		{"/", "/index.phpx", []string{".php"}, false},
+		{"/app", "/index.php", []string{".php"}, false},
+	}
+	for _, tt := range tests {
+		if got := (Route{Prefix: tt.prefix, Extensions: tt.exts}).matches(tt.path); got != tt.want {
+			t.Errorf("Route %q %v matching %q: got %v, want %v", tt.prefix, tt.exts, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package tritonhttp
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// quotaBuckets is the number of buckets a quota window is split into.
+// Usage ages out of the rolling window one bucket at a time.
+const quotaBuckets = 10
+
+// BandwidthQuota limits how many response bytes a single client IP
+// may receive over a rolling Window. Requests from a client over its
+// quota are answered with 429 Too Many Requests until enough usage
+// ages out of the window. A zero Bytes disables the quota.
+type BandwidthQuota struct {
+	Bytes  int64
+	Window time.Duration
+}
+
+type usageBucket struct {
+	start time.Time
+	bytes int64
+}
+
+// bandwidthMeter tracks bytes served per client IP in time buckets.
+type bandwidthMeter struct {
+	mu    sync.Mutex
+	usage map[string][]usageBucket
+}
+
+// add records n bytes served to ip at now.
+func (m *bandwidthMeter) add(ip string, n int64, q BandwidthQuota, now time.Time) {
+	if q.Bytes <= 0 || n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.usage == nil {
+		m.usage = make(map[string][]usageBucket)
+	}
+	start := now.Truncate(q.Window / quotaBuckets)
+	buckets := m.prune(ip, q, now)
+	if len(buckets) > 0 && buckets[len(buckets)-1].start.Equal(start) {
+		buckets[len(buckets)-1].bytes += n
+	} else {
+		buckets = append(buckets, usageBucket{start, n})
+	}
+	m.usage[ip] = buckets
+}
+
+// exceeded reports whether ip is over quota at now, and if so,
+// how long until its oldest usage leaves the window.
+func (m *bandwidthMeter) exceeded(ip string, q BandwidthQuota, now time.Time) (time.Duration, bool) {
+	if q.Bytes <= 0 {
+		return 0, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	buckets := m.prune(ip, q, now)
+	var total int64
+	for _, b := range buckets {
+		total += b.bytes
+	}
+	if total < q.Bytes {
+		return 0, false
+	}
+	return buckets[0].start.Add(q.Window).Sub(now), true
+}
+
+// prune drops the buckets of ip that are older than the window.
+// The caller must hold m.mu.
+func (m *bandwidthMeter) prune(ip string, q BandwidthQuota, now time.Time) []usageBucket {
+	buckets := m.usage[ip]
+	i := 0
+	for i < len(buckets) && now.Sub(buckets[i].start) >= q.Window {
+		i++
+	}
+	buckets = buckets[i:]
+	if len(buckets) == 0 {
+		delete(m.usage, ip)
+		return nil
+	}
+	m.usage[ip] = buckets
+	return buckets
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,33 @@
+package tritonhttp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBandwidthMeter(t *testing.T) {
+	q := BandwidthQuota{Bytes: 100, Window: 10 * time.Second}
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	var m bandwidthMeter
+
+	m.add("1.2.3.4", 60, q, now)
+	if _, over := m.exceeded("1.2.3.4", q, now); over {
+		t.Fatal("over quota after 60 of 100 bytes")
+	}
+	m.add("1.2.3.4", 40, q, now.Add(5*time.Second))
+	retryAfter, over := m.exceeded("1.2.3.4", q, now.Add(5*time.Second))
+	if !over {
+		t.Fatal("not over quota after 100 of 100 bytes")
+	}
+	if retryAfter != 5*time.Second {
+		t.Fatalf("retry after got: %v, want: %v", retryAfter, 5*time.Second)
+	}
+	if _, over := m.exceeded("5.6.7.8", q, now); over {
+		t.Fatal("unrelated client over quota")
+	}
+
+	// The first 60 bytes leave the window
+	if _, over := m.exceeded("1.2.3.4", q, now.Add(10*time.Second)); over {
+		t.Fatal("still over quota after usage aged out")
+	}
+}
@@ -0,0 +1,254 @@
+package tritonhttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// maxRanges is the most ranges a Range header may ask for; one asking
+// for more is ignored, as those of clients probing the server.
+const maxRanges = 16
+
+// ErrUnsatisfiableRange is that of a Range header none of whose ranges
+// overlap the representation asked for, answered with 416 Range Not
+// Satisfiable.
+var ErrUnsatisfiableRange = errors.New("unsatisfiable range")
+
+// ByteRange is a range of the bytes of a representation, from Start to
+// End inclusive, as in a Content-Range header.
+type ByteRange struct {
+	Start, End int64
+}
+
+// Len returns the number of bytes in r.
+func (r ByteRange) Len() int64 { return r.End - r.Start + 1 }
+
+// contentRange returns the Content-Range header of r in a
+// representation of size bytes, e.g. "bytes 0-99/1000".
+func (r ByteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End, size)
+}
+
+// Ranges parses the Range header of req, e.g. "bytes=0-99,-100", into
+// the ranges it asks for of a representation of size bytes, clipped to
+// it, in the order asked for. It returns none if there is no Range
+// header, or one that is not of bytes or is malformed, which is to be
+// ignored, and ErrUnsatisfiableRange if none of its ranges overlap the
+// representation.
+func (req *Request) Ranges(size int64) ([]ByteRange, error) {
+	h := req.Header["Range"]
+	unit, set, ok := strings.Cut(h, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, nil
+	}
+	var ranges []ByteRange
+	specs := 0
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if specs++; specs > maxRanges {
+			return nil, nil
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+		var r ByteRange
+		if first == "" {
+			// The last bytes
+			n, ok := parseDigits(last)
+			if !ok {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			r = ByteRange{Start: max(size-n, 0), End: size - 1}
+		} else {
+			start, ok := parseDigits(first)
+			if !ok {
+				return nil, nil
+			}
+			end := size - 1
+			if last != "" {
+				if end, ok = parseDigits(last); !ok || end < start {
+					return nil, nil
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = ByteRange{Start: start, End: min(end, size-1)}
+		}
+		ranges = append(ranges, r)
+	}
+	if specs == 0 {
+		return nil, nil
+	}
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// parseDigits parses s, a non-empty run of decimal digits, as a
+// non-negative number, or reports false; unlike strconv.ParseInt, it
+// takes no sign.
+func parseDigits(s string) (int64, bool) {
+	if s == "" || len(s) > 18 {
+		return 0, false
+	}
+	var n int64
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(s[i]-'0')
+	}
+	return n, true
+}
+
+// serveRange makes res, a 200 OK serving the file of FilePath, of size
+// bytes, to req, serve the ranges of it req asks for, if any: a 206
+// Partial Content with the Content-Range of a single range, or whose
+// multipart/byteranges body holds several, or a 416 Range Not
+// Satisfiable if they lie beyond the file. Only GET requests have
+// ranges, and only if their If-Range, if any, matches the file.
+func (res *Response) serveRange(req *Request, size int64) {
+	if res.StatusCode != statusOK || res.FilePath == "" {
+		return
+	}
+	if res.file != nil {
+		if _, ok := res.file.(io.Seeker); !ok {
+			return
+		}
+	}
+	res.Header["Accept-Ranges"] = "bytes"
+	if req.Method != "GET" || !ifRangeMatches(req, res.Header) {
+		// A Range whose If-Range no longer matches gets it all
+		return
+	}
+	ranges, err := req.Ranges(size)
+	if err != nil {
+		res.Text(statusRangeNotSatisfiable, StatusText(statusRangeNotSatisfiable)+"\n")
+		res.Header["Content-Range"] = fmt.Sprintf("bytes */%d", size)
+		return
+	}
+	if len(ranges) == 0 {
+		return
+	}
+	res.StatusCode = statusPartialContent
+	if ranges = coalesce(ranges); len(ranges) == 1 {
+		r := ranges[0]
+		res.Header["Content-Range"] = r.contentRange(size)
+		res.Header["Content-Length"] = fmt.Sprint(r.Len())
+		res.offset = r.Start
+		return
+	}
+	boundary := newBoundary()
+	res.parts = newByteRanges(ranges, size, res.Header["Content-Type"], boundary)
+	res.Header["Content-Type"] = "multipart/byteranges; boundary=" + boundary
+	res.Header["Content-Length"] = fmt.Sprint(res.parts.len())
+}
+
+// coalesce returns ranges merged into as few as cover the same bytes,
+// sorted, if any of them overlap or are adjacent, as RFC 9110 advises
+// against sending those as separate parts, or else as they are, in
+// the order asked for.
+func coalesce(ranges []ByteRange) []ByteRange {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b ByteRange) int {
+		switch {
+		case a.Start < b.Start:
+			return -1
+		case a.Start > b.Start:
+			return 1
+		}
+		return 0
+	})
+	merged := sorted[:1]
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.End+1 {
+			last.End = max(last.End, r.End)
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	if len(merged) == len(ranges) {
+		return ranges
+	}
+	return merged
+}
+
+// newBoundary returns a random boundary of multipart parts.
+func newBoundary() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// byteRanges is the multipart/byteranges body of a response serving
+// several ranges of a file.
+type byteRanges struct {
+	ranges  []ByteRange
+	headers []string // of the part of each range, from its boundary
+	closing string   // the closing boundary
+}
+
+// newByteRanges returns the body of ranges of a file of size bytes and
+// of contentType, whose parts are separated by boundary.
+func newByteRanges(ranges []ByteRange, size int64, contentType, boundary string) *byteRanges {
+	b := &byteRanges{ranges: ranges, closing: "\r\n--" + boundary + "--\r\n"}
+	for i, r := range ranges {
+		var h strings.Builder
+		if i > 0 {
+			h.WriteString("\r\n")
+		}
+		h.WriteString("--" + boundary + "\r\n")
+		if contentType != "" {
+			h.WriteString("Content-Type: " + contentType + "\r\n")
+		}
+		h.WriteString("Content-Range: " + r.contentRange(size) + "\r\n\r\n")
+		b.headers = append(b.headers, h.String())
+	}
+	return b
+}
+
+// len returns the length of b in bytes.
+func (b *byteRanges) len() int64 {
+	n := int64(len(b.closing))
+	for i, r := range b.ranges {
+		n += int64(len(b.headers[i])) + r.Len()
+	}
+	return n
+}
+
+// writeTo writes b, reading the ranges from f, to w.
+func (b *byteRanges) writeTo(w io.Writer, f io.ReadSeeker) (int64, error) {
+	var n int64
+	for i, r := range b.ranges {
+		m, err := io.WriteString(w, b.headers[i])
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+		if _, err := f.Seek(r.Start, io.SeekStart); err != nil {
+			return n, err
+		}
+		written, err := copyN(w, f, r.Len())
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+	m, err := io.WriteString(w, b.closing)
+	return n + int64(m), err
+}
@@ -0,0 +1,169 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestRanges(t *testing.T) {
+	var tests = []struct {
+		header  string
+		want    string
+		wantErr error
+	}{
+		{"", "[]", nil},
+		{"bytes=0-99", "[{0 99}]", nil},
+		{"bytes=900-", "[{900 999}]", nil},
+		{"bytes=-100", "[{900 999}]", nil},
+		{"bytes=-2000", "[{0 999}]", nil},
+		{"bytes=990-1500", "[{990 999}]", nil},
+		{"Bytes = 0-0, 10-19 ,", "[{0 0} {10 19}]", nil},
+		{"bytes=1000-, 5-9", "[{5 9}]", nil},
+		{"bytes=1000-", "[]", ErrUnsatisfiableRange},
+		{"bytes=-0", "[]", ErrUnsatisfiableRange},
+		{"bytes=9-5", "[]", nil},
+		{"bytes=+1-5", "[]", nil},
+		{"bytes=a-5", "[]", nil},
+		{"bytes=5", "[]", nil},
+		{"bytes=", "[]", nil},
+		{"items=0-5", "[]", nil},
+		{"bytes=0-0,1-1,2-2,3-3,4-4,5-5,6-6,7-7,8-8,9-9,10-10,11-11,12-12,13-13,14-14,15-15,16-16", "[]", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			req := &Request{Header: map[string]string{"Range": tt.header}}
+			ranges, err := req.Ranges(1000)
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got := fmt.Sprint(ranges); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeRange(t *testing.T) {
+	const data = "0123456789abcdefghij"
+	modTime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := &Server{
+		FS:       MountFS(fstest.MapFS{"data.bin": {Data: []byte(data), ModTime: modTime}}, "/srv"),
+		DocRoot:  "/srv",
+		ErrorLog: NewLogger(nil, LevelError),
+	}
+	addr, _ := startTestServer(t, s)
+	var tests = []struct {
+		name             string
+		rng              string
+		wantStatus       int
+		wantContentRange string
+		wantBody         string
+	}{
+		{"None", "", 200, "", data},
+		{"First", "bytes=0-4", 206, "bytes 0-4/20", "01234"},
+		{"Middle", "bytes=10-12", 206, "bytes 10-12/20", "abc"},
+		{"Open", "bytes=15-", 206, "bytes 15-19/20", "fghij"},
+		{"Suffix", "bytes=-3", 206, "bytes 17-19/20", "hij"},
+		{"Clipped", "bytes=18-100", 206, "bytes 18-19/20", "ij"},
+		{"Coalesced", "bytes=0-4,3-8,9-9", 206, "bytes 0-9/20", "0123456789"},
+		{"Unsatisfiable", "bytes=20-", 416, "bytes */20", "Range Not Satisfiable\n"},
+		{"Malformed", "bytes=4-2", 200, "", data},
+		{"OtherUnit", "lines=1-2", 200, "", data},
+		{"IfRange", "bytes=0-1\r\nIf-Range: Sun, 02 Jan 2022 03:04:05 GMT", 206, "bytes 0-1/20", "01"},
+		{"IfRangeModified", "bytes=0-1\r\nIf-Range: Sat, 01 Jan 2022 00:00:00 GMT", 200, "", data},
+		{"IfRangeETag", "bytes=0-1\r\nIf-Range: \"abc\"", 200, "", data},
+		{"IfRangeUnsatisfiable", "bytes=50-\r\nIf-Range: Sat, 01 Jan 2022 00:00:00 GMT", 200, "", data},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "GET /data.bin HTTP/1.1\r\nHost: test\r\n"
+			if tt.rng != "" {
+				raw += "Range: " + tt.rng + "\r\n"
+			}
+			// The connection stays open for the next request
+			res := exchangeRaw(t, addr, raw+"\r\nGET /data.bin HTTP/1.1\r\nHost: test\r\n\r\n", 2)
+			body, _ := io.ReadAll(res[0].BodyReader)
+			if res[0].StatusCode != tt.wantStatus || string(body) != tt.wantBody {
+				t.Fatalf("got %v %q, want %v %q", res[0].StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+			if got := res[0].Header["Content-Range"]; got != tt.wantContentRange {
+				t.Errorf("got Content-Range %q, want %q", got, tt.wantContentRange)
+			}
+			if res[0].Header["Accept-Ranges"] != "bytes" {
+				t.Errorf("got Accept-Ranges %q, want bytes", res[0].Header["Accept-Ranges"])
+			}
+			if next, _ := io.ReadAll(res[1].BodyReader); res[1].StatusCode != 200 || string(next) != data {
+				t.Errorf("got next %v %q, want 200 %q", res[1].StatusCode, next, data)
+			}
+		})
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	var tests = []struct {
+		ranges []ByteRange
+		want   string
+	}{
+		{[]ByteRange{{10, 19}, {0, 4}}, "[{10 19} {0 4}]"},
+		{[]ByteRange{{10, 19}, {0, 4}, {5, 6}}, "[{0 6} {10 19}]"},
+		{[]ByteRange{{0, 9}, {2, 3}, {20, 29}}, "[{0 9} {20 29}]"},
+		{[]ByteRange{{5, 5}, {5, 5}}, "[{5 5}]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(coalesce(tt.ranges)); got != tt.want {
+			t.Errorf("coalesce(%v) = %v, want %v", tt.ranges, got, tt.want)
+		}
+	}
+}
+
+func TestServeMultipleRanges(t *testing.T) {
+	const data = "0123456789abcdefghij"
+	s := &Server{
+		FS:       MountFS(fstest.MapFS{"data.txt": {Data: []byte(data)}}, "/srv"),
+		DocRoot:  "/srv",
+		ErrorLog: NewLogger(nil, LevelError),
+	}
+	addr, _ := startTestServer(t, s)
+	raw := "GET /data.txt HTTP/1.1\r\nHost: test\r\nRange: bytes=15-16, 0-1, -2, 30-40\r\n\r\n"
+	res := exchangeRaw(t, addr, raw+"GET /data.txt HTTP/1.1\r\nHost: test\r\n\r\n", 2)
+	if res[0].StatusCode != 206 {
+		t.Fatalf("got status %v, want 206", res[0].StatusCode)
+	}
+	mt, params, err := mime.ParseMediaType(res[0].Header["Content-Type"])
+	if err != nil || mt != "multipart/byteranges" {
+		t.Fatalf("got Content-Type %q", res[0].Header["Content-Type"])
+	}
+	body, _ := io.ReadAll(res[0].BodyReader)
+	if got := fmt.Sprint(len(body)); got != res[0].Header["Content-Length"] {
+		t.Errorf("got %v bytes, Content-Length %v", got, res[0].Header["Content-Length"])
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	var got []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(p)
+		got = append(got, fmt.Sprintf("%v %v %s", p.Header.Get("Content-Type"), p.Header.Get("Content-Range"), b))
+	}
+	want := []string{
+		"text/plain; charset=utf-8 bytes 15-16/20 fg",
+		"text/plain; charset=utf-8 bytes 0-1/20 01",
+		"text/plain; charset=utf-8 bytes 18-19/20 ij",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got parts %q, want %q", got, want)
+	}
+	if next, _ := io.ReadAll(res[1].BodyReader); res[1].StatusCode != 200 || string(next) != data {
+		t.Errorf("got next %v %q, want 200 %q", res[1].StatusCode, next, data)
+	}
+}
@@ -0,0 +1,70 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Redirect sends the clients asking for some paths elsewhere, with an
+// external redirect: either those asking for Path exactly, or those
+// whose path Pattern matches, "$1" and the like in Location expanded as
+// in regexp.Regexp.Expand. The Redirects of a Server are evaluated in
+// order, before its Rewrites, the first matching one answering.
+//
+// The query of the request is appended to Location unless it has one
+// of its own.
+type Redirect struct {
+	Path     string
+	Pattern  *regexp.Regexp
+	Location string
+
+	// StatusCode is 301 Moved Permanently, 302 Found, 307 Temporary
+	// Redirect or 308 Permanent Redirect; 0 means 301.
+	StatusCode int
+
+	// HTML, if set, gives the response a short HTML body linking to
+	// Location, for clients that do not follow redirects.
+	HTML bool
+}
+
+// location returns where rd sends the request for urlPath, and whether
+// rd matches it.
+func (rd Redirect) location(urlPath string) (string, bool) {
+	if rd.Pattern != nil {
+		m := rd.Pattern.FindStringSubmatchIndex(urlPath)
+		if m == nil {
+			return "", false
+		}
+		return string(rd.Pattern.ExpandString(nil, rd.Location, urlPath, m)), true
+	}
+	return rd.Location, urlPath == rd.Path
+}
+
+// redirect returns the redirect response to req if one of Redirects
+// matches it, or nil.
+func (s *Server) redirect(req *Request) *Response {
+	urlPath, query, hasQuery := strings.Cut(req.URL, "?")
+	for _, rd := range s.Redirects {
+		location, ok := rd.location(urlPath)
+		if !ok {
+			continue
+		}
+		if hasQuery && !strings.Contains(location, "?") {
+			location += "?" + query
+		}
+		code := rd.StatusCode
+		if code == 0 {
+			code = statusMovedPermanently
+		}
+		res := &Response{}
+		res.HandleRedirect(req, code, location)
+		if rd.HTML {
+			body := fmt.Sprintf("<a href=\"%v\">%v</a>.\n", html.EscapeString(location), StatusText(code))
+			res.setBody(code, MIMETypeByExtension(".html"), []byte(body))
+		}
+		return res
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package tritonhttp
+
+import (
+	"io"
+	"regexp"
+	"testing"
+)
+
+func TestServerRedirects(t *testing.T) {
+	addr, _ := startTestServer(t, &Server{
+		DocRoot: t.TempDir(),
+		Redirects: []Redirect{
+			{Path: "/old", Location: "/new"},
+			{Path: "/moved", Location: "/elsewhere?from=moved", StatusCode: 308, HTML: true},
+			{Pattern: regexp.MustCompile(`^/blog/(.*)$`), Location: "https://blog.example.com/$1", StatusCode: 302},
+			{Pattern: regexp.MustCompile(`^/tmp/`), Location: "/maintenance", StatusCode: 307},
+		},
+		Rewrites: []Rewrite{{Prefix: "/new", Replacement: "/old"}},
+	})
+
+	var tests = []struct {
+		name         string
+		url          string
+		wantStatus   int
+		wantLocation string
+		wantBody     string
+	}{
+		{"exact", "/old", 301, "/new", ""},
+		{"exact with query", "/old?a=1", 301, "/new?a=1", ""},
+		{"not a prefix", "/old/x", 404, "", ""},
+		{"own query", "/moved?a=1", 308, "/elsewhere?from=moved", "<a href=\"/elsewhere?from=moved\">Permanent Redirect</a>.\n"},
+		{"pattern", "/blog/2024/post", 302, "https://blog.example.com/2024/post", ""},
+		{"unanchored", "/tmp/a", 307, "/maintenance", ""},
+		{"before rewrites", "/new", 404, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := exchangeRaw(t, addr, "GET "+tt.url+" HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 1)[0]
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+			if got := res.Header["Location"]; got != tt.wantLocation {
+				t.Errorf("got Location %q, want %q", got, tt.wantLocation)
+			}
+			body, _ := io.ReadAll(res.BodyReader)
+			if string(body) != tt.wantBody {
+				t.Errorf("got body %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package tritonhttp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Reload re-reads the configuration through OnReload, if set,
+// re-resolves the document root, so that a DocRoot symlink switched
+// to a new release, or an archive replaced, takes effect, along with
+// those of VirtualHosts, re-reads the TLS certificates and reloads the
+// GeoIP databases. The responses stored by the Caches of Routes are
+// purged, as they may be those of backends since replaced. Open
+// connections are not disturbed. On error the previously resolved
+// document root stays in use.
+func (s *Server) Reload() error {
+	var errs []error
+	if s.OnReload != nil {
+		if err := s.OnReload(); err != nil {
+			errs = append(errs, fmt.Errorf("reload hook: %w", err))
+		}
+	}
+	if err := s.resolveDocRoot(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.resolveVirtualHosts(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.reloadCertificates(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.reloadGeoIP(); err != nil {
+		errs = append(errs, err)
+	}
+	s.purgeCaches()
+	if err := errors.Join(errs...); err != nil {
+		s.errorLog().Errorf("Reload failed: %v", err)
+		return err
+	}
+	s.logger().Infof("Reloaded, serving %v", s.root())
+	return nil
+}
+
+// resolveDocRoot resolves the symlinks in DocRoot and makes the result
+// the directory files are served from, opening it if it is an archive.
+// DocRoot is only cleaned if FS is set, as it need not exist on disk.
+func (s *Server) resolveDocRoot() error {
+	root := filepath.Clean(s.DocRoot)
+	if s.FS == nil {
+		var err error
+		if root, err = filepath.EvalSymlinks(root); err != nil {
+			return err
+		}
+		if root, err = filepath.Abs(root); err != nil {
+			return err
+		}
+		fi, err := os.Stat(root)
+		if err != nil {
+			return err
+		}
+		var archive *mountFS
+		if fi.Mode().IsRegular() && archiveKind(root) != "" {
+			a, err := OpenArchive(root)
+			if err != nil {
+				return err
+			}
+			archive = MountFS(a, root).(*mountFS)
+		} else if !fi.IsDir() {
+			return fmt.Errorf("doc root %q is not a directory", s.DocRoot)
+		}
+		// The archive replaced, if any, is left for the garbage collector
+		// to close, as requests may still be reading from it
+		s.archive.Store(archive)
+		s.docRoot.Store(&root)
+		return nil
+	}
+	fi, err := s.FS.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("doc root %q is not a directory", s.DocRoot)
+	}
+	s.docRoot.Store(&root)
+	return nil
+}
+
+// root returns the directory files are served from: the resolved
+// DocRoot, or DocRoot as is if it has not been resolved.
+func (s *Server) root() string {
+	if root := s.docRoot.Load(); root != nil {
+		return *root
+	}
+	return s.DocRoot
+}
+
+// purgeCaches purges the Caches of Routes.
+func (s *Server) purgeCaches() {
+	for _, r := range s.Routes {
+		if c, ok := r.Handler.(*Cache); ok {
+			c.Purge()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package tritonhttp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	for _, release := range []string{"v1", "v2"} {
+		if err := os.Mkdir(filepath.Join(dir, release), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, release, "index.html"), []byte(release), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	current := filepath.Join(dir, "current")
+	if err := os.Symlink("v1", current); err != nil {
+		t.Fatal(err)
+	}
+
+	hooked := 0
+	s := &Server{DocRoot: current, OnReload: func() error { hooked++; return nil }}
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.root(), filepath.Join(dir, "v1"); got != want {
+		t.Fatalf("root got: %v, want: %v", got, want)
+	}
+
+	// Switch the symlink; the old root stays until the next reload
+	if err := os.Remove(current); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("v2", current); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.root(), filepath.Join(dir, "v1"); got != want {
+		t.Fatalf("root before reload got: %v, want: %v", got, want)
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.root(), filepath.Join(dir, "v2"); got != want {
+		t.Fatalf("root after reload got: %v, want: %v", got, want)
+	}
+	if hooked != 2 {
+		t.Fatalf("OnReload ran %v times, want 2", hooked)
+	}
+
+	// A failed reload keeps the old root
+	s.DocRoot = filepath.Join(dir, "missing")
+	s.OnReload = func() error { return errors.New("bad config") }
+	if err := s.Reload(); err == nil {
+		t.Fatal("Reload of missing doc root succeeded")
+	}
+	if got, want := s.root(), filepath.Join(dir, "v2"); got != want {
+		t.Fatalf("root after failed reload got: %v, want: %v", got, want)
+	}
+}
+
+func TestReloadPurgesCaches(t *testing.T) {
+	backend := "v1"
+	up := &cacheUpstream{next: func(req *Request) (int, map[string]string, string) {
+		return 200, map[string]string{"Cache-Control": "max-age=60"}, backend
+	}}
+	c := &Cache{Handler: up}
+	s := &Server{DocRoot: t.TempDir(), Routes: []Route{{Prefix: "/api/", Handler: c}}}
+	if _, body := cacheGet(t, c, "/api/a", nil); body != "v1" {
+		t.Fatalf("got %q, want v1", body)
+	}
+
+	// Responses of the backends replaced are not served past a reload
+	backend = "v2"
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if res, body := cacheGet(t, c, "/api/a", nil); body != "v2" || res.Header["X-Cache"] != "MISS" {
+		t.Fatalf("after reload got %q, X-Cache %v, want v2 from a MISS", body, res.Header["X-Cache"])
+	}
+	if _, body := cacheGet(t, c, "/api/a", nil); body != "v2" || len(up.requests) != 2 {
+		t.Fatalf("got %q after %v upstream requests, want v2 after 2", body, len(up.requests))
+	}
+}
@@ -2,7 +2,14 @@ package tritonhttp
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -19,6 +26,40 @@ type Request struct {
 
 	Host  string // determine from the "Host" header
 	Close bool   // determine from the "Connection" header
+
+	// RemoteAddr is the network address of the client that sent the
+	// request. It is set by the server, not by ReadRequest.
+	RemoteAddr string
+
+	// Trace is the span of the request when the server traces requests.
+	Trace SpanContext
+
+	// Geo is where the client comes from, when the server looks it up,
+	// see GeoIP.
+	Geo GeoInfo
+
+	// Scheme is "https" for a request a Client sends, or a Server
+	// receives, over TLS, and "http" or "" otherwise. It is not set by
+	// ReadRequest.
+	Scheme string
+
+	// Body is the request body, of the Content-Length in Header. The
+	// server sets it for the requests it serves, skipping what its
+	// handler leaves unread; Write sends it after the headers. It is
+	// not set by ReadRequest.
+	Body io.Reader
+
+	// ctx is the context of the request, see Context and WithContext.
+	ctx context.Context
+
+	// interim writes an interim response to the client while the
+	// server waits for the response to the request.
+	interim func(res *Response) error
+
+	// conn and br are the connection the request was read from, and
+	// its reader, for a ResponseWriter to hijack.
+	conn net.Conn
+	br   *bufio.Reader
 }
 
 // ReadRequest tries to read the next valid request from br.
@@ -31,89 +72,66 @@ type Request struct {
 // some bytes are received before the error occurs. This is useful to determine
 // the timeout with partial request received condition.
 func ReadRequest(br *bufio.Reader) (req *Request, bytesReceived bool, err error) {
+	return readRequest(br, DefaultLimits())
+}
+
+// readRequest is ReadRequest enforcing the request size limits in lim.
+func readRequest(br *bufio.Reader, lim Limits) (req *Request, bytesReceived bool, err error) {
+	return readProxyRequest(br, lim, readOptions{})
+}
+
+// readOptions are the requests readProxyRequest accepts beyond the GETs
+// of paths.
+type readOptions struct {
+	proxy   bool // absolute-form GETs and CONNECTs, sent to a forward proxy
+	dav     bool // the WebDAV methods
+	methods bool // the standard methods but CONNECT, for the server to allow or refuse
+}
+
+// readProxyRequest is readRequest also accepting the requests of opts,
+// e.g. the absolute-form GETs and the CONNECTs sent to a forward proxy.
+func readProxyRequest(br *bufio.Reader, lim Limits, opts readOptions) (req *Request, bytesReceived bool, err error) {
 	// assume request is sent
 	bytesRec := false
 	// Read start line
-	line, err := ReadLine(br)
+	line, err := readLineSlice(br, lim.MaxRequestLineBytes)
+	if err == errLineTooLong {
+		return nil, true, fmt.Errorf("%w: request line exceeds %v bytes", ErrURITooLong, lim.MaxRequestLineBytes)
+	}
 	if err != nil {
 		return nil, len(line) != 0, err
 	}
 	bytesRec = true
-	fields := strings.SplitN(line, " ", 3)
-	if len(fields) != 3 {
-		return nil, bytesRec, fmt.Errorf("could not parse the request line, got fields %v", fields)
-	}
-	// check method/url/proto valid or not
-	// multiple spaces between, no space before or after (only between and only 1 space between)  (piazza)
-	if fields[0] != "GET" {
-		return nil, bytesRec, fmt.Errorf("invalid method %q", fields[0])
-	}
-
-	if len(fields[0]) == 0 || len(fields[1]) == 0 || len(fields[2]) == 0 {
-		return nil, bytesRec, fmt.Errorf("Bad Request, empty field")
-	}
-
-	if strings.Contains(fields[0], " ") || strings.Contains(fields[1], " ") || strings.Contains(fields[2], " ") {
-		return nil, bytesRec, fmt.Errorf("Bad Request, field contains spaces")
-	}
-
-	if !strings.HasPrefix(fields[1], "/") {
-		return nil, bytesRec, fmt.Errorf("Bad Request, invalid URL starts: %v", fields[1])
-	}
-
-	if fields[2] != "HTTP/1.1" {
-		return nil, bytesRec, fmt.Errorf("Bad Request, proto not HTTP/1.1, proto: %v", fields[2])
+	method, target, proto, err := parseRequestLine(line, lim, opts)
+	if err != nil {
+		return nil, bytesRec, err
 	}
 
-	req = &Request{}
-	req.Method = fields[0]
-	req.Proto = fields[2]
+	req = getRequest()
+	req.Method = method
+	req.Proto = proto
 	//req.Close = false
 
-	req.URL = fields[1]
+	req.URL = target
 	// if strings.HasSuffix(req.URL, "/") {
 	// 	req.URL = req.URL + "index.html"
 	// }
 	// fmt.Printf("url: %v\n", req.URL)
 
-	// Read headers
-	req.Header = make(map[string]string)
+	// Read headers, in a fixed array for the usual few before the map
+	var spansArr [maxInlineHeaders]headerSpan
+	values := getScratch()
+	defer putScratch(values)
 	checkConn := false
 	checkHost := false
 	// bytesRec = false
-	for {
-		line, err := ReadLine(br)
-		if err != nil {
-			fmt.Printf("Error while read line: %v\n", err)
-			return nil, bytesRec, err
-		}
-		if line == "" {
-			// header end
-			break
-		}
-		// bytesRec = true
-		// fmt.Println("Read line from request", line)
-		h := strings.SplitN(line, ":", 2)
-		// check h valid
-		if len(h) != 2 {
-			return nil, bytesRec, fmt.Errorf("Bad Request, invalid header format: %v", h)
-		}
-
-		if strings.HasSuffix(h[0], " ") || strings.HasPrefix(h[0], " ") {
-			return nil, bytesRec, fmt.Errorf("Bad Request, host has space")
-		}
-		if len(strings.TrimSpace(h[0])) == 0 {
-			return nil, bytesRec, fmt.Errorf("Bad Request, host is empty")
-		}
-
-		for _, c := range h[0] {
-			if (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && c != '-' {
-				return nil, bytesRec, fmt.Errorf("Bad Request, host contains not accepted char: %v\n", h[0])
-			}
-		}
-
-		key := CanonicalHeaderKey(h[0])
-		value := strings.TrimLeft(h[1], " ")
+	spans, err := readHeaderSpans(br, lim, spansArr[:0], values)
+	if err != nil {
+		return nil, bytesRec, err
+	}
+	all := string(*values)
+	for _, span := range spans {
+		key, value := span.key, all[span.start:span.end]
 
 		if key == "Connection" {
 			checkConn = true
@@ -125,6 +143,12 @@ func ReadRequest(br *bufio.Reader) (req *Request, bytesReceived bool, err error)
 			checkHost = true
 		}
 
+		// A body framed by several lengths could be split differently
+		// by the server and a proxy in front of it, smuggling requests
+		if prev, ok := req.Header["Content-Length"]; ok && key == "Content-Length" && prev != value {
+			return nil, bytesRec, fmt.Errorf("%w: repeated as %q and %q", ErrInvalidContentLength, prev, value)
+		}
+
 		req.Header[key] = value
 	}
 
@@ -139,11 +163,434 @@ func ReadRequest(br *bufio.Reader) (req *Request, bytesReceived bool, err error)
 		}
 		delete(req.Header, "Connection")
 	}
+	// Chunked bodies are not read, so a request with one, or a
+	// Transfer-Encoding a proxy may frame it by instead of its
+	// Content-Length, is refused before its body is taken for requests
+	if _, ok := req.Header["Transfer-Encoding"]; ok {
+		if _, ok := req.Header["Content-Length"]; ok {
+			return nil, bytesRec, fmt.Errorf("%w: sent with a Transfer-Encoding", ErrInvalidContentLength)
+		}
+		return nil, bytesRec, fmt.Errorf("%w: %q", ErrUnsupportedTransferEncoding, req.Header["Transfer-Encoding"])
+	}
+	if cl, ok := req.Header["Content-Length"]; ok {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			return nil, bytesRec, fmt.Errorf("%w: %q", ErrInvalidContentLength, cl)
+		}
+		if n > lim.MaxBodyBytes {
+			return nil, bytesRec, fmt.Errorf("%w: %v bytes exceed %v", ErrBodyTooLarge, n, lim.MaxBodyBytes)
+		}
+	}
 	if checkHost {
 		delete(req.Header, "Host")
 	} else {
-		return nil, bytesRec, fmt.Errorf("Bad Request: missing host")
+		return nil, bytesRec, ErrMissingHost
 	}
 
 	return req, bytesRec, nil
 }
+
+// ParseRequestLine parses line, a request line without its "\r\n",
+// into its method, request target and protocol version, enforcing
+// the default URL length limit. It only accepts what ReadRequest does.
+// The "." and ".." segments of the target path are removed; targets
+// whose ".." segments climb above the root are rejected.
+func ParseRequestLine(line []byte) (method, target, proto string, err error) {
+	return parseRequestLine(line, DefaultLimits(), readOptions{})
+}
+
+// parseRequestLine is ParseRequestLine enforcing the URL length limit in
+// lim, and also accepting the requests of opts. The method
+// and protocol it accepts are constants, so that only the target is
+// copied out of line.
+func parseRequestLine(line []byte, lim Limits, opts readOptions) (method, target, proto string, err error) {
+	sp1 := bytes.IndexByte(line, ' ')
+	sp2 := -1
+	if sp1 >= 0 {
+		if i := bytes.IndexByte(line[sp1+1:], ' '); i >= 0 {
+			sp2 = sp1 + 1 + i
+		}
+	}
+	if sp2 < 0 {
+		return "", "", "", fmt.Errorf("%w: %q", ErrMalformedRequestLine, line)
+	}
+	m, t, p := line[:sp1], line[sp1+1:sp2], line[sp2+1:]
+	// check method/url/proto valid or not
+	// multiple spaces between, no space before or after (only between and only 1 space between)  (piazza)
+	switch {
+	case string(m) == "GET":
+		method = "GET"
+	case opts.proxy && string(m) == "CONNECT":
+		method = "CONNECT"
+	case opts.dav && davMethods[string(m)] != "":
+		method = davMethods[string(m)]
+	case opts.methods && standardMethods[string(m)] != "" && string(m) != "CONNECT":
+		method = standardMethods[string(m)]
+	case standardMethods[string(m)] != "":
+		return "", "", "", fmt.Errorf("%w: %s", ErrUnsupportedMethod, m)
+	default:
+		return "", "", "", fmt.Errorf("%w: unknown method %q", ErrMalformedRequestLine, m)
+	}
+
+	if len(t) == 0 || len(p) == 0 {
+		return "", "", "", fmt.Errorf("%w: empty field", ErrMalformedRequestLine)
+	}
+
+	if bytes.IndexByte(p, ' ') >= 0 {
+		return "", "", "", fmt.Errorf("%w: field contains spaces", ErrMalformedRequestLine)
+	}
+
+	if len(t) > lim.MaxURLLength {
+		return "", "", "", fmt.Errorf("%w: longer than %v bytes", ErrURITooLong, lim.MaxURLLength)
+	}
+
+	target = string(t)
+	switch {
+	case method == "CONNECT":
+		if _, port, err := net.SplitHostPort(target); err != nil || port == "" {
+			return "", "", "", fmt.Errorf("%w: invalid CONNECT authority %q", ErrInvalidTarget, target)
+		}
+	case opts.proxy && strings.HasPrefix(target, "http://"):
+		// Absolute form, for the forward proxy
+	case method == "OPTIONS" && target == "*":
+		// Asterisk form, of the server as a whole
+	case !strings.HasPrefix(target, "/"):
+		return "", "", "", fmt.Errorf("%w: %q does not start with /", ErrInvalidTarget, target)
+	default:
+		if err := checkTarget(target); err != nil {
+			return "", "", "", err
+		}
+		clean, ok := removeDotSegments(target)
+		if !ok {
+			return "", "", "", fmt.Errorf("%w: %q climbs above the root", ErrInvalidTarget, target)
+		}
+		target = clean
+	}
+
+	if string(p) != "HTTP/1.1" {
+		if isHTTPVersion(string(p)) {
+			return "", "", "", fmt.Errorf("%w: %s", ErrUnsupportedVersion, p)
+		}
+		return "", "", "", fmt.Errorf("%w: invalid protocol %q", ErrMalformedRequestLine, p)
+	}
+	return method, target, "HTTP/1.1", nil
+}
+
+// standardMethods are the methods of RFC 9110 and RFC 5789, by their
+// name, which the server answers with 405 Method Not Allowed unless it
+// allows them, rather than as malformed.
+var standardMethods = map[string]string{
+	"GET": "GET", "HEAD": "HEAD", "POST": "POST", "PUT": "PUT", "DELETE": "DELETE",
+	"CONNECT": "CONNECT", "OPTIONS": "OPTIONS", "TRACE": "TRACE", "PATCH": "PATCH",
+}
+
+// isHTTPVersion reports whether proto is a well-formed HTTP-version,
+// e.g. "HTTP/1.0", whether or not it is supported.
+func isHTTPVersion(proto string) bool {
+	v, ok := strings.CutPrefix(proto, "HTTP/")
+	return ok && len(v) == 3 && '0' <= v[0] && v[0] <= '9' && v[1] == '.' && '0' <= v[2] && v[2] <= '9'
+}
+
+// targetError is the error of a malformed request target, whose
+// reason the 400 Bad Request answering it gives in its body.
+type targetError struct {
+	target string
+	reason string
+}
+
+func (e *targetError) Error() string {
+	return fmt.Sprintf("%v, %v: %q", ErrInvalidTarget, e.reason, e.target)
+}
+
+func (e *targetError) Unwrap() error {
+	return ErrInvalidTarget
+}
+
+// checkTarget checks that target, an origin-form request target, is
+// made of the characters RFC 3986 allows in a path and query, with
+// well-formed percent-escapes and no fragment, which clients never
+// send, so that no other bytes reach the file system.
+func checkTarget(target string) error {
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		switch {
+		case c == '#':
+			return &targetError{target, "fragment in request target"}
+		case c == '%':
+			if i+2 >= len(target) || !isHex(target[i+1]) || !isHex(target[i+2]) {
+				return &targetError{target, fmt.Sprintf("malformed percent-escape at offset %d", i)}
+			}
+			i += 2
+		case !isTargetChar(c):
+			return &targetError{target, fmt.Sprintf("invalid character %q in request target", target[i:i+1])}
+		}
+	}
+	return nil
+}
+
+// isTargetChar reports whether c may appear unescaped in the path or
+// query of a request target: an unreserved character, a sub-delim,
+// ':', '@', '/' or '?'.
+func isTargetChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("-._~!$&'()*+,;=:@/?", c) >= 0
+}
+
+// escapeLocation percent-encodes the bytes of location, a URL, that may
+// not appear in it as is, e.g. those of UTF-8 names and spaces, leaving
+// its percent-escapes alone.
+func escapeLocation(location string) string {
+	var b strings.Builder
+	for i := 0; i < len(location); i++ {
+		c := location[i]
+		switch {
+		case c == '%' && i+2 < len(location) && isHex(location[i+1]) && isHex(location[i+2]),
+			c == '#', c != '%' && isTargetChar(c):
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// decodedPath returns the path of target, an origin-form request
+// target, with its percent-escapes decoded and then its dot segments
+// removed, as it names a file: "/a/%2e%2e/b" names /b. It reports false
+// if the path is malformed, holds a NUL, or climbs above the root once
+// decoded, as "/%2e%2e/x" does.
+func decodedPath(target string) (string, bool) {
+	urlPath, _, _ := strings.Cut(target, "?")
+	name, err := url.PathUnescape(urlPath)
+	if err != nil || !strings.HasPrefix(name, "/") || strings.IndexByte(name, 0) >= 0 {
+		return "", false
+	}
+	return removeDotSegments(name)
+}
+
+// removeDotSegments removes the "." and ".." segments of the path of
+// target, an origin-form request target, as RFC 3986 section 5.2.4
+// does, leaving its query as is. It reports false if a ".." segment
+// would climb above the root.
+func removeDotSegments(target string) (string, bool) {
+	p, query, hasQuery := strings.Cut(target, "?")
+	if !strings.Contains(p, "/.") {
+		return target, true
+	}
+	segs := strings.Split(p[1:], "/")
+	out := make([]string, 0, len(segs))
+	for i, seg := range segs {
+		last := i == len(segs)-1
+		switch seg {
+		case ".":
+		case "..":
+			if len(out) == 0 {
+				return "", false
+			}
+			out = out[:len(out)-1]
+		default:
+			out = append(out, seg)
+			continue
+		}
+		// A trailing dot segment names the directory
+		if last {
+			out = append(out, "")
+		}
+	}
+	p = "/" + strings.Join(out, "/")
+	if hasQuery {
+		p += "?" + query
+	}
+	return p, true
+}
+
+// ParseHeaderLine parses line, a header line without its "\r\n",
+// into its canonical key and its value, stripped of leading spaces.
+func ParseHeaderLine(line []byte) (key, value string, err error) {
+	k, v, err := parseHeaderBytes(line)
+	if err != nil {
+		return "", "", err
+	}
+	return headerKey(k), string(v), nil
+}
+
+// headerSpan is a header read by readProxyRequest: its key, and where
+// its value is in the values gathered.
+type headerSpan struct {
+	key        string
+	start, end int
+}
+
+// maxInlineHeaders is how many header lines are read without
+// allocating: most requests and responses have fewer.
+const maxInlineHeaders = 16
+
+// readHeaderSpans reads the header lines from br up to the blank line
+// ending them, within the limits of lim, appending their values to
+// values and their spans in it to spans. The lines are only valid
+// until the next read, so the values are gathered, to be made into
+// one string sliced up once all are read.
+func readHeaderSpans(br *bufio.Reader, lim Limits, spans []headerSpan, values *[]byte) ([]headerSpan, error) {
+	headerBytes := 0
+	for {
+		line, err := readLineSlice(br, lim.MaxHeaderBytes-headerBytes)
+		if err == errLineTooLong {
+			return nil, fmt.Errorf("%w: headers exceed %v bytes", ErrHeaderTooLarge, lim.MaxHeaderBytes)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			return spans, nil
+		}
+		headerBytes += len(line) + 2
+		if len(spans) >= lim.MaxHeaderCount {
+			return nil, fmt.Errorf("%w: more than %v headers", ErrHeaderTooLarge, lim.MaxHeaderCount)
+		}
+		k, v, err := parseHeaderBytes(line)
+		if err != nil {
+			return nil, err
+		}
+		span := headerSpan{key: headerKey(k), start: len(*values)}
+		*values = append(*values, v...)
+		span.end = len(*values)
+		spans = append(spans, span)
+	}
+}
+
+// parseHeaderBytes is ParseHeaderLine returning the key, as is, and
+// the value as slices of line.
+func parseHeaderBytes(line []byte) (key, value []byte, err error) {
+	colon := bytes.IndexByte(line, ':')
+	// check h valid
+	if colon < 0 {
+		return nil, nil, fmt.Errorf("%w: no colon in %q", ErrMalformedHeader, line)
+	}
+	key, value = line[:colon], line[colon+1:]
+
+	if len(key) > 0 && (key[0] == ' ' || key[len(key)-1] == ' ') {
+		return nil, nil, fmt.Errorf("%w: key has spaces", ErrMalformedHeader)
+	}
+	if len(bytes.TrimSpace(key)) == 0 {
+		return nil, nil, fmt.Errorf("%w: key is empty", ErrMalformedHeader)
+	}
+
+	for _, c := range key {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && c != '-' {
+			return nil, nil, fmt.Errorf("%w: invalid key %q", ErrMalformedHeader, key)
+		}
+	}
+
+	for len(value) > 0 && value[0] == ' ' {
+		value = value[1:]
+	}
+	return key, value, nil
+}
+
+// commonHeaderKeys maps the keys of common headers, canonical or in
+// lower case, to their canonical form, so that reading them, in
+// requests or responses, takes no allocation.
+var commonHeaderKeys = make(map[string]string)
+
+func init() {
+	for _, key := range []string{
+		"Accept", "Accept-Charset", "Accept-Encoding", "Accept-Language",
+		"Authorization", "Cache-Control", "Connection", "Content-Length",
+		"Content-Type", "Cookie", "Dnt", "Expect", "Forwarded", "Host",
+		"If-Match", "If-Modified-Since", "If-None-Match", "If-Range",
+		"Origin", "Pragma", "Proxy-Authorization", "Range", "Referer",
+		"Sec-Fetch-Dest", "Sec-Fetch-Mode", "Sec-Fetch-Site", "Te",
+		"Transfer-Encoding", "Upgrade", "Upgrade-Insecure-Requests",
+		"User-Agent", "Via", "X-Forwarded-For", "X-Forwarded-Host",
+		"X-Forwarded-Proto", "X-Real-Ip", "X-Request-Id",
+		// Those of responses, read from upstreams
+		"Accept-Ranges", "Age", "Content-Disposition", "Content-Encoding",
+		"Content-Range", "Date", "Etag", "Expires", "Keep-Alive",
+		"Last-Modified", "Location", "Retry-After", "Server", "Set-Cookie",
+		"Strict-Transport-Security", "Vary", "Www-Authenticate",
+	} {
+		commonHeaderKeys[key] = key
+		commonHeaderKeys[strings.ToLower(key)] = key
+	}
+}
+
+// headerKey returns the canonical form of key, a valid header key.
+func headerKey(key []byte) string {
+	if k, ok := commonHeaderKeys[string(key)]; ok {
+		return k
+	}
+	return CanonicalHeaderKey(string(key))
+}
+
+// ParseRequestBytes parses the request at the start of b with the
+// default limits, as ReadRequest would, and returns it along with the
+// number of bytes of b it took up. It never blocks or touches the
+// network. A request cut short by the end of b is reported as
+// io.ErrUnexpectedEOF, and an empty b as io.EOF.
+func ParseRequestBytes(b []byte) (req *Request, n int, err error) {
+	r := bytes.NewReader(b)
+	br := bufio.NewReader(r)
+	req, received, err := ReadRequest(br)
+	if err == io.EOF && received {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return req, len(b) - r.Len() - br.Buffered(), nil
+}
+
+// Context returns the context of req, set by WithContext, or the
+// background context.
+func (req *Request) Context() context.Context {
+	if req.ctx != nil {
+		return req.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of req with its context changed
+// to ctx, for a Handler wrapping another to pass it values, e.g. the
+// Session of the request.
+func (req *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("tritonhttp: nil context")
+	}
+	r := *req
+	r.ctx = ctx
+	return &r
+}
+
+// Write writes req to w in wire format: the request line, the Host
+// and Connection headers from the special fields, then the other
+// headers in sorted order, the blank line ending the headers and Body.
+func (req *Request) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%v %v %v\r\n", req.Method, req.URL, req.Proto)
+	fmt.Fprintf(bw, "Host: %v\r\n", req.Host)
+	if req.Close {
+		bw.WriteString("Connection: close\r\n")
+	}
+	keys := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(bw, "%v: %v\r\n", k, req.Header[k])
+	}
+	bw.WriteString("\r\n")
+	if req.Body != nil {
+		if _, err := io.Copy(bw, req.Body); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
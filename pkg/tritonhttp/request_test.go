@@ -2,9 +2,17 @@ package tritonhttp
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func checkGoodRequest(t *testing.T, readErr error, reqGot, reqWant *Request) {
@@ -275,6 +283,22 @@ func TestReadBadRequest(t *testing.T) {
 			"MalformedURL",
 			"GET subdir/ HTTP/1.1\r\nHost: test\r\n\r\n",
 		},
+		{
+			"AboveRoot",
+			"GET /a/../../etc/passwd HTTP/1.1\r\nHost: test\r\n\r\n",
+		},
+		{
+			"Fragment",
+			"GET /index.html#top HTTP/1.1\r\nHost: test\r\n\r\n",
+		},
+		{
+			"MalformedPercentEscape",
+			"GET /%zzindex.html HTTP/1.1\r\nHost: test\r\n\r\n",
+		},
+		{
+			"ControlCharInURL",
+			"GET /index\x00.html HTTP/1.1\r\nHost: test\r\n\r\n",
+		},
 	}
 
 	for _, tt := range tests {
@@ -346,3 +370,356 @@ func TestReadMultipleRequests(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRequestBytes(t *testing.T) {
+	b := []byte("GET /a HTTP/1.1\r\nHost: test\r\n\r\nGET /b HTTP/1.1\r\nHost: test\r\n")
+	req, n, err := ParseRequestBytes(b)
+	checkGoodRequest(t, err, req, &Request{Method: "GET", URL: "/a", Proto: "HTTP/1.1", Header: map[string]string{}, Host: "test"})
+	if n != 31 {
+		t.Fatalf("consumed got: %v, want: 31", n)
+	}
+	if _, _, err := ParseRequestBytes(b[n:]); err != io.ErrUnexpectedEOF {
+		t.Fatalf("truncated got error: %v, want: %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, _, err := ParseRequestBytes(nil); err != io.EOF {
+		t.Fatalf("empty got error: %v, want: %v", err, io.EOF)
+	}
+}
+
+func FuzzParseRequestLine(f *testing.F) {
+	for _, seed := range []string{"GET / HTTP/1.1", "GET /a/b.html HTTP/1.1", "GET  / HTTP/1.1", "POST / HTTP/1.1", "GET http://x/ HTTP/1.0"} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, line []byte) {
+		method, target, proto, err := ParseRequestLine(line)
+		if err != nil {
+			return
+		}
+		if method != "GET" || proto != "HTTP/1.1" || !strings.HasPrefix(target, "/") || strings.Contains(target, " ") {
+			t.Fatalf("%q parsed as %q %q %q", line, method, target, proto)
+		}
+		// Only dot segments are removed from the target
+		if got := method + " " + target + " " + proto; got != string(line) && !strings.Contains(string(line), "/.") {
+			t.Fatalf("%q parsed as %q", line, got)
+		}
+		if again, ok := removeDotSegments(target); !ok || again != target {
+			t.Fatalf("%q parsed as %q, which normalizes to %q", line, target, again)
+		}
+	})
+}
+
+func TestRemoveDotSegments(t *testing.T) {
+	var tests = []struct {
+		target string
+		want   string
+		wantOK bool
+	}{
+		{"/a/b/c", "/a/b/c", true},
+		{"/a/./b/../c", "/a/c", true},
+		{"/a/b/..", "/a/", true},
+		{"/a/b/.", "/a/b/", true},
+		{"/a/..", "/", true},
+		{"/./", "/", true},
+		{"/a//../b", "/a/b", true},
+		{"/a/.../b", "/a/.../b", true},
+		{"/a/..b/.c", "/a/..b/.c", true},
+		{"/a/../b?x=/../..", "/b?x=/../..", true},
+		{"/..", "", false},
+		{"/a/../../b", "", false},
+		{"/a/./../../b", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := removeDotSegments(tt.target)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%q: got %q, %v, want %q, %v", tt.target, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	var tests = []struct {
+		target     string
+		wantReason string
+	}{
+		{"/index.html", ""},
+		{"/a/b?x=1&y=%2F;z", ""},
+		{"/~user/a-b_c.d!$'()*+,;=:@", ""},
+		{"/a?b=/c?d", ""},
+		{"/%E4%BD%A0.html", ""},
+		{"/a#b", "fragment in request target"},
+		{"/a?b#", "fragment in request target"},
+		{"/a%", "malformed percent-escape at offset 2"},
+		{"/a%2", "malformed percent-escape at offset 2"},
+		{"/a%g0", "malformed percent-escape at offset 2"},
+		{"/a%20%2x", "malformed percent-escape at offset 5"},
+		{"/a b", `invalid character " " in request target`},
+		{"/a\\b", `invalid character "\\" in request target`},
+		{"/<script>", `invalid character "<" in request target`},
+		{"/a\x7fb", `invalid character "\x7f" in request target`},
+		{"/\xe4\xbd\xa0.html", `invalid character "\xe4" in request target`},
+		{"/a?b=\"c\"", `invalid character "\"" in request target`},
+	}
+	for _, tt := range tests {
+		err := checkTarget(tt.target)
+		var reason string
+		if te, ok := err.(*targetError); ok {
+			reason = te.reason
+		} else if err != nil {
+			t.Fatalf("%q: got %T error %v", tt.target, err, err)
+		}
+		if reason != tt.wantReason {
+			t.Errorf("%q: got reason %q, want %q", tt.target, reason, tt.wantReason)
+		}
+	}
+}
+
+func TestEscapeLocation(t *testing.T) {
+	var tests = []struct {
+		location string
+		want     string
+	}{
+		{"/docs/", "/docs/"},
+		{"https://example.com/a?b=c#d", "https://example.com/a?b=c#d"},
+		{"/café/", "/caf%C3%A9/"},
+		{"/a b", "/a%20b"},
+		{"/caf%C3%A9", "/caf%C3%A9"},
+		{"/100%", "/100%25"},
+		{"/%zz", "/%25zz"},
+	}
+	for _, tt := range tests {
+		if got := escapeLocation(tt.location); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.location, got, tt.want)
+		}
+	}
+}
+
+func TestBadTargetReason(t *testing.T) {
+	s := &Server{DocRoot: t.TempDir(), ErrorLog: NewLogger(nil, LevelError)}
+	addr, _ := startTestServer(t, s)
+	res := exchangeRaw(t, addr, "GET /a%zz HTTP/1.1\r\nHost: test\r\n\r\n", 1)[0]
+	body, _ := io.ReadAll(res.BodyReader)
+	if res.StatusCode != 400 || string(body) != "malformed percent-escape at offset 2\n" {
+		t.Fatalf("got %v %q, want 400 with the reason", res.StatusCode, body)
+	}
+	if got := res.Header["Connection"]; got != "close" {
+		t.Errorf("got Connection %q, want close", got)
+	}
+}
+
+func TestReadRequestErrors(t *testing.T) {
+	lim := DefaultLimits()
+	lim.MaxRequestLineBytes = 64
+	lim.MaxURLLength = 32
+	lim.MaxHeaderBytes = 64
+	lim.MaxHeaderCount = 2
+	lim.MaxBodyBytes = 10
+	tests := []struct {
+		name   string
+		raw    string
+		want   error
+		status int
+	}{
+		{"Fields", "GET /\r\nHost: test\r\n\r\n", ErrMalformedRequestLine, 400},
+		{"UnknownMethod", "GETT / HTTP/1.1\r\nHost: test\r\n\r\n", ErrMalformedRequestLine, 400},
+		{"Post", "POST / HTTP/1.1\r\nHost: test\r\n\r\n", ErrUnsupportedMethod, 405},
+		{"Relative", "GET index.html HTTP/1.1\r\nHost: test\r\n\r\n", ErrInvalidTarget, 400},
+		{"BadEscape", "GET /a%zz HTTP/1.1\r\nHost: test\r\n\r\n", ErrInvalidTarget, 400},
+		{"LongURL", "GET /" + strings.Repeat("a", 40) + " HTTP/1.1\r\nHost: test\r\n\r\n", ErrURITooLong, 414},
+		{"LongLine", "GET /" + strings.Repeat("a", 80) + " HTTP/1.1\r\nHost: test\r\n\r\n", ErrURITooLong, 414},
+		{"HTTP10", "GET / HTTP/1.0\r\nHost: test\r\n\r\n", ErrUnsupportedVersion, 505},
+		{"BadProto", "GET / HTTP/one\r\nHost: test\r\n\r\n", ErrMalformedRequestLine, 400},
+		{"HeaderBytes", "GET / HTTP/1.1\r\nHost: test\r\nX-A: " + strings.Repeat("a", 64) + "\r\n\r\n", ErrHeaderTooLarge, 431},
+		{"HeaderCount", "GET / HTTP/1.1\r\nHost: test\r\nX-A: a\r\nX-B: b\r\n\r\n", ErrHeaderTooLarge, 431},
+		{"NoColon", "GET / HTTP/1.1\r\nHost: test\r\nno colon\r\n\r\n", ErrMalformedHeader, 400},
+		{"NoHost", "GET / HTTP/1.1\r\n\r\n", ErrMissingHost, 400},
+		{"ContentLength", "GET / HTTP/1.1\r\nHost: test\r\nContent-Length: x\r\n\r\n", ErrInvalidContentLength, 400},
+		{"LargeBody", "GET / HTTP/1.1\r\nHost: test\r\nContent-Length: 11\r\n\r\n", ErrBodyTooLarge, 413},
+		{"Chunked", "GET / HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n", ErrUnsupportedTransferEncoding, 501},
+		{"ChunkedLength", "GET / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 1\r\n\r\n", ErrInvalidContentLength, 400},
+		{"RepeatedLength", "GET / HTTP/1.1\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\n", ErrInvalidContentLength, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readRequest(bufio.NewReader(strings.NewReader(tt.raw)), lim)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if got := StatusFromError(err); got != tt.status {
+				t.Errorf("StatusFromError(%v) = %v, want %v", err, got, tt.status)
+			}
+		})
+	}
+}
+
+func TestRequestSmuggling(t *testing.T) {
+	root := t.TempDir()
+	for name, data := range map[string]string{"index.html": "home", "secret.html": "secret"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{DocRoot: root, ErrorLog: NewLogger(nil, LevelError)}
+	addr, _ := startTestServer(t, s)
+
+	// Each hides a request for secret.html in what the server would
+	// take for the body of the first one by one framing or the other
+	const hidden = "GET /secret.html HTTP/1.1\r\nHost: test\r\n\r\n"
+	chunked := fmt.Sprintf("0\r\n\r\n%v", hidden)
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+	}{
+		{"TransferEncoding", "GET /index.html HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n" + chunked, 501},
+		{"TransferEncodingLength", "GET /index.html HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\nContent-Length: 4\r\n\r\n" + chunked, 400},
+		{"LengthTransferEncoding", "GET /index.html HTTP/1.1\r\nHost: test\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n" + chunked, 400},
+		{"RepeatedLength", fmt.Sprintf("GET /index.html HTTP/1.1\r\nHost: test\r\nContent-Length: 0\r\nContent-Length: %v\r\n\r\n%v", len(hidden), hidden), 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := io.WriteString(conn, tt.raw); err != nil {
+				t.Fatal(err)
+			}
+			br := bufio.NewReader(conn)
+			res, err := ReadResponse(br, &Request{Method: "GET"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.BodyReader)
+			if res.StatusCode != tt.status || res.Header["Connection"] != "close" {
+				t.Fatalf("got %v with Connection %q, want %v and close", res.StatusCode, res.Header["Connection"], tt.status)
+			}
+			// The hidden request is never served
+			if rest, err := io.ReadAll(br); err != nil || len(rest) != 0 {
+				t.Errorf("got %q, %v after the response, want the connection closed", rest, err)
+			}
+		})
+	}
+
+	// A Content-Length repeated alike frames the body all the same
+	res := exchangeRaw(t, addr, "GET /index.html HTTP/1.1\r\nHost: test\r\nContent-Length: 2\r\nContent-Length: 2\r\n\r\nhi"+
+		"GET /index.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 2)
+	for i, r := range res {
+		if body, _ := io.ReadAll(r.BodyReader); r.StatusCode != 200 || string(body) != "home" {
+			t.Errorf("response %v got %v %q, want 200 home", i, r.StatusCode, body)
+		}
+	}
+}
+
+func TestRequestErrorStatus(t *testing.T) {
+	s := &Server{DocRoot: t.TempDir(), ErrorLog: NewLogger(nil, LevelError)}
+	addr, _ := startTestServer(t, s)
+	tests := []struct {
+		raw    string
+		status int
+		allow  string
+	}{
+		{"CONNECT example.com:443 HTTP/1.1\r\nHost: test\r\n\r\n", 405, "GET, OPTIONS"},
+		{"GET / HTTP/1.0\r\nHost: test\r\n\r\n", 505, ""},
+		{"GETT / HTTP/1.1\r\nHost: test\r\n\r\n", 400, ""},
+	}
+	for _, tt := range tests {
+		res := exchangeRaw(t, addr, tt.raw, 1)[0]
+		if res.StatusCode != tt.status || res.Header["Allow"] != tt.allow || res.Header["Connection"] != "close" {
+			t.Errorf("%q: got %v with Allow %q, Connection %q, want %v with Allow %q, Connection close",
+				tt.raw, res.StatusCode, res.Header["Allow"], res.Header["Connection"], tt.status, tt.allow)
+		}
+	}
+}
+
+func TestReadRequestAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not counted under the race detector")
+	}
+	raw := []byte("GET /index.html HTTP/1.1\r\nHost: test\r\nUser-Agent: x/1\r\nAccept: */*\r\nAccept-Encoding: gzip\r\nConnection: keep-alive\r\n\r\n")
+	r := bytes.NewReader(raw)
+	br := bufio.NewReader(r)
+	lim := DefaultLimits()
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset(raw)
+		br.Reset(r)
+		req, _, err := readRequest(br, lim)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releaseRequest(req)
+	})
+	// The target, and one string for all the header values
+	if allocs > 2 {
+		t.Errorf("got %v allocations per request, want at most 2", allocs)
+	}
+}
+
+func TestParseHeaderKeys(t *testing.T) {
+	var tests = []struct {
+		line  string
+		key   string
+		value string
+	}{
+		{"host: test", "Host", "test"},
+		{"Content-Length:  5", "Content-Length", "5"},
+		{"x-custom-thing: a b ", "X-Custom-Thing", "a b "},
+		{"ACCEPT: */*", "Accept", "*/*"},
+	}
+	for _, tt := range tests {
+		key, value, err := ParseHeaderLine([]byte(tt.line))
+		if err != nil || key != tt.key || value != tt.value {
+			t.Errorf("%q: got %q: %q, %v, want %q: %q", tt.line, key, value, err, tt.key, tt.value)
+		}
+	}
+}
+
+func FuzzParseHeaderLine(f *testing.F) {
+	for _, seed := range []string{"Host: test", "content-length:5", "X-A:  b", " Host: test", "Host : test", "Ho_st: x", ":"} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, line []byte) {
+		key, value, err := ParseHeaderLine(line)
+		if err != nil {
+			return
+		}
+		if key == "" || key != CanonicalHeaderKey(key) || strings.HasPrefix(value, " ") {
+			t.Fatalf("%q parsed as %q: %q", line, key, value)
+		}
+		// The parsed header reads back the same
+		key2, value2, err := ParseHeaderLine([]byte(key + ": " + value))
+		if err != nil || key2 != key || value2 != value {
+			t.Fatalf("%q: %q: %q read back as %q: %q, %v", line, key, value, key2, value2, err)
+		}
+	})
+}
+
+func FuzzParseRequestBytes(f *testing.F) {
+	for _, seed := range []string{
+		"GET / HTTP/1.1\r\nHost: test\r\n\r\n",
+		"GET /index.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\nContent-Length: 0\r\n\r\nGET / HTTP/1.1\r\n",
+		"GET / HTTP/1.1\r\nHost: test\r\nno colon\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: test\r\nContent-Length: -1\r\n\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		req, n, err := ParseRequestBytes(b)
+		if err != nil {
+			return
+		}
+		if n <= 0 || n > len(b) {
+			t.Fatalf("%q: consumed %v bytes", b, n)
+		}
+		// What is written reads back the same
+		var buf bytes.Buffer
+		if err := req.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		got, m, err := ParseRequestBytes(buf.Bytes())
+		if err != nil || m != buf.Len() || !reflect.DeepEqual(got, req) {
+			t.Fatalf("%q parsed as %+v, written as %q, read back as %+v, %v", b, req, buf.Bytes(), got, err)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// reserved reports whether the path of req, percent-escapes decoded
+// and dot segments removed, as it names a file, is under one of the
+// Reserved prefixes of s.
+func (s *Server) reserved(req *Request) bool {
+	urlPath, _, _ := strings.Cut(req.URL, "?")
+	if name, ok := decodedPath(urlPath); ok {
+		urlPath = name
+	}
+	for _, prefix := range s.Reserved {
+		if prefixMatches(prefix, urlPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveReserved returns the response of the first Internal route
+// matching req, or 404 Not Found if none does, if req is for a Reserved
+// prefix, or nil otherwise.
+func (s *Server) serveReserved(req *Request) *Response {
+	if !s.reserved(req) {
+		return nil
+	}
+	urlPath, _, _ := strings.Cut(req.URL, "?")
+	for i := range s.Internal {
+		if s.Internal[i].matches(urlPath) {
+			return s.Internal[i].serve(req)
+		}
+	}
+	res := &Response{}
+	res.HandleNotFound(req)
+	return res
+}
+
+// HealthHandler returns the handler of a health check endpoint for
+// load balancers: it answers "ok" with 200 OK, or 503 Service
+// Unavailable while s is in maintenance or shutting down.
+func (s *Server) HealthHandler() Handler {
+	return HandlerFunc(func(req *Request) *Response {
+		res := &Response{}
+		if s.current().Maintenance || s.shuttingDown() {
+			res.HandleServiceUnavailable(req, 0)
+			return res
+		}
+		res.Text(statusOK, "ok\n")
+		return res
+	})
+}
+
+// MetricsHandler returns the handler of a metrics endpoint: it answers
+// with the counters PublishExpvar publishes, one "tritonhttp_name value"
+// line each, sorted by name, in the Prometheus text format.
+func (s *Server) MetricsHandler() Handler {
+	return HandlerFunc(func(req *Request) *Response {
+		counters := s.expvarCounters()
+		names := make([]string, 0, len(counters))
+		for name := range counters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		for _, name := range names {
+			fmt.Fprintf(&b, "tritonhttp_%v %v\n", name, counters[name])
+		}
+		res := &Response{}
+		res.Text(statusOK, b.String())
+		return res
+	})
+}
@@ -0,0 +1,79 @@
+package tritonhttp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServerReserved(t *testing.T) {
+	root := t.TempDir()
+	for name, data := range map[string]string{
+		"_admin/secret.txt": "secret",
+		"_health":           "stale",
+		"_adminx.txt":       "public",
+	} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{
+		DocRoot:   root,
+		Reserved:  []string{"/_admin", "/_health", "/_metrics"},
+		Rewrites:  []Rewrite{{Prefix: "/x", Replacement: "/_admin"}},
+		Aliases:   []Alias{{Path: "/y", Target: "/_admin/secret.txt"}},
+		Redirects: []Redirect{{Path: "/_health", Location: "/elsewhere"}},
+		ErrorLog:  NewLogger(nil, LevelError),
+	}
+	s.Internal = []Route{
+		{Prefix: "/_health", Handler: s.HealthHandler()},
+		{Prefix: "/_metrics", Handler: s.MetricsHandler()},
+	}
+	addr, _ := startTestServer(t, s)
+
+	var tests = []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantBody   string
+	}{
+		{"health", "/_health", 200, "ok\n"},
+		{"metrics", "/_metrics", 200, "\ntritonhttp_conns_accepted "},
+		{"no endpoint", "/_admin/", 404, ""},
+		{"file under prefix", "/_admin/secret.txt", 404, ""},
+		{"escaped", "/%5Fadmin/secret.txt", 404, ""},
+		{"escaped dot segments", "/a/%2e%2e/_admin/secret.txt", 404, ""},
+		{"escaped slashes", "/a/%2E%2E%2F_admin%2Fsecret.txt", 404, ""},
+		{"dot segment", "/_admin/./secret.txt", 404, ""},
+		{"rewritten", "/x/secret.txt", 404, ""},
+		{"aliased", "/y", 404, ""},
+		{"outside prefix", "/_adminx.txt", 200, "public"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := exchangeRaw(t, addr, "GET "+tt.url+" HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 1)[0]
+			body, _ := io.ReadAll(res.BodyReader)
+			if res.StatusCode != tt.wantStatus || !strings.Contains(string(body), tt.wantBody) {
+				t.Fatalf("got %v %q, want %v %q", res.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	s := &Server{}
+	req := &Request{Method: "GET", URL: "/_health", Proto: "HTTP/1.1", Header: map[string]string{}, Host: "test"}
+	if res := s.HealthHandler().ServeRequest(req); res.StatusCode != 200 {
+		t.Fatalf("got status %v, want 200", res.StatusCode)
+	}
+	s.inShutdown.Store(true)
+	if res := s.HealthHandler().ServeRequest(req); res.StatusCode != 503 {
+		t.Fatalf("shutting down: got status %v, want 503", res.StatusCode)
+	}
+}
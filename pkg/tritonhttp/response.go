@@ -2,16 +2,30 @@ package tritonhttp
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
+	"net/http/httputil"
 	"os"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Response struct {
 	StatusCode int    // e.g. 200
 	Proto      string // e.g. "HTTP/1.1"
 
+	// Reason is the reason phrase of the status line, e.g. "OK";
+	// "" means the standard one of StatusCode, see StatusText.
+	// ReadResponse sets it to the one received.
+	Reason string
+
 	// Header stores all headers to write to the response.
 	// Header keys are case-incensitive, and should be stored
 	// in the canonical format in this map.
@@ -24,93 +38,538 @@ type Response struct {
 	// FilePath is the local path to the file to serve.
 	// It could be "", which means there is no file to serve.
 	FilePath string
+
+	// Body is a body generated in memory, e.g. an error page or an API
+	// response. Write sets the Content-Length header from it unless
+	// set.
+	Body []byte
+
+	// BodyReader is the body of a response read by ReadResponse,
+	// e.g. through a Client. Read it, then Close the response.
+	// Write streams it as the body, e.g. to relay a proxied response,
+	// setting the Content-Length header, unless set, if it has a Len
+	// method like *strings.Reader and *bytes.Reader do.
+	//
+	// Without a Content-Length header, as for a proxied stream, each
+	// part of BodyReader is sent as soon as it is read.
+	BodyReader io.Reader
+
+	// BodyFunc, if set, writes the body, e.g. progress or server-sent
+	// events, as Stream does.
+	//
+	// At most one of FilePath, Body, BodyReader and BodyFunc may be
+	// set.
+	BodyFunc func(w io.Writer) error
+
+	// Uncompressed reports that a Client transparently decompressed
+	// BodyReader. The Content-Encoding and Content-Length headers are
+	// left as received, describing the compressed body.
+	Uncompressed bool
+
+	// file is FilePath opened by the Server, written by WriteBody
+	// in place of opening FilePath again.
+	file fs.File
+
+	// offset is where in FilePath the body starts, that of the range
+	// served, and parts the body of several ranges, if served.
+	offset int64
+	parts  *byteRanges
+
+	// writer is the WriterFunc writing the response as Write runs it.
+	writer WriterFunc
+
+	// head reports that res answers a HEAD request: its headers are
+	// written, Content-Length included, but not its body.
+	head bool
+
+	// upgraded is the connection of a proxied 101 Switching Protocols
+	// response, spliced with the client's once it is written.
+	upgraded net.Conn
+
+	// pooled reports that res came from getResponse, to be put back
+	// once written.
+	pooled bool
 }
 
-// Write writes the res to the w.
-func (res *Response) Write(w io.Writer) error {
-	if err := res.WriteStatusLine(w); err != nil {
-		return err
+// NewResponse returns a response with status and no body, to be given
+// one with Text or JSON, or turned into a redirect with Redirect.
+func NewResponse(status int) *Response {
+	return &Response{
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		Header:     map[string]string{"Date": FormatTime(time.Now())},
 	}
-	if err := res.WriteSortedHeaders(w); err != nil {
+}
+
+// Redirect makes res a redirect to location with code, one of 301,
+// 302, 307 and 308, and no body. The bytes location may not hold as
+// is, e.g. those of UTF-8 names, are percent-encoded.
+func (res *Response) Redirect(location string, code int) {
+	res.setStatus(code)
+	res.setHeader("Location", escapeLocation(location))
+	res.setHeader("Content-Length", "0")
+}
+
+// Text makes res a response with status and the plain text body.
+func (res *Response) Text(status int, body string) {
+	res.setBody(status, "text/plain; charset=utf-8", []byte(body))
+}
+
+// JSON makes res a response with status and the JSON encoding of v as
+// its body, or, if v cannot be encoded, a 500 Internal Server Error,
+// and returns the encoding error.
+func (res *Response) JSON(status int, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		res.Text(statusInternalServerError, StatusText(statusInternalServerError)+"\n")
 		return err
 	}
-	if err := res.WriteBody(w); err != nil {
-		return err
+	res.setBody(status, "application/json", append(body, '\n'))
+	return nil
+}
+
+// Flusher is implemented by the io.Writer a BodyFunc writes to: Flush
+// sends what was written so far to the client at once, instead of
+// when enough is buffered or the body ends.
+type Flusher interface {
+	Flush() error
+}
+
+// Stream makes res a response with status and a body of contentType
+// that write writes, calling the Flush method of w, a Flusher, to push
+// each part to the client as soon as it is ready, e.g. an event of a
+// text/event-stream. As the length of the body is unknown, the
+// connection is closed once it ends.
+func (res *Response) Stream(status int, contentType string, write func(w io.Writer) error) {
+	res.setStatus(status)
+	_ = res.closeFile()
+	res.FilePath, res.Body, res.BodyReader, res.BodyFunc = "", nil, nil, write
+	res.setHeader("Content-Type", contentType)
+	res.setHeader("Connection", "close")
+	delete(res.Header, "Content-Length")
+}
+
+// setBody sets the status of res, and its body, of contentType, in
+// place of any other.
+func (res *Response) setBody(status int, contentType string, body []byte) {
+	res.setStatus(status)
+	_ = res.closeFile()
+	res.FilePath, res.BodyReader, res.BodyFunc, res.Body = "", nil, nil, body
+	res.setHeader("Content-Type", contentType)
+	res.setHeader("Content-Length", strconv.Itoa(len(body)))
+}
+
+// setStatus sets the status of res, and its protocol if unset.
+func (res *Response) setStatus(status int) {
+	res.StatusCode = status
+	if res.Proto == "" {
+		res.Proto = "HTTP/1.1"
+	}
+}
+
+// setHeader sets the header key of res to value.
+func (res *Response) setHeader(key, value string) {
+	if res.Header == nil {
+		res.Header = make(map[string]string)
+	}
+	res.Header[key] = value
+}
+
+// Close closes the body of a response read by ReadResponse,
+// releasing the connection it was read from. For a response prepared
+// by Server.HandleGoodRequest and not written, it closes the file to
+// serve.
+func (res *Response) Close() error {
+	if res.file != nil {
+		return res.closeFile()
+	}
+	if c, ok := res.BodyReader.(io.Closer); ok {
+		return c.Close()
 	}
 	return nil
 }
 
-// WriteStatusLine writes the status line of res to w, including the ending "\r\n".
-// For example, it could write "HTTP/1.1 200 OK\r\n".
-func (res *Response) WriteStatusLine(w io.Writer) error {
-	bw := bufio.NewWriter(w)
-	// s := &Server{}
-	// desc := s.statusText[res.StatusCode]
-	str := fmt.Sprintf("%v %v %v\r\n", res.Proto, res.StatusCode, statusText[res.StatusCode])
-	_, err := w.Write([]byte(str))
+// ReadResponse reads a response to req from br. Its body is available
+// from BodyReader, framed by Content-Length, decoded if chunked, or,
+// lacking either, lasting until the end of the connection.
+func ReadResponse(br *bufio.Reader, req *Request) (*Response, error) {
+	line, err := readLineSlice(br, DefaultLimits().MaxRequestLineBytes)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	proto, rest, _ := bytes.Cut(line, []byte(" "))
+	if len(rest) == 0 || !bytes.HasPrefix(proto, []byte("HTTP/")) {
+		return nil, fmt.Errorf("malformed status line %q", line)
+	}
+	codeBytes, reason, hasReason := bytes.Cut(rest, []byte(" "))
+	code, ok := parseStatusCode(codeBytes)
+	if !ok {
+		return nil, fmt.Errorf("malformed status code in %q", line)
+	}
+	res := &Response{StatusCode: code, Proto: "HTTP/1.1", Request: req}
+	if string(proto) != res.Proto {
+		res.Proto = string(proto)
+	}
+	if hasReason {
+		// The usual reasons are not copied
+		if text := statusText[code]; string(reason) == text {
+			res.Reason = text
+		} else {
+			res.Reason = string(reason)
+		}
 	}
 
-	if err := bw.Flush(); err != nil {
-		return err
+	// Read headers in a fixed array, to make the map in one go
+	var spansArr [maxInlineHeaders]headerSpan
+	values := getScratch()
+	defer putScratch(values)
+	spans, err := readHeaderSpans(br, DefaultLimits(), spansArr[:0], values)
+	if err != nil {
+		return nil, err
+	}
+	res.Header = make(map[string]string, len(spans))
+	all := string(*values)
+	for _, span := range spans {
+		res.Header[span.key] = all[span.start:span.end]
+	}
+
+	res.BodyReader, err = res.bodyReader(br)
+	if err != nil {
+		return nil, err
 	}
+	return res, nil
+}
+
+// parseStatusCode parses b, a status code of three digits.
+func parseStatusCode(b []byte) (int, bool) {
+	if len(b) != 3 {
+		return 0, false
+	}
+	code := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		code = code*10 + int(c-'0')
+	}
+	return code, true
+}
+
+// bodyReader returns the reader of the body following the headers of
+// res in br.
+func (res *Response) bodyReader(br *bufio.Reader) (io.Reader, error) {
+	if (res.Request != nil && res.Request.Method == "HEAD") ||
+		res.StatusCode/100 == 1 || res.StatusCode == 204 || res.StatusCode == 304 {
+		return io.LimitReader(br, 0), nil
+	}
+	if strings.EqualFold(res.Header["Transfer-Encoding"], "chunked") {
+		return httputil.NewChunkedReader(br), nil
+	}
+	if cl, ok := res.Header["Content-Length"]; ok {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid Content-Length %q", cl)
+		}
+		return io.LimitReader(br, n), nil
+	}
+	return br, nil
+}
+
+// errBodies is the error of a Response with several bodies.
+var errBodies = errors.New("response has more than one of FilePath, Body, BodyReader and BodyFunc")
+
+// Write writes the res to the w.
+func (res *Response) Write(w io.Writer) error {
+	_, err := res.WriteTo(w)
 	return err
 }
 
+// maxCopiedBody is the largest Body copied after the headers to go
+// out in the same Write; larger ones are written from where they are,
+// along with the headers if w can write several buffers at once.
+const maxCopiedBody = 4 << 10
+
+// WriteTo writes res to w, as Write does, and returns the number of
+// bytes written. It copies as little as it can: the status line and
+// headers go out in one Write, along with a small Body, a larger one
+// as is, in a single writev on TCP connections, and a file or
+// BodyReader straight to w, so that a w that is an io.ReaderFrom, like
+// a TCP connection, can send it without going through a buffer. A body
+// that is not framed by Content-Length is written as it is read. If w
+// is a Flusher, it is flushed once the response is written.
+func (res *Response) WriteTo(w io.Writer) (n int64, err error) {
+	defer res.Close()
+	if res.writer != nil {
+		cw := &countingWriter{w: w}
+		err := res.serveWriter(cw)
+		return cw.n, err
+	}
+	if err := res.setContentLength(); err != nil {
+		return 0, err
+	}
+	head := getScratch()
+	*head = res.appendHeaders(res.appendStatusLine(*head))
+	body, inline := res.inlineBody()
+	if res.head {
+		body, inline = nil, true
+	}
+	if len(body) > maxCopiedBody {
+		n, err = writeBuffers(w, net.Buffers{*head, body})
+	} else {
+		*head = append(*head, body...)
+		var m int
+		m, err = w.Write(*head)
+		n = int64(m)
+	}
+	putScratch(head)
+	if err != nil {
+		return n, err
+	}
+	if !inline {
+		written, err := res.writeBody(w)
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+	if f, ok := w.(Flusher); ok {
+		err = f.Flush()
+	}
+	return n, err
+}
+
+// inlineBody returns the Body of res and true if it is written as is,
+// in full, along with the headers, or if res has no body at all.
+func (res *Response) inlineBody() ([]byte, bool) {
+	if res.FilePath != "" || res.BodyReader != nil || res.BodyFunc != nil {
+		return nil, false
+	}
+	if cl, ok := res.Header["Content-Length"]; ok && res.Body != nil {
+		if n, err := strconv.Atoi(cl); err != nil || n != len(res.Body) {
+			return nil, false
+		}
+	}
+	return res.Body, true
+}
+
+// buffersWriter is a writer of several buffers in one call, e.g. a
+// connection wrapping one net.Buffers writes with writev.
+type buffersWriter interface {
+	writeBuffers(v net.Buffers) (int64, error)
+}
+
+// writeBuffers writes v to w in one call if w can, or one Write per
+// buffer otherwise.
+func writeBuffers(w io.Writer, v net.Buffers) (int64, error) {
+	if bw, ok := w.(buffersWriter); ok {
+		return bw.writeBuffers(v)
+	}
+	return v.WriteTo(w)
+}
+
+// WriteStatusLine writes the status line of res to w, including the ending "\r\n".
+// For example, it could write "HTTP/1.1 200 OK\r\n".
+func (res *Response) WriteStatusLine(w io.Writer) error {
+	_, err := w.Write(res.appendStatusLine(nil))
+	return err
+}
+
+// appendStatusLine appends the status line of res to b.
+func (res *Response) appendStatusLine(b []byte) []byte {
+	reason := res.Reason
+	if reason == "" {
+		reason = StatusText(res.StatusCode)
+	}
+	b = append(b, res.Proto...)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(res.StatusCode), 10)
+	b = append(b, ' ')
+	b = append(b, reason...)
+	return append(b, "\r\n"...)
+}
+
 // WriteSortedHeaders writes the headers of res to w, including the ending "\r\n".
 // For example, it could write "Connection: close\r\nDate: foobar\r\n\r\n".
 // For HTTP, there is no need to write headers in any particular order.
 // TritonHTTP requires to write in sorted order for the ease of testing.
 func (res *Response) WriteSortedHeaders(w io.Writer) error {
-	// sort headers
-	header_keys := make([]string, 0, len(res.Header))
+	_, err := w.Write(res.appendHeaders(nil))
+	return err
+}
+
+// appendHeaders appends the headers of res, sorted, and the blank line
+// ending them to b.
+func (res *Response) appendHeaders(b []byte) []byte {
+	// sort headers, in a fixed array for the usual few
+	var keysArr [maxInlineHeaders]string
+	header_keys := keysArr[:0]
 	for k := range res.Header {
 		header_keys = append(header_keys, k)
 	}
 	sort.Strings(header_keys)
 
-	bw := bufio.NewWriter(w)
 	for _, key := range header_keys {
-		value := res.Header[key]
-		str := fmt.Sprintf("%v: %v\r\n", key, value)
-		_, err := bw.Write([]byte(str))
-		if err != nil {
-			return err
+		b = append(b, key...)
+		b = append(b, ": "...)
+		b = append(b, res.Header[key]...)
+		b = append(b, "\r\n"...)
+	}
+	return append(b, "\r\n"...)
+}
+
+// setContentLength sets the Content-Length header of res, unless set or
+// chunked, from its Body or the Len of its BodyReader, once checked
+// that it has a single body.
+func (res *Response) setContentLength() error {
+	bodies := 0
+	for _, set := range []bool{res.FilePath != "", res.Body != nil, res.BodyReader != nil, res.BodyFunc != nil} {
+		if set {
+			bodies++
 		}
 	}
-	_, err := bw.Write([]byte("\r\n"))
-	if err != nil {
-		return err
+	if bodies > 1 {
+		return errBodies
 	}
-	if err := bw.Flush(); err != nil {
-		return err
+	if _, ok := res.Header["Content-Length"]; ok || res.Header["Transfer-Encoding"] != "" {
+		return nil
+	}
+	n := -1
+	if res.Body != nil {
+		n = len(res.Body)
+	} else if l, ok := res.BodyReader.(interface{ Len() int }); ok {
+		n = l.Len()
+	}
+	if n >= 0 {
+		res.setHeader("Content-Length", strconv.Itoa(n))
 	}
 	return nil
 }
 
-// WriteBody writes res' file content as them  response body to w.
-// It doesn't write anything if there is no file to serve.
-func (res *Response) WriteBody(w io.Writer) error {
+// copyFlushing copies src to w until EOF, writing what each read
+// returns as it comes, and flushing w after it if w is a Flusher, so
+// that a stream is relayed as it comes.
+func copyFlushing(w io.Writer, src io.Reader) (n int64, err error) {
+	f, _ := w.(Flusher)
+	bufp := getCopyBuffer(-1)
+	defer putCopyBuffer(bufp)
+	buf := *bufp
+	for {
+		m, rerr := src.Read(buf)
+		if m > 0 {
+			written, err := w.Write(buf[:m])
+			n += int64(written)
+			if err != nil {
+				return n, err
+			}
+			if f != nil {
+				if err := f.Flush(); err != nil {
+					return n, err
+				}
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
 
-	if res.FilePath == "" {
+// closeFile closes the file opened for res, if any.
+func (res *Response) closeFile() error {
+	if res.file == nil {
 		return nil
 	}
+	f := res.file
+	res.file = nil
+	return f.Close()
+}
+
+// WriteBody writes res' file content as them  response body to w, or
+// the multipart/byteranges body of the ranges of it served,
+// or its Body, BodyReader or BodyFunc if there is no file, closing what
+// it read from. It doesn't write anything if there is none. At most
+// Content-Length bytes are written, and fewer are an error.
+func (res *Response) WriteBody(w io.Writer) error {
+	_, err := res.writeBody(w)
+	return err
+}
 
-	bw := bufio.NewWriter(w)
+// writeBody is WriteBody returning the number of bytes written.
+func (res *Response) writeBody(w io.Writer) (int64, error) {
+	cl, framed := res.Header["Content-Length"]
+	var n int64
+	if framed {
+		var err error
+		if n, err = strconv.ParseInt(cl, 10, 64); err != nil {
+			return 0, fmt.Errorf("invalid Content-Length %q", cl)
+		}
+	}
 
-	data, err := os.ReadFile(res.FilePath)
-	if err != nil {
-		return err
+	var body io.Reader
+	if res.FilePath != "" {
+		f := res.file
+		res.file = nil
+		if f == nil {
+			var err error
+			if f, err = os.Open(res.FilePath); err != nil {
+				return 0, err
+			}
+		}
+		defer f.Close()
+		if res.parts != nil {
+			rs, ok := f.(io.ReadSeeker)
+			if !ok {
+				return 0, fmt.Errorf("cannot seek in %v", res.FilePath)
+			}
+			return res.parts.writeTo(w, rs)
+		}
+		if res.offset > 0 {
+			seeker, ok := f.(io.Seeker)
+			if !ok {
+				return 0, fmt.Errorf("cannot seek to byte %v of %v", res.offset, res.FilePath)
+			}
+			if _, err := seeker.Seek(res.offset, io.SeekStart); err != nil {
+				return 0, err
+			}
+		}
+		body = f
+	} else if res.Body != nil {
+		if !framed || n == int64(len(res.Body)) {
+			m, err := w.Write(res.Body)
+			return int64(m), err
+		}
+		body = bytes.NewReader(res.Body)
+	} else if res.BodyFunc != nil {
+		cw := &countingWriter{w: w}
+		bw := bufio.NewWriter(cw)
+		if err := res.BodyFunc(bw); err != nil {
+			return cw.n, err
+		}
+		err := bw.Flush()
+		return cw.n, err
+	} else if res.BodyReader != nil {
+		defer res.Close()
+		body = res.BodyReader
+	} else {
+		return 0, nil
 	}
 
-	_, err = bw.Write(data)
-	if err != nil {
-		return err
+	if framed {
+		return copyN(w, body, n)
 	}
+	return copyFlushing(w, body)
+}
 
-	if err := bw.Flush(); err != nil {
-		return err
+// copyN is io.CopyN with a buffer from the pool, for when neither w is
+// an io.ReaderFrom nor src an io.WriterTo.
+func copyN(w io.Writer, src io.Reader, n int64) (int64, error) {
+	buf := getCopyBuffer(n)
+	defer putCopyBuffer(buf)
+	written, err := io.CopyBuffer(w, io.LimitReader(src, n), *buf)
+	if written < n && err == nil {
+		err = io.EOF
 	}
-	return nil
+	return written, err
 }
@@ -1,9 +1,16 @@
 package tritonhttp
 
 import (
+	"bufio"
 	"bytes"
+	"io"
+	"net"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestWriteStatusLine(t *testing.T) {
@@ -20,6 +27,26 @@ func TestWriteStatusLine(t *testing.T) {
 			},
 			"HTTP/1.1 200 OK\r\n",
 		},
+		{
+			"CustomReason",
+			&Response{StatusCode: 200, Proto: "HTTP/1.1", Reason: "Fine"},
+			"HTTP/1.1 200 Fine\r\n",
+		},
+		{
+			"NotInTable",
+			&Response{StatusCode: 418, Proto: "HTTP/1.1"},
+			"HTTP/1.1 418 I'm a teapot\r\n",
+		},
+		{
+			"Unregistered",
+			&Response{StatusCode: 499, Proto: "HTTP/1.1"},
+			"HTTP/1.1 499 Client Error\r\n",
+		},
+		{
+			"OutOfClasses",
+			&Response{StatusCode: 999, Proto: "HTTP/1.1"},
+			"HTTP/1.1 999 Unknown Status\r\n",
+		},
 	}
 
 	for _, tt := range tests {
@@ -36,6 +63,79 @@ func TestWriteStatusLine(t *testing.T) {
 	}
 }
 
+func TestReadResponseReason(t *testing.T) {
+	raw := "HTTP/1.1 404 Nothing Here\r\nContent-Length: 0\r\n\r\n"
+	res, err := ReadResponse(bufio.NewReader(strings.NewReader(raw)), &Request{Method: "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	if err := res.WriteStatusLine(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	if got := buffer.String(); got != "HTTP/1.1 404 Nothing Here\r\n" {
+		t.Fatalf("relayed status line got: %q", got)
+	}
+}
+
+func TestReadResponseHeaders(t *testing.T) {
+	var tests = []struct {
+		name  string
+		count int
+	}{
+		{"Few", 3},
+		{"Inline", maxInlineHeaders},
+		{"Many", 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "HTTP/1.1 200 OK\r\n"
+			want := map[string]string{}
+			for i := 0; i < tt.count; i++ {
+				key := "X-Header-" + strconv.Itoa(i)
+				raw += strings.ToLower(key) + ": value " + strconv.Itoa(i) + "\r\n"
+				want[key] = "value " + strconv.Itoa(i)
+			}
+			res, err := ReadResponse(bufio.NewReader(strings.NewReader(raw+"\r\n")), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(res.Header, want) {
+				t.Fatalf("got headers %v, want %v", res.Header, want)
+			}
+		})
+	}
+}
+
+func TestHeaderAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not counted under the race detector")
+	}
+	res := &Response{StatusCode: 200, Proto: "HTTP/1.1", Header: map[string]string{
+		"Content-Type": "text/plain", "Content-Length": "5", "Date": "now", "Last-Modified": "then",
+	}}
+	b := make([]byte, 0, 1<<10)
+	if allocs := testing.AllocsPerRun(100, func() { b = res.appendHeaders(b[:0]) }); allocs != 0 {
+		t.Errorf("got %v allocations writing headers, want 0", allocs)
+	}
+
+	raw := []byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 5\r\nDate: now\r\nServer: x\r\n\r\n")
+	r := bytes.NewReader(raw)
+	br := bufio.NewReader(r)
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset(raw)
+		br.Reset(r)
+		if _, err := ReadResponse(br, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// The response, its header map, one string for all the header
+	// values and the body reader
+	if allocs > 6 {
+		t.Errorf("got %v allocations reading a response, want at most 6", allocs)
+	}
+}
+
 func TestWriteSortedHeaders(t *testing.T) {
 	var tests = []struct {
 		name string
@@ -58,6 +158,20 @@ func TestWriteSortedHeaders(t *testing.T) {
 		},
 	}
 
+	// More headers than are sorted without allocating
+	many := &Response{Header: map[string]string{}}
+	want := ""
+	for i := 10; i < 30; i++ {
+		key := "X-" + strconv.Itoa(i)
+		many.Header[key] = "v"
+		want += key + ": v\r\n"
+	}
+	tests = append(tests, struct {
+		name string
+		res  *Response
+		want string
+	}{"Many", many, want + "\r\n"})
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var buffer bytes.Buffer
@@ -123,3 +237,295 @@ func TestWriteBody(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteGeneratedBody(t *testing.T) {
+	var tests = []struct {
+		name string
+		res  *Response
+		want string
+	}{
+		{
+			"Body",
+			&Response{StatusCode: 200, Proto: "HTTP/1.1", Header: map[string]string{}, Body: []byte("hello")},
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+		},
+		{
+			"EmptyBody",
+			&Response{StatusCode: 200, Proto: "HTTP/1.1", Body: []byte{}},
+			"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+		},
+		{
+			"SizedReader",
+			&Response{StatusCode: 200, Proto: "HTTP/1.1", Header: map[string]string{}, BodyReader: strings.NewReader("hello")},
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+		},
+		{
+			"ContentLengthSet",
+			&Response{StatusCode: 200, Proto: "HTTP/1.1", Header: map[string]string{"Content-Length": "4"}, Body: []byte("hello")},
+			"HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nhell",
+		},
+		{
+			"UnsizedReader",
+			&Response{StatusCode: 200, Proto: "HTTP/1.1", Header: map[string]string{}, BodyReader: io.MultiReader(strings.NewReader("hello"))},
+			"HTTP/1.1 200 OK\r\n\r\nhello",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			if err := tt.res.Write(&buffer); err != nil {
+				t.Fatal(err)
+			}
+			if got := buffer.String(); got != tt.want {
+				t.Fatalf("got: %q, want: %q", got, tt.want)
+			}
+		})
+	}
+
+	res := &Response{StatusCode: 200, Proto: "HTTP/1.1", FilePath: "testdata/index.html", Body: []byte("hello")}
+	var buffer bytes.Buffer
+	if err := res.Write(&buffer); err != errBodies || buffer.Len() != 0 {
+		t.Fatalf("two bodies: got %v, %q written, want %v", err, buffer.String(), errBodies)
+	}
+}
+
+// writeRecorder records the writes and flushes made to it.
+type writeRecorder struct {
+	writes  []string
+	flushes int
+}
+
+func (wr *writeRecorder) Write(p []byte) (int, error) {
+	wr.writes = append(wr.writes, string(p))
+	return len(p), nil
+}
+
+func (wr *writeRecorder) Flush() error {
+	wr.flushes++
+	return nil
+}
+
+// readerFromRecorder is a writeRecorder also reading from the readers
+// given to its ReadFrom, as a TCP connection would with sendfile.
+type readerFromRecorder struct {
+	writeRecorder
+	readFrom int
+}
+
+func (rf *readerFromRecorder) ReadFrom(r io.Reader) (int64, error) {
+	rf.readFrom++
+	b, err := io.ReadAll(r)
+	rf.writes = append(rf.writes, string(b))
+	return int64(len(b)), err
+}
+
+// buffersRecorder is a writeRecorder writing several buffers at once,
+// as a TCP connection would with writev.
+type buffersRecorder struct {
+	writeRecorder
+}
+
+func (br *buffersRecorder) writeBuffers(v net.Buffers) (int64, error) {
+	var b []byte
+	for _, p := range v {
+		b = append(b, p...)
+	}
+	br.writes = append(br.writes, string(b))
+	return int64(len(b)), nil
+}
+
+func TestWriteToBuffers(t *testing.T) {
+	body := strings.Repeat("a", maxCopiedBody+1)
+	for _, res := range []*Response{
+		{StatusCode: 200, Proto: "HTTP/1.1", Body: []byte("hello")},
+		{StatusCode: 200, Proto: "HTTP/1.1", Body: []byte(body)},
+	} {
+		var w buffersRecorder
+		n, err := res.WriteTo(&w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(res.Body)) + "\r\n\r\n" + string(res.Body)
+		if len(w.writes) != 1 || w.writes[0] != want || n != int64(len(want)) {
+			t.Errorf("body of %v bytes: got %v writes of %v bytes, want one of %v", len(res.Body), len(w.writes), n, len(want))
+		}
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	file, err := os.ReadFile("testdata/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name    string
+		res     *Response
+		writes  []string
+		flushes int
+	}{
+		{
+			"Body",
+			&Response{StatusCode: 200, Proto: "HTTP/1.1", Body: []byte("hello")},
+			[]string{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"},
+			1,
+		},
+		{
+			"LargeBody",
+			&Response{StatusCode: 200, Proto: "HTTP/1.1", Body: []byte(strings.Repeat("a", maxCopiedBody+1))},
+			[]string{"HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(maxCopiedBody+1) + "\r\n\r\n", strings.Repeat("a", maxCopiedBody+1)},
+			1,
+		},
+		{
+			"PartialBody",
+			&Response{StatusCode: 200, Proto: "HTTP/1.1", Header: map[string]string{"Content-Length": "4"}, Body: []byte("hello")},
+			[]string{"HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\n", "hell"},
+			1,
+		},
+		{
+			"File",
+			&Response{StatusCode: 200, Proto: "HTTP/1.1", Header: map[string]string{"Content-Length": strconv.Itoa(len(file))}, FilePath: "testdata/index.html"},
+			[]string{"HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(file)) + "\r\n\r\n", string(file)},
+			1,
+		},
+		{
+			"UnframedReader",
+			&Response{StatusCode: 200, Proto: "HTTP/1.1", Header: map[string]string{"Connection": "close"}, BodyReader: io.MultiReader(strings.NewReader("he"), strings.NewReader("llo"))},
+			[]string{"HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n", "he", "llo"},
+			3,
+		},
+		{
+			"NoBody",
+			&Response{StatusCode: 404, Proto: "HTTP/1.1", Header: map[string]string{"Connection": "close"}},
+			[]string{"HTTP/1.1 404 Not Found\r\nConnection: close\r\n\r\n"},
+			1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w readerFromRecorder
+			n, err := tt.res.WriteTo(&w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(w.writes, tt.writes) {
+				t.Fatalf("got writes %q, want %q", w.writes, tt.writes)
+			}
+			if want := len(strings.Join(tt.writes, "")); n != int64(want) {
+				t.Errorf("got %v bytes written, want %v", n, want)
+			}
+			if w.flushes != tt.flushes {
+				t.Errorf("got %v flushes, want %v", w.flushes, tt.flushes)
+			}
+		})
+	}
+
+	// A framed file goes to the ReadFrom of the writer
+	res := &Response{StatusCode: 200, Proto: "HTTP/1.1", Header: map[string]string{"Content-Length": strconv.Itoa(len(file))}, FilePath: "testdata/index.html"}
+	var w readerFromRecorder
+	if _, err := res.WriteTo(&w); err != nil || w.readFrom != 1 {
+		t.Fatalf("got %v ReadFrom calls, %v, want 1", w.readFrom, err)
+	}
+}
+
+func TestResponseHelpers(t *testing.T) {
+	var tests = []struct {
+		name string
+		res  func() *Response
+		want string
+	}{
+		{
+			"Text",
+			func() *Response { res := NewResponse(200); res.Text(404, "gone\n"); return res },
+			"HTTP/1.1 404 Not Found\r\nContent-Length: 5\r\nContent-Type: text/plain; charset=utf-8\r\n\r\ngone\n",
+		},
+		{
+			"JSON",
+			func() *Response {
+				res := NewResponse(200)
+				if err := res.JSON(200, map[string]int{"a": 1}); err != nil {
+					t.Fatal(err)
+				}
+				return res
+			},
+			"HTTP/1.1 200 OK\r\nContent-Length: 8\r\nContent-Type: application/json\r\n\r\n{\"a\":1}\n",
+		},
+		{
+			"JSONError",
+			func() *Response {
+				res := NewResponse(200)
+				if err := res.JSON(200, func() {}); err == nil {
+					t.Fatal("encoded a func")
+				}
+				return res
+			},
+			"HTTP/1.1 500 Internal Server Error\r\nContent-Length: 22\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nInternal Server Error\n",
+		},
+		{
+			"Redirect",
+			func() *Response { res := NewResponse(200); res.Redirect("/café/", 308); return res },
+			"HTTP/1.1 308 Permanent Redirect\r\nContent-Length: 0\r\nLocation: /caf%C3%A9/\r\n\r\n",
+		},
+		{
+			"ZeroResponse",
+			func() *Response { res := &Response{}; res.Text(200, "ok"); return res },
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nok",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := tt.res()
+			delete(res.Header, "Date")
+			var buffer bytes.Buffer
+			if err := res.Write(&buffer); err != nil {
+				t.Fatal(err)
+			}
+			if got := buffer.String(); got != tt.want {
+				t.Fatalf("got: %q, want: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStream(t *testing.T) {
+	sent := make(chan struct{})
+	stream := HandlerFunc(func(req *Request) *Response {
+		res := NewResponse(200)
+		res.Stream(200, "text/event-stream", func(w io.Writer) error {
+			io.WriteString(w, "data: 1\n\n")
+			if err := w.(Flusher).Flush(); err != nil {
+				return err
+			}
+			// The client must get the first event before the second
+			<-sent
+			_, err := io.WriteString(w, "data: 2\n\n")
+			return err
+		})
+		return res
+	})
+	addr, _ := startTestServer(t, &Server{DocRoot: t.TempDir(), Routes: []Route{{Prefix: "/events", Handler: stream}}})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /events HTTP/1.1\r\nHost: test\r\n\r\n")
+	br := bufio.NewReader(conn)
+	res, err := ReadResponse(br, &Request{Method: "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Header["Connection"] != "close" || res.Header["Content-Type"] != "text/event-stream" {
+		t.Fatalf("got headers %v", res.Header)
+	}
+	first := make([]byte, len("data: 1\n\n"))
+	if _, err := io.ReadFull(res.BodyReader, first); err != nil || string(first) != "data: 1\n\n" {
+		t.Fatalf("first event got %q, %v", first, err)
+	}
+	close(sent)
+	rest, err := io.ReadAll(res.BodyReader)
+	if err != nil || string(rest) != "data: 2\n\n" {
+		t.Fatalf("rest got %q, %v", rest, err)
+	}
+}
@@ -0,0 +1,64 @@
+package tritonhttp
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy tells a ReverseProxy when to send a request again, to
+// the next backend. Only the requests with an idempotent method and no
+// body are retried.
+type RetryPolicy struct {
+	// Attempts caps the tries of a request, the first included; 0 and
+	// 1 mean no retries.
+	Attempts int
+
+	// Statuses are the upstream status codes retried, e.g. 502, 503
+	// and 504, besides the failures to get a response at all.
+	Statuses []int
+
+	// TryTimeout bounds each try up to the response headers; 0 means
+	// no bound but those of the Transport.
+	TryTimeout time.Duration
+
+	// Backoff is the wait before the first retry, doubled before each
+	// next one up to MaxBackoff, if set. A random jitter of up to as
+	// much again is added, so that the retries of many clients spread.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// retries reports whether responses with status are retried.
+func (rp RetryPolicy) retries(status int) bool {
+	for _, s := range rp.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait after the failure of try, counted from 0.
+func (rp RetryPolicy) backoff(try int) time.Duration {
+	if rp.Backoff <= 0 {
+		return 0
+	}
+	d := rp.Backoff
+	for i := 0; i < try && (rp.MaxBackoff <= 0 || d < rp.MaxBackoff); i++ {
+		d *= 2
+	}
+	if rp.MaxBackoff > 0 && d > rp.MaxBackoff {
+		d = rp.MaxBackoff
+	}
+	return d + time.Duration(rand.Int63n(int64(d)+1))
+}
+
+// retryable reports whether req may be sent again: it is idempotent, as
+// RFC 7231 section 4.2.2 defines, and has no body, which was read.
+func retryable(req *Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return req.Body == nil
+	}
+	return false
+}
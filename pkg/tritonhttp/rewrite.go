@@ -0,0 +1,88 @@
+package tritonhttp
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Rewrite is an internal rewrite of request paths, applied before the
+// request is routed or served from the doc root: the client is not
+// told, and the access log keeps the path it asked for.
+//
+// A Rewrite matches either the paths under Prefix, as a Route does,
+// whose Prefix it replaces with Replacement, or the paths Pattern
+// matches, which Replacement replaces as a whole, "$1" and the like
+// expanded as in regexp.Regexp.Expand: Pattern "^/v1/(.*)$" and
+// Replacement "/api/$1" rewrite "/v1/users" to "/api/users". The
+// query is kept, after that of Replacement if it has one.
+//
+// The Rewrites of a Server are evaluated in order, each on the path
+// the previous ones left, until one marked Last matches.
+type Rewrite struct {
+	Prefix      string
+	Pattern     *regexp.Regexp
+	Replacement string
+	Last        bool
+}
+
+// apply returns urlPath rewritten by rw, and whether rw matches it.
+func (rw Rewrite) apply(urlPath string) (string, bool) {
+	if rw.Pattern != nil {
+		m := rw.Pattern.FindStringSubmatchIndex(urlPath)
+		if m == nil {
+			return urlPath, false
+		}
+		return string(rw.Pattern.ExpandString(nil, rw.Replacement, urlPath, m)), true
+	}
+	if !prefixMatches(rw.Prefix, urlPath) {
+		return urlPath, false
+	}
+	return rw.Replacement + urlPath[len(rw.Prefix):], true
+}
+
+// rewrite applies the Rewrites to req.URL, and reports false if the
+// result is not a path under the root, the request then not found.
+func (s *Server) rewrite(req *Request) bool {
+	if len(s.Rewrites) == 0 {
+		return true
+	}
+	urlPath, query, hasQuery := strings.Cut(req.URL, "?")
+	rewritten := false
+	for _, rw := range s.Rewrites {
+		var ok bool
+		if urlPath, ok = rw.apply(urlPath); !ok {
+			continue
+		}
+		rewritten = true
+		if rw.Last {
+			break
+		}
+	}
+	if !rewritten {
+		return true
+	}
+	// Replacement may bring a query of its own
+	urlPath, newQuery, hasNewQuery := strings.Cut(urlPath, "?")
+	switch {
+	case hasNewQuery && hasQuery && query != "":
+		query = newQuery + "&" + query
+	case hasNewQuery:
+		query, hasQuery = newQuery, true
+	}
+	target := urlPath
+	if hasQuery {
+		target += "?" + query
+	}
+	if !strings.HasPrefix(target, "/") {
+		s.errorLog().Warnf("Rewriting %v: %q is not a path", req.URL, target)
+		return false
+	}
+	target, ok := removeDotSegments(target)
+	if !ok {
+		s.errorLog().Warnf("Rewriting %v: the result climbs above the root", req.URL)
+		return false
+	}
+	s.requestLogger(req).Debugf("Rewrote %v to %v", req.URL, target)
+	req.URL = target
+	return true
+}
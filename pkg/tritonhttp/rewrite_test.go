@@ -0,0 +1,124 @@
+package tritonhttp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	var tests = []struct {
+		name     string
+		rewrites []Rewrite
+		url      string
+		wantURL  string
+		wantOK   bool
+	}{
+		{"no rewrites", nil, "/a?x=1", "/a?x=1", true},
+		{"prefix", []Rewrite{{Prefix: "/old", Replacement: "/new"}}, "/old/a?x=1", "/new/a?x=1", true},
+		{"prefix equal", []Rewrite{{Prefix: "/old", Replacement: "/new"}}, "/old", "/new", true},
+		{"prefix mismatch", []Rewrite{{Prefix: "/old", Replacement: "/new"}}, "/older", "/older", true},
+		{
+			"pattern",
+			[]Rewrite{{Pattern: regexp.MustCompile(`^/v1/(.*)$`), Replacement: "/api/$1"}},
+			"/v1/users/1?x=1",
+			"/api/users/1?x=1",
+			true,
+		},
+		{
+			"continue",
+			[]Rewrite{
+				{Prefix: "/a/", Replacement: "/b/"},
+				{Prefix: "/b/", Replacement: "/c/"},
+			},
+			"/a/x",
+			"/c/x",
+			true,
+		},
+		{
+			"last",
+			[]Rewrite{
+				{Prefix: "/a/", Replacement: "/b/", Last: true},
+				{Prefix: "/b/", Replacement: "/c/"},
+			},
+			"/a/x",
+			"/b/x",
+			true,
+		},
+		{
+			"last not matching",
+			[]Rewrite{
+				{Prefix: "/z/", Replacement: "/y/", Last: true},
+				{Prefix: "/a/", Replacement: "/c/"},
+			},
+			"/a/x",
+			"/c/x",
+			true,
+		},
+		{
+			"replacement query",
+			[]Rewrite{{Pattern: regexp.MustCompile(`^/u/(\w+)$`), Replacement: "/user.php?name=$1"}},
+			"/u/bob?tab=2",
+			"/user.php?name=bob&tab=2",
+			true,
+		},
+		{
+			"dot segments",
+			[]Rewrite{{Pattern: regexp.MustCompile(`^/go/(.*)$`), Replacement: "/docs/$1"}},
+			"/go/a/../b",
+			"/docs/b",
+			true,
+		},
+		{
+			"above root",
+			[]Rewrite{{Pattern: regexp.MustCompile(`^/go/(.*)$`), Replacement: "/$1"}},
+			"/go/../x",
+			"/go/../x",
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Rewrites: tt.rewrites, ErrorLog: NewLogger(nil, LevelError)}
+			req := &Request{Method: "GET", URL: tt.url, Proto: "HTTP/1.1", Header: map[string]string{}, Host: "test"}
+			if ok := s.rewrite(req); ok != tt.wantOK || req.URL != tt.wantURL {
+				t.Fatalf("got %q, %v, want %q, %v", req.URL, ok, tt.wantURL, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestServerRewrites(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "new.html"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	echo := HandlerFunc(func(req *Request) *Response {
+		res := &Response{}
+		res.HandleNotFound(req)
+		res.StatusCode = statusOK
+		res.Header["Content-Length"] = "0"
+		res.Header["X-Url"] = req.URL
+		return res
+	})
+	addr, _ := startTestServer(t, &Server{
+		DocRoot: root,
+		Routes:  []Route{{Prefix: "/api", Handler: echo}},
+		Rewrites: []Rewrite{
+			{Prefix: "/old.html", Replacement: "/new.html", Last: true},
+			{Pattern: regexp.MustCompile(`^/v1/(.*)$`), Replacement: "/api/$1"},
+		},
+	})
+
+	res := exchangeRaw(t, addr, "GET /old.html HTTP/1.1\r\nHost: test\r\n\r\n"+
+		"GET /v1/users?id=1 HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 2)
+	body, _ := io.ReadAll(res[0].BodyReader)
+	if res[0].StatusCode != 200 || string(body) != "new" {
+		t.Errorf("got %v %q, want the rewritten file", res[0].StatusCode, body)
+	}
+	if got := res[1].Header["X-Url"]; res[1].StatusCode != 200 || got != "/api/users?id=1" {
+		t.Errorf("got %v with URL %q, want it routed as /api/users?id=1", res[1].StatusCode, got)
+	}
+}
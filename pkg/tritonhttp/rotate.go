@@ -0,0 +1,195 @@
+package tritonhttp
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp embedded in rotated file names.
+const backupTimeFormat = "20060102T150405.000"
+
+// RotatingFile is an io.WriteCloser appending to the file Filename,
+// rotating it once it grows beyond MaxSize bytes or gets older than
+// MaxAge. Rotated files are renamed with a timestamp, e.g.
+// "access-20220101T000000.000.log", optionally gzip compressed,
+// and only the newest MaxBackups are kept.
+// Zero MaxSize, MaxAge or MaxBackups mean no limit.
+// It is safe for concurrent use.
+type RotatingFile struct {
+	Filename   string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	Compress   bool
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// Write appends p to the file, rotating it first if needed.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if (rf.MaxSize > 0 && rf.size+int64(len(p)) > rf.MaxSize && rf.size > 0) ||
+		(rf.MaxAge > 0 && time.Since(rf.opened) >= rf.MaxAge) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Rotate closes the current file, moves it to a backup
+// and starts a new file.
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.rotate()
+}
+
+// Close closes the current file. A later Write reopens it.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.close()
+}
+
+// Reopen closes the current file and opens Filename again, picking up
+// a new file if the old one was moved away by an external tool.
+func (rf *RotatingFile) Reopen() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if err := rf.close(); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+// open opens Filename for appending. The caller must hold rf.mu.
+func (rf *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(rf.Filename), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(rf.Filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	rf.f, rf.size, rf.opened = f, fi.Size(), time.Now()
+	return nil
+}
+
+// close closes the current file, if any. The caller must hold rf.mu.
+func (rf *RotatingFile) close() error {
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
+
+// rotate does the work of Rotate. The caller must hold rf.mu.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.close(); err != nil {
+		return err
+	}
+	t := time.Now()
+	backup := rf.backupName(t)
+	for fileExists(backup) || fileExists(backup+".gz") {
+		t = t.Add(time.Millisecond)
+		backup = rf.backupName(t)
+	}
+	if err := os.Rename(rf.Filename, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if rf.Compress {
+		if err := compressFile(backup); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := rf.removeOldBackups(); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+// backupName returns the name of the backup rotated at t.
+func (rf *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(rf.Filename)
+	base := strings.TrimSuffix(rf.Filename, ext)
+	return fmt.Sprintf("%v-%v%v", base, t.UTC().Format(backupTimeFormat), ext)
+}
+
+// removeOldBackups deletes all but the newest MaxBackups backups.
+func (rf *RotatingFile) removeOldBackups() error {
+	if rf.MaxBackups <= 0 {
+		return nil
+	}
+	ext := filepath.Ext(rf.Filename)
+	base := strings.TrimSuffix(rf.Filename, ext)
+	backups, err := filepath.Glob(base + "-*" + ext + "*")
+	if err != nil {
+		return err
+	}
+	// The timestamp format sorts lexically in time order
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	for i := rf.MaxBackups; i < len(backups); i++ {
+		if err := os.Remove(backups[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressFile gzips path into path+".gz" and removes path.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// fileExists reports whether anything exists at path.
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,78 @@
+package tritonhttp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	rf := &RotatingFile{
+		Filename:   filepath.Join(dir, "access.log"),
+		MaxSize:    10,
+		MaxBackups: 2,
+		Compress:   true,
+	}
+	defer rf.Close()
+
+	// Every write but the first overflows the file and rotates it
+	for _, line := range []string{"line one\n", "line two\n", "line three\n", "line four\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(rf.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "line four\n"; got != want {
+		t.Fatalf("current file got: %q, want: %q", got, want)
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, "access-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("got %v backups, want 2: %v", len(backups), backups)
+	}
+	for _, b := range backups {
+		if !strings.HasSuffix(b, ".log.gz") {
+			t.Fatalf("backup %q is not compressed", b)
+		}
+	}
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	rf := &RotatingFile{Filename: filepath.Join(dir, "access.log")}
+	defer rf.Close()
+	if _, err := rf.Write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moved away by an external tool such as logrotate
+	moved := filepath.Join(dir, "access.log.1")
+	if err := os.Rename(rf.Filename, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("after\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{moved: "before\n", rf.Filename: "after\n"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Fatalf("%v got: %q, want: %q", path, data, want)
+		}
+	}
+}
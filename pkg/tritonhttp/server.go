@@ -2,28 +2,137 @@ package tritonhttp
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	statusOK         = 200
-	statusBadRequest = 400
-	statusNotFound   = 404
+	statusContinue           = 100
+	statusSwitchingProtocols = 101
+	statusProcessing         = 102
+	statusEarlyHints         = 103
+
+	statusMovedPermanently  = 301
+	statusFound             = 302
+	statusNotModified       = 304
+	statusTemporaryRedirect = 307
+	statusPermanentRedirect = 308
+
+	statusOK              = 200
+	statusBadRequest      = 400
+	statusForbidden       = 403
+	statusNotFound        = 404
+	statusTooManyRequests = 429
+
+	statusCreated              = 201
+	statusNoContent            = 204
+	statusPartialContent       = 206
+	statusMultiStatus          = 207
+	statusUnauthorized         = 401
+	statusNotAcceptable        = 406
+	statusConflict             = 409
+	statusLengthRequired       = 411
+	statusPreconditionFailed   = 412
+	statusUnsupportedMediaType = 415
+	statusRangeNotSatisfiable  = 416
+
+	statusMethodNotAllowed            = 405
+	statusPayloadTooLarge             = 413
+	statusURITooLong                  = 414
+	statusRequestHeaderFieldsTooLarge = 431
+
+	statusProxyAuthRequired = 407
+
+	statusInternalServerError = 500
+	statusNotImplemented      = 501
+	statusBadGateway          = 502
+	statusServiceUnavailable  = 503
+	statusGatewayTimeout      = 504
+
+	statusHTTPVersionNotSupported = 505
 )
 
 var statusText = map[int]string{
-	statusOK:         "OK",
-	statusBadRequest: "Bad Request",
-	statusNotFound:   "Not Found",
+	statusContinue:           "Continue",
+	statusSwitchingProtocols: "Switching Protocols",
+	statusProcessing:         "Processing",
+	statusEarlyHints:         "Early Hints",
+
+	statusMovedPermanently:  "Moved Permanently",
+	statusFound:             "Found",
+	statusNotModified:       "Not Modified",
+	statusTemporaryRedirect: "Temporary Redirect",
+	statusPermanentRedirect: "Permanent Redirect",
+
+	statusOK:              "OK",
+	statusBadRequest:      "Bad Request",
+	statusForbidden:       "Forbidden",
+	statusNotFound:        "Not Found",
+	statusTooManyRequests: "Too Many Requests",
+
+	statusCreated:              "Created",
+	statusNoContent:            "No Content",
+	statusPartialContent:       "Partial Content",
+	statusMultiStatus:          "Multi-Status",
+	statusUnauthorized:         "Unauthorized",
+	statusNotAcceptable:        "Not Acceptable",
+	statusConflict:             "Conflict",
+	statusLengthRequired:       "Length Required",
+	statusPreconditionFailed:   "Precondition Failed",
+	statusUnsupportedMediaType: "Unsupported Media Type",
+	statusRangeNotSatisfiable:  "Range Not Satisfiable",
+
+	statusMethodNotAllowed:            "Method Not Allowed",
+	statusPayloadTooLarge:             "Payload Too Large",
+	statusURITooLong:                  "URI Too Long",
+	statusRequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+
+	statusProxyAuthRequired: "Proxy Authentication Required",
+
+	statusInternalServerError: "Internal Server Error",
+	statusNotImplemented:      "Not Implemented",
+	statusBadGateway:          "Bad Gateway",
+	statusServiceUnavailable:  "Service Unavailable",
+	statusGatewayTimeout:      "Gateway Timeout",
+
+	statusHTTPVersionNotSupported: "HTTP Version Not Supported",
+}
+
+// StatusText returns the reason phrase of code: the standard one, or,
+// for a code with none, that of its class, e.g. "Client Error" for 499.
+func StatusText(code int) string {
+	if text, ok := statusText[code]; ok {
+		return text
+	}
+	if text := http.StatusText(code); text != "" {
+		return text
+	}
+	switch code / 100 {
+	case 1:
+		return "Informational"
+	case 2:
+		return "Success"
+	case 3:
+		return "Redirection"
+	case 4:
+		return "Client Error"
+	case 5:
+		return "Server Error"
+	}
+	return "Unknown Status"
 }
 
 type Server struct {
@@ -32,84 +141,526 @@ type Server struct {
 	// during ListenAndServe().
 	Addr string // e.g. ":0"
 
+	// Listeners are more addresses to listen on, serving HTTPS if they
+	// have certificates. With Listeners set, an empty Addr is not
+	// listened on.
+	Listeners []Listener
+
 	// DocRoot specifies the path to the directory to serve static files from.
+	// It may also be a .zip, .tar.gz or .tgz archive of it, see
+	// OpenArchive, served as is unless FS is set.
 	DocRoot string
+
+	// VirtualHosts serve the requests for some hosts from doc roots
+	// of their own instead of DocRoot.
+	VirtualHosts []VirtualHost
+
+	// ArchiveGzip, if set, sends the files deflated in a zip DocRoot to
+	// the clients accepting gzip as they are compressed in it, instead of
+	// decompressing them.
+	ArchiveGzip bool
+
+	// FS, if set, is used for all file access instead of the operating
+	// system, e.g. MountFS over an in-memory file system in tests.
+	FS FileSystem
+
+	// Routes hands the requests they match to their Handler instead
+	// of serving them from DocRoot, e.g. to a ReverseProxy. The first
+	// Route matching a request, in order, wins.
+	Routes []Route
+
+	// Reserved reserves path prefixes, e.g. "/_health" and "/_metrics",
+	// to the Internal routes: the requests under them are answered by
+	// the first Internal route matching them, or 404 Not Found, before
+	// any redirect, rewrite, alias or other Route applies, and no
+	// rewrite or alias can hand them to a Route or DocRoot, so that no
+	// file or rule can shadow an internal endpoint, and no file under
+	// them is ever served.
+	Reserved []string
+	Internal []Route
+
+	// CanonicalHost, if set, redirects the requests to other hosts or
+	// schemes than the canonical ones to them.
+	CanonicalHost *CanonicalHost
+
+	// Aliases serve some paths as others, or with a given content.
+	Aliases []Alias
+
+	// AttachmentPrefixes lists the paths under which the files of the
+	// doc root are served as attachments, for browsers to save rather
+	// than display; AttachmentQuery also serves so any file asked for
+	// with a "download=1" query parameter.
+	AttachmentPrefixes []string
+	AttachmentQuery    bool
+
+	// Markdown, if set, renders the Markdown files of the doc root to
+	// HTML.
+	Markdown *Markdown
+
+	// Negotiate, if set, serves the variants of the files of the doc
+	// root, e.g. index.en.html and index.zh.html for index.html, the
+	// Accept and Accept-Language headers of requests prefer.
+	Negotiate bool
+
+	// SSI, if set, processes the server-side includes of the .shtml
+	// files of the doc root.
+	SSI *SSI
+
+	// GeoIP, if set, looks up where the clients of requests come from,
+	// and refuses those its Policies say.
+	GeoIP *GeoIP
+
+	// TrailingSlash is the policy of the doc root on the trailing
+	// slash of request paths.
+	TrailingSlash TrailingSlash
+
+	// Redirects send the clients asking for some paths elsewhere; the
+	// first one matching a request, in order, answers it.
+	Redirects []Redirect
+
+	// Rewrites rewrite the paths of requests, in order, before they
+	// are routed or served from DocRoot.
+	Rewrites []Rewrite
+
+	// AllowedMethods are the methods of the requests the server serves,
+	// e.g. "GET" and "HEAD", only GET if empty; the requests of the
+	// other standard methods get a 405 Method Not Allowed listing them
+	// in its Allow header, on a connection kept open. The requests to
+	// ForwardProxy, reserved endpoints and WebDAV mounts are not subject
+	// to it, nor those answered by request hooks. OPTIONS requests,
+	// including "OPTIONS *", are answered by the server itself, listing
+	// them; see HandleOptions.
+	AllowedMethods []string
+
+	// ForwardProxy, if set, makes the server a forward proxy too,
+	// relaying absolute-form GETs and tunneling CONNECTs.
+	ForwardProxy *ForwardProxy
+
+	// Limits bounds request sizes, connection counts and timeouts.
+	// Zero fields fall back to DefaultLimits.
+	Limits Limits
+
+	// BanPolicy temporarily bans clients that keep violating
+	// the protocol or tripping limits.
+	BanPolicy BanPolicy
+
+	// AcceptFilter, if set, is called with every accepted connection
+	// before a goroutine is spawned to handle it. Returning false
+	// closes the connection right away.
+	AcceptFilter func(conn net.Conn) bool
+
+	// Quota caps the response bytes served to each client IP.
+	Quota BandwidthQuota
+
+	// LoadShedding answers part of the traffic with 503
+	// while the server is overloaded.
+	LoadShedding LoadShedding
+
+	// Logger receives the server's diagnostic output.
+	// If nil, only warnings and errors are logged, via the log package.
+	Logger Logger
+
+	// ErrorLog, if set, receives parse errors, handler errors and
+	// internal failures separately from Logger.
+	ErrorLog Logger
+
+	// AccessLog, if set, receives one structured record per request,
+	// see NewJSONAccessLog.
+	AccessLog *slog.Logger
+
+	// AccessLogSampling, if above 1, only logs 1 in every
+	// AccessLogSampling successful requests. Errors are always logged.
+	AccessLogSampling int
+
+	// DebugLog receives the debug output of requests targeted by
+	// DebugClient or DebugPath. If nil, it goes through the log package.
+	DebugLog Logger
+
+	// MetricLabels bounds the hosts, routes and countries RouteStats
+	// are broken down by.
+	MetricLabels MetricLabels
+
+	// StatsD, if set, receives the metrics of every request.
+	StatsD *StatsD
+
+	// AdminAddr, if set, is a loopback address to serve admin endpoints
+	// on: profiling under /debug/pprof/, expvars under /debug/vars and
+	// the open connections under /admin/conns.
+	AdminAddr string
+
+	// BlockProfileRate, if positive, turns on the block and mutex
+	// profiles served on AdminAddr, see runtime.SetBlockProfileRate.
+	BlockProfileRate int
+
+	// ConnState, if set, is called whenever a client connection
+	// changes state. See Stats for the counters it feeds.
+	ConnState func(conn net.Conn, state ConnState)
+
+	// Tracer, if set, traces every connection and request, joining
+	// the traces of clients that send a traceparent header.
+	Tracer Tracer
+
+	// SlowRequestThreshold, if positive, logs every request taking
+	// longer than it to the error log, with a breakdown of the time
+	// spent reading, handling and writing.
+	SlowRequestThreshold time.Duration
+
+	// Capture, if set, records transcripts of selected connections.
+	Capture *Capture
+
+	// OnReload, if set, is run by Reload to re-read configuration
+	// and reopen log files.
+	OnReload func() error
+
+	// WebDAV mounts parts of the doc root for WebDAV clients to browse
+	// and, unless read-only, change.
+	WebDAV []WebDAV
+
+	// EventLoop, if set, parks the idle keep-alive connections in an
+	// epoll or kqueue poller, where they hold neither a goroutine nor
+	// a read buffer, until bytes arrive, for servers keeping many
+	// connections open. It is ignored on systems with neither, and for
+	// connections other than TCP.
+	EventLoop bool
+
+	// ETag, if set, tags the files served, for clients to revalidate
+	// them with If-None-Match, instead of ModTimeETag; ContentHashETag
+	// tags them by their content.
+	ETag ETagFunc
+
+	// Clock, if set, supplies the time stamped in the Date header of
+	// every response instead of time.Now, so tests can expect exact
+	// headers. Last-Modified still comes from the file served.
+	Clock func() time.Time
+
+	conns connCounter
+	bans  banList
+	usage bandwidthMeter
+	load  loadMonitor
+
+	sampler accessSampler
+	debug   debugTargets
+	routes  routeMetrics
+	tracker connTracker
+	parked  parkedConns
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	inShutdown atomic.Bool
+	admin      *http.Server
+	onStartup  []func(net.Addr) error
+	onShutdown []func()
+	certs      map[*tlsCerts]struct{}
+	docRoot    atomic.Pointer[string]
+	vhostRoots atomic.Pointer[[]string] // the resolved DocRoots of VirtualHosts
+	archive    atomic.Pointer[mountFS]  // the archive DocRoot is, if any
+	hooks      atomic.Pointer[hookList]
+
+	settings    atomic.Pointer[Settings]
+	fallbackLog atomic.Pointer[levelLogger]
 }
 
-// ListenAndServe listens on the TCP network address s.Addr and then
-// handles requests on incoming connections.
+// ListenAndServe listens on the TCP network address s.Addr, and those
+// of s.Listeners, and then handles requests on incoming connections.
+// It returns ErrServerClosed once Shutdown is called.
 func (s *Server) ListenAndServe() error {
+	lns, err := s.setup()
+	if err != nil {
+		return err
+	}
+	return s.serveAll(lns)
+}
+
+// serveAll serves lns until they are all closed, returning the error
+// the first of them stopped with. If it is not ErrServerClosed, the
+// others are closed too.
+func (s *Server) serveAll(lns []boundListener) error {
+	errc := make(chan error, len(lns))
+	for _, l := range lns {
+		go func(l boundListener) { errc <- l.serve(s) }(l)
+	}
+	err := <-errc
+	if err != ErrServerClosed {
+		for _, l := range lns {
+			_ = l.ln.Close()
+		}
+	}
+	for range lns[1:] {
+		<-errc
+	}
+	return err
+}
+
+// setup validates the configuration, listens on the configured addresses
+// and starts the admin endpoints, returning the listeners to serve.
+func (s *Server) setup() ([]boundListener, error) {
 
 	// Validate the configuration of the server
 	if err := s.ValidateServerSetup(); err != nil {
-		return fmt.Errorf("server is not up correctly %v", err)
+		return nil, fmt.Errorf("server is not up correctly %w", err)
+	}
+	if err := s.resolveDocRoot(); err != nil {
+		return nil, err
+	}
+	if err := s.resolveVirtualHosts(); err != nil {
+		return nil, err
+	}
+	if s.GeoIP != nil {
+		if err := s.GeoIP.Load(); err != nil {
+			return nil, err
+		}
 	}
-	// fmt.Println("Server setup valid!")
 
-	// Server should now start to listen on the configured address
-	ln, err := net.Listen("tcp", s.Addr)
+	lns, err := s.listen()
 	if err != nil {
-		return fmt.Errorf("%v", err)
+		return nil, err
+	}
+	for _, l := range lns {
+		if len(l.certs) > 0 {
+			s.logger().Infof("Listening on %v with TLS", l.ln.Addr())
+		} else {
+			s.logger().Infof("Listening on %v", l.ln.Addr())
+		}
+	}
+
+	if err := s.startAdmin(); err != nil {
+		for _, l := range lns {
+			_ = l.ln.Close()
+		}
+		return nil, err
 	}
-	// fmt.Println("Listening on", ln.Addr())
+	return lns, nil
+}
+
+// listen returns the listeners on Addr and those of Listeners: those
+// inherited from the parent process through Upgrade, by address, or
+// new ones.
+func (s *Server) listen() ([]boundListener, error) {
+	var want []Listener
+	if s.Addr != "" || len(s.Listeners) == 0 {
+		want = append(want, Listener{Addr: s.Addr})
+	}
+	want = append(want, s.Listeners...)
+	inherited, err := InheritedListeners()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, extra := range inherited {
+			_ = extra.Close()
+		}
+	}()
+
+	lns := make([]boundListener, 0, len(want))
+	for _, l := range want {
+		var ln net.Listener
+		for i, in := range inherited {
+			// A single address takes the listener handed over whatever it
+			// is, e.g. that of ":0"
+			if len(want) == 1 || sameAddr(in.Addr(), l.Addr) {
+				ln = in
+				inherited = append(inherited[:i], inherited[i+1:]...)
+				break
+			}
+		}
+		if ln == nil {
+			// Server should now start to listen on the configured address
+			if ln, err = net.Listen("tcp", l.Addr); err != nil {
+				for _, l := range lns {
+					_ = l.ln.Close()
+				}
+				return nil, fmt.Errorf("%v", err)
+			}
+		}
+		lns = append(lns, boundListener{ln: ln, certs: l.Certificates})
+	}
+	return lns, nil
+}
+
+// Serve accepts connections on ln and handles each of them in a new
+// goroutine. It always closes ln, and returns ErrServerClosed once
+// Shutdown is called.
+func (s *Server) Serve(ln net.Listener) error {
+	return s.serve(ln, nil)
+}
+
+// serve is Serve speaking TLS with the clients if cfg is set.
+func (s *Server) serve(ln net.Listener, cfg *tls.Config) error {
+	if !s.trackListener(ln, true) {
+		_ = ln.Close()
+		return ErrServerClosed
+	}
+	defer s.trackListener(ln, false)
 
 	// Making sure the listener is closed when exit
 	defer func() {
-		err = ln.Close()
-		if err != nil {
-			fmt.Println("error in closing listener", err)
+		if err := ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.errorLog().Errorf("Error in closing listener: %v", err)
 		}
 	}()
 
-	//accept connections forever
+	if err := s.runStartupHooks(ln.Addr()); err != nil {
+		return err
+	}
+
+	//accept connections until shut down
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			// Likely out of file descriptors, give it some time
+			s.errorLog().Errorf("Accept error: %v", err)
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		s.logger().Debugf("Accepted connection %v", conn.RemoteAddr())
+		if !s.admit(conn) {
+			s.tracker.reject()
+			_ = conn.Close()
 			continue
 		}
-		fmt.Println("Accepted connection", conn.RemoteAddr())
-		go s.HandleConnection(conn)
+		addr := conn.RemoteAddr()
+		if cfg != nil {
+			conn = tls.Server(conn, cfg)
+		}
+		go s.handleConnection(s.Capture.wrap(conn, s.errorLog()), func() { s.conns.release(addr) })
 	}
+}
 
-	// Hint: call HandleConnection
+// admit decides whether the newly accepted conn gets handled,
+// according to bans, the accept filter and connection limits.
+// Admitted connections count against the limits until released.
+func (s *Server) admit(conn net.Conn) bool {
+	if s.IsBanned(hostOf(conn.RemoteAddr())) {
+		return false
+	}
+	if s.AcceptFilter != nil && !s.AcceptFilter(conn) {
+		return false
+	}
+	if ok, perIP := s.conns.acquire(conn.RemoteAddr(), s.current().Limits.withDefaults()); !ok {
+		// Only a client over its own limit is to blame for it
+		if perIP {
+			s.logger().Warnf("Too many connections from %v, dropping", hostOf(conn.RemoteAddr()))
+			s.strike(conn.RemoteAddr())
+		} else {
+			s.logger().Warnf("Too many connections, dropping %v", conn.RemoteAddr())
+		}
+		return false
+	}
+	return true
 }
 
 // HandleConnection reads requests from the accepted conn and handles them.
+// With EventLoop set, it may return once conn is idle, parked until it
+// sends more, to be served on and closed by another goroutine.
 func (s *Server) HandleConnection(conn net.Conn) {
-	br := bufio.NewReader(conn)
-	for {
+	s.handleConnection(conn, nil)
+}
+
+// handleConnection is HandleConnection calling done, if set, once conn
+// is closed.
+func (s *Server) handleConnection(conn net.Conn, done func()) {
+	s.setState(conn, StateNew)
+	c := &serverConn{s: s, tracked: conn, conn: s.tracker.metered(conn), done: done}
+	c.span = s.startConnSpan(conn.RemoteAddr().String())
+	c.br = getBufioReader(c.conn)
+	c.serve(true)
+}
+
+// serverConn is a client connection of a Server.
+type serverConn struct {
+	s       *Server
+	tracked net.Conn // as accepted, tracked in s.tracker
+	conn    net.Conn // tracked, metered
+	span    Span
+	br      *bufio.Reader // nil while parked
+	done    func()
+}
+
+// resume serves c, parked, once it is readable.
+func (c *serverConn) resume() {
+	c.br = getBufioReader(c.conn)
+	c.serve(false)
+}
+
+// close closes c, once done with.
+func (c *serverConn) close() {
+	c.span.End()
+	_ = c.conn.Close()
+	c.s.setState(c.tracked, StateClosed)
+	if c.done != nil {
+		c.done()
+	}
+}
+
+// serve reads requests from c and handles them until c is to be
+// closed, or parked; first is whether no request was read yet.
+func (c *serverConn) serve(first bool) {
+	s, conn, tracked, br, connSpan := c.s, c.conn, c.tracked, c.br, c.span
+	parked := false
+	defer func() {
+		if !parked {
+			c.close()
+		}
+	}()
+	defer s.recoverPanic(conn)
+	for ; ; first = false {
+		if !first {
+			s.setState(tracked, StateIdle)
+		}
+		lim := s.current().Limits.withDefaults()
+
 		// Set timeout
-		if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
-			fmt.Printf("Failed to set timeout for connection %v", conn)
-			_ = conn.Close()
+		if err := conn.SetReadDeadline(time.Now().Add(lim.ReadTimeout)); err != nil {
+			s.errorLog().Errorf("Failed to set timeout for connection %v: %v", conn.RemoteAddr(), err)
 			return
 		}
 
-		// Try to read next request
-		req, bytesReceived, err := ReadRequest(br)
+		// Shake hands first, so that a failed handshake is not taken
+		// for a bad request to answer
+		if tc, ok := tlsConn(tracked); ok && first {
+			if err := tc.Handshake(); err != nil {
+				s.logger().Debugf("TLS handshake with %v failed: %v", conn.RemoteAddr(), err)
+				return
+			}
+		}
+
+		// Try to read next request, timing it from its first byte
+		if _, err := br.Peek(1); err == nil {
+			s.setState(tracked, StateActive)
+		}
+		readStart := time.Now()
+		req, bytesReceived, err := readProxyRequest(br, lim, readOptions{proxy: s.ForwardProxy != nil, dav: len(s.WebDAV) > 0, methods: true})
 
 		// Handle EOF
 		if errors.Is(err, io.EOF) {
-			fmt.Printf("Connection closed by %v", conn.RemoteAddr())
-			_ = conn.Close()
+			s.logger().Debugf("Connection closed by %v", conn.RemoteAddr())
+			return
+		}
+
+		// Closed by Shutdown or CloseConnection
+		if errors.Is(err, net.ErrClosed) {
 			return
 		}
 
-		// fmt.Printf("%v\n", bytesReceived)
+		_ = conn.SetWriteDeadline(time.Now().Add(lim.WriteTimeout))
+
 		// Handle timeout
 		// just close the connection (need more)
 		if err, ok := err.(net.Error); ok && err.Timeout() {
+			s.tracker.timeout()
 			if !bytesReceived {
-				fmt.Printf("Connection to %v timed out", conn.RemoteAddr())
-				_ = conn.Close()
+				s.logger().Debugf("Connection to %v timed out", conn.RemoteAddr())
 				return
 			}
 			if bytesReceived {
-				res := &Response{}
-				fmt.Printf("Connection to %v timed out with part of a request sent", conn.RemoteAddr())
-				res.HandleBadRequest()
-				_ = res.Write(conn)
-				_ = conn.Close()
+				s.errorLog().Infof("Connection to %v timed out with part of a request sent", conn.RemoteAddr())
+				s.writeRequestError(conn, err)
 				return
 			}
 		}
@@ -117,27 +668,31 @@ func (s *Server) HandleConnection(conn net.Conn) {
 		// Handle bad request
 		// request is not a GET
 		if err != nil {
-			res := &Response{}
-			fmt.Printf("Handle bad request for error %v", err)
-			res.HandleBadRequest()
-			_ = res.Write(conn)
-			_ = conn.Close()
+			s.errorLog().Infof("Bad request from %v: %v", conn.RemoteAddr(), err)
+			s.writeRequestError(conn, err)
 			return
 		}
 
-		// Handle good request
-		log.Printf("Handle good request: %v", req)
-		res := s.HandleGoodRequest(req)
-		// fmt.Printf("Good request response: %v\n", res)
-		// call response write function
-		err = res.Write(conn)
-		if err != nil {
-			fmt.Printf("Write error: %v\n", err)
+		// Tunnels take the connection over
+		if req.Method == "CONNECT" {
+			s.tracker.setRequest(tracked, req)
+			s.serveTunnel(conn, br, req)
+			return
 		}
 
-		if req.Close || res.StatusCode == 400 {
-			fmt.Printf("Request close connection")
-			_ = conn.Close()
+		// Handle good request, then skip what it left of its body
+		body := requestBody(br, req)
+		keepAlive := s.serveRequest(conn, tracked, br, req, connSpan, readStart)
+		if !keepAlive {
+			s.logger().Debugf("Closing connection to %v", conn.RemoteAddr())
+			return
+		}
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return
+		}
+		releaseRequest(req)
+		if s.EventLoop && s.parked.park(c) {
+			parked = true
 			return
 		}
 
@@ -145,70 +700,316 @@ func (s *Server) HandleConnection(conn net.Conn) {
 	}
 }
 
+// serveRequest handles the valid req read from conn through br and
+// writes back the response. It reports whether conn should be kept open
+// for more requests.
+func (s *Server) serveRequest(conn, tracked net.Conn, br *bufio.Reader, req *Request, connSpan Span, readStart time.Time) bool {
+	req.RemoteAddr = conn.RemoteAddr().String()
+	if _, ok := tlsConn(tracked); ok {
+		req.Scheme = "https"
+	}
+	s.geoLocate(req)
+	s.tracker.setRequest(tracked, req)
+	s.requestLogger(req).Debugf("Handle good request from %v: %v", req.RemoteAddr, req)
+	ip := hostOf(conn.RemoteAddr())
+	start := time.Now()
+	rec := newAccessRecord(conn.RemoteAddr().String(), req, start)
+	span := s.startRequestSpan(req, connSpan)
+	s.load.begin()
+	st := s.current()
+	req.interim = func(res *Response) error { return writeInterim(conn, res) }
+	req.conn, req.br = conn, br
+	var cont *continueReader
+	if expectsContinue(req) {
+		cont = &continueReader{r: req.Body, req: req}
+		req.Body = cont
+	}
+	var res *Response
+	if st.Maintenance {
+		res = getResponse()
+		res.HandleServiceUnavailable(req, st.MaintenanceRetryAfter)
+	} else if s.shouldShed(st.LoadShedding) {
+		res = getResponse()
+		res.HandleServiceUnavailable(req, st.LoadShedding.RetryAfter)
+	} else if retryAfter, over := s.usage.exceeded(ip, st.Quota, time.Now()); over {
+		res = getResponse()
+		res.HandleTooManyRequests(req, retryAfter)
+	} else if s.geoRefused(req) {
+		res = getResponse()
+		res.HandleForbidden(req)
+	} else if hr := s.runRequestHooks(req); hr != nil {
+		res = hr
+	} else if rs := s.serveReserved(req); rs != nil {
+		res = rs
+	} else if req.URL == "*" {
+		res = s.HandleOptions(req)
+	} else if !strings.HasPrefix(req.URL, "/") {
+		res = s.ForwardProxy.ServeRequest(req)
+	} else if dav := s.serveWebDAV(req); dav != nil {
+		res = dav
+	} else if req.Method == "OPTIONS" {
+		res = s.HandleOptions(req)
+	} else if !s.methodAllowed(req.Method) && !s.davHead(req) {
+		res = s.methodNotAllowed(req)
+	} else if rd := s.CanonicalHost.redirect(req); rd != nil {
+		res = rd
+	} else if rd := s.redirect(req); rd != nil {
+		res = rd
+	} else if !s.rewrite(req) {
+		res = getResponse()
+		res.HandleNotFound(req)
+	} else if a := s.alias(req); a != nil {
+		res = a
+	} else if s.reserved(req) {
+		// Rewritten or aliased into a reserved prefix
+		res = getResponse()
+		res.HandleNotFound(req)
+	} else if r := s.route(req); r != nil {
+		res = r.serve(req)
+	} else {
+		res = s.HandleGoodRequest(req)
+	}
+	res = s.runResponseHooks(req, res)
+	defer releaseResponse(res)
+	res.head = req.Method == "HEAD"
+	req.interim = nil
+	defer func() { req.conn, req.br = nil, nil }()
+	// The client may or may not send a body it was not asked for, so
+	// the connection can't be reused
+	if req.Close || cont != nil && !cont.sent {
+		res.setHeader("Connection", "close")
+	}
+	res.setHeader("Date", FormatTime(s.now()))
+	handled := time.Now()
+
+	// call response write function
+	n, writeErr := res.WriteTo(conn)
+	if writeErr != nil {
+		s.errorLog().Warnf("Write error to %v: %v", conn.RemoteAddr(), writeErr)
+	}
+	written := time.Now()
+	s.requestLogger(req).Debugf("Response to %v: %v %v, %v bytes", req.RemoteAddr, res.StatusCode, res.Header, n)
+	s.usage.add(ip, n, st.Quota, written)
+	s.load.end(written.Sub(start))
+	rec.status, rec.bytes = res.StatusCode, n
+	s.finishRequest(rec)
+	endRequestSpan(span, res.StatusCode, n)
+	s.checkSlow(req, phases{read: start.Sub(readStart), handle: handled.Sub(start), write: written.Sub(handled)})
+
+	if up := res.upgraded; up != nil {
+		// The connection now speaks the protocol switched to
+		defer up.Close()
+		if writeErr == nil {
+			_ = conn.SetDeadline(time.Time{})
+			splice(conn, br, up)
+		}
+		return false
+	}
+	return !req.Close && res.StatusCode != 400 && res.Header["Connection"] != "close" && !s.shuttingDown()
+}
+
+// writeRequestError answers a request that could not be read, because
+// of err, with the status StatusFromError maps it to, e.g. 400 Bad
+// Request, giving the reason in the body if err is about a malformed
+// request target. The caller must close conn afterwards.
+func (s *Server) writeRequestError(conn net.Conn, err error) {
+	rec := newAccessRecord(conn.RemoteAddr().String(), nil, time.Now())
+	res := &Response{}
+	res.HandleBadRequest()
+	res.StatusCode = StatusFromError(err)
+	// A version or transfer coding the server does not support is no
+	// violation of the protocol
+	if res.StatusCode != statusHTTPVersionNotSupported && res.StatusCode != statusNotImplemented {
+		s.strike(conn.RemoteAddr())
+	}
+	if res.StatusCode == statusMethodNotAllowed {
+		res.Header["Allow"] = s.allow()
+	}
+	var te *targetError
+	if errors.As(err, &te) {
+		res.Text(statusBadRequest, te.reason+"\n")
+	}
+	res.Header["Date"] = FormatTime(s.now())
+	n, _ := res.WriteTo(conn)
+	rec.status, rec.bytes = res.StatusCode, n
+	s.finishRequest(rec)
+}
+
+// fileName returns the name of the file under root the path of target,
+// a request target, names once its percent-escapes are decoded, e.g.
+// "/caf%C3%A9.html" that of café.html, or false if it names none.
+func fileName(root, target string) (string, bool) {
+	name, ok := decodedPath(target)
+	if !ok {
+		return "", false
+	}
+	p := filepath.Clean(root + name)
+	return p, within(root, p)
+}
+
+// within reports whether the file name p is root or under it, and not
+// merely a sibling whose name starts alike, as /srv/www-secret is of
+// /srv/www.
+func within(root, p string) bool {
+	root = strings.TrimSuffix(filepath.Clean(root), string(filepath.Separator))
+	return p == root || len(p) > len(root) && p[len(root)] == filepath.Separator && strings.HasPrefix(p, root)
+}
+
 // HandleGoodRequest handles the valid req and generates the corresponding res.
 func (s *Server) HandleGoodRequest(req *Request) (res *Response) {
 	// validate url: error 404
-	res = &Response{}
+	res = getResponse()
+	log := s.requestLogger(req)
 
-	if strings.HasSuffix(req.URL, "/") {
-		req.URL = req.URL + "index.html"
+	// ReadRequest normalized the URL already, unless req was made
+	// otherwise; names above the doc root do not exist
+	target, ok := removeDotSegments(req.URL)
+	if !ok {
+		res.HandleNotFound(req)
+		log.Debugf("URL %v climbs above the doc root", req.URL)
+		return res
+	}
+	req.URL = target
+	root := s.rootFor(req)
+	if res := s.trailingSlash(req, root); res != nil {
+		return res
+	}
+
+	urlPath, query, hasQuery := strings.Cut(req.URL, "?")
+	dirIndex := strings.HasSuffix(urlPath, "/")
+	if dirIndex {
+		req.URL = urlPath + "index.html"
+		if hasQuery {
+			req.URL += "?" + query
+		}
 	}
-	fmt.Printf("url: %v\n", req.URL)
+	log.Debugf("URL: %v", req.URL)
 
 	if req.URL == "" {
 		res.HandleNotFound(req)
-		fmt.Printf("Empty request url: Status: %v, Connection close: %v\n", res.StatusCode, req.Close)
+		log.Debugf("Empty request URL")
 		return res
 	}
-	// path := filepath.Clean(filepath.Join(s.DocRoot, req.URL))
-	// fmt.Printf("Full path: %v\n", s.DocRoot+req.URL)
-	path := filepath.Clean(s.DocRoot + req.URL)
-	// fmt.Printf("Doc root: %v\n", s.DocRoot)
-	fmt.Printf("File path: %v\n", path)
+	path, ok := fileName(root, req.URL)
+	log.Debugf("File path: %v", path)
 
-	// fmt.Printf("Has Doc Root prefix? %v\n", strings.HasPrefix(path, s.DocRoot))
-	if strings.HasPrefix(path, s.DocRoot) == false {
+	if !ok {
 		res.HandleNotFound(req)
-		fmt.Printf("Path not doc root: Status: %v, Connection close: %v\n", res.StatusCode, req.Close)
+		log.Debugf("URL %v names no file under the doc root", req.URL)
 		return res
 	}
 
-	fi, err := os.Stat(path)
-	if os.IsNotExist(err) {
+	var chosen negotiated
+	if s.Negotiate {
+		var acceptable bool
+		if chosen, acceptable = s.negotiate(req, path); !acceptable {
+			res.Text(statusNotAcceptable, StatusText(statusNotAcceptable)+"\n")
+			res.Header["Vary"] = chosen.vary
+			log.Debugf("No variant of %v is acceptable", path)
+			return res
+		}
+		path = chosen.name
+	}
+
+	// Open the file once, so that it is served as it was stat'ed
+	// even if it is deleted or replaced meanwhile
+	f, err := s.fileSystem().Open(path)
+	if dirIndex && s.Markdown != nil && errors.Is(err, fs.ErrNotExist) {
+		// Documentation trees have an index.md instead
+		path = strings.TrimSuffix(path, "index.html") + "index.md"
+		f, err = s.fileSystem().Open(path)
+	}
+	var fi fs.FileInfo
+	if err == nil {
+		if fi, err = f.Stat(); err != nil {
+			_ = f.Close()
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
 		res.HandleNotFound(req)
-		fmt.Printf("Path not exist: Status: %v, Connection close: %v\n", res.StatusCode, req.Close)
+		log.Debugf("Path %v does not exist", path)
+	} else if err != nil {
+		res.HandleNotFound(req)
+		s.errorLog().Errorf("Failed to open %v: %v", path, err)
 	} else if fi.IsDir() {
+		_ = f.Close()
 		res.HandleNotFound(req)
-		fmt.Printf("Path is dir: Status: %v, Connection close: %v\n", res.StatusCode, req.Close)
+		log.Debugf("Path %v is a directory", path)
 	} else {
-		// fmt.Println("Handle OK")
-		res.HandleOK(req, path)
-		fmt.Printf("Status: %v, Connection close: %v\n", res.StatusCode, req.Close)
+		res.handleOK(req, path, fi, f)
+		res.file = f
+		if chosen.lang != "" {
+			res.Header["Content-Language"] = chosen.lang
+		}
+		if chosen.vary != "" {
+			res.Header["Vary"] = chosen.vary
+		}
+		if s.Markdown != nil && isMarkdown(path) {
+			s.serveMarkdown(res, req, path, fi, f)
+		} else if s.isSSI(path) {
+			s.serveSSI(res, req, path, fi)
+		} else {
+			s.setETag(res, fi, f)
+			gzipped := s.serveArchiveGzip(res, req, f)
+			if !res.checkPreconditions(req) && !gzipped {
+				res.serveRange(req, fi.Size())
+			}
+		}
+		if s.attachment(req) {
+			res.Attachment(filepath.Base(path))
+		}
 	}
-	// fmt.Printf("Response: %v\n", res)
+	return res
+}
+
+// HandleOptions answers req, an OPTIONS request of the server as a
+// whole, "OPTIONS *", or of a path, with a 200 OK listing the methods
+// allowed in its Allow header, and no body.
+func (s *Server) HandleOptions(req *Request) *Response {
+	res := NewResponse(statusOK)
+	res.Header["Allow"] = s.allow()
+	res.Header["Content-Length"] = "0"
 	return res
 }
 
 // HandleOK prepares res to be a 200 OK response
-// ready to be written back to client.
+// ready to be written back to client, a 304 Not Modified for a
+// conditional request whose client has the file as it is, a 412
+// Precondition Failed for one whose preconditions fail, or a 206
+// Partial Content for the byte range of a GET, see Request.Ranges.
+// It answers 404 Not Found if path cannot be opened and stat'ed.
 func (res *Response) HandleOK(req *Request, path string) {
-	// fmt.Printf("Handle OK")
+	f, err := os.Open(path)
+	if err != nil {
+		res.HandleNotFound(req)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		res.HandleNotFound(req)
+		return
+	}
+	res.handleOK(req, path, fi, f)
+	res.Header["Etag"], _ = ModTimeETag(fi, f)
+	if !res.checkPreconditions(req) {
+		res.serveRange(req, fi.Size())
+	}
+}
+
+// handleOK is HandleOK for the file at path, described by fi and
+// opened as f.
+func (res *Response) handleOK(req *Request, path string, fi fs.FileInfo, f fs.File) {
 	// edit response object value
 	res.Proto = req.Proto
 	res.StatusCode = statusOK
 
-	file, err := os.Stat(path)
-	if err != nil {
-		// fmt.Println(err)
-	}
-
 	// res.Header = req.Header
-	res.Header = make(map[string]string)
+	res.Header = res.freshHeader()
 	res.Header["Date"] = FormatTime(time.Now())
-	res.Header["Last-Modified"] = FormatTime(file.ModTime())
-	ext := "." + strings.SplitN(path, ".", 2)[1]
-	res.Header["Content-Type"] = MIMETypeByExtension(ext)
-	res.Header["Content-Length"] = strconv.Itoa(int(file.Size()))
+	res.Header["Last-Modified"] = FormatTime(fi.ModTime())
+	res.Header["Content-Type"] = contentType(path, f)
+	res.Header["Content-Length"] = strconv.Itoa(int(fi.Size()))
 	if req.Close {
 		res.Header["Connection"] = "close"
 	}
@@ -217,6 +1018,33 @@ func (res *Response) HandleOK(req *Request, path string) {
 	res.Request = req
 }
 
+// contentType returns the Content-Type of the file at path, opened as
+// f: that of its extension, if known, or else the one sniffed from its
+// first bytes, read without moving the offset of f.
+func contentType(path string, f fs.File) string {
+	if t := MIMETypeByExtension(filepath.Ext(path)); t != "" {
+		return t
+	}
+	buf := make([]byte, sniffLen)
+	var n int
+	var err error
+	switch r := f.(type) {
+	case io.ReaderAt:
+		n, err = r.ReadAt(buf, 0)
+	case io.ReadSeeker:
+		n, err = io.ReadFull(r, buf)
+		if _, serr := r.Seek(0, io.SeekStart); serr != nil {
+			return "application/octet-stream"
+		}
+	default:
+		return "application/octet-stream"
+	}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "application/octet-stream"
+	}
+	return DetectContentType(buf[:n])
+}
+
 // HandleBadRequest prepares res to be a 400 Bad Request response
 // ready to be written back to client
 func (res *Response) HandleBadRequest() {
@@ -224,7 +1052,7 @@ func (res *Response) HandleBadRequest() {
 	res.StatusCode = statusBadRequest
 	res.FilePath = ""
 
-	response_header := make(map[string]string)
+	response_header := res.freshHeader()
 	response_header["Date"] = FormatTime(time.Now())
 	response_header["Connection"] = "close"
 	res.Header = response_header
@@ -235,31 +1063,104 @@ func (res *Response) HandleBadRequest() {
 // HandleNotFound prepares res to be a 404 Not Found response
 // ready to be written back to client.
 func (res *Response) HandleNotFound(req *Request) {
-	// fmt.Println("Handle Not Found Error")
-	// fmt.Printf("status code got: %v\n", statusNotFound)
 	res.StatusCode = statusNotFound
 	res.FilePath = ""
 	res.Proto = "HTTP/1.1"
 	res.Request = nil
 
 	// res.Header = req.Header
-	res.Header = make(map[string]string)
+	res.Header = res.freshHeader()
 	res.Header["Date"] = FormatTime(time.Now())
 	if req.Close {
 		res.Header["Connection"] = "close"
 	}
 }
 
-func (s *Server) ValidateServerSetup() error {
-	fi, err := os.Stat(s.DocRoot)
+// HandleRedirect prepares res to be a redirect to location with code,
+// one of 301, 302, 307 and 308, and no body. The bytes location may not
+// hold as is, e.g. those of UTF-8 names, are percent-encoded.
+func (res *Response) HandleRedirect(req *Request, code int, location string) {
+	res.HandleNotFound(req)
+	res.Redirect(location, code)
+}
 
-	if os.IsNotExist(err) {
-		return err
+// HandleForbidden prepares res to be a 403 Forbidden response, for a
+// request the server refuses to serve.
+func (res *Response) HandleForbidden(req *Request) {
+	res.HandleNotFound(req)
+	res.StatusCode = statusForbidden
+}
+
+// HandleProxyAuthRequired prepares res to be a 407 Proxy Authentication
+// Required response challenging the client to authenticate with Basic
+// credentials for realm, "" meaning "TritonHTTP".
+func (res *Response) HandleProxyAuthRequired(req *Request, realm string) {
+	if realm == "" {
+		realm = defaultProxyRealm
+	}
+	res.HandleNotFound(req)
+	res.StatusCode = statusProxyAuthRequired
+	res.Header["Proxy-Authenticate"] = fmt.Sprintf("Basic realm=%q", realm)
+}
+
+// HandleTooManyRequests prepares res to be a 429 Too Many Requests response
+// telling the client to retry after retryAfter.
+func (res *Response) HandleTooManyRequests(req *Request, retryAfter time.Duration) {
+	res.StatusCode = statusTooManyRequests
+	res.FilePath = ""
+	res.Proto = "HTTP/1.1"
+	res.Request = nil
+
+	res.Header = res.freshHeader()
+	res.Header["Date"] = FormatTime(time.Now())
+	res.Header["Retry-After"] = strconv.Itoa(int((retryAfter + time.Second - 1) / time.Second))
+	if req.Close {
+		res.Header["Connection"] = "close"
 	}
+}
+
+// HandleServiceUnavailable prepares res to be a 503 Service Unavailable
+// response telling the client to retry after retryAfter.
+func (res *Response) HandleServiceUnavailable(req *Request, retryAfter time.Duration) {
+	res.HandleTooManyRequests(req, retryAfter)
+	res.StatusCode = statusServiceUnavailable
+}
+
+// HandleBadGateway prepares res to be a 502 Bad Gateway response,
+// for a request whose upstream server could not be reached or
+// answered wrongly.
+func (res *Response) HandleBadGateway(req *Request) {
+	res.HandleNotFound(req)
+	res.StatusCode = statusBadGateway
+}
 
-	if !fi.IsDir() {
-		return fmt.Errorf("doc root %q is not a directory", s.DocRoot)
+// HandleGatewayTimeout prepares res to be a 504 Gateway Timeout
+// response, for a request whose upstream server answered too late.
+func (res *Response) HandleGatewayTimeout(req *Request) {
+	res.HandleNotFound(req)
+	res.StatusCode = statusGatewayTimeout
+}
+
+// requestBody sets the Body of req, framed by its Content-Length in
+// br, and returns it. Requests without a Content-Length have none.
+func requestBody(br *bufio.Reader, req *Request) io.Reader {
+	n, err := strconv.ParseInt(req.Header["Content-Length"], 10, 64)
+	if err != nil || n <= 0 {
+		return io.LimitReader(br, 0)
 	}
+	req.Body = io.LimitReader(br, n)
+	return req.Body
+}
 
-	return nil
+// now returns the time from Clock, or time.Now if it is not set.
+func (s *Server) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+// ValidateServerSetup is Validate under its original name.
+func (s *Server) ValidateServerSetup() error {
+	return s.Validate()
 }
@@ -1,8 +1,15 @@
 package tritonhttp
 
 import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"os"
 	"path/filepath"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 const (
@@ -117,6 +124,23 @@ func TestHandleGoodRequest(t *testing.T) {
 			map[string]string{},
 			"",
 		},
+		{
+			"NotFoundAboveRoot",
+			&Request{
+				Method: "GET",
+				URL:    "/../testdata/index.html",
+				Proto:  "HTTP/1.1",
+				Header: map[string]string{},
+				Host:   "test",
+				Close:  false,
+			},
+			404,
+			[]string{
+				"Date",
+			},
+			map[string]string{},
+			"",
+		},
 		{
 			"404TryToReadADirectory",
 			&Request{
@@ -161,7 +185,7 @@ func TestHandleGoodRequest(t *testing.T) {
 			"OKFilePathCheck2",
 			&Request{
 				Method: "GET",
-				URL:    "/../testdata/subdir/../",
+				URL:    "/subdir/./../",
 				Proto:  "HTTP/1.1",
 				Header: map[string]string{},
 				Host:   "test",
@@ -208,6 +232,7 @@ func TestHandleGoodRequest(t *testing.T) {
 				DocRoot: "testdata",
 			}
 			res := s.HandleGoodRequest(tt.req)
+			defer res.Close()
 			if res.StatusCode != tt.statusWant {
 				t.Fatalf("status code got: %v, want: %v", res.StatusCode, tt.statusWant)
 			}
@@ -243,3 +268,188 @@ func TestHandleGoodRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestFileName(t *testing.T) {
+	root := filepath.FromSlash("/srv/www")
+	var tests = []struct {
+		target string
+		want   string
+		wantOK bool
+	}{
+		{"/index.html", "/srv/www/index.html", true},
+		{"/index.html?x=%zz", "/srv/www/index.html", true},
+		{"/caf%C3%A9.html", "/srv/www/café.html", true},
+		{"/a%20b/c.html", "/srv/www/a b/c.html", true},
+		{"/a%2Fb.html", "/srv/www/a/b.html", true},
+		{"/%2e%2e/etc/passwd", "", false},
+		{"/%2e%2e/www-secret/key.txt", "", false},
+		{"/%2E%2E%2Fwww-secret%2Fkey.txt", "", false},
+		{"/a/%2e%2e/%2e%2e/www-secret/key.txt", "", false},
+		{"/a/%2e%2e/index.html", "/srv/www/index.html", true},
+		{"/a/.%2e/b/%2E/c.html", "/srv/www/b/c.html", true},
+		{"/a%00.html", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := fileName(root, tt.target)
+		if ok != tt.wantOK || ok && got != filepath.FromSlash(tt.want) {
+			t.Errorf("%q: got %q, %v, want %q, %v", tt.target, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// plainFile is an fs.File that can only be read in order.
+type plainFile struct {
+	fs.File
+}
+
+func TestContentType(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dir.v2/page.html": {Data: []byte("<p>hi</p>")},
+		"v1.2/notes":       {Data: []byte("plain notes")},
+		"logo":             {Data: []byte("\x89PNG\r\n\x1a\n")},
+		"blob":             {Data: []byte{0, 1, 2}},
+	}
+	var tests = []struct {
+		name     string
+		seekable bool
+		want     string
+	}{
+		{"dir.v2/page.html", true, contentTypeHTML},
+		{"v1.2/notes", true, "text/plain; charset=utf-8"},
+		{"logo", true, contentTypePNG},
+		{"blob", true, "application/octet-stream"},
+		{"v1.2/notes", false, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		f, err := fsys.Open(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var file fs.File = f
+		if !tt.seekable {
+			file = plainFile{f}
+		}
+		if got := contentType(tt.name, file); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.name, got, tt.want)
+		}
+		// Sniffing leaves the file to be read from the start
+		if b, err := io.ReadAll(f); err != nil || string(b) != string(fsys[tt.name].Data) {
+			t.Errorf("%v: read %q, %v after sniffing", tt.name, b, err)
+		}
+		f.Close()
+	}
+}
+
+func TestServerClock(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "index.html")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 2, 3, 4, 5, 6, 0, time.UTC)
+	s := &Server{DocRoot: root, Clock: func() time.Time { return now }}
+	addr, done := startTestServer(t, s)
+	defer func() {
+		s.Shutdown(context.Background())
+		<-done
+	}()
+
+	var tests = []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"OK", "GET /index.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 200 OK\r\n" +
+				"Accept-Ranges: bytes\r\n" +
+				"Connection: close\r\n" +
+				"Content-Length: 5\r\n" +
+				"Content-Type: text/html; charset=utf-8\r\n" +
+				"Date: Thu, 03 Feb 2022 04:05:06 GMT\r\n" +
+				"Etag: \"16c65510d4bb3200-5\"\r\n" +
+				"Last-Modified: Sun, 02 Jan 2022 03:04:05 GMT\r\n" +
+				"\r\n" +
+				"hello"},
+		{"NotFound", "GET /missing.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 404 Not Found\r\n" +
+				"Connection: close\r\n" +
+				"Date: Thu, 03 Feb 2022 04:05:06 GMT\r\n" +
+				"\r\n"},
+		{"BadRequest", "bad\r\n\r\n",
+			"HTTP/1.1 400 Bad Request\r\n" +
+				"Connection: close\r\n" +
+				"Date: Thu, 03 Feb 2022 04:05:06 GMT\r\n" +
+				"\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := io.WriteString(conn, tt.raw); err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("\ngot: %q\nwant: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithin(t *testing.T) {
+	var tests = []struct {
+		root, p string
+		want    bool
+	}{
+		{"/srv/www", "/srv/www", true},
+		{"/srv/www", "/srv/www/a.html", true},
+		{"/srv/www/", "/srv/www/a.html", true},
+		{"/srv/www", "/srv/www-secret/key.txt", false},
+		{"/srv/www", "/srv", false},
+		{"/", "/etc/passwd", true},
+		{"testdata", "testdata/index.html", true},
+		{"testdata", "testdata2/index.html", false},
+	}
+	for _, tt := range tests {
+		if got := within(filepath.FromSlash(tt.root), filepath.FromSlash(tt.p)); got != tt.want {
+			t.Errorf("within(%q, %q) = %v, want %v", tt.root, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestEncodedDotSegments(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "www")
+	for name, data := range map[string]string{"www/index.html": "home", "www-secret/key.txt": "secret"} {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{DocRoot: root, ErrorLog: NewLogger(nil, LevelError)}
+	addr, _ := startTestServer(t, s)
+	for _, url := range []string{"/%2e%2e/www-secret/key.txt", "/%2E%2E%2Fwww-secret%2Fkey.txt", "/a/%2e%2e/%2e%2e/www-secret/key.txt"} {
+		res := exchangeRaw(t, addr, "GET "+url+" HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 1)[0]
+		if res.StatusCode != 404 {
+			body, _ := io.ReadAll(res.BodyReader)
+			t.Errorf("%v: got %v %q, want 404", url, res.StatusCode, body)
+		}
+	}
+	res := exchangeRaw(t, addr, "GET /a/%2e%2e/index.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n", 1)[0]
+	if body, _ := io.ReadAll(res.BodyReader); res.StatusCode != 200 || string(body) != "home" {
+		t.Errorf("got %v %q, want 200 home", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,583 @@
+package tritonhttp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSessionCookie      = "session"
+	defaultSessionIdleTimeout = 30 * time.Minute
+	defaultSessionMaxLifetime = 24 * time.Hour
+)
+
+// Session is the state a client keeps across requests, found by the
+// signed ID of its cookie. The Handler of Sessions attaches it to the
+// context of the requests it wraps, see SessionFromContext.
+type Session struct {
+	ID       string
+	Values   map[string]string
+	Created  time.Time // when it was started
+	Accessed time.Time // when it was last used, before this request
+
+	mu        sync.Mutex
+	isNew     bool   // started by this request
+	changed   bool   // Values were set or deleted
+	destroyed bool   // Destroy was called
+	oldID     string // the ID replaced by RenewID, to delete
+}
+
+// Get returns the value of key, or "" if it has none.
+func (sess *Session) Get(key string) string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.Values[key]
+}
+
+// Set sets the value of key to value.
+func (sess *Session) Set(key, value string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.Values == nil {
+		sess.Values = make(map[string]string)
+	}
+	sess.Values[key] = value
+	sess.changed = true
+}
+
+// Delete removes key.
+func (sess *Session) Delete(key string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	delete(sess.Values, key)
+	sess.changed = true
+}
+
+// Destroy ends the session once the request is served: it is deleted
+// from the store, and the client told to forget its cookie.
+func (sess *Session) Destroy() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.destroyed = true
+}
+
+// RenewID gives the session a new ID, sent to the client in place of
+// the one it had, e.g. once the user logs in, so that an ID an
+// attacker got the client to use beforehand is worthless.
+func (sess *Session) RenewID() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if !sess.isNew && sess.oldID == "" {
+		sess.oldID = sess.ID
+	}
+	sess.ID = newSessionID()
+	sess.changed = true
+}
+
+// copySession returns a copy of the stored fields of sess, for a store
+// to keep apart from those the handlers change.
+func copySession(sess *Session) *Session {
+	return &Session{
+		ID:       sess.ID,
+		Values:   maps.Clone(sess.Values),
+		Created:  sess.Created,
+		Accessed: sess.Accessed,
+	}
+}
+
+// newSessionID returns a random session ID, of 256 bits in URL-safe
+// base64.
+func newSessionID() string {
+	var b [32]byte
+	_, _ = rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// isSessionID reports whether id could be one newSessionID returned,
+// and so used as a file name.
+func isSessionID(id string) bool {
+	if len(id) != base64.RawURLEncoding.EncodedLen(32) {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if c := id[i]; c != '-' && c != '_' && !isAlnum(id[i:i+1]) {
+			return false
+		}
+	}
+	return true
+}
+
+type sessionContextKey struct{}
+
+// SessionFromContext returns the Session the Handler of Sessions
+// attached to ctx, the Context of a request, or nil if there is none.
+func SessionFromContext(ctx context.Context) *Session {
+	sess, _ := ctx.Value(sessionContextKey{}).(*Session)
+	return sess
+}
+
+// SessionStore keeps the sessions of Sessions by ID. Its methods are
+// called concurrently; the sessions it is given and returns are its
+// own, not shared with the handlers.
+type SessionStore interface {
+	// Get returns the session of id, or nil if there is none.
+	Get(id string) (*Session, error)
+	// Put saves sess, in place of any of the same ID.
+	Put(sess *Session) error
+	// Delete removes the session of id, if any.
+	Delete(id string) error
+	// Prune removes the sessions expired reports true for.
+	Prune(expired func(sess *Session) bool) error
+}
+
+// MemorySessionStore is a SessionStore holding the sessions in memory,
+// lost when the process exits. The zero value is empty and ready to
+// use.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func (st *MemorySessionStore) Get(id string) (*Session, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if sess, ok := st.sessions[id]; ok {
+		return copySession(sess), nil
+	}
+	return nil, nil
+}
+
+func (st *MemorySessionStore) Put(sess *Session) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.sessions == nil {
+		st.sessions = make(map[string]*Session)
+	}
+	st.sessions[sess.ID] = copySession(sess)
+	return nil
+}
+
+func (st *MemorySessionStore) Delete(id string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.sessions, id)
+	return nil
+}
+
+func (st *MemorySessionStore) Prune(expired func(sess *Session) bool) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for id, sess := range st.sessions {
+		if expired(sess) {
+			delete(st.sessions, id)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of sessions st holds.
+func (st *MemorySessionStore) Len() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.sessions)
+}
+
+// FileSessionStore is a SessionStore keeping each session in a JSON
+// file of Dir, named by its ID, so that sessions outlive restarts and
+// are shared by the servers of the same host.
+type FileSessionStore struct {
+	Dir string
+}
+
+// path returns the name of the file of the session of id.
+func (st FileSessionStore) path(id string) (string, error) {
+	if !isSessionID(id) {
+		return "", fmt.Errorf("invalid session ID %q", id)
+	}
+	return filepath.Join(st.Dir, id+".json"), nil
+}
+
+func (st FileSessionStore) Get(id string) (*Session, error) {
+	name, err := st.path(id)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := readSessionFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return sess, err
+}
+
+func (st FileSessionStore) Put(sess *Session) error {
+	name, err := st.path(sess.ID)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(copySession(sess))
+	if err != nil {
+		return err
+	}
+	// Written whole, then renamed, so that Get never reads half of it
+	tmp, err := os.CreateTemp(st.Dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (st FileSessionStore) Delete(id string) error {
+	name, err := st.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (st FileSessionStore) Prune(expired func(sess *Session) bool) error {
+	entries, err := os.ReadDir(st.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !isSessionID(id) {
+			continue
+		}
+		name := filepath.Join(st.Dir, e.Name())
+		// A file that cannot be read is no session either
+		if sess, err := readSessionFile(name); err != nil || expired(sess) {
+			if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readSessionFile reads the session saved in the file name.
+func readSessionFile(name string) (*Session, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	sess := new(Session)
+	if err := json.Unmarshal(b, sess); err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	return sess, nil
+}
+
+// Sessions manages the sessions of the clients of the handlers its
+// Handler wraps. The ID of a session is sent in a cookie signed with
+// the first of Keys; a cookie whose signature none of Keys verifies is
+// ignored, so keys can be rotated by adding a new one in front.
+//
+// A session expires once unused for IdleTimeout, 30 minutes if 0, or
+// MaxLifetime after it started, 24 hours if 0, whichever comes first.
+// Only the sessions handlers set values in are saved and sent to the
+// client.
+//
+// The response of a handler setting its own Set-Cookie header keeps
+// it, without the session cookie, as a response holds a single value
+// of each header.
+//
+// A Sessions must not be copied once used.
+type Sessions struct {
+	Keys [][]byte
+
+	// Store keeps the sessions, in memory if nil.
+	Store SessionStore
+
+	// CookieName is the name of the cookie, "session" if "". The
+	// cookie is sent with the Path CookiePath, "/" if "", the Domain
+	// Domain if set, and the Secure attribute if Secure is set.
+	CookieName string
+	CookiePath string
+	Domain     string
+	Secure     bool
+
+	// SameSite is the SameSite attribute of the cookie, "Lax" if "".
+	SameSite string
+
+	IdleTimeout time.Duration
+	MaxLifetime time.Duration
+
+	// Clock, if set, supplies the time sessions expire by, in place
+	// of time.Now.
+	Clock func() time.Time
+
+	storeOnce sync.Once
+	store     SessionStore
+	lastPrune atomic.Int64 // in Unix nanoseconds
+	pruning   atomic.Bool
+}
+
+// Handler returns a Handler attaching the session of each request to
+// its Context, a new one if the client has none, before handing it to
+// next, then saving the session and sending its cookie:
+//
+//	func(req *tritonhttp.Request) *tritonhttp.Response {
+//		sess := tritonhttp.SessionFromContext(req.Context())
+//		sess.Set("user", "alice")
+//		...
+//	}
+//
+// It panics if m has no Keys.
+func (m *Sessions) Handler(next Handler) Handler {
+	if len(m.Keys) == 0 {
+		panic("tritonhttp: Sessions without Keys")
+	}
+	return HandlerFunc(func(req *Request) *Response {
+		now := m.now()
+		sess, err := m.load(req, now)
+		if err != nil {
+			return sessionError(req)
+		}
+		m.prune(now)
+		res := next.ServeRequest(req.WithContext(context.WithValue(req.Context(), sessionContextKey{}, sess)))
+		if res == nil {
+			return nil
+		}
+		if err := m.save(res, sess, now); err != nil {
+			_ = res.Close()
+			return sessionError(req)
+		}
+		return res
+	})
+}
+
+// sessionError returns the response to req when its session cannot be
+// loaded or saved.
+func sessionError(req *Request) *Response {
+	res := NewResponse(statusInternalServerError)
+	if req.Close {
+		res.Header["Connection"] = "close"
+	}
+	res.Text(statusInternalServerError, StatusText(statusInternalServerError)+"\n")
+	return res
+}
+
+// load returns the session of the cookie of req, or a new one if it
+// has none, or its session expired by now.
+func (m *Sessions) load(req *Request, now time.Time) (*Session, error) {
+	if id, ok := m.verify(cookieValue(req.Header["Cookie"], m.cookieName())); ok {
+		sess, err := m.sessionStore().Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if sess != nil && !m.expired(sess, now) {
+			return sess, nil
+		}
+		if sess != nil {
+			if err := m.sessionStore().Delete(id); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Session{ID: newSessionID(), Created: now, Accessed: now, isNew: true}, nil
+}
+
+// save saves sess, used by the request res answers, at now, and sets
+// the cookie res sends for it.
+func (m *Sessions) save(res *Response, sess *Session, now time.Time) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	st := m.sessionStore()
+	if sess.oldID != "" {
+		if err := st.Delete(sess.oldID); err != nil {
+			return err
+		}
+	}
+	switch {
+	case sess.destroyed:
+		if err := st.Delete(sess.ID); err != nil {
+			return err
+		}
+		if !sess.isNew || sess.oldID != "" {
+			m.setCookie(res, "", 0)
+		}
+		return nil
+	case sess.isNew && !sess.changed:
+		return nil
+	}
+	sess.Accessed = now
+	if err := st.Put(sess); err != nil {
+		return err
+	}
+	if sess.isNew || sess.oldID != "" {
+		maxAge := sess.Created.Add(m.maxLifetime()).Sub(now)
+		m.setCookie(res, m.sign(sess.ID), int(maxAge/time.Second))
+	}
+	return nil
+}
+
+// setCookie sets the session cookie of res to value, kept for maxAge
+// seconds, or removed if 0, unless res sets a cookie of its own.
+func (m *Sessions) setCookie(res *Response, value string, maxAge int) {
+	if _, ok := res.Header["Set-Cookie"]; ok {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(m.cookieName() + "=" + value)
+	b.WriteString("; Path=" + m.cookiePath())
+	if m.Domain != "" {
+		b.WriteString("; Domain=" + m.Domain)
+	}
+	b.WriteString("; Max-Age=" + strconv.Itoa(maxAge))
+	b.WriteString("; HttpOnly")
+	if m.Secure {
+		b.WriteString("; Secure")
+	}
+	b.WriteString("; SameSite=" + m.sameSite())
+	res.setHeader("Set-Cookie", b.String())
+	// The response is for this client only
+	res.setHeader("Cache-Control", "no-store")
+}
+
+// sign returns the value of the cookie of the session of id: id and its
+// signature by the first of Keys.
+func (m *Sessions) sign(id string) string {
+	return id + "." + sessionSignature(m.Keys[0], id)
+}
+
+// verify returns the session ID of the cookie value, and whether one
+// of Keys signed it.
+func (m *Sessions) verify(value string) (string, bool) {
+	id, sig, ok := strings.Cut(value, ".")
+	if !ok || !isSessionID(id) {
+		return "", false
+	}
+	for _, key := range m.Keys {
+		if hmac.Equal([]byte(sig), []byte(sessionSignature(key, id))) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// sessionSignature returns the HMAC-SHA256 of id with key, in URL-safe
+// base64.
+func sessionSignature(key []byte, id string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// expired reports whether sess is expired at now.
+func (m *Sessions) expired(sess *Session, now time.Time) bool {
+	return now.Sub(sess.Accessed) > m.idleTimeout() || now.Sub(sess.Created) > m.maxLifetime()
+}
+
+// prune removes the expired sessions from the store, in the
+// background, at most once every IdleTimeout.
+func (m *Sessions) prune(now time.Time) {
+	last := m.lastPrune.Load()
+	if last == 0 {
+		// Counted from the first request
+		m.lastPrune.CompareAndSwap(0, now.UnixNano())
+		return
+	}
+	if now.Sub(time.Unix(0, last)) < m.idleTimeout() || !m.pruning.CompareAndSwap(false, true) {
+		return
+	}
+	m.lastPrune.Store(now.UnixNano())
+	go func() {
+		defer m.pruning.Store(false)
+		_ = m.sessionStore().Prune(func(sess *Session) bool { return m.expired(sess, now) })
+	}()
+}
+
+// sessionStore returns Store, or the memory store of m if nil.
+func (m *Sessions) sessionStore() SessionStore {
+	if m.Store != nil {
+		return m.Store
+	}
+	m.storeOnce.Do(func() { m.store = new(MemorySessionStore) })
+	return m.store
+}
+
+func (m *Sessions) now() time.Time {
+	if m.Clock != nil {
+		return m.Clock()
+	}
+	return time.Now()
+}
+
+func (m *Sessions) cookieName() string {
+	if m.CookieName != "" {
+		return m.CookieName
+	}
+	return defaultSessionCookie
+}
+
+func (m *Sessions) cookiePath() string {
+	if m.CookiePath != "" {
+		return m.CookiePath
+	}
+	return "/"
+}
+
+func (m *Sessions) sameSite() string {
+	if m.SameSite != "" {
+		return m.SameSite
+	}
+	return "Lax"
+}
+
+func (m *Sessions) idleTimeout() time.Duration {
+	if m.IdleTimeout > 0 {
+		return m.IdleTimeout
+	}
+	return defaultSessionIdleTimeout
+}
+
+func (m *Sessions) maxLifetime() time.Duration {
+	if m.MaxLifetime > 0 {
+		return m.MaxLifetime
+	}
+	return defaultSessionMaxLifetime
+}
+
+// cookieValue returns the value of the cookie name in the Cookie
+// header h, e.g. "a=1; b=2", or "" if it has none.
+func cookieValue(h, name string) string {
+	for _, c := range strings.Split(h, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(c), "=")
+		if ok && k == name {
+			return strings.Trim(v, `"`)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,188 @@
+package tritonhttp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionHandler is a handler of tests: it answers with the value of
+// "user" in the session, after acting on the query of the request.
+var sessionHandler = HandlerFunc(func(req *Request) *Response {
+	sess := SessionFromContext(req.Context())
+	_, query, _ := strings.Cut(req.URL, "?")
+	switch {
+	case strings.HasPrefix(query, "user="):
+		sess.Set("user", strings.TrimPrefix(query, "user="))
+	case query == "login":
+		sess.RenewID()
+	case query == "logout":
+		sess.Destroy()
+	}
+	res := NewResponse(statusOK)
+	res.Text(statusOK, sess.Get("user"))
+	return res
+})
+
+func TestSessions(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := new(MemorySessionStore)
+	m := &Sessions{
+		Keys:        [][]byte{[]byte("0123456789abcdef")},
+		Store:       store,
+		IdleTimeout: time.Hour,
+		MaxLifetime: 3 * time.Hour,
+		Clock:       func() time.Time { return now },
+	}
+	h := m.Handler(sessionHandler)
+	var cookie string
+	get := func(url string) (body, setCookie string) {
+		t.Helper()
+		req := &Request{Method: "GET", URL: url, Proto: "HTTP/1.1", Header: map[string]string{}}
+		if cookie != "" {
+			req.Header["Cookie"] = "theme=dark; " + cookie
+		}
+		res := h.ServeRequest(req)
+		if res.StatusCode != statusOK {
+			t.Fatalf("GET %v got status %v", url, res.StatusCode)
+		}
+		setCookie = res.Header["Set-Cookie"]
+		if c, _, _ := strings.Cut(setCookie, ";"); c != "" {
+			cookie = c
+		}
+		return string(res.Body), setCookie
+	}
+
+	if body, set := get("/"); body != "" || set != "" || store.Len() != 0 {
+		t.Fatalf("unused session got body %q, Set-Cookie %q, %v stored", body, set, store.Len())
+	}
+	_, set := get("/?user=alice")
+	want := "; Path=/; Max-Age=10800; HttpOnly; SameSite=Lax"
+	if !strings.HasPrefix(set, "session=") || !strings.HasSuffix(set, want) {
+		t.Fatalf("got Set-Cookie %q, want session=...%v", set, want)
+	}
+	now = now.Add(30 * time.Minute)
+	if body, set := get("/"); body != "alice" || set != "" {
+		t.Fatalf("got body %q, Set-Cookie %q, want alice and no cookie", body, set)
+	}
+
+	// Idle for 50 minutes, not an hour, since the last request
+	now = now.Add(50 * time.Minute)
+	if body, _ := get("/"); body != "alice" {
+		t.Fatalf("got body %q after 50 minutes, want alice", body)
+	}
+
+	// Tampered with
+	saved := cookie
+	cookie = cookie[:len(cookie)-1] + "A"
+	if cookie == saved {
+		cookie = cookie[:len(cookie)-1] + "B"
+	}
+	if body, _ := get("/"); body != "" {
+		t.Fatalf("tampered cookie got body %q, want none", body)
+	}
+	cookie = saved
+
+	// A new ID, the old one forgotten
+	_, set = get("/?login")
+	if set == "" || cookie == saved {
+		t.Fatalf("RenewID got Set-Cookie %q, want a new cookie", set)
+	}
+	if store.Len() != 1 {
+		t.Fatalf("RenewID left %v sessions, want 1", store.Len())
+	}
+	if body, _ := get("/"); body != "alice" {
+		t.Fatalf("renewed session got body %q, want alice", body)
+	}
+
+	// Past its lifetime of 3 hours, however active
+	for i := 0; i < 4; i++ {
+		now = now.Add(30 * time.Minute)
+		get("/")
+	}
+	if body, _ := get("/"); body != "" {
+		t.Fatalf("session past its lifetime got body %q, want none", body)
+	}
+	if store.Len() != 0 {
+		t.Fatalf("expired session kept in the store")
+	}
+
+	get("/?user=bob")
+	_, set = get("/?logout")
+	if !strings.HasPrefix(set, "session=; Path=/; Max-Age=0;") || store.Len() != 0 {
+		t.Fatalf("Destroy got Set-Cookie %q and %v sessions, want the cookie removed", set, store.Len())
+	}
+}
+
+func TestSessionsKeyRotation(t *testing.T) {
+	oldKey, newKey := []byte("old key of tests"), []byte("new key of tests")
+	store := new(MemorySessionStore)
+	sess := &Session{ID: newSessionID(), Values: map[string]string{"user": "alice"}, Created: time.Now(), Accessed: time.Now()}
+	store.Put(sess)
+	var tests = []struct {
+		name string
+		keys [][]byte
+		want string
+	}{
+		{"Old", [][]byte{oldKey}, "alice"},
+		{"Rotated", [][]byte{newKey, oldKey}, "alice"},
+		{"Retired", [][]byte{newKey}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Sessions{Keys: tt.keys, Store: store, CookieName: "sid"}
+			req := &Request{Method: "GET", URL: "/", Header: map[string]string{
+				"Cookie": "sid=" + sess.ID + "." + sessionSignature(oldKey, sess.ID),
+			}}
+			if res := m.Handler(sessionHandler).ServeRequest(req); string(res.Body) != tt.want {
+				t.Errorf("got body %q, want %q", res.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileSessionStore(t *testing.T) {
+	st := FileSessionStore{Dir: t.TempDir()}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	a := &Session{ID: newSessionID(), Values: map[string]string{"k": "v"}, Created: now, Accessed: now}
+	b := &Session{ID: newSessionID(), Created: now, Accessed: now.Add(time.Hour)}
+	for _, sess := range []*Session{a, b} {
+		if err := st.Put(sess); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := st.Get(a.ID)
+	if err != nil || got == nil || got.Values["k"] != "v" || !got.Created.Equal(now) {
+		t.Fatalf("Get got %+v, %v, want the session put", got, err)
+	}
+	if got, err := st.Get(newSessionID()); got != nil || err != nil {
+		t.Fatalf("Get of a missing session got %+v, %v", got, err)
+	}
+	if _, err := st.Get("../escape"); err == nil {
+		t.Fatalf("Get of an invalid ID got no error")
+	}
+
+	os.WriteFile(filepath.Join(st.Dir, "notes.txt"), nil, 0o644)
+	err = st.Prune(func(sess *Session) bool { return sess.Accessed.Before(now.Add(time.Minute)) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.Get(a.ID); got != nil {
+		t.Errorf("Prune kept the expired session")
+	}
+	if got, _ := st.Get(b.ID); got == nil {
+		t.Errorf("Prune removed the live session")
+	}
+	if _, err := os.Stat(filepath.Join(st.Dir, "notes.txt")); err != nil {
+		t.Errorf("Prune removed a file not of a session: %v", err)
+	}
+
+	if err := st.Delete(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Delete(b.ID); err != nil {
+		t.Fatalf("Delete of a missing session got %v", err)
+	}
+}
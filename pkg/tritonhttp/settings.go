@@ -0,0 +1,120 @@
+package tritonhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Settings are the settings that can be changed while the server is
+// running, without restarting it or re-binding its listeners.
+// Changes apply to the connections and requests that follow.
+type Settings struct {
+	LogLevel     LogLevel
+	Limits       Limits
+	BanPolicy    BanPolicy
+	Quota        BandwidthQuota
+	LoadShedding LoadShedding
+
+	// Maintenance answers every request with 503 Service Unavailable
+	// and a Retry-After of MaintenanceRetryAfter.
+	Maintenance           bool
+	MaintenanceRetryAfter time.Duration
+}
+
+// Settings returns the settings currently in effect. Until
+// UpdateSettings is first called, they come from the Server fields.
+func (s *Server) Settings() Settings {
+	return s.current()
+}
+
+// UpdateSettings atomically changes the settings in effect by calling
+// update on a copy of them. If update fails, its error is returned;
+// if the result is invalid, a *ValidationError is. Either way nothing
+// changes. From then on, the Limits,
+// BanPolicy, Quota and LoadShedding fields of s are no longer used.
+// The log level can only be changed if Logger is nil or implements
+// LevelSetter.
+func (s *Server) UpdateSettings(update func(*Settings) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.current()
+	st := old
+	if err := update(&st); err != nil {
+		return err
+	}
+
+	v := &validation{}
+	st.Limits.validate(v, "Limits.")
+	validatePolicies(v, st.BanPolicy, st.Quota, st.LoadShedding)
+	v.check(st.MaintenanceRetryAfter < 0, "MaintenanceRetryAfter", "must not be negative")
+	if st.LogLevel != old.LogLevel {
+		if _, ok := levelNames[st.LogLevel]; !ok {
+			v.add("LogLevel", fmt.Errorf("unknown log level %v", st.LogLevel))
+		} else if _, ok := s.logger().(LevelSetter); !ok && s.Logger != nil {
+			v.add("LogLevel", fmt.Errorf("Logger %T cannot change its level", s.Logger))
+		}
+	}
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	if st.LogLevel != old.LogLevel {
+		if ls, ok := s.Logger.(LevelSetter); ok {
+			ls.SetLevel(st.LogLevel)
+		} else if s.Logger == nil {
+			s.fallbackLog.Store(NewLogger(nil, st.LogLevel).(*levelLogger))
+		}
+	}
+	s.settings.Store(&st)
+	s.logger().Infof("Settings changed to %+v", st)
+	return nil
+}
+
+// current returns the settings in effect.
+func (s *Server) current() Settings {
+	if st := s.settings.Load(); st != nil {
+		return *st
+	}
+	level := LevelWarn
+	if ls, ok := s.logger().(LevelSetter); ok {
+		level = ls.Level()
+	}
+	return Settings{
+		LogLevel:     level,
+		Limits:       s.Limits,
+		BanPolicy:    s.BanPolicy,
+		Quota:        s.Quota,
+		LoadShedding: s.LoadShedding,
+	}
+}
+
+// serveAdminSettings shows the settings in effect as JSON on GET, and
+// changes them on PUT to the fields of the JSON object in the body.
+func (s *Server) serveAdminSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		err := s.UpdateSettings(func(st *Settings) error {
+			return json.NewDecoder(r.Body).Decode(st)
+		})
+		var ve *ValidationError
+		if errors.As(err, &ve) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		} else if err != nil {
+			http.Error(w, "invalid settings: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(s.Settings())
+}
@@ -0,0 +1,115 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateSettings(t *testing.T) {
+	s := &Server{Limits: Limits{MaxConns: 50, MaxConnsPerIP: 5}}
+	if got := s.Settings().Limits.MaxConns; got != 50 {
+		t.Fatalf("initial MaxConns got: %v, want: 50", got)
+	}
+
+	err := s.UpdateSettings(func(st *Settings) error {
+		st.Limits.ReadTimeout = time.Second
+		st.LogLevel = LevelDebug
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := s.Settings()
+	if st.Limits.MaxConns != 50 || st.Limits.ReadTimeout != time.Second {
+		t.Fatalf("limits got: %+v", st.Limits)
+	}
+	if got := s.logger().(LevelSetter).Level(); got != LevelDebug {
+		t.Fatalf("log level got: %v, want: %v", got, LevelDebug)
+	}
+	if defaultLogger.(LevelSetter).Level() != LevelWarn {
+		t.Fatal("changing the level of one server changed the default logger")
+	}
+
+	// Invalid or failed updates change nothing
+	err = s.UpdateSettings(func(st *Settings) error {
+		st.Limits.ReadTimeout = -1
+		return nil
+	})
+	var ve *ValidationError
+	if !errors.As(err, &ve) || ve.Errors[0].Field != "Limits.ReadTimeout" {
+		t.Fatalf("invalid update got: %v", err)
+	}
+	boom := errors.New("boom")
+	if err := s.UpdateSettings(func(st *Settings) error { st.Maintenance = true; return boom }); err != boom {
+		t.Fatalf("failed update got: %v, want: %v", err, boom)
+	}
+	if got := s.Settings(); got != st {
+		t.Fatalf("settings got: %+v, want unchanged: %+v", got, st)
+	}
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	s := &Server{DocRoot: "testdata"}
+	err := s.UpdateSettings(func(st *Settings) error {
+		st.Maintenance = true
+		st.MaintenanceRetryAfter = 30 * time.Second
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	go s.HandleConnection(server)
+	go client.Write([]byte("GET /index.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"))
+	resp, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, _ := bufio.NewReader(strings.NewReader(string(resp))).ReadString('\n')
+	if status != "HTTP/1.1 503 Service Unavailable\r\n" || !strings.Contains(string(resp), "Retry-After: 30\r\n") {
+		t.Fatalf("response got: %q", resp)
+	}
+}
+
+func TestAdminSettings(t *testing.T) {
+	s := &Server{}
+	mux := s.adminMux()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("PUT", "/admin/settings", strings.NewReader(`{"LogLevel": "INFO", "Maintenance": true}`)))
+	if rec.Code != 200 {
+		t.Fatalf("PUT status got: %v, body: %v", rec.Code, rec.Body)
+	}
+	if st := s.Settings(); !st.Maintenance || st.LogLevel != LevelInfo {
+		t.Fatalf("settings after PUT got: %+v", st)
+	}
+
+	var tests = []struct {
+		body     string
+		wantCode int
+	}{
+		{`{"LogLevel": "LOUD"}`, 400},
+		{`not json`, 400},
+		{`{"Quota": {"Bytes": 100}}`, 422},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("PUT", "/admin/settings", strings.NewReader(tt.body)))
+		if rec.Code != tt.wantCode {
+			t.Fatalf("PUT %v status got: %v, want: %v", tt.body, rec.Code, tt.wantCode)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/settings", nil))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"LogLevel": "INFO"`) {
+		t.Fatalf("GET got: %v %v", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,73 @@
+package tritonhttp
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// latencyWeight is the weight of the newest sample in the
+// exponentially weighted moving average of response latency.
+const latencyWeight = 0.1
+
+// LoadShedding configures how the server sheds load when overloaded.
+// The server counts as overloaded when any of the non-zero thresholds
+// is exceeded; while overloaded, Fraction of the requests are answered
+// with 503 Service Unavailable and a Retry-After header instead of
+// being served. A zero Fraction disables load shedding.
+type LoadShedding struct {
+	MaxConns    int           // open connections, standing in for the accept queue depth
+	MaxInFlight int           // requests being handled at the same time
+	MaxLatency  time.Duration // moving average of request handling time
+
+	Fraction   float64       // share of requests to shed, in (0, 1]
+	RetryAfter time.Duration // value of the Retry-After header
+}
+
+// loadMonitor keeps the load signals load shedding decides on.
+type loadMonitor struct {
+	mu       sync.Mutex
+	inFlight int
+	latency  time.Duration // moving average
+}
+
+// begin records the start of a request.
+func (m *loadMonitor) begin() {
+	m.mu.Lock()
+	m.inFlight++
+	m.mu.Unlock()
+}
+
+// end records the end of a request that took d to handle.
+func (m *loadMonitor) end(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight--
+	if m.latency == 0 {
+		m.latency = d
+	} else {
+		m.latency = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(m.latency))
+	}
+}
+
+// overloaded reports whether any threshold in ls is exceeded,
+// given conns currently open connections.
+func (m *loadMonitor) overloaded(ls LoadShedding, conns int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return (ls.MaxConns > 0 && conns > ls.MaxConns) ||
+		(ls.MaxInFlight > 0 && m.inFlight > ls.MaxInFlight) ||
+		(ls.MaxLatency > 0 && m.latency > ls.MaxLatency)
+}
+
+// shouldShed decides whether the request about to be handled is shed
+// according to ls.
+func (s *Server) shouldShed(ls LoadShedding) bool {
+	if ls.Fraction <= 0 {
+		return false
+	}
+	if !s.load.overloaded(ls, s.conns.count()) {
+		return false
+	}
+	return rand.Float64() < ls.Fraction
+}
@@ -0,0 +1,32 @@
+package tritonhttp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadMonitorOverloaded(t *testing.T) {
+	var tests = []struct {
+		name     string
+		ls       LoadShedding
+		inFlight int
+		latency  time.Duration
+		conns    int
+		want     bool
+	}{
+		{"Idle", LoadShedding{MaxConns: 10, MaxInFlight: 10, MaxLatency: time.Second}, 0, 0, 0, false},
+		{"TooManyConns", LoadShedding{MaxConns: 10}, 0, 0, 11, true},
+		{"TooManyInFlight", LoadShedding{MaxInFlight: 2}, 3, 0, 3, true},
+		{"TooSlow", LoadShedding{MaxLatency: time.Second}, 1, 2 * time.Second, 1, true},
+		{"NoThresholds", LoadShedding{}, 100, time.Hour, 100, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &loadMonitor{inFlight: tt.inFlight, latency: tt.latency}
+			if got := m.overloaded(tt.ls, tt.conns); got != tt.want {
+				t.Fatalf("got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package tritonhttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ErrServerClosed is returned by ListenAndServe and Serve
+// once Shutdown has been called.
+var ErrServerClosed = errors.New("tritonhttp: Server closed")
+
+// shutdownPollInterval is how often Shutdown checks for idle
+// connections to close while draining.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// newConnGrace is how long Shutdown leaves a connection just accepted
+// to send its first request before closing it, as net/http does, so
+// that a client connecting as the server stops is not cut off before
+// it could say anything.
+const newConnGrace = 5 * time.Second
+
+// closedChan is a closed channel, for a wait that is already over.
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// Shutdown gracefully stops the server: it closes the listeners so no
+// new connections are accepted, closes idle connections, and those
+// accepted that sent no request within a few seconds, and waits for
+// the requests being handled to finish, closing their connections
+// afterwards. The functions registered with RegisterOnShutdown run
+// concurrently with draining, and Shutdown waits for them too.
+// If ctx expires first, the remaining connections are
+// force-closed and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var hooksDone <-chan struct{} = closedChan
+	if !s.inShutdown.Swap(true) {
+		hooksDone = s.runShutdownHooks()
+	}
+	err := s.closeListeners()
+	if admin := s.adminServer(); admin != nil {
+		if adminErr := admin.Shutdown(ctx); err == nil {
+			err = adminErr
+		}
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.tracker.closeIdle() {
+			select {
+			case <-hooksDone:
+				return err
+			default:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			_ = s.tracker.closeAll()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes the listeners, the admin listener and all
+// open connections, interrupting in-flight requests. It returns the
+// errors from closing them joined together. For a graceful stop use
+// Shutdown.
+func (s *Server) Close() error {
+	s.inShutdown.Store(true)
+	errs := []error{s.closeListeners()}
+	if admin := s.adminServer(); admin != nil {
+		errs = append(errs, admin.Close())
+	}
+	errs = append(errs, s.tracker.closeAll())
+	return errors.Join(errs...)
+}
+
+// shuttingDown reports whether Shutdown has been called.
+func (s *Server) shuttingDown() bool {
+	return s.inShutdown.Load()
+}
+
+// trackListener adds ln to, or removes it from, the listeners closed
+// on shutdown. Adding fails once the server is shutting down.
+func (s *Server) trackListener(ln net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.listeners, ln)
+		return true
+	}
+	if s.shuttingDown() {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[ln] = struct{}{}
+	return true
+}
+
+// closeListeners closes all listeners, returning their errors joined.
+func (s *Server) closeListeners() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for ln := range s.listeners {
+		errs = append(errs, ln.Close())
+		delete(s.listeners, ln)
+	}
+	return errors.Join(errs...)
+}
+
+// closeIdle closes the connections waiting for another request, and
+// those waiting for their first for newConnGrace already, and reports
+// whether no connection was open at all.
+func (ct *connTracker) closeIdle() bool {
+	ct.each(func(conn net.Conn, info *connInfo) bool {
+		switch info.loadState() {
+		case StateIdle:
+			_ = conn.Close()
+		case StateNew:
+			if time.Since(info.accepted) >= newConnGrace {
+				_ = conn.Close()
+			}
+		}
+		return true
+	})
+	return atomic.LoadInt64(&ct.open) == 0
+}
+
+// closeAll closes every open connection, returning the errors joined.
+func (ct *connTracker) closeAll() error {
+	var errs []error
+	ct.each(func(conn net.Conn, info *connInfo) bool {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+		return true
+	})
+	return errors.Join(errs...)
+}
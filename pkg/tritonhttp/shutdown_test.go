@@ -0,0 +1,195 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startTestServer serves s on a loopback port and returns its address
+// and a channel receiving the result of Serve.
+func startTestServer(t *testing.T, s *Server) (string, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ln) }()
+	return ln.Addr().String(), done
+}
+
+func TestShutdownClosesIdle(t *testing.T) {
+	s := &Server{DocRoot: t.TempDir()}
+	addr, done := startTestServer(t, s)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// A request answered leaves the connection idle, waiting for the next
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	if _, err := ReadResponse(br, &Request{Method: "GET"}); err != nil {
+		t.Fatal(err)
+	}
+	for s.Stats().IdleConns == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown got error: %v", err)
+	}
+	if err := <-done; err != ErrServerClosed {
+		t.Fatalf("Serve got: %v, want: %v", err, ErrServerClosed)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("idle conn read got: %v, want: EOF", err)
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatal("dial after Shutdown succeeded")
+	}
+}
+
+func TestShutdownNewConnGrace(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("home"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{DocRoot: dir}
+	addr, done := startTestServer(t, s)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for s.Stats().IdleConns == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A connection yet to send its first request is left to send it
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	<-done
+	time.Sleep(5 * shutdownPollInterval)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, "GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	res, err := ReadResponse(br, &Request{Method: "GET"})
+	if err != nil {
+		t.Fatalf("new conn closed by Shutdown: %v", err)
+	}
+	if body, _ := io.ReadAll(res.BodyReader); res.StatusCode != 200 || string(body) != "home" {
+		t.Fatalf("got %v %q, want 200 home", res.StatusCode, body)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown got error: %v", err)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("conn read after its request got: %v, want: EOF", err)
+	}
+}
+
+func TestCloseIdleNewConns(t *testing.T) {
+	var ct connTracker
+	fresh, freshPeer := net.Pipe()
+	defer freshPeer.Close()
+	stale, stalePeer := net.Pipe()
+	defer stalePeer.Close()
+	ct.set(fresh, StateNew)
+	ct.set(stale, StateNew)
+	ct.info(stale).accepted = time.Now().Add(-newConnGrace)
+
+	if ct.closeIdle() {
+		t.Fatal("closeIdle reported no open connection")
+	}
+	stalePeer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := stalePeer.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("conn past the grace period read got: %v, want: EOF", err)
+	}
+	freshPeer.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := freshPeer.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("conn in the grace period read got: %v, want it left open", err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	s := &Server{DocRoot: t.TempDir()}
+	addr, done := startTestServer(t, s)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// A partial request keeps the connection active
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	for s.Stats().ActiveConns == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown got: %v, want: %v", err, context.DeadlineExceeded)
+	}
+	<-done
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("active conn still open after forced shutdown")
+	}
+}
+
+func TestServeAfterShutdown(t *testing.T) {
+	s := &Server{}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(ln); err != ErrServerClosed {
+		t.Fatalf("Serve got: %v, want: %v", err, ErrServerClosed)
+	}
+}
+
+func TestClose(t *testing.T) {
+	s := &Server{DocRoot: t.TempDir()}
+	addr, done := startTestServer(t, s)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	for s.Stats().ActiveConns == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close got error: %v", err)
+	}
+	if err := <-done; err != ErrServerClosed {
+		t.Fatalf("Serve got: %v, want: %v", err, ErrServerClosed)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("active conn still open after Close")
+	}
+}
@@ -0,0 +1,131 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// TrailingSlash is the policy of a mount, the doc root or a Route, on
+// the trailing slash of request paths. The policies but SlashAsIs treat
+// files and directories alike: "/docs" and "/docs/" both name the
+// directory docs, served as its index.html, and "/a.html" and
+// "/a.html/" both name the file a.html.
+type TrailingSlash int
+
+const (
+	// SlashAsIs serves the paths ending in "/" as directories, and
+	// the others as files.
+	SlashAsIs TrailingSlash = iota
+	// SlashAdd redirects the paths not ending in "/" to add one.
+	SlashAdd
+	// SlashStrip redirects the paths ending in "/" to strip it.
+	SlashStrip
+	// SlashAccept serves both forms of the paths.
+	SlashAccept
+)
+
+var trailingSlashNames = map[TrailingSlash]string{
+	SlashAsIs:   "as-is",
+	SlashAdd:    "add",
+	SlashStrip:  "strip",
+	SlashAccept: "accept",
+}
+
+func (ts TrailingSlash) String() string {
+	if name, ok := trailingSlashNames[ts]; ok {
+		return name
+	}
+	return fmt.Sprintf("TrailingSlash(%d)", int(ts))
+}
+
+func (ts TrailingSlash) valid() bool {
+	_, ok := trailingSlashNames[ts]
+	return ok
+}
+
+// ParseTrailingSlash returns the policy named s: "as-is" or "",
+// "add", "strip" or "accept".
+func ParseTrailingSlash(s string) (TrailingSlash, error) {
+	if s == "" {
+		return SlashAsIs, nil
+	}
+	for ts, name := range trailingSlashNames {
+		if strings.EqualFold(s, name) {
+			return ts, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown trailing slash policy %q", s)
+}
+
+// redirect returns the redirect of req to the form of its path ts
+// wants, with its leading slashes collapsed into one, or nil if its
+// path has that form. The root path is always left alone.
+func (ts TrailingSlash) redirect(req *Request) *Response {
+	urlPath, query, hasQuery := strings.Cut(req.URL, "?")
+	if urlPath == "/" {
+		return nil
+	}
+	hasSlash := strings.HasSuffix(urlPath, "/")
+	switch {
+	case ts == SlashAdd && !hasSlash:
+		urlPath += "/"
+	case ts == SlashStrip && hasSlash:
+		urlPath = strings.TrimRight(urlPath, "/")
+		if urlPath == "" {
+			urlPath = "/"
+		}
+	default:
+		return nil
+	}
+	// A Location starting with "//" would be that of another host,
+	// e.g. the "//evil.example" of "//evil.example/"
+	urlPath = "/" + strings.TrimLeft(urlPath, "/")
+	if hasQuery {
+		urlPath += "?" + query
+	}
+	res := &Response{}
+	res.HandleRedirect(req, statusMovedPermanently, urlPath)
+	return res
+}
+
+// trailingSlash applies TrailingSlash to req, to be served from root:
+// it returns the redirect to the form of its path the policy wants, if
+// req names a file or a directory with an index.html in the other, or
+// sets req.URL to the form that serves it, and returns nil.
+func (s *Server) trailingSlash(req *Request, root string) *Response {
+	if s.TrailingSlash == SlashAsIs {
+		return nil
+	}
+	urlPath, query, hasQuery := strings.Cut(req.URL, "?")
+	bare := strings.TrimRight(urlPath, "/")
+	if bare == "" {
+		return nil
+	}
+	name, ok := fileName(root, bare)
+	if !ok {
+		return nil
+	}
+	fi, err := s.fileSystem().Stat(name)
+	if err != nil {
+		return nil
+	}
+	if fi.IsDir() {
+		if _, err := s.fileSystem().Stat(filepath.Join(name, "index.html")); err != nil {
+			return nil
+		}
+	}
+	if res := s.TrailingSlash.redirect(req); res != nil {
+		return res
+	}
+	// Serve the path as what it names, whatever its form
+	urlPath = bare
+	if fi.IsDir() {
+		urlPath += "/"
+	}
+	if hasQuery {
+		urlPath += "?" + query
+	}
+	req.URL = urlPath
+	return nil
+}
@@ -0,0 +1,139 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTrailingSlash(t *testing.T) {
+	root := filepath.FromSlash("/srv/www")
+	fsys := MountFS(fstest.MapFS{
+		"index.html":      {Data: []byte("home")},
+		"a.html":          {Data: []byte("a")},
+		"docs/index.html": {Data: []byte("docs")},
+		"empty/x.js":      {Data: []byte("x")},
+	}, root)
+
+	var tests = []struct {
+		policy       TrailingSlash
+		url          string
+		wantStatus   int
+		wantBody     string
+		wantLocation string
+	}{
+		{SlashAsIs, "/docs", 404, "", ""},
+		{SlashAsIs, "/docs/", 200, "docs", ""},
+		{SlashAsIs, "/a.html/", 404, "", ""},
+
+		{SlashAdd, "/docs", 301, "", "/docs/"},
+		{SlashAdd, "/docs?x=1", 301, "", "/docs/?x=1"},
+		{SlashAdd, "/docs/", 200, "docs", ""},
+		{SlashAdd, "/a.html", 301, "", "/a.html/"},
+		{SlashAdd, "/a.html/", 200, "a", ""},
+		{SlashAdd, "/", 200, "home", ""},
+		{SlashAdd, "/missing", 404, "", ""},
+		{SlashAdd, "/empty", 404, "", ""},
+
+		{SlashStrip, "/docs/", 301, "", "/docs"},
+		{SlashStrip, "/docs", 200, "docs", ""},
+		{SlashStrip, "/a.html/", 301, "", "/a.html"},
+		{SlashStrip, "/a.html", 200, "a", ""},
+		{SlashStrip, "/", 200, "home", ""},
+		{SlashStrip, "/missing/", 404, "", ""},
+
+		{SlashAccept, "/docs", 200, "docs", ""},
+		{SlashAccept, "/docs/", 200, "docs", ""},
+		{SlashAccept, "/a.html", 200, "a", ""},
+		{SlashAccept, "/a.html/", 200, "a", ""},
+		{SlashAccept, "/empty/", 404, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String()+tt.url, func(t *testing.T) {
+			s := &Server{DocRoot: root, FS: fsys, TrailingSlash: tt.policy, ErrorLog: NewLogger(nil, LevelError)}
+			res := s.HandleGoodRequest(&Request{Method: "GET", URL: tt.url, Proto: "HTTP/1.1", Header: map[string]string{}, Host: "test"})
+			var body bytes.Buffer
+			if err := res.WriteBody(&body); err != nil {
+				t.Fatalf("WriteBody: %v", err)
+			}
+			if res.StatusCode != tt.wantStatus || body.String() != tt.wantBody {
+				t.Fatalf("got: %v %q, want: %v %q", res.StatusCode, body.String(), tt.wantStatus, tt.wantBody)
+			}
+			if got := res.Header["Location"]; got != tt.wantLocation {
+				t.Errorf("got Location %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestRouteTrailingSlash(t *testing.T) {
+	echo := HandlerFunc(func(req *Request) *Response {
+		res := &Response{}
+		res.HandleNotFound(req)
+		res.StatusCode = statusOK
+		return res
+	})
+	var tests = []struct {
+		policy     TrailingSlash
+		url        string
+		wantStatus int
+	}{
+		{SlashAsIs, "/api/x", 200},
+		{SlashAdd, "/api/x", 301},
+		{SlashAdd, "/api/x/", 200},
+		{SlashStrip, "/api/x/", 301},
+		{SlashStrip, "/api/x", 200},
+		{SlashAccept, "/api/x/", 200},
+	}
+	for _, tt := range tests {
+		r := &Route{Prefix: "/api", TrailingSlash: tt.policy, Handler: echo}
+		res := r.serve(&Request{Method: "GET", URL: tt.url, Proto: "HTTP/1.1", Header: map[string]string{}, Host: "test"})
+		if res.StatusCode != tt.wantStatus {
+			t.Errorf("%v %v: got status %v, want %v", tt.policy, tt.url, res.StatusCode, tt.wantStatus)
+		}
+	}
+}
+
+func TestTrailingSlashOpenRedirect(t *testing.T) {
+	echo := HandlerFunc(func(req *Request) *Response {
+		return NewResponse(statusOK)
+	})
+	var tests = []struct {
+		policy       TrailingSlash
+		url          string
+		wantLocation string
+	}{
+		{SlashStrip, "//evil.example/", "/evil.example"},
+		{SlashStrip, "///evil.example/?x=1", "/evil.example?x=1"},
+		{SlashAdd, "//evil.example", "/evil.example/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String()+tt.url, func(t *testing.T) {
+			s := &Server{
+				DocRoot:  t.TempDir(),
+				ErrorLog: NewLogger(nil, LevelError),
+				Routes:   []Route{{Prefix: "/", TrailingSlash: tt.policy, Handler: echo}},
+			}
+			addr, _ := startTestServer(t, s)
+			res := exchangeRaw(t, addr, "GET "+tt.url+" HTTP/1.1\r\nHost: test\r\n\r\n", 1)[0]
+			if res.StatusCode != 301 || res.Header["Location"] != tt.wantLocation {
+				t.Errorf("got %v to %q, want 301 to %q", res.StatusCode, res.Header["Location"], tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestParseTrailingSlash(t *testing.T) {
+	for ts, name := range trailingSlashNames {
+		if got, err := ParseTrailingSlash(name); err != nil || got != ts {
+			t.Errorf("%q: got %v, %v, want %v", name, got, err, ts)
+		}
+	}
+	if got, err := ParseTrailingSlash(""); err != nil || got != SlashAsIs {
+		t.Errorf("empty: got %v, %v, want %v", got, err, SlashAsIs)
+	}
+	if _, err := ParseTrailingSlash("sometimes"); err == nil {
+		t.Errorf("unknown policy parsed")
+	}
+}
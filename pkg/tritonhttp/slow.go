@@ -0,0 +1,25 @@
+package tritonhttp
+
+import "time"
+
+// phases breaks down the time spent on a request.
+type phases struct {
+	read   time.Duration // from the first byte to the end of the headers
+	handle time.Duration // building the response
+	write  time.Duration // writing the response
+}
+
+func (p phases) total() time.Duration {
+	return p.read + p.handle + p.write
+}
+
+// checkSlow logs and counts req if it took longer than
+// s.SlowRequestThreshold.
+func (s *Server) checkSlow(req *Request, p phases) {
+	if s.SlowRequestThreshold <= 0 || p.total() <= s.SlowRequestThreshold {
+		return
+	}
+	s.tracker.slow()
+	s.errorLog().Warnf("Slow request %v %v from %v: %v (read %v, handle %v, write %v)",
+		req.Method, req.URL, req.RemoteAddr, p.total(), p.read, p.handle, p.write)
+}
@@ -0,0 +1,32 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckSlow(t *testing.T) {
+	var errs bytes.Buffer
+	s := &Server{
+		ErrorLog:             NewLogger(log.New(&errs, "", 0), LevelDebug),
+		SlowRequestThreshold: time.Second,
+	}
+	req := &Request{Method: "GET", URL: "/index.html", RemoteAddr: "10.0.0.1:5555"}
+
+	s.checkSlow(req, phases{read: 100 * time.Millisecond, handle: 200 * time.Millisecond})
+	if errs.Len() != 0 || s.Stats().SlowRequests != 0 {
+		t.Fatalf("fast request logged: %q", errs.String())
+	}
+
+	s.checkSlow(req, phases{read: 100 * time.Millisecond, handle: 200 * time.Millisecond, write: 800 * time.Millisecond})
+	want := "WARN Slow request GET /index.html from 10.0.0.1:5555: 1.1s (read 100ms, handle 200ms, write 800ms)"
+	if got := strings.TrimSpace(errs.String()); got != want {
+		t.Fatalf("got: %q, want: %q", got, want)
+	}
+	if got := s.Stats().SlowRequests; got != 1 {
+		t.Fatalf("slow requests got: %v, want: 1", got)
+	}
+}
@@ -0,0 +1,369 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSSIMaxDepth is how deep includes may nest if SSI.MaxDepth is
+// not set.
+const defaultSSIMaxDepth = 8
+
+// maxSSIDirective is the length of the longest directive parsed; a
+// longer one is left as is, as any other comment.
+const maxSSIDirective = 1 << 10
+
+// defaultSSIErrmsg is what a directive that fails is replaced with,
+// that of Apache.
+const defaultSSIErrmsg = "[an error occurred while processing this directive]"
+
+// defaultSSITimefmt is the format of the dates #echo prints, until a
+// #config timefmt changes it.
+const defaultSSITimefmt = "%A, %d-%b-%Y %H:%M:%S %Z"
+
+// SSI processes the server-side include directives of the .shtml files
+// of the doc root as they are sent:
+//
+//	<!--#include virtual="/footer.html" -->
+//	<!--#include file="nav.html" -->
+//	<!--#echo var="DATE_LOCAL" -->
+//	<!--#config timefmt="%Y-%m-%d" errmsg="..." -->
+//
+// An include is of the path of a request, virtual, or of a file
+// relative to the directory of the document, file, which may not climb
+// out of it; the included .shtml files are processed in turn. #echo
+// knows the variables DOCUMENT_NAME, DOCUMENT_URI, QUERY_STRING,
+// DATE_LOCAL, DATE_GMT and LAST_MODIFIED, whose dates are formatted as
+// the strftime timefmt of the last #config says. A directive that
+// fails is replaced with an error message.
+//
+// As the length of the result is unknown until it is sent, the
+// connection is closed once it is.
+type SSI struct {
+	// MaxDepth is how deep includes may nest, 8 if 0: deeper ones
+	// fail, stopping a file that includes itself.
+	MaxDepth int
+}
+
+// ssiContext is the state of the processing of a document.
+type ssiContext struct {
+	s       *Server
+	req     *Request
+	docURI  string
+	docName string
+	modTime time.Time
+	timefmt string
+	errmsg  string
+}
+
+// serveSSI makes res, the response serving the .shtml file at name,
+// described by fi, for req, the stream of the file with its directives
+// processed.
+func (s *Server) serveSSI(res *Response, req *Request, name string, fi fs.FileInfo) {
+	urlPath, _, _ := strings.Cut(req.URL, "?")
+	c := &ssiContext{
+		s:       s,
+		req:     req,
+		docURI:  urlPath,
+		docName: path.Base(urlPath),
+		timefmt: defaultSSITimefmt,
+		errmsg:  defaultSSIErrmsg,
+		modTime: fi.ModTime(),
+	}
+	// The result changes with the included files and the time
+	delete(res.Header, "Last-Modified")
+	res.Stream(statusOK, "text/html; charset=utf-8", func(w io.Writer) error {
+		return c.include(w, urlPath, name, 0)
+	})
+}
+
+// include writes the file at name, the one of the path urlPath, to w,
+// processing its directives if it is a .shtml file included depth
+// levels deep.
+func (c *ssiContext) include(w io.Writer, urlPath, name string, depth int) error {
+	f, err := c.s.fileSystem().Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil {
+		return err
+	} else if fi.IsDir() {
+		return fmt.Errorf("%v is a directory", urlPath)
+	}
+	if !strings.EqualFold(pathExt(name), ".shtml") {
+		_, err := io.Copy(w, f)
+		return err
+	}
+	br := getBufioReader(f)
+	defer putBufioReader(br)
+	return c.process(w, br, urlPath, depth)
+}
+
+// errWrite wraps the errors of writing to the client, which end the
+// processing, unlike those of directives.
+type errWrite struct{ err error }
+
+func (e errWrite) Error() string { return e.err.Error() }
+func (e errWrite) Unwrap() error { return e.err }
+
+// process copies the document read from br, at urlPath, to w, with its
+// directives replaced by their results.
+func (c *ssiContext) process(w io.Writer, br *bufio.Reader, urlPath string, depth int) error {
+	write := func(p []byte) error {
+		if _, err := w.Write(p); err != nil {
+			return errWrite{err}
+		}
+		return nil
+	}
+	for {
+		chunk, err := br.ReadSlice('<')
+		if err == bufio.ErrBufferFull {
+			if err := write(chunk); err != nil {
+				return err
+			}
+			continue
+		}
+		if err == io.EOF {
+			return write(chunk)
+		} else if err != nil {
+			return err
+		}
+		if err := write(chunk[:len(chunk)-1]); err != nil {
+			return err
+		}
+		if p, _ := br.Peek(4); string(p) != "!--#" {
+			if err := write([]byte{'<'}); err != nil {
+				return err
+			}
+			continue
+		}
+		directive, ok := readSSIDirective(br)
+		if !ok {
+			// Not a directive after all, or too long: sent as is
+			if err := write(append([]byte{'<'}, directive...)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.directive(w, directive, urlPath, depth); err != nil {
+			var we errWrite
+			if errors.As(err, &we) {
+				return err
+			}
+			c.s.requestLogger(c.req).Warnf("SSI in %v: %v", urlPath, err)
+			if err := write([]byte(c.errmsg)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readSSIDirective reads the directive br starts with, "!--#...-->",
+// returning what is between "!--#" and "-->", or what it read and
+// false if it holds none.
+func readSSIDirective(br *bufio.Reader) ([]byte, bool) {
+	var b []byte
+	for len(b) < maxSSIDirective {
+		c, err := br.ReadByte()
+		if err != nil {
+			return b, false
+		}
+		b = append(b, c)
+		if c == '>' && bytes.HasSuffix(b, []byte("-->")) {
+			return b[len("!--#") : len(b)-len("-->")], true
+		}
+	}
+	return b, false
+}
+
+// directive writes the result of directive, e.g. `echo var="X" `, in
+// the document at urlPath, to w.
+func (c *ssiContext) directive(w io.Writer, directive []byte, urlPath string, depth int) error {
+	name, attrs, err := parseSSIDirective(string(directive))
+	if err != nil {
+		return err
+	}
+	for _, a := range attrs {
+		switch {
+		case name == "include" && (a[0] == "virtual" || a[0] == "file"):
+			target, err := c.includeTarget(a[0], a[1], urlPath)
+			if err != nil {
+				return err
+			}
+			if depth+1 > c.maxDepth() {
+				return fmt.Errorf("includes nest deeper than %v levels", c.maxDepth())
+			}
+			file, ok := fileName(c.s.rootFor(c.req), target)
+			if !ok {
+				return fmt.Errorf("%v %q names no file", a[0], a[1])
+			}
+			if err := c.include(w, target, file, depth+1); err != nil {
+				return err
+			}
+		case name == "echo" && a[0] == "var":
+			if _, err := io.WriteString(w, html.EscapeString(c.variable(a[1]))); err != nil {
+				return errWrite{err}
+			}
+		case name == "config" && a[0] == "timefmt":
+			c.timefmt = a[1]
+		case name == "config" && a[0] == "errmsg":
+			c.errmsg = a[1]
+		default:
+			return fmt.Errorf("unknown directive %v %v", name, a[0])
+		}
+	}
+	return nil
+}
+
+// includeTarget returns the path of the request an include of kind,
+// "virtual" or "file", of value names from the document at urlPath.
+func (c *ssiContext) includeTarget(kind, value, urlPath string) (string, error) {
+	if kind == "file" {
+		if strings.HasPrefix(value, "/") || strings.Contains("/"+value+"/", "/../") {
+			return "", fmt.Errorf("file %q is not under the directory of the document", value)
+		}
+	}
+	target := value
+	if !strings.HasPrefix(target, "/") {
+		target = path.Dir(urlPath) + "/" + target
+	}
+	target, ok := removeDotSegments(target)
+	if !ok {
+		return "", fmt.Errorf("%v %q climbs above the doc root", kind, value)
+	}
+	return target, nil
+}
+
+// maxDepth returns how deep includes may nest.
+func (c *ssiContext) maxDepth() int {
+	if c.s.SSI != nil && c.s.SSI.MaxDepth > 0 {
+		return c.s.SSI.MaxDepth
+	}
+	return defaultSSIMaxDepth
+}
+
+// variable returns the value of the #echo variable name.
+func (c *ssiContext) variable(name string) string {
+	switch name {
+	case "DOCUMENT_NAME":
+		return c.docName
+	case "DOCUMENT_URI":
+		return c.docURI
+	case "QUERY_STRING":
+		_, query, _ := strings.Cut(c.req.URL, "?")
+		return query
+	case "DATE_LOCAL":
+		return strftime(c.s.now().Local(), c.timefmt)
+	case "DATE_GMT":
+		return strftime(c.s.now().UTC(), c.timefmt)
+	case "LAST_MODIFIED":
+		return strftime(c.modTime.Local(), c.timefmt)
+	}
+	return "(none)"
+}
+
+// parseSSIDirective parses directive, e.g. `include virtual="/a.html" `,
+// into its name and attributes, in order.
+func parseSSIDirective(directive string) (name string, attrs [][2]string, err error) {
+	name, rest, _ := strings.Cut(strings.TrimSpace(directive), " ")
+	if name == "" {
+		return "", nil, errors.New("empty directive")
+	}
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		key, value, ok := strings.Cut(rest, "=")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if !ok || key == "" || value == "" || value[0] != '"' && value[0] != '\'' {
+			return "", nil, fmt.Errorf("malformed attributes of %v: %q", name, rest)
+		}
+		end := strings.IndexByte(value[1:], value[0])
+		if end < 0 {
+			return "", nil, fmt.Errorf("unterminated value of %v %v", name, key)
+		}
+		attrs = append(attrs, [2]string{strings.ToLower(key), value[1 : 1+end]})
+		rest = value[end+2:]
+	}
+	if len(attrs) == 0 {
+		return "", nil, fmt.Errorf("%v has no attributes", name)
+	}
+	return strings.ToLower(name), attrs, nil
+}
+
+// strftime formats t as the C strftime conversions of format say, for
+// those of them #config timefmt is usually given.
+func strftime(t time.Time, format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'A':
+			b.WriteString(t.Format("Monday"))
+		case 'b', 'h':
+			b.WriteString(t.Format("Jan"))
+		case 'B':
+			b.WriteString(t.Format("January"))
+		case 'c':
+			b.WriteString(t.Format("Mon Jan _2 15:04:05 2006"))
+		case 'd':
+			b.WriteString(t.Format("02"))
+		case 'e':
+			b.WriteString(t.Format("_2"))
+		case 'F':
+			b.WriteString(t.Format("2006-01-02"))
+		case 'H':
+			b.WriteString(t.Format("15"))
+		case 'I':
+			b.WriteString(t.Format("03"))
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'm':
+			b.WriteString(t.Format("01"))
+		case 'M':
+			b.WriteString(t.Format("04"))
+		case 'p':
+			b.WriteString(t.Format("PM"))
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'S':
+			b.WriteString(t.Format("05"))
+		case 'T':
+			b.WriteString(t.Format("15:04:05"))
+		case 'y':
+			b.WriteString(t.Format("06"))
+		case 'Y':
+			b.WriteString(t.Format("2006"))
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(format[i])
+		}
+	}
+	return b.String()
+}
+
+// isSSI reports whether the file at name has its directives processed
+// by s.
+func (s *Server) isSSI(name string) bool {
+	return s.SSI != nil && strings.EqualFold(pathExt(name), ".shtml")
+}
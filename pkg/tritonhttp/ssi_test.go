@@ -0,0 +1,85 @@
+package tritonhttp
+
+import (
+	"io"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSSI(t *testing.T) {
+	fsys := fstest.MapFS{
+		"header.html":      {Data: []byte("<h1>Site</h1>")},
+		"docs/nav.html":    {Data: []byte("<nav>docs</nav>")},
+		"docs/page.shtml":  {Data: []byte(`<!--#include virtual="/header.html" --><!--#include file="nav.html" --><p>body</p>`)},
+		"docs/outer.shtml": {Data: []byte(`[<!--#include file="inner.shtml" -->]`)},
+		"docs/inner.shtml": {Data: []byte(`<!--#echo var="DOCUMENT_NAME" --> in <!--#include virtual="../header.html" -->`)},
+		"echo.shtml": {Data: []byte(`<!--#config timefmt="%Y-%m-%d %H:%M" --><!--#echo var="DATE_GMT" --> ` +
+			`<!--#echo var="DOCUMENT_URI" -->?<!--#echo var="QUERY_STRING" --> <!--#echo var="NOPE" -->`)},
+		"errors.shtml": {Data: []byte(`<!--#include file="../header.html" -->|<!--#include virtual="/missing.html" -->|` +
+			`<!--#config errmsg="oops" --><!--#bogus x="y" -->|<!--#include virtual="/docs" -->`)},
+		"self.shtml":     {Data: []byte(`x<!--#include virtual="/self.shtml" -->`)},
+		"comments.shtml": {Data: []byte(`<!-- plain --> a < b <!--#unterminated`)},
+	}
+	clock := func() time.Time { return time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC) }
+	var tests = []struct {
+		name     string
+		ssi      *SSI
+		url      string
+		wantBody string
+	}{
+		{"Include", &SSI{}, "/docs/page.shtml", "<h1>Site</h1><nav>docs</nav><p>body</p>"},
+		{"Nested", &SSI{}, "/docs/outer.shtml", "[outer.shtml in <h1>Site</h1>]"},
+		{"Echo", &SSI{}, "/echo.shtml?a=%3Cb%3E&c", "2024-03-01 12:30 /echo.shtml?a=%3Cb%3E&amp;c (none)"},
+		{"Errors", &SSI{}, "/errors.shtml",
+			"[an error occurred while processing this directive]|[an error occurred while processing this directive]|oops|oops"},
+		{"Depth", &SSI{MaxDepth: 3}, "/self.shtml", "xxxx[an error occurred while processing this directive]"},
+		{"Comments", &SSI{}, "/comments.shtml", "<!-- plain --> a < b <!--#unterminated"},
+		{"Off", nil, "/docs/page.shtml", `<!--#include virtual="/header.html" --><!--#include file="nav.html" --><p>body</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				FS:       MountFS(fsys, "/srv"),
+				DocRoot:  "/srv",
+				ErrorLog: NewLogger(nil, LevelError),
+				Clock:    clock,
+				SSI:      tt.ssi,
+			}
+			addr, _ := startTestServer(t, s)
+			res := exchangeRaw(t, addr, "GET "+tt.url+" HTTP/1.1\r\nHost: test\r\n\r\n", 1)[0]
+			body, _ := io.ReadAll(res.BodyReader)
+			if res.StatusCode != 200 {
+				t.Fatalf("got status %v, want 200", res.StatusCode)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("got body:\n%s\nwant:\n%s", body, tt.wantBody)
+			}
+			if tt.ssi == nil {
+				return
+			}
+			if res.Header["Content-Type"] != "text/html; charset=utf-8" || res.Header["Connection"] != "close" ||
+				res.Header["Content-Length"] != "" || res.Header["Last-Modified"] != "" {
+				t.Errorf("got headers %v, want a stream of HTML", res.Header)
+			}
+		})
+	}
+}
+
+func TestStrftime(t *testing.T) {
+	tm := time.Date(2024, 3, 1, 14, 5, 9, 0, time.UTC)
+	var tests = []struct {
+		format string
+		want   string
+	}{
+		{"%Y-%m-%d %H:%M:%S", "2024-03-01 14:05:09"},
+		{"%A, %d-%b-%Y %H:%M:%S %Z", "Friday, 01-Mar-2024 14:05:09 UTC"},
+		{"%a %B %e %I%p %j %y", "Fri March  1 02PM 061 24"},
+		{"100%% %q %", "100% %q %"},
+	}
+	for _, tt := range tests {
+		if got := strftime(tm, tt.format); got != tt.want {
+			t.Errorf("strftime(%q) got: %q, want: %q", tt.format, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatsD emits request metrics as StatsD packets over UDP.
+// Create one with NewStatsD and set it as Server.StatsD.
+type StatsD struct {
+	conn net.Conn
+
+	// Prefix is prepended to every metric name, e.g. "tritonhttp.".
+	Prefix string
+	// SampleRate is the share of requests reported, in (0, 1].
+	SampleRate float64
+	// Tags are added to every metric in the DogStatsD "|#k:v" form,
+	// along with per-request vhost, route and status tags. Plain StatsD
+	// servers don't understand tags, so they are only sent if DogStatsD.
+	Tags      []string
+	DogStatsD bool
+}
+
+// NewStatsD returns a StatsD emitter sending to the daemon at addr,
+// e.g. "localhost:8125".
+func NewStatsD(addr string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsD{conn: conn, SampleRate: 1}, nil
+}
+
+// Close closes the UDP socket.
+func (sd *StatsD) Close() error {
+	return sd.conn.Close()
+}
+
+// recordRequest reports one request, subject to sampling.
+func (sd *StatsD) recordRequest(key RouteKey, status int, bytes int64, latency time.Duration) {
+	if sd.SampleRate < 1 && rand.Float64() >= sd.SampleRate {
+		return
+	}
+	tags := []string{"vhost:" + key.Host, "route:" + key.Route, fmt.Sprintf("status:%dxx", status/100)}
+	if key.Country != "" {
+		tags = append(tags, "country:"+key.Country)
+	}
+	var b strings.Builder
+	sd.appendMetric(&b, "requests", "1", "c", tags)
+	sd.appendMetric(&b, "request.duration", strconv.FormatFloat(float64(latency)/float64(time.Millisecond), 'f', 3, 64), "ms", tags)
+	sd.appendMetric(&b, "response.bytes", strconv.FormatInt(bytes, 10), "h", tags)
+	// Best effort: a lost metric is not worth failing a request for
+	_, _ = sd.conn.Write([]byte(b.String()))
+}
+
+// appendMetric appends one metric line to b.
+func (sd *StatsD) appendMetric(b *strings.Builder, name, value, kind string, tags []string) {
+	if b.Len() > 0 {
+		b.WriteByte('\n')
+	}
+	fmt.Fprintf(b, "%v%v:%v|%v", sd.Prefix, name, value, kind)
+	if sd.SampleRate < 1 {
+		fmt.Fprintf(b, "|@%v", strconv.FormatFloat(sd.SampleRate, 'f', -1, 64))
+	}
+	if sd.DogStatsD {
+		all := append(append([]string(nil), sd.Tags...), tags...)
+		fmt.Fprintf(b, "|#%v", strings.Join(all, ","))
+	}
+}
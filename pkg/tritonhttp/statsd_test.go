@@ -0,0 +1,41 @@
+package tritonhttp
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsD(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	sd, err := NewStatsD(pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sd.Close()
+	sd.Prefix = "triton."
+	sd.Tags = []string{"env:test"}
+	sd.DogStatsD = true
+	sd.recordRequest(RouteKey{Host: "example.com", Route: "/"}, 404, 12, 1500*time.Microsecond)
+
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := "|#env:test,vhost:example.com,route:/,status:4xx"
+	want := []string{
+		"triton.requests:1|c" + tags,
+		"triton.request.duration:1.500|ms" + tags,
+		"triton.response.bytes:12|h" + tags,
+	}
+	if got := strings.Split(string(buf[:n]), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("\ngot: %q\nwant: %q", got, want)
+	}
+}
@@ -0,0 +1,162 @@
+package tritonhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultShutdownTimeout is how long Run lets the servers drain
+// when the Supervisor has no ShutdownTimeout.
+const defaultShutdownTimeout = 10 * time.Second
+
+// Supervisor runs several Servers as one unit, e.g. a main site, a
+// redirector and an internal status server. If any of them stops on
+// its own, the others are shut down too.
+type Supervisor struct {
+	Servers []*Server
+
+	// ShutdownTimeout bounds how long Run waits for the servers to
+	// drain after a signal. Zero means 10 seconds.
+	ShutdownTimeout time.Duration
+
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	errs []error
+}
+
+// Start sets up every server, binding all their listeners before any
+// of them starts serving, and then serves them in the background.
+// If any server fails to set up, the ones already set up are closed
+// and the errors are returned joined.
+func (sv *Supervisor) Start() error {
+	lns := make([][]boundListener, len(sv.Servers))
+	var errs []error
+	for i, s := range sv.Servers {
+		ln, err := s.setup()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %v (%v): %w", i, s.Addr, err))
+			continue
+		}
+		lns[i] = ln
+	}
+	if len(errs) > 0 {
+		for i, bound := range lns {
+			if bound != nil {
+				for _, l := range bound {
+					_ = l.ln.Close()
+				}
+				_ = sv.Servers[i].Close()
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	for i, s := range sv.Servers {
+		sv.wg.Add(1)
+		go func(i int, s *Server, lns []boundListener) {
+			defer sv.wg.Done()
+			if err := s.serveAll(lns); err != nil && !errors.Is(err, ErrServerClosed) {
+				sv.record(fmt.Errorf("server %v (%v): %w", i, lns[0].ln.Addr(), err))
+				ctx, cancel := context.WithTimeout(context.Background(), sv.shutdownTimeout())
+				defer cancel()
+				_ = sv.Shutdown(ctx)
+			}
+		}(i, s, lns[i])
+	}
+	return nil
+}
+
+// Wait waits for every server to stop and returns the errors they
+// stopped with, other than ErrServerClosed, joined.
+func (sv *Supervisor) Wait() error {
+	sv.wg.Wait()
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	return errors.Join(sv.errs...)
+}
+
+// Shutdown gracefully shuts down every server concurrently, see
+// Server.Shutdown, and returns their errors joined.
+func (sv *Supervisor) Shutdown(ctx context.Context) error {
+	errs := make([]error, len(sv.Servers))
+	var wg sync.WaitGroup
+	for i, s := range sv.Servers {
+		wg.Add(1)
+		go func(i int, s *Server) {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				errs[i] = fmt.Errorf("server %v (%v): %w", i, s.Addr, err)
+			}
+		}(i, s)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Reload reloads every server, see Server.Reload, and returns their
+// errors joined.
+func (sv *Supervisor) Reload() error {
+	var errs []error
+	for i, s := range sv.Servers {
+		if err := s.Reload(); err != nil {
+			errs = append(errs, fmt.Errorf("server %v (%v): %w", i, s.Addr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run starts the servers and runs them until ctx is done, SIGINT or
+// SIGTERM is received, or one of them stops on its own; the servers
+// are then shut down gracefully. SIGHUP reloads them. It returns all
+// errors from starting, running and shutting down the servers joined.
+func (sv *Supervisor) Run(ctx context.Context) error {
+	if err := sv.Start(); err != nil {
+		return err
+	}
+	stopped := make(chan struct{})
+	go func() {
+		sv.wg.Wait()
+		close(stopped)
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sig)
+	for stop := false; !stop; {
+		select {
+		case got := <-sig:
+			if stop = got != syscall.SIGHUP; !stop {
+				_ = sv.Reload()
+			}
+		case <-ctx.Done():
+			stop = true
+		case <-stopped:
+			return sv.Wait()
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), sv.shutdownTimeout())
+	defer cancel()
+	shutdownErr := sv.Shutdown(shutdownCtx)
+	return errors.Join(sv.Wait(), shutdownErr)
+}
+
+// record keeps err to be returned by Wait.
+func (sv *Supervisor) record(err error) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sv.errs = append(sv.errs, err)
+}
+
+func (sv *Supervisor) shutdownTimeout() time.Duration {
+	if sv.ShutdownTimeout > 0 {
+		return sv.ShutdownTimeout
+	}
+	return defaultShutdownTimeout
+}
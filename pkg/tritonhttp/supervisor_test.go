@@ -0,0 +1,72 @@
+package tritonhttp
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSupervisorRun(t *testing.T) {
+	dir := t.TempDir()
+	ready := make(chan string, 2)
+	sv := &Supervisor{}
+	for i := 0; i < 2; i++ {
+		s := &Server{Addr: "127.0.0.1:0", DocRoot: dir}
+		s.RegisterOnStartup(func(addr net.Addr) error {
+			ready <- addr.String()
+			return nil
+		})
+		sv.Servers = append(sv.Servers, s)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- sv.Run(ctx) }()
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", <-ready)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run got error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestSupervisorStartError(t *testing.T) {
+	dir := t.TempDir()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	good := &Server{Addr: "127.0.0.1:0", DocRoot: dir}
+	sv := &Supervisor{Servers: []*Server{
+		good,
+		{Addr: ln.Addr().String(), DocRoot: dir}, // address in use
+		{Addr: "127.0.0.1:0"},                    // no doc root
+	}}
+	err = sv.Start()
+	if err == nil {
+		t.Fatal("Start got no error")
+	}
+	for _, want := range []string{"server 1", "server 2"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error %q does not mention %v", err, want)
+		}
+	}
+	if !good.shuttingDown() {
+		t.Fatal("server set up before the failure was not closed")
+	}
+}
@@ -0,0 +1,216 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SyslogFacility is the facility part of a syslog priority.
+type SyslogFacility int
+
+const (
+	FacilityKern   SyslogFacility = 0
+	FacilityUser   SyslogFacility = 1
+	FacilityDaemon SyslogFacility = 3
+	FacilityAuth   SyslogFacility = 4
+	FacilityLocal0 SyslogFacility = 16
+	FacilityLocal1 SyslogFacility = 17
+	FacilityLocal2 SyslogFacility = 18
+	FacilityLocal3 SyslogFacility = 19
+	FacilityLocal4 SyslogFacility = 20
+	FacilityLocal5 SyslogFacility = 21
+	FacilityLocal6 SyslogFacility = 22
+	FacilityLocal7 SyslogFacility = 23
+)
+
+var facilityNames = map[string]SyslogFacility{
+	"kern":   FacilityKern,
+	"user":   FacilityUser,
+	"daemon": FacilityDaemon,
+	"auth":   FacilityAuth,
+	"local0": FacilityLocal0,
+	"local1": FacilityLocal1,
+	"local2": FacilityLocal2,
+	"local3": FacilityLocal3,
+	"local4": FacilityLocal4,
+	"local5": FacilityLocal5,
+	"local6": FacilityLocal6,
+	"local7": FacilityLocal7,
+}
+
+// ParseSyslogFacility returns the facility named s, e.g. "daemon" or "local0".
+func ParseSyslogFacility(s string) (SyslogFacility, error) {
+	if f, ok := facilityNames[strings.ToLower(s)]; ok {
+		return f, nil
+	}
+	return 0, fmt.Errorf("unknown syslog facility %q", s)
+}
+
+// SyslogSeverity is the severity part of a syslog priority.
+type SyslogSeverity int
+
+const (
+	SeverityError   SyslogSeverity = 3
+	SeverityWarning SyslogSeverity = 4
+	SeverityInfo    SyslogSeverity = 6
+	SeverityDebug   SyslogSeverity = 7
+)
+
+// localSyslogPaths are the usual unix sockets of the local syslog daemon.
+var localSyslogPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogWriter sends every Write as one RFC 5424 message to a syslog daemon.
+// It is safe for concurrent use.
+type SyslogWriter struct {
+	network  string
+	addr     string
+	facility SyslogFacility
+	severity SyslogSeverity
+	tag      string
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogWriter connects to the syslog daemon at addr over network,
+// e.g. "udp" and "logs.example.com:514". An empty network connects to
+// the local daemon. Messages are sent with the given facility, tag
+// (the APP-NAME) and, for plain Writes, severity.
+func NewSyslogWriter(network, addr string, facility SyslogFacility, severity SyslogSeverity, tag string) (*SyslogWriter, error) {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	if tag == "" {
+		tag = "-"
+	}
+	w := &SyslogWriter{
+		network:  network,
+		addr:     addr,
+		facility: facility,
+		severity: severity,
+		tag:      tag,
+		hostname: hostname,
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write sends p as one message with the writer's default severity.
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	if err := w.WriteSeverity(w.severity, string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteSeverity sends msg as one message with severity sev,
+// reconnecting once if the connection was lost.
+func (w *SyslogWriter) WriteSeverity(sev SyslogSeverity, msg string) error {
+	line := w.format(sev, time.Now(), strings.TrimRight(msg, "\n"))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn != nil {
+		if _, err := w.conn.Write(line); err == nil {
+			return nil
+		}
+		_ = w.conn.Close()
+		w.conn = nil
+	}
+	if err := w.connect(); err != nil {
+		return err
+	}
+	_, err := w.conn.Write(line)
+	return err
+}
+
+// Close closes the connection to the syslog daemon.
+func (w *SyslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// format renders an RFC 5424 message. Stream transports get the
+// octet-counting framing of RFC 6587.
+func (w *SyslogWriter) format(sev SyslogSeverity, t time.Time, msg string) []byte {
+	pri := int(w.facility)*8 + int(sev)
+	s := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		pri, t.UTC().Format(time.RFC3339Nano), w.hostname, w.tag, os.Getpid(), msg)
+	if w.network == "tcp" || w.network == "tcp4" || w.network == "tcp6" || w.network == "unix" {
+		s = fmt.Sprintf("%d %s", len(s), s)
+	}
+	return []byte(s)
+}
+
+// connect dials the daemon. The caller must hold w.mu.
+func (w *SyslogWriter) connect() error {
+	if w.network != "" {
+		conn, err := net.Dial(w.network, w.addr)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+		return nil
+	}
+	for _, path := range localSyslogPaths {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, path); err == nil {
+				w.conn = conn
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no local syslog daemon found at %v", localSyslogPaths)
+}
+
+// syslogSeverities maps log levels to syslog severities.
+var syslogSeverities = map[LogLevel]SyslogSeverity{
+	LevelDebug: SeverityDebug,
+	LevelInfo:  SeverityInfo,
+	LevelWarn:  SeverityWarning,
+	LevelError: SeverityError,
+}
+
+// NewSyslogLogger returns a Logger sending messages of at least level min
+// to w, with the syslog severity matching their level.
+func NewSyslogLogger(w *SyslogWriter, min LogLevel) Logger {
+	sl := &syslogLogger{w: w}
+	sl.SetLevel(min)
+	return sl
+}
+
+type syslogLogger struct {
+	w   *SyslogWriter
+	min atomic.Int64
+}
+
+func (sl *syslogLogger) Level() LogLevel       { return LogLevel(sl.min.Load()) }
+func (sl *syslogLogger) SetLevel(min LogLevel) { sl.min.Store(int64(min)) }
+
+func (sl *syslogLogger) logf(level LogLevel, format string, v []interface{}) {
+	if level < sl.Level() {
+		return
+	}
+	_ = sl.w.WriteSeverity(syslogSeverities[level], fmt.Sprintf(format, v...))
+}
+
+func (sl *syslogLogger) Debugf(format string, v ...interface{}) { sl.logf(LevelDebug, format, v) }
+func (sl *syslogLogger) Infof(format string, v ...interface{})  { sl.logf(LevelInfo, format, v) }
+func (sl *syslogLogger) Warnf(format string, v ...interface{})  { sl.logf(LevelWarn, format, v) }
+func (sl *syslogLogger) Errorf(format string, v ...interface{}) { sl.logf(LevelError, format, v) }
@@ -0,0 +1,35 @@
+package tritonhttp
+
+import (
+	"net"
+	"regexp"
+	"testing"
+)
+
+func TestSyslogWriter(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	w, err := NewSyslogWriter("udp", pc.LocalAddr().String(), FacilityLocal0, SeverityInfo, "httpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := w.Write([]byte("hello world\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// local0 * 8 + info = 134
+	re := regexp.MustCompile(`^<134>1 \S+ \S+ httpd \d+ - - hello world$`)
+	if got := string(buf[:n]); !re.MatchString(got) {
+		t.Fatalf("got: %q, want match of %v", got, re)
+	}
+}
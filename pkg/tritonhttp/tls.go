@@ -0,0 +1,146 @@
+package tritonhttp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// Listener is an address a Server listens on besides Addr, serving
+// HTTPS there if it has Certificates.
+type Listener struct {
+	// Addr is the TCP address to listen on, e.g. ":8443".
+	Addr string
+
+	// Certificates, if set, make the clients speak TLS, presented the
+	// certificate whose names match the server name they ask for, or
+	// else the first. They are read again by Reload, for renewed
+	// certificates to take effect.
+	Certificates []Certificate
+}
+
+// Certificate names the PEM files of a certificate chain and its
+// private key.
+type Certificate struct {
+	CertFile string
+	KeyFile  string
+}
+
+// boundListener is a net.Listener of a Server, with the certificates
+// of its Listener, if any.
+type boundListener struct {
+	ln    net.Listener
+	certs []Certificate
+}
+
+// serve serves the connections accepted by l with s until it is
+// closed.
+func (l boundListener) serve(s *Server) error {
+	if len(l.certs) > 0 {
+		return s.ServeTLS(l.ln, l.certs...)
+	}
+	return s.Serve(l.ln)
+}
+
+// tlsCerts are the certificates of a listener serving TLS, as last
+// loaded from their files.
+type tlsCerts struct {
+	files  []Certificate
+	config atomic.Pointer[tls.Config]
+}
+
+// load reads the certificates from their files, leaving those loaded
+// before in use if any cannot be.
+func (c *tlsCerts) load() error {
+	cfg := &tls.Config{NextProtos: []string{"http/1.1"}}
+	for _, f := range c.files {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return fmt.Errorf("certificate %v: %w", f.CertFile, err)
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+	c.config.Store(cfg)
+	return nil
+}
+
+// tlsConfig returns the configuration of the TLS connections of the
+// listener, presenting the certificates last loaded to each client.
+func (c *tlsCerts) tlsConfig() *tls.Config {
+	return &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return c.config.Load(), nil
+		},
+	}
+}
+
+// ServeTLS is Serve for clients speaking TLS, presented the certificate
+// of certs whose names match the server name they ask for, or else the
+// first. The certificates are read again by Reload.
+func (s *Server) ServeTLS(ln net.Listener, certs ...Certificate) error {
+	if len(certs) == 0 {
+		_ = ln.Close()
+		return errors.New("no certificates to serve TLS with")
+	}
+	c := &tlsCerts{files: certs}
+	if err := c.load(); err != nil {
+		_ = ln.Close()
+		return err
+	}
+	s.mu.Lock()
+	if s.certs == nil {
+		s.certs = make(map[*tlsCerts]struct{})
+	}
+	s.certs[c] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.certs, c)
+		s.mu.Unlock()
+	}()
+	return s.serve(ln, c.tlsConfig())
+}
+
+// reloadCertificates reads the certificates of the listeners serving
+// TLS again.
+func (s *Server) reloadCertificates() error {
+	s.mu.Lock()
+	certs := make([]*tlsCerts, 0, len(s.certs))
+	for c := range s.certs {
+		certs = append(certs, c)
+	}
+	s.mu.Unlock()
+	var errs []error
+	for _, c := range certs {
+		errs = append(errs, c.load())
+	}
+	return errors.Join(errs...)
+}
+
+// tlsConn returns the TLS connection under conn, if any.
+func tlsConn(conn net.Conn) (*tls.Conn, bool) {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			return c, true
+		case netConner:
+			conn = c.netConn()
+		default:
+			return nil, false
+		}
+	}
+}
+
+// sameAddr reports whether addr, a listening address, is that of the
+// address to listen on want, e.g. "[::]:8443" that of ":8443".
+func sameAddr(addr net.Addr, want string) bool {
+	got, ok := addr.(*net.TCPAddr)
+	w, err := net.ResolveTCPAddr("tcp", want)
+	if !ok || err != nil || got.Port != w.Port {
+		return false
+	}
+	return got.IP.Equal(w.IP) || got.IP.IsUnspecified() && (w.IP == nil || w.IP.IsUnspecified())
+}
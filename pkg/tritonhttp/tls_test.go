@@ -0,0 +1,200 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes cert, from testCertificate, to PEM files in
+// dir, returning their names.
+func writeCertificate(t *testing.T, dir string, cert tls.Certificate) Certificate {
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Certificate{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	if err := os.WriteFile(c.CertFile, certPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.KeyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// getTLS sends a GET of target to addr over TLS, trusting roots, and
+// returns the response, with its body read, and the certificate the
+// server presented.
+func getTLS(t *testing.T, addr, target string, roots *x509.CertPool) (*Response, []byte) {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, "GET "+target+" HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := ReadResponse(bufio.NewReader(conn), &Request{Method: "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.BodyReader)
+	res.BodyReader = bytes.NewReader(body)
+	return res, conn.ConnectionState().PeerCertificates[0].Raw
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	cert, roots := testCertificate(t)
+	files := writeCertificate(t, dir, cert)
+	s := &Server{
+		DocRoot:       dir,
+		ErrorLog:      NewLogger(nil, LevelError),
+		CanonicalHost: &CanonicalHost{HTTPS: true},
+		Routes: []Route{{Prefix: "/scheme", Handler: HandlerFunc(func(req *Request) *Response {
+			res := NewResponse(statusOK)
+			res.Text(statusOK, req.Scheme)
+			return res
+		})}},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeTLS(ln, files)
+	addr := ln.Addr().String()
+
+	// Requests over TLS are made over HTTPS, so not redirected to it
+	res, presented := getTLS(t, addr, "/scheme", roots)
+	if body, _ := io.ReadAll(res.BodyReader); res.StatusCode != 200 || string(body) != "https" {
+		t.Fatalf("got %v %q, want 200 https", res.StatusCode, body)
+	}
+	if !bytes.Equal(presented, cert.Certificate[0]) {
+		t.Fatal("got another certificate")
+	}
+
+	// Plain HTTP gets no response
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	if got, _ := io.ReadAll(conn); bytes.Contains(got, []byte("HTTP/1.1")) {
+		t.Errorf("plain HTTP got %q", got)
+	}
+
+	// Reloading reads renewed certificates
+	renewed, renewedRoots := testCertificate(t)
+	writeCertificate(t, dir, renewed)
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, presented := getTLS(t, addr, "/scheme", renewedRoots); !bytes.Equal(presented, renewed.Certificate[0]) {
+		t.Error("got the replaced certificate")
+	}
+
+	// A certificate that no longer loads leaves the last one in use
+	if err := os.WriteFile(files.KeyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err == nil {
+		t.Error("reloading a broken key got no error")
+	}
+	if _, presented := getTLS(t, addr, "/scheme", renewedRoots); !bytes.Equal(presented, renewed.Certificate[0]) {
+		t.Error("got another certificate than the last loaded")
+	}
+}
+
+func TestListeners(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("home"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cert, roots := testCertificate(t)
+	s := &Server{
+		Listeners: []Listener{
+			{Addr: "127.0.0.1:0"},
+			{Addr: "127.0.0.1:0", Certificates: []Certificate{writeCertificate(t, dir, cert)}},
+		},
+		DocRoot:  dir,
+		ErrorLog: NewLogger(nil, LevelError),
+	}
+	addrs := make(chan string, 2)
+	s.RegisterOnStartup(func(addr net.Addr) error {
+		addrs <- addr.String()
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() { done <- s.ListenAndServe() }()
+
+	// Only Listeners are listened on with Addr empty; which one starts
+	// first is up to the scheduler, and a TLS one answers no plain HTTP
+	var plain, secure int
+	for i := 0; i < 2; i++ {
+		addr := <-addrs
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(conn, "GET /index.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+		res, err := ReadResponse(bufio.NewReader(conn), &Request{Method: "GET"})
+		if err == nil {
+			if body, _ := io.ReadAll(res.BodyReader); string(body) != "home" {
+				t.Errorf("got %q over plain HTTP", body)
+			}
+			plain++
+		} else {
+			res, _ := getTLS(t, addr, "/index.html", roots)
+			if body, _ := io.ReadAll(res.BodyReader); string(body) != "home" {
+				t.Errorf("got %q over TLS", body)
+			}
+			secure++
+		}
+		conn.Close()
+	}
+	if plain != 1 || secure != 1 {
+		t.Errorf("got %v plain and %v TLS listeners, want one of each", plain, secure)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != ErrServerClosed {
+		t.Errorf("ListenAndServe got %v, want ErrServerClosed", err)
+	}
+}
+
+func TestSameAddr(t *testing.T) {
+	var tests = []struct {
+		addr net.Addr
+		want string
+		same bool
+	}{
+		{&net.TCPAddr{IP: net.IPv6unspecified, Port: 8443}, ":8443", true},
+		{&net.TCPAddr{IP: net.IPv4zero, Port: 8443}, "0.0.0.0:8443", true},
+		{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8443}, "127.0.0.1:8443", true},
+		{&net.TCPAddr{IP: net.IPv6unspecified, Port: 8443}, ":8080", false},
+		{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8443}, ":8443", false},
+		{&net.UnixAddr{Name: "/run/httpd.sock", Net: "unix"}, ":8443", false},
+	}
+	for _, tt := range tests {
+		if got := sameAddr(tt.addr, tt.want); got != tt.same {
+			t.Errorf("sameAddr(%v, %q) = %v, want %v", tt.addr, tt.want, got, tt.same)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package tritonhttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// SpanContext identifies a span of a distributed trace,
+// as propagated by the W3C Trace Context "traceparent" header.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether sc has non-zero trace and span IDs.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%v", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a traceparent header value such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("malformed traceparent %q", s)
+	}
+	var flags [1]byte
+	for _, f := range []struct {
+		dst []byte
+		src string
+	}{{sc.TraceID[:], parts[1]}, {sc.SpanID[:], parts[2]}, {flags[:], parts[3]}} {
+		if len(f.src) != 2*len(f.dst) || strings.ToLower(f.src) != f.src {
+			return SpanContext{}, fmt.Errorf("malformed traceparent %q", s)
+		}
+		if _, err := hex.Decode(f.dst, []byte(f.src)); err != nil {
+			return SpanContext{}, fmt.Errorf("malformed traceparent %q", s)
+		}
+	}
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", s)
+	}
+	return sc, nil
+}
+
+// Span is one timed operation of a trace.
+type Span interface {
+	// Context returns the identity of the span, for propagation.
+	Context() SpanContext
+	// SetAttribute records a key-value attribute on the span,
+	// following the OpenTelemetry semantic conventions for keys.
+	SetAttribute(key string, value interface{})
+	// End completes the span.
+	End()
+}
+
+// Tracer starts spans. It mirrors the shape of an OpenTelemetry tracer,
+// so that one can be adapted to it in a few lines without TritonHTTP
+// depending on the OpenTelemetry SDK.
+type Tracer interface {
+	// Start starts a span named name. If parent is valid, the span
+	// joins its trace as a child; otherwise it starts a new trace.
+	Start(parent SpanContext, name string) Span
+}
+
+// NewChildSpanContext returns the identity of a new span: a child of
+// parent if it is valid, the root of a new sampled trace otherwise.
+// Tracer implementations may use it to mint span IDs.
+func NewChildSpanContext(parent SpanContext) SpanContext {
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		_, _ = rand.Read(sc.TraceID[:])
+		sc.Sampled = true
+	}
+	_, _ = rand.Read(sc.SpanID[:])
+	return sc
+}
+
+// noopSpan is used when the server has no Tracer.
+type noopSpan struct{}
+
+func (noopSpan) Context() SpanContext             { return SpanContext{} }
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) End()                             {}
+
+// startConnSpan starts the span covering the whole of conn.
+func (s *Server) startConnSpan(remote string) Span {
+	if s.Tracer == nil {
+		return noopSpan{}
+	}
+	span := s.Tracer.Start(SpanContext{}, "connection")
+	span.SetAttribute("network.peer.address", remote)
+	return span
+}
+
+// startRequestSpan starts the span of req, continuing the trace of the
+// client's traceparent header if it sent a valid one, and the trace of
+// connSpan otherwise. req.Trace is set to the new span's context.
+func (s *Server) startRequestSpan(req *Request, connSpan Span) Span {
+	if s.Tracer == nil {
+		return noopSpan{}
+	}
+	parent, err := ParseTraceparent(req.Header["Traceparent"])
+	if err != nil {
+		parent = connSpan.Context()
+	}
+	span := s.Tracer.Start(parent, req.Method)
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("url.path", req.URL)
+	span.SetAttribute("server.address", req.Host)
+	span.SetAttribute("client.address", splitHost(req.RemoteAddr))
+	req.Trace = span.Context()
+	return span
+}
+
+// endRequestSpan records the outcome of a request on span and ends it.
+func endRequestSpan(span Span, status int, bytes int64) {
+	span.SetAttribute("http.response.status_code", status)
+	span.SetAttribute("http.response.body.size", bytes)
+	span.End()
+}
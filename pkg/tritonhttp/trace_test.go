@@ -0,0 +1,85 @@
+package tritonhttp
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	var tests = []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"Valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"FutureVersion", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ExtraFieldsInVersion00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"ZeroTraceID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", true},
+		{"UpperCase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", true},
+		{"ShortSpanID", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01", true},
+		{"Empty", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error: %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil && tt.value[:2] == "00" && sc.Traceparent() != tt.value {
+				t.Fatalf("round trip got: %q, want: %q", sc.Traceparent(), tt.value)
+			}
+		})
+	}
+}
+
+type testSpan struct {
+	name   string
+	ctx    SpanContext
+	parent SpanContext
+	attrs  map[string]interface{}
+}
+
+func (ts *testSpan) Context() SpanContext                 { return ts.ctx }
+func (ts *testSpan) SetAttribute(k string, v interface{}) { ts.attrs[k] = v }
+func (ts *testSpan) End()                                 {}
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (tt *testTracer) Start(parent SpanContext, name string) Span {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	span := &testSpan{name, NewChildSpanContext(parent), parent, map[string]interface{}{}}
+	tt.spans = append(tt.spans, span)
+	return span
+}
+
+func TestHandleConnectionTracing(t *testing.T) {
+	tracer := &testTracer{}
+	s := &Server{DocRoot: "testdata", Tracer: tracer}
+	client, server := net.Pipe()
+	go client.Write([]byte("GET /index.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n" +
+		"Traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n\r\n"))
+	go s.HandleConnection(server)
+	if _, err := io.ReadAll(client); err != nil {
+		t.Fatal(err)
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if len(tracer.spans) != 2 {
+		t.Fatalf("got %v spans, want 2", len(tracer.spans))
+	}
+	reqSpan := tracer.spans[1]
+	if got := reqSpan.parent.Traceparent(); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Fatalf("request span parent got: %v", got)
+	}
+	if reqSpan.attrs["http.response.status_code"] != 200 || reqSpan.attrs["url.path"] != "/index.html" {
+		t.Fatalf("request span attributes got: %v", reqSpan.attrs)
+	}
+}
@@ -0,0 +1,514 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxIdleConnsPerHost = 2
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// DefaultTransport is the Transport of Clients without one.
+var DefaultTransport = &Transport{}
+
+// Transport sends requests over keep-alive connections, keeping the
+// idle ones to each server for reuse. The zero Transport is usable.
+// It is safe for concurrent use.
+type Transport struct {
+	// DialContext, if set, opens the connections to servers
+	// instead of a net.Dialer.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// TLSClientConfig configures the TLS connections to https servers,
+	// e.g. with custom RootCAs, client Certificates, or, in tests only,
+	// InsecureSkipVerify. If nil, the default configuration is used.
+	// ServerName defaults to the host the request is sent to.
+	TLSClientConfig *tls.Config
+
+	// TLSHandshakeTimeout bounds the TLS handshake. Zero means no timeout.
+	TLSHandshakeTimeout time.Duration
+
+	// DialTimeout bounds opening a connection; ResponseHeaderTimeout
+	// bounds writing the request and reading the response headers;
+	// ResponseBodyTimeout bounds reading the response body once the
+	// headers are in. Zero means no timeout. Expiring yields a
+	// *TimeoutError naming the phase.
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+	ResponseBodyTimeout   time.Duration
+
+	// DisableCompression stops the Transport from asking for gzip
+	// compressed responses, which it transparently decompresses,
+	// when the request has no Accept-Encoding of its own.
+	// Only gzip is requested; a br body, sent anyway, is left as is.
+	DisableCompression bool
+
+	// MaxIdleConnsPerHost caps the idle connections kept per server.
+	// Zero means 2; a negative value disables keep-alive.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection is kept.
+	// Zero means 90 seconds.
+	IdleConnTimeout time.Duration
+
+	// MaxConnLifetime, if positive, is how long a connection is used
+	// for, counted from when it was opened; older ones are closed
+	// instead of being reused, so that traffic moves to new instances
+	// of a server, e.g. behind a DNS name or a load balancer.
+	MaxConnLifetime time.Duration
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+// persistConn is a connection that may carry several requests.
+type persistConn struct {
+	conn      net.Conn
+	br        *bufio.Reader
+	key       string // the scheme and address it connects to
+	created   time.Time
+	idleSince time.Time
+}
+
+// RoundTrip sends req on an idle connection to its server, or a new
+// one, and reads the response. The connection goes back to the pool
+// once the response body has been read and the response closed.
+func (t *Transport) RoundTrip(req *Request) (*Response, error) {
+	return t.RoundTripContext(context.Background(), req)
+}
+
+// RoundTripContext is RoundTrip giving up, with ctx's error, once ctx
+// is done, be it while dialing, waiting for the response headers or
+// reading the response body.
+func (t *Transport) RoundTripContext(ctx context.Context, req *Request) (*Response, error) {
+	addr := req.addr()
+	sent := *req
+	if t.maxIdle() < 0 {
+		sent.Close = true
+	}
+	_, callerEncoding := req.Header["Accept-Encoding"]
+	gzipped := !t.DisableCompression && !callerEncoding && req.Method != "HEAD"
+	if gzipped {
+		sent.Header = make(map[string]string, len(req.Header)+1)
+		for k, v := range req.Header {
+			sent.Header[k] = v
+		}
+		sent.Header["Accept-Encoding"] = "gzip"
+	}
+
+	for {
+		pc, reused, err := t.getConn(ctx, req.scheme(), addr)
+		if err != nil {
+			return nil, err
+		}
+		res, err := t.exchange(ctx, pc, &sent)
+		if err != nil {
+			_ = pc.conn.Close()
+			// The server may have closed an idle connection just as we
+			// reused it; retry on a new one if it is safe to
+			if reused && ctx.Err() == nil && (req.Method == "GET" || req.Method == "HEAD") && req.Body == nil {
+				continue
+			}
+			return nil, phaseError(ctx, "response header", err)
+		}
+		res.Request = req
+		if gzipped && strings.EqualFold(res.Header["Content-Encoding"], "gzip") {
+			res.BodyReader = &gzipBody{body: res.BodyReader.(io.ReadCloser)}
+			res.Uncompressed = true
+		}
+		return res, nil
+	}
+}
+
+// addr returns the host:port req is sent to, from its Host and scheme.
+func (req *Request) addr() string {
+	if _, _, err := net.SplitHostPort(req.Host); err == nil {
+		return req.Host
+	}
+	port := "80"
+	if req.scheme() == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(req.Host, port)
+}
+
+// exchange writes req on pc and reads the response.
+func (t *Transport) exchange(ctx context.Context, pc *persistConn, req *Request) (*Response, error) {
+	stop := interruptOnDone(ctx, pc.conn)
+	if err := pc.conn.SetDeadline(deadline(ctx, t.ResponseHeaderTimeout)); err != nil {
+		stop()
+		return nil, err
+	}
+	if err := req.Write(pc.conn); err != nil {
+		stop()
+		return nil, err
+	}
+	res, err := readFinalResponse(pc.br, req)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	if err := pc.conn.SetDeadline(deadline(ctx, t.ResponseBodyTimeout)); err != nil {
+		stop()
+		return nil, err
+	}
+	lr, framed := res.BodyReader.(*io.LimitedReader)
+	keep := framed && !req.Close && res.Header["Connection"] != "close"
+	res.BodyReader = &pooledBody{r: res.BodyReader, pc: pc, t: t, keep: keep, limited: lr, ctx: ctx, stop: stop}
+	return res, nil
+}
+
+// getConn returns an idle connection to addr using scheme if there is
+// one, or dials a new one, and reports whether it was reused.
+func (t *Transport) getConn(ctx context.Context, scheme, addr string) (*persistConn, bool, error) {
+	key := scheme + "://" + addr
+	t.mu.Lock()
+	for conns := t.idle[key]; len(conns) > 0; conns = t.idle[key] {
+		pc := conns[len(conns)-1]
+		t.idle[key] = conns[:len(conns)-1]
+		if time.Since(pc.idleSince) < t.idleTimeout() && !t.expired(pc) {
+			t.mu.Unlock()
+			return pc, true, nil
+		}
+		_ = pc.conn.Close()
+	}
+	t.mu.Unlock()
+
+	conn, err := t.dial(ctx, scheme, addr)
+	if err != nil {
+		return nil, false, err
+	}
+	return &persistConn{conn: conn, br: bufio.NewReader(conn), key: key, created: time.Now()}, false, nil
+}
+
+// dial opens a new connection to addr using scheme.
+func (t *Transport) dial(ctx context.Context, scheme, addr string) (net.Conn, error) {
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	dialCtx := ctx
+	if t.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, t.DialTimeout)
+		defer cancel()
+	}
+	conn, err := dial(dialCtx, "tcp", addr)
+	if err != nil {
+		return nil, phaseError(dialCtx, "dial", err)
+	}
+	if scheme == "https" {
+		return t.handshake(ctx, conn, addr)
+	}
+	return conn, nil
+}
+
+// Upgrade sends req, a request with an Upgrade header, on a new
+// connection and reads the response. If it is a 101 Switching
+// Protocols, Upgrade returns the connection too, for the caller to
+// speak the new protocol on, and close. Otherwise the connection is
+// closed with the response.
+func (t *Transport) Upgrade(ctx context.Context, req *Request) (*Response, net.Conn, error) {
+	conn, err := t.dial(ctx, req.scheme(), req.addr())
+	if err != nil {
+		return nil, nil, err
+	}
+	stop := interruptOnDone(ctx, conn)
+	defer stop()
+	br := bufio.NewReader(conn)
+	err = conn.SetDeadline(deadline(ctx, t.ResponseHeaderTimeout))
+	if err == nil {
+		err = req.Write(conn)
+	}
+	var res *Response
+	if err == nil {
+		res, err = readFinalResponse(br, req)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, phaseError(ctx, "response header", err)
+	}
+	res.Request = req
+	if res.StatusCode != statusSwitchingProtocols {
+		_ = conn.SetDeadline(deadline(ctx, t.ResponseBodyTimeout))
+		res.BodyReader = &connBody{Reader: res.BodyReader, conn: conn}
+		return res, nil, nil
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return res, &bufferedConn{Conn: conn, br: br}, nil
+}
+
+// connBody is the body of a response on a connection of its own,
+// closed with it.
+type connBody struct {
+	io.Reader
+	conn net.Conn
+}
+
+func (b *connBody) Close() error { return b.conn.Close() }
+
+// bufferedConn is a connection whose reads go through br first, so
+// that the bytes br read ahead are not lost.
+type bufferedConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.br.Read(p) }
+
+// handshake runs the TLS client handshake on conn to addr.
+func (t *Transport) handshake(ctx context.Context, conn net.Conn, addr string) (net.Conn, error) {
+	cfg := &tls.Config{}
+	if t.TLSClientConfig != nil {
+		cfg = t.TLSClientConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	if t.TLSHandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.TLSHandshakeTimeout)
+		defer cancel()
+	}
+	tc := tls.Client(conn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, phaseError(ctx, "tls handshake", err)
+	}
+	return tc, nil
+}
+
+// putIdle keeps pc for reuse, or closes it if the pool for its
+// server is full or pc outlived MaxConnLifetime.
+func (t *Transport) putIdle(pc *persistConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.idle == nil {
+		t.idle = make(map[string][]*persistConn)
+	}
+	if len(t.idle[pc.key]) >= t.maxIdle() || t.expired(pc) {
+		_ = pc.conn.Close()
+		return
+	}
+	pc.idleSince = time.Now()
+	t.idle[pc.key] = append(t.idle[pc.key], pc)
+}
+
+// CloseIdleConnections closes the connections kept for reuse.
+func (t *Transport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for addr, conns := range t.idle {
+		for _, pc := range conns {
+			_ = pc.conn.Close()
+		}
+		delete(t.idle, addr)
+	}
+}
+
+// idleCount returns the number of idle connections kept.
+func (t *Transport) idleCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, conns := range t.idle {
+		n += len(conns)
+	}
+	return n
+}
+
+func (t *Transport) maxIdle() int {
+	if t.MaxIdleConnsPerHost != 0 {
+		return t.MaxIdleConnsPerHost
+	}
+	return defaultMaxIdleConnsPerHost
+}
+
+func (t *Transport) idleTimeout() time.Duration {
+	if t.IdleConnTimeout > 0 {
+		return t.IdleConnTimeout
+	}
+	return defaultIdleConnTimeout
+}
+
+// expired reports whether pc outlived MaxConnLifetime.
+func (t *Transport) expired(pc *persistConn) bool {
+	return t.MaxConnLifetime > 0 && time.Since(pc.created) >= t.MaxConnLifetime
+}
+
+// errBodyClosed is returned when reading a closed response body.
+var errBodyClosed = errors.New("read on closed response body")
+
+// pooledBody is a response body handing its connection back to the
+// Transport once the body is consumed, or closing it otherwise.
+type pooledBody struct {
+	r       io.Reader
+	limited *io.LimitedReader // nil if the body lasts until the connection closes
+	pc      *persistConn
+	t       *Transport
+	keep    bool
+
+	ctx  context.Context
+	stop func() // stops watching ctx
+
+	mu       sync.Mutex
+	released bool // the connection went back to the pool
+	closed   bool
+}
+
+func (b *pooledBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	if b.released {
+		return 0, io.EOF
+	}
+	n, err := b.r.Read(p)
+	if err == io.EOF && b.limited != nil && b.limited.N > 0 {
+		// The connection closed short of Content-Length
+		err = io.ErrUnexpectedEOF
+	}
+	if err == io.EOF && b.keep {
+		// Fully read: the connection is ready for the next request
+		b.release()
+	} else if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		err = phaseError(b.ctx, "response body", err)
+	}
+	return n, err
+}
+
+// release hands the connection back to the pool.
+// The caller must hold b.mu.
+func (b *pooledBody) release() {
+	b.stop()
+	b.released = true
+	_ = b.pc.conn.SetDeadline(time.Time{})
+	b.t.putIdle(b.pc)
+}
+
+func (b *pooledBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	if b.released {
+		return nil
+	}
+	if b.keep && b.limited.N == 0 {
+		b.release()
+		return nil
+	}
+	b.stop()
+	return b.pc.conn.Close()
+}
+
+// gzipBody decompresses a gzip response body as it is read.
+type gzipBody struct {
+	body io.ReadCloser
+	zr   *gzip.Reader
+	err  error
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.zr == nil && b.err == nil {
+		b.zr, b.err = gzip.NewReader(b.body)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.zr.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	return b.body.Close()
+}
+
+// TimeoutError reports that a phase of a client request ran out of
+// time, because of a Transport timeout or the request's context.
+type TimeoutError struct {
+	Phase string // "dial", "response header" or "response body"
+	Err   error  // the underlying error, e.g. context.DeadlineExceeded
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("tritonhttp: timeout during %v: %v", e.Phase, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// Timeout reports true, so TimeoutError is a net.Error timing out.
+func (e *TimeoutError) Timeout() bool { return true }
+
+// Temporary reports true: the same request may succeed later.
+func (e *TimeoutError) Temporary() bool { return true }
+
+// phaseError turns err from the given phase into ctx's error if ctx is
+// done, or into a *TimeoutError if err is a timeout.
+func phaseError(ctx context.Context, phase string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if errors.Is(ctxErr, context.DeadlineExceeded) {
+			return &TimeoutError{Phase: phase, Err: ctxErr}
+		}
+		return fmt.Errorf("tritonhttp: %v: %w", phase, ctxErr)
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		// The connection deadline may fire just before ctx notices
+		if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			return &TimeoutError{Phase: phase, Err: context.DeadlineExceeded}
+		}
+		return &TimeoutError{Phase: phase, Err: err}
+	}
+	return err
+}
+
+// deadline returns the earlier of ctx's deadline and timeout from
+// now, or the zero time if there is neither.
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	d, ok := ctx.Deadline()
+	if timeout > 0 {
+		if t := time.Now().Add(timeout); !ok || t.Before(d) {
+			return t
+		}
+	}
+	if ok {
+		return d
+	}
+	return time.Time{}
+}
+
+// aLongTimeAgo is a deadline in the past, making blocked I/O fail.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// interruptOnDone interrupts I/O on conn once ctx is done,
+// until the returned function is called.
+func interruptOnDone(ctx context.Context, conn net.Conn) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+	return func() { once.Do(func() { close(done) }) }
+}
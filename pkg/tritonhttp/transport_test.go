@@ -0,0 +1,270 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportReusesConns(t *testing.T) {
+	s := &Server{DocRoot: "testdata"}
+	addr, done := startTestServer(t, s)
+	defer func() {
+		s.Shutdown(context.Background())
+		<-done
+	}()
+
+	var dials atomic.Int32
+	tr := &Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials.Add(1)
+		return net.Dial(network, addr)
+	}}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	for i := 0; i < 3; i++ {
+		res, err := c.Get("http://" + addr + "/index.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(res.BodyReader); err != nil {
+			t.Fatal(err)
+		}
+		res.Close()
+	}
+	if got := dials.Load(); got != 1 {
+		t.Fatalf("dialed %v times, want 1", got)
+	}
+	if got := tr.idleCount(); got != 1 {
+		t.Fatalf("idle conns got: %v, want: 1", got)
+	}
+
+	// A response closed before its body is read gives up the connection
+	res, err := c.Get("http://" + addr + "/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Close()
+	if got := tr.idleCount(); got != 0 {
+		t.Fatalf("idle conns after early close got: %v, want: 0", got)
+	}
+}
+
+func TestTransportRetriesStaleConn(t *testing.T) {
+	s := &Server{DocRoot: "testdata"}
+	addr, done := startTestServer(t, s)
+	defer func() {
+		s.Shutdown(context.Background())
+		<-done
+	}()
+
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	get := func() {
+		res, err := c.Get("http://" + addr + "/index.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Close()
+		if _, err := io.ReadAll(res.BodyReader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get()
+
+	// The server drops the idle connection behind the client's back
+	for _, ci := range s.Connections() {
+		s.CloseConnection(ci.ID)
+	}
+	time.Sleep(10 * time.Millisecond)
+	get()
+}
+
+func TestTransportIdleTimeout(t *testing.T) {
+	tr := &Transport{IdleConnTimeout: time.Millisecond}
+	client, server := net.Pipe()
+	defer server.Close()
+	tr.putIdle(&persistConn{conn: client, key: "http://example.com:80"})
+	time.Sleep(5 * time.Millisecond)
+
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if _, reused, err := tr.getConn(context.Background(), "http", "example.com:80"); reused || err == nil {
+		t.Fatalf("expired conn got reused: %v, err: %v", reused, err)
+	}
+}
+
+func TestTransportMaxConnLifetime(t *testing.T) {
+	tr := &Transport{MaxConnLifetime: 5 * time.Millisecond}
+	client, server := net.Pipe()
+	defer server.Close()
+	tr.putIdle(&persistConn{conn: client, key: "http://example.com:80", created: time.Now()})
+	if tr.idleCount() != 1 {
+		t.Fatalf("got %v idle conns, want 1", tr.idleCount())
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if _, reused, err := tr.getConn(context.Background(), "http", "example.com:80"); reused || err == nil {
+		t.Fatalf("expired conn got reused: %v, err: %v", reused, err)
+	}
+	// Nor is it kept once its response is read
+	tr.putIdle(&persistConn{conn: client, key: "http://example.com:80", created: time.Now().Add(-time.Second)})
+	if tr.idleCount() != 0 {
+		t.Fatalf("got %v idle conns, want 0", tr.idleCount())
+	}
+}
+
+// stallingServer accepts connections and answers each request with
+// head, then stalls until the test ends.
+func stallingServer(t *testing.T, head string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go func() {
+				buf := make([]byte, 1024)
+				conn.Read(buf)
+				conn.Write([]byte(head))
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestTransportTimeouts(t *testing.T) {
+	var tests = []struct {
+		name      string
+		head      string
+		tr        *Transport
+		wantPhase string
+	}{
+		{"Header", "", &Transport{ResponseHeaderTimeout: 20 * time.Millisecond}, "response header"},
+		{"Body", "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nabc", &Transport{ResponseBodyTimeout: 20 * time.Millisecond}, "response body"},
+		{"Dial", "", &Transport{
+			DialTimeout: 20 * time.Millisecond,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		}, "dial"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := stallingServer(t, tt.head)
+			c := &Client{Transport: tt.tr}
+			res, err := c.Get("http://" + addr + "/")
+			if err == nil {
+				defer res.Close()
+				_, err = io.ReadAll(res.BodyReader)
+			}
+			var te *TimeoutError
+			if !errors.As(err, &te) || te.Phase != tt.wantPhase {
+				t.Fatalf("got error: %v, want a %v timeout", err, tt.wantPhase)
+			}
+			var ne net.Error
+			if !errors.As(err, &ne) || !ne.Timeout() {
+				t.Fatalf("%v is not a net.Error timeout", err)
+			}
+		})
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	addr := stallingServer(t, "")
+	c := &Client{Transport: &Transport{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := c.GetContext(ctx, "http://"+addr+"/"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled got: %v, want: %v", err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.GetContext(ctx, "http://"+addr+"/")
+	var te *TimeoutError
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &te) {
+		t.Fatalf("deadline got: %v, want a *TimeoutError wrapping %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestTransportDecompression(t *testing.T) {
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	zw.Write([]byte("hello, gzip"))
+	zw.Close()
+	addr := scriptedServer(t, func(req *Request) string {
+		if req.Header["Accept-Encoding"] != "gzip" && req.URL != "/raw" {
+			return "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nplain"
+		}
+		return fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: %v\r\n\r\n%s",
+			zipped.Len(), zipped.Bytes())
+	})
+
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	for i := 0; i < 2; i++ {
+		res, err := c.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.BodyReader)
+		res.Close()
+		if err != nil || string(body) != "hello, gzip" || !res.Uncompressed {
+			t.Fatalf("got: %q, %v, uncompressed %v", body, err, res.Uncompressed)
+		}
+		if res.Header["Content-Length"] != fmt.Sprint(zipped.Len()) {
+			t.Fatalf("Content-Length got: %v, want the compressed length %v", res.Header["Content-Length"], zipped.Len())
+		}
+	}
+	if got := tr.idleCount(); got != 1 {
+		t.Fatalf("idle conns got: %v, want: 1", got)
+	}
+
+	// A caller asking for an encoding itself gets the body as sent
+	req, err := NewRequest("GET", "http://"+addr+"/raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header["Accept-Encoding"] = "gzip"
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.BodyReader)
+	res.Close()
+	if !bytes.Equal(body, zipped.Bytes()) || res.Uncompressed {
+		t.Fatalf("caller Accept-Encoding got: %q, uncompressed %v", body, res.Uncompressed)
+	}
+
+	c.Transport = &Transport{DisableCompression: true}
+	res, err = c.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(res.BodyReader)
+	res.Close()
+	if string(body) != "plain" {
+		t.Fatalf("DisableCompression got: %q, want: %q", body, "plain")
+	}
+}
@@ -0,0 +1,121 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+)
+
+// listenFDsEnv is the environment variable telling a process started by
+// Upgrade how many listening sockets it inherited, from fd 3 onwards.
+const listenFDsEnv = "TRITONHTTP_LISTEN_FDS"
+
+// firstInheritedFD is the descriptor of the first inherited listener,
+// following stdin, stdout and stderr.
+const firstInheritedFD = 3
+
+// Upgrade starts a new copy of the running binary, with the same
+// arguments, handing it the server's listening sockets. Once it
+// returns, the caller should Shutdown the server so the old process
+// drains its connections while the new one accepts the new ones.
+// The new process picks the sockets up in ListenAndServe.
+// The admin listener is closed first so the new process can bind
+// AdminAddr again.
+func (s *Server) Upgrade() (*os.Process, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.listenerFiles()
+	if err != nil {
+		return nil, err
+	}
+	if admin := s.adminServer(); admin != nil {
+		_ = admin.Close()
+	}
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%v=%v", listenFDsEnv, len(files)))
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	s.logger().Infof("Started upgraded process %v with %v listeners", cmd.Process.Pid, len(files))
+	return cmd.Process, nil
+}
+
+// listenerFiles returns duplicates of the listening sockets,
+// ordered by address.
+func (s *Server) listenerFiles() ([]*os.File, error) {
+	s.mu.Lock()
+	lns := make([]net.Listener, 0, len(s.listeners))
+	for ln := range s.listeners {
+		lns = append(lns, ln)
+	}
+	s.mu.Unlock()
+	if len(lns) == 0 {
+		return nil, fmt.Errorf("no listeners to hand off")
+	}
+	sort.Slice(lns, func(i, j int) bool { return lns[i].Addr().String() < lns[j].Addr().String() })
+
+	var files []*os.File
+	for _, ln := range lns {
+		fl, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("listener %v cannot be handed off", ln.Addr())
+		}
+		f, err := fl.File()
+		if err != nil {
+			for _, f := range files {
+				_ = f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// InheritedListeners returns the listening sockets handed over by a
+// parent process through Upgrade, or nothing if there are none.
+// The handoff is consumed, so later calls return nothing.
+func InheritedListeners() ([]net.Listener, error) {
+	count := os.Getenv(listenFDsEnv)
+	if count == "" {
+		return nil, nil
+	}
+	_ = os.Unsetenv(listenFDsEnv)
+	return inheritedListeners(count, firstInheritedFD)
+}
+
+// inheritedListeners turns count descriptors starting at first
+// into listeners.
+func inheritedListeners(count string, first uintptr) ([]net.Listener, error) {
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid %v %q", listenFDsEnv, count)
+	}
+	lns := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		f := os.NewFile(first+uintptr(i), fmt.Sprintf("listener-%v", i))
+		ln, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			for _, ln := range lns {
+				_ = ln.Close()
+			}
+			return nil, fmt.Errorf("inherited fd %v: %v", first+uintptr(i), err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
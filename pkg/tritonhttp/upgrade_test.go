@@ -0,0 +1,56 @@
+package tritonhttp
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestInheritedListeners(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	s := &Server{}
+	if !s.trackListener(ln, true) {
+		t.Fatal("trackListener failed")
+	}
+	files, err := s.listenerFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %v listener files, want 1", len(files))
+	}
+
+	// The handed off descriptor accepts connections for the same socket
+	fd, err := syscall.Dup(int(files[0].Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files[0].Close()
+	inherited, err := inheritedListeners("1", uintptr(fd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited[0].Close()
+	if got, want := inherited[0].Addr().String(), ln.Addr().String(); got != want {
+		t.Fatalf("inherited addr got: %v, want: %v", got, want)
+	}
+	go func() {
+		if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := inherited[0].Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if _, err := inheritedListeners("x", 3); err == nil {
+		t.Fatal("invalid count got no error")
+	}
+}
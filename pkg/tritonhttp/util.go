@@ -2,7 +2,9 @@ package tritonhttp
 
 import (
 	"bufio"
-	"mime"
+	"bytes"
+	"errors"
+	"net/http"
 	"net/textproto"
 	"strings"
 	"time"
@@ -34,8 +36,25 @@ func FormatTime(t time.Time) string {
 // leading dot, as in ".html". When ext has no associated type,
 // MIMETypeByExtension returns "".
 // You should use this function for the "Content-Type" header.
+//
+// The types are those of a MIMETypes registry holding the defaults of
+// NewMIMETypes, then those of the system mime.types files for other
+// extensions, and those added by AddExtensionType and LoadMIMETypes.
 func MIMETypeByExtension(ext string) string {
-	return mime.TypeByExtension(ext)
+	return defaultMIMETypeRegistry().TypeByExtension(ext)
+}
+
+// sniffLen is how many bytes at the start of a body DetectContentType
+// looks at.
+const sniffLen = 512
+
+// DetectContentType returns the MIME type of a body starting with data,
+// sniffed from its first 512 bytes as the WHATWG MIME Sniffing standard
+// says, or "application/octet-stream" if it recognizes none. You should
+// use it for the "Content-Type" header of a body whose name tells
+// nothing, as a file without a known extension.
+func DetectContentType(data []byte) string {
+	return http.DetectContentType(data)
 }
 
 // ReadLine reads a single line ending with "\r\n" from br,
@@ -59,3 +78,44 @@ func ReadLine(br *bufio.Reader) (string, error) {
 		}
 	}
 }
+
+// errLineTooLong is returned by readLineLimit when a line
+// does not fit in the allowed number of bytes.
+var errLineTooLong = errors.New("line too long")
+
+// readLineLimit is like ReadLine, but gives up with errLineTooLong
+// as soon as the line, excluding "\r\n", grows beyond max bytes.
+func readLineLimit(br *bufio.Reader, max int) (string, error) {
+	line, err := readLineSlice(br, max)
+	return string(line), err
+}
+
+// readLineSlice is readLineLimit returning the line as a slice, of
+// the buffer of br if it fits in it, only valid until the next read
+// from br.
+func readLineSlice(br *bufio.Reader, max int) ([]byte, error) {
+	s, err := br.ReadSlice('\n')
+	if err == nil && len(s) <= max+2 && bytes.HasSuffix(s, []byte("\r\n")) {
+		return s[:len(s)-2], nil
+	}
+	// A long line, or one with a bare "\n", takes several reads
+	line := append([]byte(nil), s...)
+	for {
+		if len(line) > max+2 {
+			return line, errLineTooLong
+		}
+		if err == bufio.ErrBufferFull {
+			s, err = br.ReadSlice('\n')
+			line = append(line, s...)
+			continue
+		}
+		if err != nil {
+			return line, err
+		}
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			return line[:len(line)-2], nil
+		}
+		s, err = br.ReadSlice('\n')
+		line = append(line, s...)
+	}
+}
@@ -0,0 +1,236 @@
+package tritonhttp
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// FieldError reports one invalid setting.
+type FieldError struct {
+	Field string // e.g. "Limits.ReadTimeout"
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError lists every invalid setting found by Validate.
+// Use errors.As to get at it, and errors.As or errors.Is through it
+// to get at the individual problems.
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, fe := range e.Errors {
+		errs[i] = fe
+	}
+	return errs
+}
+
+// validation collects field errors.
+type validation struct {
+	errs []*FieldError
+}
+
+// check records a problem with field if bad holds.
+func (v *validation) check(bad bool, field, format string, args ...interface{}) {
+	if bad {
+		v.add(field, fmt.Errorf(format, args...))
+	}
+}
+
+// add records err as a problem with field, unless it is nil.
+func (v *validation) add(field string, err error) {
+	if err != nil {
+		v.errs = append(v.errs, &FieldError{Field: field, Err: err})
+	}
+}
+
+// err returns the problems found as a *ValidationError, or nil.
+func (v *validation) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errs}
+}
+
+// Validate checks the configuration of s for invalid values and
+// contradictions, without changing it. It returns a *ValidationError
+// listing every problem found, or nil.
+func (s *Server) Validate() error {
+	v := &validation{}
+
+	if s.DocRoot == "" {
+		v.add("DocRoot", errors.New("must be set"))
+	} else if fi, err := s.fileSystem().Stat(s.DocRoot); err != nil {
+		v.add("DocRoot", err)
+	} else if !fi.IsDir() && (s.FS != nil || archiveKind(s.DocRoot) == "") {
+		v.add("DocRoot", fmt.Errorf("%q is neither a directory nor a .zip, .tar.gz or .tgz archive", s.DocRoot))
+	}
+
+	for i, l := range s.Listeners {
+		field := fmt.Sprintf("Listeners[%d]", i)
+		v.check(l.Addr == "", field+".Addr", "must be set")
+		for j, c := range l.Certificates {
+			v.check(c.CertFile == "" || c.KeyFile == "", fmt.Sprintf("%v.Certificates[%d]", field, j), "must name both a certificate and a key file")
+		}
+	}
+	for i, vh := range s.VirtualHosts {
+		field := fmt.Sprintf("VirtualHosts[%d]", i)
+		v.check(len(vh.Hosts) == 0, field+".Hosts", "must be set")
+		if vh.DocRoot == "" {
+			v.add(field+".DocRoot", errors.New("must be set"))
+		} else if s.FS == nil && archiveKind(s.DocRoot) != "" {
+			v.add(field+".DocRoot", errors.New("cannot be served along with an archive DocRoot"))
+		} else if fi, err := s.fileSystem().Stat(vh.DocRoot); err != nil {
+			v.add(field+".DocRoot", err)
+		} else if !fi.IsDir() {
+			v.add(field+".DocRoot", fmt.Errorf("%q is not a directory", vh.DocRoot))
+		}
+	}
+
+	s.Limits.validate(v, "Limits.")
+	validateMethods(v, s.AllowedMethods)
+
+	if s.AdminAddr != "" {
+		v.add("AdminAddr", checkLoopback(s.AdminAddr))
+	}
+
+	validatePolicies(v, s.BanPolicy, s.Quota, s.LoadShedding)
+
+	v.check(s.AccessLogSampling < 0, "AccessLogSampling", "must not be negative")
+	v.check(s.SlowRequestThreshold < 0, "SlowRequestThreshold", "must not be negative")
+	for i, pattern := range s.MetricLabels.Routes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			v.add(fmt.Sprintf("MetricLabels.Routes[%d]", i), fmt.Errorf("%q: %v", pattern, err))
+		}
+	}
+	v.check(!s.TrailingSlash.valid(), "TrailingSlash", "unknown policy %v", s.TrailingSlash)
+	for i, r := range s.Routes {
+		field := fmt.Sprintf("Routes[%d]", i)
+		v.check(!strings.HasPrefix(r.Prefix, "/"), field+".Prefix", "must start with \"/\", got %q", r.Prefix)
+		v.check(r.Handler == nil, field+".Handler", "must be set")
+		v.check(!r.TrailingSlash.valid(), field+".TrailingSlash", "unknown policy %v", r.TrailingSlash)
+		for j, ext := range r.Extensions {
+			v.check(!strings.HasPrefix(ext, ".") || strings.Contains(ext, "/"), fmt.Sprintf("%v.Extensions[%d]", field, j),
+				"must be a file extension such as \".php\", got %q", ext)
+		}
+	}
+	for i, prefix := range s.AttachmentPrefixes {
+		v.check(!strings.HasPrefix(prefix, "/"), fmt.Sprintf("AttachmentPrefixes[%d]", i), "must start with \"/\", got %q", prefix)
+	}
+	for i, prefix := range s.Reserved {
+		v.check(!strings.HasPrefix(prefix, "/") || prefix == "/", fmt.Sprintf("Reserved[%d]", i), "must start with \"/\" and not be the root, got %q", prefix)
+	}
+	for i, r := range s.Internal {
+		field := fmt.Sprintf("Internal[%d]", i)
+		v.check(!s.reserved(&Request{URL: r.Prefix}), field+".Prefix", "must be under a Reserved prefix, got %q", r.Prefix)
+		v.check(r.Handler == nil, field+".Handler", "must be set")
+	}
+	if c := s.CanonicalHost; c != nil {
+		v.check(strings.ContainsAny(c.Host, "/?# "), "CanonicalHost.Host", "must be a host, optionally with a port, got %q", c.Host)
+	}
+	for i, rd := range s.Redirects {
+		field := fmt.Sprintf("Redirects[%d]", i)
+		if rd.Pattern == nil {
+			v.check(!strings.HasPrefix(rd.Path, "/"), field+".Path", "must start with \"/\", got %q", rd.Path)
+		} else {
+			v.check(rd.Path != "", field+".Path", "must not be set along with Pattern")
+		}
+		v.check(rd.Location == "", field+".Location", "must be set")
+		switch rd.StatusCode {
+		case 0, statusMovedPermanently, statusFound, statusTemporaryRedirect, statusPermanentRedirect:
+		default:
+			v.add(field+".StatusCode", fmt.Errorf("must be 301, 302, 307 or 308, got %v", rd.StatusCode))
+		}
+	}
+	for i, a := range s.Aliases {
+		field := fmt.Sprintf("Aliases[%d]", i)
+		v.check(!strings.HasPrefix(a.Path, "/"), field+".Path", "must start with \"/\", got %q", a.Path)
+		v.check(a.Target != "" && !strings.HasPrefix(a.Target, "/"), field+".Target", "must start with \"/\", got %q", a.Target)
+		v.check(a.Target != "" && (a.Content != "" || a.ContentType != ""), field+".Content", "must not be set along with Target")
+	}
+	for i, rw := range s.Rewrites {
+		field := fmt.Sprintf("Rewrites[%d]", i)
+		if rw.Pattern == nil {
+			v.check(!strings.HasPrefix(rw.Prefix, "/"), field+".Prefix", "must start with \"/\", got %q", rw.Prefix)
+		} else {
+			v.check(rw.Prefix != "", field+".Prefix", "must not be set along with Pattern")
+		}
+		v.check(!strings.HasPrefix(rw.Replacement, "/"), field+".Replacement", "must start with \"/\", got %q", rw.Replacement)
+	}
+	for i, d := range s.WebDAV {
+		v.check(!strings.HasPrefix(d.Prefix, "/"), fmt.Sprintf("WebDAV[%d].Prefix", i), "must start with \"/\", got %q", d.Prefix)
+	}
+	if s.SSI != nil {
+		v.check(s.SSI.MaxDepth < 0, "SSI.MaxDepth", "must not be negative")
+	}
+	if s.GeoIP != nil {
+		validateGeoIP(v, s.GeoIP)
+	}
+	if s.ForwardProxy != nil {
+		for i, pattern := range s.ForwardProxy.Allow {
+			if _, err := path.Match(pattern, ""); err != nil {
+				v.add(fmt.Sprintf("ForwardProxy.Allow[%d]", i), fmt.Errorf("%q: %v", pattern, err))
+			}
+		}
+		v.check(s.ForwardProxy.DialTimeout < 0, "ForwardProxy.DialTimeout", "must not be negative")
+	}
+	if s.StatsD != nil {
+		r := s.StatsD.SampleRate
+		v.check(r <= 0 || r > 1, "StatsD.SampleRate", "must be in (0, 1], got %v", r)
+	}
+	if s.Capture != nil {
+		v.check(s.Capture.Dir == "", "Capture.Dir", "must be set")
+		v.check(s.Capture.MaxBytes < 0, "Capture.MaxBytes", "must not be negative")
+	}
+	return v.err()
+}
+
+// validatePolicies records the problems with the ban policy, the
+// bandwidth quota and the load shedding configuration in v.
+func validatePolicies(v *validation, bp BanPolicy, q BandwidthQuota, ls LoadShedding) {
+	v.check(bp.Threshold < 0, "BanPolicy.Threshold", "must not be negative")
+	v.check(bp.Window < 0, "BanPolicy.Window", "must not be negative")
+	v.check(bp.Duration < 0, "BanPolicy.Duration", "must not be negative")
+	v.check(bp.Threshold > 0 && bp.Duration == 0, "BanPolicy.Duration", "must be set when Threshold is")
+
+	v.check(q.Bytes < 0, "Quota.Bytes", "must not be negative")
+	v.check(q.Window < 0 || (q.Bytes > 0 && q.Window == 0), "Quota.Window", "must be positive when Bytes is set")
+
+	v.check(ls.Fraction < 0 || ls.Fraction > 1, "LoadShedding.Fraction", "must be in [0, 1], got %v", ls.Fraction)
+	v.check(ls.MaxConns < 0, "LoadShedding.MaxConns", "must not be negative")
+	v.check(ls.MaxInFlight < 0, "LoadShedding.MaxInFlight", "must not be negative")
+	v.check(ls.MaxLatency < 0, "LoadShedding.MaxLatency", "must not be negative")
+	v.check(ls.RetryAfter < 0, "LoadShedding.RetryAfter", "must not be negative")
+}
+
+// ApplyDefaults fills in the settings of s left at their zero value
+// with the defaults the server would otherwise use implicitly, so they
+// can be inspected or logged.
+func (s *Server) ApplyDefaults() {
+	s.Limits = s.Limits.withDefaults()
+	if s.Logger == nil {
+		s.Logger = NewLogger(nil, LevelWarn)
+	}
+	if s.AccessLogSampling == 0 {
+		s.AccessLogSampling = 1
+	}
+}
@@ -0,0 +1,173 @@
+package tritonhttp
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestServerValidate(t *testing.T) {
+	dir := t.TempDir()
+	var tests = []struct {
+		name       string
+		s          *Server
+		wantFields []string
+	}{
+		{"Valid", &Server{DocRoot: dir}, nil},
+		{"MissingDocRoot", &Server{}, []string{"DocRoot"}},
+		{"NoSuchDocRoot", &Server{DocRoot: dir + "/missing"}, []string{"DocRoot"}},
+		{
+			"Several",
+			&Server{
+				DocRoot:        dir,
+				Limits:         Limits{ReadTimeout: -1, MaxConns: -1},
+				AllowedMethods: []string{"GET", "get", "CONNECT"},
+				BanPolicy:      BanPolicy{Threshold: 3},
+				LoadShedding:   LoadShedding{Fraction: 2},
+				StatsD:         &StatsD{},
+			},
+			[]string{"Limits.MaxConns", "Limits.ReadTimeout", "AllowedMethods[1]", "AllowedMethods[2]", "BanPolicy.Duration", "LoadShedding.Fraction", "StatsD.SampleRate"},
+		},
+		{
+			"Contradictions",
+			&Server{
+				DocRoot: dir,
+				Limits:  Limits{MaxConns: 10, MaxConnsPerIP: 20},
+				Quota:   BandwidthQuota{Bytes: 1 << 20},
+			},
+			[]string{"Limits.MaxConnsPerIP", "Quota.Window"},
+		},
+		{
+			"BadPatterns",
+			&Server{
+				DocRoot:      dir,
+				AdminAddr:    ":6060",
+				MetricLabels: MetricLabels{Routes: []string{"/ok/", "/bad["}},
+				Capture:      &Capture{},
+			},
+			[]string{"AdminAddr", "MetricLabels.Routes[1]", "Capture.Dir"},
+		},
+		{
+			"BadRoutes",
+			&Server{
+				DocRoot: dir,
+				Routes: []Route{
+					{Prefix: "api", Handler: &FastCGI{}},
+					{Prefix: "/", Extensions: []string{".php", "php"}, Handler: &FastCGI{}},
+				},
+				ForwardProxy: &ForwardProxy{Allow: []string{"[bad"}},
+			},
+			[]string{"Routes[0].Prefix", "Routes[1].Extensions[1]", "ForwardProxy.Allow[0]"},
+		},
+		{
+			"BadRewrites",
+			&Server{
+				DocRoot: dir,
+				Aliases: []Alias{
+					{Path: "favicon.ico", Target: "static/favicon.ico"},
+					{Path: "/robots.txt", Target: "/static/robots.txt", Content: "User-agent: *"},
+				},
+				Rewrites: []Rewrite{
+					{Prefix: "old", Replacement: "/new"},
+					{Prefix: "/old", Pattern: regexp.MustCompile("^/old"), Replacement: "new"},
+				},
+			},
+			[]string{"Aliases[0].Path", "Aliases[0].Target", "Aliases[1].Content", "Rewrites[0].Prefix", "Rewrites[1].Prefix", "Rewrites[1].Replacement"},
+		},
+		{
+			"BadModules",
+			&Server{
+				DocRoot: dir,
+				WebDAV:  []WebDAV{{Prefix: "/dav/"}, {Prefix: "files/"}},
+				SSI:     &SSI{MaxDepth: -1},
+				GeoIP:   &GeoIP{Policies: []GeoPolicy{{Prefix: "/admin", Allow: []string{"US", "USA", "AS1"}}}},
+			},
+			[]string{"WebDAV[1].Prefix", "SSI.MaxDepth", "GeoIP.Databases", "GeoIP.Policies[0]"},
+		},
+		{
+			"BadRedirects",
+			&Server{
+				DocRoot:       dir,
+				CanonicalHost: &CanonicalHost{Host: "https://example.com"},
+				Redirects: []Redirect{
+					{Path: "old", Location: "/new"},
+					{Path: "/old", Pattern: regexp.MustCompile("^/old"), StatusCode: 303},
+				},
+			},
+			[]string{"CanonicalHost.Host", "Redirects[0].Path", "Redirects[1].Path", "Redirects[1].Location", "Redirects[1].StatusCode"},
+		},
+		{
+			"BadReserved",
+			&Server{
+				DocRoot:            dir,
+				AttachmentPrefixes: []string{"files/"},
+				Reserved:           []string{"/_health", "_admin", "admin/"},
+				Internal: []Route{
+					{Prefix: "/_health", Handler: HandlerFunc(func(*Request) *Response { return nil })},
+					{Prefix: "/status"},
+				},
+			},
+			[]string{"AttachmentPrefixes[0]", "Reserved[1]", "Reserved[2]", "Internal[1].Prefix", "Internal[1].Handler"},
+		},
+		{
+			"BadHosts",
+			&Server{
+				DocRoot: dir,
+				Listeners: []Listener{
+					{Addr: ":8443", Certificates: []Certificate{{CertFile: "cert.pem", KeyFile: "key.pem"}}},
+					{Certificates: []Certificate{{CertFile: "cert.pem"}}},
+				},
+				VirtualHosts: []VirtualHost{
+					{Hosts: []string{"example.org"}, DocRoot: dir},
+					{DocRoot: dir + "/missing"},
+				},
+			},
+			[]string{"Listeners[1].Addr", "Listeners[1].Certificates[0]", "VirtualHosts[1].Hosts", "VirtualHosts[1].DocRoot"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.s.Validate()
+			if tt.wantFields == nil {
+				if err != nil {
+					t.Fatalf("got error: %v", err)
+				}
+				return
+			}
+			var ve *ValidationError
+			if !errors.As(err, &ve) {
+				t.Fatalf("got: %v, want a *ValidationError", err)
+			}
+			var fields []string
+			for _, fe := range ve.Errors {
+				fields = append(fields, fe.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Fatalf("invalid fields got: %v, want: %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestValidationErrorUnwrap(t *testing.T) {
+	err := (&Server{DocRoot: "/no/such/dir"}).Validate()
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got: %v, want it to wrap os.ErrNotExist", err)
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	s := &Server{Limits: Limits{ReadTimeout: time.Second}}
+	s.ApplyDefaults()
+	want := DefaultLimits()
+	want.ReadTimeout = time.Second
+	if s.Limits != want {
+		t.Fatalf("limits got: %+v, want: %+v", s.Limits, want)
+	}
+	if s.Logger == nil || s.AccessLogSampling != 1 {
+		t.Fatalf("got Logger %v, AccessLogSampling %v", s.Logger, s.AccessLogSampling)
+	}
+}
@@ -0,0 +1,72 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// VirtualHost serves the requests for some hosts from a doc root of
+// their own instead of DocRoot. Everything else, from the routes to
+// the WebDAV mounts, applies to them alike.
+type VirtualHost struct {
+	// Hosts are the names of the hosts, e.g. "example.org" and
+	// "www.example.org", matched against the Host header of requests,
+	// without its port, regardless of case.
+	Hosts []string
+
+	// DocRoot is the directory their files are served from.
+	DocRoot string
+}
+
+// resolveVirtualHosts resolves the DocRoots of VirtualHosts, as
+// resolveDocRoot does DocRoot. On error those resolved before stay in
+// use.
+func (s *Server) resolveVirtualHosts() error {
+	if len(s.VirtualHosts) == 0 {
+		return nil
+	}
+	roots := make([]string, len(s.VirtualHosts))
+	for i, vh := range s.VirtualHosts {
+		root := filepath.Clean(vh.DocRoot)
+		if s.FS == nil {
+			var err error
+			if root, err = filepath.EvalSymlinks(root); err != nil {
+				return err
+			}
+			if root, err = filepath.Abs(root); err != nil {
+				return err
+			}
+		}
+		fi, err := s.fileSystem().Stat(root)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("doc root %q of %v is not a directory", vh.DocRoot, strings.Join(vh.Hosts, ", "))
+		}
+		roots[i] = root
+	}
+	s.vhostRoots.Store(&roots)
+	return nil
+}
+
+// rootFor returns the directory the files req asks for are served
+// from: that of the VirtualHost it is for, if any, or the doc root.
+func (s *Server) rootFor(req *Request) string {
+	if len(s.VirtualHosts) == 0 {
+		return s.root()
+	}
+	host := splitHost(req.Host)
+	for i, vh := range s.VirtualHosts {
+		for _, h := range vh.Hosts {
+			if strings.EqualFold(h, host) {
+				if roots := s.vhostRoots.Load(); roots != nil && i < len(*roots) {
+					return (*roots)[i]
+				}
+				return filepath.Clean(vh.DocRoot)
+			}
+		}
+	}
+	return s.root()
+}
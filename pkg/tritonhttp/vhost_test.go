@@ -0,0 +1,67 @@
+package tritonhttp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	dir := t.TempDir()
+	for _, site := range []string{"main", "org-v1", "org-v2"} {
+		if err := os.Mkdir(filepath.Join(dir, site), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, site, "index.html"), []byte(site), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	org := filepath.Join(dir, "org")
+	if err := os.Symlink("org-v1", org); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		DocRoot:      filepath.Join(dir, "main"),
+		VirtualHosts: []VirtualHost{{Hosts: []string{"example.org", "www.example.org"}, DocRoot: org}},
+		ErrorLog:     NewLogger(nil, LevelError),
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := startTestServer(t, s)
+	get := func(host string) string {
+		res := exchangeRaw(t, addr, "GET / HTTP/1.1\r\nHost: "+host+"\r\nConnection: close\r\n\r\n", 1)[0]
+		body, _ := io.ReadAll(res.BodyReader)
+		return string(body)
+	}
+
+	var tests = []struct {
+		host string
+		want string
+	}{
+		{"example.org", "org-v1"},
+		{"WWW.Example.org:8080", "org-v1"},
+		{"example.com", "main"},
+		{"sub.example.org", "main"},
+	}
+	for _, tt := range tests {
+		if got := get(tt.host); got != tt.want {
+			t.Errorf("Host %v got %q, want %q", tt.host, got, tt.want)
+		}
+	}
+
+	// Reloading follows the doc roots of the virtual hosts too
+	if err := os.Remove(org); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("org-v2", org); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := get("example.org"); got != "org-v2" {
+		t.Errorf("after reload got %q, want org-v2", got)
+	}
+}
@@ -0,0 +1,318 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const defaultWebDAVRealm = "TritonHTTP"
+
+// davMethods are the methods of WebDAV requests, accepted by
+// ReadRequest when the server has WebDAV mounts, by their name.
+var davMethods = map[string]string{
+	"OPTIONS": "OPTIONS", "PROPFIND": "PROPFIND", "PUT": "PUT", "DELETE": "DELETE", "MKCOL": "MKCOL",
+}
+
+// WebDAV mounts the part of the doc root under Prefix for WebDAV
+// clients, e.g. Finder, Explorer or rclone, to browse it with PROPFIND
+// and, unless ReadOnly, change it: uploading files with PUT, deleting
+// them with DELETE and making directories with MKCOL. The files are
+// still served by GET and HEAD as any other. Changes are made on the file
+// system of the operating system: with Server.FS set, every mount is
+// read-only.
+type WebDAV struct {
+	// Prefix is the path of the mount, e.g. "/dav/".
+	Prefix string
+
+	// ReadOnly refuses the requests changing files with 403 Forbidden.
+	ReadOnly bool
+
+	// Credentials, if set, maps the users clients must authenticate
+	// as, with Basic Authorization, to their passwords. Requests under
+	// Prefix without valid credentials, GETs included, are refused
+	// with 401 Unauthorized.
+	Credentials map[string]string
+
+	// Realm is that of the WWW-Authenticate challenge; "" means
+	// "TritonHTTP".
+	Realm string
+}
+
+// webDAV returns the WebDAV mount req is under, if any, by its path
+// as it names a file, percent-escapes decoded and dot segments removed.
+func (s *Server) webDAV(req *Request) *WebDAV {
+	urlPath, ok := decodedPath(req.URL)
+	if !ok {
+		return nil
+	}
+	for i := range s.WebDAV {
+		d := &s.WebDAV[i]
+		if prefixMatches(d.Prefix, urlPath) || urlPath+"/" == d.Prefix {
+			return d
+		}
+	}
+	return nil
+}
+
+// serveWebDAV answers req if it is a WebDAV request, or one under a
+// mount its client may not make; it returns nil for those to be
+// served as usual, including all those outside of the mounts.
+func (s *Server) serveWebDAV(req *Request) *Response {
+	d := s.webDAV(req)
+	if d == nil {
+		return nil
+	}
+	if !basicAuthorized(req.Header["Authorization"], d.Credentials) {
+		realm := d.Realm
+		if realm == "" {
+			realm = defaultWebDAVRealm
+		}
+		res := davResponse(req, statusUnauthorized)
+		res.Header["Www-Authenticate"] = "Basic realm=" + strconv.Quote(realm)
+		return res
+	}
+	readOnly := d.ReadOnly || s.FS != nil
+	switch req.Method {
+	case "GET", "HEAD":
+		return nil
+	case "OPTIONS":
+		res := davResponse(req, statusNoContent)
+		res.Header["Dav"] = "1"
+		res.Header["Ms-Author-Via"] = "DAV"
+		res.Header["Allow"] = d.allow(readOnly)
+		return res
+	case "PROPFIND":
+		return s.propfind(req)
+	case "PUT":
+		return s.davChange(req, d, readOnly, davPut)
+	case "DELETE":
+		return s.davChange(req, d, readOnly, davDelete)
+	case "MKCOL":
+		return s.davChange(req, d, readOnly, davMkcol)
+	}
+	res := s.methodNotAllowed(req)
+	res.Header["Allow"] = d.allow(readOnly)
+	return res
+}
+
+// davChange answers req, a request to change the file it names under
+// the mount d, with change, unless the mount is readOnly, or the file
+// the mount itself or outside of it.
+func (s *Server) davChange(req *Request, d *WebDAV, readOnly bool, change func(req *Request, name string) *Response) *Response {
+	if readOnly {
+		return davResponse(req, statusForbidden)
+	}
+	root := s.rootFor(req)
+	name, ok := fileName(root, req.URL)
+	if !ok {
+		return davResponse(req, statusNotFound)
+	}
+	if mount := filepath.Clean(root + d.Prefix); name == mount || !within(mount, name) {
+		// The mount itself stays, and nothing outside it is changed
+		return davResponse(req, statusForbidden)
+	}
+	return change(req, name)
+}
+
+// davHead reports whether req is a HEAD under a WebDAV mount, which
+// is served as the GET the Allow header of the mount lists is.
+func (s *Server) davHead(req *Request) bool {
+	return req.Method == "HEAD" && s.methodAllowed("GET") && s.webDAV(req) != nil
+}
+
+// allow returns the Allow header of the mount.
+func (d *WebDAV) allow(readOnly bool) string {
+	if readOnly {
+		return "OPTIONS, GET, HEAD, PROPFIND"
+	}
+	return "OPTIONS, GET, HEAD, PROPFIND, PUT, DELETE, MKCOL"
+}
+
+// davResponse returns a response to req with status, and its reason
+// as body, framed so that the connection can be kept alive.
+func davResponse(req *Request, status int) *Response {
+	res := NewResponse(status)
+	if req.Close {
+		res.Header["Connection"] = "close"
+	}
+	if status != statusNoContent {
+		res.Text(status, StatusText(status)+"\n")
+	}
+	return res
+}
+
+// davPut writes the body of req to the file name, through a temporary
+// file renamed over it once complete, so that a failed upload leaves
+// any previous version in place.
+func davPut(req *Request, name string) *Response {
+	fi, err := os.Stat(name)
+	existed := err == nil
+	if existed && fi.IsDir() {
+		return davResponse(req, statusMethodNotAllowed)
+	}
+	if pfi, err := os.Stat(filepath.Dir(name)); err != nil || !pfi.IsDir() {
+		return davResponse(req, statusConflict)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return davResponse(req, statusInternalServerError)
+	}
+	body := req.Body
+	if body == nil {
+		body = bytes.NewReader(nil)
+	}
+	_, err = io.Copy(tmp, body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		if errors.Is(err, ErrBodyTooLarge) {
+			return davResponse(req, statusPayloadTooLarge)
+		}
+		return davResponse(req, statusInternalServerError)
+	}
+	if existed {
+		return davResponse(req, statusNoContent)
+	}
+	return davResponse(req, statusCreated)
+}
+
+// davDelete deletes the file or directory name.
+func davDelete(req *Request, name string) *Response {
+	if _, err := os.Lstat(name); errors.Is(err, fs.ErrNotExist) {
+		return davResponse(req, statusNotFound)
+	}
+	if err := os.RemoveAll(name); err != nil {
+		return davResponse(req, statusInternalServerError)
+	}
+	return davResponse(req, statusNoContent)
+}
+
+// davMkcol makes the directory name.
+func davMkcol(req *Request, name string) *Response {
+	if req.Body != nil {
+		// No MKCOL request body format is supported
+		return davResponse(req, statusUnsupportedMediaType)
+	}
+	if _, err := os.Lstat(name); err == nil {
+		return davResponse(req, statusMethodNotAllowed)
+	}
+	if err := os.Mkdir(name, 0o755); errors.Is(err, fs.ErrNotExist) {
+		return davResponse(req, statusConflict)
+	} else if err != nil {
+		return davResponse(req, statusInternalServerError)
+	}
+	return davResponse(req, statusCreated)
+}
+
+// propfind answers req, a PROPFIND, with the properties of the file
+// or directory it names, and of the entries of the directory unless
+// its Depth is 0. Every property is sent, whichever were asked for,
+// and an infinite Depth is taken as 1.
+func (s *Server) propfind(req *Request) *Response {
+	urlPath, _, _ := strings.Cut(req.URL, "?")
+	name, ok := fileName(s.rootFor(req), urlPath)
+	if !ok {
+		return davResponse(req, statusNotFound)
+	}
+	fsys := s.fileSystem()
+	fi, err := fsys.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return davResponse(req, statusNotFound)
+	} else if err != nil {
+		return davResponse(req, statusInternalServerError)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<D:multistatus xmlns:D="DAV:">` + "\n")
+	href := urlPath
+	if fi.IsDir() && !strings.HasSuffix(href, "/") {
+		href += "/"
+	}
+	writePropResponse(&b, href, fi)
+	if fi.IsDir() && req.Header["Depth"] != "0" {
+		entries, err := fsys.ReadDir(name)
+		if err != nil {
+			return davResponse(req, statusInternalServerError)
+		}
+		for _, e := range entries {
+			efi, err := e.Info()
+			if err != nil {
+				continue
+			}
+			eHref := href + (&url.URL{Path: e.Name()}).EscapedPath()
+			if efi.IsDir() {
+				eHref += "/"
+			}
+			writePropResponse(&b, eHref, efi)
+		}
+	}
+	b.WriteString("</D:multistatus>\n")
+
+	res := NewResponse(statusMultiStatus)
+	if req.Close {
+		res.Header["Connection"] = "close"
+	}
+	res.setBody(statusMultiStatus, "application/xml; charset=utf-8", b.Bytes())
+	return res
+}
+
+// writePropResponse writes the response element of the properties of
+// fi, at href, to b.
+func writePropResponse(b *bytes.Buffer, href string, fi fs.FileInfo) {
+	b.WriteString("<D:response><D:href>")
+	_ = xml.EscapeText(b, []byte(href))
+	b.WriteString("</D:href><D:propstat><D:prop><D:displayname>")
+	_ = xml.EscapeText(b, []byte(fi.Name()))
+	b.WriteString("</D:displayname>")
+	if fi.IsDir() {
+		b.WriteString("<D:resourcetype><D:collection/></D:resourcetype>")
+	} else {
+		b.WriteString("<D:resourcetype/><D:getcontentlength>")
+		b.WriteString(strconv.FormatInt(fi.Size(), 10))
+		b.WriteString("</D:getcontentlength>")
+		if t := MIMETypeByExtension(path.Ext(fi.Name())); t != "" {
+			b.WriteString("<D:getcontenttype>")
+			_ = xml.EscapeText(b, []byte(t))
+			b.WriteString("</D:getcontenttype>")
+		}
+	}
+	b.WriteString("<D:getlastmodified>")
+	b.WriteString(FormatTime(fi.ModTime()))
+	b.WriteString("</D:getlastmodified></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>\n")
+}
+
+// basicAuthorized reports whether the Authorization header auth holds
+// valid Basic credentials, or none are needed.
+func basicAuthorized(auth string, credentials map[string]string) bool {
+	if len(credentials) == 0 {
+		return true
+	}
+	scheme, encoded, _ := strings.Cut(auth, " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	user, password, _ := strings.Cut(string(decoded), ":")
+	want, ok := credentials[user]
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
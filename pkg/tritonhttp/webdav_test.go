@@ -0,0 +1,223 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startWebDAVServer starts a server of a doc root holding dav/a.txt
+// and pub/b.txt, with the mounts dav, and pub read-only.
+func startWebDAVServer(t *testing.T, credentials map[string]string) (addr, root string) {
+	root = t.TempDir()
+	for _, name := range []string{"dav/a.txt", "pub/b.txt"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), []byte("hello"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{
+		DocRoot:  root,
+		ErrorLog: NewLogger(nil, LevelError),
+		WebDAV: []WebDAV{
+			{Prefix: "/dav/", Credentials: credentials},
+			{Prefix: "/pub/", ReadOnly: true, Credentials: credentials},
+		},
+	}
+	addr, _ = startTestServer(t, s)
+	return addr, root
+}
+
+func TestWebDAV(t *testing.T) {
+	addr, root := startWebDAVServer(t, nil)
+	var tests = []struct {
+		name       string
+		raw        string
+		wantStatus int
+		wantHeader map[string]string
+		wantBody   []string
+		wantFile   string // the contents of dav/c.txt afterwards, "-" if missing
+	}{
+		{"Options", "OPTIONS /dav/ HTTP/1.1\r\nHost: test\r\n\r\n", 204,
+			map[string]string{"Dav": "1", "Allow": "OPTIONS, GET, HEAD, PROPFIND, PUT, DELETE, MKCOL"}, nil, "-"},
+		{"OptionsReadOnly", "OPTIONS /pub/ HTTP/1.1\r\nHost: test\r\n\r\n", 204,
+			map[string]string{"Allow": "OPTIONS, GET, HEAD, PROPFIND"}, nil, "-"},
+		{"PropfindDepth0", "PROPFIND /dav HTTP/1.1\r\nHost: test\r\nDepth: 0\r\n\r\n", 207,
+			map[string]string{"Content-Type": "application/xml; charset=utf-8"},
+			[]string{"<D:href>/dav/</D:href>", "<D:collection/>"}, "-"},
+		{"PropfindDepth1", "PROPFIND /dav/ HTTP/1.1\r\nHost: test\r\nDepth: 1\r\n\r\n", 207, nil,
+			[]string{"<D:href>/dav/a.txt</D:href>", "<D:getcontentlength>5</D:getcontentlength>", "<D:getcontenttype>text/plain"}, "-"},
+		{"PropfindMissing", "PROPFIND /dav/x.txt HTTP/1.1\r\nHost: test\r\n\r\n", 404, nil, nil, "-"},
+		{"Put", "PUT /dav/c.txt HTTP/1.1\r\nHost: test\r\nContent-Length: 3\r\n\r\nabc", 201, nil, nil, "abc"},
+		{"PutOver", "PUT /dav/c.txt HTTP/1.1\r\nHost: test\r\nContent-Length: 2\r\n\r\nde", 204, nil, nil, "de"},
+		{"PutNoParent", "PUT /dav/x/c.txt HTTP/1.1\r\nHost: test\r\nContent-Length: 1\r\n\r\nx", 409, nil, nil, "de"},
+		{"PutChunked", "PUT /dav/c.txt HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n", 501,
+			map[string]string{"Connection": "close"}, nil, "de"},
+		{"Delete", "DELETE /dav/c.txt HTTP/1.1\r\nHost: test\r\n\r\n", 204, nil, nil, "-"},
+		{"DeleteMissing", "DELETE /dav/c.txt HTTP/1.1\r\nHost: test\r\n\r\n", 404, nil, nil, "-"},
+		{"DeleteMount", "DELETE /dav/ HTTP/1.1\r\nHost: test\r\n\r\n", 403, nil, nil, "-"},
+		{"Mkcol", "MKCOL /dav/d HTTP/1.1\r\nHost: test\r\n\r\n", 201, nil, nil, "-"},
+		{"MkcolExists", "MKCOL /dav/d HTTP/1.1\r\nHost: test\r\n\r\n", 405, nil, nil, "-"},
+		{"MkcolNoParent", "MKCOL /dav/x/y HTTP/1.1\r\nHost: test\r\n\r\n", 409, nil, nil, "-"},
+		{"ReadOnly", "PUT /pub/c.txt HTTP/1.1\r\nHost: test\r\nContent-Length: 1\r\n\r\nx", 403, nil, nil, "-"},
+		{"OutsideMounts", "PROPFIND /index.html HTTP/1.1\r\nHost: test\r\n\r\n", 405,
+			map[string]string{"Allow": "GET, OPTIONS"}, nil, "-"},
+		{"Get", "GET /dav/a.txt HTTP/1.1\r\nHost: test\r\n\r\n", 200, nil, []string{"hello"}, "-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := exchangeRaw(t, addr, tt.raw, 1)[0]
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+			for k, v := range tt.wantHeader {
+				if res.Header[k] != v {
+					t.Errorf("got %v: %q, want %q", k, res.Header[k], v)
+				}
+			}
+			body, _ := io.ReadAll(res.BodyReader)
+			for _, want := range tt.wantBody {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("got body %q, want it to contain %q", body, want)
+				}
+			}
+			got, err := os.ReadFile(filepath.Join(root, "dav/c.txt"))
+			if err != nil {
+				got = []byte("-")
+			}
+			if string(got) != tt.wantFile {
+				t.Errorf("got dav/c.txt %q, want %q", got, tt.wantFile)
+			}
+		})
+	}
+	if fi, err := os.Stat(filepath.Join(root, "dav/d")); err != nil || !fi.IsDir() {
+		t.Errorf("MKCOL made no directory: %v", err)
+	}
+}
+
+func TestWebDAVAuth(t *testing.T) {
+	addr, _ := startWebDAVServer(t, map[string]string{"alice": "secret"})
+	var tests = []struct {
+		name       string
+		auth       string
+		wantStatus int
+	}{
+		{"None", "", 401},
+		{"WrongPassword", basicAuth("alice", "guess"), 401},
+		{"Valid", basicAuth("alice", "secret"), 207},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "PROPFIND /dav/ HTTP/1.1\r\nHost: test\r\n"
+			if tt.auth != "" {
+				raw += "Authorization: " + tt.auth + "\r\n"
+			}
+			res := exchangeRaw(t, addr, raw+"\r\n", 1)[0]
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == 401 && res.Header["Www-Authenticate"] != `Basic realm="TritonHTTP"` {
+				t.Errorf("got WWW-Authenticate %q", res.Header["Www-Authenticate"])
+			}
+		})
+	}
+
+	// GETs under a mount need credentials too
+	if res := exchangeRaw(t, addr, "GET /dav/a.txt HTTP/1.1\r\nHost: test\r\n\r\n", 1)[0]; res.StatusCode != 401 {
+		t.Errorf("GET under a mount got status %v, want 401", res.StatusCode)
+	}
+}
+
+func TestWebDAVEncodedDotSegments(t *testing.T) {
+	addr, root := startWebDAVServer(t, nil)
+	var tests = []struct {
+		name       string
+		raw        string
+		wantStatus int
+	}{
+		{"DeleteRoot", "DELETE /dav/%2e%2e HTTP/1.1\r\nHost: test\r\n\r\n", 405},
+		{"DeleteRootSlash", "DELETE /dav/%2e%2e/ HTTP/1.1\r\nHost: test\r\n\r\n", 405},
+		{"DeleteOtherMount", "DELETE /dav/%2e%2e/pub/b.txt HTTP/1.1\r\nHost: test\r\n\r\n", 403},
+		{"DeleteEscapedSlashes", "DELETE /dav/%2E%2E%2Fpub%2Fb.txt HTTP/1.1\r\nHost: test\r\n\r\n", 403},
+		{"DeleteMount", "DELETE /dav/x/%2e%2e/ HTTP/1.1\r\nHost: test\r\n\r\n", 403},
+		{"PutOutside", "PUT /dav/%2e%2e/evil.txt HTTP/1.1\r\nHost: test\r\nContent-Length: 1\r\n\r\nx", 405},
+		{"MkcolOutside", "MKCOL /dav/%2e%2e/evil HTTP/1.1\r\nHost: test\r\n\r\n", 405},
+		{"PropfindOutside", "PROPFIND /dav/%2e%2e/ HTTP/1.1\r\nHost: test\r\n\r\n", 405},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := exchangeRaw(t, addr, tt.raw, 1)[0]
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+	for _, name := range []string{"dav/a.txt", "pub/b.txt"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Errorf("%v got %v", name, err)
+		}
+	}
+	for _, name := range []string{"evil.txt", "evil"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+			t.Errorf("%v was created", name)
+		}
+	}
+
+	// Dot segments within the mount name what they resolve to
+	res := exchangeRaw(t, addr, "DELETE /dav/x/%2e%2e/a.txt HTTP/1.1\r\nHost: test\r\n\r\n", 1)[0]
+	if _, err := os.Stat(filepath.Join(root, "dav/a.txt")); res.StatusCode != 204 || err == nil {
+		t.Errorf("got status %v, dav/a.txt left: %v", res.StatusCode, err == nil)
+	}
+}
+
+func TestWebDAVMethods(t *testing.T) {
+	addr, root := startWebDAVServer(t, nil)
+	var tests = []struct {
+		name       string
+		raw        string
+		wantStatus int
+		wantAllow  string
+	}{
+		{"Head", "HEAD /dav/a.txt HTTP/1.1\r\nHost: test\r\n\r\n", 200, ""},
+		{"HeadReadOnly", "HEAD /pub/b.txt HTTP/1.1\r\nHost: test\r\n\r\n", 200, ""},
+		{"HeadMissing", "HEAD /dav/x HTTP/1.1\r\nHost: test\r\n\r\n", 404, ""},
+		{"Post", "POST /dav/x HTTP/1.1\r\nHost: test\r\n\r\n", 405, "OPTIONS, GET, HEAD, PROPFIND, PUT, DELETE, MKCOL"},
+		{"Patch", "PATCH /dav/x HTTP/1.1\r\nHost: test\r\n\r\n", 405, "OPTIONS, GET, HEAD, PROPFIND, PUT, DELETE, MKCOL"},
+		{"Trace", "TRACE /dav/x HTTP/1.1\r\nHost: test\r\n\r\n", 405, "OPTIONS, GET, HEAD, PROPFIND, PUT, DELETE, MKCOL"},
+		{"PostReadOnly", "POST /pub/x HTTP/1.1\r\nHost: test\r\n\r\n", 405, "OPTIONS, GET, HEAD, PROPFIND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := io.WriteString(conn, tt.raw); err != nil {
+				t.Fatal(err)
+			}
+			method, _, _ := strings.Cut(tt.raw, " ")
+			res, err := ReadResponse(bufio.NewReader(conn), &Request{Method: method})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.wantStatus || res.Header["Allow"] != tt.wantAllow {
+				t.Fatalf("got %v with Allow %q, want %v with Allow %q", res.StatusCode, res.Header["Allow"], tt.wantStatus, tt.wantAllow)
+			}
+		})
+	}
+	// None of them made anything
+	for _, name := range []string{"dav/x", "pub/x"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+			t.Errorf("%v was created", name)
+		}
+	}
+}
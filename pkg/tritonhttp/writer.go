@@ -0,0 +1,173 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// maxBufferedBody is how much of the body written through a
+// ResponseWriter is buffered, to be framed by Content-Length, before
+// the response is sent as a stream.
+const maxBufferedBody = 32 << 10
+
+// ErrHijacked is the error of writing through a ResponseWriter whose
+// connection was hijacked.
+var ErrHijacked = errors.New("tritonhttp: connection hijacked")
+
+// ResponseWriter is what a WriterFunc answers a request with, writing
+// the response on the connection as it goes instead of returning a
+// Response.
+//
+// The status and headers are sent on the first Flush, once the body
+// outgrows a buffer, or when the handler returns. A body written in
+// full by then is framed by its Content-Length, unless the handler set
+// one; otherwise the response is streamed and the connection closed
+// once the body ends.
+type ResponseWriter interface {
+	// Header returns the headers to send, to be changed before they
+	// are sent.
+	Header() map[string]string
+
+	// WriteHeader sets the status of the response, 200 OK if it is
+	// never called. Only the first call before the headers are sent
+	// counts.
+	WriteHeader(code int)
+
+	// Write writes p to the body of the response.
+	Write(p []byte) (int, error)
+
+	// Flush sends the headers, if not yet sent, and what was written
+	// so far to the client.
+	Flusher
+
+	// Hijack hands the connection over to the handler, along with
+	// what the client sent past the request, for another protocol
+	// to take over: the server writes nothing more on it, and closes
+	// it once the handler returns.
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+// WriterFunc is a function serving as a Handler by writing its
+// response through a ResponseWriter.
+type WriterFunc func(w ResponseWriter, req *Request)
+
+// ServeRequest returns the response that runs f as it is written.
+func (f WriterFunc) ServeRequest(req *Request) *Response {
+	res := NewResponse(statusOK)
+	res.Request = req
+	res.writer = f
+	return res
+}
+
+// responseWriter is the ResponseWriter of a WriterFunc writing res to
+// w.
+type responseWriter struct {
+	res         *Response
+	w           io.Writer
+	buf         bytes.Buffer
+	wroteStatus bool
+	sent        bool
+	hijacked    bool
+	err         error
+}
+
+// serveWriter writes res, running its WriterFunc, to w.
+func (res *Response) serveWriter(w io.Writer) error {
+	rw := &responseWriter{res: res, w: w}
+	res.writer(rw, res.Request)
+	if rw.hijacked {
+		return nil
+	}
+	if !rw.sent {
+		if _, ok := res.Header["Content-Length"]; !ok {
+			res.setHeader("Content-Length", strconv.Itoa(rw.buf.Len()))
+		}
+	}
+	if err := rw.Flush(); err != nil {
+		return err
+	}
+	return rw.err
+}
+
+func (rw *responseWriter) Header() map[string]string {
+	return rw.res.Header
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.sent || rw.wroteStatus {
+		return
+	}
+	rw.wroteStatus = true
+	rw.res.StatusCode = code
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if rw.hijacked {
+		return 0, ErrHijacked
+	}
+	if rw.err != nil {
+		return 0, rw.err
+	}
+	if !rw.sent {
+		n, _ := rw.buf.Write(p)
+		if rw.buf.Len() > maxBufferedBody {
+			if err := rw.Flush(); err != nil {
+				return 0, err
+			}
+		}
+		return n, nil
+	}
+	if rw.res.head {
+		return len(p), nil
+	}
+	n, err := rw.w.Write(p)
+	if err != nil {
+		rw.err = err
+	}
+	return n, err
+}
+
+func (rw *responseWriter) Flush() error {
+	if rw.hijacked {
+		return ErrHijacked
+	}
+	if rw.err != nil {
+		return rw.err
+	}
+	if !rw.sent {
+		rw.sent = true
+		if _, ok := rw.res.Header["Content-Length"]; !ok {
+			// Streamed, the body ends with the connection
+			rw.res.setHeader("Connection", "close")
+		}
+		if _, err := rw.w.Write(rw.res.appendHeaders(rw.res.appendStatusLine(nil))); err != nil {
+			rw.err = err
+			return err
+		}
+	}
+	if rw.res.head {
+		rw.buf.Reset()
+		return nil
+	}
+	if _, err := rw.buf.WriteTo(rw.w); err != nil {
+		rw.err = err
+		return err
+	}
+	return nil
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	req := rw.res.Request
+	if rw.hijacked || req == nil || req.conn == nil {
+		return nil, nil, errors.New("tritonhttp: connection cannot be hijacked")
+	}
+	rw.hijacked = true
+	rw.res.setHeader("Connection", "close")
+	_ = req.conn.SetDeadline(time.Time{})
+	return req.conn, bufio.NewReadWriter(req.br, bufio.NewWriter(req.conn)), nil
+}
@@ -0,0 +1,97 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseWriter(t *testing.T) {
+	flushed := make(chan struct{})
+	handler := WriterFunc(func(w ResponseWriter, req *Request) {
+		switch req.URL {
+		case "/small":
+			w.Header()["Content-Type"] = "text/plain"
+			fmt.Fprint(w, "hello, ")
+			fmt.Fprint(w, "world")
+		case "/status":
+			w.WriteHeader(404)
+			w.WriteHeader(500)
+			io.WriteString(w, "nope")
+		case "/large":
+			io.WriteString(w, strings.Repeat("x", maxBufferedBody+1))
+		case "/stream":
+			io.WriteString(w, "first;")
+			if err := w.Flush(); err != nil {
+				t.Errorf("Flush: %v", err)
+			}
+			<-flushed
+			io.WriteString(w, "second")
+		case "/hijack":
+			conn, rw, err := w.Hijack()
+			if err != nil {
+				t.Errorf("Hijack: %v", err)
+				return
+			}
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			line, _ := rw.ReadString('\n')
+			rw.WriteString("echo " + line)
+			rw.Flush()
+			if _, err := w.Write([]byte("late")); err != ErrHijacked {
+				t.Errorf("Write after Hijack got: %v", err)
+			}
+		}
+	})
+	addr, _ := startTestServer(t, &Server{DocRoot: t.TempDir(), Routes: []Route{{Prefix: "/", Handler: handler}}})
+
+	// Buffered bodies are framed, keeping the connection open
+	responses := exchangeRaw(t, addr, "GET /small HTTP/1.1\r\nHost: test\r\n\r\nGET /status HTTP/1.1\r\nHost: test\r\n\r\n", 2)
+	if body, _ := io.ReadAll(responses[0].BodyReader); responses[0].StatusCode != 200 || string(body) != "hello, world" ||
+		responses[0].Header["Content-Length"] != "12" || responses[0].Header["Content-Type"] != "text/plain" {
+		t.Fatalf("small got: %v %v %q", responses[0].StatusCode, responses[0].Header, body)
+	}
+	if body, _ := io.ReadAll(responses[1].BodyReader); responses[1].StatusCode != 404 || string(body) != "nope" {
+		t.Fatalf("status got: %v %q", responses[1].StatusCode, body)
+	}
+
+	res := exchangeRaw(t, addr, "GET /large HTTP/1.1\r\nHost: test\r\n\r\n", 1)[0]
+	if body, _ := io.ReadAll(res.BodyReader); len(body) != maxBufferedBody+1 || res.Header["Connection"] != "close" {
+		t.Fatalf("large got: %v, %v bytes", res.Header, len(body))
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /stream HTTP/1.1\r\nHost: test\r\n\r\n")
+	br := bufio.NewReader(conn)
+	res, err = ReadResponse(br, &Request{Method: "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := make([]byte, len("first;"))
+	if _, err := io.ReadFull(res.BodyReader, first); err != nil || res.Header["Connection"] != "close" {
+		t.Fatalf("stream got: %v %q, %v", res.Header, first, err)
+	}
+	close(flushed)
+	if rest, _ := io.ReadAll(res.BodyReader); string(rest) != "second" {
+		t.Fatalf("stream rest got: %q", rest)
+	}
+
+	conn, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /hijack HTTP/1.1\r\nHost: test\r\n\r\nping\n")
+	if got, _ := io.ReadAll(conn); string(got) != "echo ping\n" {
+		t.Fatalf("hijacked connection got: %q", got)
+	}
+}
@@ -0,0 +1,277 @@
+package tritonhttptest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cse224/proj3/pkg/tritonhttp"
+)
+
+// differentialTimeout bounds each exchange of the differential harness.
+const differentialTimeout = 5 * time.Second
+
+// Outcome is a response as the differential harness compares it.
+type Outcome struct {
+	StatusCode int
+	Header     map[string]string // only the headers compared, see Compare
+	Body       []byte            // only kept for 200 responses
+}
+
+// comparedHeaders are the headers Compare looks at. Content-Type
+// is compared without its parameters, as net/http adds a charset.
+var comparedHeaders = []string{"Connection", "Content-Length", "Content-Type", "Last-Modified"}
+
+// Differential is a harness feeding the same raw request bytes to a
+// TritonHTTP server and to a reference implementation of the TritonHTTP
+// spec built on net/http, both serving the same doc root.
+type Differential struct {
+	triton *Server
+	std    *httptest.Server
+}
+
+// NewDifferential starts both servers over a doc root holding files,
+// as in Options.Files. They are closed when t finishes.
+func NewDifferential(t testing.TB, files map[string]string) *Differential {
+	t.Helper()
+	d := &Differential{triton: NewTestServer(t, &Options{Files: files})}
+	d.std = httptest.NewServer(referenceHandler(d.triton.DocRoot))
+	t.Cleanup(d.std.Close)
+	return d
+}
+
+// Run sends raw to both servers, then reads their responses until
+// they close the connection, and returns what each answered.
+func (d *Differential) Run(raw []byte) (triton, std []Outcome, err error) {
+	if triton, err = exchange(d.triton.Listener.Addr().String(), raw); err != nil {
+		return nil, nil, fmt.Errorf("tritonhttp: %w", err)
+	}
+	if std, err = exchange(d.std.Listener.Addr().String(), raw); err != nil {
+		return nil, nil, fmt.Errorf("net/http: %w", err)
+	}
+	return triton, std, nil
+}
+
+// Check fails t if the servers answer raw differently.
+func (d *Differential) Check(t testing.TB, raw []byte) {
+	t.Helper()
+	triton, std, err := d.Run(raw)
+	if err != nil {
+		t.Fatalf("%q: %v", raw, err)
+	}
+	if diffs := Compare(triton, std); len(diffs) > 0 {
+		t.Errorf("%q: tritonhttp and net/http differ:\n\t%v", raw, strings.Join(diffs, "\n\t"))
+	}
+}
+
+// Compare returns the differences between the responses in a and b:
+// their number, status codes, compared headers, and for 200 responses,
+// bodies.
+func Compare(a, b []Outcome) []string {
+	var diffs []string
+	if len(a) != len(b) {
+		diffs = append(diffs, fmt.Sprintf("%v responses != %v", len(a), len(b)))
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ra, rb := a[i], b[i]
+		if ra.StatusCode != rb.StatusCode {
+			diffs = append(diffs, fmt.Sprintf("response %v: status %v != %v", i, ra.StatusCode, rb.StatusCode))
+			continue
+		}
+		for _, key := range comparedHeaders {
+			if ra.Header[key] != rb.Header[key] {
+				diffs = append(diffs, fmt.Sprintf("response %v: %v %q != %q", i, key, ra.Header[key], rb.Header[key]))
+			}
+		}
+		if string(ra.Body) != string(rb.Body) {
+			diffs = append(diffs, fmt.Sprintf("response %v: bodies differ, %v bytes != %v", i, len(ra.Body), len(rb.Body)))
+		}
+	}
+	return diffs
+}
+
+// exchange writes raw on a new connection to addr, half-closes it and
+// reads the responses until the server closes it.
+func exchange(addr string, raw []byte) ([]Outcome, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(differentialTimeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	var outcomes []Outcome
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			return outcomes, nil
+		}
+		o, err := readOutcome(br)
+		if err != nil {
+			return outcomes, err
+		}
+		outcomes = append(outcomes, o)
+		if o.Header["Connection"] == "close" {
+			// Nothing follows but, maybe, the body of an error
+			_, _ = io.Copy(io.Discard, br)
+			return outcomes, nil
+		}
+	}
+}
+
+// readOutcome reads one response from br. A response without a
+// Content-Length has no body, as in the TritonHTTP spec.
+func readOutcome(br *bufio.Reader) (Outcome, error) {
+	res, err := tritonhttp.ReadResponse(br, &tritonhttp.Request{Method: "HEAD"})
+	if err != nil {
+		return Outcome{}, err
+	}
+	o := Outcome{StatusCode: res.StatusCode, Header: map[string]string{}}
+	for _, key := range comparedHeaders {
+		if v, ok := res.Header[key]; ok {
+			o.Header[key] = v
+		}
+	}
+	if ct, ok := o.Header["Content-Type"]; ok {
+		if mt, _, err := mime.ParseMediaType(ct); err == nil {
+			o.Header["Content-Type"] = mt
+		}
+	}
+	body := []byte{}
+	if cl, ok := res.Header["Content-Length"]; ok {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			return Outcome{}, fmt.Errorf("invalid Content-Length %q", cl)
+		}
+		body = make([]byte, n)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return Outcome{}, err
+		}
+	}
+	if o.StatusCode == 200 {
+		o.Body = body
+	} else {
+		// Error bodies are free-form; only the headers are compared
+		delete(o.Header, "Content-Length")
+		delete(o.Header, "Content-Type")
+	}
+	return o, nil
+}
+
+// standardMethod reports whether method is one of RFC 9110 or RFC 5789,
+// which the spec answers with 405 Method Not Allowed, rather than 400,
+// but GET.
+func standardMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH":
+		return true
+	}
+	return false
+}
+
+// referenceHandler serves root the way the TritonHTTP spec asks for,
+// with net/http doing the parsing and framing.
+func referenceHandler(root string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			if !standardMethod(r.Method) {
+				w.Header().Set("Connection", "close")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			// The connection stays open but for CONNECT, whose client
+			// expects a tunnel
+			w.Header().Set("Allow", "GET, OPTIONS")
+			if r.Method == "CONNECT" {
+				w.Header().Set("Connection", "close")
+			}
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Proto != "HTTP/1.1" {
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+		if !strings.HasPrefix(r.RequestURI, "/") {
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		name := r.URL.Path
+		if climbsAboveRoot(name) {
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// A trailing dot segment names a directory
+		if base := path.Base(name); base == "." || base == ".." {
+			name += "/"
+		}
+		if strings.HasSuffix(name, "/") {
+			name += "index.html"
+		}
+		p := filepath.Clean(root + name)
+		if !strings.HasPrefix(p, root) {
+			// Outside the doc root
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil || fi.IsDir() {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		h := w.Header()
+		// Without a known extension, net/http sniffs the type
+		if ct := tritonhttp.MIMETypeByExtension(path.Ext(name)); ct != "" {
+			h.Set("Content-Type", ct)
+		}
+		h.Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+		h.Set("Last-Modified", tritonhttp.FormatTime(fi.ModTime()))
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.Copy(w, f)
+	})
+}
+
+// climbsAboveRoot reports whether the ".." segments of the URL path p
+// outnumber the segments they remove.
+func climbsAboveRoot(p string) bool {
+	depth := 0
+	for _, seg := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		switch seg {
+		case ".":
+		case "..":
+			if depth--; depth < 0 {
+				return true
+			}
+		default:
+			depth++
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package tritonhttptest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDifferential(t *testing.T) {
+	d := NewDifferential(t, map[string]string{
+		"index.html":        "<h1>home</h1>",
+		"subdir/index.html": "<h1>sub</h1>",
+		"style.css":         "body {}",
+		"blob.bin":          "\x00\x01",
+		"café.html":         "<h1>café</h1>",
+		"a b.html":          "<h1>space</h1>",
+		"a.tar.gz":          "gz",
+		"v1.2/notes":        "plain notes",
+		"logo":              "\x89PNG\r\n\x1a\n",
+	})
+	get := func(target string) *RequestBuilder { return NewRequest("GET", target) }
+	var tests = []struct {
+		name string
+		raw  string
+	}{
+		{"OK", get("/index.html").String()},
+		{"OKClose", get("/index.html").Close().String()},
+		{"Index", get("/").String()},
+		{"SubdirIndex", get("/subdir/").String()},
+		{"ContentTypes", get("/style.css").String() + get("/blob.bin").String()},
+		{"MultiDotExtension", get("/a.tar.gz").String()},
+		{"SniffedContentTypes", get("/v1.2/notes").String() + get("/logo").String()},
+		{"ExtraHeaders", get("/index.html").Header("User-Agent", "x").Header("Accept", "*/*").String()},
+		{"HeaderCase", get("/index.html").Host("").Line("hOsT: test").Line("connection: close").String()},
+		{"NotFound", get("/missing.html").String()},
+		{"Directory", get("/subdir").String()},
+		{"Escape", get("/../index.html").String()},
+		{"DotSegments", get("/subdir/./../index.html").String() + get("/subdir/..").String() + get("/a/../../index.html").String()},
+		{"QueryString", get("/index.html?x=1").String() + get("/subdir/?x=1&y").String()},
+		{"PercentEncoding", get("/%69ndex.html").String() + get("/subdir%2Findex.html").String()},
+		{"UTF8Name", get("/caf%C3%A9.html").String() + get("/caf%c3%a9.html").String() + get("/a%20b.html").String()},
+		{"NoHost", get("/index.html").Host("").String()},
+		{"BadMethod", NewRequest("POST", "/index.html").String() + get("/index.html").String()},
+		{"Garbage", "This is a bad request\r\n\r\n"},
+		{"BadHeader", get("/index.html").Line("no colon").String()},
+		{"Pipeline", get("/index.html").String() + get("/missing").String() + get("/").Close().String()},
+		{"HTTP10", get("/index.html").Proto("HTTP/1.0").String()},
+		{"AbsoluteForm", get("http://test/index.html").String()},
+		{"PipelineBadRequest", get("/").String() + "bad\r\n\r\n" + get("/").String()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d.Check(t, []byte(test.raw))
+		})
+	}
+}
+
+// TestKnownDeviations keeps track of where TritonHTTP knowingly parts
+// from net/http. Once one is fixed, move it to TestDifferential.
+func TestKnownDeviations(t *testing.T) {
+	d := NewDifferential(t, map[string]string{"index.html": "<h1>home</h1>"})
+	var tests = []struct {
+		name string
+		raw  string
+	}{
+		{"BareLF", "GET /index.html HTTP/1.1\nHost: test\n\n"},
+		{"ConnectionCase", "GET /index.html HTTP/1.1\r\nHost: test\r\nConnection: Close\r\n\r\n"},
+		{"DuplicateHost", "GET /index.html HTTP/1.1\r\nHost: test\r\nHost: other\r\n\r\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			triton, std, err := d.Run([]byte(test.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(Compare(triton, std)) == 0 {
+				t.Fatalf("%q: no longer deviates, move it to TestDifferential", test.raw)
+			}
+		})
+	}
+}
+
+// TestDifferentialCorpus replays the end-to-end test requests that
+// are complete, i.e. not meant to time out.
+func TestDifferentialCorpus(t *testing.T) {
+	htdocs := "../../test/testdata/htdocs"
+	files := map[string]string{}
+	err := filepath.Walk(htdocs, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		rel, _ := filepath.Rel(htdocs, path)
+		files[filepath.ToSlash(rel)] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDifferential(t, files)
+	requests, err := filepath.Glob("../../test/testdata/requests/*/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range requests {
+		if strings.Contains(path, "Timeout") {
+			continue
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			d.Check(t, raw)
+		})
+	}
+}
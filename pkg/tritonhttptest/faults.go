@@ -0,0 +1,129 @@
+package tritonhttptest
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ReadFault is how the input of a faulty connection ends.
+type ReadFault int
+
+const (
+	NoReadFault ReadFault = iota
+	ReadEOF               // the client drops the connection
+	ReadTimeout           // the read deadline expires
+)
+
+// Faults describes the faults injected into the server side of the
+// connections of a test server, to exercise its error paths without
+// waiting on real timeouts. Offsets count bytes since the connection
+// was accepted, so that a fault hits at the same point every run.
+type Faults struct {
+	// ReadFault, if set, happens once ReadFaultAt bytes have been
+	// read, e.g. halfway through the headers of a request.
+	// Reads never return bytes past ReadFaultAt.
+	ReadFault   ReadFault
+	ReadFaultAt int64
+
+	// ReadChunk, if positive, caps the bytes returned by each Read,
+	// splitting requests across reads.
+	ReadChunk int
+
+	// WriteDelay is slept before every Write.
+	WriteDelay time.Duration
+
+	// WriteLimit, if positive, closes the connection once that many
+	// bytes have been written, truncating the response in progress.
+	WriteLimit int64
+}
+
+// FaultListener returns a listener accepting the connections of ln
+// with f injected into them.
+func FaultListener(ln net.Listener, f Faults) net.Listener {
+	return &faultListener{Listener: ln, f: f}
+}
+
+type faultListener struct {
+	net.Listener
+	f Faults
+}
+
+func (l *faultListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &faultConn{Conn: conn, f: l.f}, nil
+}
+
+// faultConn is a net.Conn suffering the faults in f.
+type faultConn struct {
+	net.Conn
+	f Faults
+
+	mu      sync.Mutex
+	read    int64
+	written int64
+}
+
+func (fc *faultConn) Read(p []byte) (int, error) {
+	fc.mu.Lock()
+	read := fc.read
+	fc.mu.Unlock()
+	if fc.f.ReadFault != NoReadFault {
+		left := fc.f.ReadFaultAt - read
+		if left <= 0 {
+			return 0, fc.readFault()
+		}
+		if int64(len(p)) > left {
+			p = p[:left]
+		}
+	}
+	if fc.f.ReadChunk > 0 && len(p) > fc.f.ReadChunk {
+		p = p[:fc.f.ReadChunk]
+	}
+	n, err := fc.Conn.Read(p)
+	fc.mu.Lock()
+	fc.read += int64(n)
+	fc.mu.Unlock()
+	return n, err
+}
+
+// readFault returns the error of the read fault, dropping the
+// connection for ReadEOF.
+func (fc *faultConn) readFault() error {
+	if fc.f.ReadFault == ReadEOF {
+		_ = fc.Conn.Close()
+		return io.EOF
+	}
+	return &net.OpError{Op: "read", Net: "tcp", Source: fc.LocalAddr(), Addr: fc.RemoteAddr(), Err: os.ErrDeadlineExceeded}
+}
+
+func (fc *faultConn) Write(p []byte) (int, error) {
+	if fc.f.WriteDelay > 0 {
+		time.Sleep(fc.f.WriteDelay)
+	}
+	if fc.f.WriteLimit <= 0 {
+		return fc.Conn.Write(p)
+	}
+	fc.mu.Lock()
+	left := fc.f.WriteLimit - fc.written
+	fc.mu.Unlock()
+	if int64(len(p)) <= left {
+		n, err := fc.Conn.Write(p)
+		fc.mu.Lock()
+		fc.written += int64(n)
+		fc.mu.Unlock()
+		return n, err
+	}
+	n, _ := fc.Conn.Write(p[:max(left, 0)])
+	fc.mu.Lock()
+	fc.written += int64(n)
+	fc.mu.Unlock()
+	_ = fc.Conn.Close()
+	return n, &net.OpError{Op: "write", Net: "tcp", Source: fc.LocalAddr(), Addr: fc.RemoteAddr(), Err: syscall.EPIPE}
+}
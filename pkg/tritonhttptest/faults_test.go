@@ -0,0 +1,92 @@
+package tritonhttptest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawExchange sends raw to ts and returns all it answers until it
+// closes the connection.
+func rawExchange(t *testing.T, ts *Server, raw string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestFaults(t *testing.T) {
+	files := map[string]string{"index.html": strings.Repeat("x", 1000)}
+	request := NewRequest("GET", "/index.html").Close().String()
+	keepAlive := NewRequest("GET", "/index.html").String()
+	partial := request[:30]
+	var tests = []struct {
+		name       string
+		faults     Faults
+		raw        string
+		wantStatus string // "" for no response at all
+		wantBody   int
+	}{
+		{"None", Faults{}, request, "HTTP/1.1 200 OK", 1000},
+		{"DropMidHeader", Faults{ReadFault: ReadEOF, ReadFaultAt: 30}, partial, "", 0},
+		{"TimeoutMidHeader", Faults{ReadFault: ReadTimeout, ReadFaultAt: 30}, partial, "HTTP/1.1 400 Bad Request", 0},
+		{"TimeoutIdle", Faults{ReadFault: ReadTimeout}, request, "", 0},
+		{"TimeoutAfterRequest", Faults{ReadFault: ReadTimeout, ReadFaultAt: int64(len(keepAlive))}, keepAlive, "HTTP/1.1 200 OK", 1000},
+		{"ByteByByte", Faults{ReadChunk: 1}, request, "HTTP/1.1 200 OK", 1000},
+		{"TruncatedBody", Faults{WriteLimit: 500}, request, "HTTP/1.1 200 OK", -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			faults := test.faults
+			ts := NewTestServer(t, &Options{Files: files, Faults: &faults})
+			got := rawExchange(t, ts, test.raw)
+			if test.wantStatus == "" {
+				if got != "" {
+					t.Fatalf("got: %q, want no response", got)
+				}
+				return
+			}
+			br := bufio.NewReader(strings.NewReader(got))
+			status, _ := br.ReadString('\n')
+			if strings.TrimSpace(status) != test.wantStatus {
+				t.Fatalf("status got: %q, want: %q", status, test.wantStatus)
+			}
+			_, body, _ := strings.Cut(got, "\r\n\r\n")
+			switch {
+			case test.wantBody >= 0 && len(body) != test.wantBody:
+				t.Fatalf("body got %v bytes, want: %v", len(body), test.wantBody)
+			case test.wantBody < 0 && len(got) != int(faults.WriteLimit):
+				t.Fatalf("got %v bytes, want them truncated at %v", len(got), faults.WriteLimit)
+			}
+		})
+	}
+}
+
+func TestFaultsWriteDelay(t *testing.T) {
+	ts := NewTestServer(t, &Options{
+		Files:  map[string]string{"index.html": "hi"},
+		Faults: &Faults{WriteDelay: 50 * time.Millisecond},
+	})
+	start := time.Now()
+	got := rawExchange(t, ts, NewRequest("GET", "/index.html").Close().String())
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("answered in %v, want at least the write delay", elapsed)
+	}
+	if !strings.HasSuffix(got, "\r\n\r\nhi") {
+		t.Fatalf("got: %q", got)
+	}
+}
@@ -0,0 +1,110 @@
+package tritonhttptest
+
+import (
+	"strings"
+
+	"cse224/proj3/pkg/tritonhttp"
+)
+
+// RequestBuilder builds a request for a test, both as the
+// tritonhttp.Request ReadRequest would return and as wire bytes:
+//
+//	raw := tritonhttptest.NewRequest("GET", "/x").Header("K", "v").Close().Bytes()
+//
+// Requests are sent to Host "test" unless Host says otherwise.
+type RequestBuilder struct {
+	method, target, proto string
+	host                  string
+	close                 bool
+	lines                 []string // header lines in order, without "\r\n"
+}
+
+// NewRequest starts building a method request for target,
+// e.g. "/index.html".
+func NewRequest(method, target string) *RequestBuilder {
+	return &RequestBuilder{method: method, target: target, proto: "HTTP/1.1", host: "test"}
+}
+
+// Proto sets the protocol version, "HTTP/1.1" by default.
+func (b *RequestBuilder) Proto(proto string) *RequestBuilder {
+	b.proto = proto
+	return b
+}
+
+// Host sets the Host header; "" leaves it out.
+func (b *RequestBuilder) Host(host string) *RequestBuilder {
+	b.host = host
+	return b
+}
+
+// Close adds "Connection: close".
+func (b *RequestBuilder) Close() *RequestBuilder {
+	b.close = true
+	return b
+}
+
+// Header adds the header key: value. Headers are written in the
+// order they are added, repeated keys included.
+func (b *RequestBuilder) Header(key, value string) *RequestBuilder {
+	return b.Line(key + ": " + value)
+}
+
+// Line adds line to the headers as is, e.g. to build a malformed
+// request.
+func (b *RequestBuilder) Line(line string) *RequestBuilder {
+	b.lines = append(b.lines, line)
+	return b
+}
+
+// String returns the request in wire format: the request line, Host,
+// Connection, the other headers and the blank line ending them.
+func (b *RequestBuilder) String() string {
+	var sb strings.Builder
+	sb.WriteString(b.method + " " + b.target + " " + b.proto + "\r\n")
+	if b.host != "" {
+		sb.WriteString("Host: " + b.host + "\r\n")
+	}
+	if b.close {
+		sb.WriteString("Connection: close\r\n")
+	}
+	for _, line := range b.lines {
+		sb.WriteString(line + "\r\n")
+	}
+	sb.WriteString("\r\n")
+	return sb.String()
+}
+
+// Bytes returns String as bytes.
+func (b *RequestBuilder) Bytes() []byte {
+	return []byte(b.String())
+}
+
+// Request returns the request as ReadRequest returns it once read:
+// header keys canonical, with Host and Connection moved to their
+// fields. Malformed headers are not checked for.
+func (b *RequestBuilder) Request() *tritonhttp.Request {
+	req := &tritonhttp.Request{
+		Method: b.method,
+		URL:    b.target,
+		Proto:  b.proto,
+		Header: map[string]string{},
+		Host:   b.host,
+		Close:  b.close,
+	}
+	for _, line := range b.lines {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = tritonhttp.CanonicalHeaderKey(key), strings.TrimLeft(value, " ")
+		switch key {
+		case "Host":
+			req.Host = value
+		case "Connection":
+			req.Close = value == "close"
+		default:
+			req.Header[key] = value
+		}
+	}
+	return req
+}
@@ -0,0 +1,53 @@
+package tritonhttptest
+
+import (
+	"reflect"
+	"testing"
+
+	"cse224/proj3/pkg/tritonhttp"
+)
+
+func TestRequestBuilder(t *testing.T) {
+	got := NewRequest("GET", "/x").Header("K", "v").Close().String()
+	if want := "GET /x HTTP/1.1\r\nHost: test\r\nConnection: close\r\nK: v\r\n\r\n"; got != want {
+		t.Fatalf("got: %q, want: %q", got, want)
+	}
+
+	// What is built reads back as the request built
+	var tests = []struct {
+		name string
+		b    *RequestBuilder
+	}{
+		{"Basic", NewRequest("GET", "/index.html")},
+		{"Close", NewRequest("GET", "/").Close()},
+		{"Headers", NewRequest("GET", "/").Header("user-agent", "test").Header("Accept", "*/*")},
+		{"Host", NewRequest("GET", "/").Host("example.com")},
+		{"HostLine", NewRequest("GET", "/").Host("").Line("host: other")},
+		{"KeepAlive", NewRequest("GET", "/").Header("Connection", "keep-alive")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, n, err := tritonhttp.ParseRequestBytes(test.b.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(test.b.Bytes()) {
+				t.Fatalf("read %v bytes of %v", n, len(test.b.Bytes()))
+			}
+			if want := test.b.Request(); !reflect.DeepEqual(req, want) {
+				t.Fatalf("\ngot: %+v\nwant: %+v", req, want)
+			}
+		})
+	}
+
+	for _, b := range []*RequestBuilder{
+		NewRequest("POST", "/"),
+		NewRequest("GET", "/").Proto("HTTP/1.0"),
+		NewRequest("GET", "/").Host(""),
+		NewRequest("GET", "/").Line("no colon"),
+	} {
+		if _, _, err := tritonhttp.ParseRequestBytes(b.Bytes()); err == nil {
+			t.Fatalf("%q got no error", b)
+		}
+	}
+}
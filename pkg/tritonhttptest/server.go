@@ -0,0 +1,190 @@
+// Package tritonhttptest starts TritonHTTP servers for tests.
+//
+// A test gets a running server over a temporary doc root with:
+//
+//	ts := tritonhttptest.NewTestServer(t, &tritonhttptest.Options{
+//		Files: map[string]string{"index.html": "<h1>hi</h1>"},
+//	})
+//	res, err := ts.Client.Get(ts.URL + "/index.html")
+//
+// The server is shut down when the test ends.
+//
+// NewDifferential checks TritonHTTP's answers to raw requests against
+// those of net/http, to catch deviations from the spec.
+package tritonhttptest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"cse224/proj3/pkg/tritonhttp"
+)
+
+// shutdownTimeout bounds the graceful part of Server.Close.
+const shutdownTimeout = 5 * time.Second
+
+// Options configures NewTestServer. A nil *Options starts a server
+// on a random loopback port over an empty doc root.
+type Options struct {
+	// Files are written to the doc root, keyed by slash separated
+	// path relative to it, e.g. "subdir/index.html".
+	Files map[string]string
+
+	// InMemory serves over net.Pipe connections instead of TCP.
+	// Only Client can reach such a server.
+	InMemory bool
+
+	// Faults, if set, are injected into every connection accepted.
+	Faults *Faults
+
+	// Configure, if set, is called with the server before it starts,
+	// e.g. to set its Limits. DocRoot is already set.
+	Configure func(s *tritonhttp.Server)
+}
+
+// Server is a TritonHTTP server running for a test.
+type Server struct {
+	URL      string // base URL, e.g. "http://127.0.0.1:34567", without a trailing slash
+	DocRoot  string // temporary directory served
+	Server   *tritonhttp.Server
+	Listener net.Listener
+
+	// Client sends requests to the server, whether over TCP
+	// or in memory.
+	Client *tritonhttp.Client
+
+	t         testing.TB
+	served    chan error
+	closeOnce sync.Once
+}
+
+// NewTestServer starts a server as opts describes, failing t if it
+// cannot. The server is closed when t and its subtests finish.
+func NewTestServer(t testing.TB, opts *Options) *Server {
+	t.Helper()
+	if opts == nil {
+		opts = &Options{}
+	}
+	ts := &Server{
+		DocRoot: t.TempDir(),
+		t:       t,
+		served:  make(chan error, 1),
+	}
+	for name, content := range opts.Files {
+		ts.WriteFile(name, content)
+	}
+
+	ts.Server = &tritonhttp.Server{DocRoot: ts.DocRoot}
+	if opts.Configure != nil {
+		opts.Configure(ts.Server)
+	}
+	tr := &tritonhttp.Transport{}
+	if opts.InMemory {
+		ln := newPipeListener()
+		ts.Listener, ts.URL = ln, "http://"+ln.Addr().String()
+		tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return ln.dial(ctx)
+		}
+	} else {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("tritonhttptest: listening: %v", err)
+		}
+		ts.Listener, ts.URL = ln, "http://"+ln.Addr().String()
+	}
+	if opts.Faults != nil {
+		ts.Listener = FaultListener(ts.Listener, *opts.Faults)
+	}
+	ts.Client = &tritonhttp.Client{Transport: tr}
+
+	go func() { ts.served <- ts.Server.Serve(ts.Listener) }()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// WriteFile writes content to name, a slash separated path relative
+// to the doc root, creating its directories.
+func (ts *Server) WriteFile(name, content string) {
+	ts.t.Helper()
+	path := filepath.Join(ts.DocRoot, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		ts.t.Fatalf("tritonhttptest: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		ts.t.Fatalf("tritonhttptest: %v", err)
+	}
+}
+
+// Close shuts the server down, waiting a while for requests in flight,
+// and reports Serve errors other than tritonhttp.ErrServerClosed to the
+// test. It is called when the test ends, and may be called before.
+func (ts *Server) Close() {
+	ts.closeOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := ts.Server.Shutdown(ctx); err != nil {
+			_ = ts.Server.Close()
+		}
+		if err := <-ts.served; !errors.Is(err, tritonhttp.ErrServerClosed) {
+			ts.t.Errorf("tritonhttptest: Serve: %v", err)
+		}
+		ts.Client.Transport.CloseIdleConnections()
+	})
+}
+
+// pipeAddr is the address of in-memory connections.
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// pipeListener is a net.Listener handing out the server ends of
+// net.Pipe connections made by dial.
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+// dial connects to the listener, returning the client end.
+func (l *pipeListener) dial(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+	case <-ctx.Done():
+	}
+	_ = client.Close()
+	_ = server.Close()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr{}, Err: errors.New("connection refused")}
+}
@@ -0,0 +1,53 @@
+package tritonhttptest
+
+import (
+	"io"
+	"testing"
+
+	"cse224/proj3/pkg/tritonhttp"
+)
+
+func TestNewTestServer(t *testing.T) {
+	files := map[string]string{
+		"index.html":        "<h1>home</h1>",
+		"subdir/index.html": "<h1>sub</h1>",
+	}
+	for _, inMemory := range []bool{false, true} {
+		ts := NewTestServer(t, &Options{
+			Files:     files,
+			InMemory:  inMemory,
+			Configure: func(s *tritonhttp.Server) { s.Limits.MaxConnsPerIP = 5 },
+		})
+		if ts.Server.Limits.MaxConnsPerIP != 5 {
+			t.Fatal("Configure was not called")
+		}
+		for _, path := range []string{"/", "/subdir/", "/subdir/index.html"} {
+			res, err := ts.Client.Get(ts.URL + path)
+			if err != nil {
+				t.Fatalf("in memory %v: %v", inMemory, err)
+			}
+			body, err := io.ReadAll(res.BodyReader)
+			res.Close()
+			if err != nil || res.StatusCode != 200 {
+				t.Fatalf("in memory %v: %v got: %v %q, %v", inMemory, path, res.StatusCode, body, err)
+			}
+		}
+		ts.Close()
+		if _, err := ts.Client.Get(ts.URL + "/"); err == nil {
+			t.Fatalf("in memory %v: closed server got no error", inMemory)
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	ts := NewTestServer(t, nil)
+	ts.WriteFile("late/file.txt", "added later")
+	res, err := ts.Client.Get(ts.URL + "/late/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	if body, _ := io.ReadAll(res.BodyReader); string(body) != "added later" {
+		t.Fatalf("got: %q, want: %q", body, "added later")
+	}
+}
@@ -0,0 +1,248 @@
+package tritonhttptest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxStressFailures bounds the failures a StressReport details.
+const maxStressFailures = 20
+
+// malformedRequests are sent by Stress, each earning its status.
+var malformedRequests = []struct {
+	raw    string
+	status int
+}{
+	{"GARBAGE\r\n\r\n", 400},
+	{"GET /index.html HTTP/1.0\r\nHost: test\r\n\r\n", 505},
+	{"CONNECT example.com:443 HTTP/1.1\r\nHost: test\r\n\r\n", 405},
+	{"GET /index.html HTTP/1.1\r\n\r\n", 400},
+	{"GET /index.html HTTP/1.1\r\nHost: test\r\nno colon\r\n\r\n", 400},
+	{"GET index.html HTTP/1.1\r\nHost: test\r\n\r\n", 400},
+}
+
+// StressOptions configures Stress.
+type StressOptions struct {
+	// Files are those the server serves, as in Options.Files. They are
+	// requested, and their bodies checked. It must not be empty.
+	Files map[string]string
+
+	Conns           int           // keep-alive connections held open at once; 0 means 1000
+	RequestsPerConn int           // 0 means 20
+	Seed            int64         // of the request mix, so that failing runs can be repeated
+	Timeout         time.Duration // per exchange; 0 means 10s
+}
+
+// StressReport is the outcome of Stress.
+type StressReport struct {
+	Conns     int // connections opened
+	Requests  int // requests sent, of which:
+	Pipelined int // sent in a batch before reading the responses
+	Partial   int // sent a few bytes at a time
+	Malformed int // malformed, each ending its connection
+
+	// Failures counts the connections on which the server answered
+	// wrongly or not at all. Messages details the first few.
+	Failures int
+	Messages []string
+}
+
+// Stress opens opts.Conns keep-alive connections to the TritonHTTP
+// server at addr at once and sends on each an interleaving of
+// single, pipelined, partial and malformed requests, checking every
+// response. Run it with the race detector on, against a server in the
+// same process, to validate connection handling under contention.
+//
+// The server's Limits must allow opts.Conns connections from the
+// loopback address, and its BanPolicy must not ban the client.
+func Stress(ctx context.Context, addr string, opts StressOptions) (*StressReport, error) {
+	if len(opts.Files) == 0 {
+		return nil, errors.New("tritonhttptest: Stress without Files")
+	}
+	if opts.Conns == 0 {
+		opts.Conns = 1000
+	}
+	if opts.RequestsPerConn == 0 {
+		opts.RequestsPerConn = 20
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	paths := make([]string, 0, len(opts.Files))
+	for name := range opts.Files {
+		paths = append(paths, "/"+name)
+	}
+	sort.Strings(paths)
+
+	var (
+		mu     sync.Mutex
+		report = &StressReport{Conns: opts.Conns}
+		ready  sync.WaitGroup
+		done   sync.WaitGroup
+		start  = make(chan struct{})
+	)
+	ready.Add(opts.Conns)
+	done.Add(opts.Conns)
+	for i := 0; i < opts.Conns; i++ {
+		go func(i int) {
+			defer done.Done()
+			sc := &stressConn{
+				opts: &opts,
+				rng:  rand.New(rand.NewSource(opts.Seed + int64(i))),
+			}
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			ready.Done()
+			if err == nil {
+				defer conn.Close()
+				stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+				defer stop()
+				// Hold the connection open until all are
+				<-start
+				sc.conn, sc.br = conn, bufio.NewReader(conn)
+				err = sc.run(paths)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Requests += sc.requests
+			report.Pipelined += sc.pipelined
+			report.Partial += sc.partial
+			report.Malformed += sc.malformed
+			if err != nil {
+				report.Failures++
+				if len(report.Messages) < maxStressFailures {
+					report.Messages = append(report.Messages, fmt.Sprintf("connection %v: %v", i, err))
+				}
+			}
+		}(i)
+	}
+	ready.Wait()
+	close(start)
+	done.Wait()
+	return report, ctx.Err()
+}
+
+// stressConn is a connection of Stress.
+type stressConn struct {
+	opts *StressOptions
+	rng  *rand.Rand
+	conn net.Conn
+	br   *bufio.Reader
+
+	requests, pipelined, partial, malformed int
+}
+
+// stressRequest is a request of Stress and the response it expects.
+type stressRequest struct {
+	raw    []byte
+	status int
+	body   string
+	close  bool
+}
+
+// run sends the requests of sc, ending with one closing the
+// connection, and checks the responses.
+func (sc *stressConn) run(paths []string) error {
+	for sc.requests < sc.opts.RequestsPerConn {
+		last := sc.requests == sc.opts.RequestsPerConn-1
+		switch n := sc.rng.Intn(100); {
+		case n < 5:
+			// Malformed requests end the connection
+			sc.malformed++
+			m := malformedRequests[sc.rng.Intn(len(malformedRequests))]
+			return sc.exchange([]stressRequest{{raw: []byte(m.raw), status: m.status, close: true}}, false)
+		case n < 30 && !last:
+			batch := make([]stressRequest, 2+sc.rng.Intn(4))
+			if left := sc.opts.RequestsPerConn - sc.requests - 1; len(batch) > left {
+				batch = batch[:left]
+			}
+			for i := range batch {
+				batch[i] = sc.request(paths, false)
+			}
+			sc.pipelined += len(batch)
+			if err := sc.exchange(batch, false); err != nil {
+				return err
+			}
+		case n < 50:
+			sc.partial++
+			if err := sc.exchange([]stressRequest{sc.request(paths, last)}, true); err != nil {
+				return err
+			}
+		default:
+			if err := sc.exchange([]stressRequest{sc.request(paths, last)}, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// request returns a GET of one of paths, or now and then of a missing
+// file, closing the connection if close is set.
+func (sc *stressConn) request(paths []string, close bool) stressRequest {
+	p := paths[sc.rng.Intn(len(paths))]
+	sr := stressRequest{status: 200, body: sc.opts.Files[p[1:]], close: close}
+	if sc.rng.Intn(10) == 0 {
+		p, sr.status, sr.body = p+".missing", 404, ""
+	}
+	b := NewRequest("GET", p)
+	if close {
+		b.Close()
+	}
+	sr.raw = b.Bytes()
+	return sr
+}
+
+// exchange writes the requests of batch, a few bytes at a time if
+// partial is set, then reads and checks their responses. After one
+// closing the connection, the server must have closed it.
+func (sc *stressConn) exchange(batch []stressRequest, partial bool) error {
+	if err := sc.conn.SetDeadline(time.Now().Add(sc.opts.Timeout)); err != nil {
+		return err
+	}
+	var raw []byte
+	for _, sr := range batch {
+		raw = append(raw, sr.raw...)
+	}
+	sc.requests += len(batch)
+	for len(raw) > 0 {
+		n := len(raw)
+		if partial {
+			n = min(n, 1+sc.rng.Intn(16))
+		}
+		if _, err := sc.conn.Write(raw[:n]); err != nil {
+			return fmt.Errorf("writing request %v: %w", sc.requests, err)
+		}
+		raw = raw[n:]
+		if partial && len(raw) > 0 {
+			time.Sleep(time.Duration(sc.rng.Intn(500)) * time.Microsecond)
+		}
+	}
+	for i, sr := range batch {
+		o, err := readOutcome(sc.br)
+		if err != nil {
+			return fmt.Errorf("reading response %v: %w", sc.requests-len(batch)+i+1, err)
+		}
+		if o.StatusCode != sr.status || (sr.status == 200 && string(o.Body) != sr.body) {
+			return fmt.Errorf("%q: got status %v with %v bytes, want %v with %v",
+				sr.raw, o.StatusCode, len(o.Body), sr.status, len(sr.body))
+		}
+		if got := o.Header["Connection"] == "close"; got != sr.close {
+			return fmt.Errorf("%q: got Connection %q", sr.raw, o.Header["Connection"])
+		}
+	}
+	if batch[len(batch)-1].close {
+		if _, err := sc.br.Peek(1); err != io.EOF {
+			return fmt.Errorf("connection left open after Connection: close, %v", err)
+		}
+	}
+	return nil
+}
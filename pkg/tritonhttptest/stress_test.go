@@ -0,0 +1,41 @@
+package tritonhttptest
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"testing"
+
+	"cse224/proj3/pkg/tritonhttp"
+)
+
+var stressConns = flag.Int("stress.conns", 100, "connections TestStress holds open at once")
+
+func TestStress(t *testing.T) {
+	files := map[string]string{
+		"index.html":       "<h1>hi</h1>",
+		"big.txt":          strings.Repeat("0123456789", 10000),
+		"empty.txt":        "",
+		"subdir/page.html": "<p>page</p>",
+	}
+	ts := NewTestServer(t, &Options{
+		Files: files,
+		Configure: func(s *tritonhttp.Server) {
+			s.Limits.MaxConnsPerIP = *stressConns
+		},
+	})
+	rep, err := Stress(context.Background(), ts.Listener.Addr().String(), StressOptions{
+		Files: files,
+		Conns: *stressConns,
+	})
+	if err != nil {
+		t.Fatalf("Stress: %v", err)
+	}
+	t.Logf("%+v", *rep)
+	if rep.Failures > 0 {
+		t.Fatalf("%v connections failed:\n\t%v", rep.Failures, strings.Join(rep.Messages, "\n\t"))
+	}
+	if rep.Pipelined == 0 || rep.Partial == 0 || rep.Malformed == 0 {
+		t.Fatalf("got %+v, want a mix of requests", *rep)
+	}
+}
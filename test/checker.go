@@ -54,11 +54,13 @@ func (rc *ResponseChecker) Check(br *bufio.Reader) error {
 			{"Content-Length", fmt.Sprint(fi.Size())},
 			{"Content-Type", rc.ContentType},
 			{"Date", ""},
+			{"Etag", ""},
 			{"Last-Modified", ""},
 		}
 		if rc.Close {
 			specs = append(connCloseHeader, specs...)
 		}
+		specs = append([]HeaderSpec{{"Accept-Ranges", "bytes"}}, specs...)
 	case 400:
 		specs = []HeaderSpec{
 			{"Connection", "close"},